@@ -0,0 +1,132 @@
+package solvere
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// ErrInvalidClientRule is returned by ParseClientRule when a rule is malformed
+var ErrInvalidClientRule = errors.New("solvere: Invalid client rule")
+
+// ClientAction is how a Handler treats the queries of a client
+type ClientAction int
+
+const (
+	// ClientAllowRecursion answers queries, resolving them if needed
+	ClientAllowRecursion ClientAction = iota
+	// ClientAllow answers queries using only the local data, local zones and
+	// cache of the resolver, as if the client hadn't set the RD bit
+	ClientAllow
+	// ClientRefuse answers every query with REFUSED
+	ClientRefuse
+)
+
+func (ca ClientAction) String() string {
+	switch ca {
+	case ClientAllowRecursion:
+		return "allow_recursion"
+	case ClientAllow:
+		return "allow"
+	case ClientRefuse:
+		return "refuse"
+	}
+	return "unknown"
+}
+
+// ClientRule is the policy for the clients in a network
+type ClientRule struct {
+	Network *net.IPNet
+	Action  ClientAction
+	// Resolver, if not nil, is used to answer the queries of the clients
+	// instead of the Handler's resolver, e.g. a view returned by
+	// RecursiveResolver.With with a different blocklist
+	Resolver *RecursiveResolver
+}
+
+// ParseClientRule parses a rule in the form network=action, where network is a
+// CIDR or a single address and action is one of allow_recursion, allow or refuse
+func ParseClientRule(s string) (ClientRule, error) {
+	parts := strings.SplitN(s, "=", 2)
+	if len(parts) != 2 {
+		return ClientRule{}, fmt.Errorf("%w: %q, expected network=action", ErrInvalidClientRule, s)
+	}
+	network, err := parseClientNetwork(parts[0])
+	if err != nil {
+		return ClientRule{}, err
+	}
+	for _, action := range []ClientAction{ClientAllowRecursion, ClientAllow, ClientRefuse} {
+		if strings.Replace(parts[1], "-", "_", -1) == action.String() {
+			return ClientRule{Network: network, Action: action}, nil
+		}
+	}
+	return ClientRule{}, fmt.Errorf("%w: unknown action %q", ErrInvalidClientRule, parts[1])
+}
+
+// parseClientNetwork parses a CIDR, or a single address as a network containing
+// only it
+func parseClientNetwork(s string) (*net.IPNet, error) {
+	if !strings.Contains(s, "/") {
+		ip := net.ParseIP(s)
+		if ip == nil {
+			return nil, fmt.Errorf("%w: invalid address %q", ErrInvalidClientRule, s)
+		}
+		if ip4 := ip.To4(); ip4 != nil {
+			return &net.IPNet{IP: ip4, Mask: net.CIDRMask(32, 32)}, nil
+		}
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}, nil
+	}
+	_, network, err := net.ParseCIDR(s)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid network %q", ErrInvalidClientRule, s)
+	}
+	return network, nil
+}
+
+// AccessControl decides how a Handler treats the queries of each client by the
+// address they were received from, using the rule with the most specific network
+// containing it. Queries from clients that don't match any rule use Default, and
+// queries without an address, such as those from a resolver returned by
+// NewNetResolver, are always allowed.
+type AccessControl struct {
+	Rules []ClientRule
+	// Default is the action for clients not in any of the networks of Rules
+	Default ClientAction
+}
+
+// Match returns the rule for client
+func (ac *AccessControl) Match(client net.IP) ClientRule {
+	best := ClientRule{Action: ac.Default}
+	bestOnes := -1
+	for _, rule := range ac.Rules {
+		if ones, _ := rule.Network.Mask.Size(); ones > bestOnes && rule.Network.Contains(client) {
+			best, bestOnes = rule, ones
+		}
+	}
+	return best
+}
+
+type clientAddrKey struct{}
+
+// withClientAddr returns a copy of ctx recording the address a query was
+// received from
+func withClientAddr(ctx context.Context, addr net.Addr) context.Context {
+	var ip net.IP
+	switch a := addr.(type) {
+	case *net.UDPAddr:
+		ip = a.IP
+	case *net.TCPAddr:
+		ip = a.IP
+	default:
+		return ctx
+	}
+	return context.WithValue(ctx, clientAddrKey{}, ip)
+}
+
+// clientAddr returns the address recorded by withClientAddr, or nil
+func clientAddr(ctx context.Context) net.IP {
+	ip, _ := ctx.Value(clientAddrKey{}).(net.IP)
+	return ip
+}
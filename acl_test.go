@@ -0,0 +1,95 @@
+package solvere
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestParseClientRule(t *testing.T) {
+	for s, expected := range map[string]string{
+		"10.0.0.0/8=allow_recursion": "10.0.0.0/8 allow_recursion",
+		"192.0.2.1=allow":            "192.0.2.1/32 allow",
+		"2001:db8::1=refuse":         "2001:db8::1/128 refuse",
+		"::/0=allow-recursion":       "::/0 allow_recursion",
+	} {
+		rule, err := ParseClientRule(s)
+		if err != nil {
+			t.Fatalf("ParseClientRule(%q) failed: %s", s, err)
+		}
+		if got := rule.Network.String() + " " + rule.Action.String(); got != expected {
+			t.Fatalf("ParseClientRule(%q): expected %q, got %q", s, expected, got)
+		}
+	}
+	for _, s := range []string{"10.0.0.0/8", "10.0.0.0/33=allow", "host=allow", "10.0.0.0/8=deny"} {
+		if _, err := ParseClientRule(s); err == nil || !errors.Is(err, ErrInvalidClientRule) {
+			t.Fatalf("Expected ParseClientRule(%q) to fail, got %v", s, err)
+		}
+	}
+}
+
+func TestHandlerAccessControl(t *testing.T) {
+	resolver := func(rcode int) *RecursiveResolver {
+		rr := NewResolver(WithCache(NewBasicCache()), WithValidation(false))
+		rr.AddHooks(Hooks{
+			OnQuery: func(_ context.Context, q *Question) (*Answer, error) {
+				return &Answer{Rcode: rcode}, nil
+			},
+		})
+		rr.LocalData = NewLocalData([]dns.RR{&dns.A{Hdr: dns.RR_Header{Name: "printer.lan.", Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60}, A: net.IP{192, 168, 0, 2}}})
+		return rr
+	}
+	rule := func(s string) ClientRule {
+		r, err := ParseClientRule(s)
+		if err != nil {
+			t.Fatalf("ParseClientRule(%q) failed: %s", s, err)
+		}
+		return r
+	}
+	kids := rule("10.1.0.0/16=allow_recursion")
+	kids.Resolver = resolver(dns.RcodeNameError)
+	h := NewHandler(resolver(dns.RcodeSuccess))
+	h.AccessControl = &AccessControl{
+		Rules:   []ClientRule{rule("10.0.0.0/8=allow_recursion"), kids, rule("10.2.0.0/16=allow"), rule("10.2.3.4=refuse")},
+		Default: ClientRefuse,
+	}
+	query := func(client, name string) *dns.Msg {
+		m := new(dns.Msg)
+		m.SetQuestion(name, dns.TypeA)
+		ctx := withClientAddr(context.Background(), &net.UDPAddr{IP: net.ParseIP(client), Port: 53})
+		return h.respond(ctx, m)
+	}
+
+	for _, tc := range []struct {
+		client, name string
+		rcode        int
+		ra           bool
+	}{
+		{"10.0.0.1", "a.com.", dns.RcodeSuccess, true},
+		{"10.1.0.1", "a.com.", dns.RcodeNameError, true},
+		{"10.2.0.1", "printer.lan.", dns.RcodeSuccess, false},
+		{"10.2.0.1", "a.com.", dns.RcodeRefused, false},
+		{"10.2.3.4", "printer.lan.", dns.RcodeRefused, true},
+		{"192.0.2.1", "a.com.", dns.RcodeRefused, true},
+	} {
+		r := query(tc.client, tc.name)
+		if r.Rcode != tc.rcode || r.RecursionAvailable != tc.ra {
+			t.Errorf("Query for %s from %s: expected rcode %d and RA %t, got %d and %t", tc.name, tc.client, tc.rcode, tc.ra, r.Rcode, r.RecursionAvailable)
+		}
+	}
+
+	// queries without a client address aren't checked
+	m := new(dns.Msg)
+	m.SetQuestion("a.com.", dns.TypeA)
+	if r := h.respond(context.Background(), m); r.Rcode != dns.RcodeSuccess {
+		t.Fatalf("Unexpected rcode for query without a client address: %s", r)
+	}
+
+	h.SetAccessControl(&AccessControl{})
+	if r := query("192.0.2.1", "a.com."); r.Rcode != dns.RcodeSuccess {
+		t.Fatalf("Unexpected rcode after SetAccessControl: %s", r)
+	}
+}
@@ -0,0 +1,178 @@
+package solvere
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// DefaultHealthCheckTimeout is how long an AdminHandler health check lookup can
+// take before the resolver is reported as unhealthy
+var DefaultHealthCheckTimeout = 5 * time.Second
+
+// AdminRequestHeader is the header POST requests to an AdminHandler have to carry,
+// with any value. Browsers only send custom headers cross-origin after a CORS
+// preflight, which AdminHandler doesn't allow, so a web page can't make an
+// administrator's browser flush the cache.
+const AdminRequestHeader = "X-Solvere-Admin"
+
+// AdminHandler is a http.Handler serving a JSON management API for a resolver, so
+// orchestration systems can monitor and manage it without shelling out. It
+// should be served on a separate listener which is only reachable by
+// administrators. The endpoints are
+//
+//	GET  /health                 {"status": "ok"}, see HealthCheckName
+//	GET  /ready                  the HealthReport of RecursiveResolver.HealthCheck,
+//	                             with a 503 status if it isn't healthy
+//	GET  /stats                  the resolver's Stats
+//	GET  /config                 Config, if it is set
+//	POST /flush?name=<name>      remove everything cached about name, and with
+//	                             zone=true the names below it, see
+//	                             RecursiveResolver.Flush
+//
+// POST requests have to set AdminRequestHeader, or they are refused with a 403
+// status. Errors are returned as {"error": "..."} with a 4xx or 5xx status.
+type AdminHandler struct {
+	// Resolver is the resolver managed, it must be set unless Handler is
+	Resolver *RecursiveResolver
+	// Handler, if not nil, is used to find the resolver managed instead of
+	// Resolver, so the resolver most recently passed to SetResolver is used
+	Handler *Handler
+	// Config, if not nil, is the configuration the resolver was created from,
+	// which is returned by /config
+	Config *Config
+	// HealthCheckName, if not empty, is looked up by /health, which responds
+	// with a 503 status if the lookup fails. The name passed in the name query
+	// parameter is looked up instead if there is one.
+	HealthCheckName string
+	// HealthCheckTimeout is how long the health check lookup can take, if zero
+	// DefaultHealthCheckTimeout is used
+	HealthCheckTimeout time.Duration
+}
+
+// NewAdminHandler returns a AdminHandler for rr
+func NewAdminHandler(rr *RecursiveResolver) *AdminHandler {
+	return &AdminHandler{Resolver: rr}
+}
+
+func (ah *AdminHandler) resolver() *RecursiveResolver {
+	if ah.Handler != nil {
+		return ah.Handler.CurrentResolver()
+	}
+	return ah.Resolver
+}
+
+func (ah *AdminHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	method := http.MethodGet
+	if r.URL.Path == "/flush" {
+		method = http.MethodPost
+	}
+	if r.Method != method {
+		w.Header().Set("Allow", method)
+		writeAdminError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	if method == http.MethodPost && r.Header.Get(AdminRequestHeader) == "" {
+		writeAdminError(w, http.StatusForbidden, "missing "+AdminRequestHeader+" header")
+		return
+	}
+	switch r.URL.Path {
+	case "/health":
+		ah.serveHealth(w, r)
+	case "/ready":
+		ah.serveReady(w, r)
+	case "/stats":
+		writeAdminJSON(w, http.StatusOK, ah.resolver().Stats())
+	case "/config":
+		if ah.Config == nil {
+			writeAdminError(w, http.StatusNotFound, "no configuration")
+			return
+		}
+		writeAdminJSON(w, http.StatusOK, ah.Config)
+	case "/flush":
+		name := r.URL.Query().Get("name")
+		if _, ok := dns.IsDomainName(name); name == "" || !ok {
+			writeAdminError(w, http.StatusBadRequest, "missing or invalid name")
+			return
+		}
+		zone := false
+		if z := r.URL.Query().Get("zone"); z != "" {
+			var err error
+			if zone, err = strconv.ParseBool(z); err != nil {
+				writeAdminError(w, http.StatusBadRequest, "invalid zone parameter")
+				return
+			}
+		}
+		writeAdminJSON(w, http.StatusOK, struct {
+			Flushed int `json:"flushed"`
+		}{ah.resolver().Flush(name, zone)})
+	default:
+		writeAdminError(w, http.StatusNotFound, "not found")
+	}
+}
+
+// serveHealth reports whether the resolver is healthy, using a lookup if a name
+// to check is configured or requested
+func (ah *AdminHandler) serveHealth(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Query().Get("name")
+	if name == "" {
+		name = ah.HealthCheckName
+	}
+	if name == "" {
+		writeAdminJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+		return
+	}
+	if _, ok := dns.IsDomainName(name); !ok {
+		writeAdminError(w, http.StatusBadRequest, "invalid name")
+		return
+	}
+	timeout := ah.HealthCheckTimeout
+	if timeout <= 0 {
+		timeout = DefaultHealthCheckTimeout
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
+	if _, _, err := ah.resolver().Lookup(ctx, Question{Name: dns.Fqdn(name), Type: dns.TypeA}); err != nil {
+		writeAdminJSON(w, http.StatusServiceUnavailable, map[string]string{"status": "failing", "error": err.Error()})
+		return
+	}
+	writeAdminJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// serveReady reports the results of the resolver's self test
+func (ah *AdminHandler) serveReady(w http.ResponseWriter, r *http.Request) {
+	timeout := ah.HealthCheckTimeout
+	if timeout <= 0 {
+		timeout = DefaultHealthCheckTimeout
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
+	report := ah.resolver().HealthCheck(ctx)
+	status := http.StatusOK
+	if !report.Healthy {
+		status = http.StatusServiceUnavailable
+	}
+	writeAdminJSON(w, status, report)
+}
+
+func writeAdminJSON(w http.ResponseWriter, status int, v interface{}) {
+	j, err := json.Marshal(v)
+	if err != nil {
+		writeAdminError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(append(j, '\n'))
+}
+
+func writeAdminError(w http.ResponseWriter, status int, msg string) {
+	j, _ := json.Marshal(map[string]string{"error": msg})
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(append(j, '\n'))
+}
@@ -0,0 +1,100 @@
+package solvere
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func TestAdminHandler(t *testing.T) {
+	failing := false
+	rr := NewResolver(WithCache(NewBasicCache()), WithValidation(false))
+	rr.Forward = &ForwardConfig{Servers: []string{"192.0.2.1:53"}, Attempts: 1}
+	rr.FailureTTL = -1
+	rr.Transport = transportFunc(func(_ context.Context, m *dns.Msg, _ string) (*dns.Msg, error) {
+		if failing {
+			return nil, errors.New("timeout")
+		}
+		r := new(dns.Msg)
+		r.SetReply(m)
+		r.Answer = []dns.RR{&dns.A{Hdr: dns.RR_Header{Name: m.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60}, A: net.IP{1, 2, 3, 4}}}
+		return r, nil
+	})
+	ah := NewAdminHandler(rr)
+	ah.HealthCheckName = "health.example"
+	ah.Config = &Config{QueryTimeout: 2 * time.Second, Cache: &CacheConfig{MaxEntries: 10}}
+
+	request := func(method, target string) (int, map[string]interface{}) {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(method, target, nil)
+		if method == http.MethodPost {
+			req.Header.Set(AdminRequestHeader, "1")
+		}
+		ah.ServeHTTP(rec, req)
+		if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+			t.Fatalf("%s %s: unexpected content type %q", method, target, ct)
+		}
+		var body map[string]interface{}
+		if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+			t.Fatalf("%s %s: failed to decode response %q: %s", method, target, rec.Body, err)
+		}
+		return rec.Code, body
+	}
+
+	if status, body := request(http.MethodGet, "/health"); status != http.StatusOK || body["status"] != "ok" {
+		t.Fatalf("Unexpected health response %d %v", status, body)
+	}
+	answer := &Answer{Answer: []dns.RR{&dns.A{Hdr: dns.RR_Header{Ttl: 60}, A: net.IP{1, 2, 3, 4}}}}
+	rr.cache.Add(&Question{Name: "www.cached.example.", Type: dns.TypeA}, answer, false)
+	// a form posted from another site can't set the header
+	rec := httptest.NewRecorder()
+	ah.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/flush?name=cached.example&zone=true", nil))
+	if rec.Code != http.StatusForbidden {
+		t.Fatalf("Flush without %s wasn't refused: %d %s", AdminRequestHeader, rec.Code, rec.Body)
+	}
+	if status, body := request(http.MethodPost, "/flush?name=cached.example&zone=true"); status != http.StatusOK || body["flushed"] != 1.0 {
+		t.Fatalf("Unexpected flush response %d %v", status, body)
+	}
+	failing = true
+	if status, body := request(http.MethodGet, "/health"); status != http.StatusServiceUnavailable || body["status"] != "failing" {
+		t.Fatalf("Unexpected health response %d %v", status, body)
+	}
+	if status, body := request(http.MethodGet, "/stats"); status != http.StatusOK || body["Queries"] != 2.0 || body["Errors"] != 1.0 {
+		t.Fatalf("Unexpected stats response %d %v", status, body)
+	}
+	if status, body := request(http.MethodGet, "/ready"); status != http.StatusServiceUnavailable || body["healthy"] != false {
+		t.Fatalf("Unexpected ready response %d %v", status, body)
+	}
+	status, body := request(http.MethodGet, "/config")
+	if cache, _ := body["cache"].(map[string]interface{}); status != http.StatusOK || body["query_timeout"] != "2s" || cache["max_entries"] != 10.0 {
+		t.Fatalf("Unexpected config response %d %v", status, body)
+	}
+
+	for _, tc := range []struct {
+		method, target string
+		status         int
+	}{
+		{http.MethodGet, "/flush?name=example", http.StatusMethodNotAllowed},
+		{http.MethodPost, "/stats", http.StatusMethodNotAllowed},
+		{http.MethodPost, "/flush", http.StatusBadRequest},
+		{http.MethodPost, "/flush?name=example&zone=maybe", http.StatusBadRequest},
+		{http.MethodGet, "/unknown", http.StatusNotFound},
+	} {
+		status, body := request(tc.method, tc.target)
+		if msg, _ := body["error"].(string); status != tc.status || msg == "" {
+			t.Fatalf("%s %s: expected %d error, got %d %v", tc.method, tc.target, tc.status, status, body)
+		}
+	}
+	ah.Config = nil
+	if status, body := request(http.MethodGet, "/config"); status != http.StatusNotFound || !strings.Contains(body["error"].(string), "configuration") {
+		t.Fatalf("Unexpected config response %d %v", status, body)
+	}
+}
@@ -0,0 +1,115 @@
+package solvere
+
+import (
+	"net"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// IPs returns the addresses in the A and AAAA records of the answer section, in
+// the order they appear
+func (a *Answer) IPs() []net.IP {
+	var ips []net.IP
+	for _, r := range a.Answer {
+		switch r := r.(type) {
+		case *dns.A:
+			ips = append(ips, r.A)
+		case *dns.AAAA:
+			ips = append(ips, r.AAAA)
+		}
+	}
+	return ips
+}
+
+// TXTStrings returns the text of each TXT record in the answer section, the
+// character strings of a record are concatenated, as records longer than 255
+// octets have to be split across strings (RFC 7208 Section 3.3)
+func (a *Answer) TXTStrings() []string {
+	var txts []string
+	for _, r := range a.Answer {
+		if txt, ok := r.(*dns.TXT); ok {
+			txts = append(txts, strings.Join(txt.Txt, ""))
+		}
+	}
+	return txts
+}
+
+// CNAMEChain returns the names the query was redirected through by the CNAME
+// records in the answer section, starting with the name that was asked for and
+// ending with the canonical name, or nil if there are no CNAME records
+func (a *Answer) CNAMEChain() []string {
+	targets := map[string]string{}
+	aliased := map[string]bool{}
+	for _, r := range a.Answer {
+		if cname, ok := r.(*dns.CNAME); ok {
+			owner := strings.ToLower(cname.Hdr.Name)
+			targets[owner] = cname.Target
+			aliased[strings.ToLower(cname.Target)] = true
+		}
+	}
+	if len(targets) == 0 {
+		return nil
+	}
+	// the chain starts at the only owner which isn't also the target of another
+	// CNAME, fall back to the first record if they form a loop
+	var name string
+	for _, r := range a.Answer {
+		cname, ok := r.(*dns.CNAME)
+		if !ok {
+			continue
+		}
+		if name == "" {
+			name = cname.Hdr.Name
+		}
+		if !aliased[strings.ToLower(cname.Hdr.Name)] {
+			name = cname.Hdr.Name
+			break
+		}
+	}
+	chain := []string{name}
+	for len(chain) <= len(targets) {
+		target, present := targets[strings.ToLower(name)]
+		if !present {
+			break
+		}
+		chain = append(chain, target)
+		name = target
+	}
+	return chain
+}
+
+// MinTTL returns the amount of time the answer may be cached for, the lowest TTL
+// of the records in the answer section or, for negative answers, the lower of the
+// TTL and MINIMUM field of the SOA record in the authority section (RFC 2308
+// Section 5). It is zero if there are no records the TTL can be taken from.
+func (a *Answer) MinTTL() time.Duration {
+	var min uint32
+	found := false
+	lower := func(ttl uint32) {
+		if !found || ttl < min {
+			min, found = ttl, true
+		}
+	}
+	for _, r := range a.Answer {
+		lower(r.Header().Ttl)
+	}
+	if !found {
+		for _, r := range a.Authority {
+			if soa, ok := r.(*dns.SOA); ok {
+				lower(soa.Hdr.Ttl)
+				lower(soa.Minttl)
+			}
+		}
+	}
+	return time.Duration(min) * time.Second
+}
+
+// ExpiresAt returns the time at which the answer, received at received, should no
+// longer be used, see MinTTL. The TTLs of records returned from the cache are
+// decremented by the time they were cached for, so received should be the time
+// the answer was returned, or Expires can be used instead.
+func (a *Answer) ExpiresAt(received time.Time) time.Time {
+	return received.Add(a.MinTTL())
+}
@@ -0,0 +1,57 @@
+package solvere
+
+import (
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func TestAnswerAccessors(t *testing.T) {
+	hdr := func(name string, rrtype uint16, ttl uint32) dns.RR_Header {
+		return dns.RR_Header{Name: name, Rrtype: rrtype, Class: dns.ClassINET, Ttl: ttl}
+	}
+	a := &Answer{Answer: []dns.RR{
+		&dns.CNAME{Hdr: hdr("b.example.", dns.TypeCNAME, 300), Target: "c.example."},
+		&dns.CNAME{Hdr: hdr("a.example.", dns.TypeCNAME, 600), Target: "b.example."},
+		&dns.A{Hdr: hdr("c.example.", dns.TypeA, 60), A: net.IP{1, 2, 3, 4}},
+		&dns.AAAA{Hdr: hdr("c.example.", dns.TypeAAAA, 120), AAAA: net.ParseIP("2001:db8::1")},
+		&dns.TXT{Hdr: hdr("c.example.", dns.TypeTXT, 120), Txt: []string{"v=spf1 ", "-all"}},
+	}}
+	if ips := a.IPs(); len(ips) != 2 || !ips[0].Equal(net.IP{1, 2, 3, 4}) || !ips[1].Equal(net.ParseIP("2001:db8::1")) {
+		t.Fatalf("Unexpected addresses: %v", ips)
+	}
+	if txts := a.TXTStrings(); !reflect.DeepEqual(txts, []string{"v=spf1 -all"}) {
+		t.Fatalf("Unexpected TXT strings: %q", txts)
+	}
+	if chain := a.CNAMEChain(); !reflect.DeepEqual(chain, []string{"a.example.", "b.example.", "c.example."}) {
+		t.Fatalf("Unexpected CNAME chain: %v", chain)
+	}
+	if ttl := a.MinTTL(); ttl != time.Minute {
+		t.Fatalf("Unexpected minimum TTL: %s", ttl)
+	}
+	received := time.Now()
+	if expires := a.ExpiresAt(received); !expires.Equal(received.Add(time.Minute)) {
+		t.Fatalf("Unexpected expiry: %s", expires)
+	}
+
+	negative := &Answer{Rcode: dns.RcodeNameError, Authority: []dns.RR{
+		&dns.SOA{Hdr: hdr("example.", dns.TypeSOA, 3600), Minttl: 900},
+	}}
+	if chain := negative.CNAMEChain(); chain != nil {
+		t.Fatalf("Unexpected CNAME chain: %v", chain)
+	}
+	if ttl := negative.MinTTL(); ttl != 15*time.Minute {
+		t.Fatalf("Unexpected negative TTL: %s", ttl)
+	}
+
+	loop := &Answer{Answer: []dns.RR{
+		&dns.CNAME{Hdr: hdr("a.example.", dns.TypeCNAME, 60), Target: "b.example."},
+		&dns.CNAME{Hdr: hdr("b.example.", dns.TypeCNAME, 60), Target: "a.example."},
+	}}
+	if chain := loop.CNAMEChain(); len(chain) != 3 {
+		t.Fatalf("Unexpected chain for CNAME loop: %v", chain)
+	}
+}
@@ -0,0 +1,34 @@
+package solvere
+
+import (
+	"errors"
+	"strings"
+	"time"
+)
+
+var (
+	// DefaultBogusTTL is the amount of time a failed validation is remembered for
+	// if RecursiveResolver.BogusTTL isn't set
+	DefaultBogusTTL = 30 * time.Second
+)
+
+func bogusKey(q Question) Question {
+	return Question{Name: strings.ToLower(q.Name), Type: q.Type}
+}
+
+// isBogus checks if err is the result of a answer failing validation
+func isBogus(err error) bool {
+	var re *ResolutionError
+	if !errors.As(err, &re) {
+		return errors.Is(err, ErrBogusZone)
+	}
+	return re.Stage == StageValidation || re.Stage == StageDenial
+}
+
+// bogusTTL returns the amount of time failed validations are remembered for
+func (rr *RecursiveResolver) bogusTTL() time.Duration {
+	if rr.BogusTTL == 0 {
+		return DefaultBogusTTL
+	}
+	return rr.BogusTTL
+}
@@ -0,0 +1,76 @@
+package solvere
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/jmhodges/clock"
+	"github.com/miekg/dns"
+)
+
+func TestIsBogus(t *testing.T) {
+	q := &Question{Name: "example.", Type: dns.TypeA}
+	for _, tc := range []struct {
+		err   error
+		bogus bool
+	}{
+		{newResolutionError(StageValidation, q, nil, -1, ErrNoSignatures), true},
+		{newResolutionError(StageDenial, q, nil, -1, ErrNSECWildcardProof), true},
+		{newResolutionError(StageQuery, q, nil, -1, errors.New("timeout")), false},
+		{ErrBogusZone, true},
+		{ErrTooManyReferrals, false},
+	} {
+		if bogus := isBogus(tc.err); bogus != tc.bogus {
+			t.Errorf("isBogus(%s) = %t, expected %t", tc.err, bogus, tc.bogus)
+		}
+	}
+}
+
+func TestBogusCooldown(t *testing.T) {
+	root, example := newTestZone(t, "."), newTestZone(t, "example.")
+	a := &dns.A{Hdr: dns.RR_Header{Name: "a.example.", Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60}, A: net.IP{1, 2, 3, 4}}
+	responses := map[uint16][]dns.RR{
+		// signed by a key that isn't in the chain of trust
+		dns.TypeA:      newTestZone(t, "example.").sign(t, a),
+		dns.TypeDNSKEY: example.sign(t, example.key),
+		dns.TypeDS:     root.sign(t, example.key.ToDS(dns.SHA256)),
+	}
+	queries := 0
+	fc := clock.NewFake()
+	rr := NewRecursiveResolver(false, true, nil, []dns.RR{root.key}, nil)
+	rr.failures.clk = fc
+	rr.Forward = &ForwardConfig{Servers: []string{"10.0.0.1"}}
+	rr.Transport = transportFunc(func(_ context.Context, m *dns.Msg, _ string) (*dns.Msg, error) {
+		queries++
+		r := new(dns.Msg)
+		r.SetReply(m)
+		r.Answer = responses[m.Question[0].Qtype]
+		return r, nil
+	})
+
+	_, _, err := rr.Lookup(context.Background(), Question{Name: "a.example.", Type: dns.TypeA})
+	if err == nil {
+		t.Fatal("Lookup of bogus answer didn't fail")
+	}
+	queries = 0
+	_, _, cachedErr := rr.Lookup(context.Background(), Question{Name: "A.example.", Type: dns.TypeA})
+	if cachedErr != err {
+		t.Fatalf("Expected the original error, got %v", cachedErr)
+	}
+	if queries != 0 {
+		t.Fatalf("Lookup of recently bogus answer sent %d queries", queries)
+	}
+
+	// once the zone is fixed the answer is validated after the cooldown
+	responses[dns.TypeA] = example.sign(t, a)
+	fc.Add(DefaultBogusTTL)
+	ans, _, err := rr.Lookup(context.Background(), Question{Name: "a.example.", Type: dns.TypeA})
+	if err != nil {
+		t.Fatalf("Lookup failed after cooldown: %s", err)
+	}
+	if !ans.Authenticated {
+		t.Fatal("Fixed answer isn't authenticated")
+	}
+}
@@ -0,0 +1,65 @@
+package solvere
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+)
+
+var (
+	// MaxSignatureVerifications is the maximum number of RRSIGs verified while
+	// resolving a single question, including those of the DNSKEY and DS RRsets
+	// in the chain of trust
+	MaxSignatureVerifications = 200
+	// MaxKeyFetches is the maximum number of DNSKEY and DS RRsets looked up
+	// while resolving a single question
+	MaxKeyFetches = 32
+
+	// ErrValidationBudgetExceeded is returned when resolving a question needs more
+	// signature verifications or key lookups than allowed, which legitimate zones
+	// don't, so responses crafted to make validation expensive (such as the
+	// KeyTrap attacks) can't tie up the resolver
+	ErrValidationBudgetExceeded = errors.New("solvere: Validation budget exceeded")
+)
+
+// validationBudget counts the cryptographic work done resolving a question, its
+// counters are accessed atomically as lookups of different parts of the chain of
+// trust may run concurrently
+type validationBudget struct {
+	verifications int64
+	fetches       int64
+}
+
+type validationBudgetKey struct{}
+
+// withValidationBudget returns a copy of ctx with a new budget, unless ctx already
+// has one in which case work done by lookups using the copy counts towards it
+func withValidationBudget(ctx context.Context) context.Context {
+	if budgetFrom(ctx) != nil {
+		return ctx
+	}
+	return context.WithValue(ctx, validationBudgetKey{}, new(validationBudget))
+}
+
+// budgetFrom returns the budget of ctx, or nil if it has none in which case the
+// work isn't limited
+func budgetFrom(ctx context.Context) *validationBudget {
+	b, _ := ctx.Value(validationBudgetKey{}).(*validationBudget)
+	return b
+}
+
+// verify spends the budget for a signature verification
+func (b *validationBudget) verify() error {
+	if b == nil || atomic.AddInt64(&b.verifications, 1) <= int64(MaxSignatureVerifications) {
+		return nil
+	}
+	return ErrValidationBudgetExceeded
+}
+
+// fetch spends the budget for a DNSKEY or DS lookup
+func (b *validationBudget) fetch() error {
+	if b == nil || atomic.AddInt64(&b.fetches, 1) <= int64(MaxKeyFetches) {
+		return nil
+	}
+	return ErrValidationBudgetExceeded
+}
@@ -0,0 +1,81 @@
+package solvere
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestValidationBudget(t *testing.T) {
+	root, example := newTestZone(t, "."), newTestZone(t, "example.")
+	a := &dns.A{Hdr: dns.RR_Header{Name: "a.example.", Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60}, A: net.IP{1, 2, 3, 4}}
+	// every one of the signatures is valid, so all of them are verified
+	answer := example.sign(t, a)
+	for i := 0; i < 9; i++ {
+		answer = append(answer, example.sign(t, a)[1])
+	}
+	responses := map[uint16][]dns.RR{
+		dns.TypeA:      answer,
+		dns.TypeDNSKEY: example.sign(t, example.key),
+		dns.TypeDS:     root.sign(t, example.key.ToDS(dns.SHA256)),
+	}
+	rr := NewRecursiveResolver(false, true, nil, []dns.RR{root.key}, nil)
+	rr.Forward = &ForwardConfig{Servers: []string{"10.0.0.1"}}
+	rr.BogusTTL = -1
+	rr.FailureTTL = -1
+	rr.Transport = transportFunc(func(_ context.Context, m *dns.Msg, _ string) (*dns.Msg, error) {
+		r := new(dns.Msg)
+		r.SetReply(m)
+		r.Answer = responses[m.Question[0].Qtype]
+		return r, nil
+	})
+	q := Question{Name: "a.example.", Type: dns.TypeA}
+	if ans, _, err := rr.Lookup(context.Background(), q); err != nil || !ans.Authenticated {
+		t.Fatalf("Lookup within the budget failed: %v", err)
+	}
+
+	defer func(verifications, fetches int) {
+		MaxSignatureVerifications, MaxKeyFetches = verifications, fetches
+	}(MaxSignatureVerifications, MaxKeyFetches)
+	MaxSignatureVerifications = 8
+	_, _, err := rr.Lookup(context.Background(), q)
+	var re *ResolutionError
+	if !errors.Is(err, ErrValidationBudgetExceeded) || !errors.As(err, &re) || re.Stage != StageValidation {
+		t.Fatalf("Expected the signature verification budget to be exceeded, got %v", err)
+	}
+
+	// the DNSKEY and DS RRsets of example. both have to be fetched
+	responses[dns.TypeA] = example.sign(t, a)
+	MaxSignatureVerifications = 8
+	MaxKeyFetches = 1
+	if _, _, err = rr.Lookup(context.Background(), q); !errors.Is(err, ErrValidationBudgetExceeded) {
+		t.Fatalf("Expected the key fetch budget to be exceeded, got %v", err)
+	}
+	MaxKeyFetches = 2
+	if _, _, err = rr.Lookup(context.Background(), q); err != nil {
+		t.Fatalf("Lookup within the budget failed: %v", err)
+	}
+}
+
+func TestValidationBudgetShared(t *testing.T) {
+	ctx := withValidationBudget(context.Background())
+	if withValidationBudget(ctx) != ctx {
+		t.Fatal("Nested lookup was given a new budget")
+	}
+	b := budgetFrom(ctx)
+	for i := 0; i < MaxKeyFetches; i++ {
+		if err := b.fetch(); err != nil {
+			t.Fatalf("Fetch %d exceeded the budget", i+1)
+		}
+	}
+	if b.fetch() != ErrValidationBudgetExceeded {
+		t.Fatal("Fetch beyond MaxKeyFetches was allowed")
+	}
+	// work without a budget isn't limited
+	if budgetFrom(context.Background()).verify() != nil {
+		t.Fatal("Verification without a budget failed")
+	}
+}
@@ -1,9 +1,12 @@
 package solvere
 
 import (
+	"container/list"
 	"crypto/sha1"
 	"math"
+	"net"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/miekg/dns"
@@ -12,7 +15,10 @@ import (
 )
 
 func hashQuestion(q *Question) [sha1.Size]byte {
-	inp := append([]byte{uint8(q.Type & 0xff), uint8(q.Type >> 8)}, []byte(q.Name)...)
+	// names are at most 255 octets so this buffer can generally stay on the stack
+	var buf [2 + 255]byte
+	buf[0], buf[1] = uint8(q.Type&0xff), uint8(q.Type>>8)
+	inp := append(buf[:2], q.Name...)
 	return sha1.Sum(inp)
 }
 
@@ -20,60 +26,307 @@ const (
 	year68 = int64(1 << 31)
 )
 
+// minTTL returns the lowest TTL of the records in a, lowered to the time until the
+// earliest RRSIG record expires so cached data never outlives its signatures
 func minTTL(a []dns.RR, clk clock.Clock) int {
-	var min *uint32
+	if len(a) == 0 {
+		return 0
+	}
+	min := a[0].Header().Ttl
+	for _, r := range a[1:] {
+		if r.Header().Ttl < min {
+			min = r.Header().Ttl
+		}
+	}
+	if expiresIn, signed := signatureTTL(a, clk.Now()); signed && expiresIn < min {
+		min = expiresIn
+	}
+	return int(min)
+}
+
+// signatureTTL returns the number of seconds until the earliest expiring RRSIG
+// record in a expires, or false if there aren't any. Expiration times are
+// compared using serial number arithmetic (RFC 4034 Section 3.1.5).
+func signatureTTL(a []dns.RR, now time.Time) (uint32, bool) {
+	var min uint32
+	signed := false
 	for _, r := range a {
-		if min == nil {
-			min = &r.Header().Ttl
+		sig, ok := r.(*dns.RRSIG)
+		if !ok {
 			continue
 		}
-		if r.Header().Ttl < *min {
-			min = &r.Header().Ttl
-		}
-		if r.Header().Rrtype == dns.TypeRRSIG {
-			// if expiration is lower than Ttl then use that instead so we always
-			// use fresh signatures
-			rr := r.(*dns.RRSIG)
-			n := clk.Now().UTC().Unix()
-			mod := (int64(rr.Expiration) - n) / year68
-			t := int64(rr.Expiration) + (mod * year68)
-			expiresIn := (t - n) / 1000000000 // convert to seconds
-			if expiresIn > 0 && expiresIn < math.MaxUint32 && uint32(expiresIn) < *min {
-				uei := uint32(expiresIn)
-				min = &uei
-			}
+		var expiresIn uint32
+		if remaining := int32(sig.Expiration - uint32(now.Unix())); remaining > 0 {
+			expiresIn = uint32(remaining)
+		}
+		if !signed || expiresIn < min {
+			min, signed = expiresIn, true
 		}
 	}
-	if min == nil {
-		return 0
+	return min, signed
+}
+
+// Credibility ranks cached data by how trustworthy the part of the response it was
+// taken from is (RFC 2181 Section 5.4.1)
+type Credibility int
+
+const (
+	// CredibilityAdditional is data from the additional section, such as glue
+	CredibilityAdditional Credibility = iota + 1
+	// CredibilityAuthority is data from the authority section, such as the NS
+	// records in a referral
+	CredibilityAuthority
+	// CredibilityAnswer is data from the answer section
+	CredibilityAnswer
+)
+
+// answerTTL returns the number of seconds answer can be cached for, the TTL field
+// of OPT records holds flags so they are ignored
+func answerTTL(answer *Answer, clk clock.Clock) int {
+	return minTTL(answerRecords(answer), clk)
+}
+
+// answerRecords returns the records of every section of answer, except OPT
+// records
+func answerRecords(answer *Answer) []dns.RR {
+	records := make([]dns.RR, 0, len(answer.Answer)+len(answer.Authority)+len(answer.Additional))
+	records = append(records, answer.Answer...)
+	records = append(records, answer.Authority...)
+	return append(records, filterRRSet(answer.Additional, dns.TypeOPT)...)
+}
+
+// ttlLimits are the shortest and longest time positive and negative answers can
+// be cached for, zero fields don't limit them
+type ttlLimits struct {
+	min, max                 time.Duration
+	negativeMin, negativeMax time.Duration
+}
+
+// limit returns the number of seconds answer should be cached for, and the
+// answer to cache. If the TTL of the answer had to be changed to fit the limits
+// the answer is a copy with the TTLs of its records set to the new TTL, so they
+// match how long it is cached for.
+func (tl ttlLimits) limit(answer *Answer, clk clock.Clock) (*Answer, int) {
+	ttl := answerTTL(answer, clk)
+	if ttl == 0 {
+		return answer, 0
+	}
+	min, max := tl.min, tl.max
+	if answer.Rcode == dns.RcodeNameError || (answer.Rcode == dns.RcodeSuccess && len(answer.Answer) == 0) {
+		min, max = tl.negativeMin, tl.negativeMax
+	}
+	limited := ttl
+	if min > 0 && time.Duration(limited)*time.Second < min {
+		limited = int(min / time.Second)
+	}
+	if max > 0 && time.Duration(limited)*time.Second > max {
+		limited = int(max / time.Second)
+	}
+	if expiresIn, signed := signatureTTL(answerRecords(answer), clk.Now()); signed && int(expiresIn) < limited {
+		limited = int(expiresIn)
 	}
-	return int(*min)
+	if limited == ttl || limited <= 0 {
+		return answer, limited
+	}
+	a := *answer
+	set := func(uint32) uint32 { return uint32(limited) }
+	a.Answer = adjustTTLs(answer.Answer, set)
+	a.Authority = adjustTTLs(answer.Authority, set)
+	a.Additional = adjustTTLs(answer.Additional, set)
+	return &a, limited
+}
+
+// cacheState is the data held by a cacheEntry, it is never modified once the
+// entry holds it so readers can use it without locking
+type cacheState struct {
+	answer      *Answer
+	ttl         int
+	modified    time.Time
+	credibility Credibility
+	// size is the approximate number of bytes used by the answer
+	size int64
+	// adjusted holds the last *adjustedAnswer returned by answerAt, so the
+	// records are only copied once a second rather than for every lookup
+	adjusted atomic.Value
+}
+
+// adjustedAnswer is the answer returned by answerAt during the second starting
+// at unix, when it had been cached for elapsed seconds
+type adjustedAnswer struct {
+	unix    int64
+	elapsed uint32
+	answer  *Answer
 }
 
 type cacheEntry struct {
-	answer   *Answer
-	ttl      int
-	modified time.Time
+	question Question
 	forever  bool
-	mu       sync.Mutex
+	// state holds the current *cacheState, updates replace it rather than
+	// modifying it
+	state atomic.Value
+	// mu serializes updates
+	mu sync.Mutex
+
+	// elem is the position of the entry in the LRU list of a bounded cache,
+	// it is nil for entries which can't be evicted and is protected by the
+	// lruMu of the cache
+	elem *list.Element
 }
 
-func (ce *cacheEntry) update(answer *Answer, ttl int, clk clock.Clock) {
+func newCacheEntry(q *Question, answer *Answer, ttl int, forever bool, credibility Credibility, now time.Time) *cacheEntry {
+	ce := &cacheEntry{question: *q, forever: forever}
+	ce.state.Store(&cacheState{answer: answer, ttl: ttl, modified: now, credibility: credibility, size: answerSize(answer)})
+	return ce
+}
+
+func (ce *cacheEntry) load() *cacheState {
+	return ce.state.Load().(*cacheState)
+}
+
+// update replaces the cached answer unless it is still fresh and more credible
+// than answer, it returns false if the answer wasn't replaced and the change in
+// the size of the entry
+func (ce *cacheEntry) update(answer *Answer, ttl int, credibility Credibility, clk clock.Clock) (bool, int64) {
 	ce.mu.Lock()
 	defer ce.mu.Unlock()
-	// just overwrite the previous one...
-	ce.answer = answer
-	ce.ttl = ttl
-	ce.modified = clk.Now()
+	now := clk.Now()
+	cs := ce.load()
+	if credibility < cs.credibility && (ce.forever || !cs.expired(now)) {
+		return false, 0
+	}
+	size := answerSize(answer)
+	ce.state.Store(&cacheState{answer: answer, ttl: ttl, modified: now, credibility: credibility, size: size})
+	return true, size - cs.size
+}
+
+// event returns a event of type t describing the entry
+func (ce *cacheEntry) event(t CacheEventType, scope *net.IPNet) CacheEvent {
+	return CacheEvent{Type: t, Question: ce.question, Answer: ce.load().answer, Scope: scope}
 }
 
 func (ce *cacheEntry) expired(clk clock.Clock) bool {
-	ce.mu.Lock()
-	defer ce.mu.Unlock()
-	if ce.forever {
-		return false
+	return !ce.forever && ce.load().expired(clk.Now())
+}
+
+// answerAt returns the cached answer with the TTLs of its records reduced by the
+// time it has been cached, and to the time until its signatures expire, with
+// Expires set so clients and downstream caches don't keep it for longer than it
+// is valid. The records are copied since the cached ones may be in use, but the
+// copy is shared by the lookups made during the same second. Answers cached
+// forever are returned as they are.
+func (cs *cacheState) answerAt(now time.Time, forever bool) *Answer {
+	if forever {
+		return cs.answer
+	}
+	elapsed := uint32(0)
+	if d := now.Sub(cs.modified); d > 0 {
+		elapsed = uint32(d / time.Second)
+	}
+	unix := now.Unix()
+	if adj, ok := cs.adjusted.Load().(*adjustedAnswer); ok && adj.unix == unix && adj.elapsed == elapsed {
+		return adj.answer
 	}
-	return clk.Now().After(ce.modified.Add(time.Second * time.Duration(ce.ttl)))
+	a := cs.adjust(now, elapsed)
+	cs.adjusted.Store(&adjustedAnswer{unix: unix, elapsed: elapsed, answer: a})
+	return a
+}
+
+// adjust returns a copy of the cached answer as it should be returned at now,
+// elapsed seconds after it was cached
+func (cs *cacheState) adjust(now time.Time, elapsed uint32) *Answer {
+	a := *cs.answer
+	a.Expires = cs.modified.Add(time.Second * time.Duration(cs.ttl))
+	max, signed := signatureTTL(answerRecords(cs.answer), now)
+	if !signed {
+		if elapsed == 0 {
+			return &a
+		}
+		max = math.MaxUint32
+	}
+	decrement := func(ttl uint32) uint32 {
+		if ttl > elapsed {
+			ttl -= elapsed
+		} else {
+			ttl = 0
+		}
+		if ttl > max {
+			ttl = max
+		}
+		return ttl
+	}
+	a.Answer = adjustTTLs(cs.answer.Answer, decrement)
+	a.Authority = adjustTTLs(cs.answer.Authority, decrement)
+	a.Additional = adjustTTLs(cs.answer.Additional, decrement)
+	return &a
+}
+
+// adjustTTLs returns copies of records with their TTLs replaced by the result of
+// calling adjust with them. OPT records are returned as they are since their TTL
+// field holds flags.
+func adjustTTLs(records []dns.RR, adjust func(ttl uint32) uint32) []dns.RR {
+	if len(records) == 0 {
+		return records
+	}
+	adjusted := make([]dns.RR, len(records))
+	for i, r := range records {
+		if r.Header().Rrtype == dns.TypeOPT {
+			adjusted[i] = r
+			continue
+		}
+		r = dns.Copy(r)
+		r.Header().Ttl = adjust(r.Header().Ttl)
+		adjusted[i] = r
+	}
+	return adjusted
+}
+
+func (cs *cacheState) expired(now time.Time) bool {
+	return now.After(cs.modified.Add(time.Second * time.Duration(cs.ttl)))
+}
+
+// CacheEventType is the kind of change described by a CacheEvent
+type CacheEventType int
+
+const (
+	// CacheInserted is sent when a answer is added for a question which wasn't
+	// cached
+	CacheInserted CacheEventType = iota
+	// CacheRefreshed is sent when a cached answer is replaced
+	CacheRefreshed
+	// CacheExpired is sent when a answer is removed because its TTL has passed
+	CacheExpired
+	// CacheEvicted is sent when a answer is removed to make room in a full
+	// bounded cache
+	CacheEvicted
+	// CacheFlushed is sent when a answer is removed by Flush
+	CacheFlushed
+)
+
+func (t CacheEventType) String() string {
+	switch t {
+	case CacheInserted:
+		return "inserted"
+	case CacheRefreshed:
+		return "refreshed"
+	case CacheExpired:
+		return "expired"
+	case CacheEvicted:
+		return "evicted"
+	case CacheFlushed:
+		return "flushed"
+	}
+	return "unknown"
+}
+
+// CacheEvent describes a change to the contents of a BasicCache
+type CacheEvent struct {
+	Type     CacheEventType
+	Question Question
+	// Answer is the answer that was added, or removed
+	Answer *Answer
+	// Scope is the network a scoped answer applies to, it is nil for answers
+	// which apply to all clients
+	Scope *net.IPNet
 }
 
 // QuestionAnswerCache is used to cache responses to queries. The internal implementation
@@ -83,95 +336,584 @@ type QuestionAnswerCache interface {
 	Add(q *Question, answer *Answer, forever bool)
 }
 
-// BasicCache is a basic implementation of the QuestionAnswerCache interface
+// CredibilityCache is a QuestionAnswerCache which can also store data taken from
+// the authority and additional sections of responses, such as NS records and glue
+// from referrals. Cached data is never replaced by less credible data while it is
+// fresh, and Get only returns data from the answer section so less credible data
+// is never used to answer queries.
+type CredibilityCache interface {
+	QuestionAnswerCache
+	AddWithCredibility(q *Question, answer *Answer, credibility Credibility)
+	GetWithCredibility(q *Question, min Credibility) *Answer
+}
+
+// PinningCache is a QuestionAnswerCache which can prevent the answers to particular
+// questions from being evicted
+type PinningCache interface {
+	QuestionAnswerCache
+	Pin(q *Question)
+	Unpin(q *Question)
+}
+
+// FlushingCache is a QuestionAnswerCache whose answers for a name, or a name and
+// every name below it, can be removed before they expire
+type FlushingCache interface {
+	QuestionAnswerCache
+	// Flush removes the answers for name, and if subdomains is true the names
+	// below it, and returns the number of answers removed
+	Flush(name string, subdomains bool) int
+}
+
+// ScopedCache is a QuestionAnswerCache which can store answers that only apply to
+// clients in a particular network, as indicated by the scope of a EDNS Client
+// Subnet option (RFC 7871 Section 7.3)
+type ScopedCache interface {
+	QuestionAnswerCache
+	AddScoped(q *Question, answer *Answer, scope *net.IPNet)
+	// GetScoped returns the answer for the most specific scope containing
+	// client, or a answer which applies to all clients
+	GetScoped(q *Question, client net.IP) *Answer
+}
+
+type scopedEntry struct {
+	scope *net.IPNet
+	entry *cacheEntry
+}
+
+// BasicCache is a basic implementation of the QuestionAnswerCache interface.
+// Lookups don't take any locks. The records of answers are copied to reduce
+// their TTLs at most once a second, the answers returned during that second are
+// shared and must not be modified.
 type BasicCache struct {
-	mu    sync.RWMutex
-	cache map[[sha1.Size]byte]*cacheEntry
-	clk   clock.Clock
+	// OnEvent, if not nil, is called after each change to the contents of the
+	// cache. It is called synchronously without any locks held, so it should
+	// return quickly. It must not be modified once the cache is in use.
+	OnEvent func(CacheEvent)
+
+	// bytes is the approximate memory used by the cached answers, it is only
+	// modified with mu held but is read atomically
+	bytes int64
+
+	// cache maps question hashes to *cacheEntry and scoped maps them to
+	// []scopedEntry, the slices are replaced rather than modified. Both are
+	// read without locking, mu serializes changes to them and protects size,
+	// the number of entries in cache.
+	mu     sync.Mutex
+	cache  sync.Map
+	scoped sync.Map
+	size   int
+	clk    clock.Clock
+
+	// maxEntries is the number of entries a bounded cache holds before the least
+	// recently used are evicted, lru is ordered from most to least recently used
+	maxEntries int
+	lruMu      sync.Mutex
+	lru        *list.List
+	pinned     map[[sha1.Size]byte]struct{}
+
+	// ttls are the limits on how long answers are cached for
+	ttls ttlLimits
+
+	done      chan struct{}
+	closeOnce sync.Once
 }
 
-var defaultPruneInterval = time.Minute
+// DefaultJanitorInterval is how often expired answers are removed from a BasicCache
+// in the background if CacheConfig.JanitorInterval isn't set
+var DefaultJanitorInterval = time.Minute
+
+// CacheConfig configures a BasicCache
+type CacheConfig struct {
+	// MaxEntries is the maximum number of answers held, once full the least
+	// recently used answer is evicted to make room for a new one. Answers added
+	// forever and pinned answers don't count towards the limit and are never
+	// evicted. If zero the cache is unbounded.
+	MaxEntries int
+	// JanitorInterval is how often expired answers are removed in the
+	// background, rather than only when they are next looked up. If zero
+	// DefaultJanitorInterval is used.
+	JanitorInterval time.Duration
+	// JanitorBatch is the maximum number of answers examined each time the
+	// janitor runs, so the work of cleaning up a large cache is spread out
+	// instead of holding up lookups for a full scan. If zero every answer is
+	// examined.
+	JanitorBatch int
+	// MinTTL and MaxTTL, if not zero, are the shortest and longest time answers
+	// are cached for, their TTLs are raised or lowered to fit. Answers with a TTL
+	// of zero are never cached, and answers are never cached for longer than
+	// their signatures are valid.
+	MinTTL time.Duration
+	MaxTTL time.Duration
+	// NegativeMinTTL and NegativeMaxTTL are used instead of MinTTL and MaxTTL
+	// for negative answers, NXDOMAIN responses and responses without any answer
+	// records, so they can be kept for less time without affecting other
+	// answers
+	NegativeMinTTL time.Duration
+	NegativeMaxTTL time.Duration
+}
 
 // NewBasicCache returns an initialized BasicCache
 func NewBasicCache() *BasicCache {
-	bc := &BasicCache{cache: make(map[[sha1.Size]byte]*cacheEntry), clk: clock.Default()}
-	go func() {
-		t := time.NewTicker(defaultPruneInterval)
-		for range t.C {
-			bc.fullPrune()
-		}
-	}()
+	return NewCache(CacheConfig{})
+}
+
+// NewBoundedCache returns an initialized BasicCache which holds at most maxEntries
+// answers, see CacheConfig.MaxEntries
+func NewBoundedCache(maxEntries int) *BasicCache {
+	return NewCache(CacheConfig{MaxEntries: maxEntries})
+}
+
+// NewCache returns a initialized BasicCache configured by cfg. A janitor goroutine
+// is started which runs until Close is called.
+func NewCache(cfg CacheConfig) *BasicCache {
+	bc := &BasicCache{
+		clk:        clock.Default(),
+		maxEntries: cfg.MaxEntries,
+		lru:        list.New(),
+		pinned:     make(map[[sha1.Size]byte]struct{}),
+		ttls: ttlLimits{
+			min:         cfg.MinTTL,
+			max:         cfg.MaxTTL,
+			negativeMin: cfg.NegativeMinTTL,
+			negativeMax: cfg.NegativeMaxTTL,
+		},
+		done: make(chan struct{}),
+	}
+	interval := cfg.JanitorInterval
+	if interval == 0 {
+		interval = DefaultJanitorInterval
+	}
+	go bc.janitor(interval, cfg.JanitorBatch)
 	return bc
 }
 
-func (bc *BasicCache) del(id [sha1.Size]byte) {
+// janitor removes expired answers every interval until the cache is closed
+func (bc *BasicCache) janitor(interval time.Duration, batch int) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-bc.done:
+			return
+		case <-t.C:
+			bc.prune(batch)
+		}
+	}
+}
+
+// Close stops the janitor goroutine, the cache can still be used but expired
+// answers are only removed when they are looked up
+func (bc *BasicCache) Close() {
+	bc.closeOnce.Do(func() {
+		if bc.done != nil {
+			close(bc.done)
+		}
+	})
+}
+
+// emit sends events to the OnEvent callback
+func (bc *BasicCache) emit(events []CacheEvent) {
+	if bc.OnEvent == nil {
+		return
+	}
+	for _, e := range events {
+		bc.OnEvent(e)
+	}
+}
+
+// entry returns the entry for id, if there is one
+func (bc *BasicCache) entry(id [sha1.Size]byte) (*cacheEntry, bool) {
+	e, present := bc.cache.Load(id)
+	if !present {
+		return nil, false
+	}
+	return e.(*cacheEntry), true
+}
+
+// scopedEntries returns the scoped entries for id, the slice must not be modified
+func (bc *BasicCache) scopedEntries(id [sha1.Size]byte) []scopedEntry {
+	entries, _ := bc.scoped.Load(id)
+	se, _ := entries.([]scopedEntry)
+	return se
+}
+
+// remove deletes the entry for id, bc.mu must be held
+func (bc *BasicCache) remove(id [sha1.Size]byte) {
+	if e, present := bc.cache.LoadAndDelete(id); present {
+		bc.size--
+		atomic.AddInt64(&bc.bytes, -e.(*cacheEntry).load().size)
+	}
+}
+
+// delExpired removes the entry for id if it has expired
+func (bc *BasicCache) delExpired(id [sha1.Size]byte) {
+	bc.mu.Lock()
+	entry, present := bc.entry(id)
+	if !present || !entry.expired(bc.clk) {
+		bc.mu.Unlock()
+		return
+	}
+	bc.untrack(entry)
+	bc.remove(id)
+	bc.mu.Unlock()
+	if bc.OnEvent != nil {
+		bc.emit([]CacheEvent{entry.event(CacheExpired, nil)})
+	}
+}
+
+// track adds a new entry to the LRU list, evicting the least recently used
+// entries if the cache is full, and returns the eviction events. bc.mu must be
+// held.
+func (bc *BasicCache) track(id [sha1.Size]byte, entry *cacheEntry) []CacheEvent {
+	if bc.maxEntries <= 0 || entry.forever {
+		return nil
+	}
+	if _, present := bc.pinned[id]; present {
+		return nil
+	}
+	bc.lruMu.Lock()
+	defer bc.lruMu.Unlock()
+	entry.elem = bc.lru.PushFront(id)
+	var events []CacheEvent
+	for bc.lru.Len() > bc.maxEntries {
+		oldest := bc.lru.Back()
+		bc.lru.Remove(oldest)
+		id := oldest.Value.([sha1.Size]byte)
+		evicted, _ := bc.entry(id)
+		evicted.elem = nil
+		bc.remove(id)
+		if bc.OnEvent != nil {
+			events = append(events, evicted.event(CacheEvicted, nil))
+		}
+	}
+	return events
+}
+
+// untrack removes a entry from the LRU list
+func (bc *BasicCache) untrack(entry *cacheEntry) {
+	bc.lruMu.Lock()
+	defer bc.lruMu.Unlock()
+	if entry.elem != nil {
+		bc.lru.Remove(entry.elem)
+		entry.elem = nil
+	}
+}
+
+// touch marks a entry as the most recently used. Since it is called by every
+// lookup of a bounded cache the entry isn't moved if another goroutine holds
+// the LRU lock, so the order is approximate under contention but lookups
+// never wait for each other.
+func (bc *BasicCache) touch(entry *cacheEntry) {
+	if bc.maxEntries <= 0 || !bc.lruMu.TryLock() {
+		return
+	}
+	defer bc.lruMu.Unlock()
+	if entry.elem != nil {
+		bc.lru.MoveToFront(entry.elem)
+	}
+}
+
+// Pin prevents the answer for q from being evicted from a bounded cache, it is
+// still removed once it expires
+func (bc *BasicCache) Pin(q *Question) {
+	id := hashQuestion(q)
 	bc.mu.Lock()
 	defer bc.mu.Unlock()
-	delete(bc.cache, id)
+	if bc.pinned == nil {
+		bc.pinned = make(map[[sha1.Size]byte]struct{})
+	}
+	bc.pinned[id] = struct{}{}
+	if entry, present := bc.entry(id); present {
+		bc.untrack(entry)
+	}
+}
+
+// Unpin allows the answer for q to be evicted again
+func (bc *BasicCache) Unpin(q *Question) {
+	id := hashQuestion(q)
+	var events []CacheEvent
+	defer func() { bc.emit(events) }()
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	if _, present := bc.pinned[id]; !present {
+		return
+	}
+	delete(bc.pinned, id)
+	if entry, present := bc.entry(id); present {
+		events = bc.track(id, entry)
+	}
+}
+
+// Flush removes the answers, including scoped answers, for name, or if subdomains
+// is true for name and every name below it, and returns the number removed.
+// Answers added forever, such as the trust anchors, are kept.
+func (bc *BasicCache) Flush(name string, subdomains bool) int {
+	flushed := 0
+	var events []CacheEvent
+	defer func() { bc.emit(events) }()
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	bc.cache.Range(func(id, e interface{}) bool {
+		entry := e.(*cacheEntry)
+		if entry.forever || !flushMatches(entry.question.Name, name, subdomains) {
+			return true
+		}
+		bc.untrack(entry)
+		bc.remove(id.([sha1.Size]byte))
+		flushed++
+		if bc.OnEvent != nil {
+			events = append(events, entry.event(CacheFlushed, nil))
+		}
+		return true
+	})
+	bc.scoped.Range(func(id, e interface{}) bool {
+		// every scoped entry for a id is for the same question
+		entries := e.([]scopedEntry)
+		if len(entries) == 0 || !flushMatches(entries[0].entry.question.Name, name, subdomains) {
+			return true
+		}
+		for _, se := range entries {
+			atomic.AddInt64(&bc.bytes, -se.entry.load().size)
+			flushed++
+			if bc.OnEvent != nil {
+				events = append(events, se.entry.event(CacheFlushed, se.scope))
+			}
+		}
+		bc.scoped.Delete(id)
+		return true
+	})
+	return flushed
 }
 
 func (bc *BasicCache) fullPrune() {
+	bc.prune(0)
+}
+
+// prune removes expired answers, examining at most limit answers, and scoped
+// answers, if limit is positive. Since map iteration order is random repeated
+// passes eventually examine every answer.
+func (bc *BasicCache) prune(limit int) {
 	ids := [][sha1.Size]byte{}
-	bc.mu.RLock()
-	for id, a := range bc.cache {
-		if a.expired(bc.clk) {
-			ids = append(ids, id)
+	examined := 0
+	bc.cache.Range(func(id, e interface{}) bool {
+		if limit > 0 && examined == limit {
+			return false
 		}
-	}
-	bc.mu.RUnlock()
+		examined++
+		if e.(*cacheEntry).expired(bc.clk) {
+			ids = append(ids, id.([sha1.Size]byte))
+		}
+		return true
+	})
 	for _, id := range ids {
-		bc.del(id)
+		bc.delExpired(id)
 	}
+	var events []CacheEvent
+	defer func() { bc.emit(events) }()
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	examined = 0
+	bc.scoped.Range(func(id, e interface{}) bool {
+		if limit > 0 && examined >= limit {
+			return false
+		}
+		entries := e.([]scopedEntry)
+		examined += len(entries)
+		var fresh []scopedEntry
+		for _, se := range entries {
+			if !se.entry.expired(bc.clk) {
+				fresh = append(fresh, se)
+				continue
+			}
+			atomic.AddInt64(&bc.bytes, -se.entry.load().size)
+			if bc.OnEvent != nil {
+				events = append(events, se.entry.event(CacheExpired, se.scope))
+			}
+		}
+		if len(fresh) == 0 {
+			bc.scoped.Delete(id)
+		} else if len(fresh) < len(entries) {
+			bc.scoped.Store(id, fresh)
+		}
+		return true
+	})
 }
 
 // Add adds a response to the cache using a index based on the question
 func (bc *BasicCache) Add(q *Question, answer *Answer, forever bool) {
+	bc.add(q, answer, forever, CredibilityAnswer)
+}
+
+// AddWithCredibility adds data to the cache which replaces any cached data for the
+// question which is less credible or has expired
+func (bc *BasicCache) AddWithCredibility(q *Question, answer *Answer, credibility Credibility) {
+	bc.add(q, answer, false, credibility)
+}
+
+func (bc *BasicCache) add(q *Question, answer *Answer, forever bool, credibility Credibility) {
 	id := hashQuestion(q)
 	var ttl int
 	if !forever {
-		ttl = minTTL(append(answer.Answer, append(answer.Additional, answer.Authority...)...), bc.clk)
+		answer, ttl = bc.ttls.limit(answer, bc.clk)
 		if ttl == 0 {
 			return
 		}
 	}
-	// should filter out OPT records here
+	var events []CacheEvent
+	defer func() { bc.emit(events) }()
 	bc.mu.Lock()
 	defer bc.mu.Unlock()
-	if _, present := bc.cache[id]; present {
-		bc.cache[id].update(answer, ttl, bc.clk)
+	if entry, present := bc.entry(id); present {
+		updated, grown := entry.update(answer, ttl, credibility, bc.clk)
+		atomic.AddInt64(&bc.bytes, grown)
+		if updated && bc.OnEvent != nil {
+			events = append(events, entry.event(CacheRefreshed, nil))
+		}
+		bc.touch(entry)
 		return
 	}
-	bc.cache[id] = &cacheEntry{
-		answer,
-		ttl,
-		bc.clk.Now(),
-		forever,
-		sync.Mutex{},
+	entry := newCacheEntry(q, answer, ttl, forever, credibility, bc.clk.Now())
+	bc.cache.Store(id, entry)
+	bc.size++
+	atomic.AddInt64(&bc.bytes, entry.load().size)
+	if bc.OnEvent != nil {
+		events = append(events, entry.event(CacheInserted, nil))
 	}
-	if forever {
+	events = append(events, bc.track(id, entry)...)
+}
+
+// AddScoped adds a answer which only applies to clients in scope, answers with a
+// zero length scope apply to all clients and are added as normal
+func (bc *BasicCache) AddScoped(q *Question, answer *Answer, scope *net.IPNet) {
+	if ones, _ := scope.Mask.Size(); ones == 0 {
+		bc.Add(q, answer, false)
 		return
 	}
-	// go bc.prune(q, id, ttl)
+	answer, ttl := bc.ttls.limit(answer, bc.clk)
+	if ttl == 0 {
+		return
+	}
+	id := hashQuestion(q)
+	var events []CacheEvent
+	defer func() { bc.emit(events) }()
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	entries := bc.scopedEntries(id)
+	for _, se := range entries {
+		if se.scope.String() == scope.String() {
+			updated, grown := se.entry.update(answer, ttl, CredibilityAnswer, bc.clk)
+			atomic.AddInt64(&bc.bytes, grown)
+			if updated && bc.OnEvent != nil {
+				events = append(events, se.entry.event(CacheRefreshed, scope))
+			}
+			return
+		}
+	}
+	entry := newCacheEntry(q, answer, ttl, false, CredibilityAnswer, bc.clk.Now())
+	// readers may be iterating over the current slice so a copy is stored
+	updated := make([]scopedEntry, len(entries), len(entries)+1)
+	copy(updated, entries)
+	bc.scoped.Store(id, append(updated, scopedEntry{scope: scope, entry: entry}))
+	atomic.AddInt64(&bc.bytes, entry.load().size)
+	if bc.OnEvent != nil {
+		events = append(events, entry.event(CacheInserted, scope))
+	}
 }
 
-func (bc *BasicCache) getEntry(q *Question) (*cacheEntry, bool) {
-	id := hashQuestion(q)
-	bc.mu.RLock()
-	defer bc.mu.RUnlock()
-	entry, present := bc.cache[id]
-	return entry, present
+// GetScoped returns the answer for a question with the most specific scope that
+// contains client, or a answer that applies to all clients
+func (bc *BasicCache) GetScoped(q *Question, client net.IP) *Answer {
+	var best *cacheEntry
+	bestOnes := -1
+	for _, se := range bc.scopedEntries(hashQuestion(q)) {
+		if ones, _ := se.scope.Mask.Size(); ones > bestOnes && se.scope.Contains(client) && !se.entry.expired(bc.clk) {
+			best, bestOnes = se.entry, ones
+		}
+	}
+	if best == nil {
+		return bc.Get(q)
+	}
+	return best.load().answerAt(bc.clk.Now(), best.forever)
 }
 
-// Get returns the response for a question if it exists in the cache
+// Get returns the response for a question if it exists in the cache, the TTLs of
+// its records are reduced by the time it has been cached
 func (bc *BasicCache) Get(q *Question) *Answer {
-	if entry, present := bc.getEntry(q); present {
-		if entry.expired(bc.clk) {
-			bc.del(hashQuestion(q))
-			return nil
+	return bc.GetWithCredibility(q, CredibilityAnswer)
+}
+
+// GetWithCredibility returns the cached data for a question if it is at least as
+// credible as min
+func (bc *BasicCache) GetWithCredibility(q *Question, min Credibility) *Answer {
+	id := hashQuestion(q)
+	entry, present := bc.entry(id)
+	if !present {
+		return nil
+	}
+	if entry.expired(bc.clk) {
+		bc.delExpired(id)
+		return nil
+	}
+	cs := entry.load()
+	if !entry.forever && cs.credibility < min {
+		return nil
+	}
+	bc.touch(entry)
+	return cs.answerAt(bc.clk.Now(), entry.forever)
+}
+
+// MemoryUsage returns the approximate number of bytes used by the cached answers
+func (bc *BasicCache) MemoryUsage() int64 {
+	return atomic.LoadInt64(&bc.bytes)
+}
+
+// Shrink evicts answers until at least n bytes have been freed, or there are no
+// more answers which can be evicted, and returns the number of bytes freed.
+// Expired answers are removed first, followed by the least recently used answers
+// of a bounded cache or arbitrary answers of an unbounded one. Answers added
+// forever and pinned answers are never evicted, and scoped answers are only
+// removed once they expire.
+func (bc *BasicCache) Shrink(n int64) int64 {
+	var freed int64
+	var events []CacheEvent
+	defer func() { bc.emit(events) }()
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	evict := func(id [sha1.Size]byte, entry *cacheEntry, t CacheEventType) {
+		freed += entry.load().size
+		bc.remove(id)
+		if bc.OnEvent != nil {
+			events = append(events, entry.event(t, nil))
+		}
+	}
+	bc.cache.Range(func(id, e interface{}) bool {
+		if entry := e.(*cacheEntry); entry.expired(bc.clk) {
+			bc.untrack(entry)
+			evict(id.([sha1.Size]byte), entry, CacheExpired)
 		}
-		entry.mu.Lock()
-		defer entry.mu.Unlock()
-		return entry.answer
+		return freed < n
+	})
+	if bc.maxEntries > 0 {
+		bc.lruMu.Lock()
+		defer bc.lruMu.Unlock()
+		for freed < n && bc.lru.Len() > 0 {
+			oldest := bc.lru.Back()
+			bc.lru.Remove(oldest)
+			id := oldest.Value.([sha1.Size]byte)
+			entry, _ := bc.entry(id)
+			entry.elem = nil
+			evict(id, entry, CacheEvicted)
+		}
+		return freed
+	}
+	if freed < n {
+		bc.cache.Range(func(id, e interface{}) bool {
+			entry, key := e.(*cacheEntry), id.([sha1.Size]byte)
+			if _, pinned := bc.pinned[key]; !entry.forever && !pinned {
+				evict(key, entry, CacheEvicted)
+			}
+			return freed < n
+		})
 	}
-	return nil
+	return freed
 }
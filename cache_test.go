@@ -1,8 +1,9 @@
 package solvere
 
 import (
-	"crypto/sha1"
+	"fmt"
 	"net"
+	"sync"
 	"testing"
 	"time"
 
@@ -26,12 +27,8 @@ func TestMinTTL(t *testing.T) {
 	}
 
 	fc := clock.NewFake()
-	n := time.Now().Add(time.Second).UTC().Unix()
-	mod := (n / year68) - 1
-	if mod < 0 {
-		mod = 0
-	}
-	e := uint32(n - (mod * year68))
+	fc.Set(time.Date(2038, time.January, 19, 3, 14, 7, 0, time.UTC))
+	e := uint32(fc.Now().Add(time.Second).Unix())
 	rrSet = []dns.RR{
 		&dns.A{Hdr: dns.RR_Header{Ttl: 5}},
 		&dns.RRSIG{Hdr: dns.RR_Header{Ttl: 4, Rrtype: dns.TypeRRSIG}, Expiration: e},
@@ -40,11 +37,21 @@ func TestMinTTL(t *testing.T) {
 	if min != 1 {
 		t.Fatalf("minTTL didn't account for RRSIG expiring before TTL: wanted %d, got %d", 1, min)
 	}
+	// expiration times wrap around
+	fc.Add(time.Duration(year68) * time.Second)
+	rrSet[1].(*dns.RRSIG).Expiration = uint32(fc.Now().Add(3 * time.Second).Unix())
+	if min = minTTL(rrSet, fc); min != 3 {
+		t.Fatalf("minTTL didn't handle a wrapped RRSIG expiration: wanted %d, got %d", 3, min)
+	}
+	rrSet[1].(*dns.RRSIG).Expiration = uint32(fc.Now().Add(-time.Second).Unix())
+	if min = minTTL(rrSet, fc); min != 0 {
+		t.Fatalf("minTTL produced a non-zero TTL with a expired RRSIG: %d", min)
+	}
 }
 
 func TestCache(t *testing.T) {
 	fc := clock.NewFake()
-	cache := &BasicCache{cache: make(map[[sha1.Size]byte]*cacheEntry), clk: fc}
+	cache := &BasicCache{clk: fc}
 
 	q := Question{Name: "testing", Type: dns.TypeA}
 	ca := cache.Get(&q)
@@ -68,7 +75,7 @@ func TestCache(t *testing.T) {
 	q = Question{Name: "testing-2", Type: dns.TypeA}
 	cache.Add(&q, &a, false)
 	ca = cache.Get(&q)
-	if ca != &a {
+	if !isCachedAnswer(ca, &a) {
 		t.Fatalf("Cache returned incorrect answer: expected %#v, got %#v", a, ca)
 	}
 	fc.Add(time.Second * 30)
@@ -105,3 +112,383 @@ func TestCache(t *testing.T) {
 	}
 
 }
+
+// isCachedAnswer checks if a was returned from the cache for original, ignoring
+// the TTLs of its records which are decremented by the cache
+func isCachedAnswer(a, original *Answer) bool {
+	if a == nil || len(a.Answer) != len(original.Answer) {
+		return false
+	}
+	for i, r := range a.Answer {
+		r, o := dns.Copy(r), dns.Copy(original.Answer[i])
+		r.Header().Ttl = o.Header().Ttl
+		if r.String() != o.String() {
+			return false
+		}
+	}
+	return true
+}
+
+func TestCacheDecrementsTTLs(t *testing.T) {
+	fc := clock.NewFake()
+	cache := &BasicCache{clk: fc}
+	q := Question{Name: "example.", Type: dns.TypeA}
+	opt := &dns.OPT{Hdr: dns.RR_Header{Name: ".", Rrtype: dns.TypeOPT, Ttl: 1 << 15}}
+	a := &Answer{
+		Answer:     []dns.RR{&dns.A{Hdr: dns.RR_Header{Name: "example.", Rrtype: dns.TypeA, Ttl: 60}, A: net.IP{1, 2, 3, 4}}},
+		Authority:  []dns.RR{&dns.NS{Hdr: dns.RR_Header{Name: "example.", Rrtype: dns.TypeNS, Ttl: 3600}, Ns: "ns.example."}},
+		Additional: []dns.RR{opt},
+	}
+	added := fc.Now()
+	cache.Add(&q, a, false)
+
+	fc.Add(25*time.Second + 500*time.Millisecond)
+	ca := cache.Get(&q)
+	if ca == nil {
+		t.Fatal("Cache didn't return a fresh answer")
+	}
+	if ttl := ca.Answer[0].Header().Ttl; ttl != 35 {
+		t.Fatalf("Unexpected answer TTL: %d", ttl)
+	}
+	if ttl := ca.Authority[0].Header().Ttl; ttl != 3575 {
+		t.Fatalf("Unexpected authority TTL: %d", ttl)
+	}
+	if ca.Additional[0] != opt {
+		t.Fatal("OPT record was modified")
+	}
+	if !ca.Expires.Equal(added.Add(time.Minute)) {
+		t.Fatalf("Unexpected expiry: %s", ca.Expires)
+	}
+	if a.Answer[0].Header().Ttl != 60 || a.Authority[0].Header().Ttl != 3600 {
+		t.Fatal("Records of the cached answer were modified")
+	}
+	// the records are only copied once a second
+	if again := cache.Get(&q); again.Answer[0] != ca.Answer[0] {
+		t.Fatal("Records were copied again during the same second")
+	}
+	fc.Add(time.Second)
+	if ttl := cache.Get(&q).Answer[0].Header().Ttl; ttl != 34 || ca.Answer[0].Header().Ttl != 35 {
+		t.Fatalf("Unexpected answer TTL a second later: %d", ttl)
+	}
+
+	forever := Question{Name: "forever.", Type: dns.TypeA}
+	cache.Add(&forever, a, true)
+	fc.Add(time.Hour)
+	if ca := cache.Get(&forever); ca != a {
+		t.Fatalf("Answer cached forever was changed: %v", ca)
+	}
+}
+
+func TestCacheSignatureExpiration(t *testing.T) {
+	fc := clock.NewFake()
+	fc.Set(time.Date(2024, time.March, 1, 0, 0, 0, 0, time.UTC))
+	cache := &BasicCache{clk: fc}
+	q := Question{Name: "example.", Type: dns.TypeA}
+	a := &Answer{Authenticated: true, Answer: []dns.RR{
+		&dns.A{Hdr: dns.RR_Header{Name: "example.", Rrtype: dns.TypeA, Ttl: 3600}, A: net.IP{1, 2, 3, 4}},
+		&dns.RRSIG{
+			Hdr:         dns.RR_Header{Name: "example.", Rrtype: dns.TypeRRSIG, Ttl: 3600},
+			TypeCovered: dns.TypeA,
+			Expiration:  uint32(fc.Now().Add(time.Minute).Unix()),
+		},
+	}}
+	cache.Add(&q, a, false)
+	ca := cache.Get(&q)
+	if ca == nil {
+		t.Fatal("Signed answer wasn't cached")
+	}
+	for _, r := range ca.Answer {
+		if r.Header().Ttl != 60 {
+			t.Fatalf("TTL wasn't lowered to the signature expiration: %s", r)
+		}
+	}
+	fc.Add(61 * time.Second)
+	if ca := cache.Get(&q); ca != nil {
+		t.Fatalf("Answer was returned after its signature expired: %v", ca)
+	}
+
+	expired := &Answer{Answer: []dns.RR{
+		&dns.A{Hdr: dns.RR_Header{Name: "example.", Rrtype: dns.TypeA, Ttl: 3600}, A: net.IP{1, 2, 3, 4}},
+		&dns.RRSIG{
+			Hdr:         dns.RR_Header{Name: "example.", Rrtype: dns.TypeRRSIG, Ttl: 3600},
+			TypeCovered: dns.TypeA,
+			Expiration:  uint32(fc.Now().Add(-time.Second).Unix()),
+		},
+	}}
+	cache.Add(&q, expired, false)
+	if ca := cache.Get(&q); ca != nil {
+		t.Fatalf("Answer with a expired signature was cached: %v", ca)
+	}
+}
+
+func TestCacheTTLLimits(t *testing.T) {
+	cache := NewCache(CacheConfig{
+		MinTTL:         time.Minute,
+		MaxTTL:         time.Hour,
+		NegativeMinTTL: time.Second,
+		NegativeMaxTTL: 10 * time.Second,
+	})
+	defer cache.Close()
+	fc := clock.NewFake()
+	cache.clk = fc
+	soa := func(ttl uint32) []dns.RR {
+		return []dns.RR{&dns.SOA{Hdr: dns.RR_Header{Name: "example.", Rrtype: dns.TypeSOA, Ttl: ttl}, Minttl: ttl}}
+	}
+	a := func(ttl uint32) []dns.RR {
+		return []dns.RR{&dns.A{Hdr: dns.RR_Header{Name: "example.", Rrtype: dns.TypeA, Ttl: ttl}, A: net.IP{1, 2, 3, 4}}}
+	}
+	for _, tc := range []struct {
+		answer   *Answer
+		expected time.Duration
+	}{
+		{&Answer{Answer: a(5)}, time.Minute},
+		{&Answer{Answer: a(86400)}, time.Hour},
+		{&Answer{Answer: a(300)}, 5 * time.Minute},
+		{&Answer{Rcode: dns.RcodeNameError, Authority: soa(3600)}, 10 * time.Second},
+		{&Answer{Authority: soa(3600)}, 10 * time.Second},
+		{&Answer{Authority: soa(5)}, 5 * time.Second},
+	} {
+		q := &Question{Name: "example.", Type: dns.TypeA}
+		cache.Flush("example.", false)
+		cache.Add(q, tc.answer, false)
+		ca := cache.Get(q)
+		if ca == nil {
+			t.Fatalf("Answer wasn't cached: %v", tc.answer)
+		}
+		if expires := ca.Expires.Sub(fc.Now()); expires != tc.expected {
+			t.Errorf("Expected %v to be cached for %s, got %s", tc.answer, tc.expected, expires)
+		}
+		if ttl := ca.MinTTL(); ttl != tc.expected {
+			t.Errorf("Expected the TTLs of %v to be %s, got %s", tc.answer, tc.expected, ttl)
+		}
+	}
+}
+
+func BenchmarkHashQuestion(b *testing.B) {
+	q := &Question{Name: "www.example.com.", Type: dns.TypeAAAA}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		hashQuestion(q)
+	}
+}
+
+func TestCacheCredibility(t *testing.T) {
+	fc := clock.NewFake()
+	cache := &BasicCache{clk: fc}
+	q := Question{Name: "ns.example.", Type: dns.TypeA}
+	glue := &Answer{Answer: []dns.RR{&dns.A{Hdr: dns.RR_Header{Ttl: 10}, A: net.IP{1, 2, 3, 4}}}}
+	answer := &Answer{Answer: []dns.RR{&dns.A{Hdr: dns.RR_Header{Ttl: 10}, A: net.IP{5, 6, 7, 8}}}}
+
+	cache.AddWithCredibility(&q, glue, CredibilityAdditional)
+	if a := cache.Get(&q); a != nil {
+		t.Fatal("Get returned data from the additional section")
+	}
+	if a := cache.GetWithCredibility(&q, CredibilityAdditional); !isCachedAnswer(a, glue) {
+		t.Fatalf("GetWithCredibility returned the wrong data: %v", a)
+	}
+
+	cache.Add(&q, answer, false)
+	if a := cache.Get(&q); !isCachedAnswer(a, answer) {
+		t.Fatalf("Answer didn't replace less credible data: %v", a)
+	}
+	cache.AddWithCredibility(&q, glue, CredibilityAdditional)
+	if a := cache.GetWithCredibility(&q, CredibilityAdditional); !isCachedAnswer(a, answer) {
+		t.Fatalf("Less credible data replaced a fresh answer: %v", a)
+	}
+
+	// once the answer expires it can be replaced by anything
+	fc.Add(11 * time.Second)
+	cache.AddWithCredibility(&q, glue, CredibilityAdditional)
+	if a := cache.GetWithCredibility(&q, CredibilityAdditional); !isCachedAnswer(a, glue) {
+		t.Fatalf("Expired answer wasn't replaced: %v", a)
+	}
+}
+
+func TestBoundedCache(t *testing.T) {
+	cache := NewBoundedCache(2)
+	answer := &Answer{Answer: []dns.RR{&dns.A{Hdr: dns.RR_Header{Ttl: 60}, A: net.IP{1, 2, 3, 4}}}}
+	a, b, c, d := &Question{Name: "a.", Type: dns.TypeA}, &Question{Name: "b.", Type: dns.TypeA}, &Question{Name: "c.", Type: dns.TypeA}, &Question{Name: "d.", Type: dns.TypeA}
+
+	cache.Add(a, answer, false)
+	cache.Add(b, answer, false)
+	// using a makes b the least recently used
+	cache.Get(a)
+	cache.Add(c, answer, false)
+	if cache.Get(b) != nil {
+		t.Fatal("Least recently used answer wasn't evicted")
+	}
+	if cache.Get(a) == nil || cache.Get(c) == nil {
+		t.Fatal("Recently used answers were evicted")
+	}
+
+	// pinned and forever answers aren't evicted
+	cache.Pin(d)
+	cache.Add(d, answer, false)
+	cache.Add(&Question{Name: "e.", Type: dns.TypeA}, answer, true)
+	cache.Add(b, answer, false)
+	cache.Add(&Question{Name: "f.", Type: dns.TypeA}, answer, false)
+	cache.Add(&Question{Name: "g.", Type: dns.TypeA}, answer, false)
+	if cache.Get(d) == nil || cache.Get(&Question{Name: "e.", Type: dns.TypeA}) == nil {
+		t.Fatal("Pinned answer was evicted")
+	}
+	if cache.lru.Len() != 2 || cacheSize(cache) != 4 {
+		t.Fatalf("Unexpected cache size: %d tracked, %d total", cache.lru.Len(), cacheSize(cache))
+	}
+
+	cache.Unpin(d)
+	if cache.Get(&Question{Name: "f.", Type: dns.TypeA}) != nil {
+		t.Fatal("Unpinned answer didn't take up room in the cache")
+	}
+}
+
+func TestCacheEvents(t *testing.T) {
+	fc := clock.NewFake()
+	cache := NewBoundedCache(1)
+	cache.clk = fc
+	var events []CacheEvent
+	cache.OnEvent = func(e CacheEvent) {
+		events = append(events, e)
+	}
+	a, b := &Question{Name: "a.", Type: dns.TypeA}, &Question{Name: "b.", Type: dns.TypeA}
+	answer := &Answer{Answer: []dns.RR{&dns.A{Hdr: dns.RR_Header{Ttl: 10}, A: net.IP{1, 2, 3, 4}}}}
+
+	cache.Add(a, answer, false)
+	cache.Add(a, answer, false)
+	cache.Add(b, answer, false)
+	fc.Add(11 * time.Second)
+	cache.fullPrune()
+	cache.AddScoped(a, answer, &net.IPNet{IP: net.IP{10, 0, 0, 0}, Mask: net.CIDRMask(8, 32)})
+
+	expected := []struct {
+		t    CacheEventType
+		name string
+	}{
+		{CacheInserted, "a."},
+		{CacheRefreshed, "a."},
+		{CacheInserted, "b."},
+		{CacheEvicted, "a."},
+		{CacheExpired, "b."},
+		{CacheInserted, "a."},
+	}
+	if len(events) != len(expected) {
+		t.Fatalf("Expected %d events, got %d: %v", len(expected), len(events), events)
+	}
+	for i, e := range expected {
+		if events[i].Type != e.t || events[i].Question.Name != e.name || events[i].Answer != answer {
+			t.Errorf("Event %d: expected %s %s, got %s %s", i, e.t, e.name, events[i].Type, events[i].Question.Name)
+		}
+	}
+	if events[5].Scope == nil {
+		t.Fatal("Scoped event is missing its scope")
+	}
+}
+
+func TestCacheFlush(t *testing.T) {
+	cache := NewBoundedCache(10)
+	var flushed []string
+	cache.OnEvent = func(e CacheEvent) {
+		if e.Type == CacheFlushed {
+			flushed = append(flushed, e.Question.Name)
+		}
+	}
+	answer := &Answer{Answer: []dns.RR{&dns.A{Hdr: dns.RR_Header{Ttl: 10}, A: net.IP{1, 2, 3, 4}}}}
+	for _, name := range []string{"example.com.", "www.example.com.", "notexample.com.", "example.net."} {
+		cache.Add(&Question{Name: name, Type: dns.TypeA}, answer, false)
+	}
+	cache.Add(&Question{Name: "example.com.", Type: dns.TypeAAAA}, answer, false)
+	cache.Add(&Question{Name: "com.", Type: dns.TypeDNSKEY}, answer, true)
+	cache.AddScoped(&Question{Name: "cdn.example.com.", Type: dns.TypeA}, answer, &net.IPNet{IP: net.IP{10, 0, 0, 0}, Mask: net.CIDRMask(8, 32)})
+
+	if n := cache.Flush("Example.com.", false); n != 2 {
+		t.Fatalf("Expected 2 answers to be flushed, got %d", n)
+	}
+	if cache.Get(&Question{Name: "example.com.", Type: dns.TypeA}) != nil || cache.Get(&Question{Name: "www.example.com.", Type: dns.TypeA}) == nil {
+		t.Fatal("Flush removed the wrong answers")
+	}
+	if n := cache.Flush("com.", true); n != 3 {
+		t.Fatalf("Expected 3 answers to be flushed, got %d", n)
+	}
+	if cache.Get(&Question{Name: "example.net.", Type: dns.TypeA}) == nil || cache.Get(&Question{Name: "com.", Type: dns.TypeDNSKEY}) == nil {
+		t.Fatal("Flush removed a answer outside the zone or added forever")
+	}
+	if cache.GetScoped(&Question{Name: "cdn.example.com.", Type: dns.TypeA}, net.IP{10, 0, 0, 1}) != nil {
+		t.Fatal("Flush didn't remove the scoped answer")
+	}
+	if len(flushed) != 5 || cache.lru.Len() != 1 {
+		t.Fatalf("Unexpected flush events %v, or LRU length %d", flushed, cache.lru.Len())
+	}
+}
+
+func TestCacheJanitor(t *testing.T) {
+	fc := clock.NewFake()
+	cache := NewCache(CacheConfig{JanitorInterval: time.Millisecond, JanitorBatch: 2})
+	defer cache.Close()
+	cache.mu.Lock()
+	cache.clk = fc
+	cache.mu.Unlock()
+	answer := &Answer{Answer: []dns.RR{&dns.A{Hdr: dns.RR_Header{Ttl: 10}, A: net.IP{1, 2, 3, 4}}}}
+	for _, name := range []string{"a.", "b.", "c.", "d.", "e."} {
+		cache.Add(&Question{Name: name, Type: dns.TypeA}, answer, false)
+	}
+	cache.Add(&Question{Name: "forever.", Type: dns.TypeA}, answer, true)
+
+	cache.prune(2)
+	if n := cacheSize(cache); n != 6 {
+		t.Fatalf("Fresh answers were removed, %d left", n)
+	}
+	fc.Add(11 * time.Second)
+	cache.prune(2)
+	if n := cacheSize(cache); n < 4 {
+		t.Fatalf("Pass examined more than its limit, %d left", n)
+	}
+	for i := 0; i < 1000 && cacheSize(cache) > 1; i++ {
+		time.Sleep(time.Millisecond)
+	}
+	if n := cacheSize(cache); n != 1 {
+		t.Fatalf("Janitor didn't remove expired answers, %d left", n)
+	}
+}
+
+func TestCacheConcurrentAccess(t *testing.T) {
+	cache := NewBoundedCache(8)
+	defer cache.Close()
+	answer := &Answer{Answer: []dns.RR{&dns.A{Hdr: dns.RR_Header{Ttl: 60}}}}
+	wg := new(sync.WaitGroup)
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 500; j++ {
+				q := &Question{Name: fmt.Sprintf("%d.", (i+j)%16), Type: dns.TypeA}
+				if j%4 == 0 {
+					cache.Add(q, answer, false)
+				} else if a := cache.Get(q); a != nil && !isCachedAnswer(a, answer) {
+					t.Error("Get returned a different answer than was added")
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	if n := cacheSize(cache); n > 8 {
+		t.Fatalf("Bounded cache holds %d answers", n)
+	}
+}
+
+func BenchmarkCacheGet(b *testing.B) {
+	cache := NewBasicCache()
+	defer cache.Close()
+	q := &Question{Name: "example.", Type: dns.TypeA}
+	cache.Add(q, &Answer{Answer: []dns.RR{&dns.A{Hdr: dns.RR_Header{Ttl: 3600}}}}, false)
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			cache.Get(q)
+		}
+	})
+}
+
+func cacheSize(bc *BasicCache) int {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	return bc.size
+}
@@ -0,0 +1,73 @@
+package solvere
+
+import (
+	"context"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// ServerIdentity describes a nameserver using the answers to CHAOS class TXT
+// queries, for anycast nameservers this identifies the instance that answered.
+// Fields are empty if the nameserver didn't answer the corresponding query.
+type ServerIdentity struct {
+	// ID is the answer for id.server. (RFC 4892)
+	ID string
+	// Hostname is the answer for hostname.bind.
+	Hostname string
+	// Version is the answer for version.bind.
+	Version string
+}
+
+// identityNames maps the CHAOS names that are queried to the fields of a
+// ServerIdentity
+var identityNames = []struct {
+	name  string
+	field func(*ServerIdentity) *string
+}{
+	{"id.server.", func(si *ServerIdentity) *string { return &si.ID }},
+	{"hostname.bind.", func(si *ServerIdentity) *string { return &si.Hostname }},
+	{"version.bind.", func(si *ServerIdentity) *string { return &si.Version }},
+}
+
+// chaosTXT queries the nameserver at addr for the CHAOS class TXT records at name
+// and returns their contents
+func (rr *RecursiveResolver) chaosTXT(ctx context.Context, addr, name string) (string, error) {
+	m := new(dns.Msg)
+	m.Id = dns.Id()
+	m.Question = []dns.Question{{Name: name, Qtype: dns.TypeTXT, Qclass: dns.ClassCHAOS}}
+	r, err := rr.exchange(ctx, m, addr)
+	if err != nil {
+		return "", err
+	}
+	var parts []string
+	for _, a := range r.Answer {
+		if txt, ok := a.(*dns.TXT); ok && strings.EqualFold(txt.Hdr.Name, name) {
+			parts = append(parts, strings.Join(txt.Txt, ""))
+		}
+	}
+	return strings.Join(parts, " "), nil
+}
+
+// IdentifyServer asks the nameserver at addr, which may omit the port, to identify
+// itself using the id.server, hostname.bind and version.bind CHAOS class TXT
+// queries. An error is only returned if none of the queries were answered.
+func (rr *RecursiveResolver) IdentifyServer(ctx context.Context, addr string) (*ServerIdentity, error) {
+	addr = nameserverAddr(&Nameserver{Addr: addr})
+	si := &ServerIdentity{}
+	var lastErr error
+	answered := false
+	for _, in := range identityNames {
+		txt, err := rr.chaosTXT(ctx, addr, in.name)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		answered = true
+		*in.field(si) = txt
+	}
+	if !answered {
+		return nil, lastErr
+	}
+	return si, nil
+}
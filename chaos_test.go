@@ -0,0 +1,51 @@
+package solvere
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestIdentifyServer(t *testing.T) {
+	rr := NewRecursiveResolver(false, false, nil, nil, nil)
+	var addrs []string
+	rr.Transport = transportFunc(func(_ context.Context, m *dns.Msg, addr string) (*dns.Msg, error) {
+		addrs = append(addrs, addr)
+		q := m.Question[0]
+		if q.Qclass != dns.ClassCHAOS || q.Qtype != dns.TypeTXT {
+			t.Fatalf("Unexpected question %v", q)
+		}
+		r := new(dns.Msg)
+		r.SetReply(m)
+		switch q.Name {
+		case "id.server.":
+			r.Answer = []dns.RR{&dns.TXT{Hdr: dns.RR_Header{Name: q.Name, Rrtype: dns.TypeTXT, Class: dns.ClassCHAOS}, Txt: []string{"fra", "1"}}}
+		case "version.bind.":
+			r.Answer = []dns.RR{&dns.TXT{Hdr: dns.RR_Header{Name: q.Name, Rrtype: dns.TypeTXT, Class: dns.ClassCHAOS}, Txt: []string{"9.18"}}}
+		default:
+			r.Rcode = dns.RcodeRefused
+		}
+		return r, nil
+	})
+	si, err := rr.IdentifyServer(context.Background(), "192.0.2.1")
+	if err != nil {
+		t.Fatalf("IdentifyServer failed: %s", err)
+	}
+	if si.ID != "fra1" || si.Hostname != "" || si.Version != "9.18" {
+		t.Fatalf("Unexpected identity: %+v", si)
+	}
+	if addrs[0] != net.JoinHostPort("192.0.2.1", dnsPort) {
+		t.Fatalf("Query sent to %s", addrs[0])
+	}
+
+	failed := errors.New("unreachable")
+	rr.Transport = transportFunc(func(context.Context, *dns.Msg, string) (*dns.Msg, error) {
+		return nil, failed
+	})
+	if _, err = rr.IdentifyServer(context.Background(), "192.0.2.1:53"); err != failed {
+		t.Fatalf("Expected the transport error, got %v", err)
+	}
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/rolandshoemaker/solvere"
+)
+
+func main() {
+	socket := flag.String("socket", "/run/solvd/control.sock", "Path of the unix socket passed to solvd with -controlSocket")
+	secretFile := flag.String("secret", "", "File containing the secret passed to solvd with -controlSecret")
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: solvctl [flags] command [args]")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	var secret string
+	if *secretFile != "" {
+		s, err := ioutil.ReadFile(*secretFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to read secret: %s\n", err)
+			os.Exit(1)
+		}
+		secret = strings.TrimSpace(string(s))
+	}
+	out, err := solvere.ControlRequest(*socket, secret, flag.Args()...)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	fmt.Print(out)
+}
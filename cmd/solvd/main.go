@@ -1,31 +1,401 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 	"time"
 
 	"github.com/miekg/dns"
 
 	"github.com/rolandshoemaker/solvere"
-	"github.com/rolandshoemaker/solvere/hints"
+	"github.com/rolandshoemaker/solvere/service"
 )
 
+func printLog(log *solvere.LookupLog) {
+	j, err := log.JSON()
+	if err != nil {
+		fmt.Println("err encoding log message")
+		return
+	}
+	fmt.Println(string(j))
+}
+
 func main() {
-	listenAddr := flag.String("listen", "127.0.0.1:53", "")
+	configFile := flag.String("config", "", "Path of a solvere configuration file, flags passed on the command line override the settings in it")
+	listenAddr := flag.String("listen", "127.0.0.1:53", "UDP and TCP address to listen on")
+	tlsListenAddr := flag.String("tlsListen", "", "TCP address to listen on for DNS-over-TLS queries, disabled if empty")
+	tlsCert := flag.String("tlsCert", "", "Path to PEM certificate used for DNS-over-TLS")
+	tlsKey := flag.String("tlsKey", "", "Path to PEM private key used for DNS-over-TLS")
+	maxConnections := flag.Int("maxConnections", 0, "Maximum number of concurrent TCP and TLS connections per listener, unlimited if zero")
+	idleTimeout := flag.Duration("idleTimeout", solvere.DefaultIdleTimeout, "How long to keep idle TCP and TLS connections open")
+	shutdownTimeout := flag.Duration("shutdownTimeout", 5*time.Second, "How long to wait for in-flight queries when shutting down")
+	serverRate := flag.Float64("serverRate", 0, "Maximum queries per second sent to a single upstream nameserver, unlimited if zero")
+	zoneRate := flag.Float64("zoneRate", 0, "Maximum queries per second sent to the nameservers of a single zone, unlimited if zero")
+	damping := flag.Int("subdomainDamping", 0, "Throttle queries to the nameservers of a zone once this many queries to them fail or are answered with NXDOMAIN within 10 seconds, disabled if zero")
+	maxResolutions := flag.Int("maxResolutions", 0, "Maximum number of concurrent resolutions, unlimited if zero")
+	resolutionQueue := flag.Int("resolutionQueue", 0, "Number of queries allowed to wait for a resolution slot once -maxResolutions is reached")
+	rebinding := flag.Bool("rebindingProtection", false, "Strip private addresses from the answers for external names")
+	internalZones := flag.String("internalZones", "", "Comma separated list of zones allowed to resolve to private addresses when -rebindingProtection is set")
+	privateNameservers := flag.String("privateNameserverZones", "", "Comma separated list of zones whose delegations may be to nameservers with private addresses, \".\" allows every zone")
+	resolvConf := flag.String("resolvConf", "", "Forward queries to the nameservers listed in this resolv.conf file instead of iterating, responses are still validated")
+	secondaryZones := flag.String("secondaryZones", "", "Comma separated list of origin=host:port zones to transfer from a primary and answer authoritatively")
+	transferKey := flag.String("transferKey", "", "TSIG key used for zone transfers, as name:base64 secret, using HMAC-SHA256")
+	forwardStamps := flag.String("forwardStamps", "", "Comma separated list of sdns:// DNS stamps of plain, DoH, or DoT servers to forward queries to instead of iterating, responses are still validated")
+	forwardKey := flag.String("forwardKey", "", "TSIG key used to sign queries to the -resolvConf nameservers, as name:base64 secret, using HMAC-SHA256")
+	raceForwarders := flag.Bool("raceForwarders", false, "Send queries to two of the -resolvConf nameservers at once and use the first response")
+	noRecursion := flag.Bool("noRecursion", false, "Send queries to the -resolvConf nameservers with the RD bit clear, iterating when they can't answer without recursing")
+	localZones := flag.String("localZones", "", "Comma separated list of origin=path master files to answer authoritatively")
+	blocklist := flag.String("blocklist", "", "File listing names, one per line, which are answered with NXDOMAIN along with every name below them")
+	acl := flag.String("acl", "", "Comma separated list of network=action client rules, where action is allow_recursion, allow (only answer from local data and the cache) or refuse, clients not listed are refused")
+	clientBlocklists := flag.String("clientBlocklists", "", "Comma separated list of network=path blocklists used instead of -blocklist for the clients in each network")
+	trustAnchors := flag.String("trustAnchors", "", "Master file containing the root DNSKEY records to use as trust anchors instead of the built-in ones")
+	controlListen := flag.String("controlListen", "", "HTTP address to listen on for POST /reload requests, disabled if empty")
+	adminListen := flag.String("adminListen", "", "HTTP address to serve the JSON admin API on, for stats, cache flushes, configuration and health checks, disabled if empty")
+	grpcListen := flag.String("grpcListen", "", "Address to serve the gRPC Resolver service defined in service/resolver.proto on, without TLS, disabled if empty")
+	healthCheckName := flag.String("healthCheckName", "", "Name looked up by the admin API /health endpoint, which fails if it can't be resolved")
+	controlSocket := flag.String("controlSocket", "", "Path of a unix socket to accept solvctl commands on, disabled if empty")
+	controlSecret := flag.String("controlSecret", "", "File containing a secret solvctl must send along with commands to -controlSocket")
+	cacheSize := flag.Int("cacheSize", 0, "Maximum number of answers to cache, unlimited if zero")
+	maxMemory := flag.Int64("maxMemory", 0, "Approximate number of bytes the caches may use before answers are evicted, unlimited if zero")
+	addressOrder := flag.String("addressOrder", "fixed", "Order of cached A and AAAA records in answers, either fixed, rotate, or random")
+	permissive := flag.Bool("permissiveResponses", false, "Accept structurally invalid responses from broken nameservers instead of rejecting them")
+	minimal := flag.Bool("minimalResponses", false, "Only include the records needed to answer each query in responses")
+	parentFallback := flag.Bool("parentFallback", false, "Retry queries against the parent zone's nameservers when every nameserver of an unsigned zone fails to respond")
+	lazyValidation := flag.Bool("lazyValidation", false, "Return answers before they are validated and validate them in the background")
+	failureTTL := flag.Duration("failureTTL", solvere.DefaultFailureTTL, "How long a question that couldn't be resolved is answered with SERVFAIL without retrying, doubled for each consecutive failure, disabled if negative")
+	logLevel := flag.String("logLevel", "warn", "Minimum level of resolver diagnostics written to stderr, either debug, info, warn, or error")
+	slowQueries := flag.Duration("slowQueryThreshold", 0, "Write the lookup logs of queries taking at least this long to stderr as JSON, disabled if zero")
+	primeTLDs := flag.String("primeTLDs", "", "Comma separated list of top level domains whose delegations are learnt at startup, and hourly, so iteration for them skips the root")
+	pinned := flag.String("pin", "", "Comma separated list of names whose addresses are kept in the cache and refreshed as they expire")
+	allowFragments := flag.Bool("allowFragmentation", false, "Accept upstream responses that arrived fragmented and don't set the don't fragment bit on queries and responses")
 	flag.Parse()
+	passed := map[string]bool{}
+	flag.Visit(func(f *flag.Flag) { passed[f.Name] = true })
+	// override checks if the setting of a flag should be used, rather than the
+	// one from the configuration file
+	override := func(name string) bool {
+		return *configFile == "" || passed[name]
+	}
 
-	s := &server{solvere.NewRecursiveResolver(false, true, hints.RootNameservers, hints.RootKeys, solvere.NewBasicCache())}
-	dns.HandleFunc(".", s.handler)
-	dnsServer := &dns.Server{
-		Addr:         *listenAddr,
-		Net:          "udp",
-		ReadTimeout:  time.Millisecond,
-		WriteTimeout: time.Millisecond,
+	var opts []solvere.Option
+	var fileConfig *solvere.Config
+	if *configFile != "" {
+		c, err := solvere.LoadConfigFile(*configFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to load %s: %s\n", *configFile, err)
+			os.Exit(1)
+		}
+		fileConfig = c
+		if passed["cacheSize"] {
+			c.Cache = nil
+		}
+		if opts, err = c.Options(); err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid configuration %s: %s\n", *configFile, err)
+			os.Exit(1)
+		}
+		if c.Cache == nil && !passed["cacheSize"] {
+			opts = append(opts, solvere.WithCache(solvere.NewBasicCache()))
+		}
+	}
+	if override("cacheSize") {
+		opts = append(opts, solvere.WithCache(solvere.NewBoundedCache(*cacheSize)))
+	}
+	rr := solvere.NewResolver(opts...)
+	transport := solvere.NewUDPPoolTransport()
+	transport.DropOversized = !*allowFragments
+	transport.DontFragment = !*allowFragments
+	defer transport.Close()
+	if st, ok := rr.Transport.(*solvere.StampTransport); ok {
+		st.Fallback = transport
+	} else {
+		rr.Transport = transport
+	}
+	// the level can be changed at runtime using the verbosity control command
+	level := new(slog.LevelVar)
+	if err := level.UnmarshalText([]byte(*logLevel)); err != nil {
+		fmt.Fprintf(os.Stderr, "Unknown log level %q\n", *logLevel)
+		os.Exit(1)
+	}
+	rr.Logger = solvere.SlogLogger(slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: level})))
+	if override("minimalResponses") {
+		rr.MinimalResponses = *minimal
+	}
+	if override("permissiveResponses") {
+		rr.PermissiveResponses = *permissive
+	}
+	if override("failureTTL") {
+		rr.FailureTTL = *failureTTL
+	}
+	if *slowQueries > 0 {
+		rr.SlowQueryLog = solvere.NewSlowQueryWriter(os.Stderr)
+		rr.SlowQueryThreshold = *slowQueries
+	}
+	if override("parentFallback") {
+		rr.ParentFallback = *parentFallback
+	}
+	if override("lazyValidation") {
+		rr.LazyValidation = *lazyValidation
+	}
+	if override("maxMemory") {
+		rr.MaxMemory = *maxMemory
+	}
+	switch *addressOrder {
+	case "fixed":
+	case "rotate":
+		rr.AddressOrder = solvere.AddressOrderRotate
+	case "random":
+		rr.AddressOrder = solvere.AddressOrderRandom
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown address order %q\n", *addressOrder)
+		os.Exit(1)
+	}
+	if *rebinding {
+		rr.Rebinding = &solvere.RebindingProtection{}
+		if *internalZones != "" {
+			rr.Rebinding.AllowedZones = strings.Split(*internalZones, ",")
+		}
+	}
+	if *privateNameservers != "" {
+		rr.AllowPrivateNameservers = strings.Split(*privateNameservers, ",")
+	}
+	if *resolvConf != "" && *forwardStamps != "" {
+		fmt.Fprintln(os.Stderr, "-resolvConf and -forwardStamps can't be used together")
+		os.Exit(1)
+	}
+	conf := &config{
+		resolvConf:     *resolvConf,
+		forwardStamps:  *forwardStamps,
+		raceForwarders: *raceForwarders,
+		noRecursion:    *noRecursion,
+		localZones:     *localZones,
+		blocklist:      *blocklist,
+		trustAnchors:   *trustAnchors,
+
+		clientBlocklists: *clientBlocklists,
+	}
+	if *acl != "" {
+		for _, r := range strings.Split(*acl, ",") {
+			rule, err := solvere.ParseClientRule(r)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Invalid -acl: %s\n", err)
+				os.Exit(1)
+			}
+			conf.acl = append(conf.acl, rule)
+		}
+	}
+	if *forwardKey != "" {
+		key, err := parseTSIGKey(*forwardKey)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid forward key: %s\n", err)
+			os.Exit(1)
+		}
+		conf.forwardKey = key
+	}
+	if *secondaryZones != "" {
+		var key *solvere.TSIGKey
+		if *transferKey != "" {
+			var err error
+			if key, err = parseTSIGKey(*transferKey); err != nil {
+				fmt.Fprintf(os.Stderr, "Invalid transfer key: %s\n", err)
+				os.Exit(1)
+			}
+		}
+		for _, z := range strings.Split(*secondaryZones, ",") {
+			parts := strings.SplitN(z, "=", 2)
+			if len(parts) != 2 {
+				fmt.Fprintf(os.Stderr, "Invalid secondary zone %q, expected origin=host:port\n", z)
+				os.Exit(1)
+			}
+			sz := solvere.NewSecondaryZone(parts[0], parts[1], key)
+			sz.Logger = rr.Logger
+			conf.secondaryZones = append(conf.secondaryZones, sz.Zone)
+			go sz.Run(context.Background())
+		}
 	}
-	err := dnsServer.ListenAndServe()
+	if *maxResolutions > 0 {
+		rr.Limiter = solvere.NewConcurrencyLimiter(*maxResolutions, *resolutionQueue)
+	}
+	if *serverRate > 0 || *zoneRate > 0 {
+		rr.RateLimiter = solvere.NewRateLimiter(*serverRate, *zoneRate)
+	}
+	if *damping > 0 {
+		rr.Damping = &solvere.SubdomainDamping{Threshold: *damping}
+	}
+	rr.AddHooks(solvere.Hooks{
+		OnAnswer: func(_ context.Context, _ solvere.Question, a *solvere.Answer, log *solvere.LookupLog) *solvere.Answer {
+			printLog(log)
+			return a
+		},
+		OnError: func(_ context.Context, _ solvere.Question, err error, log *solvere.LookupLog) {
+			fmt.Println("Query failed:", err)
+			printLog(log)
+		},
+	})
+
+	// The resolver is only used as the base of the views built from conf, live
+	// configuration is read from the view the handler currently uses
+	handler := solvere.NewHandler(rr)
+	reloads := &reloader{base: rr, conf: conf, handler: handler}
+	if err := reloads.reload(); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load configuration: %s\n", err)
+		os.Exit(1)
+	}
+
+	if *primeTLDs != "" {
+		tlds := strings.Split(*primeTLDs, ",")
+		go func() {
+			for {
+				if err := rr.PrimeTLDs(context.Background(), tlds...); err != nil {
+					fmt.Fprintf(os.Stderr, "Failed to prime top level domains: %s\n", err)
+				}
+				time.Sleep(time.Hour)
+			}
+		}()
+	}
+
+	if *pinned != "" {
+		for _, name := range strings.Split(*pinned, ",") {
+			for _, t := range []uint16{dns.TypeA, dns.TypeAAAA} {
+				q := solvere.Question{Name: dns.Fqdn(name), Type: t}
+				if err := handler.CurrentResolver().Pin(context.Background(), q); err != nil {
+					fmt.Fprintf(os.Stderr, "Failed to pin %s %s: %s\n", q.Name, dns.TypeToString[t], err)
+				}
+			}
+		}
+	}
+
+	newServer := func(addr string) *solvere.Server {
+		s := &solvere.Server{Addr: addr, Handler: handler, DontFragment: !*allowFragments}
+		s.MaxConnections = *maxConnections
+		s.IdleTimeout = *idleTimeout
+		return s
+	}
+	sockets, err := solvere.SystemdSockets()
 	if err != nil {
-		fmt.Println(err)
-		return
+		fmt.Fprintf(os.Stderr, "Failed to use systemd sockets: %s\n", err)
+		os.Exit(1)
+	}
+	var servers []*solvere.Server
+	var listeners []func() error
+	if len(sockets) > 0 {
+		// when socket activated -listen and -tlsListen are ignored, TCP
+		// sockets named tls are used for DNS-over-TLS
+		plain, dot := newServer(""), newServer("")
+		servers = append(servers, plain, dot)
+		for _, sock := range sockets {
+			sock := sock
+			switch {
+			case sock.Name == "tls" && sock.Listener != nil:
+				listeners = append(listeners, func() error { return dot.ServeTLS(sock.Listener, *tlsCert, *tlsKey) })
+			case sock.Name == "tls":
+				fmt.Fprintln(os.Stderr, "systemd socket named tls isn't a TCP socket")
+				os.Exit(1)
+			default:
+				listeners = append(listeners, func() error { return plain.Serve(sock.PacketConn, sock.Listener) })
+			}
+		}
+	} else {
+		servers = append(servers, newServer(*listenAddr))
+		listeners = append(listeners, servers[0].ListenAndServe)
+		if *tlsListenAddr != "" {
+			s := newServer(*tlsListenAddr)
+			servers = append(servers, s)
+			listeners = append(listeners, func() error { return s.ListenAndServeTLS(*tlsCert, *tlsKey) })
+		}
+	}
+
+	go func() {
+		hups := make(chan os.Signal, 1)
+		signal.Notify(hups, syscall.SIGHUP)
+		for range hups {
+			if err := reloads.reloadRunning(); err != nil {
+				fmt.Fprintf(os.Stderr, "Failed to reload configuration, keeping the current one: %s\n", err)
+			}
+		}
+	}()
+	if *controlListen != "" {
+		go func() {
+			if err := http.ListenAndServe(*controlListen, reloads); err != nil {
+				fmt.Fprintf(os.Stderr, "Control server failed: %s\n", err)
+			}
+		}()
+	}
+
+	if *adminListen != "" {
+		ah := &solvere.AdminHandler{Handler: handler}
+		ah.Config = fileConfig
+		ah.HealthCheckName = *healthCheckName
+		go func() {
+			if err := http.ListenAndServe(*adminListen, ah); err != nil {
+				fmt.Fprintf(os.Stderr, "Admin server failed: %s\n", err)
+			}
+		}()
+	}
+	if *grpcListen != "" {
+		l, err := net.Listen("tcp", *grpcListen)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to listen on %s: %s\n", *grpcListen, err)
+			os.Exit(1)
+		}
+		go func() {
+			if err := (&service.Server{Handler: handler}).Serve(l); err != nil {
+				fmt.Fprintf(os.Stderr, "gRPC server failed: %s\n", err)
+			}
+		}()
+	}
+	if *controlSocket != "" {
+		cs := solvere.NewControlServer(rr)
+		if *controlSecret != "" {
+			secret, err := ioutil.ReadFile(*controlSecret)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Failed to read control secret: %s\n", err)
+				os.Exit(1)
+			}
+			cs.Secret = strings.TrimSpace(string(secret))
+		}
+		cs.Commands = controlCommands(reloads, level)
+		defer cs.Close()
+		go func() {
+			if err := cs.ListenAndServe(*controlSocket); err != nil && err != solvere.ErrServerClosed {
+				fmt.Fprintf(os.Stderr, "Control socket failed: %s\n", err)
+			}
+		}()
+	}
+
+	go func() {
+		sigs := make(chan os.Signal, 1)
+		signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+		<-sigs
+		solvere.SystemdNotify(solvere.SystemdStopping)
+		ctx, cancel := context.WithTimeout(context.Background(), *shutdownTimeout)
+		defer cancel()
+		for _, s := range servers {
+			if err := s.Shutdown(ctx); err != nil {
+				fmt.Println("Failed to shutdown cleanly:", err)
+			}
+		}
+	}()
+
+	errs := make(chan error, len(listeners))
+	for _, listen := range listeners {
+		go func(listen func() error) { errs <- listen() }(listen)
+	}
+	if err := solvere.SystemdNotify(solvere.SystemdReady); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to notify systemd: %s\n", err)
+	}
+	for range listeners {
+		if err := <-errs; err != nil && err != solvere.ErrServerClosed {
+			fmt.Println(err)
+			os.Exit(1)
+		}
 	}
 }
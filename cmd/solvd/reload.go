@@ -0,0 +1,296 @@
+package main
+
+import (
+	"bufio"
+	"encoding/base64"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/miekg/dns"
+
+	"github.com/rolandshoemaker/solvere"
+)
+
+// config contains the parts of the configuration which are read again when
+// solvd is reloaded
+type config struct {
+	resolvConf     string
+	forwardStamps  string
+	raceForwarders bool
+	noRecursion    bool
+	forwardKey     *solvere.TSIGKey
+	localZones     string
+	blocklist      string
+	trustAnchors   string
+	// acl are the client rules passed with -acl, clientBlocklists the
+	// network=path pairs passed with -clientBlocklists
+	acl              []solvere.ClientRule
+	clientBlocklists string
+	// secondaryZones are kept up to date by transfers, so they are carried over
+	// rather than reloaded
+	secondaryZones []*solvere.LocalZone
+}
+
+// apply returns a view of base using the configuration, and the access control
+// for clients, the files it names are all read before the view is created so if
+// any of them can't be loaded an error is returned and base is left untouched
+func (c *config) apply(base *solvere.RecursiveResolver) (*solvere.RecursiveResolver, *solvere.AccessControl, error) {
+	var opts []solvere.Option
+	if c.trustAnchors != "" {
+		keys, err := loadTrustAnchors(c.trustAnchors)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to load %s: %w", c.trustAnchors, err)
+		}
+		opts = append(opts, solvere.WithTrustAnchors(keys))
+	}
+	var fc *solvere.ForwardConfig
+	if c.resolvConf != "" {
+		rc, err := solvere.LoadResolvConf(c.resolvConf)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to load %s: %w", c.resolvConf, err)
+		}
+		fc = rc.ForwardConfig()
+	} else if c.forwardStamps != "" {
+		var st *solvere.StampTransport
+		var err error
+		if fc, st, err = solvere.ForwardStamps(strings.Split(c.forwardStamps, ",")); err != nil {
+			return nil, nil, fmt.Errorf("invalid forward stamps: %w", err)
+		}
+		// queries which aren't forwarded, such as those when iterating after
+		// the forwarders refuse them, use the base transport
+		st.Fallback = base.Transport
+		opts = append(opts, solvere.WithTransport(st))
+	}
+	if fc != nil {
+		fc.Race = c.raceForwarders
+		fc.NoRecursion = c.noRecursion
+		if c.forwardKey != nil && c.resolvConf != "" {
+			fc.Keys = make(map[string]*solvere.TSIGKey, len(fc.Servers))
+			for _, s := range fc.Servers {
+				fc.Keys[s] = c.forwardKey
+			}
+		}
+		opts = append(opts, solvere.WithForwardConfig(fc))
+	}
+	// the zones from the configuration file are part of base
+	zones := append(append([]*solvere.LocalZone(nil), base.LocalZones...), c.secondaryZones...)
+	if c.localZones != "" {
+		for _, z := range strings.Split(c.localZones, ",") {
+			parts := strings.SplitN(z, "=", 2)
+			if len(parts) != 2 {
+				return nil, nil, fmt.Errorf("invalid local zone %q, expected origin=path", z)
+			}
+			lz, err := solvere.LoadLocalZone(parts[1], parts[0])
+			if err != nil {
+				return nil, nil, fmt.Errorf("failed to load %s: %w", parts[1], err)
+			}
+			zones = append(zones, lz)
+		}
+	}
+	specialUse, err := blockedDomains(c.blocklist)
+	if err != nil {
+		return nil, nil, err
+	}
+	rr := base.With(opts...)
+	rr.LocalZones = zones
+	rr.SpecialUseDomains = specialUse
+	ac, err := c.accessControl(rr)
+	if err != nil {
+		return nil, nil, err
+	}
+	return rr, ac, nil
+}
+
+// blockedDomains returns the special use domains answering the names in the
+// blocklist at path with NXDOMAIN, or nil to use the defaults if path is empty
+func blockedDomains(path string) (map[string]solvere.SpecialUse, error) {
+	if path == "" {
+		return nil, nil
+	}
+	names, err := loadBlocklist(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load %s: %w", path, err)
+	}
+	specialUse := make(map[string]solvere.SpecialUse, len(solvere.DefaultSpecialUseDomains)+len(names))
+	for name, su := range solvere.DefaultSpecialUseDomains {
+		specialUse[name] = su
+	}
+	for _, name := range names {
+		specialUse[name] = solvere.SpecialUseNXDOMAIN
+	}
+	return specialUse, nil
+}
+
+// accessControl returns the access control for clients, or nil if every client
+// is allowed to use rr. Clients with their own blocklist use a view of rr with
+// it, and the action of the -acl rule containing their network.
+func (c *config) accessControl(rr *solvere.RecursiveResolver) (*solvere.AccessControl, error) {
+	if len(c.acl) == 0 && c.clientBlocklists == "" {
+		return nil, nil
+	}
+	ac := &solvere.AccessControl{Rules: c.acl}
+	if len(c.acl) > 0 {
+		// once any rules are given only the listed clients are answered
+		ac.Default = solvere.ClientRefuse
+	}
+	if c.clientBlocklists == "" {
+		return ac, nil
+	}
+	var rules []solvere.ClientRule
+	for _, cb := range strings.Split(c.clientBlocklists, ",") {
+		parts := strings.SplitN(cb, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid client blocklist %q, expected network=path", cb)
+		}
+		rule, err := solvere.ParseClientRule(parts[0] + "=allow_recursion")
+		if err != nil {
+			return nil, err
+		}
+		specialUse, err := blockedDomains(parts[1])
+		if err != nil {
+			return nil, err
+		}
+		rule.Action = ac.Match(rule.Network.IP).Action
+		rule.Resolver = rr.With()
+		rule.Resolver.SpecialUseDomains = specialUse
+		rules = append(rules, rule)
+	}
+	ac.Rules = append(append([]solvere.ClientRule(nil), c.acl...), rules...)
+	return ac, nil
+}
+
+// parseTSIGKey parses a TSIG key in the form name:base64 secret
+func parseTSIGKey(s string) (*solvere.TSIGKey, error) {
+	parts := strings.SplitN(s, ":", 2)
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("expected name:secret")
+	}
+	if _, err := base64.StdEncoding.DecodeString(parts[1]); err != nil {
+		return nil, fmt.Errorf("secret isn't base64 encoded: %w", err)
+	}
+	return &solvere.TSIGKey{Name: parts[0], Secret: parts[1]}, nil
+}
+
+// loadBlocklist reads a file containing a name per line, lines starting with #
+// are ignored
+func loadBlocklist(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var names []string
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if _, ok := dns.IsDomainName(line); !ok {
+			return nil, fmt.Errorf("invalid name %q", line)
+		}
+		names = append(names, strings.ToLower(dns.Fqdn(line)))
+	}
+	return names, s.Err()
+}
+
+// loadTrustAnchors reads the root DNSKEY records from a master file
+func loadTrustAnchors(path string) ([]dns.RR, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var keys []dns.RR
+	for t := range dns.ParseZone(f, ".", path) {
+		// the channel has to be drained even after an error
+		if t.Error != nil {
+			if err == nil {
+				err = t.Error
+			}
+			continue
+		}
+		if k, ok := t.RR.(*dns.DNSKEY); ok && k.Hdr.Name == "." {
+			keys = append(keys, k)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no root DNSKEY records")
+	}
+	return keys, nil
+}
+
+// reloader applies the configuration to the base resolver and switches the
+// handler to the result
+type reloader struct {
+	base    *solvere.RecursiveResolver
+	conf    *config
+	handler *solvere.Handler
+}
+
+func (r *reloader) reload() error {
+	rr, ac, err := r.conf.apply(r.base)
+	if err != nil {
+		return err
+	}
+	r.handler.SetAccessControl(ac)
+	r.handler.SetResolver(rr)
+	return nil
+}
+
+// reloadRunning reloads the configuration once solvd is serving, telling systemd
+// while it does so. The previous configuration stays in use if the reload fails
+// so solvd is ready again either way.
+func (r *reloader) reloadRunning() error {
+	solvere.SystemdNotify(solvere.SystemdReloading)
+	defer solvere.SystemdNotify(solvere.SystemdReady)
+	return r.reload()
+}
+
+// ServeHTTP reloads the configuration on POST /reload
+func (r *reloader) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.URL.Path != "/reload" {
+		http.NotFound(w, req)
+		return
+	}
+	if req.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := r.reloadRunning(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	fmt.Fprintln(w, "reloaded")
+}
+
+// controlCommands returns the solvd specific commands accepted on the control
+// socket
+func controlCommands(r *reloader, level *slog.LevelVar) map[string]solvere.ControlCommand {
+	return map[string]solvere.ControlCommand{
+		"reload": func([]string) (string, error) {
+			if err := r.reloadRunning(); err != nil {
+				return "", err
+			}
+			return "reloaded", nil
+		},
+		"verbosity": func(args []string) (string, error) {
+			if len(args) > 1 {
+				return "", fmt.Errorf("usage: verbosity [debug|info|warn|error]")
+			}
+			if len(args) == 1 {
+				if err := level.UnmarshalText([]byte(args[0])); err != nil {
+					return "", fmt.Errorf("unknown log level %q", args[0])
+				}
+			}
+			return strings.ToLower(level.Level().String()), nil
+		},
+	}
+}
@@ -0,0 +1,354 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"github.com/rolandshoemaker/solvere"
+)
+
+// tracer prints every upstream response as it is received, similar to dig +trace,
+// and collects the DNSKEY and DS records seen so the DNSSEC chain can be printed
+type tracer struct {
+	mu    sync.Mutex
+	sent  map[*dns.Msg]time.Time
+	print bool
+
+	zones []string
+	keys  map[string][]dns.RR
+	ds    map[string][]dns.RR
+}
+
+func newTracer(print bool) *tracer {
+	return &tracer{
+		sent:  make(map[*dns.Msg]time.Time),
+		print: print,
+		keys:  make(map[string][]dns.RR),
+		ds:    make(map[string][]dns.RR),
+	}
+}
+
+func (t *tracer) addZone(zone string) {
+	if _, present := t.keys[zone]; present {
+		return
+	}
+	if _, present := t.ds[zone]; present {
+		return
+	}
+	t.zones = append(t.zones, zone)
+}
+
+func (t *tracer) hooks() solvere.Hooks {
+	return solvere.Hooks{
+		OnUpstreamSend: func(_ context.Context, m *dns.Msg, _ *solvere.Nameserver) (*dns.Msg, error) {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			t.sent[m] = time.Now()
+			return nil, nil
+		},
+		OnUpstreamReceive: func(_ context.Context, m *dns.Msg, ns *solvere.Nameserver, r *dns.Msg) error {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			rtt := time.Since(t.sent[m])
+			delete(t.sent, m)
+			for _, rr := range r.Answer {
+				if rr.Header().Rrtype == dns.TypeDNSKEY {
+					t.addZone(rr.Header().Name)
+					t.keys[rr.Header().Name] = append(t.keys[rr.Header().Name], rr)
+				}
+			}
+			for _, rr := range r.Ns {
+				if rr.Header().Rrtype == dns.TypeDS {
+					t.addZone(rr.Header().Name)
+					t.ds[rr.Header().Name] = append(t.ds[rr.Header().Name], rr)
+				}
+			}
+			if !t.print {
+				return nil
+			}
+			for _, section := range [][]dns.RR{r.Answer, r.Ns} {
+				for _, rr := range section {
+					fmt.Println(rr)
+				}
+			}
+			fmt.Printf(";; Received %d bytes from %s#53(%s) for %s in %s (zone %s)\n\n", r.Len(), ns.Addr, ns.Name, m.Question[0].Name, rtt, ns.Zone)
+			return nil
+		},
+	}
+}
+
+func (t *tracer) printChain() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	fmt.Println(";; DNSSEC CHAIN:")
+	for _, zone := range t.zones {
+		for _, ds := range t.ds[zone] {
+			d := ds.(*dns.DS)
+			fmt.Printf("%s\tDS\tkey tag %d, algorithm %s, digest type %d\n", zone, d.KeyTag, dns.AlgorithmToString[d.Algorithm], d.DigestType)
+		}
+		for _, key := range t.keys[zone] {
+			k := key.(*dns.DNSKEY)
+			kind := "ZSK"
+			if k.Flags&dns.SEP != 0 {
+				kind = "KSK"
+			}
+			fmt.Printf("%s\tDNSKEY\tkey tag %d, algorithm %s, %s\n", zone, k.KeyTag(), dns.AlgorithmToString[k.Algorithm], kind)
+		}
+	}
+	fmt.Println()
+}
+
+func printSection(name string, section []dns.RR) {
+	section = filterOPT(section)
+	if len(section) == 0 {
+		return
+	}
+	fmt.Printf(";; %s SECTION:\n", name)
+	for _, rr := range section {
+		fmt.Println(rr)
+	}
+	fmt.Println()
+}
+
+func filterOPT(section []dns.RR) []dns.RR {
+	out := []dns.RR{}
+	for _, rr := range section {
+		if rr.Header().Rrtype != dns.TypeOPT {
+			out = append(out, rr)
+		}
+	}
+	return out
+}
+
+func rrStrings(section []dns.RR) []string {
+	out := []string{}
+	for _, rr := range filterOPT(section) {
+		out = append(out, rr.String())
+	}
+	return out
+}
+
+type jsonResult struct {
+	Question       solvere.Question
+	Rcode          string
+	Authenticated  bool
+	Answer         []string
+	Authority      []string
+	Additional     []string
+	ExtendedErrors []solvere.ExtendedError `json:",omitempty"`
+	Error          string                  `json:",omitempty"`
+	Log            *solvere.LookupLog
+}
+
+func main() {
+	qtype := flag.String("type", "A", "Type of record to look up, may also be passed after the name")
+	trace := flag.Bool("trace", false, "Print each response received while iterating from the root")
+	verbose := flag.Bool("verbose", false, "Print every query sent, response received, and validation decision made during the lookup")
+	chain := flag.Bool("chain", false, "Print the DNSKEY and DS records that make up the DNSSEC chain")
+	jsonOutput := flag.Bool("json", false, "Print the answer and lookup log as JSON")
+	dotOutput := flag.Bool("dot", false, "Print the lookup log as a Graphviz DOT graph")
+	transport := flag.String("transport", "udp", "Transport used to query nameservers, one of udp, tcp, or tcp-tls, which can only be used with -forward")
+	forward := flag.String("forward", "", "Comma separated list of forwarders to send the query to instead of iterating from the root")
+	useIPv6 := flag.Bool("ipv6", false, "Query nameservers over IPv6 as well as IPv4")
+	family := flag.String("family", "any", "Address family preferred when a nameserver has both with -ipv6, one of any, ipv6, ipv4, or interleave")
+	useDNSSEC := flag.Bool("dnssec", true, "Request DNSSEC records from nameservers")
+	noValidation := flag.Bool("cd", false, "Skip DNSSEC validation and show the records as returned by the nameservers")
+	strictIDNA := flag.Bool("strictIDNA", false, "Reject Unicode names containing characters disallowed by IDNA2008 instead of lower casing them")
+	timeout := flag.Duration("timeout", 30*time.Second, "Maximum time to spend on the lookup")
+	record := flag.String("record", "", "Write every exchange with a nameserver to this file so the lookup can be replayed")
+	replay := flag.String("replay", "", "Answer queries using the exchanges recorded in this file instead of the network")
+	search := flag.Bool("search", false, "Qualify names without a trailing dot using the search list and ndots option of /etc/resolv.conf, like a stub resolver")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s [flags] name [type]\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() < 1 || flag.NArg() > 2 {
+		flag.Usage()
+		os.Exit(1)
+	}
+	if flag.NArg() == 2 {
+		*qtype = flag.Arg(1)
+	}
+	t, present := dns.StringToType[strings.ToUpper(*qtype)]
+	if !present {
+		fmt.Fprintf(os.Stderr, "Unknown record type %q\n", *qtype)
+		os.Exit(1)
+	}
+	switch *transport {
+	case "udp", "tcp":
+	case "tcp-tls":
+		// authoritative nameservers don't offer DNS over TLS
+		if *forward == "" {
+			fmt.Fprintln(os.Stderr, "-transport tcp-tls can only be used with -forward")
+			os.Exit(1)
+		}
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown transport %q\n", *transport)
+		os.Exit(1)
+	}
+	families := map[string]solvere.AddressFamilyPolicy{
+		"any":        solvere.AddressFamilyAny,
+		"ipv6":       solvere.AddressFamilyPreferIPv6,
+		"ipv4":       solvere.AddressFamilyPreferIPv4,
+		"interleave": solvere.AddressFamilyInterleave,
+	}
+	policy, present := families[*family]
+	if !present {
+		fmt.Fprintf(os.Stderr, "Unknown address family policy %q\n", *family)
+		os.Exit(1)
+	}
+	q := solvere.Question{Name: dns.Fqdn(flag.Arg(0)), Type: t}
+
+	rr := solvere.NewResolver(solvere.WithIPv6(*useIPv6), solvere.WithValidation(*useDNSSEC), solvere.WithCache(solvere.NewBasicCache()))
+	rr.Transport = solvere.NewClientTransport(*transport)
+	if *forward != "" {
+		servers := strings.Split(*forward, ",")
+		for i, addr := range servers {
+			if _, _, err := net.SplitHostPort(addr); err != nil && *transport == "tcp-tls" {
+				// use the DNS over TLS port (RFC 7858) rather than port 53
+				servers[i] = net.JoinHostPort(addr, "853")
+			}
+		}
+		rr.Forward = &solvere.ForwardConfig{Servers: servers}
+	}
+	rr.StrictIDNA = *strictIDNA
+	rr.AddressFamily = policy
+	tr := newTracer(*trace && !*jsonOutput && !*dotOutput)
+	rr.AddHooks(tr.hooks())
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+	var recorder *solvere.RecordingTransport
+	switch {
+	case *record != "" && *replay != "":
+		fmt.Fprintln(os.Stderr, "-record and -replay can't be used together")
+		os.Exit(1)
+	case *record != "":
+		f, err := os.Create(*record)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to create %s: %s\n", *record, err)
+			os.Exit(1)
+		}
+		defer f.Close()
+		recorder = solvere.NewRecordingTransport(rr.Transport, f)
+		rr.Transport = recorder
+	case *replay != "":
+		rt, err := solvere.LoadReplayTransport(*replay)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to load %s: %s\n", *replay, err)
+			os.Exit(1)
+		}
+		rr.Transport = rt
+		// validate the signatures as they were when the lookup was recorded
+		ctx = solvere.WithValidationTime(ctx, rt.Recorded())
+	}
+	if *noValidation {
+		ctx = solvere.WithoutValidation(ctx)
+	}
+	var vt *solvere.Trace
+	if *verbose {
+		ctx, vt = solvere.WithTrace(ctx)
+	}
+	var a *solvere.Answer
+	var log *solvere.LookupLog
+	var err error
+	if *search {
+		rc, rcErr := solvere.LoadResolvConf(solvere.DefaultResolvConfPath)
+		if rcErr != nil {
+			fmt.Fprintf(os.Stderr, "Failed to load %s: %s\n", solvere.DefaultResolvConfPath, rcErr)
+			os.Exit(1)
+		}
+		q, a, log, err = rc.SearchResolver(rr).Lookup(ctx, flag.Arg(0), t)
+	} else {
+		a, log, err = rr.Lookup(ctx, q)
+	}
+	if recorder != nil {
+		if rerr := recorder.Err(); rerr != nil {
+			fmt.Fprintf(os.Stderr, "Failed to record exchanges: %s\n", rerr)
+		}
+	}
+
+	switch {
+	case *jsonOutput:
+		res := jsonResult{Question: q, Log: log}
+		if err != nil {
+			res.Error = err.Error()
+		} else {
+			res.Rcode = dns.RcodeToString[a.Rcode]
+			res.Authenticated = a.Authenticated
+			res.Answer = rrStrings(a.Answer)
+			res.Authority = rrStrings(a.Authority)
+			res.Additional = rrStrings(a.Additional)
+			res.ExtendedErrors = a.ExtendedErrors
+		}
+		j, jerr := json.MarshalIndent(res, "", "  ")
+		if jerr != nil {
+			fmt.Fprintf(os.Stderr, "Failed to encode result: %s\n", jerr)
+			os.Exit(1)
+		}
+		fmt.Println(string(j))
+	case *dotOutput:
+		if log != nil {
+			fmt.Print(log.DOT())
+		}
+	default:
+		if vt != nil {
+			fmt.Print(vt)
+		}
+		if *chain {
+			tr.printChain()
+		}
+		if err != nil {
+			break
+		}
+		flags := []string{}
+		if a.Authenticated {
+			flags = append(flags, "ad")
+		}
+		fmt.Printf(";; status: %s, flags: %s\n", dns.RcodeToString[a.Rcode], strings.Join(flags, " "))
+		for _, ee := range a.ExtendedErrors {
+			fmt.Printf(";; EDE: %s\n", ee)
+		}
+		fmt.Println()
+		fmt.Printf(";; QUESTION SECTION:\n;%s\tIN\t%s\n", q.Name, dns.TypeToString[q.Type])
+		if name := solvere.ToUnicode(q.Name); name != q.Name {
+			fmt.Printf(";; (%s)\n", name)
+		}
+		fmt.Println()
+		printSection("ANSWER", a.Answer)
+		printSection("AUTHORITY", a.Authority)
+		printSection("ADDITIONAL", a.Additional)
+		fmt.Printf(";; Query time: %s\n", log.Latency)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Lookup failed: %s\n", err)
+		var re *solvere.ResolutionError
+		if !*jsonOutput && !*dotOutput && errors.As(err, &re) && re.Server != "" {
+			printIdentity(rr, re.Server)
+		}
+		os.Exit(1)
+	}
+}
+
+// printIdentity prints the identity of the nameserver at addr, which is useful
+// when a failure only happens at some of the instances of an anycast nameserver
+func printIdentity(rr *solvere.RecursiveResolver, addr string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	si, err := rr.IdentifyServer(ctx, addr)
+	if err != nil {
+		return
+	}
+	fmt.Fprintf(os.Stderr, ";; Server %s identifies as id %q, hostname %q, version %q\n", addr, si.ID, si.Hostname, si.Version)
+}
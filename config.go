@@ -0,0 +1,393 @@
+package solvere
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// Config is the declarative configuration of a RecursiveResolver, read and
+// written in a TOML based format by LoadConfig and SaveConfig so embedders and
+// solvd can share configuration files:
+//
+//	dnssec = true
+//	query_timeout = "2s"
+//	trust_anchor_file = "/etc/solvere/root.key"
+//
+//	[cache]
+//	max_entries = 100000
+//
+//	[forward]
+//	servers = ["192.0.2.1:53", "https://dns.example/dns-query"]
+//	race = true
+//
+//	[[stub_zone]]
+//	zone = "corp."
+//	servers = ["10.0.0.53:53"]
+//
+// Fields which aren't set keep the values from DefaultConfig, which match the
+// defaults of NewResolver.
+type Config struct {
+	// IPv6 controls whether the IPv6 addresses of nameservers are used
+	IPv6 bool `config:"ipv6"`
+	// DNSSEC controls whether answers are validated
+	DNSSEC bool `config:"dnssec"`
+	// RootHints, if set, is the path of a master file containing the root
+	// nameservers and their addresses, replacing those in the hints package
+	RootHints string
+	// TrustAnchors are root DNSKEY records in presentation format, and
+	// TrustAnchorFile the path of a master file containing them. If either is
+	// set they replace the root keys in the hints package.
+	TrustAnchors    []string
+	TrustAnchorFile string
+	// LocalData are records in presentation format answered before any
+	// resolution is attempted, see RecursiveResolver.LocalData
+	LocalData []string
+
+	// The remaining fields set the RecursiveResolver field with the same name
+	UDPSize                 uint16 `config:"udp_size"`
+	QueryTimeout            time.Duration
+	LookupTimeout           time.Duration
+	FailureTTL              time.Duration `config:"failure_ttl"`
+	BogusTTL                time.Duration `config:"bogus_ttl"`
+	MinimalResponses        bool
+	PermissiveResponses     bool
+	ParentFallback          bool
+	LazyValidation          bool
+	StrictIDNA              bool `config:"strict_idna"`
+	MaxMemory               int64
+	AllowPrivateNameservers []string
+
+	// Cache, if not nil, configures the BasicCache answers are cached in
+	Cache *CacheConfig
+	// ResponseLimits bounds the responses accepted from remote nameservers
+	ResponseLimits ResponseLimits
+	// Forward, if not nil, configures the forwarders for the root zone
+	Forward *UpstreamConfig
+	// ForwardZones configure the forwarders for other zones
+	ForwardZones []UpstreamConfig `config:"forward_zone"`
+	// StubZones configure zones whose iteration starts at their nameservers
+	StubZones []StubZoneConfig `config:"stub_zone"`
+	// LocalZones configure zones answered authoritatively from master files
+	LocalZones []LocalZoneConfig `config:"local_zone"`
+	// Rewrites map names to other names before they are resolved, see
+	// RecursiveResolver.Rewrites
+	Rewrites []NameRewrite `config:"rewrite"`
+}
+
+// UpstreamConfig configures the forwarders of a zone, see ForwardConfig
+type UpstreamConfig struct {
+	// Zone is the zone forwarded, it is ignored for Config.Forward
+	Zone string
+	// Servers are the addresses of the forwarders, see ForwardConfig.Servers
+	Servers []string
+	// Stamps are sdns:// DNS stamps of forwarders, which are queried after Servers
+	// using the Transport for each stamp, see ServerStamp
+	Stamps      []string
+	Timeout     time.Duration
+	Attempts    int
+	Rotate      bool
+	Race        bool
+	NoRecursion bool
+}
+
+// StubZoneConfig configures a stub zone, see RecursiveResolver.StubZones
+type StubZoneConfig struct {
+	Zone    string
+	Servers []string
+}
+
+// LocalZoneConfig configures a zone loaded from the master file File, see
+// LoadLocalZone
+type LocalZoneConfig struct {
+	Origin string
+	File   string
+}
+
+// DefaultConfig returns the configuration of a resolver created by NewResolver
+// without any options
+func DefaultConfig() *Config {
+	return &Config{DNSSEC: true}
+}
+
+// LoadConfig reads a configuration, the fields it doesn't set have the values from
+// DefaultConfig. Unknown keys are errors.
+func LoadConfig(r io.Reader) (*Config, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	c := DefaultConfig()
+	if err = decodeTOML(string(data), c); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// LoadConfigFile reads the configuration in the file at path, see LoadConfig
+func LoadConfigFile(path string) (*Config, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return LoadConfig(f)
+}
+
+// SaveConfig writes c in the format read by LoadConfig, omitting the fields which
+// have their default values
+func SaveConfig(w io.Writer, c *Config) error {
+	return encodeTOML(w, c, DefaultConfig())
+}
+
+// String returns c in the format written by SaveConfig
+func (c *Config) String() string {
+	var b bytes.Buffer
+	if err := SaveConfig(&b, c); err != nil {
+		return err.Error()
+	}
+	return b.String()
+}
+
+// MarshalJSON encodes the configuration as a JSON object with the same keys as
+// the file format, durations are encoded as strings such as "1m30s"
+func (c *Config) MarshalJSON() ([]byte, error) {
+	return json.Marshal(configJSONValue(reflect.ValueOf(c).Elem()))
+}
+
+// Options returns the options for NewResolver which create the configured
+// resolver, reading the files it refers to. If any forwarders are configured with
+// stamps the options set Transport to a StampTransport, whose Fallback is used for
+// the other nameservers.
+func (c *Config) Options() ([]Option, error) {
+	opts := []Option{WithIPv6(c.IPv6), WithValidation(c.DNSSEC)}
+	if c.RootHints != "" {
+		rootHints, err := loadConfigRecords(c.RootHints)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load %s: %w", c.RootHints, err)
+		}
+		opts = append(opts, WithRootHints(rootHints))
+	}
+	anchors, err := parseConfigRecords(c.TrustAnchors)
+	if err != nil {
+		return nil, err
+	}
+	if c.TrustAnchorFile != "" {
+		keys, err := loadConfigRecords(c.TrustAnchorFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load %s: %w", c.TrustAnchorFile, err)
+		}
+		anchors = append(anchors, keys...)
+	}
+	if len(anchors) > 0 {
+		opts = append(opts, WithTrustAnchors(anchors))
+	}
+	localData, err := parseConfigRecords(c.LocalData)
+	if err != nil {
+		return nil, err
+	}
+	var localZones []*LocalZone
+	for _, lzc := range c.LocalZones {
+		lz, err := LoadLocalZone(lzc.File, lzc.Origin)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load %s: %w", lzc.File, err)
+		}
+		localZones = append(localZones, lz)
+	}
+
+	st := &StampTransport{transports: make(map[string]Transport)}
+	var forward *ForwardConfig
+	if c.Forward != nil {
+		if forward, err = c.Forward.forwardConfig(st); err != nil {
+			return nil, err
+		}
+	}
+	var forwardZones map[string]*ForwardConfig
+	for _, uc := range c.ForwardZones {
+		if err := checkZoneName(uc.Zone); err != nil {
+			return nil, err
+		}
+		fc, err := uc.forwardConfig(st)
+		if err != nil {
+			return nil, err
+		}
+		if forwardZones == nil {
+			forwardZones = make(map[string]*ForwardConfig)
+		}
+		forwardZones[CanonicalName(uc.Zone)] = fc
+	}
+	var stubZones map[string][]string
+	for _, sz := range c.StubZones {
+		if err := checkZoneName(sz.Zone); err != nil {
+			return nil, err
+		}
+		if stubZones == nil {
+			stubZones = make(map[string][]string)
+		}
+		z := CanonicalName(sz.Zone)
+		stubZones[z] = append(stubZones[z], sz.Servers...)
+	}
+
+	if c.Cache != nil {
+		opts = append(opts, WithCache(NewCache(*c.Cache)))
+	}
+	if len(st.transports) > 0 {
+		opts = append(opts, WithTransport(st))
+	}
+	// later changes to c don't affect the options
+	settings := *c
+	opts = append(opts, func(o *options) {
+		o.apply(func(rr *RecursiveResolver) {
+			rr.Forward = forward
+			rr.ForwardZones = forwardZones
+			rr.StubZones = stubZones
+			rr.LocalZones = localZones
+			if len(localData) > 0 {
+				rr.LocalData = NewLocalData(localData)
+			}
+			c := settings
+			rr.UDPSize = c.UDPSize
+			rr.QueryTimeout = c.QueryTimeout
+			rr.LookupTimeout = c.LookupTimeout
+			rr.FailureTTL = c.FailureTTL
+			rr.BogusTTL = c.BogusTTL
+			rr.MinimalResponses = c.MinimalResponses
+			rr.PermissiveResponses = c.PermissiveResponses
+			rr.ParentFallback = c.ParentFallback
+			rr.LazyValidation = c.LazyValidation
+			rr.StrictIDNA = c.StrictIDNA
+			rr.MaxMemory = c.MaxMemory
+			rr.AllowPrivateNameservers = c.AllowPrivateNameservers
+			rr.ResponseLimits = c.ResponseLimits
+			rr.Rewrites = c.Rewrites
+		})
+	})
+	return opts, nil
+}
+
+// forwardConfig returns the ForwardConfig for the upstreams, adding the transports
+// of any stamps to st
+func (uc *UpstreamConfig) forwardConfig(st *StampTransport) (*ForwardConfig, error) {
+	fc := &ForwardConfig{
+		Servers:     append([]string(nil), uc.Servers...),
+		Timeout:     uc.Timeout,
+		Attempts:    uc.Attempts,
+		Rotate:      uc.Rotate,
+		Race:        uc.Race,
+		NoRecursion: uc.NoRecursion,
+	}
+	for _, s := range uc.Stamps {
+		ss, err := ParseStamp(s)
+		if err != nil {
+			return nil, err
+		}
+		server := ss.Server()
+		fc.Servers = append(fc.Servers, server)
+		if ss.Protocol != StampPlain {
+			st.transports[server] = ss.Transport()
+		}
+	}
+	return fc, nil
+}
+
+// parseConfigRecords parses records in presentation format
+func parseConfigRecords(records []string) ([]dns.RR, error) {
+	var parsed []dns.RR
+	for _, s := range records {
+		r, err := dns.NewRR(s)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrConfigSyntax, err)
+		}
+		if r != nil {
+			parsed = append(parsed, r)
+		}
+	}
+	return parsed, nil
+}
+
+// loadConfigRecords reads the records in a master file whose origin is the root
+func loadConfigRecords(path string) ([]dns.RR, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var records []dns.RR
+	for t := range dns.ParseZone(f, ".", path) {
+		// the channel has to be drained even after an error
+		if t.Error != nil {
+			if err == nil {
+				err = t.Error
+			}
+			continue
+		}
+		records = append(records, t.RR)
+	}
+	return records, err
+}
+
+// Config returns the imported options as a Config, so they can be saved in the
+// native format. The ignored directives aren't included.
+func (ic *ImportedConfig) Config() *Config {
+	c := DefaultConfig()
+	if ic.Forward != nil {
+		c.Forward = upstreamConfig("", ic.Forward)
+	}
+	var zones []string
+	for zone := range ic.ForwardZones {
+		zones = append(zones, zone)
+	}
+	sortZones(zones)
+	for _, zone := range zones {
+		c.ForwardZones = append(c.ForwardZones, *upstreamConfig(zone, ic.ForwardZones[zone]))
+	}
+	zones = zones[:0]
+	for zone := range ic.StubZones {
+		zones = append(zones, zone)
+	}
+	sortZones(zones)
+	for _, zone := range zones {
+		c.StubZones = append(c.StubZones, StubZoneConfig{Zone: zone, Servers: ic.StubZones[zone]})
+	}
+	for _, r := range ic.TrustAnchors {
+		c.TrustAnchors = append(c.TrustAnchors, r.String())
+	}
+	for _, r := range ic.LocalData {
+		c.LocalData = append(c.LocalData, r.String())
+	}
+	return c
+}
+
+func upstreamConfig(zone string, fc *ForwardConfig) *UpstreamConfig {
+	return &UpstreamConfig{
+		Zone:        zone,
+		Servers:     fc.Servers,
+		Timeout:     fc.Timeout,
+		Attempts:    fc.Attempts,
+		Rotate:      fc.Rotate,
+		Race:        fc.Race,
+		NoRecursion: fc.NoRecursion,
+	}
+}
+
+// sortZones sorts zone names in canonical order
+func sortZones(zones []string) {
+	sort.Slice(zones, func(i, j int) bool { return CanonicalNameLess(zones[i], zones[j]) })
+}
+
+// checkZoneName returns an error if name isn't a valid domain name
+func checkZoneName(name string) error {
+	if _, ok := dns.IsDomainName(name); !ok || strings.TrimSpace(name) == "" {
+		return fmt.Errorf("%w: invalid zone name %q", ErrConfigSyntax, name)
+	}
+	return nil
+}
@@ -0,0 +1,165 @@
+package solvere
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestConfigRoundTrip(t *testing.T) {
+	if s := DefaultConfig().String(); s != "" {
+		t.Fatalf("Default config wasn't empty: %q", s)
+	}
+
+	c := &Config{
+		IPv6:                    true,
+		DNSSEC:                  false,
+		TrustAnchors:            []string{testRootKey},
+		LocalData:               []string{`printer.lan. 300 IN TXT "laser \"# 2\""`},
+		UDPSize:                 1232,
+		QueryTimeout:            1500 * time.Millisecond,
+		FailureTTL:              -1,
+		MinimalResponses:        true,
+		MaxMemory:               1 << 30,
+		AllowPrivateNameservers: []string{},
+		Cache:                   &CacheConfig{},
+		ResponseLimits:          ResponseLimits{MaxSectionRecords: 100},
+		Forward:                 &UpstreamConfig{Servers: []string{"192.0.2.1:53"}, Race: true, Timeout: time.Second},
+		ForwardZones: []UpstreamConfig{
+			{Zone: "corp.example.", Servers: []string{"10.0.0.1:53"}},
+			{Zone: "lab.example.", Stamps: []string{(&ServerStamp{Protocol: StampDoT, ProviderName: "dot.example"}).String()}},
+		},
+		StubZones:  []StubZoneConfig{{Zone: "stub.example.", Servers: []string{"10.1.0.1:5353"}}},
+		LocalZones: []LocalZoneConfig{{Origin: "home.arpa.", File: "/etc/solvere/home.zone"}},
+	}
+	var b bytes.Buffer
+	if err := SaveConfig(&b, c); err != nil {
+		t.Fatalf("SaveConfig failed: %s", err)
+	}
+	loaded, err := LoadConfig(&b)
+	if err != nil {
+		t.Fatalf("Failed to load saved config: %s\n%s", err, c)
+	}
+	if !reflect.DeepEqual(c, loaded) {
+		t.Fatalf("Config didn't round trip:\n%#v\n%#v\n%s", c, loaded, c)
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	c, err := LoadConfig(strings.NewReader(`
+# resolver options
+query_timeout = "2s"
+failure_ttl = "-1ns"
+allow_private_nameservers = ["corp"]
+
+[cache]
+max_entries = 1_000
+negative_max_ttl = "30s"
+
+[forward]
+servers = [
+    "192.0.2.1:53", # primary
+    "192.0.2.2:53",
+]
+no_recursion = true
+
+[[forward_zone]]
+zone = "Corp.Example"
+servers = ["10.0.0.1:53"]
+
+[[stub_zone]]
+zone = "lab.example."
+servers = ["10.1.0.1:5353"]
+`))
+	if err != nil {
+		t.Fatalf("LoadConfig failed: %s", err)
+	}
+	if !c.DNSSEC || c.QueryTimeout != 2*time.Second || c.Cache == nil || c.Cache.MaxEntries != 1000 || c.Cache.NegativeMaxTTL != 30*time.Second {
+		t.Fatalf("Unexpected config: %#v", c)
+	}
+
+	opts, err := c.Options()
+	if err != nil {
+		t.Fatalf("Options failed: %s", err)
+	}
+	rr := NewResolver(opts...)
+	if rr.cache == nil || !rr.useDNSSEC || rr.QueryTimeout != 2*time.Second || rr.FailureTTL != -1 {
+		t.Fatalf("Options weren't applied")
+	}
+	if rr.Forward == nil || !reflect.DeepEqual(rr.Forward.Servers, []string{"192.0.2.1:53", "192.0.2.2:53"}) || !rr.Forward.NoRecursion {
+		t.Fatalf("Unexpected forwarders: %#v", rr.Forward)
+	}
+	if fc := rr.ForwardZones["corp.example."]; fc == nil || !reflect.DeepEqual(fc.Servers, []string{"10.0.0.1:53"}) {
+		t.Fatalf("Unexpected zone forwarders: %#v", rr.ForwardZones)
+	}
+	if !reflect.DeepEqual(rr.StubZones, map[string][]string{"lab.example.": {"10.1.0.1:5353"}}) {
+		t.Fatalf("Unexpected stub zones: %#v", rr.StubZones)
+	}
+	if !reflect.DeepEqual(rr.AllowPrivateNameservers, []string{"corp"}) {
+		t.Fatalf("Unexpected private nameserver zones: %v", rr.AllowPrivateNameservers)
+	}
+	if rr.Transport != nil {
+		t.Fatalf("Transport set without any stamps: %T", rr.Transport)
+	}
+
+	// stamps are queried using a StampTransport
+	c.Forward.Stamps = []string{(&ServerStamp{Protocol: StampDoT, Addr: "192.0.2.3", ProviderName: "dot.example"}).String()}
+	if opts, err = c.Options(); err != nil {
+		t.Fatalf("Options failed: %s", err)
+	}
+	rr = NewResolver(opts...)
+	if st, ok := rr.Transport.(*StampTransport); !ok || st.transports["192.0.2.3:853"] == nil {
+		t.Fatalf("Unexpected transport: %#v", rr.Transport)
+	}
+	if servers := rr.Forward.Servers; len(servers) != 3 || servers[2] != "192.0.2.3:853" {
+		t.Fatalf("Stamp server wasn't added to forwarders: %v", servers)
+	}
+
+	for _, invalid := range []string{
+		"unknown = true",
+		"dnssec = \"yes\"",
+		"[forward]\ntimeout = 5",
+		"[forward]\n[forward]",
+		"[unknown]",
+		"[[forward]]",
+		"udp_size = 70000",
+	} {
+		if _, err := LoadConfig(strings.NewReader(invalid)); err == nil || !errors.Is(err, ErrConfigSyntax) {
+			t.Fatalf("Expected %q to be rejected, got %v", invalid, err)
+		}
+	}
+	c = DefaultConfig()
+	c.StubZones = []StubZoneConfig{{Zone: "bad..name"}}
+	if _, err = c.Options(); err == nil {
+		t.Fatal("Options accepted an invalid zone name")
+	}
+}
+
+func TestImportedConfigConfig(t *testing.T) {
+	ic, err := ParseBINDConfig(strings.NewReader(`
+options { forwarders { 192.0.2.1; }; };
+zone "b.example" { type forward; forwarders { 10.0.0.2; }; };
+zone "a.example" { type forward; forwarders { 10.0.0.1; }; };
+zone "lab.example" { type stub; masters { 10.1.0.1 port 5353; }; };
+`))
+	if err != nil {
+		t.Fatalf("ParseBINDConfig failed: %s", err)
+	}
+	c := ic.Config()
+	if c.Forward == nil || !reflect.DeepEqual(c.Forward.Servers, []string{"192.0.2.1:53"}) {
+		t.Fatalf("Unexpected forwarders: %#v", c.Forward)
+	}
+	if len(c.ForwardZones) != 2 || c.ForwardZones[0].Zone != "a.example." || c.ForwardZones[1].Zone != "b.example." {
+		t.Fatalf("Unexpected forward zones: %#v", c.ForwardZones)
+	}
+	if len(c.StubZones) != 1 || c.StubZones[0].Zone != "lab.example." || c.StubZones[0].Servers[0] != "10.1.0.1:5353" {
+		t.Fatalf("Unexpected stub zones: %#v", c.StubZones)
+	}
+	loaded, err := LoadConfig(strings.NewReader(c.String()))
+	if err != nil || !reflect.DeepEqual(c, loaded) {
+		t.Fatalf("Imported config didn't round trip: %v\n%s", err, c)
+	}
+}
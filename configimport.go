@@ -0,0 +1,435 @@
+package solvere
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+var ErrConfigSyntax = errors.New("solvere: Malformed configuration")
+
+// ImportedConfig contains the solvere options a Unbound or BIND configuration maps
+// onto, it is intended to make testing a migration to solvere easier rather than
+// being a complete implementation of either format
+type ImportedConfig struct {
+	// Forward contains the forwarders for the root zone
+	Forward *ForwardConfig
+	// ForwardZones contains the forwarders for all other zones
+	ForwardZones map[string]*ForwardConfig
+	// StubZones contains the addresses of the nameservers for stub zones
+	StubZones map[string][]string
+	// TrustAnchors contains the DNSKEY records of the root zone
+	TrustAnchors []dns.RR
+	// LocalData contains records which should be answered locally
+	LocalData []dns.RR
+	// Ignored lists the directives that have no solvere equivalent
+	Ignored []string
+}
+
+func newImportedConfig() *ImportedConfig {
+	return &ImportedConfig{ForwardZones: make(map[string]*ForwardConfig), StubZones: make(map[string][]string)}
+}
+
+func (ic *ImportedConfig) ignore(format string, args ...interface{}) {
+	ic.Ignored = append(ic.Ignored, fmt.Sprintf(format, args...))
+}
+
+func (ic *ImportedConfig) addForwarders(zone string, addrs []string) {
+	if len(addrs) == 0 {
+		return
+	}
+	zone = CanonicalName(zone)
+	if zone == "." {
+		if ic.Forward == nil {
+			ic.Forward = &ForwardConfig{}
+		}
+		ic.Forward.Servers = append(ic.Forward.Servers, addrs...)
+		return
+	}
+	fc, present := ic.ForwardZones[zone]
+	if !present {
+		fc = &ForwardConfig{}
+		ic.ForwardZones[zone] = fc
+	}
+	fc.Servers = append(fc.Servers, addrs...)
+}
+
+// addTrustAnchor adds a trust anchor, only root DNSKEYs can be used
+func (ic *ImportedConfig) addTrustAnchor(r dns.RR) {
+	if key, ok := r.(*dns.DNSKEY); ok && key.Hdr.Name == "." {
+		ic.TrustAnchors = append(ic.TrustAnchors, key)
+		return
+	}
+	ic.ignore("trust anchor: %s", r)
+}
+
+// addTrustAnchorFile adds the trust anchors in a zone file formatted file
+func (ic *ImportedConfig) addTrustAnchorFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	var parseErr error
+	for t := range dns.ParseZone(f, ".", path) {
+		// the channel has to be drained even after an error
+		if t.Error != nil {
+			if parseErr == nil {
+				parseErr = t.Error
+			}
+			continue
+		}
+		if parseErr == nil {
+			ic.addTrustAnchor(t.RR)
+		}
+	}
+	return parseErr
+}
+
+// Apply sets the options on rr, it must be called before rr is used
+func (ic *ImportedConfig) Apply(rr *RecursiveResolver) {
+	if ic.Forward != nil {
+		rr.Forward = ic.Forward
+	}
+	if len(ic.ForwardZones) > 0 {
+		rr.ForwardZones = ic.ForwardZones
+	}
+	if len(ic.StubZones) > 0 {
+		rr.StubZones = ic.StubZones
+	}
+	if len(ic.LocalData) > 0 {
+		rr.LocalData = NewLocalData(ic.LocalData)
+	}
+	if len(ic.TrustAnchors) > 0 {
+		rr.rootKeys = ic.TrustAnchors
+		if rr.cache != nil {
+			rr.cache.Add(&Question{Name: ".", Type: dns.TypeDNSKEY}, &Answer{Answer: rr.rootKeys, Rcode: dns.RcodeSuccess, Authenticated: true}, true)
+		}
+	}
+}
+
+// parseServerAddr converts a address in the form used by Unbound (IP@port#name) or
+// BIND (IP port N) to a host:port pair
+func parseServerAddr(addr, port string) (string, error) {
+	addr = strings.SplitN(addr, "#", 2)[0]
+	if i := strings.LastIndex(addr, "@"); i >= 0 {
+		addr, port = addr[:i], addr[i+1:]
+	}
+	if net.ParseIP(strings.SplitN(addr, "%", 2)[0]) == nil {
+		return "", fmt.Errorf("%w: invalid address %q", ErrConfigSyntax, addr)
+	}
+	if _, err := strconv.ParseUint(port, 10, 16); err != nil {
+		return "", fmt.Errorf("%w: invalid port %q", ErrConfigSyntax, port)
+	}
+	return net.JoinHostPort(addr, port), nil
+}
+
+// ParseUnboundConfig imports the forward-zone, stub-zone, trust-anchor,
+// trust-anchor-file, auto-trust-anchor-file, and local-data directives from a
+// unbound.conf(5) formatted configuration. include directives aren't followed.
+func ParseUnboundConfig(r io.Reader) (*ImportedConfig, error) {
+	ic := newImportedConfig()
+	scanner := bufio.NewScanner(r)
+	var clause, zone string
+	var addrs []string
+	endZone := func() {
+		switch clause {
+		case "forward-zone":
+			ic.addForwarders(zone, addrs)
+		case "stub-zone":
+			if len(addrs) > 0 {
+				z := CanonicalName(zone)
+				ic.StubZones[z] = append(ic.StubZones[z], addrs...)
+			}
+		}
+		zone, addrs = "", nil
+	}
+	for line := 1; scanner.Scan(); line++ {
+		key, value := splitUnboundLine(scanner.Text())
+		if key == "" {
+			continue
+		}
+		if value == "" {
+			// start of a new clause
+			endZone()
+			clause = key
+			continue
+		}
+		var err error
+		switch clause + " " + key {
+		case "forward-zone name", "stub-zone name":
+			zone = value
+		case "forward-zone forward-addr", "stub-zone stub-addr":
+			var addr string
+			if addr, err = parseServerAddr(value, "53"); err == nil {
+				addrs = append(addrs, addr)
+			}
+		case "server trust-anchor":
+			var anchor dns.RR
+			if anchor, err = dns.NewRR(value); err == nil && anchor != nil {
+				ic.addTrustAnchor(anchor)
+			}
+		case "server trust-anchor-file", "server auto-trust-anchor-file":
+			err = ic.addTrustAnchorFile(value)
+		case "server local-data":
+			var record dns.RR
+			if record, err = dns.NewRR(value); err == nil && record != nil {
+				ic.LocalData = append(ic.LocalData, record)
+			}
+		default:
+			ic.ignore("%s: %s: %s", clause, key, value)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	endZone()
+	return ic, nil
+}
+
+// splitUnboundLine splits a line into a key and a unquoted value, removing any
+// comment. Values may be quoted with single or double quotes.
+func splitUnboundLine(line string) (string, string) {
+	var quote byte
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		if quote != 0 {
+			if c == quote {
+				quote = 0
+			}
+		} else if c == '"' || c == '\'' {
+			quote = c
+		} else if c == '#' {
+			line = line[:i]
+		}
+	}
+	i := strings.Index(line, ":")
+	if i < 0 {
+		return "", ""
+	}
+	value := strings.TrimSpace(line[i+1:])
+	if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+		value = value[1 : len(value)-1]
+	}
+	return strings.TrimSpace(line[:i]), value
+}
+
+// bindStatement is a statement in a named.conf(5) configuration, such as
+// zone "example" { ... };
+type bindStatement struct {
+	words []string
+	block []bindStatement
+}
+
+// ParseBINDConfig imports the forwarders, forward and stub zones, and trusted-keys
+// or trust-anchors statements from a named.conf(5) formatted configuration.
+// include statements aren't followed.
+func ParseBINDConfig(r io.Reader) (*ImportedConfig, error) {
+	tokens, err := tokenizeBIND(r)
+	if err != nil {
+		return nil, err
+	}
+	statements, rest, err := parseBINDStatements(tokens)
+	if err != nil {
+		return nil, err
+	}
+	if len(rest) > 0 {
+		return nil, fmt.Errorf("%w: unexpected %q", ErrConfigSyntax, rest[0])
+	}
+	ic := newImportedConfig()
+	for _, s := range statements {
+		switch s.words[0] {
+		case "options":
+			for _, o := range s.block {
+				if o.words[0] == "forwarders" {
+					addrs, err := bindAddrs(o.block)
+					if err != nil {
+						return nil, err
+					}
+					ic.addForwarders(".", addrs)
+				} else {
+					ic.ignore("options: %s", strings.Join(o.words, " "))
+				}
+			}
+		case "zone":
+			if err = ic.bindZone(s); err != nil {
+				return nil, err
+			}
+		case "trusted-keys", "managed-keys", "trust-anchors":
+			for _, k := range s.block {
+				if err = ic.bindTrustAnchor(k.words); err != nil {
+					return nil, err
+				}
+			}
+		default:
+			ic.ignore("%s", strings.Join(s.words, " "))
+		}
+	}
+	return ic, nil
+}
+
+func (ic *ImportedConfig) bindZone(s bindStatement) error {
+	if len(s.words) < 2 {
+		return fmt.Errorf("%w: zone without a name", ErrConfigSyntax)
+	}
+	zone := s.words[1]
+	var zoneType string
+	var servers []bindStatement
+	for _, o := range s.block {
+		switch o.words[0] {
+		case "type":
+			if len(o.words) > 1 {
+				zoneType = o.words[1]
+			}
+		case "forwarders", "masters", "primaries":
+			servers = o.block
+		}
+	}
+	addrs, err := bindAddrs(servers)
+	if err != nil {
+		return err
+	}
+	switch zoneType {
+	case "forward":
+		ic.addForwarders(zone, addrs)
+	case "stub", "static-stub":
+		z := CanonicalName(zone)
+		ic.StubZones[z] = append(ic.StubZones[z], addrs...)
+	default:
+		ic.ignore("zone %s: type %s", zone, zoneType)
+	}
+	return nil
+}
+
+// bindTrustAnchor adds a trusted-keys (name flags protocol algorithm key) or
+// trust-anchors (name initial-key flags protocol algorithm key) entry
+func (ic *ImportedConfig) bindTrustAnchor(words []string) error {
+	if len(words) > 1 {
+		switch words[1] {
+		case "static-key", "initial-key":
+			words = append(words[:1:1], words[2:]...)
+		case "static-ds", "initial-ds":
+			ic.ignore("trust anchor: %s", strings.Join(words, " "))
+			return nil
+		}
+	}
+	if len(words) < 5 {
+		ic.ignore("trust anchor: %s", strings.Join(words, " "))
+		return nil
+	}
+	anchor, err := dns.NewRR(fmt.Sprintf("%s IN DNSKEY %s", dns.Fqdn(words[0]), strings.Join(words[1:], " ")))
+	if err != nil {
+		return err
+	}
+	ic.addTrustAnchor(anchor)
+	return nil
+}
+
+// bindAddrs converts a list of server addresses, optionally followed by a port, to
+// host:port pairs
+func bindAddrs(block []bindStatement) ([]string, error) {
+	var addrs []string
+	for _, s := range block {
+		port := "53"
+		if len(s.words) >= 3 && s.words[1] == "port" {
+			port = s.words[2]
+		}
+		addr, err := parseServerAddr(s.words[0], port)
+		if err != nil {
+			return nil, err
+		}
+		addrs = append(addrs, addr)
+	}
+	return addrs, nil
+}
+
+// tokenizeBIND splits a named.conf(5) configuration into words, quoted strings
+// (without their quotes), and the punctuation {, }, and ;
+func tokenizeBIND(r io.Reader) ([]string, error) {
+	raw, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	data := string(raw)
+	var tokens []string
+	for i := 0; i < len(data); {
+		c := data[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '#' || (c == '/' && i+1 < len(data) && data[i+1] == '/'):
+			for i < len(data) && data[i] != '\n' {
+				i++
+			}
+		case c == '/' && i+1 < len(data) && data[i+1] == '*':
+			end := strings.Index(data[i+2:], "*/")
+			if end < 0 {
+				return nil, fmt.Errorf("%w: unterminated comment", ErrConfigSyntax)
+			}
+			i += end + 4
+		case c == '"':
+			end := strings.IndexByte(data[i+1:], '"')
+			if end < 0 {
+				return nil, fmt.Errorf("%w: unterminated string", ErrConfigSyntax)
+			}
+			tokens = append(tokens, data[i+1:i+1+end])
+			i += end + 2
+		case c == '{' || c == '}' || c == ';':
+			tokens = append(tokens, string(c))
+			i++
+		default:
+			start := i
+			for i < len(data) && !strings.ContainsRune(" \t\r\n{};\"", rune(data[i])) {
+				i++
+			}
+			tokens = append(tokens, data[start:i])
+		}
+	}
+	return tokens, nil
+}
+
+// parseBINDStatements parses statements from tokens until a closing brace or the
+// end of the tokens, returning the unparsed tokens
+func parseBINDStatements(tokens []string) ([]bindStatement, []string, error) {
+	var statements []bindStatement
+	for len(tokens) > 0 && tokens[0] != "}" {
+		var s bindStatement
+		for len(tokens) > 0 && tokens[0] != ";" && tokens[0] != "{" && tokens[0] != "}" {
+			s.words = append(s.words, tokens[0])
+			tokens = tokens[1:]
+		}
+		if len(tokens) > 0 && tokens[0] == "{" {
+			var err error
+			s.block, tokens, err = parseBINDStatements(tokens[1:])
+			if err != nil {
+				return nil, nil, err
+			}
+			if len(tokens) == 0 {
+				return nil, nil, fmt.Errorf("%w: unterminated block", ErrConfigSyntax)
+			}
+			// consume the closing brace, and any words following it
+			for tokens = tokens[1:]; len(tokens) > 0 && tokens[0] != ";" && tokens[0] != "}" && tokens[0] != "{"; tokens = tokens[1:] {
+				s.words = append(s.words, tokens[0])
+			}
+		}
+		if len(tokens) == 0 || tokens[0] != ";" {
+			return nil, nil, fmt.Errorf("%w: missing semicolon after %q", ErrConfigSyntax, strings.Join(s.words, " "))
+		}
+		tokens = tokens[1:]
+		if len(s.words) > 0 {
+			statements = append(statements, s)
+		}
+	}
+	return statements, tokens, nil
+}
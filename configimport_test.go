@@ -0,0 +1,146 @@
+package solvere
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+const testRootKey = ". 172800 IN DNSKEY 257 3 8 AwEAAaz/tAm8yTn4Mfeh5eyI96WSVexTBAvkMgJzkKTOiW1vkIbzxeF3+/4RgWOq7HrxRixHlFlExOLAJr5emLvN7SWXgnLh4+B5xQlNVz8Og8kvArMtNROxVQuCaSnIDdD5LKyWbRd2n9WGe2R8PzgCmr3EgVLrjyBxWezF0jLHwVN8efS3rCj/EWgvIWgb9tarpVUDK/b58Da+sqqls3eNbuv7pr+eoZG+SrDK6nWeL3c6H5Apxz7LjVc1uTIdsIXxuOLYA4/ilBmSVIzuDWfdRUfhHdY6+cn8HFRm+2hM8AnXGXws9555KrUB5qihylGa8subX2Nn6UwNR1AkUTV74bU="
+
+func TestParseUnboundConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "solvere")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	anchorFile := filepath.Join(dir, "root.key")
+	if err = ioutil.WriteFile(anchorFile, []byte("; autotrust anchor file\n"+testRootKey+" ;{id = 20326 (ksk), size = 2048b}\n"), 0644); err != nil {
+		t.Fatalf("Failed to write anchor file: %s", err)
+	}
+
+	ic, err := ParseUnboundConfig(strings.NewReader(`
+server:
+	interface: 127.0.0.1 # listen locally
+	auto-trust-anchor-file: "` + anchorFile + `"
+	local-data: "printer.lan. 300 IN A 192.168.1.5"
+	local-data: 'printer.lan. 300 IN TXT "laser # 2"'
+
+forward-zone:
+	name: "."
+	forward-addr: 192.0.2.1
+	forward-addr: 2001:db8::1@853#dns.example
+
+forward-zone:
+	name: "Corp.Example"
+	forward-addr: 10.0.0.1
+
+stub-zone:
+	name: "lab.example."
+	stub-addr: 10.1.0.1@5353
+`))
+	if err != nil {
+		t.Fatalf("ParseUnboundConfig failed: %s", err)
+	}
+	if ic.Forward == nil || !reflect.DeepEqual(ic.Forward.Servers, []string{"192.0.2.1:53", "[2001:db8::1]:853"}) {
+		t.Fatalf("Unexpected root forwarders: %#v", ic.Forward)
+	}
+	if fc := ic.ForwardZones["corp.example."]; fc == nil || !reflect.DeepEqual(fc.Servers, []string{"10.0.0.1:53"}) {
+		t.Fatalf("Unexpected zone forwarders: %#v", ic.ForwardZones)
+	}
+	if !reflect.DeepEqual(ic.StubZones, map[string][]string{"lab.example.": {"10.1.0.1:5353"}}) {
+		t.Fatalf("Unexpected stub zones: %#v", ic.StubZones)
+	}
+	if len(ic.TrustAnchors) != 1 || ic.TrustAnchors[0].(*dns.DNSKEY).KeyTag() != 20326 {
+		t.Fatalf("Unexpected trust anchors: %v", ic.TrustAnchors)
+	}
+	if len(ic.LocalData) != 2 || ic.LocalData[1].(*dns.TXT).Txt[0] != "laser # 2" {
+		t.Fatalf("Unexpected local data: %v", ic.LocalData)
+	}
+	if !reflect.DeepEqual(ic.Ignored, []string{"server: interface: 127.0.0.1"}) {
+		t.Fatalf("Unexpected ignored directives: %v", ic.Ignored)
+	}
+
+	if _, err = ParseUnboundConfig(strings.NewReader("forward-zone:\n\tname: .\n\tforward-addr: dns.example\n")); err == nil {
+		t.Fatal("ParseUnboundConfig didn't fail with a host name forward-addr")
+	}
+}
+
+func TestParseBINDConfig(t *testing.T) {
+	ic, err := ParseBINDConfig(strings.NewReader(`
+// upstreams
+options {
+	directory "/var/named";
+	forwarders { 192.0.2.1; 192.0.2.2 port 5353; };
+	forward only;
+};
+
+/* internal zones */
+zone "corp.example" IN {
+	type forward;
+	forwarders { 10.0.0.1; };
+};
+
+zone "lab.example" {
+	type stub;
+	masters { 10.1.0.1; };
+};
+
+zone "." { type hint; file "named.ca"; };
+
+trust-anchors {
+	. initial-key 257 3 8 "` + strings.Fields(testRootKey)[7] + `";
+	. initial-ds 20326 8 2 "E06D44B80B8F1D39A95C0B0D7C65D08458E880409BBC683457104237C7F8EC8D";
+};
+`))
+	if err != nil {
+		t.Fatalf("ParseBINDConfig failed: %s", err)
+	}
+	if ic.Forward == nil || !reflect.DeepEqual(ic.Forward.Servers, []string{"192.0.2.1:53", "192.0.2.2:5353"}) {
+		t.Fatalf("Unexpected root forwarders: %#v", ic.Forward)
+	}
+	if fc := ic.ForwardZones["corp.example."]; fc == nil || !reflect.DeepEqual(fc.Servers, []string{"10.0.0.1:53"}) {
+		t.Fatalf("Unexpected zone forwarders: %#v", ic.ForwardZones)
+	}
+	if !reflect.DeepEqual(ic.StubZones, map[string][]string{"lab.example.": {"10.1.0.1:53"}}) {
+		t.Fatalf("Unexpected stub zones: %#v", ic.StubZones)
+	}
+	if len(ic.TrustAnchors) != 1 || ic.TrustAnchors[0].(*dns.DNSKEY).KeyTag() != 20326 {
+		t.Fatalf("Unexpected trust anchors: %v", ic.TrustAnchors)
+	}
+	if len(ic.Ignored) != 4 {
+		t.Fatalf("Unexpected ignored directives: %v", ic.Ignored)
+	}
+
+	for _, bad := range []string{
+		`options { forwarders { 192.0.2.1; } };`,
+		`options { forwarders { 192.0.2.1; };`,
+		`zone "a" { type forward; forwarders { not-an-ip; }; };`,
+		`/* unterminated`,
+	} {
+		if _, err = ParseBINDConfig(strings.NewReader(bad)); err == nil {
+			t.Errorf("ParseBINDConfig didn't fail with %q", bad)
+		}
+	}
+}
+
+func TestImportedConfigApply(t *testing.T) {
+	rr := NewRecursiveResolver(false, true, nil, nil, NewBasicCache())
+	ic := newImportedConfig()
+	ic.addForwarders(".", []string{"192.0.2.1:53"})
+	key, _ := dns.NewRR(testRootKey)
+	ic.addTrustAnchor(key)
+	ic.LocalData = []dns.RR{&dns.A{Hdr: dns.RR_Header{Name: "a.lan.", Rrtype: dns.TypeA, Class: dns.ClassINET}}}
+	ic.Apply(rr)
+	if rr.Forward != ic.Forward || rr.LocalData == nil || len(rr.rootKeys) != 1 || rr.ForwardZones != nil {
+		t.Fatal("Apply didn't set the expected options")
+	}
+	if a := rr.cache.Get(&Question{Name: ".", Type: dns.TypeDNSKEY}); a == nil || len(a.Answer) != 1 {
+		t.Fatal("Apply didn't cache the root keys")
+	}
+}
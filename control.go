@@ -0,0 +1,301 @@
+package solvere
+
+import (
+	"bufio"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrControlCommand is returned by ControlRequest when the server failed to run
+// a command
+var ErrControlCommand = errors.New("solvere: Control command failed")
+
+// DefaultControlTimeout is how long a ControlServer waits for a client to send
+// its request, and for the response to be read
+var DefaultControlTimeout = 10 * time.Second
+
+// maxControlRequest is the maximum length of the secret and command sent by a
+// control client
+const maxControlRequest = 4096
+
+// ControlCommand runs a command sent to a ControlServer, args are the words that
+// followed the command name. The returned output is sent to the client.
+type ControlCommand func(args []string) (string, error)
+
+// ControlServer is a local control channel for a running resolver, similar to
+// unbound-control or rndc. Each connection sends a single command on one line,
+// with its arguments separated by spaces, and receives a response starting with
+// a line containing either "ok" or "error: " and a description of the failure,
+// followed by the output of the command.
+//
+// The built-in commands are
+//
+//	flush <name>       remove everything cached about name
+//	flush_zone <name>  remove everything cached about name and the names below it
+//	stats              print the resolver's Stats as JSON
+//	help               list the available commands
+//
+// Additional commands, such as reloading configuration, can be added with
+// Commands.
+type ControlServer struct {
+	// Resolver is the resolver flushed and whose statistics are reported, it
+	// must be set
+	Resolver *RecursiveResolver
+	// Secret, if not empty, must be sent by clients on the line before their
+	// command. On Linux only clients running as root or the same user as the
+	// server are accepted, elsewhere anyone who can connect to the socket can
+	// control the resolver so it should be used if the socket isn't in a
+	// directory only accessible by trusted users.
+	Secret string
+	// Commands are run when their name is sent, in addition to the built-in
+	// commands which they replace if they have the same name. It must not be
+	// modified once the server is in use.
+	Commands map[string]ControlCommand
+	// Timeout is how long clients have to send their request, and read the
+	// response, if zero DefaultControlTimeout is used
+	Timeout time.Duration
+
+	mu        sync.Mutex
+	closed    bool
+	listeners []net.Listener
+}
+
+// NewControlServer returns a ControlServer for rr
+func NewControlServer(rr *RecursiveResolver) *ControlServer {
+	return &ControlServer{Resolver: rr}
+}
+
+// ListenAndServe listens on the unix socket path, which is only accessible by the
+// user running the process, and serves commands until Close is called. A socket
+// left at path by a previous process is replaced.
+func (cs *ControlServer) ListenAndServe(path string) error {
+	if fi, err := os.Lstat(path); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		os.Remove(path)
+	}
+	l, err := listenPrivateUnix(path)
+	if err != nil {
+		return err
+	}
+	return cs.Serve(l)
+}
+
+// privateUnixListener removes the socket it was moved to when it is closed
+type privateUnixListener struct {
+	net.Listener
+	path string
+}
+
+func (pl *privateUnixListener) Close() error {
+	err := pl.Listener.Close()
+	os.Remove(pl.path)
+	return err
+}
+
+// listenPrivateUnix listens on the unix socket path, with permissions restricting
+// it to the user running the process. The socket is created in a temporary
+// directory only that user can access, since it would be created with the
+// permissions allowed by the umask, and only once its permissions are restricted
+// is it moved to path.
+func listenPrivateUnix(path string) (net.Listener, error) {
+	dir, err := ioutil.TempDir(filepath.Dir(path), ".control")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	tmp := filepath.Join(dir, "sock")
+	l, err := net.Listen("unix", tmp)
+	if err != nil {
+		return nil, err
+	}
+	l.(*net.UnixListener).SetUnlinkOnClose(false)
+	if err := os.Chmod(tmp, 0600); err != nil {
+		l.Close()
+		return nil, err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		l.Close()
+		return nil, err
+	}
+	return &privateUnixListener{Listener: l, path: path}, nil
+}
+
+// Serve serves commands sent to connections accepted from l until Close is
+// called or l fails. Serve takes ownership of l.
+func (cs *ControlServer) Serve(l net.Listener) error {
+	cs.mu.Lock()
+	if cs.closed {
+		cs.mu.Unlock()
+		l.Close()
+		return ErrServerClosed
+	}
+	cs.listeners = append(cs.listeners, l)
+	cs.mu.Unlock()
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			cs.mu.Lock()
+			defer cs.mu.Unlock()
+			if cs.closed {
+				return ErrServerClosed
+			}
+			return err
+		}
+		go cs.serveConn(conn)
+	}
+}
+
+// Close stops the server from accepting new connections, commands that are
+// already running are allowed to finish
+func (cs *ControlServer) Close() error {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	cs.closed = true
+	var err error
+	for _, l := range cs.listeners {
+		if lerr := l.Close(); lerr != nil && err == nil {
+			err = lerr
+		}
+	}
+	cs.listeners = nil
+	return err
+}
+
+func (cs *ControlServer) timeout() time.Duration {
+	if cs.Timeout > 0 {
+		return cs.Timeout
+	}
+	return DefaultControlTimeout
+}
+
+// serveConn reads the request sent to conn, runs the command, and writes the
+// response
+func (cs *ControlServer) serveConn(conn net.Conn) {
+	defer conn.Close()
+	if err := checkPeerCredentials(conn); err != nil {
+		cs.Resolver.log(LogWarn, "rejected control connection", "err", err)
+		fmt.Fprintf(conn, "error: %s\n", err)
+		return
+	}
+	conn.SetDeadline(time.Now().Add(cs.timeout()))
+	r := bufio.NewReader(io.LimitReader(conn, maxControlRequest))
+	if cs.Secret != "" {
+		secret, err := readControlLine(r)
+		if err != nil {
+			return
+		}
+		if subtle.ConstantTimeCompare([]byte(secret), []byte(cs.Secret)) != 1 {
+			cs.Resolver.log(LogWarn, "rejected control connection with invalid secret")
+			fmt.Fprintln(conn, "error: invalid secret")
+			return
+		}
+	}
+	line, err := readControlLine(r)
+	if err != nil {
+		return
+	}
+	// commands such as reloads can take longer than the client is given to
+	// send them
+	conn.SetDeadline(time.Time{})
+	output, err := cs.run(strings.Fields(line))
+	conn.SetDeadline(time.Now().Add(cs.timeout()))
+	if err != nil {
+		fmt.Fprintf(conn, "error: %s\n", err)
+		return
+	}
+	if output != "" && !strings.HasSuffix(output, "\n") {
+		output += "\n"
+	}
+	io.WriteString(conn, "ok\n"+output)
+}
+
+// readControlLine reads a line sent by a control client, without its line ending
+func readControlLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil && (err != io.EOF || line == "") {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// run runs the command named by the first of words
+func (cs *ControlServer) run(words []string) (string, error) {
+	if len(words) == 0 {
+		return "", errors.New("no command")
+	}
+	name, args := words[0], words[1:]
+	cs.Resolver.log(LogInfo, "running control command", "command", name)
+	if cmd, present := cs.Commands[name]; present {
+		return cmd(args)
+	}
+	switch name {
+	case "flush", "flush_zone":
+		if len(args) != 1 {
+			return "", fmt.Errorf("usage: %s <name>", name)
+		}
+		n := cs.Resolver.Flush(args[0], name == "flush_zone")
+		return fmt.Sprintf("flushed %d entries", n), nil
+	case "stats":
+		j, err := json.MarshalIndent(cs.Resolver.Stats(), "", "  ")
+		if err != nil {
+			return "", err
+		}
+		return string(j), nil
+	case "help":
+		available := map[string]bool{"flush": true, "flush_zone": true, "stats": true, "help": true}
+		for name := range cs.Commands {
+			available[name] = true
+		}
+		var names []string
+		for name := range available {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return strings.Join(names, "\n"), nil
+	}
+	return "", fmt.Errorf("unknown command %q", name)
+}
+
+// ControlRequest sends a command, made up of the name and its arguments, to the
+// ControlServer listening on the unix socket path and returns its output. If
+// the server requires a secret it must be passed, otherwise secret should be
+// empty. If the command fails an error wrapping ErrControlCommand is returned.
+func ControlRequest(path, secret string, command ...string) (string, error) {
+	conn, err := net.DialTimeout("unix", path, DefaultControlTimeout)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	request := strings.Join(command, " ") + "\n"
+	if secret != "" {
+		request = secret + "\n" + request
+	}
+	if _, err := io.WriteString(conn, request); err != nil {
+		return "", err
+	}
+	response, err := ioutil.ReadAll(conn)
+	if err != nil {
+		return "", err
+	}
+	status, output := string(response), ""
+	if i := strings.IndexByte(status, '\n'); i >= 0 {
+		status, output = status[:i], status[i+1:]
+	}
+	switch {
+	case status == "ok":
+		return output, nil
+	case strings.HasPrefix(status, "error: "):
+		return "", fmt.Errorf("%w: %s", ErrControlCommand, strings.TrimPrefix(status, "error: "))
+	}
+	return "", fmt.Errorf("%w: malformed response %q", ErrControlCommand, status)
+}
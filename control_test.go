@@ -0,0 +1,97 @@
+package solvere
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func TestControlServer(t *testing.T) {
+	dir, err := ioutil.TempDir("", "solvere-control")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "control.sock")
+
+	rr := NewResolver(WithCache(NewBasicCache()))
+	answer := &Answer{Answer: []dns.RR{&dns.A{Hdr: dns.RR_Header{Ttl: 60}, A: net.IP{1, 2, 3, 4}}}}
+	rr.cache.Add(&Question{Name: "a.example.", Type: dns.TypeA}, answer, false)
+	cs := NewControlServer(rr)
+	cs.Secret = "secret"
+	cs.Commands = map[string]ControlCommand{
+		"echo": func(args []string) (string, error) { return strings.Join(args, " "), nil },
+	}
+	served := make(chan error, 1)
+	go func() { served <- cs.ListenAndServe(path) }()
+	// the socket is usable once its permissions have been restricted
+	for i := 0; ; i++ {
+		if fi, err := os.Stat(path); err == nil && fi.Mode().Perm() == 0600 {
+			break
+		}
+		if i == 1000 {
+			t.Fatal("Control socket wasn't created")
+		}
+		select {
+		case err := <-served:
+			t.Fatalf("ListenAndServe failed: %s", err)
+		case <-time.After(time.Millisecond):
+		}
+	}
+
+	if out, err := ControlRequest(path, "secret", "echo", "a", "b"); err != nil || out != "a b\n" {
+		t.Fatalf("Unexpected echo output %q: %v", out, err)
+	}
+	// the socket is created in a private directory which is removed before
+	// connections are accepted
+	if entries, err := ioutil.ReadDir(dir); err != nil || len(entries) != 1 {
+		t.Fatalf("Unexpected entries next to the control socket: %v, %v", entries, err)
+	}
+
+	if out, err := ControlRequest(path, "secret", "flush_zone", "example"); err != nil || out != "flushed 1 entries\n" {
+		t.Fatalf("Unexpected flush output %q: %v", out, err)
+	}
+	out, err := ControlRequest(path, "secret", "stats")
+	if err != nil {
+		t.Fatalf("stats failed: %s", err)
+	}
+	var st Stats
+	if err := json.Unmarshal([]byte(out), &st); err != nil {
+		t.Fatalf("Failed to decode stats %q: %s", out, err)
+	}
+	if out, err := ControlRequest(path, "secret", "help"); err != nil || out != "echo\nflush\nflush_zone\nhelp\nstats\n" {
+		t.Fatalf("Unexpected help output %q: %v", out, err)
+	}
+	for _, tc := range []struct {
+		secret  string
+		command []string
+		err     string
+	}{
+		{"wrong", []string{"stats"}, "invalid secret"},
+		{"", []string{"stats"}, "invalid secret"},
+		{"secret", []string{"unknown"}, "unknown command"},
+		{"secret", []string{"flush"}, "usage"},
+		{"secret", nil, "no command"},
+	} {
+		_, err := ControlRequest(path, tc.secret, tc.command...)
+		if err == nil || !errors.Is(err, ErrControlCommand) || !strings.Contains(err.Error(), tc.err) {
+			t.Fatalf("Expected %v with secret %q to fail with %q, got %v", tc.command, tc.secret, tc.err, err)
+		}
+	}
+
+	cs.Close()
+	if err := <-served; err != ErrServerClosed {
+		t.Fatalf("Expected ErrServerClosed, got %v", err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("Control socket wasn't removed: %v", err)
+	}
+}
@@ -0,0 +1,211 @@
+package solvere
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jmhodges/clock"
+	"github.com/miekg/dns"
+)
+
+// ErrZoneDamped is returned when a query to the nameservers of a zone is throttled
+// because lookups of names in the zone are failing at a high rate
+var ErrZoneDamped = errors.New("solvere: Queries to zone throttled due to a high failure rate")
+
+var (
+	// DefaultDampingWindow is the default period SubdomainDamping measures the
+	// failure rate of a zone over
+	DefaultDampingWindow = 10 * time.Second
+	// DefaultDampingThreshold is the default number of failures within the
+	// window after which SubdomainDamping throttles queries to a zone
+	DefaultDampingThreshold = 200
+	// DefaultDampedRate is the default number of queries per second
+	// SubdomainDamping allows to the nameservers of a throttled zone
+	DefaultDampedRate = 5.0
+)
+
+// maxDampingZones is the number of zones whose failures are tracked before zones
+// which aren't throttled are discarded
+const maxDampingZones = 4096
+
+// SubdomainDamping protects the nameservers of zones, and the resolver itself, from
+// random subdomain attacks, where clients ask for many unique names below a zone
+// which don't exist so every query misses the cache and is sent upstream. The
+// number of queries to the nameservers of each zone which fail, are answered with
+// NXDOMAIN, or are throttled, is counted and once it reaches Threshold within
+// Window further queries to the zone's nameservers are limited to Rate per second.
+// Questions which would exceed it fail with ErrZoneDamped, which is answered with
+// SERVFAIL, without contacting the nameservers. Answers which are already cached
+// are still returned. Once a Window passes with fewer than Threshold failures the
+// zone is no longer throttled.
+//
+// Queries to the root zone and forwarders aren't counted or throttled, as every
+// lookup depends on them.
+type SubdomainDamping struct {
+	// accessed atomically, kept at the start of the struct for alignment
+	throttled uint64
+
+	// Window is the period the failures of each zone are counted over, if zero
+	// DefaultDampingWindow is used
+	Window time.Duration
+	// Threshold is the number of failures within Window after which queries to
+	// a zone are throttled, if zero DefaultDampingThreshold is used
+	Threshold int
+	// Rate is the number of queries per second allowed to the nameservers of a
+	// throttled zone, if zero DefaultDampedRate is used. If negative no queries
+	// are allowed.
+	Rate float64
+
+	mu    sync.Mutex
+	zones map[string]*dampingZone
+	clk   clock.Clock
+}
+
+// dampingZone is the failure count of a zone in the current window
+type dampingZone struct {
+	start    time.Time
+	failures int
+	damped   bool
+	bucket   bucket
+}
+
+func (sd *SubdomainDamping) window() time.Duration {
+	if sd.Window > 0 {
+		return sd.Window
+	}
+	return DefaultDampingWindow
+}
+
+func (sd *SubdomainDamping) threshold() int {
+	if sd.Threshold > 0 {
+		return sd.Threshold
+	}
+	return DefaultDampingThreshold
+}
+
+func (sd *SubdomainDamping) rate() float64 {
+	if sd.Rate != 0 {
+		return sd.Rate
+	}
+	return DefaultDampedRate
+}
+
+func (sd *SubdomainDamping) now() time.Time {
+	if sd.clk == nil {
+		return time.Now()
+	}
+	return sd.clk.Now()
+}
+
+// Throttled returns the number of queries which failed with ErrZoneDamped
+func (sd *SubdomainDamping) Throttled() uint64 {
+	return atomic.LoadUint64(&sd.throttled)
+}
+
+// DampedZones returns the zones queries are currently being throttled for
+func (sd *SubdomainDamping) DampedZones() []string {
+	sd.mu.Lock()
+	defer sd.mu.Unlock()
+	now := sd.now()
+	var zones []string
+	for name, z := range sd.zones {
+		sd.roll(z, now)
+		if z.damped {
+			zones = append(zones, name)
+		}
+	}
+	sort.Strings(zones)
+	return zones
+}
+
+// counted checks if queries to the nameserver auth are counted and throttled
+func counted(auth *Nameserver) bool {
+	return !auth.Forwarder && auth.Zone != "" && auth.Zone != "."
+}
+
+// roll starts a new window for z if the current one has passed, the zone is
+// throttled during the new window if the failures in the last reached the
+// threshold. Must be called with sd.mu held.
+func (sd *SubdomainDamping) roll(z *dampingZone, now time.Time) {
+	window := sd.window()
+	if now.Sub(z.start) < window {
+		return
+	}
+	// if more than a window has passed the last complete window had no failures
+	z.damped = now.Sub(z.start) < 2*window && z.failures >= sd.threshold()
+	z.start = now
+	z.failures = 0
+}
+
+// zone returns the state of zone, creating it if needed. Must be called with sd.mu
+// held.
+func (sd *SubdomainDamping) zone(name string, now time.Time) *dampingZone {
+	z, present := sd.zones[name]
+	if present {
+		sd.roll(z, now)
+		return z
+	}
+	if sd.zones == nil {
+		sd.zones = make(map[string]*dampingZone)
+	}
+	if len(sd.zones) >= maxDampingZones {
+		for k, oz := range sd.zones {
+			if sd.roll(oz, now); !oz.damped && oz.failures == 0 {
+				delete(sd.zones, k)
+			}
+		}
+	}
+	z = &dampingZone{start: now}
+	sd.zones[name] = z
+	return z
+}
+
+// allow checks if a query can be sent to the nameserver auth, once the zone is
+// throttled queries which would exceed the damped rate fail with ErrZoneDamped
+func (sd *SubdomainDamping) allow(auth *Nameserver) error {
+	if !counted(auth) {
+		return nil
+	}
+	name := strings.ToLower(auth.Zone)
+	sd.mu.Lock()
+	defer sd.mu.Unlock()
+	now := sd.now()
+	z, present := sd.zones[name]
+	if !present {
+		return nil
+	}
+	if sd.roll(z, now); !z.damped {
+		return nil
+	}
+	if rate := sd.rate(); rate > 0 && z.bucket.reserve(now, rate, burstOrOne(int(rate))) == 0 {
+		return nil
+	} else if rate > 0 {
+		// the token wasn't available so isn't taken
+		z.bucket.tokens++
+	}
+	// throttled queries are counted so the zone stays throttled while the
+	// attack continues
+	z.failures++
+	atomic.AddUint64(&sd.throttled, 1)
+	return ErrZoneDamped
+}
+
+// record counts the outcome of a query to the nameserver auth, r is nil if the
+// query failed
+func (sd *SubdomainDamping) record(auth *Nameserver, r *dns.Msg) {
+	if !counted(auth) || (r != nil && r.Rcode != dns.RcodeNameError && r.Rcode != dns.RcodeServerFailure) {
+		return
+	}
+	name := strings.ToLower(auth.Zone)
+	sd.mu.Lock()
+	defer sd.mu.Unlock()
+	now := sd.now()
+	z := sd.zone(name, now)
+	if z.failures++; z.failures >= sd.threshold() {
+		z.damped = true
+	}
+}
@@ -0,0 +1,102 @@
+package solvere
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/jmhodges/clock"
+	"github.com/miekg/dns"
+)
+
+func TestSubdomainDamping(t *testing.T) {
+	cache := NewBasicCache()
+	rr := NewRecursiveResolver(false, false, []dns.RR{
+		&dns.A{Hdr: dns.RR_Header{Name: "a.root-servers.net.", Rrtype: dns.TypeA, Class: dns.ClassINET}, A: net.IP{198, 41, 0, 4}},
+	}, nil, cache)
+	rr.FailureTTL = -1
+	fc := clock.NewFake()
+	rr.Damping = &SubdomainDamping{Threshold: 3, Rate: -1, clk: fc}
+	root := net.JoinHostPort("198.41.0.4", dnsPort)
+	zoneQueries := 0
+	rr.Transport = transportFunc(func(_ context.Context, m *dns.Msg, addr string) (*dns.Msg, error) {
+		r := new(dns.Msg)
+		r.SetReply(m)
+		if addr == root {
+			r.Ns = []dns.RR{&dns.NS{Hdr: dns.RR_Header{Name: "example.com.", Rrtype: dns.TypeNS, Class: dns.ClassINET, Ttl: 3600}, Ns: "ns.example.com."}}
+			r.Extra = []dns.RR{&dns.A{Hdr: dns.RR_Header{Name: "ns.example.com.", Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 3600}, A: net.IP{192, 0, 2, 1}}}
+			return r, nil
+		}
+		zoneQueries++
+		if m.Question[0].Name != "www.example.com." {
+			r.Rcode = dns.RcodeNameError
+			return r, nil
+		}
+		r.Answer = []dns.RR{&dns.A{Hdr: dns.RR_Header{Name: m.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 3600}, A: net.IP{1, 2, 3, 4}}}
+		return r, nil
+	})
+	www := Question{Name: "www.example.com.", Type: dns.TypeA}
+	if _, _, err := rr.Lookup(context.Background(), www); err != nil {
+		t.Fatalf("Lookup failed: %s", err)
+	}
+	// answers are added to the cache in the background
+	for i := 0; cache.Get(&www) == nil; i++ {
+		if i == 100 {
+			t.Fatal("Answer wasn't cached")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	random := 0
+	lookupRandom := func() error {
+		random++
+		_, _, err := rr.Lookup(context.Background(), Question{Name: fmt.Sprintf("r%d.example.com.", random), Type: dns.TypeA})
+		return err
+	}
+	for i := 0; i < 3; i++ {
+		if err := lookupRandom(); err != nil {
+			t.Fatalf("Lookup of nonexistent name failed: %s", err)
+		}
+	}
+	if zones := rr.Damping.DampedZones(); len(zones) != 1 || zones[0] != "example.com." {
+		t.Fatalf("Unexpected damped zones: %v", zones)
+	}
+	zoneQueries = 0
+	if err := lookupRandom(); !errors.Is(err, ErrZoneDamped) {
+		t.Fatalf("Lookup in damped zone wasn't throttled: %v", err)
+	}
+	if zoneQueries != 0 || rr.Damping.Throttled() != 1 {
+		t.Fatalf("Throttled lookup sent %d queries, %d throttled", zoneQueries, rr.Damping.Throttled())
+	}
+	if _, _, err := rr.Lookup(context.Background(), www); err != nil {
+		t.Fatalf("Cached answer wasn't returned while damped: %s", err)
+	}
+
+	// the zone stays damped while the attack continues
+	fc.Add(DefaultDampingWindow / 2)
+	lookupRandom()
+	lookupRandom()
+	fc.Add(DefaultDampingWindow / 2)
+	if err := lookupRandom(); !errors.Is(err, ErrZoneDamped) {
+		t.Fatalf("Zone wasn't damped during the next window: %v", err)
+	}
+	// and is released once it subsides
+	fc.Add(2 * DefaultDampingWindow)
+	if err := lookupRandom(); err != nil || zoneQueries != 1 {
+		t.Fatalf("Zone still damped after the failures stopped: %v", err)
+	}
+
+	// while damped queries are allowed at the damped rate
+	rr.Damping = &SubdomainDamping{Threshold: 1, Rate: 1, clk: fc}
+	lookupRandom()
+	zoneQueries = 0
+	if err := lookupRandom(); err != nil || zoneQueries != 1 {
+		t.Fatalf("Query within the damped rate wasn't allowed: %v", err)
+	}
+	if err := lookupRandom(); !errors.Is(err, ErrZoneDamped) {
+		t.Fatalf("Query exceeding the damped rate wasn't throttled: %v", err)
+	}
+}
@@ -0,0 +1,152 @@
+package solvere
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+var (
+	// MaxDelegationDepth is the maximum number of nameserver address lookups which
+	// may be nested, each started because the nameservers a zone was delegated to
+	// had no glue
+	MaxDelegationDepth = 7
+
+	ErrDelegationLoop    = errors.New("solvere: Delegation loop detected")
+	ErrDelegationTooDeep = errors.New("solvere: Too many nested nameserver address lookups")
+	ErrPrivateNameserver = errors.New("solvere: Delegation to a nameserver with a private address")
+)
+
+// DelegationStep is a nameserver whose address was being looked up in order to
+// query a zone it serves
+type DelegationStep struct {
+	Zone       string
+	Nameserver string
+}
+
+func (ds DelegationStep) String() string {
+	return ds.Nameserver + " (" + ds.Zone + ")"
+}
+
+// DelegationError is the cause of a ResolutionError when following delegations
+// requires the address of a nameserver which is already being looked up, such as
+// when a zone is delegated to nameservers in a zone which is delegated back to
+// nameservers in the first (ErrDelegationLoop), or more than MaxDelegationDepth
+// nested nameserver address lookups (ErrDelegationTooDeep)
+type DelegationError struct {
+	// Err is either ErrDelegationLoop or ErrDelegationTooDeep
+	Err error
+	// Path contains the nameservers whose addresses were being looked up, the
+	// outermost first, ending with the one which caused the failure
+	Path []DelegationStep
+}
+
+func (de *DelegationError) Error() string {
+	steps := make([]string, len(de.Path))
+	for i, s := range de.Path {
+		steps[i] = s.String()
+	}
+	return fmt.Sprintf("%s: %s", de.Err, strings.Join(steps, " -> "))
+}
+
+// Unwrap returns ErrDelegationLoop or ErrDelegationTooDeep
+func (de *DelegationError) Unwrap() error {
+	return de.Err
+}
+
+type delegationPathKey struct{}
+
+// delegationPath returns the nameservers whose addresses are being looked up by
+// the lookups enclosing the one using ctx
+func delegationPath(ctx context.Context) []DelegationStep {
+	path, _ := ctx.Value(delegationPathKey{}).([]DelegationStep)
+	return path
+}
+
+// enterDelegation returns a copy of ctx for looking up the address of the
+// nameserver name of zone, or a ResolutionError and the LookupLog describing it
+// if that would loop or exceed MaxDelegationDepth
+func enterDelegation(ctx context.Context, name, zone string) (context.Context, *LookupLog, error) {
+	path := delegationPath(ctx)
+	step := DelegationStep{Zone: zone, Nameserver: name}
+	var cause error
+	for _, s := range path {
+		if strings.EqualFold(s.Nameserver, name) {
+			cause = ErrDelegationLoop
+			break
+		}
+	}
+	if cause == nil && len(path) >= MaxDelegationDepth {
+		cause = ErrDelegationTooDeep
+	}
+	full := append(path[:len(path):len(path)], step)
+	if cause == nil {
+		return context.WithValue(ctx, delegationPathKey{}, full), nil, nil
+	}
+	q := &Question{Name: name, Type: dns.TypeA}
+	err := &ResolutionError{Stage: StageAuthority, Question: q, Zone: zone, Rcode: -1, Err: &DelegationError{Err: cause, Path: full}}
+	log := newLookupLog(q, nil)
+	log.DelegationPath = full
+	log.Error = err.Error()
+	traceFrom(ctx).add(TraceError, nil, nil, "%s", err)
+	return nil, log, err
+}
+
+// privateNameserversAllowed checks if the nameservers of zone may have private
+// addresses, see AllowPrivateNameservers
+func (rr *RecursiveResolver) privateNameserversAllowed(zone string) bool {
+	for _, z := range rr.AllowPrivateNameservers {
+		if isSubdomain(zone, dns.Fqdn(z)) {
+			return true
+		}
+	}
+	return false
+}
+
+// removePrivateGlue returns extras without the loopback, link-local and RFC 1918
+// addresses of the nameservers in auths of zones which aren't allowed to have
+// them, as following them would let a zone direct queries at internal services,
+// and the names of the nameservers whose addresses were removed
+func (rr *RecursiveResolver) removePrivateGlue(auths, extras []dns.RR) ([]dns.RR, map[string]bool) {
+	var private map[string]bool
+	filtered := make([]dns.RR, 0, len(extras))
+	for _, extra := range extras {
+		var ip net.IP
+		switch a := extra.(type) {
+		case *dns.A:
+			ip = a.A
+		case *dns.AAAA:
+			ip = a.AAAA
+		}
+		if ip == nil || !isPrivateIP(ip) || !rr.privateGlueRejected(auths, extra.Header().Name) {
+			filtered = append(filtered, extra)
+			continue
+		}
+		if private == nil {
+			private = make(map[string]bool)
+		}
+		private[extra.Header().Name] = true
+	}
+	return filtered, private
+}
+
+// privateGlueRejected checks if any of the nameserver records in auths for name
+// belong to a zone that isn't allowed private nameserver addresses
+func (rr *RecursiveResolver) privateGlueRejected(auths []dns.RR, name string) bool {
+	for _, auth := range auths {
+		if ns, ok := auth.(*dns.NS); ok && ns.Ns == name && !rr.privateNameserversAllowed(ns.Hdr.Name) {
+			return true
+		}
+	}
+	return false
+}
+
+// isPrivateAddr checks if addr is a private IP address, see isPrivateIP
+func isPrivateAddr(addr string) bool {
+	ip := net.ParseIP(addr)
+	return ip != nil && isPrivateIP(ip)
+}
@@ -0,0 +1,145 @@
+package solvere
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+// newDelegationResolver returns a resolver whose root delegates the zones in
+// delegations to the nameservers they map to, without glue unless the nameserver
+// is ns.c.example. which answers every query with its own address
+func newDelegationResolver(delegations map[string]string) *RecursiveResolver {
+	rr := NewRecursiveResolver(false, false, []dns.RR{
+		&dns.A{Hdr: dns.RR_Header{Name: "a.root-servers.net.", Rrtype: dns.TypeA, Class: dns.ClassINET}, A: net.IP{198, 41, 0, 4}},
+	}, nil, nil)
+	rr.FailureTTL = -1
+	root := net.JoinHostPort("198.41.0.4", dnsPort)
+	rr.Transport = transportFunc(func(_ context.Context, m *dns.Msg, addr string) (*dns.Msg, error) {
+		r := new(dns.Msg)
+		r.SetReply(m)
+		name := m.Question[0].Name
+		if addr != root {
+			r.Answer = []dns.RR{&dns.A{Hdr: dns.RR_Header{Name: name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60}, A: net.IP{192, 0, 2, 3}}}
+			return r, nil
+		}
+		for zone, ns := range delegations {
+			if !dns.IsSubDomain(zone, name) {
+				continue
+			}
+			r.Ns = []dns.RR{&dns.NS{Hdr: dns.RR_Header{Name: zone, Rrtype: dns.TypeNS, Class: dns.ClassINET, Ttl: 60}, Ns: ns}}
+			if ns == "ns.c.example." {
+				r.Extra = []dns.RR{&dns.A{Hdr: dns.RR_Header{Name: ns, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60}, A: net.IP{192, 0, 2, 3}}}
+			}
+			return r, nil
+		}
+		r.Rcode = dns.RcodeNameError
+		return r, nil
+	})
+	return rr
+}
+
+// findDelegationPath returns the first delegation path in the LookupLog tree
+func findDelegationPath(ll *LookupLog) []DelegationStep {
+	if ll == nil {
+		return nil
+	}
+	if len(ll.DelegationPath) > 0 {
+		return ll.DelegationPath
+	}
+	for _, c := range ll.Composites {
+		if path := findDelegationPath(c); path != nil {
+			return path
+		}
+	}
+	return nil
+}
+
+func TestDelegationLoop(t *testing.T) {
+	rr := newDelegationResolver(map[string]string{"a.example.": "ns.b.example.", "b.example.": "ns.a.example."})
+	_, ll, err := rr.Lookup(context.Background(), Question{Name: "www.a.example.", Type: dns.TypeA})
+	var de *DelegationError
+	if !errors.Is(err, ErrDelegationLoop) || !errors.As(err, &de) {
+		t.Fatalf("Expected a delegation loop, got %v", err)
+	}
+	expected := []DelegationStep{{"a.example.", "ns.b.example."}, {"b.example.", "ns.a.example."}, {"a.example.", "ns.b.example."}}
+	if len(de.Path) != len(expected) {
+		t.Fatalf("Unexpected loop path: %v", de.Path)
+	}
+	for i := range expected {
+		if de.Path[i] != expected[i] {
+			t.Fatalf("Unexpected loop path: %v", de.Path)
+		}
+	}
+	if !strings.Contains(err.Error(), "ns.b.example. (a.example.) -> ns.a.example. (b.example.)") {
+		t.Fatalf("Loop path missing from error: %s", err)
+	}
+	if path := findDelegationPath(ll); len(path) != len(expected) {
+		t.Fatalf("Loop path missing from LookupLog: %v", path)
+	}
+}
+
+func TestDelegationDepth(t *testing.T) {
+	rr := newDelegationResolver(map[string]string{"a.example.": "ns.b.example.", "b.example.": "ns.c.example.", "c.example.": "ns.c.example."})
+	a, _, err := rr.Lookup(context.Background(), Question{Name: "www.a.example.", Type: dns.TypeA})
+	if err != nil {
+		t.Fatalf("Lookup failed: %s", err)
+	}
+	if ips := a.IPs(); len(ips) != 1 || !ips[0].Equal(net.IP{192, 0, 2, 3}) {
+		t.Fatalf("Unexpected answer: %v", a.Answer)
+	}
+
+	defer func(depth int) { MaxDelegationDepth = depth }(MaxDelegationDepth)
+	MaxDelegationDepth = 1
+	rr = newDelegationResolver(map[string]string{"a.example.": "ns.b.example.", "b.example.": "ns.d.example.", "d.example.": "ns.c.example."})
+	_, _, err = rr.Lookup(context.Background(), Question{Name: "www.a.example.", Type: dns.TypeA})
+	var de *DelegationError
+	if !errors.Is(err, ErrDelegationTooDeep) || !errors.As(err, &de) || len(de.Path) != 2 {
+		t.Fatalf("Expected the delegation depth to be exceeded, got %v", err)
+	}
+}
+
+func TestPrivateNameservers(t *testing.T) {
+	rr := NewRecursiveResolver(false, false, []dns.RR{
+		&dns.A{Hdr: dns.RR_Header{Name: "a.root-servers.net.", Rrtype: dns.TypeA, Class: dns.ClassINET}, A: net.IP{198, 41, 0, 4}},
+	}, nil, nil)
+	rr.FailureTTL = -1
+	root := net.JoinHostPort("198.41.0.4", dnsPort)
+	rr.Transport = transportFunc(func(_ context.Context, m *dns.Msg, addr string) (*dns.Msg, error) {
+		r := new(dns.Msg)
+		r.SetReply(m)
+		name := m.Question[0].Name
+		if addr != root {
+			r.Answer = []dns.RR{&dns.A{Hdr: dns.RR_Header{Name: name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60}, A: net.IP{192, 0, 2, 3}}}
+			return r, nil
+		}
+		r.Ns = []dns.RR{&dns.NS{Hdr: dns.RR_Header{Name: "example.", Rrtype: dns.TypeNS, Class: dns.ClassINET, Ttl: 60}, Ns: "ns.example."}}
+		r.Extra = []dns.RR{&dns.A{Hdr: dns.RR_Header{Name: "ns.example.", Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60}, A: net.IP{10, 0, 0, 1}}}
+		return r, nil
+	})
+
+	_, _, err := rr.Lookup(context.Background(), Question{Name: "www.example.", Type: dns.TypeA})
+	if !errors.Is(err, ErrPrivateNameserver) {
+		t.Fatalf("Expected the delegation to a private address to be refused, got %v", err)
+	}
+
+	for _, allowed := range []string{".", "example"} {
+		rr.AllowPrivateNameservers = []string{allowed}
+		a, _, err := rr.Lookup(context.Background(), Question{Name: "www.example.", Type: dns.TypeA})
+		if err != nil {
+			t.Fatalf("Lookup with %q allowed failed: %s", allowed, err)
+		}
+		if ips := a.IPs(); len(ips) != 1 || !ips[0].Equal(net.IP{192, 0, 2, 3}) {
+			t.Fatalf("Unexpected answer: %v", a.Answer)
+		}
+	}
+
+	rr.AllowPrivateNameservers = []string{"other."}
+	if _, _, err = rr.Lookup(context.Background(), Question{Name: "www.example.", Type: dns.TypeA}); !errors.Is(err, ErrPrivateNameserver) {
+		t.Fatalf("Expected the delegation to be refused when another zone is allowed, got %v", err)
+	}
+}
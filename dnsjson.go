@@ -0,0 +1,314 @@
+package solvere
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+var ErrMalformedJSON = errors.New("solvere: Malformed DNS JSON object")
+
+// jsonBool is a RFC 8427 flag, it is encoded as 0 or 1 like the examples in the RFC
+// but either form is accepted when decoding
+type jsonBool bool
+
+func (jb jsonBool) MarshalJSON() ([]byte, error) {
+	if jb {
+		return []byte("1"), nil
+	}
+	return []byte("0"), nil
+}
+
+func (jb *jsonBool) UnmarshalJSON(b []byte) error {
+	switch string(b) {
+	case "1", "true":
+		*jb = true
+	case "0", "false":
+		*jb = false
+	default:
+		return fmt.Errorf("%w: invalid flag %s", ErrMalformedJSON, b)
+	}
+	return nil
+}
+
+// jsonMessage is the RFC 8427 message object, only the first question is included
+// since that is all that is used in practice
+type jsonMessage struct {
+	ID      uint16   `json:"ID"`
+	QR      jsonBool `json:"QR"`
+	Opcode  int      `json:"Opcode"`
+	AA      jsonBool `json:"AA"`
+	TC      jsonBool `json:"TC"`
+	RD      jsonBool `json:"RD"`
+	RA      jsonBool `json:"RA"`
+	AD      jsonBool `json:"AD"`
+	CD      jsonBool `json:"CD"`
+	RCODE   int      `json:"RCODE"`
+	QDCOUNT int      `json:"QDCOUNT"`
+	ANCOUNT int      `json:"ANCOUNT"`
+	NSCOUNT int      `json:"NSCOUNT"`
+	ARCOUNT int      `json:"ARCOUNT"`
+
+	QNAME      string `json:"QNAME,omitempty"`
+	QTYPE      uint16 `json:"QTYPE,omitempty"`
+	QTYPEname  string `json:"QTYPEname,omitempty"`
+	QCLASS     uint16 `json:"QCLASS,omitempty"`
+	QCLASSname string `json:"QCLASSname,omitempty"`
+
+	AnswerRRs     []jsonRR `json:"answerRRs,omitempty"`
+	AuthorityRRs  []jsonRR `json:"authorityRRs,omitempty"`
+	AdditionalRRs []jsonRR `json:"additionalRRs,omitempty"`
+
+	// ExtendedErrors isn't defined by RFC 8427, parsers are expected to ignore
+	// members they don't understand
+	ExtendedErrors []ExtendedError `json:"extendedErrors,omitempty"`
+}
+
+// jsonRR is a RFC 8427 resource record object. The RDATA is always included as
+// RDATAHEX, so any type can be decoded, and as a rdata<TYPE> member in presentation
+// format for types known to miekg/dns.
+type jsonRR struct {
+	dns.RR
+}
+
+func (jr jsonRR) MarshalJSON() ([]byte, error) {
+	h := jr.Header()
+	rdata, err := packRdata(jr.RR)
+	if err != nil {
+		return nil, err
+	}
+	o := map[string]interface{}{
+		"NAME":     h.Name,
+		"TYPE":     h.Rrtype,
+		"CLASS":    h.Class,
+		"TTL":      h.Ttl,
+		"RDLENGTH": len(rdata),
+		"RDATAHEX": strings.ToUpper(hex.EncodeToString(rdata)),
+	}
+	if name, present := dns.TypeToString[h.Rrtype]; present {
+		o["TYPEname"] = name
+		if _, rfc3597 := jr.RR.(*dns.RFC3597); !rfc3597 && h.Rrtype != dns.TypeOPT {
+			o["rdata"+name] = strings.TrimPrefix(jr.RR.String(), h.String())
+		}
+	}
+	if name, present := dns.ClassToString[h.Class]; present && h.Rrtype != dns.TypeOPT {
+		o["CLASSname"] = name
+	}
+	return json.Marshal(o)
+}
+
+func (jr *jsonRR) UnmarshalJSON(b []byte) error {
+	var o map[string]json.RawMessage
+	if err := json.Unmarshal(b, &o); err != nil {
+		return err
+	}
+	var name, rdataHex string
+	var rrtype, class uint16
+	var ttl uint32
+	for _, f := range []struct {
+		key string
+		v   interface{}
+	}{{"NAME", &name}, {"TYPE", &rrtype}, {"CLASS", &class}, {"TTL", &ttl}} {
+		raw, present := o[f.key]
+		if !present {
+			return fmt.Errorf("%w: resource record without %s", ErrMalformedJSON, f.key)
+		}
+		if err := json.Unmarshal(raw, f.v); err != nil {
+			return err
+		}
+	}
+	if raw, present := o["RDATAHEX"]; present {
+		if err := json.Unmarshal(raw, &rdataHex); err != nil {
+			return err
+		}
+		rdata, err := hex.DecodeString(rdataHex)
+		if err != nil {
+			return fmt.Errorf("%w: %s", ErrMalformedJSON, err)
+		}
+		jr.RR, err = unpackRR(name, rrtype, class, ttl, rdata)
+		return err
+	}
+	// fall back to the presentation format
+	typeName, present := dns.TypeToString[rrtype]
+	if !present {
+		return fmt.Errorf("%w: resource record without RDATAHEX", ErrMalformedJSON)
+	}
+	var rdata string
+	raw, present := o["rdata"+typeName]
+	if !present {
+		return fmt.Errorf("%w: resource record without RDATA", ErrMalformedJSON)
+	}
+	if err := json.Unmarshal(raw, &rdata); err != nil {
+		return err
+	}
+	className, present := dns.ClassToString[class]
+	if !present {
+		className = fmt.Sprintf("CLASS%d", class)
+	}
+	rr, err := dns.NewRR(fmt.Sprintf("%s %d %s %s %s", name, ttl, className, typeName, rdata))
+	if err != nil {
+		return err
+	}
+	jr.RR = rr
+	return nil
+}
+
+// unpackRR builds a record from its header fields and the wire format of its RDATA
+func unpackRR(name string, rrtype, class uint16, ttl uint32, rdata []byte) (dns.RR, error) {
+	if len(rdata) > 0xffff {
+		return nil, fmt.Errorf("%w: RDATA too long", ErrMalformedJSON)
+	}
+	wire := make([]byte, 256+10+len(rdata))
+	off, err := dns.PackDomainName(dns.Fqdn(name), wire, 0, nil, false)
+	if err != nil {
+		return nil, err
+	}
+	binary.BigEndian.PutUint16(wire[off:], rrtype)
+	binary.BigEndian.PutUint16(wire[off+2:], class)
+	binary.BigEndian.PutUint32(wire[off+4:], ttl)
+	binary.BigEndian.PutUint16(wire[off+8:], uint16(len(rdata)))
+	end := off + 10 + copy(wire[off+10:], rdata)
+	rr, _, err := dns.UnpackRR(wire[:end], 0)
+	return rr, err
+}
+
+func jsonRRs(records []dns.RR) []jsonRR {
+	if len(records) == 0 {
+		return nil
+	}
+	out := make([]jsonRR, len(records))
+	for i, r := range records {
+		out[i] = jsonRR{r}
+	}
+	return out
+}
+
+func dnsRRs(records []jsonRR) []dns.RR {
+	if len(records) == 0 {
+		return nil
+	}
+	out := make([]dns.RR, len(records))
+	for i, r := range records {
+		out[i] = r.RR
+	}
+	return out
+}
+
+func newJSONMessage(m *dns.Msg) *jsonMessage {
+	jm := &jsonMessage{
+		ID:            m.Id,
+		QR:            jsonBool(m.Response),
+		Opcode:        m.Opcode,
+		AA:            jsonBool(m.Authoritative),
+		TC:            jsonBool(m.Truncated),
+		RD:            jsonBool(m.RecursionDesired),
+		RA:            jsonBool(m.RecursionAvailable),
+		AD:            jsonBool(m.AuthenticatedData),
+		CD:            jsonBool(m.CheckingDisabled),
+		RCODE:         m.Rcode,
+		QDCOUNT:       len(m.Question),
+		ANCOUNT:       len(m.Answer),
+		NSCOUNT:       len(m.Ns),
+		ARCOUNT:       len(m.Extra),
+		AnswerRRs:     jsonRRs(m.Answer),
+		AuthorityRRs:  jsonRRs(m.Ns),
+		AdditionalRRs: jsonRRs(m.Extra),
+	}
+	if len(m.Question) > 0 {
+		q := m.Question[0]
+		jm.QDCOUNT = 1
+		jm.QNAME, jm.QTYPE, jm.QTYPEname = q.Name, q.Qtype, dns.TypeToString[q.Qtype]
+		jm.QCLASS, jm.QCLASSname = q.Qclass, dns.ClassToString[q.Qclass]
+	}
+	return jm
+}
+
+func (jm *jsonMessage) msg() *dns.Msg {
+	m := &dns.Msg{
+		MsgHdr: dns.MsgHdr{
+			Id:                 jm.ID,
+			Response:           bool(jm.QR),
+			Opcode:             jm.Opcode,
+			Authoritative:      bool(jm.AA),
+			Truncated:          bool(jm.TC),
+			RecursionDesired:   bool(jm.RD),
+			RecursionAvailable: bool(jm.RA),
+			AuthenticatedData:  bool(jm.AD),
+			CheckingDisabled:   bool(jm.CD),
+			Rcode:              jm.RCODE,
+		},
+		Answer: dnsRRs(jm.AnswerRRs),
+		Ns:     dnsRRs(jm.AuthorityRRs),
+		Extra:  dnsRRs(jm.AdditionalRRs),
+	}
+	if jm.QNAME != "" {
+		class := jm.QCLASS
+		if class == 0 {
+			class = dns.ClassINET
+		}
+		m.Question = []dns.Question{{Name: jm.QNAME, Qtype: jm.QTYPE, Qclass: class}}
+	}
+	return m
+}
+
+// MsgToJSON returns the RFC 8427 JSON representation of m. Only the first question
+// is included and the RDATA of each record is included both as RDATAHEX and,
+// for known types, in presentation format.
+func MsgToJSON(m *dns.Msg) ([]byte, error) {
+	return json.Marshal(newJSONMessage(m))
+}
+
+// MsgFromJSON parses a RFC 8427 JSON message object. Records are decoded from their
+// RDATAHEX member if present, otherwise from their rdata<TYPE> member.
+func MsgFromJSON(b []byte) (*dns.Msg, error) {
+	jm := new(jsonMessage)
+	if err := json.Unmarshal(b, jm); err != nil {
+		return nil, err
+	}
+	return jm.msg(), nil
+}
+
+// MarshalJSON encodes the answer as a RFC 8427 response message object without a
+// question. The AA and AD flags are set from Authoritative and Authenticated and
+// any extended errors are included using the non-standard extendedErrors member.
+func (a *Answer) MarshalJSON() ([]byte, error) {
+	m := &dns.Msg{
+		MsgHdr: dns.MsgHdr{
+			Response:           true,
+			RecursionAvailable: true,
+			Rcode:              a.Rcode,
+			Authoritative:      a.Authoritative,
+			AuthenticatedData:  a.Authenticated,
+		},
+		Answer: a.Answer,
+		Ns:     a.Authority,
+		Extra:  a.Additional,
+	}
+	jm := newJSONMessage(m)
+	jm.ExtendedErrors = a.ExtendedErrors
+	return json.Marshal(jm)
+}
+
+// UnmarshalJSON decodes a RFC 8427 message object into the answer
+func (a *Answer) UnmarshalJSON(b []byte) error {
+	jm := new(jsonMessage)
+	if err := json.Unmarshal(b, jm); err != nil {
+		return err
+	}
+	m := jm.msg()
+	*a = Answer{
+		Answer:         m.Answer,
+		Authority:      m.Ns,
+		Additional:     m.Extra,
+		Rcode:          m.Rcode,
+		Authenticated:  m.AuthenticatedData,
+		Authoritative:  m.Authoritative,
+		ExtendedErrors: jm.ExtendedErrors,
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+package solvere
+
+import (
+	"encoding/json"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestMsgJSON(t *testing.T) {
+	m := new(dns.Msg)
+	m.SetQuestion("example.com.", dns.TypeA)
+	m.Response, m.AuthenticatedData = true, true
+	m.Answer = []dns.RR{
+		&dns.CNAME{Hdr: dns.RR_Header{Name: "example.com.", Rrtype: dns.TypeCNAME, Class: dns.ClassINET, Ttl: 60}, Target: "www.example.com."},
+		&dns.A{Hdr: dns.RR_Header{Name: "www.example.com.", Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60}, A: net.IP{192, 0, 2, 1}},
+	}
+	m.Ns = []dns.RR{&dns.RFC3597{Hdr: dns.RR_Header{Name: "example.com.", Rrtype: 65280, Class: dns.ClassINET, Ttl: 60}, Rdata: "0102"}}
+	m.SetEdns0(1232, true)
+
+	j, err := MsgToJSON(m)
+	if err != nil {
+		t.Fatalf("MsgToJSON failed: %s", err)
+	}
+	for _, member := range []string{`"QR":1`, `"AD":1`, `"QNAME":"example.com."`, `"QTYPEname":"A"`, `"rdataA":"192.0.2.1"`, `"rdataCNAME":"www.example.com."`, `"ANCOUNT":2`} {
+		if !strings.Contains(string(j), member) {
+			t.Errorf("JSON is missing %s: %s", member, j)
+		}
+	}
+	decoded, err := MsgFromJSON(j)
+	if err != nil {
+		t.Fatalf("MsgFromJSON failed: %s", err)
+	}
+	if decoded.String() != m.String() {
+		t.Fatalf("Message didn't survive the round trip:\n%s\n%s", decoded, m)
+	}
+
+	// records may only contain the presentation format, and flags may be booleans
+	decoded, err = MsgFromJSON([]byte(`{"ID": 1, "QR": true, "RCODE": 3, "QNAME": "example.com.", "QTYPE": 1,
+		"authorityRRs": [{"NAME": "example.com.", "TYPE": 6, "CLASS": 1, "TTL": 300, "rdataSOA": "ns.example.com. hostmaster.example.com. 1 7200 900 1209600 300"}]}`))
+	if err != nil {
+		t.Fatalf("MsgFromJSON failed: %s", err)
+	}
+	if !decoded.Response || decoded.Rcode != dns.RcodeNameError || len(decoded.Ns) != 1 || decoded.Ns[0].(*dns.SOA).Minttl != 300 {
+		t.Fatalf("Unexpected message: %s", decoded)
+	}
+
+	for _, bad := range []string{
+		`{"QR": 2}`,
+		`{"answerRRs": [{"NAME": "a.", "TYPE": 1, "CLASS": 1}]}`,
+		`{"answerRRs": [{"NAME": "a.", "TYPE": 1, "CLASS": 1, "TTL": 1, "RDATAHEX": "zz"}]}`,
+		`{"answerRRs": [{"NAME": "a.", "TYPE": 1, "CLASS": 1, "TTL": 1}]}`,
+	} {
+		if _, err = MsgFromJSON([]byte(bad)); err == nil {
+			t.Errorf("MsgFromJSON didn't fail with %s", bad)
+		}
+	}
+}
+
+func TestAnswerJSON(t *testing.T) {
+	a := &Answer{
+		Answer:         []dns.RR{&dns.A{Hdr: dns.RR_Header{Name: "example.com.", Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60}, A: net.IP{192, 0, 2, 1}}},
+		Rcode:          dns.RcodeSuccess,
+		Authenticated:  true,
+		ExtendedErrors: []ExtendedError{{InfoCode: ExtendedErrorStaleAnswer, ExtraText: "stale"}},
+	}
+	j, err := json.Marshal(a)
+	if err != nil {
+		t.Fatalf("Failed to marshal Answer: %s", err)
+	}
+	decoded := new(Answer)
+	if err = json.Unmarshal(j, decoded); err != nil {
+		t.Fatalf("Failed to unmarshal Answer: %s", err)
+	}
+	if decoded.Answer[0].String() != a.Answer[0].String() {
+		t.Fatalf("Answer records didn't survive the round trip: %v", decoded.Answer)
+	}
+	decoded.Answer = a.Answer
+	if !reflect.DeepEqual(decoded, a) {
+		t.Fatalf("Answer didn't survive the round trip: %#v", decoded)
+	}
+}
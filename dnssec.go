@@ -3,6 +3,7 @@ package solvere
 import (
 	"context"
 	"errors"
+	"strings"
 	"time"
 
 	"github.com/miekg/dns"
@@ -20,6 +21,62 @@ var (
 	ErrMissingSigned          = errors.New("solvere: Signed records are missing")
 )
 
+type noValidationKey struct{}
+
+// WithoutValidation returns a copy of ctx which causes lookups using it to skip
+// DNSSEC validation while still resolving as normal, so the records the servers
+// returned are seen whether or not they would have validated. Answers from these
+// lookups are never authenticated and aren't cached.
+func WithoutValidation(ctx context.Context) context.Context {
+	return context.WithValue(ctx, noValidationKey{}, true)
+}
+
+// validationSkipped checks if ctx was returned by WithoutValidation
+func validationSkipped(ctx context.Context) bool {
+	skip, _ := ctx.Value(noValidationKey{}).(bool)
+	return skip
+}
+
+type dnssecRecordsKey struct{}
+
+// WithDNSSECRecords returns a copy of ctx which causes lookups using it to ask
+// servers for DNSSEC records even if the resolver isn't validating, so the
+// signatures and proofs of non-existence a validating client needs to check the
+// answer itself are included in it. Handler uses it for queries with the DO bit
+// set. If the resolver isn't validating the cache, which may hold answers
+// without them, is bypassed.
+func WithDNSSECRecords(ctx context.Context) context.Context {
+	return context.WithValue(ctx, dnssecRecordsKey{}, true)
+}
+
+// dnssecRecordsRequested checks if ctx was returned by WithDNSSECRecords
+func dnssecRecordsRequested(ctx context.Context) bool {
+	requested, _ := ctx.Value(dnssecRecordsKey{}).(bool)
+	return requested
+}
+
+type validationTimeKey struct{}
+
+// WithValidationTime returns a copy of ctx which causes lookups using it to check
+// the validity periods of signatures against t instead of the current time, so
+// responses recorded by a RecordingTransport can still be validated once their
+// signatures have expired
+func WithValidationTime(ctx context.Context, t time.Time) context.Context {
+	return context.WithValue(ctx, validationTimeKey{}, t)
+}
+
+// validationTime returns the time set by WithValidationTime, or the zero time
+// which dns.RRSIG.ValidityPeriod treats as the current time
+func validationTime(ctx context.Context) time.Time {
+	t, _ := ctx.Value(validationTimeKey{}).(time.Time)
+	return t
+}
+
+// validating checks if a lookup using ctx should be validated
+func (rr *RecursiveResolver) validating(ctx context.Context) bool {
+	return rr.useDNSSEC && !validationSkipped(ctx)
+}
+
 func (rr *RecursiveResolver) lookupDNSKEY(ctx context.Context, auth *Nameserver) (map[uint16]*dns.DNSKEY, *LookupLog, func(), error) {
 	q := &Question{Name: auth.Zone, Type: dns.TypeDNSKEY}
 	var r *dns.Msg
@@ -37,11 +94,15 @@ func (rr *RecursiveResolver) lookupDNSKEY(ctx context.Context, auth *Nameserver)
 			r.Extra = a.Additional
 			log = newLookupLog(q, nil)
 			log.CacheHit = true
+			log.Cache = CacheInfrastructure
 			log.DNSSECValid = a.Authenticated
 			log.Rcode = dns.RcodeSuccess
 		}
 	}
 	if r == nil {
+		if err = budgetFrom(ctx).fetch(); err != nil {
+			return nil, newLookupLog(q, auth), nil, err
+		}
 		r, log, err = rr.query(ctx, q, auth)
 		if err != nil {
 			return nil, log, nil, err
@@ -70,7 +131,9 @@ func (rr *RecursiveResolver) lookupDNSKEY(ctx context.Context, auth *Nameserver)
 
 	// Verify RRSIGs from the message passed in using the KSK keys
 	if auth.Zone != "." {
-		err = verifyRRSIG(r, keyMap)
+		vs := time.Now()
+		err = verifyRRSIG(ctx, r, keyMap)
+		log.timings().Validation += time.Since(vs)
 		if err != nil {
 			return nil, log, nil, err
 		}
@@ -78,7 +141,7 @@ func (rr *RecursiveResolver) lookupDNSKEY(ctx context.Context, auth *Nameserver)
 
 	addCache := func() {
 		if rr.cache != nil && !log.CacheHit {
-			rr.cache.Add(q, &Answer{r.Answer, r.Ns, r.Extra, dns.RcodeSuccess, true}, false)
+			rr.cache.Add(q, &Answer{Answer: r.Answer, Authority: r.Ns, Additional: r.Extra, Rcode: dns.RcodeSuccess, Authenticated: true}, false)
 		}
 	}
 
@@ -107,7 +170,19 @@ func checkDS(keyMap map[uint16]*dns.DNSKEY, parentDSSet []dns.RR) error {
 	return ErrMissingKSK
 }
 
-func verifyRRSIG(msg *dns.Msg, keyMap map[uint16]*dns.DNSKEY) error {
+func verifyRRSIG(ctx context.Context, msg *dns.Msg, keyMap map[uint16]*dns.DNSKEY) error {
+	return verifySignatures(ctx, msg, func(sig *dns.RRSIG) *dns.DNSKEY {
+		return keyMap[sig.KeyTag]
+	})
+}
+
+// verifySignatures verifies the RRSIGs in the answer and authority sections of msg
+// using the DNSKEYs returned by keyFor, their validity periods are checked against
+// the time set by WithValidationTime. Each verification is counted against the
+// validation budget of ctx.
+func verifySignatures(ctx context.Context, msg *dns.Msg, keyFor func(*dns.RRSIG) *dns.DNSKEY) error {
+	now := validationTime(ctx)
+	budget := budgetFrom(ctx)
 	for _, section := range [][]dns.RR{msg.Answer, msg.Ns} {
 		if len(section) == 0 {
 			continue
@@ -116,21 +191,28 @@ func verifyRRSIG(msg *dns.Msg, keyMap map[uint16]*dns.DNSKEY) error {
 		if len(sigs) == 0 {
 			return ErrNoSignatures
 		}
+		sets := make(map[rrsetKey][]dns.RR)
+		for _, set := range GroupRRSets(section) {
+			sets[rrsetKey{strings.ToLower(set.Name), set.Type, set.Class}] = set.Records
+		}
 		for _, sigRR := range sigs {
 			sig := sigRR.(*dns.RRSIG)
-			rest := extractRRSet(section, sig.Header().Name, sig.TypeCovered)
+			rest := sets[rrsetKey{strings.ToLower(sig.Hdr.Name), sig.TypeCovered, sig.Hdr.Class}]
 			if len(rest) == 0 {
 				return ErrMissingSigned
 			}
-			k, present := keyMap[sig.KeyTag]
-			if !present {
+			k := keyFor(sig)
+			if k == nil {
 				return ErrMissingDNSKEY
 			}
+			if err := budget.verify(); err != nil {
+				return err
+			}
 			err := sig.Verify(k, rest)
 			if err != nil {
 				return err
 			}
-			if !sig.ValidityPeriod(time.Time{}) {
+			if !sig.ValidityPeriod(now) {
 				return ErrInvalidSignaturePeriod
 			}
 		}
@@ -138,22 +220,53 @@ func verifyRRSIG(msg *dns.Msg, keyMap map[uint16]*dns.DNSKEY) error {
 	return nil
 }
 
-func (rr *RecursiveResolver) checkSignatures(ctx context.Context, m *dns.Msg, auth *Nameserver, parentDSSet []dns.RR) (*LookupLog, error) {
+// allSigned checks that every RRset in section is covered by a RRSIG, which once
+// the signatures have been verified means the whole section is secure
+func allSigned(section []dns.RR) bool {
+	signed := map[rrsetKey]struct{}{}
+	for _, r := range section {
+		if sig, ok := r.(*dns.RRSIG); ok {
+			signed[rrsetKey{strings.ToLower(sig.Hdr.Name), sig.TypeCovered, sig.Hdr.Class}] = struct{}{}
+		}
+	}
+	for _, set := range GroupRRSets(section) {
+		if set.Type == dns.TypeRRSIG || set.Type == dns.TypeOPT {
+			continue
+		}
+		if _, present := signed[rrsetKey{strings.ToLower(set.Name), set.Type, set.Class}]; !present {
+			return false
+		}
+	}
+	return true
+}
+
+// checkSignatures validates m using the DNSKEYs of the zone auth is a nameserver
+// for, which are checked against parentDSSet if it isn't empty. If ctx was
+// returned by WithPartialResults the RRsets in the answer section which fail
+// validation may be removed from m instead, and are returned.
+func (rr *RecursiveResolver) checkSignatures(ctx context.Context, m *dns.Msg, auth *Nameserver, parentDSSet []dns.RR) (*LookupLog, []PartialFailure, error) {
 	keyMap, log, addCache, err := rr.lookupDNSKEY(ctx, auth)
 	if err != nil {
-		return log, err
+		return log, nil, err
 	}
 
+	vs := time.Now()
 	if len(parentDSSet) > 0 {
 		err = checkDS(keyMap, parentDSSet)
 		if err != nil {
-			return log, err
+			log.timings().Validation += time.Since(vs)
+			return log, nil, err
 		}
 	}
 
-	err = verifyRRSIG(m, keyMap)
+	err = verifyRRSIG(ctx, m, keyMap)
+	var failures []PartialFailure
+	if err != nil && err != ErrValidationBudgetExceeded && partialResults(ctx) {
+		failures, err = salvageRRSets(ctx, m, func(sig *dns.RRSIG) *dns.DNSKEY { return keyMap[sig.KeyTag] }, err)
+	}
+	log.timings().Validation += time.Since(vs)
 	if err != nil {
-		return log, err
+		return log, nil, err
 	}
 
 	log.DNSSECValid = true
@@ -165,5 +278,5 @@ func (rr *RecursiveResolver) checkSignatures(ctx context.Context, m *dns.Msg, au
 		}
 	}
 
-	return log, nil
+	return log, failures, nil
 }
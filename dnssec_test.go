@@ -4,7 +4,6 @@ import (
 	"context"
 	"crypto"
 	"crypto/rsa"
-	"crypto/sha1"
 	"fmt"
 	"net"
 	"sync"
@@ -19,7 +18,7 @@ import (
 var eMu = new(sync.Mutex)
 
 var exampleKey = dns.DNSKEY{
-	Hdr:       dns.RR_Header{Name: "example.", Rrtype: dns.TypeDNSKEY, Ttl: 3600},
+	Hdr:       dns.RR_Header{Name: "example.", Rrtype: dns.TypeDNSKEY, Class: dns.ClassINET, Ttl: 3600},
 	Algorithm: dns.RSASHA256,
 	Flags:     256,
 	Protocol:  3,
@@ -84,7 +83,7 @@ func mockDNSKEYServer(w dns.ResponseWriter, r *dns.Msg) {
 		return
 	case "no-keys-weird.":
 		m.Answer = append(m.Answer, &dns.SOA{
-			Hdr:     dns.RR_Header{Name: "no-keys-weird.", Rrtype: dns.TypeSOA},
+			Hdr:     dns.RR_Header{Name: "no-keys-weird.", Rrtype: dns.TypeSOA, Class: dns.ClassINET},
 			Ns:      "ns.no-keys-weird.",
 			Mbox:    "master.no-keys-weird.",
 			Serial:  1,
@@ -191,7 +190,7 @@ func TestLookupDNSKEY(t *testing.T) {
 
 	// Cache test
 	fc := clock.NewFake()
-	cache := &BasicCache{cache: make(map[[sha1.Size]byte]*cacheEntry), clk: fc}
+	cache := &BasicCache{clk: fc}
 	rr.cache = cache
 
 	_, _, addToCache, err = rr.lookupDNSKEY(context.Background(), auth)
@@ -307,28 +306,28 @@ func TestVerifyRRSIG(t *testing.T) {
 
 	// Valid signatures
 	m := &dns.Msg{Answer: append(nsSet, sigB)}
-	err = verifyRRSIG(m, keyMap)
+	err = verifyRRSIG(context.Background(), m, keyMap)
 	if err != nil {
 		t.Fatalf("Failed to verify valid RRSIGs: %s", err)
 	}
 
 	// Missing signatures
 	m = &dns.Msg{Answer: aSet}
-	err = verifyRRSIG(m, keyMap)
+	err = verifyRRSIG(context.Background(), m, keyMap)
 	if err == nil {
 		t.Fatal("verifyRRSIG didn't fail with missing signatures")
 	}
 
 	// Missing signed records
 	m = &dns.Msg{Answer: []dns.RR{sigA}}
-	err = verifyRRSIG(m, keyMap)
+	err = verifyRRSIG(context.Background(), m, keyMap)
 	if err == nil {
 		t.Fatal("verifyRRSIG didn't fail with missing signed records")
 	}
 
 	// Missing key
 	m = &dns.Msg{Answer: append(aSet, sigA)}
-	err = verifyRRSIG(m, make(map[uint16]*dns.DNSKEY))
+	err = verifyRRSIG(context.Background(), m, make(map[uint16]*dns.DNSKEY))
 	if err == nil {
 		t.Fatal("verifyRRSIG didn't fail with missing DNSKEY")
 	}
@@ -336,7 +335,7 @@ func TestVerifyRRSIG(t *testing.T) {
 	// Invalid signature
 	sigA.Signature = ""
 	m = &dns.Msg{Answer: append(aSet, sigA)}
-	err = verifyRRSIG(m, keyMap)
+	err = verifyRRSIG(context.Background(), m, keyMap)
 	if err == nil {
 		t.Fatal("verifyRRSIG didn't fail with invalid signature")
 	}
@@ -348,7 +347,7 @@ func TestVerifyRRSIG(t *testing.T) {
 		t.Fatalf("Failed to sign aSet: %s", err)
 	}
 	m = &dns.Msg{Answer: append(aSet, sigA)}
-	err = verifyRRSIG(m, keyMap)
+	err = verifyRRSIG(context.Background(), m, keyMap)
 	if err == nil {
 		t.Fatal("verifyRRSIG didn't fail with invalid validity period")
 	}
@@ -357,3 +356,63 @@ func TestVerifyRRSIG(t *testing.T) {
 func TestCheckSignatures(t *testing.T) {
 
 }
+
+func TestAllSigned(t *testing.T) {
+	a := &dns.A{Hdr: dns.RR_Header{Name: "a.example.", Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60}}
+	cname := &dns.CNAME{Hdr: dns.RR_Header{Name: "b.example.", Rrtype: dns.TypeCNAME, Class: dns.ClassINET, Ttl: 60}, Target: "a.example."}
+	sigA := &dns.RRSIG{Hdr: dns.RR_Header{Name: "A.example.", Rrtype: dns.TypeRRSIG, Class: dns.ClassINET, Ttl: 60}, TypeCovered: dns.TypeA}
+	sigCNAME := &dns.RRSIG{Hdr: dns.RR_Header{Name: "b.example.", Rrtype: dns.TypeRRSIG, Class: dns.ClassINET, Ttl: 60}, TypeCovered: dns.TypeCNAME}
+
+	for _, tc := range []struct {
+		section []dns.RR
+		signed  bool
+	}{
+		{nil, true},
+		{[]dns.RR{a, sigA}, true},
+		{[]dns.RR{cname, sigCNAME, a, sigA}, true},
+		{[]dns.RR{a}, false},
+		{[]dns.RR{cname, a, sigA}, false},
+		{[]dns.RR{a, sigCNAME}, false},
+	} {
+		if signed := allSigned(tc.section); signed != tc.signed {
+			t.Errorf("allSigned(%v) = %t, expected %t", tc.section, signed, tc.signed)
+		}
+	}
+}
+
+func TestWithoutValidation(t *testing.T) {
+	root, example := newTestZone(t, "."), newTestZone(t, "example.")
+	a := &dns.A{Hdr: dns.RR_Header{Name: "a.example.", Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60}, A: net.IP{1, 2, 3, 4}}
+	responses := map[uint16][]dns.RR{
+		// signed by a key that isn't in the chain of trust
+		dns.TypeA:      newTestZone(t, "example.").sign(t, a),
+		dns.TypeDNSKEY: example.sign(t, example.key),
+		dns.TypeDS:     root.sign(t, example.key.ToDS(dns.SHA256)),
+	}
+	rr := NewRecursiveResolver(false, true, nil, []dns.RR{root.key}, NewBasicCache())
+	rr.Forward = &ForwardConfig{Servers: []string{"10.0.0.1"}}
+	rr.Transport = transportFunc(func(_ context.Context, m *dns.Msg, _ string) (*dns.Msg, error) {
+		r := new(dns.Msg)
+		r.SetReply(m)
+		r.Answer = responses[m.Question[0].Qtype]
+		return r, nil
+	})
+
+	q := Question{Name: "a.example.", Type: dns.TypeA}
+	ans, _, err := rr.Lookup(WithoutValidation(context.Background()), q)
+	if err != nil {
+		t.Fatalf("Lookup without validation failed: %s", err)
+	}
+	if ans.Authenticated || len(ans.Answer) != 2 {
+		t.Fatalf("Unexpected answer: %v", ans)
+	}
+	// the unvalidated answer must not be served to validating lookups
+	time.Sleep(10 * time.Millisecond)
+	if _, _, err = rr.Lookup(context.Background(), q); err == nil {
+		t.Fatal("Validating lookup of bogus answer didn't fail")
+	}
+	// and the bogus result doesn't affect lookups without validation
+	if _, _, err = rr.Lookup(WithoutValidation(context.Background()), q); err != nil {
+		t.Fatalf("Lookup without validation failed: %s", err)
+	}
+}
@@ -0,0 +1,330 @@
+package solvere
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jmhodges/clock"
+	"github.com/miekg/dns"
+)
+
+// DoHContentType is the media type of DNS messages exchanged over HTTPS (RFC 8484)
+const DoHContentType = "application/dns-message"
+
+const maxDoHMessageSize = 65535
+
+// ErrDoHStatus is returned by DoHTransport when a server responds with a status
+// other than 200
+var ErrDoHStatus = errors.New("solvere: DoH request failed")
+
+// DefaultHTTP3Backoff is how long a DoHTransport waits before trying HTTP/3 with a
+// server again after a request over it failed
+var DefaultHTTP3Backoff = 5 * time.Minute
+
+// altSvcDefaultMaxAge is how long an Alt-Svc advertisement without a ma parameter
+// is valid for (RFC 7838 3.1)
+const altSvcDefaultMaxAge = 24 * time.Hour
+
+// DoHHandler is a http.Handler which answers DNS-over-HTTPS (RFC 8484) queries
+// using a Handler. It supports both the GET and POST methods and sets the
+// Cache-Control max-age of responses using the TTLs of the returned records.
+type DoHHandler struct {
+	Handler *Handler
+
+	clk clock.Clock
+}
+
+// NewDoHHandler returns a DoHHandler that uses rr to answer queries
+func NewDoHHandler(rr *RecursiveResolver) *DoHHandler {
+	return &DoHHandler{Handler: NewHandler(rr), clk: clock.Default()}
+}
+
+func (dh *DoHHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var wire []byte
+	switch r.Method {
+	case http.MethodGet:
+		param := r.URL.Query().Get("dns")
+		if param == "" {
+			http.Error(w, "missing dns query parameter", http.StatusBadRequest)
+			return
+		}
+		var err error
+		wire, err = base64.RawURLEncoding.DecodeString(param)
+		if err != nil {
+			http.Error(w, "malformed dns query parameter", http.StatusBadRequest)
+			return
+		}
+	case http.MethodPost:
+		if r.Header.Get("Content-Type") != DoHContentType {
+			http.Error(w, fmt.Sprintf("unsupported content type, expected %s", DoHContentType), http.StatusUnsupportedMediaType)
+			return
+		}
+		var err error
+		wire, err = ioutil.ReadAll(io.LimitReader(r.Body, maxDoHMessageSize+1))
+		if err != nil {
+			http.Error(w, "failed to read request body", http.StatusBadRequest)
+			return
+		}
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if len(wire) > maxDoHMessageSize {
+		http.Error(w, "query too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	query := new(dns.Msg)
+	if err := query.Unpack(wire); err != nil {
+		http.Error(w, "malformed dns message", http.StatusBadRequest)
+		return
+	}
+	ctx := r.Context()
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		if ip := net.ParseIP(host); ip != nil {
+			ctx = withClientAddr(ctx, &net.TCPAddr{IP: ip})
+		}
+	}
+	m := dh.Handler.respond(ctx, query)
+	resp, err := m.Pack()
+	if err != nil {
+		http.Error(w, "failed to pack response", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", DoHContentType)
+	w.Header().Set("Content-Length", strconv.Itoa(len(resp)))
+	w.Header().Set("Cache-Control", fmt.Sprintf("max-age=%d", dh.maxAge(m)))
+	w.Write(resp)
+}
+
+// maxAge returns the number of seconds a HTTP cache may store the response m for
+func (dh *DoHHandler) maxAge(m *dns.Msg) int {
+	if m.Rcode != dns.RcodeSuccess && m.Rcode != dns.RcodeNameError {
+		return 0
+	}
+	clk := dh.clk
+	if clk == nil {
+		clk = clock.Default()
+	}
+	records := append(append([]dns.RR{}, m.Answer...), m.Ns...)
+	records = append(records, filterRRSet(m.Extra, dns.TypeOPT)...)
+	return minTTL(records, clk)
+}
+
+// DoHTransport is a Transport which sends queries to DNS-over-HTTPS (RFC 8484)
+// servers using POST requests. Client negotiates HTTP/2 or HTTP/1.1 with the
+// server as usual, if HTTP3 is also set then servers which advertise HTTP/3 on the
+// same port using Alt-Svc (RFC 7838) are queried using it instead. When a request
+// over HTTP3 fails it is retried using Client, and HTTP/3 isn't tried with that
+// server again until HTTP3Backoff has passed, so networks which block UDP only
+// cost a single failed request.
+//
+// The standard library has no HTTP/3 implementation, HTTP3 is typically the
+// RoundTripper from github.com/quic-go/quic-go/http3.
+//
+//	rr.Forward = &ForwardConfig{Servers: []string{"https://dns.google/dns-query"}}
+//	rr.Transport = &DoHTransport{HTTP3: &http3.RoundTripper{}}
+type DoHTransport struct {
+	// Client is used to send requests which aren't sent using HTTP3
+	Client *http.Client
+	// HTTP3, if set, is used to send requests to servers which support HTTP/3
+	HTTP3 http.RoundTripper
+	// PreferHTTP3 causes HTTP3 to be tried first with servers which haven't yet
+	// advertised HTTP/3 support, rather than after they have
+	PreferHTTP3 bool
+	// HTTP3Backoff is how long HTTP/3 isn't used with a server after a request over
+	// it failed, if zero DefaultHTTP3Backoff is used
+	HTTP3Backoff time.Duration
+
+	clk clock.Clock
+
+	mu sync.Mutex
+	// h3 contains the state of HTTP/3 support of each host:port
+	h3 map[string]http3State
+}
+
+type http3State struct {
+	// advertised is when the Alt-Svc advertisement of HTTP/3 support expires
+	advertised time.Time
+	// failed is when HTTP/3 can next be tried after a request over it failed
+	failed time.Time
+}
+
+// NewDoHTransport returns a DoHTransport that uses http.DefaultClient
+func NewDoHTransport() *DoHTransport {
+	return &DoHTransport{Client: http.DefaultClient, clk: clock.Default()}
+}
+
+// Exchange implements Transport, addr is the URL of the DoH endpoint
+func (dt *DoHTransport) Exchange(ctx context.Context, m *dns.Msg, addr string) (*dns.Msg, error) {
+	u, err := url.Parse(addr)
+	if err != nil {
+		return nil, err
+	}
+	// the ID is zero so responses can be cached by HTTP caches (RFC 8484 4.1)
+	q := m.Copy()
+	q.Id = 0
+	wire, err := q.Pack()
+	if err != nil {
+		return nil, err
+	}
+	host := u.Host
+	if u.Port() == "" {
+		host = net.JoinHostPort(u.Hostname(), "443")
+	}
+
+	var resp *http.Response
+	if dt.HTTP3 != nil && dt.useHTTP3(host) {
+		resp, err = dt.HTTP3.RoundTrip(newDoHRequest(ctx, u, wire))
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, err
+			}
+			dt.http3Failed(host)
+			resp = nil
+		}
+	}
+	if resp == nil {
+		client := dt.Client
+		if client == nil {
+			client = http.DefaultClient
+		}
+		if resp, err = client.Do(newDoHRequest(ctx, u, wire)); err != nil {
+			return nil, err
+		}
+	}
+	defer resp.Body.Close()
+	if dt.HTTP3 != nil {
+		dt.altSvc(host, resp.Header.Values("Alt-Svc"))
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%w: %s", ErrDoHStatus, resp.Status)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != DoHContentType {
+		return nil, fmt.Errorf("%w: unexpected content type %q", ErrDoHStatus, ct)
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxDoHMessageSize))
+	if err != nil {
+		return nil, err
+	}
+	r := new(dns.Msg)
+	if err = r.Unpack(body); err != nil {
+		return nil, err
+	}
+	r.Id = m.Id
+	return r, nil
+}
+
+func newDoHRequest(ctx context.Context, u *url.URL, wire []byte) *http.Request {
+	req := &http.Request{
+		Method: http.MethodPost,
+		URL:    u,
+		Host:   u.Host,
+		Header: http.Header{
+			"Content-Type": {DoHContentType},
+			"Accept":       {DoHContentType},
+		},
+		Body:          ioutil.NopCloser(bytes.NewReader(wire)),
+		ContentLength: int64(len(wire)),
+	}
+	return req.WithContext(ctx)
+}
+
+func (dt *DoHTransport) now() time.Time {
+	if dt.clk == nil {
+		return time.Now()
+	}
+	return dt.clk.Now()
+}
+
+// useHTTP3 returns true if HTTP/3 should be used to send requests to host
+func (dt *DoHTransport) useHTTP3(host string) bool {
+	dt.mu.Lock()
+	defer dt.mu.Unlock()
+	s := dt.h3[host]
+	now := dt.now()
+	if now.Before(s.failed) {
+		return false
+	}
+	return dt.PreferHTTP3 || now.Before(s.advertised)
+}
+
+// http3Failed stops HTTP/3 being used with host for HTTP3Backoff
+func (dt *DoHTransport) http3Failed(host string) {
+	backoff := dt.HTTP3Backoff
+	if backoff == 0 {
+		backoff = DefaultHTTP3Backoff
+	}
+	dt.mu.Lock()
+	defer dt.mu.Unlock()
+	if dt.h3 == nil {
+		dt.h3 = make(map[string]http3State)
+	}
+	s := dt.h3[host]
+	s.failed = dt.now().Add(backoff)
+	dt.h3[host] = s
+}
+
+// altSvc records whether the Alt-Svc header fields of a response from host
+// advertise HTTP/3 on the same port. Alternatives on other hosts or ports are
+// ignored, since HTTP3 would have to be pointed at them.
+func (dt *DoHTransport) altSvc(host string, fields []string) {
+	if len(fields) == 0 {
+		return
+	}
+	_, port, _ := net.SplitHostPort(host)
+	var advertised time.Time
+	now := dt.now()
+	for _, field := range fields {
+		for _, alt := range strings.Split(field, ",") {
+			params := strings.Split(alt, ";")
+			value := strings.TrimSpace(params[0])
+			if value == "clear" {
+				advertised = time.Time{}
+				continue
+			}
+			parts := strings.SplitN(value, "=", 2)
+			if len(parts) != 2 || parts[0] != "h3" {
+				continue
+			}
+			authority := strings.Trim(parts[1], `"`)
+			if authority != ":"+port && authority != host {
+				continue
+			}
+			maxAge := altSvcDefaultMaxAge
+			for _, p := range params[1:] {
+				kv := strings.SplitN(strings.TrimSpace(p), "=", 2)
+				if len(kv) == 2 && kv[0] == "ma" {
+					if secs, err := strconv.Atoi(strings.Trim(kv[1], `"`)); err == nil {
+						maxAge = time.Duration(secs) * time.Second
+					}
+				}
+			}
+			if expires := now.Add(maxAge); expires.After(advertised) {
+				advertised = expires
+			}
+		}
+	}
+	dt.mu.Lock()
+	defer dt.mu.Unlock()
+	if dt.h3 == nil {
+		dt.h3 = make(map[string]http3State)
+	}
+	s := dt.h3[host]
+	s.advertised = advertised
+	dt.h3[host] = s
+}
@@ -0,0 +1,201 @@
+package solvere
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/jmhodges/clock"
+	"github.com/miekg/dns"
+)
+
+func TestDoHHandler(t *testing.T) {
+	rr := &RecursiveResolver{c: new(dns.Client)}
+	rr.AddHooks(Hooks{
+		OnQuery: func(_ context.Context, q *Question) (*Answer, error) {
+			return &Answer{
+				Answer: []dns.RR{
+					&dns.A{Hdr: dns.RR_Header{Name: q.Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 300}, A: net.IP{1, 2, 3, 4}},
+					&dns.A{Hdr: dns.RR_Header{Name: q.Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60}, A: net.IP{1, 2, 3, 5}},
+				},
+				Rcode: dns.RcodeSuccess,
+			}, nil
+		},
+	})
+	server := httptest.NewServer(NewDoHHandler(rr))
+	defer server.Close()
+
+	m := new(dns.Msg)
+	m.SetQuestion("a.com.", dns.TypeA)
+	m.Id = 0
+	wire, err := m.Pack()
+	if err != nil {
+		t.Fatalf("Failed to pack query: %s", err)
+	}
+
+	check := func(resp *http.Response, err error) {
+		t.Helper()
+		if err != nil {
+			t.Fatalf("DoH request failed: %s", err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("DoH request returned unexpected status: %s", resp.Status)
+		}
+		if ct := resp.Header.Get("Content-Type"); ct != DoHContentType {
+			t.Fatalf("DoH response has unexpected content type: %s", ct)
+		}
+		if cc := resp.Header.Get("Cache-Control"); cc != "max-age=60" {
+			t.Fatalf("DoH response has unexpected cache control: %s", cc)
+		}
+		body, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatalf("Failed to read DoH response: %s", err)
+		}
+		r := new(dns.Msg)
+		if err = r.Unpack(body); err != nil {
+			t.Fatalf("Failed to unpack DoH response: %s", err)
+		}
+		if r.Id != 0 || len(r.Answer) != 2 {
+			t.Fatalf("Unexpected DoH response: %s", r)
+		}
+	}
+	check(http.Get(server.URL + "?dns=" + base64.RawURLEncoding.EncodeToString(wire)))
+	check(http.Post(server.URL, DoHContentType, bytes.NewReader(wire)))
+
+	for _, tc := range []struct {
+		method      string
+		url         string
+		contentType string
+		body        []byte
+		status      int
+	}{
+		{http.MethodGet, server.URL, "", nil, http.StatusBadRequest},
+		{http.MethodGet, server.URL + "?dns=!!!", "", nil, http.StatusBadRequest},
+		{http.MethodGet, server.URL + "?dns=AAAA", "", nil, http.StatusBadRequest},
+		{http.MethodPost, server.URL, "text/plain", wire, http.StatusUnsupportedMediaType},
+		{http.MethodPut, server.URL, DoHContentType, wire, http.StatusMethodNotAllowed},
+	} {
+		req, err := http.NewRequest(tc.method, tc.url, bytes.NewReader(tc.body))
+		if err != nil {
+			t.Fatalf("Failed to create request: %s", err)
+		}
+		if tc.contentType != "" {
+			req.Header.Set("Content-Type", tc.contentType)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("DoH request failed: %s", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != tc.status {
+			t.Fatalf("%s %s returned unexpected status: expected %d, got %d", tc.method, tc.url, tc.status, resp.StatusCode)
+		}
+	}
+}
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (rt roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return rt(req)
+}
+
+func TestDoHTransport(t *testing.T) {
+	var protos []int
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		protos = append(protos, r.ProtoMajor)
+		body, _ := ioutil.ReadAll(r.Body)
+		q := new(dns.Msg)
+		if r.Method != http.MethodPost || r.Header.Get("Content-Type") != DoHContentType || q.Unpack(body) != nil || q.Id != 0 {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		if q.Question[0].Name == "broken.example." {
+			http.Error(w, "broken", http.StatusInternalServerError)
+			return
+		}
+		m := new(dns.Msg)
+		m.SetReply(q)
+		m.Answer = []dns.RR{&dns.A{Hdr: dns.RR_Header{Name: q.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60}, A: net.IP{192, 0, 2, 1}}}
+		wire, _ := m.Pack()
+		_, port, _ := net.SplitHostPort(r.Host)
+		w.Header().Set("Alt-Svc", `h3-29=":443", h3=":`+port+`"; ma=60`)
+		w.Header().Set("Content-Type", DoHContentType)
+		w.Write(wire)
+	}))
+	srv.EnableHTTP2 = true
+	srv.StartTLS()
+	defer srv.Close()
+
+	fc := clock.NewFake()
+	h3Requests, h3Broken := 0, false
+	dt := &DoHTransport{
+		Client: srv.Client(),
+		HTTP3: roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			h3Requests++
+			if h3Broken {
+				return nil, errors.New("no recent network activity")
+			}
+			return srv.Client().Transport.RoundTrip(req)
+		}),
+		HTTP3Backoff: 30 * time.Second,
+		clk:          fc,
+	}
+	exchange := func(name string) (*dns.Msg, error) {
+		m := new(dns.Msg)
+		m.SetQuestion(name, dns.TypeA)
+		return dt.Exchange(context.Background(), m, srv.URL+"/dns-query")
+	}
+
+	r, err := exchange("example.")
+	if err != nil {
+		t.Fatalf("Exchange failed: %s", err)
+	}
+	if r.Id == 0 || len(r.Answer) != 1 || protos[0] != 2 || h3Requests != 0 {
+		t.Fatalf("Expected the first query to be sent over HTTP/2, got %s over HTTP/%d", r, protos[0])
+	}
+
+	// the server advertised HTTP/3 on the same port
+	if _, err = exchange("example."); err != nil {
+		t.Fatalf("Exchange failed: %s", err)
+	}
+	if h3Requests != 1 {
+		t.Fatal("Query wasn't sent using HTTP/3 after it was advertised")
+	}
+
+	// the advertisement expires
+	fc.Add(time.Minute)
+	exchange("example.")
+	if h3Requests != 1 {
+		t.Fatal("Query was sent using HTTP/3 after the advertisement expired")
+	}
+
+	// failed HTTP/3 requests fall back to HTTP/2 and back off
+	h3Broken = true
+	if _, err = exchange("example."); err != nil {
+		t.Fatalf("Exchange didn't fall back to HTTP/2: %s", err)
+	}
+	if h3Requests != 2 {
+		t.Fatalf("Expected HTTP/3 to be tried, got %d requests", h3Requests)
+	}
+	exchange("example.")
+	if h3Requests != 2 {
+		t.Fatal("HTTP/3 was tried again during the backoff")
+	}
+	fc.Add(dt.HTTP3Backoff)
+	exchange("example.")
+	if h3Requests != 3 {
+		t.Fatal("HTTP/3 wasn't tried again after the backoff")
+	}
+
+	if _, err = exchange("broken.example."); err == nil {
+		t.Fatal("Exchange didn't fail for a server error")
+	}
+}
@@ -0,0 +1,150 @@
+package solvere
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/miekg/dns"
+)
+
+// DoHJSONContentType is the media type of the JSON resolution API offered by
+// Google and Cloudflare
+const DoHJSONContentType = "application/dns-json"
+
+const maxDoHJSONSize = 1 << 20
+
+var ErrDoHJSONStatus = errors.New("solvere: DoH JSON API request failed")
+
+// DoHJSONTransport is a Transport which sends queries to a application/dns-json
+// resolution API, such as https://dns.google/resolve or
+// https://cloudflare-dns.com/dns-query, for environments where only HTTPS is
+// reachable. Since these APIs are recursive it is intended to be used in forwarding
+// mode with the endpoint URLs as the forwarder addresses, i.e.
+//
+//	rr.Forward = &ForwardConfig{Servers: []string{"https://dns.google/resolve"}}
+//	rr.Transport = NewDoHJSONTransport()
+//
+// The DO and CD bits of queries are passed through so responses can still be
+// validated.
+type DoHJSONTransport struct {
+	Client *http.Client
+}
+
+// NewDoHJSONTransport returns a DoHJSONTransport that uses http.DefaultClient
+func NewDoHJSONTransport() *DoHJSONTransport {
+	return &DoHJSONTransport{Client: http.DefaultClient}
+}
+
+type dohJSONQuestion struct {
+	Name string `json:"name"`
+	Type uint16 `json:"type"`
+}
+
+type dohJSONRR struct {
+	Name string `json:"name"`
+	Type uint16 `json:"type"`
+	TTL  uint32 `json:"TTL"`
+	Data string `json:"data"`
+}
+
+type dohJSONResponse struct {
+	Status     int
+	TC         bool
+	RD         bool
+	RA         bool
+	AD         bool
+	CD         bool
+	Question   []dohJSONQuestion
+	Answer     []dohJSONRR
+	Authority  []dohJSONRR
+	Additional []dohJSONRR
+}
+
+// Exchange implements Transport, addr is the URL of the API endpoint
+func (dt *DoHJSONTransport) Exchange(ctx context.Context, m *dns.Msg, addr string) (*dns.Msg, error) {
+	if len(m.Question) != 1 {
+		return nil, fmt.Errorf("%w: exactly one question is required", ErrDoHJSONStatus)
+	}
+	u, err := url.Parse(addr)
+	if err != nil {
+		return nil, err
+	}
+	params := u.Query()
+	params.Set("name", m.Question[0].Name)
+	params.Set("type", strconv.Itoa(int(m.Question[0].Qtype)))
+	if opt := m.IsEdns0(); opt != nil && opt.Do() {
+		params.Set("do", "1")
+	}
+	if m.CheckingDisabled {
+		params.Set("cd", "1")
+	}
+	u.RawQuery = params.Encode()
+	req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", DoHJSONContentType)
+	client := dt.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%w: %s", ErrDoHJSONStatus, resp.Status)
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxDoHJSONSize))
+	if err != nil {
+		return nil, err
+	}
+	var jr dohJSONResponse
+	if err = json.Unmarshal(body, &jr); err != nil {
+		return nil, err
+	}
+	return jr.msg(m)
+}
+
+// msg converts the API response to a response message for the query m
+func (jr *dohJSONResponse) msg(m *dns.Msg) (*dns.Msg, error) {
+	r := new(dns.Msg)
+	r.SetReply(m)
+	r.Rcode = jr.Status
+	r.Truncated = jr.TC
+	r.RecursionDesired = jr.RD
+	r.RecursionAvailable = jr.RA
+	r.AuthenticatedData = jr.AD
+	r.CheckingDisabled = jr.CD
+	var err error
+	for _, s := range []struct {
+		records []dohJSONRR
+		section *[]dns.RR
+	}{{jr.Answer, &r.Answer}, {jr.Authority, &r.Ns}, {jr.Additional, &r.Extra}} {
+		for _, record := range s.records {
+			var parsed dns.RR
+			if parsed, err = record.rr(); err != nil {
+				return nil, err
+			}
+			*s.section = append(*s.section, parsed)
+		}
+	}
+	return r, nil
+}
+
+// rr parses the record, the data member contains the RDATA in presentation format
+func (jr dohJSONRR) rr() (dns.RR, error) {
+	t, present := dns.TypeToString[jr.Type]
+	if !present {
+		t = fmt.Sprintf("TYPE%d", jr.Type)
+	}
+	return dns.NewRR(fmt.Sprintf("%s %d IN %s %s", dns.Fqdn(jr.Name), jr.TTL, t, jr.Data))
+}
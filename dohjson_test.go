@@ -0,0 +1,78 @@
+package solvere
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestDoHJSONTransport(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Accept") != DoHJSONContentType {
+			http.Error(w, "bad accept header", http.StatusBadRequest)
+			return
+		}
+		params := r.URL.Query()
+		if params.Get("key") != "value" || params.Get("do") != "1" || params.Get("cd") != "1" {
+			http.Error(w, "bad parameters", http.StatusBadRequest)
+			return
+		}
+		switch params.Get("name") {
+		case "example.com.":
+			w.Write([]byte(`{"Status": 0, "TC": false, "RD": true, "RA": true, "AD": true, "CD": true,
+				"Question": [{"name": "example.com.", "type": 1}],
+				"Answer": [
+					{"name": "example.com.", "type": 5, "TTL": 60, "data": "www.example.com."},
+					{"name": "www.example.com", "type": 1, "TTL": 60, "data": "192.0.2.1"}
+				]}`))
+		case "missing.example.com.":
+			w.Write([]byte(`{"Status": 3, "RA": true,
+				"Authority": [{"name": "example.com.", "type": 6, "TTL": 300, "data": "ns.example.com. hostmaster.example.com. 1 7200 900 1209600 300"}]}`))
+		default:
+			http.Error(w, "broken", http.StatusInternalServerError)
+		}
+	}))
+	defer srv.Close()
+
+	dt := NewDoHJSONTransport()
+	m := new(dns.Msg)
+	m.SetQuestion("example.com.", dns.TypeA)
+	m.SetEdns0(1232, true)
+	m.CheckingDisabled = true
+	r, err := dt.Exchange(context.Background(), m, srv.URL+"?key=value")
+	if err != nil {
+		t.Fatalf("Exchange failed: %s", err)
+	}
+	if r.Id != m.Id || !r.Response || !r.AuthenticatedData || len(r.Answer) != 2 || r.Answer[1].(*dns.A).A.String() != "192.0.2.1" {
+		t.Fatalf("Unexpected response: %s", r)
+	}
+
+	m.SetQuestion("missing.example.com.", dns.TypeA)
+	r, err = dt.Exchange(context.Background(), m, srv.URL+"?key=value")
+	if err != nil {
+		t.Fatalf("Exchange failed: %s", err)
+	}
+	if r.Rcode != dns.RcodeNameError || len(r.Ns) != 1 {
+		t.Fatalf("Unexpected response: %s", r)
+	}
+
+	m.SetQuestion("broken.example.com.", dns.TypeA)
+	if _, err = dt.Exchange(context.Background(), m, srv.URL+"?key=value"); err == nil {
+		t.Fatal("Exchange didn't fail with a HTTP error")
+	}
+
+	// the transport can be used as the upstream for forwarding mode
+	rr := NewRecursiveResolver(false, false, nil, nil, nil)
+	rr.Forward = &ForwardConfig{Servers: []string{srv.URL + "?key=value&do=1&cd=1"}}
+	rr.Transport = dt
+	a, _, err := rr.Lookup(context.Background(), Question{Name: "example.com.", Type: dns.TypeA})
+	if err != nil {
+		t.Fatalf("Lookup failed: %s", err)
+	}
+	if len(a.Answer) != 2 {
+		t.Fatalf("Unexpected answer: %v", a.Answer)
+	}
+}
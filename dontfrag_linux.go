@@ -0,0 +1,29 @@
+//go:build linux
+
+package solvere
+
+import "syscall"
+
+// setDontFragment sets the don't fragment bit on the packets sent on the socket c.
+// IP_PMTUDISC_PROBE is used rather than IP_PMTUDISC_DO so the path MTU learnt from
+// ICMP messages, which can be forged, is ignored and packets up to the interface
+// MTU are always sent. Sockets bound to IPv6 addresses may be dual stack so the
+// option is set for both address families, the IPv4 one failing is ignored.
+func setDontFragment(c syscall.RawConn, ipv6 bool) error {
+	var serr error
+	err := c.Control(func(fd uintptr) {
+		if ipv6 {
+			serr = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IPV6, syscall.IPV6_MTU_DISCOVER, syscall.IPV6_PMTUDISC_PROBE)
+			if serr != nil {
+				return
+			}
+		}
+		if ierr := syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IP, syscall.IP_MTU_DISCOVER, syscall.IP_PMTUDISC_PROBE); !ipv6 {
+			serr = ierr
+		}
+	})
+	if err != nil {
+		return err
+	}
+	return serr
+}
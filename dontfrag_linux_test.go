@@ -0,0 +1,79 @@
+//go:build linux
+
+package solvere
+
+import (
+	"net"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func mtuDiscover(t *testing.T, c interface{}, level, opt int) int {
+	t.Helper()
+	rc, err := c.(syscall.Conn).SyscallConn()
+	if err != nil {
+		t.Fatalf("Failed to get raw connection: %s", err)
+	}
+	var v int
+	var serr error
+	rc.Control(func(fd uintptr) {
+		v, serr = syscall.GetsockoptInt(int(fd), level, opt)
+	})
+	if serr != nil {
+		t.Fatalf("getsockopt failed: %s", serr)
+	}
+	return v
+}
+
+func TestDontFragment(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %s", err)
+	}
+	defer pc.Close()
+
+	ut := NewUDPPoolTransport()
+	defer ut.Close()
+	co, err := ut.get(pc.LocalAddr().String(), time.Now().Add(time.Second))
+	if err != nil {
+		t.Fatalf("Failed to dial: %s", err)
+	}
+	defer co.Close()
+	if v := mtuDiscover(t, co.Conn, syscall.IPPROTO_IP, syscall.IP_MTU_DISCOVER); v != syscall.IP_PMTUDISC_PROBE {
+		t.Fatalf("Query socket has IP_MTU_DISCOVER %d", v)
+	}
+
+	ut.DontFragment = false
+	co, err = ut.get(pc.LocalAddr().String(), time.Now().Add(time.Second))
+	if err != nil {
+		t.Fatalf("Failed to dial: %s", err)
+	}
+	defer co.Close()
+	if v := mtuDiscover(t, co.Conn, syscall.IPPROTO_IP, syscall.IP_MTU_DISCOVER); v == syscall.IP_PMTUDISC_PROBE {
+		t.Fatal("Don't fragment was set on query socket when disabled")
+	}
+
+	if err = dontFragmentPacketConn(pc); err != nil {
+		t.Fatalf("Failed to set don't fragment on listener: %s", err)
+	}
+	if v := mtuDiscover(t, pc, syscall.IPPROTO_IP, syscall.IP_MTU_DISCOVER); v != syscall.IP_PMTUDISC_PROBE {
+		t.Fatalf("Listener has IP_MTU_DISCOVER %d", v)
+	}
+
+	// wildcard listeners are dual stack IPv6 sockets
+	pc6, err := net.ListenPacket("udp", ":0")
+	if err != nil {
+		t.Skipf("Failed to listen on wildcard address: %s", err)
+	}
+	defer pc6.Close()
+	if _, ok := pc6.LocalAddr().(*net.UDPAddr); !ok || pc6.LocalAddr().(*net.UDPAddr).IP.To4() != nil {
+		t.Skip("Wildcard listener isn't IPv6")
+	}
+	if err = dontFragmentPacketConn(pc6); err != nil {
+		t.Fatalf("Failed to set don't fragment on IPv6 listener: %s", err)
+	}
+	if v := mtuDiscover(t, pc6, syscall.IPPROTO_IPV6, syscall.IPV6_MTU_DISCOVER); v != syscall.IPV6_PMTUDISC_PROBE {
+		t.Fatalf("IPv6 listener has IPV6_MTU_DISCOVER %d", v)
+	}
+}
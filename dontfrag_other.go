@@ -0,0 +1,11 @@
+//go:build !linux
+
+package solvere
+
+import "syscall"
+
+// setDontFragment does nothing as the syscall package doesn't expose the don't
+// fragment socket options on this platform
+func setDontFragment(c syscall.RawConn, ipv6 bool) error {
+	return nil
+}
@@ -0,0 +1,171 @@
+package solvere
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// ErrNoDTLSDialer is returned by DTLSTransport when Dial isn't set
+var ErrNoDTLSDialer = errors.New("solvere: DTLSTransport has no Dial function")
+
+// DTLSTransport is a Transport which sends queries over DNS over DTLS (RFC 8094),
+// for networks where TCP based encrypted transports are blocked but UDP to port
+// 853 isn't. Each query and response is carried in a single DTLS record, so
+// queries should advertise an EDNS buffer size which fits in the path MTU after
+// the DTLS overhead, DefaultEDNSBufferSize does on almost all paths. Responses
+// which are truncated are retried using Fallback, as RFC 8094 3.2 recommends.
+//
+// The standard library has no DTLS implementation, so sessions are established
+// using Dial, typically a wrapper around github.com/pion/dtls's Dial. Sessions are
+// kept after use and reused, as the handshake is expensive, and sessions which
+// fail are closed.
+//
+//	rr.Forward = &ForwardConfig{Servers: []string{"192.0.2.53:853"}}
+//	rr.Transport = &DTLSTransport{Dial: func(ctx context.Context, addr string) (net.Conn, error) {
+//		raddr, err := net.ResolveUDPAddr("udp", addr)
+//		if err != nil {
+//			return nil, err
+//		}
+//		return dtls.DialWithContext(ctx, "udp", raddr, &dtls.Config{ServerName: "dns.example"})
+//	}}
+type DTLSTransport struct {
+	// Dial establishes a DTLS session with the nameserver at addr
+	Dial func(ctx context.Context, addr string) (net.Conn, error)
+	// Fallback is used to ask the question again when a response is truncated, if
+	// nil a DNS over TLS ClientTransport is used
+	Fallback Transport
+	// MaxIdlePerServer is the maximum number of idle sessions kept for a single
+	// nameserver, if zero DefaultMaxIdleSockets is used
+	MaxIdlePerServer int
+	// Timeout is used when the context passed to Exchange has no deadline, if zero
+	// DefaultUDPTimeout is used
+	Timeout time.Duration
+
+	mu     sync.Mutex
+	idle   map[string][]net.Conn
+	closed bool
+}
+
+func (dt *DTLSTransport) maxIdle() int {
+	if dt.MaxIdlePerServer > 0 {
+		return dt.MaxIdlePerServer
+	}
+	return DefaultMaxIdleSockets
+}
+
+func (dt *DTLSTransport) timeout() time.Duration {
+	if dt.Timeout > 0 {
+		return dt.Timeout
+	}
+	return DefaultUDPTimeout
+}
+
+// get returns an idle session with addr, or establishes a new one
+func (dt *DTLSTransport) get(ctx context.Context, addr string) (net.Conn, error) {
+	dt.mu.Lock()
+	if conns := dt.idle[addr]; len(conns) > 0 {
+		c := conns[len(conns)-1]
+		if len(conns) == 1 {
+			delete(dt.idle, addr)
+		} else {
+			dt.idle[addr] = conns[:len(conns)-1]
+		}
+		dt.mu.Unlock()
+		return c, nil
+	}
+	dt.mu.Unlock()
+	if dt.Dial == nil {
+		return nil, ErrNoDTLSDialer
+	}
+	return dt.Dial(ctx, addr)
+}
+
+// put returns a session to the pool, or closes it if the pool is full
+func (dt *DTLSTransport) put(addr string, c net.Conn) {
+	dt.mu.Lock()
+	defer dt.mu.Unlock()
+	if dt.closed || len(dt.idle[addr]) >= dt.maxIdle() {
+		c.Close()
+		return
+	}
+	if dt.idle == nil {
+		dt.idle = make(map[string][]net.Conn)
+	}
+	dt.idle[addr] = append(dt.idle[addr], c)
+}
+
+// Exchange implements Transport
+func (dt *DTLSTransport) Exchange(ctx context.Context, m *dns.Msg, addr string) (*dns.Msg, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(dt.timeout())
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, deadline)
+		defer cancel()
+	}
+	wire, err := m.Pack()
+	if err != nil {
+		return nil, err
+	}
+	c, err := dt.get(ctx, addr)
+	if err != nil {
+		return nil, err
+	}
+	c.SetDeadline(deadline)
+	if _, err = c.Write(wire); err != nil {
+		c.Close()
+		return nil, err
+	}
+	buf := make([]byte, dns.MaxMsgSize)
+	for {
+		n, err := c.Read(buf)
+		if err != nil {
+			c.Close()
+			return nil, err
+		}
+		r := new(dns.Msg)
+		if err = r.Unpack(buf[:n]); err != nil && err != dns.ErrTruncated {
+			c.Close()
+			return nil, err
+		}
+		// a response to an earlier query which timed out may still arrive
+		if !responseMatches(m, r) {
+			continue
+		}
+		dt.put(addr, c)
+		if err == dns.ErrTruncated || r.Truncated {
+			return dt.fallback().Exchange(ctx, m, addr)
+		}
+		return r, nil
+	}
+}
+
+func (dt *DTLSTransport) fallback() Transport {
+	if dt.Fallback != nil {
+		return dt.Fallback
+	}
+	return NewClientTransport("tcp-tls")
+}
+
+// Close closes all idle sessions, sessions in use are closed once their exchange
+// completes
+func (dt *DTLSTransport) Close() error {
+	dt.mu.Lock()
+	defer dt.mu.Unlock()
+	for _, conns := range dt.idle {
+		for _, c := range conns {
+			c.Close()
+		}
+	}
+	dt.idle = nil
+	dt.closed = true
+	return nil
+}
@@ -0,0 +1,89 @@
+package solvere
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestDTLSTransport(t *testing.T) {
+	dials := 0
+	dt := &DTLSTransport{
+		Dial: func(_ context.Context, addr string) (net.Conn, error) {
+			if addr != "192.0.2.53:853" {
+				t.Fatalf("Unexpected address %s", addr)
+			}
+			dials++
+			client, server := net.Pipe()
+			go func() {
+				defer server.Close()
+				buf := make([]byte, dns.MaxMsgSize)
+				for {
+					n, err := server.Read(buf)
+					if err != nil {
+						return
+					}
+					q := new(dns.Msg)
+					if err = q.Unpack(buf[:n]); err != nil {
+						return
+					}
+					// a stale response to an earlier query is sent first
+					stale := new(dns.Msg)
+					stale.SetReply(q)
+					stale.Id = q.Id + 1
+					m := new(dns.Msg)
+					m.SetReply(q)
+					if q.Question[0].Name == "big.example." {
+						m.Truncated = true
+					} else {
+						m.Answer = []dns.RR{&dns.A{Hdr: dns.RR_Header{Name: q.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60}, A: net.IP{192, 0, 2, 1}}}
+					}
+					for _, r := range []*dns.Msg{stale, m} {
+						wire, _ := r.Pack()
+						if _, err = server.Write(wire); err != nil {
+							return
+						}
+					}
+				}
+			}()
+			return client, nil
+		},
+	}
+	var fellBack bool
+	dt.Fallback = transportFunc(func(_ context.Context, m *dns.Msg, _ string) (*dns.Msg, error) {
+		fellBack = true
+		r := new(dns.Msg)
+		r.SetReply(m)
+		return r, nil
+	})
+	defer dt.Close()
+
+	m := new(dns.Msg)
+	for i := 0; i < 2; i++ {
+		m.SetQuestion("example.", dns.TypeA)
+		r, err := dt.Exchange(context.Background(), m, "192.0.2.53:853")
+		if err != nil {
+			t.Fatalf("Exchange failed: %s", err)
+		}
+		if r.Id != m.Id || len(r.Answer) != 1 {
+			t.Fatalf("Unexpected response: %s", r)
+		}
+	}
+	if dials != 1 {
+		t.Fatalf("Expected the session to be reused, dialed %d times", dials)
+	}
+
+	m.SetQuestion("big.example.", dns.TypeA)
+	if _, err := dt.Exchange(context.Background(), m, "192.0.2.53:853"); err != nil {
+		t.Fatalf("Exchange failed: %s", err)
+	}
+	if !fellBack {
+		t.Fatal("Truncated response wasn't retried using the fallback transport")
+	}
+
+	if _, err := (&DTLSTransport{}).Exchange(context.Background(), m, "192.0.2.53:853"); err != ErrNoDTLSDialer {
+		t.Fatalf("Expected ErrNoDTLSDialer, got %v", err)
+	}
+}
@@ -0,0 +1,96 @@
+package solvere
+
+import (
+	"context"
+	"net"
+
+	"github.com/miekg/dns"
+)
+
+type clientSubnetKey struct{}
+
+// WithClientSubnet returns a copy of ctx which causes lookups using it to include
+// a EDNS Client Subnet option (RFC 7871) for subnet in queries sent upstream. The
+// prefix length of subnet is sent as is, so it should already be truncated to
+// protect the privacy of the client (i.e. to /24 or /56).
+func WithClientSubnet(ctx context.Context, subnet *net.IPNet) context.Context {
+	return context.WithValue(ctx, clientSubnetKey{}, subnet)
+}
+
+// clientSubnet returns the subnet set by WithClientSubnet, or nil
+func clientSubnet(ctx context.Context) *net.IPNet {
+	subnet, _ := ctx.Value(clientSubnetKey{}).(*net.IPNet)
+	return subnet
+}
+
+// addClientSubnet adds the EDNS Client Subnet option for subnet to the OPT record
+// of m
+func addClientSubnet(m *dns.Msg, subnet *net.IPNet) {
+	opt := m.IsEdns0()
+	if opt == nil {
+		return
+	}
+	ones, _ := subnet.Mask.Size()
+	e := &dns.EDNS0_SUBNET{Code: dns.EDNS0SUBNET, SourceNetmask: uint8(ones)}
+	if ip4 := subnet.IP.To4(); ip4 != nil {
+		e.Family, e.Address = 1, ip4.Mask(subnet.Mask)
+	} else {
+		e.Family, e.Address = 2, subnet.IP.Mask(subnet.Mask)
+	}
+	opt.Option = append(opt.Option, e)
+}
+
+// responseScope returns the network the answer in r applies to, as described by
+// the scope prefix length of its EDNS Client Subnet option, or nil if the answer
+// applies to all clients
+func responseScope(subnet *net.IPNet, r *dns.Msg) *net.IPNet {
+	if subnet == nil {
+		return nil
+	}
+	opt := r.IsEdns0()
+	if opt == nil {
+		return nil
+	}
+	for _, o := range opt.Option {
+		e, ok := o.(*dns.EDNS0_SUBNET)
+		if !ok || e.SourceScope == 0 {
+			continue
+		}
+		bits := 8 * net.IPv6len
+		ip := subnet.IP
+		if ip4 := ip.To4(); ip4 != nil {
+			bits, ip = 8*net.IPv4len, ip4
+		}
+		scope := int(e.SourceScope)
+		if scope > bits {
+			scope = bits
+		}
+		mask := net.CIDRMask(scope, bits)
+		return &net.IPNet{IP: ip.Mask(mask), Mask: mask}
+	}
+	return nil
+}
+
+// addToCache caches a answer that was extracted from r, answers tailored to the
+// client subnet of the lookup are only cached if the cache supports scoped answers
+func (rr *RecursiveResolver) addToCache(ctx context.Context, q *Question, a *Answer, r *dns.Msg) {
+	scope := responseScope(clientSubnet(ctx), r)
+	if scope == nil {
+		rr.cache.Add(q, a, false)
+		return
+	}
+	if sc, ok := rr.cache.(ScopedCache); ok {
+		sc.AddScoped(q, a, scope)
+	}
+}
+
+// getFromCache returns the cached answer for q that applies to the client subnet
+// of the lookup
+func (rr *RecursiveResolver) getFromCache(ctx context.Context, q *Question) *Answer {
+	if subnet := clientSubnet(ctx); subnet != nil {
+		if sc, ok := rr.cache.(ScopedCache); ok {
+			return sc.GetScoped(q, subnet.IP)
+		}
+	}
+	return rr.cache.Get(q)
+}
@@ -0,0 +1,114 @@
+package solvere
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/jmhodges/clock"
+	"github.com/miekg/dns"
+)
+
+func mustCIDR(t *testing.T, s string) *net.IPNet {
+	t.Helper()
+	_, n, err := net.ParseCIDR(s)
+	if err != nil {
+		t.Fatalf("Failed to parse %s: %s", s, err)
+	}
+	return n
+}
+
+func TestScopedCache(t *testing.T) {
+	cache := &BasicCache{clk: clock.NewFake()}
+	q := &Question{Name: "example.", Type: dns.TypeA}
+	answer := func(ip net.IP) *Answer {
+		return &Answer{Answer: []dns.RR{&dns.A{Hdr: dns.RR_Header{Ttl: 60}, A: ip}}}
+	}
+	global, wide, narrow := answer(net.IP{1, 1, 1, 1}), answer(net.IP{2, 2, 2, 2}), answer(net.IP{3, 3, 3, 3})
+	cache.AddScoped(q, global, mustCIDR(t, "0.0.0.0/0"))
+	cache.AddScoped(q, wide, mustCIDR(t, "10.0.0.0/8"))
+	cache.AddScoped(q, narrow, mustCIDR(t, "10.1.0.0/16"))
+
+	for client, expected := range map[string]*Answer{
+		"10.1.2.3":    narrow,
+		"10.2.2.3":    wide,
+		"192.168.1.1": global,
+		"2001:db8::1": global,
+	} {
+		if a := cache.GetScoped(q, net.ParseIP(client)); !isCachedAnswer(a, expected) {
+			t.Errorf("Wrong answer for %s: %v", client, a)
+		}
+	}
+	if a := cache.Get(q); !isCachedAnswer(a, global) {
+		t.Fatalf("Get returned a scoped answer: %v", a)
+	}
+}
+
+func TestResponseScope(t *testing.T) {
+	r := new(dns.Msg)
+	r.SetEdns0(4096, false)
+	subnet := mustCIDR(t, "192.0.2.0/24")
+	if scope := responseScope(subnet, r); scope != nil {
+		t.Fatalf("Response without ECS has scope %s", scope)
+	}
+	opt := r.IsEdns0()
+	opt.Option = append(opt.Option, &dns.EDNS0_SUBNET{Code: dns.EDNS0SUBNET, Family: 1, SourceNetmask: 24, SourceScope: 16, Address: net.IP{192, 0, 2, 0}})
+	if scope := responseScope(subnet, r); scope == nil || scope.String() != "192.0.0.0/16" {
+		t.Fatalf("Unexpected scope %s", scope)
+	}
+	if scope := responseScope(nil, r); scope != nil {
+		t.Fatalf("Lookup without a client subnet has scope %s", scope)
+	}
+}
+
+func TestClientSubnetLookup(t *testing.T) {
+	cache := NewBasicCache()
+	rr := NewRecursiveResolver(false, false, nil, nil, cache)
+	rr.Forward = &ForwardConfig{Servers: []string{"10.0.0.1"}}
+	queries := 0
+	rr.Transport = transportFunc(func(_ context.Context, m *dns.Msg, _ string) (*dns.Msg, error) {
+		queries++
+		var e *dns.EDNS0_SUBNET
+		if opt := m.IsEdns0(); opt != nil {
+			for _, o := range opt.Option {
+				e, _ = o.(*dns.EDNS0_SUBNET)
+			}
+		}
+		if e == nil {
+			t.Fatal("Query doesn't include a client subnet")
+		}
+		r := new(dns.Msg)
+		r.SetReply(m)
+		r.SetEdns0(4096, false)
+		r.IsEdns0().Option = []dns.EDNS0{&dns.EDNS0_SUBNET{Code: dns.EDNS0SUBNET, Family: 1, SourceNetmask: e.SourceNetmask, SourceScope: 24, Address: e.Address}}
+		r.Answer = []dns.RR{&dns.A{Hdr: dns.RR_Header{Name: m.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60}, A: e.Address}}
+		return r, nil
+	})
+
+	q := Question{Name: "example.", Type: dns.TypeA}
+	lookup := func(subnet string) *Answer {
+		a, _, err := rr.Lookup(WithClientSubnet(context.Background(), mustCIDR(t, subnet)), q)
+		if err != nil {
+			t.Fatalf("Lookup failed: %s", err)
+		}
+		return a
+	}
+	if a := lookup("192.0.2.0/24"); a.Answer[0].(*dns.A).A.String() != "192.0.2.0" {
+		t.Fatalf("Unexpected answer: %v", a.Answer)
+	}
+	// answers are added to the cache asynchronously
+	for i := 0; i < 100 && cache.GetScoped(&q, net.IP{192, 0, 2, 1}) == nil; i++ {
+		time.Sleep(time.Millisecond)
+	}
+	if a := lookup("198.51.100.0/24"); a.Answer[0].(*dns.A).A.String() != "198.51.100.0" {
+		t.Fatalf("Answer for another subnet was served: %v", a.Answer)
+	}
+	if queries != 2 {
+		t.Fatalf("Expected 2 queries, sent %d", queries)
+	}
+	lookup("192.0.2.0/24")
+	if queries != 2 {
+		t.Fatal("Scoped answer wasn't served from the cache")
+	}
+}
@@ -0,0 +1,132 @@
+package solvere
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/miekg/dns"
+)
+
+// EDNS0EDE is the EDNS0 option code for Extended DNS Errors (RFC 8914)
+const EDNS0EDE = 15
+
+// Extended DNS Error INFO-CODEs, RFC 8914 Section 4
+const (
+	ExtendedErrorOther uint16 = iota
+	ExtendedErrorUnsupportedDNSKEYAlgorithm
+	ExtendedErrorUnsupportedDSDigestType
+	ExtendedErrorStaleAnswer
+	ExtendedErrorForgedAnswer
+	ExtendedErrorDNSSECIndeterminate
+	ExtendedErrorDNSSECBogus
+	ExtendedErrorSignatureExpired
+	ExtendedErrorSignatureNotYetValid
+	ExtendedErrorDNSKEYMissing
+	ExtendedErrorRRSIGsMissing
+	ExtendedErrorNoZoneKeyBitSet
+	ExtendedErrorNSECMissing
+	ExtendedErrorCachedError
+	ExtendedErrorNotReady
+	ExtendedErrorBlocked
+	ExtendedErrorCensored
+	ExtendedErrorFiltered
+	ExtendedErrorProhibited
+	ExtendedErrorStaleNXDOMAINAnswer
+	ExtendedErrorNotAuthoritative
+	ExtendedErrorNotSupported
+	ExtendedErrorNoReachableAuthority
+	ExtendedErrorNetworkError
+	ExtendedErrorInvalidData
+)
+
+var extendedErrorCodeToString = map[uint16]string{
+	ExtendedErrorOther:                      "Other",
+	ExtendedErrorUnsupportedDNSKEYAlgorithm: "Unsupported DNSKEY Algorithm",
+	ExtendedErrorUnsupportedDSDigestType:    "Unsupported DS Digest Type",
+	ExtendedErrorStaleAnswer:                "Stale Answer",
+	ExtendedErrorForgedAnswer:               "Forged Answer",
+	ExtendedErrorDNSSECIndeterminate:        "DNSSEC Indeterminate",
+	ExtendedErrorDNSSECBogus:                "DNSSEC Bogus",
+	ExtendedErrorSignatureExpired:           "Signature Expired",
+	ExtendedErrorSignatureNotYetValid:       "Signature Not Yet Valid",
+	ExtendedErrorDNSKEYMissing:              "DNSKEY Missing",
+	ExtendedErrorRRSIGsMissing:              "RRSIGs Missing",
+	ExtendedErrorNoZoneKeyBitSet:            "No Zone Key Bit Set",
+	ExtendedErrorNSECMissing:                "NSEC Missing",
+	ExtendedErrorCachedError:                "Cached Error",
+	ExtendedErrorNotReady:                   "Not Ready",
+	ExtendedErrorBlocked:                    "Blocked",
+	ExtendedErrorCensored:                   "Censored",
+	ExtendedErrorFiltered:                   "Filtered",
+	ExtendedErrorProhibited:                 "Prohibited",
+	ExtendedErrorStaleNXDOMAINAnswer:        "Stale NXDOMAIN Answer",
+	ExtendedErrorNotAuthoritative:           "Not Authoritative",
+	ExtendedErrorNotSupported:               "Not Supported",
+	ExtendedErrorNoReachableAuthority:       "No Reachable Authority",
+	ExtendedErrorNetworkError:               "Network Error",
+	ExtendedErrorInvalidData:                "Invalid Data",
+}
+
+// ExtendedError is a Extended DNS Error (RFC 8914) received from a remote
+// nameserver
+type ExtendedError struct {
+	InfoCode  uint16
+	ExtraText string `json:",omitempty"`
+}
+
+func (ee ExtendedError) String() string {
+	name, present := extendedErrorCodeToString[ee.InfoCode]
+	if !present {
+		name = "Unknown"
+	}
+	if ee.ExtraText != "" {
+		return fmt.Sprintf("%s (%d): %s", name, ee.InfoCode, ee.ExtraText)
+	}
+	return fmt.Sprintf("%s (%d)", name, ee.InfoCode)
+}
+
+// Filtering returns true if the error indicates the upstream deliberately
+// withheld the answer (Blocked, Censored, Filtered, or Prohibited)
+func (ee ExtendedError) Filtering() bool {
+	switch ee.InfoCode {
+	case ExtendedErrorBlocked, ExtendedErrorCensored, ExtendedErrorFiltered, ExtendedErrorProhibited:
+		return true
+	}
+	return false
+}
+
+// DNSSEC returns true if the error indicates a DNSSEC validation failure
+func (ee ExtendedError) DNSSEC() bool {
+	switch ee.InfoCode {
+	case ExtendedErrorUnsupportedDNSKEYAlgorithm, ExtendedErrorUnsupportedDSDigestType,
+		ExtendedErrorDNSSECIndeterminate, ExtendedErrorDNSSECBogus, ExtendedErrorSignatureExpired,
+		ExtendedErrorSignatureNotYetValid, ExtendedErrorDNSKEYMissing, ExtendedErrorRRSIGsMissing,
+		ExtendedErrorNoZoneKeyBitSet, ExtendedErrorNSECMissing:
+		return true
+	}
+	return false
+}
+
+// extractExtendedErrors returns any Extended DNS Errors in the OPT record of m,
+// malformed options are ignored
+func extractExtendedErrors(m *dns.Msg) []ExtendedError {
+	if m == nil {
+		return nil
+	}
+	opt := m.IsEdns0()
+	if opt == nil {
+		return nil
+	}
+	var errs []ExtendedError
+	for _, o := range opt.Option {
+		local, ok := o.(*dns.EDNS0_LOCAL)
+		if !ok || local.Code != EDNS0EDE || len(local.Data) < 2 {
+			continue
+		}
+		errs = append(errs, ExtendedError{
+			InfoCode:  binary.BigEndian.Uint16(local.Data),
+			ExtraText: string(local.Data[2:]),
+		})
+	}
+	return errs
+}
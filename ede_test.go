@@ -0,0 +1,64 @@
+package solvere
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestExtractExtendedErrors(t *testing.T) {
+	if extractExtendedErrors(nil) != nil {
+		t.Fatal("extractExtendedErrors returned errors for a nil message")
+	}
+	m := new(dns.Msg)
+	if extractExtendedErrors(m) != nil {
+		t.Fatal("extractExtendedErrors returned errors for a message without a OPT record")
+	}
+	m.SetEdns0(4096, true)
+	opt := m.IsEdns0()
+	opt.Option = append(opt.Option,
+		&dns.EDNS0_LOCAL{Code: EDNS0EDE, Data: []byte{0, 15, 'a', 'd', 's'}},
+		&dns.EDNS0_LOCAL{Code: EDNS0EDE, Data: []byte{0}}, // malformed
+		&dns.EDNS0_LOCAL{Code: EDNS0EDE, Data: []byte{0, 6}},
+		&dns.EDNS0_LOCAL{Code: 0xfde9, Data: []byte{0, 1}},
+	)
+	// round trip through the wire format to make sure the vendored dns
+	// package returns unknown options as EDNS0_LOCAL
+	b, err := m.Pack()
+	if err != nil {
+		t.Fatalf("Failed to pack message: %s", err)
+	}
+	m = new(dns.Msg)
+	if err = m.Unpack(b); err != nil {
+		t.Fatalf("Failed to unpack message: %s", err)
+	}
+
+	errs := extractExtendedErrors(m)
+	if len(errs) != 2 {
+		t.Fatalf("extractExtendedErrors returned the wrong number of errors: %v", errs)
+	}
+	if errs[0].InfoCode != ExtendedErrorBlocked || errs[0].ExtraText != "ads" || !errs[0].Filtering() || errs[0].DNSSEC() {
+		t.Fatalf("extractExtendedErrors returned unexpected error: %#v", errs[0])
+	}
+	if errs[0].String() != "Blocked (15): ads" {
+		t.Fatalf("Unexpected string for extended error: %s", errs[0])
+	}
+	if errs[1].InfoCode != ExtendedErrorDNSSECBogus || !errs[1].DNSSEC() || errs[1].Filtering() {
+		t.Fatalf("extractExtendedErrors returned unexpected error: %#v", errs[1])
+	}
+	if (ExtendedError{InfoCode: 1000}).String() != "Unknown (1000)" {
+		t.Fatalf("Unexpected string for unknown extended error: %s", ExtendedError{InfoCode: 1000})
+	}
+
+	m.Rcode = dns.RcodeServerFailure
+	var re *ResolutionError
+	err = newResponseError(StageQuery, nil, nil, m, ErrBadAnswer)
+	if !errors.As(err, &re) || len(re.ExtendedErrors) != 2 {
+		t.Fatalf("newResponseError didn't include the extended errors: %s", err)
+	}
+	if a := extractAnswer(context.Background(), m, false); len(a.ExtendedErrors) != 2 {
+		t.Fatalf("extractAnswer didn't include the extended errors: %#v", a)
+	}
+}
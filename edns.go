@@ -0,0 +1,43 @@
+package solvere
+
+import (
+	"context"
+
+	"github.com/miekg/dns"
+)
+
+type ednsOptionsKey struct{}
+
+// WithEDNSOptions returns a copy of ctx which causes lookups using it to include
+// opts in the OPT record of every query sent upstream, and to return the options
+// included in the response an answer was extracted from in its EDNSOptions field.
+// Options already added by an earlier call are kept. Answers served from the cache
+// don't have any options.
+func WithEDNSOptions(ctx context.Context, opts ...dns.EDNS0) context.Context {
+	existing := ednsOptions(ctx)
+	combined := make([]dns.EDNS0, 0, len(existing)+len(opts))
+	combined = append(append(combined, existing...), opts...)
+	return context.WithValue(ctx, ednsOptionsKey{}, combined)
+}
+
+// ednsOptions returns the options set by WithEDNSOptions
+func ednsOptions(ctx context.Context) []dns.EDNS0 {
+	opts, _ := ctx.Value(ednsOptionsKey{}).([]dns.EDNS0)
+	return opts
+}
+
+// addEDNSOptions adds opts to the OPT record of m
+func addEDNSOptions(m *dns.Msg, opts []dns.EDNS0) {
+	if opt := m.IsEdns0(); opt != nil {
+		opt.Option = append(opt.Option, opts...)
+	}
+}
+
+// responseOptions returns the options in the OPT record of m
+func responseOptions(m *dns.Msg) []dns.EDNS0 {
+	opt := m.IsEdns0()
+	if opt == nil || len(opt.Option) == 0 {
+		return nil
+	}
+	return append([]dns.EDNS0(nil), opt.Option...)
+}
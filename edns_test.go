@@ -0,0 +1,52 @@
+package solvere
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestLookupEDNSOptions(t *testing.T) {
+	rr := NewRecursiveResolver(false, false, nil, nil, nil)
+	rr.Forward = &ForwardConfig{Servers: []string{"10.0.0.1"}}
+	private := &dns.EDNS0_LOCAL{Code: 65001, Data: []byte("experiment")}
+	rr.Transport = transportFunc(func(_ context.Context, m *dns.Msg, _ string) (*dns.Msg, error) {
+		var sent []dns.EDNS0
+		if opt := m.IsEdns0(); opt != nil {
+			sent = opt.Option
+		}
+		r := new(dns.Msg)
+		r.SetReply(m)
+		r.SetEdns0(1232, false)
+		r.Answer = []dns.RR{&dns.A{Hdr: dns.RR_Header{Name: m.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60}, A: net.IP{1, 2, 3, 4}}}
+		if len(sent) == 2 {
+			// echo the private option back
+			opt := r.IsEdns0()
+			opt.Option = append(opt.Option, &dns.EDNS0_LOCAL{Code: 65001, Data: []byte("reply")})
+		}
+		return r, nil
+	})
+
+	a, _, err := rr.Lookup(context.Background(), Question{Name: "a.example.", Type: dns.TypeA})
+	if err != nil {
+		t.Fatalf("Lookup failed: %s", err)
+	}
+	if a.EDNSOptions != nil {
+		t.Fatalf("EDNSOptions set without WithEDNSOptions: %v", a.EDNSOptions)
+	}
+
+	ctx := WithEDNSOptions(context.Background(), private)
+	ctx = WithEDNSOptions(ctx, &dns.EDNS0_NSID{Code: dns.EDNS0NSID})
+	a, _, err = rr.Lookup(ctx, Question{Name: "b.example.", Type: dns.TypeA})
+	if err != nil {
+		t.Fatalf("Lookup failed: %s", err)
+	}
+	if len(a.EDNSOptions) != 1 {
+		t.Fatalf("Expected one upstream option, got %v", a.EDNSOptions)
+	}
+	if o, ok := a.EDNSOptions[0].(*dns.EDNS0_LOCAL); !ok || o.Code != 65001 || string(o.Data) != "reply" {
+		t.Fatalf("Unexpected upstream option: %v", a.EDNSOptions[0])
+	}
+}
@@ -0,0 +1,111 @@
+package solvere
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// Stage describes the step of a resolution at which a failure occurred
+type Stage int
+
+const (
+	// StageQuery indicates a failure sending a query to, or receiving a response
+	// from, a remote nameserver
+	StageQuery Stage = iota
+	// StageValidation indicates a failure validating the DNSSEC chain
+	StageValidation
+	// StageDenial indicates a failure verifying a NSEC/NSEC3 proof of non-existence
+	StageDenial
+	// StageAlias indicates a failure following a CNAME or DNAME
+	StageAlias
+	// StageReferral indicates a failure following a referral
+	StageReferral
+	// StageAuthority indicates a failure looking up the address of an authority
+	StageAuthority
+)
+
+var stageStrings = map[Stage]string{
+	StageQuery:      "query",
+	StageValidation: "validation",
+	StageDenial:     "denial",
+	StageAlias:      "alias",
+	StageReferral:   "referral",
+	StageAuthority:  "authority",
+}
+
+func (s Stage) String() string {
+	if str, present := stageStrings[s]; present {
+		return str
+	}
+	return fmt.Sprintf("Stage(%d)", int(s))
+}
+
+// ResolutionError is returned by RecursiveResolver.Lookup when resolution fails. It
+// wraps the underlying cause, which is usually one of the exported Err* sentinel
+// errors, so callers can use errors.Is to test for a specific failure and errors.As
+// to extract the context in which it happened.
+type ResolutionError struct {
+	Stage Stage
+	// Question is the question being resolved when the failure occurred, this
+	// may differ from the original question if aliases were followed
+	Question *Question
+	// Zone is the zone of the nameserver being queried, if any
+	Zone string
+	// Server is the address of the nameserver being queried, if any
+	Server string
+	// Rcode is the RCODE of the response being processed, or -1 if no response
+	// was received
+	Rcode int
+	// ExtendedErrors contains any Extended DNS Errors (RFC 8914) included in
+	// the response being processed
+	ExtendedErrors []ExtendedError
+	Err            error
+}
+
+func newResolutionError(stage Stage, q *Question, auth *Nameserver, rcode int, err error) *ResolutionError {
+	re := &ResolutionError{Stage: stage, Rcode: rcode, Err: err}
+	if q != nil {
+		re.Question = &Question{Name: q.Name, Type: q.Type}
+	}
+	if auth != nil {
+		re.Zone = auth.Zone
+		re.Server = auth.Addr
+	}
+	return re
+}
+
+func newResponseError(stage Stage, q *Question, auth *Nameserver, r *dns.Msg, err error) *ResolutionError {
+	if r == nil {
+		return newResolutionError(stage, q, auth, -1, err)
+	}
+	re := newResolutionError(stage, q, auth, r.Rcode, err)
+	re.ExtendedErrors = extractExtendedErrors(r)
+	return re
+}
+
+func (re *ResolutionError) Error() string {
+	details := []string{"stage: " + re.Stage.String()}
+	if re.Question != nil {
+		details = append(details, fmt.Sprintf("question: %s %s", re.Question.Name, dns.TypeToString[re.Question.Type]))
+	}
+	if re.Zone != "" {
+		details = append(details, "zone: "+re.Zone)
+	}
+	if re.Server != "" {
+		details = append(details, "server: "+re.Server)
+	}
+	if re.Rcode >= 0 {
+		details = append(details, "rcode: "+dns.RcodeToString[re.Rcode])
+	}
+	for _, ee := range re.ExtendedErrors {
+		details = append(details, "ede: "+ee.String())
+	}
+	return fmt.Sprintf("%s [%s]", re.Err, strings.Join(details, ", "))
+}
+
+// Unwrap returns the underlying cause of the failure
+func (re *ResolutionError) Unwrap() error {
+	return re.Err
+}
@@ -0,0 +1,45 @@
+package solvere
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestResolutionError(t *testing.T) {
+	q := &Question{Name: "a.com.", Type: dns.TypeA}
+	auth := &Nameserver{Name: "ns.com.", Addr: "1.2.3.4", Zone: "com."}
+	var err error = newResolutionError(StageValidation, q, auth, dns.RcodeSuccess, ErrNoSignatures)
+	q.Name = "b.com."
+
+	if !errors.Is(err, ErrNoSignatures) {
+		t.Fatalf("errors.Is didn't find the wrapped cause: %s", err)
+	}
+	var re *ResolutionError
+	if !errors.As(err, &re) {
+		t.Fatalf("errors.As didn't find a ResolutionError: %s", err)
+	}
+	if re.Stage != StageValidation || re.Zone != "com." || re.Server != "1.2.3.4" || re.Question.Name != "a.com." {
+		t.Fatalf("ResolutionError has unexpected context: %#v", re)
+	}
+	for _, expected := range []string{ErrNoSignatures.Error(), "stage: validation", "zone: com.", "server: 1.2.3.4", "rcode: NOERROR"} {
+		if !strings.Contains(err.Error(), expected) {
+			t.Fatalf("ResolutionError message %q doesn't contain %q", err, expected)
+		}
+	}
+
+	// nested failures should still match the innermost cause
+	err = newResolutionError(StageReferral, q, nil, -1, &ResolutionError{Stage: StageAuthority, Rcode: dns.RcodeServerFailure, Err: ErrAuthorityLookup})
+	if !errors.Is(err, ErrAuthorityLookup) {
+		t.Fatalf("errors.Is didn't find the nested cause: %s", err)
+	}
+	msg := err.Error()
+	if strings.Contains(msg[strings.LastIndex(msg, "["):], "rcode") {
+		t.Fatalf("ResolutionError message contains the rcode of a missing response: %q", err)
+	}
+	if Stage(100).String() != "Stage(100)" {
+		t.Fatalf("Unexpected string for unknown stage: %s", Stage(100))
+	}
+}
@@ -0,0 +1,208 @@
+package solvere
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/jmhodges/clock"
+	"github.com/miekg/dns"
+)
+
+var (
+	// DefaultFailureTTL is the amount of time a resolution failure is initially
+	// remembered for if RecursiveResolver.FailureTTL isn't set
+	DefaultFailureTTL = 5 * time.Second
+	// MinFailureTTL and MaxFailureTTL bound the amount of time a resolution
+	// failure is remembered for (RFC 9520 Section 3.2)
+	MinFailureTTL = time.Second
+	MaxFailureTTL = 5 * time.Minute
+	// MaxFailureEntries is the maximum number of questions whose resolution or
+	// validation failure is remembered
+	MaxFailureEntries = 10000
+)
+
+// failureKind is the kind of failure a failureCache entry remembers
+type failureKind int
+
+const (
+	// resolutionFailure is a question that couldn't be resolved
+	resolutionFailure failureKind = iota
+	// validationFailure is a question whose answer failed validation
+	validationFailure
+)
+
+type failureKey struct {
+	q    Question
+	kind failureKind
+}
+
+type failureEntry struct {
+	answer  *Answer
+	err     error
+	expires time.Time
+	// failures is the number of consecutive times resolving the question has
+	// failed, it is used to back off the amount of time the failure is kept
+	failures int
+}
+
+// failureCache remembers questions that couldn't be resolved, either because
+// every server timed out or was unreachable or because they responded with
+// SERVFAIL or REFUSED, so that repeated queries aren't sent to servers which are
+// already failing (RFC 9520). Questions whose answers failed validation are
+// remembered separately, as validationFailure entries, so that repeated queries
+// fail without redoing the lookup (RFC 4035 Section 4.7).
+type failureCache struct {
+	mu      sync.Mutex
+	entries map[failureKey]failureEntry
+	clk     clock.Clock
+}
+
+func newFailureCache() *failureCache {
+	return &failureCache{entries: make(map[failureKey]failureEntry), clk: clock.Default()}
+}
+
+// get returns the answer or error resolving q last failed with and true, or false
+// if it isn't known to be failing
+func (fc *failureCache) get(q Question, kind failureKind) (*Answer, error, bool) {
+	if fc == nil {
+		return nil, nil, false
+	}
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	e, present := fc.entries[failureKey{bogusKey(q), kind}]
+	if !present || !fc.clk.Now().Before(e.expires) {
+		return nil, nil, false
+	}
+	return e.answer, e.err, true
+}
+
+// add remembers that resolving q failed with either a or err. Resolution failures
+// are kept for ttl, doubled for every consecutive failure since the last success,
+// up to MaxFailureTTL, validation failures are kept for ttl.
+func (fc *failureCache) add(q Question, kind failureKind, a *Answer, err error, ttl time.Duration) {
+	if fc == nil {
+		return
+	}
+	now := fc.clk.Now()
+	key := failureKey{bogusKey(q), kind}
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	prev, present := fc.entries[key]
+	if !present && len(fc.entries) >= MaxFailureEntries {
+		for k, e := range fc.entries {
+			if !now.Before(e.expires) {
+				delete(fc.entries, k)
+			}
+		}
+		if len(fc.entries) >= MaxFailureEntries {
+			return
+		}
+	}
+	failures := prev.failures
+	if kind == resolutionFailure {
+		for i := 0; i < failures && ttl < MaxFailureTTL; i++ {
+			ttl *= 2
+		}
+		if ttl > MaxFailureTTL {
+			ttl = MaxFailureTTL
+		}
+	}
+	fc.entries[key] = failureEntry{answer: a, err: err, expires: now.Add(ttl), failures: failures + 1}
+}
+
+// remove forgets any resolution failure resolving q, resetting its back off
+func (fc *failureCache) remove(q Question) {
+	if fc == nil {
+		return
+	}
+	key := failureKey{bogusKey(q), resolutionFailure}
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	delete(fc.entries, key)
+}
+
+// flush forgets the failures resolving name, and if subdomains is true the names
+// below it, and returns the number forgotten
+func (fc *failureCache) flush(name string, subdomains bool) int {
+	if fc == nil {
+		return 0
+	}
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	flushed := 0
+	for k := range fc.entries {
+		if flushMatches(k.q.Name, name, subdomains) {
+			delete(fc.entries, k)
+			flushed++
+		}
+	}
+	return flushed
+}
+
+// memoryUsage returns the approximate number of bytes used by the remembered
+// failures
+func (fc *failureCache) memoryUsage() int64 {
+	if fc == nil {
+		return 0
+	}
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	return int64(len(fc.entries)) * negativeEntrySize
+}
+
+// shrink forgets failures, expired ones first, until at least n bytes have been
+// freed and returns the number of bytes freed
+func (fc *failureCache) shrink(n int64) int64 {
+	if fc == nil {
+		return 0
+	}
+	now := fc.clk.Now()
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	var freed int64
+	for _, expiredOnly := range []bool{true, false} {
+		for k, e := range fc.entries {
+			if freed >= n {
+				return freed
+			}
+			if !expiredOnly || !now.Before(e.expires) {
+				delete(fc.entries, k)
+				freed += negativeEntrySize
+			}
+		}
+	}
+	return freed
+}
+
+// isResolutionFailure checks if a and err, the result of resolving a question,
+// are a resolution failure that should be remembered. Failures caused by local
+// limits, or by ctx being cancelled, say nothing about the state of the remote
+// servers and aren't, neither are validation failures which are remembered as
+// their own kind of failure.
+func isResolutionFailure(ctx context.Context, a *Answer, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	if err == nil {
+		return a.Rcode == dns.RcodeServerFailure || a.Rcode == dns.RcodeRefused
+	}
+	return !isBogus(err) && !errors.Is(err, ErrRateLimited) && !errors.Is(err, ErrResolverBusy)
+}
+
+// failureTTL returns the amount of time resolution failures are initially
+// remembered for
+func (rr *RecursiveResolver) failureTTL() time.Duration {
+	switch {
+	case rr.FailureTTL == 0:
+		return DefaultFailureTTL
+	case rr.FailureTTL < 0:
+		return rr.FailureTTL
+	case rr.FailureTTL < MinFailureTTL:
+		return MinFailureTTL
+	case rr.FailureTTL > MaxFailureTTL:
+		return MaxFailureTTL
+	}
+	return rr.FailureTTL
+}
@@ -0,0 +1,111 @@
+package solvere
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/jmhodges/clock"
+	"github.com/miekg/dns"
+)
+
+func TestFailureCaching(t *testing.T) {
+	queries := 0
+	failing := true
+	fc := clock.NewFake()
+	rr := NewRecursiveResolver(false, false, nil, nil, nil)
+	rr.failures.clk = fc
+	rr.Forward = &ForwardConfig{Servers: []string{"10.0.0.1"}, Attempts: 1}
+	rr.Transport = transportFunc(func(_ context.Context, m *dns.Msg, _ string) (*dns.Msg, error) {
+		queries++
+		if failing {
+			return nil, errors.New("timeout")
+		}
+		r := new(dns.Msg)
+		r.SetReply(m)
+		r.Answer = []dns.RR{&dns.A{Hdr: dns.RR_Header{Name: m.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60}, A: net.IP{1, 2, 3, 4}}}
+		return r, nil
+	})
+	q := Question{Name: "a.example.", Type: dns.TypeA}
+
+	_, _, err := rr.Lookup(context.Background(), q)
+	if err == nil {
+		t.Fatal("Lookup with unreachable servers didn't fail")
+	}
+	queries = 0
+	_, ll, cachedErr := rr.Lookup(context.Background(), Question{Name: "A.example.", Type: dns.TypeA})
+	if cachedErr != err {
+		t.Fatalf("Expected the original error, got %v", cachedErr)
+	}
+	if queries != 0 || !ll.CacheHit {
+		t.Fatalf("Lookup of recently failed question sent %d queries", queries)
+	}
+
+	// the second failure is remembered for twice as long
+	fc.Add(DefaultFailureTTL)
+	if _, _, err = rr.Lookup(context.Background(), q); err == nil || queries != 1 {
+		t.Fatalf("Expected the failure to be retried after it expired, sent %d queries", queries)
+	}
+	fc.Add(DefaultFailureTTL)
+	if _, _, err = rr.Lookup(context.Background(), q); err == nil || queries != 1 {
+		t.Fatalf("Expected the failure to be backed off, sent %d queries", queries)
+	}
+
+	// a success resets the back off
+	failing = false
+	fc.Add(DefaultFailureTTL)
+	if _, _, err = rr.Lookup(context.Background(), q); err != nil {
+		t.Fatalf("Lookup failed after the servers recovered: %s", err)
+	}
+	if _, _, present := rr.failures.get(q, resolutionFailure); present {
+		t.Fatal("Failure still remembered after a success")
+	}
+
+	// cancelled lookups aren't remembered
+	failing = true
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	q.Name = "b.example."
+	rr.Lookup(ctx, q)
+	if _, _, present := rr.failures.get(q, resolutionFailure); present {
+		t.Fatal("Failure of a cancelled lookup was remembered")
+	}
+}
+
+func TestFailureTTL(t *testing.T) {
+	for _, tc := range []struct {
+		configured, expected time.Duration
+	}{
+		{0, DefaultFailureTTL},
+		{-1, -1},
+		{time.Millisecond, MinFailureTTL},
+		{time.Hour, MaxFailureTTL},
+		{time.Minute, time.Minute},
+	} {
+		rr := &RecursiveResolver{FailureTTL: tc.configured}
+		if ttl := rr.failureTTL(); ttl != tc.expected {
+			t.Errorf("failureTTL() with FailureTTL %s = %s, expected %s", tc.configured, ttl, tc.expected)
+		}
+	}
+
+	fc := newFailureCache()
+	q := Question{Name: "example.", Type: dns.TypeA}
+	for i := 0; i < 20; i++ {
+		fc.add(q, resolutionFailure, &Answer{Rcode: dns.RcodeServerFailure}, nil, MinFailureTTL)
+		fc.add(q, validationFailure, nil, ErrBogusZone, DefaultBogusTTL)
+	}
+	if e := fc.entries[failureKey{q, resolutionFailure}]; e.expires.Sub(fc.clk.Now()) > MaxFailureTTL {
+		t.Fatalf("Failure remembered for longer than MaxFailureTTL: %s", e.expires.Sub(fc.clk.Now()))
+	}
+	// validation failures aren't backed off, and are kept when the question is
+	// resolved
+	if e := fc.entries[failureKey{q, validationFailure}]; e.expires.Sub(fc.clk.Now()) > DefaultBogusTTL {
+		t.Fatalf("Validation failure remembered for longer than its TTL: %s", e.expires.Sub(fc.clk.Now()))
+	}
+	fc.remove(q)
+	if _, err, present := fc.get(q, validationFailure); !present || err != ErrBogusZone {
+		t.Fatalf("Validation failure was forgotten with the resolution failure: %v", err)
+	}
+}
@@ -0,0 +1,113 @@
+package solvere
+
+import (
+	mrand "math/rand"
+	"net"
+	"sync/atomic"
+
+	"github.com/miekg/dns"
+)
+
+// AddressFamilyPolicy controls which address is queried when the nameservers of a
+// zone have both IPv4 and IPv6 addresses, it only matters when the resolver uses
+// IPv6 (WithIPv6). The policy applies to the root hints, stub zones, primed zones,
+// and the glue of referrals, nameservers whose addresses have to be looked up are
+// only queried over IPv4.
+type AddressFamilyPolicy int
+
+const (
+	// AddressFamilyAny picks an address at random regardless of its family
+	AddressFamilyAny AddressFamilyPolicy = iota
+	// AddressFamilyPreferIPv6 picks an IPv6 address if there is one, as the
+	// default policy table of RFC 6724 does
+	AddressFamilyPreferIPv6
+	// AddressFamilyPreferIPv4 picks an IPv4 address if there is one, for hosts
+	// whose IPv6 connectivity is worse than their IPv4 connectivity
+	AddressFamilyPreferIPv4
+	// AddressFamilyInterleave alternates between the families on successive
+	// queries, so both are used and a broken path only affects half of them
+	AddressFamilyInterleave
+)
+
+// isIPv6Addr checks if addr, either an IP address or a host:port pair, is an IPv6
+// address
+func isIPv6Addr(addr string) bool {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		addr = host
+	}
+	ip := net.ParseIP(addr)
+	return ip != nil && ip.To4() == nil
+}
+
+// pickAddress returns the index of the address out of n to query using the
+// AddressFamily policy, addr returns the address at an index. Addresses of
+// nameservers with a poor reputation are only picked if all of them have one.
+func (rr *RecursiveResolver) pickAddress(n int, addr func(int) string) int {
+	candidates := rr.reputation.reputable(n, addr)
+	if rr.AddressFamily == AddressFamilyAny || len(candidates) < 2 {
+		return candidates[mrand.Intn(len(candidates))]
+	}
+	var v4, v6 []int
+	for _, i := range candidates {
+		if isIPv6Addr(addr(i)) {
+			v6 = append(v6, i)
+		} else {
+			v4 = append(v4, i)
+		}
+	}
+	from := v4
+	switch rr.AddressFamily {
+	case AddressFamilyPreferIPv6:
+		from = v6
+	case AddressFamilyInterleave:
+		if atomic.AddUint32(&rr.counters().family, 1)%2 == 1 {
+			from = v6
+		}
+	}
+	if len(from) == 0 {
+		from = candidates
+	}
+	return from[mrand.Intn(len(from))]
+}
+
+// pickNameserver returns the nameserver out of servers to query
+func (rr *RecursiveResolver) pickNameserver(servers []Nameserver) *Nameserver {
+	return &servers[rr.pickAddress(len(servers), func(i int) string { return servers[i].Addr })]
+}
+
+// pickAddressRecord returns the address out of the A records in addresses to query
+func (rr *RecursiveResolver) pickAddressRecord(addresses []dns.RR) string {
+	return addresses[rr.pickAddress(len(addresses), func(i int) string { return addresses[i].(*dns.A).A.String() })].(*dns.A).A.String()
+}
+
+// orderAddressFamilies returns addrs ordered so that the addresses the AddressFamily
+// policy prefers come first, interleaving the families if the policy does
+func (rr *RecursiveResolver) orderAddressFamilies(addrs []string) []string {
+	if rr.AddressFamily == AddressFamilyAny {
+		return addrs
+	}
+	var v4, v6 []string
+	for _, addr := range addrs {
+		if isIPv6Addr(addr) {
+			v6 = append(v6, addr)
+		} else {
+			v4 = append(v4, addr)
+		}
+	}
+	switch rr.AddressFamily {
+	case AddressFamilyPreferIPv6:
+		return append(v6, v4...)
+	case AddressFamilyPreferIPv4:
+		return append(v4, v6...)
+	}
+	ordered := make([]string, 0, len(addrs))
+	for i := 0; i < len(v4) || i < len(v6); i++ {
+		if i < len(v6) {
+			ordered = append(ordered, v6[i])
+		}
+		if i < len(v4) {
+			ordered = append(ordered, v4[i])
+		}
+	}
+	return ordered
+}
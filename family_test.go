@@ -0,0 +1,54 @@
+package solvere
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestPickAddress(t *testing.T) {
+	servers := []Nameserver{{Addr: "192.0.2.1"}, {Addr: "2001:db8::1"}, {Addr: "192.0.2.2"}, {Addr: "[2001:db8::2]:53"}}
+	rr := NewRecursiveResolver(true, false, nil, nil, nil)
+	for policy, v6 := range map[AddressFamilyPolicy]bool{AddressFamilyPreferIPv6: true, AddressFamilyPreferIPv4: false} {
+		rr.AddressFamily = policy
+		for i := 0; i < 20; i++ {
+			if ns := rr.pickNameserver(servers); isIPv6Addr(ns.Addr) != v6 {
+				t.Fatalf("Policy %d picked %s", policy, ns.Addr)
+			}
+		}
+		// the other family is used if it's all there is
+		if ns := rr.pickNameserver(servers[:1]); ns.Addr != "192.0.2.1" {
+			t.Fatalf("Policy %d picked %s", policy, ns.Addr)
+		}
+	}
+
+	rr.AddressFamily = AddressFamilyInterleave
+	last := isIPv6Addr(rr.pickNameserver(servers).Addr)
+	for i := 0; i < 20; i++ {
+		v6 := isIPv6Addr(rr.pickNameserver(servers).Addr)
+		if v6 == last {
+			t.Fatal("Interleaving policy picked the same family twice in a row")
+		}
+		last = v6
+	}
+}
+
+func TestOrderAddressFamilies(t *testing.T) {
+	addrs := []string{"192.0.2.1", "192.0.2.2", "192.0.2.3", "2001:db8::1"}
+	rr := NewRecursiveResolver(true, false, nil, nil, nil)
+	for policy, expected := range map[AddressFamilyPolicy][]string{
+		AddressFamilyAny:        addrs,
+		AddressFamilyPreferIPv6: {"2001:db8::1", "192.0.2.1", "192.0.2.2", "192.0.2.3"},
+		AddressFamilyPreferIPv4: addrs,
+		AddressFamilyInterleave: {"2001:db8::1", "192.0.2.1", "192.0.2.2", "192.0.2.3"},
+	} {
+		rr.AddressFamily = policy
+		if ordered := rr.orderAddressFamilies(addrs); !reflect.DeepEqual(ordered, expected) {
+			t.Fatalf("Policy %d ordered addresses as %v, expected %v", policy, ordered, expected)
+		}
+	}
+	rr.AddressFamily = AddressFamilyInterleave
+	addrs = append(addrs, "2001:db8::2")
+	if ordered := rr.orderAddressFamilies(addrs); !reflect.DeepEqual(ordered, []string{"2001:db8::1", "192.0.2.1", "2001:db8::2", "192.0.2.2", "192.0.2.3"}) {
+		t.Fatalf("Addresses weren't interleaved: %v", ordered)
+	}
+}
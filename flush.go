@@ -0,0 +1,32 @@
+package solvere
+
+import (
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// flushMatches checks if the cached data for qname should be removed when name,
+// and if subdomains is true the names below it, are flushed
+func flushMatches(qname, name string, subdomains bool) bool {
+	if subdomains {
+		return isSubdomain(qname, name)
+	}
+	return strings.EqualFold(qname, name)
+}
+
+// Flush removes everything the resolver has cached about name, or if subdomains
+// is true name and every name below it, so that the next lookups are answered
+// from the authoritative servers. This includes answers, remembered failures
+// and validation failures, and the security status of zones. Answers that were
+// added forever, such as the trust anchors, are kept, and if the cache doesn't
+// implement FlushingCache only the resolver's own state is removed. The number of
+// entries removed is returned.
+func (rr *RecursiveResolver) Flush(name string, subdomains bool) int {
+	name = dns.Fqdn(name)
+	flushed := rr.failures.flush(name, subdomains) + rr.zoneStatus.flush(name, subdomains)
+	if fc, ok := rr.cache.(FlushingCache); ok {
+		flushed += fc.Flush(name, subdomains)
+	}
+	return flushed
+}
@@ -0,0 +1,39 @@
+package solvere
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func TestResolverFlush(t *testing.T) {
+	rr := NewResolver(WithCache(NewBasicCache()))
+	answer := &Answer{Answer: []dns.RR{&dns.A{Hdr: dns.RR_Header{Ttl: 60}, A: net.IP{1, 2, 3, 4}}}}
+	rr.cache.Add(&Question{Name: "www.example.com.", Type: dns.TypeA}, answer, false)
+	rr.cache.Add(&Question{Name: "example.net.", Type: dns.TypeA}, answer, false)
+	rr.failures.add(Question{Name: "down.example.com.", Type: dns.TypeA}, resolutionFailure, nil, errors.New("timeout"), time.Minute)
+	rr.failures.add(Question{Name: "bogus.example.com.", Type: dns.TypeA}, validationFailure, nil, errors.New("bogus"), time.Minute)
+	rr.zoneStatus.set("example.com.", SecurityBogus, time.Minute)
+
+	if n := rr.Flush("www.example.com", false); n != 1 {
+		t.Fatalf("Expected 1 entry to be flushed, got %d", n)
+	}
+	if n := rr.Flush("example.com", true); n != 3 {
+		t.Fatalf("Expected 3 entries to be flushed, got %d", n)
+	}
+	if _, err, present := rr.failures.get(Question{Name: "down.example.com.", Type: dns.TypeA}, resolutionFailure); present || err != nil {
+		t.Fatal("Failure wasn't flushed")
+	}
+	if _, _, present := rr.failures.get(Question{Name: "bogus.example.com.", Type: dns.TypeA}, validationFailure); present || rr.zoneStatus.get("example.com.") != SecurityUnknown {
+		t.Fatal("Validation state wasn't flushed")
+	}
+	if rr.cache.Get(&Question{Name: "example.net.", Type: dns.TypeA}) == nil {
+		t.Fatal("Answer outside the zone was flushed")
+	}
+	if rr.cache.Get(&Question{Name: ".", Type: dns.TypeDNSKEY}) == nil {
+		t.Fatal("Trust anchors were flushed")
+	}
+}
@@ -0,0 +1,653 @@
+package solvere
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+var (
+	// DefaultForwardTimeout is the default amount of time to wait for a response
+	// from a single forwarder
+	DefaultForwardTimeout = 5 * time.Second
+	// DefaultForwardAttempts is the default number of times the list of forwarders
+	// is tried before a lookup fails
+	DefaultForwardAttempts = 2
+	// DefaultRaceStagger is the default amount of time to wait for the first
+	// forwarder before also querying the second when racing forwarders
+	DefaultRaceStagger = 50 * time.Millisecond
+	// DefaultRaceSlowThreshold is the default smoothed round trip time above
+	// which a forwarder is no longer raced
+	DefaultRaceSlowThreshold = 500 * time.Millisecond
+	// RaceMaxFailures is the number of consecutive failures after which a
+	// forwarder is no longer raced
+	RaceMaxFailures = 3
+	// RaceRetryInterval is the amount of time after which a forwarder that is
+	// no longer raced is given another chance
+	RaceRetryInterval = time.Minute
+
+	ErrNoForwarders      = errors.New("solvere: No forwarders configured")
+	ErrUntrustedKeys     = errors.New("solvere: DNSKEY records for signer couldn't be authenticated")
+	ErrMissingTrustChain = errors.New("solvere: DS records for signed zone aren't signed by its parent")
+	ErrSignerMismatch    = errors.New("solvere: RRSIG signer doesn't enclose the signed records")
+	// ErrRecursionUnavailable is returned when a forwarder responds to a query
+	// with the RD bit set with a referral, or by refusing it, without setting
+	// the RA bit, usually because it is an authoritative server rather than a
+	// recursive resolver
+	ErrRecursionUnavailable = errors.New("solvere: Forwarder doesn't offer recursion")
+	// ErrUnsignedResponse is returned when the response to a TSIG signed query
+	// isn't signed
+	ErrUnsignedResponse = errors.New("solvere: Response to signed query isn't signed")
+)
+
+// ForwardConfig configures a RecursiveResolver to send all queries to a set of
+// upstream recursive resolvers instead of iterating from the root. If DNSSEC is
+// enabled responses are still validated by solvere, queries are sent with the CD
+// bit set and the chain of trust is built from the signer of each response up to
+// the root keys by asking the forwarders for the DNSKEY and DS records of each zone.
+// Forwarders which are found to remove DNSSEC records or ignore the CD bit aren't
+// used by validating lookups for ForwarderDNSSECRetryInterval, which are resolved
+// by iterating from the root if there are no other forwarders.
+type ForwardConfig struct {
+	// Servers are the addresses of the forwarders, either a IP address, a
+	// host:port pair, or a URL understood by the Transport, and are tried in
+	// order
+	Servers []string
+	// Timeout is the amount of time to wait for a response from a single
+	// forwarder, if zero DefaultForwardTimeout is used
+	Timeout time.Duration
+	// Attempts is the number of times the list of forwarders is tried, if zero
+	// DefaultForwardAttempts is used
+	Attempts int
+	// Rotate causes queries to be spread across the forwarders rather than
+	// always trying the first forwarder first
+	Rotate bool
+	// Race causes queries to be sent to two forwarders, the second after
+	// RaceStagger if the first hasn't answered, and the first usable response
+	// to be used. Forwarders which are persistently slow or failing aren't
+	// raced, but are still tried in order if the race fails.
+	Race bool
+	// RaceStagger is the amount time to wait before querying the second
+	// forwarder, if zero DefaultRaceStagger is used
+	RaceStagger time.Duration
+	// RaceSlowThreshold is the smoothed round trip time above which a forwarder
+	// is considered slow, if zero DefaultRaceSlowThreshold is used
+	RaceSlowThreshold time.Duration
+	// NoRecursion causes queries to be sent with the RD bit clear, so the
+	// forwarders only answer from their caches or the zones they serve. Queries
+	// they respond to with a referral, or refuse, are resolved by iterating
+	// from the root instead.
+	NoRecursion bool
+	// Keys are the TSIG keys queries to the forwarders are signed with, keyed by
+	// their entry in Servers. Forwarders without a key aren't sent signed queries.
+	Keys map[string]*TSIGKey
+
+	next   uint32
+	health forwarderHealth
+	dnssec brokenForwarders
+}
+
+func (fc *ForwardConfig) raceStagger() time.Duration {
+	if fc.RaceStagger > 0 {
+		return fc.RaceStagger
+	}
+	return DefaultRaceStagger
+}
+
+func (fc *ForwardConfig) raceSlowThreshold() time.Duration {
+	if fc.RaceSlowThreshold > 0 {
+		return fc.RaceSlowThreshold
+	}
+	return DefaultRaceSlowThreshold
+}
+
+// forwarderStats is the recent performance of a single forwarder
+type forwarderStats struct {
+	// srtt is the smoothed round trip time of successful queries
+	srtt     time.Duration
+	failures int
+	updated  time.Time
+}
+
+// forwarderHealth tracks the performance of each forwarder so that slow ones
+// aren't raced
+type forwarderHealth struct {
+	mu    sync.Mutex
+	stats map[string]*forwarderStats
+}
+
+// observe records the outcome of a query sent to addr
+func (fh *forwarderHealth) observe(addr string, rtt time.Duration, ok bool) {
+	fh.mu.Lock()
+	defer fh.mu.Unlock()
+	if fh.stats == nil {
+		fh.stats = make(map[string]*forwarderStats)
+	}
+	s, present := fh.stats[addr]
+	if !present {
+		s = &forwarderStats{}
+		fh.stats[addr] = s
+	}
+	s.updated = time.Now()
+	if !ok {
+		s.failures++
+		return
+	}
+	s.failures = 0
+	if s.srtt == 0 {
+		s.srtt = rtt
+	} else {
+		// the same smoothing as the TCP retransmission timer (RFC 6298)
+		s.srtt = s.srtt*7/8 + rtt/8
+	}
+}
+
+// healthy checks if addr has been answering quickly enough to be raced, forwarders
+// that haven't been used recently are healthy
+func (fh *forwarderHealth) healthy(addr string, threshold time.Duration) bool {
+	fh.mu.Lock()
+	defer fh.mu.Unlock()
+	s, present := fh.stats[addr]
+	return !present || time.Since(s.updated) > RaceRetryInterval || (s.failures < RaceMaxFailures && s.srtt <= threshold)
+}
+
+// order moves the healthy servers to the front, keeping their relative order,
+// and returns how many there are
+func (fh *forwarderHealth) order(servers []Nameserver, threshold time.Duration) int {
+	var healthy, slow []Nameserver
+	for _, s := range servers {
+		if fh.healthy(s.Addr, threshold) {
+			healthy = append(healthy, s)
+		} else {
+			slow = append(slow, s)
+		}
+	}
+	copy(servers, healthy)
+	copy(servers[len(healthy):], slow)
+	return len(healthy)
+}
+
+func (fc *ForwardConfig) timeout() time.Duration {
+	if fc.Timeout > 0 {
+		return fc.Timeout
+	}
+	return DefaultForwardTimeout
+}
+
+func (fc *ForwardConfig) attempts() int {
+	if fc.Attempts > 0 {
+		return fc.Attempts
+	}
+	return DefaultForwardAttempts
+}
+
+// servers returns the forwarders in the order they should be tried
+func (fc *ForwardConfig) servers() []Nameserver {
+	start := 0
+	if fc.Rotate && len(fc.Servers) > 0 {
+		start = int(atomic.AddUint32(&fc.next, 1)-1) % len(fc.Servers)
+	}
+	servers := make([]Nameserver, 0, len(fc.Servers))
+	for i := range fc.Servers {
+		addr := fc.Servers[(start+i)%len(fc.Servers)]
+		servers = append(servers, Nameserver{Name: addr, Addr: addr, Zone: ".", Forwarder: !fc.NoRecursion, TSIG: fc.Keys[addr]})
+	}
+	return servers
+}
+
+// nameserverAddr returns the host:port address of a nameserver, Addr may either be a
+// bare IP address, in which case the default DNS port is used, a host:port pair, or
+// a URL for transports such as DoHJSONTransport which are passed through as is
+func nameserverAddr(auth *Nameserver) string {
+	if _, _, err := net.SplitHostPort(auth.Addr); err == nil || strings.Contains(auth.Addr, "://") {
+		return auth.Addr
+	}
+	return net.JoinHostPort(auth.Addr, dnsPort)
+}
+
+// forwardConfig returns the forwarders queries for name should be sent to, or nil
+// if name should be resolved by iterating
+func (rr *RecursiveResolver) forwardConfig(name string) *ForwardConfig {
+	if len(rr.ForwardZones) > 0 {
+		for _, zone := range enclosingZones(name) {
+			if fc, present := rr.ForwardZones[zone]; present {
+				return fc
+			}
+		}
+	}
+	return rr.Forward
+}
+
+// forwardResult is the outcome of sending a query to a single forwarder
+type forwardResult struct {
+	r    *dns.Msg
+	log  *LookupLog
+	auth *Nameserver
+	err  error
+}
+
+// queryForwarder sends q to a single forwarder, responses with a SERVFAIL or
+// REFUSED RCODE are returned as errors so the next forwarder is tried, as are
+// responses to recursive queries that show the forwarder ignored the RD bit
+func (rr *RecursiveResolver) queryForwarder(ctx context.Context, fc *ForwardConfig, q *Question, auth *Nameserver) forwardResult {
+	qctx, cancel := context.WithTimeout(ctx, fc.timeout())
+	defer cancel()
+	s := time.Now()
+	r, log, err := rr.query(qctx, q, auth)
+	if err == nil && auth.Forwarder && !log.CacheHit && !r.RecursionAvailable && (r.Rcode == dns.RcodeRefused || isReferral(r)) {
+		err = newResponseError(StageQuery, q, auth, r, ErrRecursionUnavailable)
+		traceFrom(ctx).add(TraceDiscarded, auth, nil, "%s", err)
+		rr.log(LogWarn, "forwarder doesn't offer recursion", "server", auth.Addr, "rcode", dns.RcodeToString[r.Rcode])
+	} else if err == nil && (r.Rcode == dns.RcodeServerFailure || r.Rcode == dns.RcodeRefused) {
+		err = newResponseError(StageQuery, q, auth, r, ErrBadAnswer)
+		traceFrom(ctx).add(TraceDiscarded, auth, nil, "%s", err)
+	}
+	if err != nil {
+		log.Error = err.Error()
+	}
+	if !log.CacheHit && ctx.Err() == nil {
+		fc.health.observe(auth.Addr, time.Since(s), err == nil)
+	}
+	return forwardResult{r, log, auth, err}
+}
+
+// raceQuery sends q to the first two forwarders, starting the second after the
+// stagger or once the first fails, and returns the first usable response. If the
+// second forwarder wins the first one is counted as having failed.
+func (rr *RecursiveResolver) raceQuery(ctx context.Context, fc *ForwardConfig, q *Question, servers []Nameserver, ll *LookupLog) (forwardResult, bool) {
+	racers := []*Nameserver{&servers[0], &servers[1]}
+	rctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	results := make(chan forwardResult, len(racers))
+	race := func(auth *Nameserver) {
+		results <- rr.queryForwarder(rctx, fc, q, auth)
+	}
+	go race(racers[0])
+	stagger := time.NewTimer(fc.raceStagger())
+	defer stagger.Stop()
+	started, finished := 1, 0
+	var last forwardResult
+	for finished < started {
+		select {
+		case <-stagger.C:
+			if started < len(racers) {
+				go race(racers[started])
+				started++
+			}
+		case res := <-results:
+			finished++
+			ll.Composites = append(ll.Composites, res.log)
+			if res.err == nil {
+				if res.auth == racers[1] && finished < started {
+					fc.health.observe(racers[0].Addr, 0, false)
+				}
+				return res, true
+			}
+			last = res
+			if started < len(racers) && ctx.Err() == nil {
+				go race(racers[started])
+				started++
+			}
+		}
+	}
+	return last, ctx.Err() != nil
+}
+
+// forwardQuery sends q to each of the forwarders until one of them answers
+func (rr *RecursiveResolver) forwardQuery(ctx context.Context, fc *ForwardConfig, q *Question, ll *LookupLog) (*dns.Msg, *LookupLog, *Nameserver, error) {
+	servers := fc.servers()
+	if len(servers) == 0 {
+		return nil, nil, nil, ErrNoForwarders
+	}
+	if rr.validating(ctx) {
+		// forwarders which break DNSSEC are only used if there are no others
+		if usable := fc.dnssec.usable(servers); len(usable) > 0 {
+			servers = usable
+		}
+	}
+	if fc.Race && fc.health.order(servers, fc.raceSlowThreshold()) >= 2 {
+		if res, done := rr.raceQuery(ctx, fc, q, servers, ll); done {
+			return res.r, res.log, res.auth, res.err
+		}
+	}
+	var err error
+	var auth *Nameserver
+	for attempt := 0; attempt < fc.attempts(); attempt++ {
+		for i := range servers {
+			auth = &servers[i]
+			res := rr.queryForwarder(ctx, fc, q, auth)
+			ll.Composites = append(ll.Composites, res.log)
+			if err = res.err; err != nil {
+				if ctx.Err() != nil {
+					return nil, res.log, auth, err
+				}
+				continue
+			}
+			return res.r, res.log, auth, nil
+		}
+	}
+	return nil, nil, auth, err
+}
+
+// forward resolves q using the forwarders in fc
+func (rr *RecursiveResolver) forward(ctx context.Context, fc *ForwardConfig, q Question) (*Answer, *LookupLog, error) {
+	ll := newLookupLog(&q, nil)
+	defer func() {
+		ll.Latency = time.Since(ll.Started)
+	}()
+
+	iterate := func() (*Answer, *LookupLog, error) {
+		a, il, err := rr.lookup(ctx, q)
+		ll.Composites = append(ll.Composites, il)
+		ll.DNSSECValid = il.DNSSECValid
+		return a, ll, err
+	}
+	canIterate := len(rr.rootNameservers) > 0
+	if canIterate && rr.validating(ctx) && len(fc.dnssec.usable(fc.servers())) == 0 {
+		// every forwarder breaks DNSSEC
+		return iterate()
+	}
+
+	r, log, auth, err := rr.forwardQuery(ctx, fc, &q, ll)
+	if fc.NoRecursion && canIterate && ctx.Err() == nil && ((err == nil && !log.CacheHit && isReferral(r)) || isRefused(err)) {
+		// the forwarders couldn't answer without recursing
+		return iterate()
+	}
+	if err != nil {
+		if _, ok := err.(*ResolutionError); !ok {
+			err = newResolutionError(StageQuery, &q, auth, -1, err)
+		}
+		return nil, ll, err
+	}
+	if log.CacheHit {
+		ll.DNSSECValid = log.DNSSECValid
+		return extractAnswer(ctx, r, log.DNSSECValid), ll, nil
+	}
+	log.ExtendedErrors = extractExtendedErrors(r)
+
+	validated := false
+	var failures []PartialFailure
+	if rr.validating(ctx) {
+		v := &forwardValidator{rr: rr, fc: fc, keys: make(map[string]map[uint16]*dns.DNSKEY)}
+		validated, err = v.validate(ctx, r, log)
+		if trace := traceFrom(ctx); err != nil || validated {
+			trace.validation(auth, "signatures", err)
+		} else {
+			trace.add(TraceValidation, auth, nil, "response is unsigned or from an insecure zone")
+		}
+		for _, f := range v.failures {
+			traceFrom(ctx).add(TraceDiscarded, auth, nil, "removed %s %s which failed validation: %s", f.Name, dns.TypeToString[f.Type], f.Err)
+		}
+		failures = v.failures
+		if err != nil {
+			err = newResponseError(StageValidation, &q, auth, r, err)
+			log.Error = err.Error()
+			return nil, ll, err
+		}
+		if validated {
+			err = verifyForwardedDenial(r, &q, log)
+			traceFrom(ctx).validation(auth, "proof of non-existence", err)
+			if err != nil {
+				err = newResponseError(StageDenial, &q, auth, r, err)
+				log.Error = err.Error()
+				return nil, ll, err
+			}
+		}
+	}
+	if rr.validating(ctx) && !validated {
+		if err := rr.checkForwardedDNSSEC(r, &q, auth); err != nil {
+			fc.dnssec.mark(auth.Addr)
+			traceFrom(ctx).add(TraceDiscarded, auth, nil, "%s", err)
+			rr.log(LogWarn, "forwarder breaks DNSSEC, resolving by iterating instead", "server", auth.Addr, "name", q.Name, "error", err)
+			if canIterate && ctx.Err() == nil {
+				return iterate()
+			}
+		}
+	}
+	log.DNSSECValid = validated
+	ll.DNSSECValid = validated
+
+	if r.Rcode == dns.RcodeSuccess && len(r.Answer) > 0 && rr.cache != nil && !validationSkipped(ctx) && len(failures) == 0 {
+		go rr.addToCache(ctx, &q, &Answer{Answer: r.Answer, Authority: r.Ns, Additional: r.Extra, Rcode: r.Rcode, Authenticated: validated}, r)
+	}
+	// an answer with records removed isn't the authenticated answer
+	a := extractAnswer(ctx, r, validated && len(failures) == 0)
+	a.Failures = failures
+	return a, ll, nil
+}
+
+// isReferral checks if r is a non-authoritative response delegating the question
+// to another zone
+func isReferral(r *dns.Msg) bool {
+	if r.Rcode != dns.RcodeSuccess || r.Authoritative || len(r.Answer) > 0 {
+		return false
+	}
+	return len(extractRRSet(r.Ns, "", dns.TypeNS)) > 0 && len(extractRRSet(r.Ns, "", dns.TypeSOA)) == 0
+}
+
+// isRefused checks if err is the result of a forwarder refusing a query
+func isRefused(err error) bool {
+	var re *ResolutionError
+	return errors.As(err, &re) && re.Rcode == dns.RcodeRefused
+}
+
+// verifyForwardedDenial checks that wildcard expansions in a positive response are
+// proven, and any NSEC3 proof of non-existence in a negative response
+func verifyForwardedDenial(r *dns.Msg, q *Question, log *LookupLog) error {
+	vs := time.Now()
+	defer func() { log.timings().Validation += time.Since(vs) }()
+	if len(r.Answer) > 0 {
+		return verifyWildcardAnswer(r.Answer, r.Ns)
+	}
+	nsecSet := extractRRSet(r.Ns, "", dns.TypeNSEC3)
+	if len(nsecSet) == 0 {
+		return nil
+	}
+	if r.Rcode == dns.RcodeNameError {
+		return verifyNameError(q, nsecSet)
+	}
+	if r.Rcode == dns.RcodeSuccess && len(r.Answer) == 0 {
+		return verifyNODATA(q, nsecSet)
+	}
+	return nil
+}
+
+// forwardValidator builds the chain of trust for responses from forwarders, keys
+// which have been authenticated are remembered for the rest of the lookup
+type forwardValidator struct {
+	rr   *RecursiveResolver
+	fc   *ForwardConfig
+	keys map[string]map[uint16]*dns.DNSKEY
+	// failures are the RRsets removed from the response because they failed
+	// validation, see WithPartialResults
+	failures []PartialFailure
+}
+
+// signerNames returns the lower cased names of the zones that signed the records
+// in sections
+func signerNames(sections ...[]dns.RR) []string {
+	var signers []string
+	seen := map[string]struct{}{}
+	for _, section := range sections {
+		for _, r := range section {
+			if sig, ok := r.(*dns.RRSIG); ok {
+				signer := strings.ToLower(sig.SignerName)
+				if _, present := seen[signer]; !present {
+					seen[signer] = struct{}{}
+					signers = append(signers, signer)
+				}
+			}
+		}
+	}
+	return signers
+}
+
+// validate checks the signatures in r, building the chain of trust for each of
+// the signers. Unsigned responses aren't validated and false is returned.
+//
+// XXX: this doesn't prove that an unsigned response is from an insecure zone (the
+//
+//	way iterating does with DS records), so checkForwardedDNSSEC treats unsigned
+//	responses as stripped unless the zone is already known to be insecure
+func (v *forwardValidator) validate(ctx context.Context, r *dns.Msg, log *LookupLog) (bool, error) {
+	signers := signerNames(r.Answer, r.Ns)
+	if len(signers) == 0 {
+		return false, nil
+	}
+	for _, section := range [][]dns.RR{r.Answer, r.Ns} {
+		for _, a := range section {
+			if sig, ok := a.(*dns.RRSIG); ok && !isSubdomain(sig.Hdr.Name, sig.SignerName) {
+				return false, ErrSignerMismatch
+			}
+		}
+	}
+	for _, signer := range signers {
+		secure, err := v.zoneKeys(ctx, signer, log)
+		if err != nil {
+			return false, err
+		}
+		if !secure {
+			return false, nil
+		}
+	}
+	vs := time.Now()
+	keyFor := func(sig *dns.RRSIG) *dns.DNSKEY {
+		return v.keys[strings.ToLower(sig.SignerName)][sig.KeyTag]
+	}
+	err := verifySignatures(ctx, r, keyFor)
+	if err != nil && err != ErrValidationBudgetExceeded && partialResults(ctx) {
+		v.failures, err = salvageRRSets(ctx, r, keyFor, err)
+	}
+	log.timings().Validation += time.Since(vs)
+	if err != nil {
+		return false, err
+	}
+	// the answer is only secure if every RRset in it is signed, aliases may
+	// lead to unsigned zones (RFC 6840 Section 5.8)
+	return allSigned(r.Answer) && allSigned(r.Ns), nil
+}
+
+// zoneKeys authenticates the DNSKEY records for zone, it returns false if the zone
+// is an island of security with no DS records in its parent
+func (v *forwardValidator) zoneKeys(ctx context.Context, zone string, log *LookupLog) (bool, error) {
+	if _, present := v.keys[zone]; present {
+		return true, nil
+	}
+	if zone == "." {
+		if len(v.rr.rootKeys) == 0 {
+			return false, ErrUntrustedKeys
+		}
+		v.keys[zone] = keyMapFromRecords(v.rr.rootKeys)
+		return true, nil
+	}
+	switch v.rr.zoneStatus.get(zone) {
+	case SecurityInsecure:
+		return false, nil
+	case SecurityBogus:
+		return false, ErrBogusZone
+	}
+
+	if err := budgetFrom(ctx).fetch(); err != nil {
+		return false, err
+	}
+	keyQuestion := &Question{Name: zone, Type: dns.TypeDNSKEY}
+	keyMsg, keyLog, _, err := v.rr.forwardQuery(ctx, v.fc, keyQuestion, log)
+	if err != nil {
+		return false, err
+	}
+	keyMap := keyMapFromRecords(keyMsg.Answer)
+	if len(keyMap) == 0 {
+		return false, ErrNoDNSKEY
+	}
+	if keyLog.CacheHit && keyLog.DNSSECValid {
+		v.keys[zone] = keyMap
+		return true, nil
+	}
+
+	if err := budgetFrom(ctx).fetch(); err != nil {
+		return false, err
+	}
+	dsQuestion := &Question{Name: zone, Type: dns.TypeDS}
+	dsMsg, _, _, err := v.rr.forwardQuery(ctx, v.fc, dsQuestion, log)
+	if err != nil {
+		return false, err
+	}
+	dsSet := extractRRSet(dsMsg.Answer, "", dns.TypeDS)
+	if len(dsSet) == 0 {
+		v.provenInsecure(ctx, zone, dsMsg, log)
+		return false, nil
+	}
+	// the DS records are signed by the parent zone
+	parents := signerNames(dsMsg.Answer)
+	if len(parents) != 1 || parents[0] == zone || !isSubdomain(zone, parents[0]) {
+		v.rr.zoneStatus.set(zone, SecurityBogus, BogusZoneTTL)
+		return false, ErrMissingTrustChain
+	}
+	secure, err := v.zoneKeys(ctx, parents[0], log)
+	if err != nil || !secure {
+		return false, err
+	}
+
+	vs := time.Now()
+	defer func() { log.timings().Validation += time.Since(vs) }()
+	err = verifySignatures(ctx, dsMsg, func(sig *dns.RRSIG) *dns.DNSKEY {
+		return v.keys[parents[0]][sig.KeyTag]
+	})
+	if err == nil {
+		err = checkDS(keyMap, dsSet)
+	}
+	if err == nil {
+		err = verifyRRSIG(ctx, keyMsg, keyMap)
+	}
+	if err != nil {
+		v.rr.zoneStatus.set(zone, SecurityBogus, BogusZoneTTL)
+		return false, err
+	}
+	v.rr.zoneStatus.setFromRecords(zone, SecuritySecure, dsSet)
+	v.keys[zone] = keyMap
+	if v.rr.cache != nil {
+		v.rr.cache.Add(keyQuestion, &Answer{Answer: keyMsg.Answer, Authority: keyMsg.Ns, Additional: keyMsg.Extra, Rcode: dns.RcodeSuccess, Authenticated: true}, false)
+	}
+	return true, nil
+}
+
+// provenInsecure remembers zone as insecure if dsMsg contains a NSEC3 proof, signed
+// by a secure parent, that there are no DS records for the delegation. Without a
+// proof nothing is remembered and the DS records are requested again next time.
+func (v *forwardValidator) provenInsecure(ctx context.Context, zone string, dsMsg *dns.Msg, log *LookupLog) {
+	nsecSet := extractRRSet(dsMsg.Ns, "", dns.TypeNSEC3)
+	parents := signerNames(dsMsg.Ns)
+	if len(nsecSet) == 0 || len(parents) != 1 || parents[0] == zone || !isSubdomain(zone, parents[0]) {
+		return
+	}
+	if secure, err := v.zoneKeys(ctx, parents[0], log); err != nil || !secure {
+		return
+	}
+	vs := time.Now()
+	defer func() { log.timings().Validation += time.Since(vs) }()
+	err := verifySignatures(ctx, dsMsg, func(sig *dns.RRSIG) *dns.DNSKEY {
+		return v.keys[parents[0]][sig.KeyTag]
+	})
+	if err != nil || verifyDelegation(zone, nsecSet) != nil {
+		return
+	}
+	v.rr.zoneStatus.setFromRecords(zone, SecurityInsecure, nsecSet)
+}
+
+// keyMapFromRecords returns the zone and key signing keys in records indexed by
+// their key tag
+func keyMapFromRecords(records []dns.RR) map[uint16]*dns.DNSKEY {
+	keyMap := make(map[uint16]*dns.DNSKEY)
+	for _, r := range records {
+		if dnskey, ok := r.(*dns.DNSKEY); ok && (dnskey.Flags == 256 || dnskey.Flags == 257) {
+			keyMap[dnskey.KeyTag()] = dnskey
+		}
+	}
+	return keyMap
+}
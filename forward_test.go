@@ -0,0 +1,320 @@
+package solvere
+
+import (
+	"context"
+	"crypto"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func TestForwardFailover(t *testing.T) {
+	rr := NewRecursiveResolver(false, false, nil, nil, nil)
+	rr.Forward = &ForwardConfig{Servers: []string{"10.0.0.1", "10.0.0.2:5353"}}
+	first := net.JoinHostPort("10.0.0.1", dnsPort)
+	var tried []string
+	rr.Transport = transportFunc(func(_ context.Context, m *dns.Msg, addr string) (*dns.Msg, error) {
+		tried = append(tried, addr)
+		if !m.RecursionDesired {
+			t.Fatal("Forwarded query doesn't have the RD bit set")
+		}
+		r := new(dns.Msg)
+		r.SetReply(m)
+		if addr == first {
+			r.Rcode = dns.RcodeServerFailure
+			return r, nil
+		}
+		r.Answer = []dns.RR{&dns.A{Hdr: dns.RR_Header{Name: m.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60}, A: net.IP{1, 2, 3, 4}}}
+		return r, nil
+	})
+	a, _, err := rr.Lookup(context.Background(), Question{Name: "example.", Type: dns.TypeA})
+	if err != nil {
+		t.Fatalf("Lookup failed: %s", err)
+	}
+	if len(a.Answer) != 1 {
+		t.Fatalf("Expected one answer, got %d", len(a.Answer))
+	}
+	if len(tried) != 2 || tried[0] != first || tried[1] != "10.0.0.2:5353" {
+		t.Fatalf("Unexpected forwarders tried: %v", tried)
+	}
+
+	tried = nil
+	rr.Transport = transportFunc(func(_ context.Context, m *dns.Msg, addr string) (*dns.Msg, error) {
+		tried = append(tried, addr)
+		return nil, errors.New("broken")
+	})
+	_, _, err = rr.Lookup(context.Background(), Question{Name: "example.", Type: dns.TypeA})
+	if err == nil {
+		t.Fatal("Lookup didn't fail with broken forwarders")
+	}
+	if len(tried) != 2*DefaultForwardAttempts {
+		t.Fatalf("Expected %d queries, got %d", 2*DefaultForwardAttempts, len(tried))
+	}
+}
+
+func TestForwardRotate(t *testing.T) {
+	fc := &ForwardConfig{Servers: []string{"a", "b", "c"}, Rotate: true}
+	for _, first := range []string{"a", "b", "c", "a"} {
+		servers := fc.servers()
+		if len(servers) != 3 || servers[0].Addr != first {
+			t.Fatalf("Expected %s first, got %v", first, servers)
+		}
+	}
+	fc.Rotate = false
+	if fc.servers()[0].Addr != "a" {
+		t.Fatal("Servers rotated without Rotate set")
+	}
+}
+
+func TestForwardRace(t *testing.T) {
+	rr := NewRecursiveResolver(false, false, nil, nil, nil)
+	fc := &ForwardConfig{Servers: []string{"10.0.0.1", "10.0.0.2"}, Race: true, RaceStagger: 10 * time.Millisecond}
+	rr.Forward = fc
+	slow, fast := net.JoinHostPort("10.0.0.1", dnsPort), net.JoinHostPort("10.0.0.2", dnsPort)
+	var mu sync.Mutex
+	queried := make(map[string]int)
+	rr.Transport = transportFunc(func(ctx context.Context, m *dns.Msg, addr string) (*dns.Msg, error) {
+		mu.Lock()
+		queried[addr]++
+		mu.Unlock()
+		if addr == slow {
+			select {
+			case <-time.After(time.Second):
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		}
+		r := new(dns.Msg)
+		r.SetReply(m)
+		r.Answer = []dns.RR{&dns.A{Hdr: dns.RR_Header{Name: m.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60}, A: net.IP{1, 2, 3, 4}}}
+		return r, nil
+	})
+	for i := 0; i < RaceMaxFailures+1; i++ {
+		s := time.Now()
+		a, _, err := rr.Lookup(context.Background(), Question{Name: fmt.Sprintf("%d.example.", i), Type: dns.TypeA})
+		if err != nil {
+			t.Fatalf("Lookup failed: %s", err)
+		}
+		if len(a.Answer) != 1 {
+			t.Fatalf("Expected one answer, got %d", len(a.Answer))
+		}
+		if time.Since(s) > 500*time.Millisecond {
+			t.Fatal("Lookup waited for the slow forwarder")
+		}
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if queried[slow] != RaceMaxFailures || queried[fast] != RaceMaxFailures+1 {
+		t.Fatalf("Expected the slow forwarder to stop being raced after %d queries, got %v", RaceMaxFailures, queried)
+	}
+	if fc.health.healthy("10.0.0.1", fc.raceSlowThreshold()) {
+		t.Fatal("Slow forwarder is still considered healthy")
+	}
+}
+
+type testZone struct {
+	key  *dns.DNSKEY
+	priv crypto.Signer
+}
+
+func newTestZone(t *testing.T, name string) *testZone {
+	key := &dns.DNSKEY{
+		Hdr:       dns.RR_Header{Name: name, Rrtype: dns.TypeDNSKEY, Class: dns.ClassINET, Ttl: 3600},
+		Flags:     257,
+		Protocol:  3,
+		Algorithm: dns.ECDSAP256SHA256,
+	}
+	priv, err := key.Generate(256)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %s", err)
+	}
+	return &testZone{key: key, priv: priv.(crypto.Signer)}
+}
+
+func (tz *testZone) sign(t *testing.T, rrset ...dns.RR) []dns.RR {
+	h := rrset[0].Header()
+	sig := &dns.RRSIG{
+		Hdr:        dns.RR_Header{Name: h.Name, Rrtype: dns.TypeRRSIG, Class: dns.ClassINET, Ttl: h.Ttl},
+		Algorithm:  tz.key.Algorithm,
+		SignerName: tz.key.Hdr.Name,
+		KeyTag:     tz.key.KeyTag(),
+		Inception:  uint32(time.Now().Add(-time.Hour).Unix()),
+		Expiration: uint32(time.Now().Add(time.Hour).Unix()),
+	}
+	if err := sig.Sign(tz.priv, rrset); err != nil {
+		t.Fatalf("Failed to sign RRset: %s", err)
+	}
+	return append(rrset, sig)
+}
+
+func TestForwardNoRecursion(t *testing.T) {
+	rr := NewRecursiveResolver(false, false, []dns.RR{
+		&dns.A{Hdr: dns.RR_Header{Name: "a.root-servers.net.", Rrtype: dns.TypeA, Class: dns.ClassINET}, A: net.IP{198, 41, 0, 4}},
+	}, nil, nil)
+	forwarder := net.JoinHostPort("10.0.0.1", dnsPort)
+	var addrs []string
+	rd := false
+	rr.Transport = transportFunc(func(_ context.Context, m *dns.Msg, addr string) (*dns.Msg, error) {
+		addrs = append(addrs, addr)
+		r := new(dns.Msg)
+		r.SetReply(m)
+		if addr == forwarder {
+			rd = m.RecursionDesired
+			// an authoritative server which ignores the RD bit
+			r.Ns = []dns.RR{&dns.NS{Hdr: dns.RR_Header{Name: "example.", Rrtype: dns.TypeNS, Class: dns.ClassINET, Ttl: 60}, Ns: "ns.example."}}
+			return r, nil
+		}
+		r.Authoritative = true
+		r.Answer = []dns.RR{&dns.A{Hdr: dns.RR_Header{Name: m.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60}, A: net.IP{1, 2, 3, 4}}}
+		return r, nil
+	})
+	rr.Forward = &ForwardConfig{Servers: []string{"10.0.0.1"}, Attempts: 1}
+	_, _, err := rr.Lookup(context.Background(), Question{Name: "a.example.", Type: dns.TypeA})
+	if !errors.Is(err, ErrRecursionUnavailable) {
+		t.Fatalf("Expected ErrRecursionUnavailable from a forwarder ignoring the RD bit, got %v", err)
+	}
+	if !rd {
+		t.Fatal("Query to forwarder didn't have the RD bit set")
+	}
+
+	rr.Forward = &ForwardConfig{Servers: []string{"10.0.0.1"}, Attempts: 1, NoRecursion: true}
+	addrs = nil
+	a, _, err := rr.Lookup(context.Background(), Question{Name: "b.example.", Type: dns.TypeA})
+	if err != nil {
+		t.Fatalf("Lookup failed: %s", err)
+	}
+	if rd {
+		t.Fatal("Query to forwarder had the RD bit set with NoRecursion")
+	}
+	if len(addrs) != 2 || addrs[0] != forwarder || len(a.Answer) != 1 {
+		t.Fatalf("Expected the referral from the forwarder to be resolved by iterating, sent %v", addrs)
+	}
+}
+
+func TestForwardValidation(t *testing.T) {
+	root, example := newTestZone(t, "."), newTestZone(t, "example.")
+	a := &dns.A{Hdr: dns.RR_Header{Name: "a.example.", Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60}, A: net.IP{1, 2, 3, 4}}
+	responses := map[uint16][]dns.RR{
+		dns.TypeA:      example.sign(t, a),
+		dns.TypeDNSKEY: example.sign(t, example.key),
+		dns.TypeDS:     root.sign(t, example.key.ToDS(dns.SHA256)),
+	}
+
+	rr := NewRecursiveResolver(false, true, nil, []dns.RR{root.key}, nil)
+	rr.Forward = &ForwardConfig{Servers: []string{"10.0.0.1"}}
+	// the same question is looked up after it fails validation
+	rr.BogusTTL = -1
+	rr.Transport = transportFunc(func(_ context.Context, m *dns.Msg, _ string) (*dns.Msg, error) {
+		if !m.CheckingDisabled {
+			t.Fatal("Forwarded query doesn't have the CD bit set")
+		}
+		r := new(dns.Msg)
+		r.SetReply(m)
+		r.Answer = responses[m.Question[0].Qtype]
+		return r, nil
+	})
+	ans, _, err := rr.Lookup(context.Background(), Question{Name: "a.example.", Type: dns.TypeA})
+	if err != nil {
+		t.Fatalf("Lookup failed: %s", err)
+	}
+	if !ans.Authenticated {
+		t.Fatal("Validated answer isn't authenticated")
+	}
+
+	// a DS record signed by the wrong key breaks the chain of trust
+	responses[dns.TypeDS] = example.sign(t, example.key.ToDS(dns.SHA256))
+	if _, _, err = rr.Lookup(context.Background(), Question{Name: "a.example.", Type: dns.TypeA}); err == nil {
+		t.Fatal("Lookup didn't fail with a broken chain of trust")
+	}
+
+	// unsigned answers are insecure
+	responses[dns.TypeA] = []dns.RR{a}
+	ans, _, err = rr.Lookup(context.Background(), Question{Name: "a.example.", Type: dns.TypeA})
+	if err != nil {
+		t.Fatalf("Lookup failed: %s", err)
+	}
+	if ans.Authenticated {
+		t.Fatal("Unsigned answer is authenticated")
+	}
+}
+
+func TestForwardZones(t *testing.T) {
+	rr := NewRecursiveResolver(false, false, nil, nil, nil)
+	rr.Forward = &ForwardConfig{Servers: []string{"10.0.0.1:53"}}
+	rr.ForwardZones = map[string]*ForwardConfig{"corp.example.": {Servers: []string{"10.0.0.2:53"}}}
+	var addr string
+	rr.Transport = transportFunc(func(_ context.Context, m *dns.Msg, a string) (*dns.Msg, error) {
+		addr = a
+		r := new(dns.Msg)
+		r.SetReply(m)
+		r.Rcode = dns.RcodeNameError
+		return r, nil
+	})
+	for name, expected := range map[string]string{
+		"corp.example.":       "10.0.0.2:53",
+		"a.b.CORP.example.":   "10.0.0.2:53",
+		"notcorp.example.":    "10.0.0.1:53",
+		"corp.example.other.": "10.0.0.1:53",
+	} {
+		if _, _, err := rr.Lookup(context.Background(), Question{Name: name, Type: dns.TypeA}); err != nil {
+			t.Fatalf("Lookup failed: %s", err)
+		}
+		if addr != expected {
+			t.Errorf("Query for %s sent to %s, expected %s", name, addr, expected)
+		}
+	}
+}
+
+func TestForwardTSIG(t *testing.T) {
+	key := &TSIGKey{Name: "forward.", Secret: "c2VjcmV0c2VjcmV0c2VjcmV0"}
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen on UDP: %s", err)
+	}
+	s := &dns.Server{PacketConn: pc, TsigSecret: key.secrets(), Handler: dns.HandlerFunc(func(w dns.ResponseWriter, q *dns.Msg) {
+		r := new(dns.Msg)
+		r.SetReply(q)
+		r.RecursionAvailable = true
+		if q.IsTsig() == nil || w.TsigStatus() != nil {
+			r.Rcode = dns.RcodeNotAuth
+			w.WriteMsg(r)
+			return
+		}
+		r.Answer = []dns.RR{&dns.A{Hdr: dns.RR_Header{Name: q.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60}, A: net.IP{192, 0, 2, 1}}}
+		if q.Question[0].Name != "unsigned.example." {
+			r.SetTsig(q.IsTsig().Hdr.Name, dns.HmacSHA256, 300, time.Now().Unix())
+		}
+		w.WriteMsg(r)
+	})}
+	started := make(chan struct{})
+	s.NotifyStartedFunc = func() { close(started) }
+	go s.ActivateAndServe()
+	<-started
+	defer s.Shutdown()
+	addr := pc.LocalAddr().String()
+
+	rr := NewRecursiveResolver(false, false, nil, nil, nil)
+	rr.FailureTTL = -1
+	rr.Forward = &ForwardConfig{Servers: []string{addr}, Attempts: 1, Keys: map[string]*TSIGKey{addr: key}}
+	a, _, err := rr.Lookup(context.Background(), Question{Name: "a.example.", Type: dns.TypeA})
+	if err != nil {
+		t.Fatalf("Lookup failed: %s", err)
+	}
+	if len(a.Answer) != 1 || len(a.Additional) != 0 {
+		t.Fatalf("Unexpected answer: %+v", a)
+	}
+
+	if _, _, err = rr.Lookup(context.Background(), Question{Name: "unsigned.example.", Type: dns.TypeA}); !errors.Is(err, ErrUnsignedResponse) {
+		t.Fatalf("Expected ErrUnsignedResponse for an unsigned response, got %v", err)
+	}
+
+	rr.Forward = &ForwardConfig{Servers: []string{addr}, Attempts: 1, Keys: map[string]*TSIGKey{addr: {Name: "forward.", Secret: "d3JvbmdrZXl3cm9uZ2tleQ=="}}}
+	if _, _, err = rr.Lookup(context.Background(), Question{Name: "b.example.", Type: dns.TypeA}); err == nil {
+		t.Fatal("Lookup succeeded with the wrong TSIG secret")
+	}
+}
@@ -0,0 +1,109 @@
+package solvere
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+var (
+	// ForwarderDNSSECRetryInterval is how long a forwarder found to break DNSSEC
+	// isn't used by a validating resolver before it is given another chance
+	ForwarderDNSSECRetryInterval = 10 * time.Minute
+
+	// ErrDNSSECStripped is used when a forwarder returns an unsigned response for
+	// a zone known to be signed, or doesn't set the DO bit in its response
+	ErrDNSSECStripped = errors.New("solvere: Forwarder removed DNSSEC records")
+	// ErrCDIgnored is used when a forwarder doesn't copy the CD bit of a query
+	// to its response (RFC 4035 Section 3.1.6), so it may be validating answers
+	// itself and failing those it considers bogus
+	ErrCDIgnored = errors.New("solvere: Forwarder ignored the CD bit")
+)
+
+// brokenForwarders remembers the forwarders which were found to break DNSSEC, so
+// validating queries are resolved by iterating instead
+type brokenForwarders struct {
+	mu    sync.Mutex
+	until map[string]time.Time
+}
+
+// mark remembers addr as broken for ForwarderDNSSECRetryInterval
+func (bf *brokenForwarders) mark(addr string) {
+	bf.mu.Lock()
+	defer bf.mu.Unlock()
+	if bf.until == nil {
+		bf.until = make(map[string]time.Time)
+	}
+	bf.until[addr] = time.Now().Add(ForwarderDNSSECRetryInterval)
+}
+
+// broken checks if addr was recently marked as broken
+func (bf *brokenForwarders) broken(addr string) bool {
+	bf.mu.Lock()
+	defer bf.mu.Unlock()
+	until, present := bf.until[addr]
+	if present && !time.Now().Before(until) {
+		delete(bf.until, addr)
+		return false
+	}
+	return present
+}
+
+// usable returns the servers which aren't broken
+func (bf *brokenForwarders) usable(servers []Nameserver) []Nameserver {
+	usable := servers[:0:0]
+	for _, s := range servers {
+		if !bf.broken(s.Addr) {
+			usable = append(usable, s)
+		}
+	}
+	return usable
+}
+
+// checkForwardedDNSSEC checks if r, a response from a forwarder to a query with
+// the DO bit set that wasn't validated, shows that the forwarder doesn't pass
+// through DNSSEC records or honour the CD bit. Responses which are unsigned
+// aren't proof on their own since the zone may be insecure, so they are only
+// considered stripped if the closest enclosing zone of one of the names in the
+// response with a known status is signed, the root counts as signed when trust
+// anchors are configured.
+func (rr *RecursiveResolver) checkForwardedDNSSEC(r *dns.Msg, q *Question, auth *Nameserver) error {
+	if opt := r.IsEdns0(); opt == nil || !opt.Do() {
+		return ErrDNSSECStripped
+	}
+	if auth.Forwarder && !r.CheckingDisabled {
+		return ErrCDIgnored
+	}
+	if len(signerNames(r.Answer, r.Ns)) > 0 {
+		return nil
+	}
+	names := []string{q.Name}
+	for _, section := range [][]dns.RR{r.Answer, r.Ns} {
+		for _, record := range section {
+			names = append(names, record.Header().Name)
+		}
+	}
+	for _, name := range names {
+		if rr.expectSigned(name) {
+			return ErrDNSSECStripped
+		}
+	}
+	return nil
+}
+
+// expectSigned checks if records owned by name should be signed, walking up from
+// name until a zone with a known status is found. A zone proven to be insecure
+// stops the walk since the records below it may legitimately be unsigned.
+func (rr *RecursiveResolver) expectSigned(name string) bool {
+	for _, zone := range enclosingZones(name) {
+		switch rr.zoneStatus.get(zone) {
+		case SecuritySecure, SecurityBogus:
+			return true
+		case SecurityInsecure:
+			return false
+		}
+	}
+	return len(rr.rootKeys) > 0
+}
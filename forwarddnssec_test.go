@@ -0,0 +1,126 @@
+package solvere
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func TestCheckForwardedDNSSEC(t *testing.T) {
+	rr := NewResolver(WithCache(nil))
+	rr.zoneStatus.set("secure.example.", SecuritySecure, time.Hour)
+	rr.zoneStatus.set("insecure.example.", SecurityInsecure, time.Hour)
+	unanchored := NewResolver(WithCache(nil), WithTrustAnchors(nil))
+	auth := &Nameserver{Addr: "192.0.2.1", Forwarder: true}
+	q := &Question{Name: "www.insecure.example.", Type: dns.TypeA}
+	reply := func(cd, edns bool, answer, authority []dns.RR) *dns.Msg {
+		r := new(dns.Msg)
+		r.CheckingDisabled = cd
+		if edns {
+			r.SetEdns0(4096, true)
+		}
+		r.Answer, r.Ns = answer, authority
+		return r
+	}
+	a := &dns.A{Hdr: dns.RR_Header{Name: "www.insecure.example.", Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60}, A: net.IP{1, 2, 3, 4}}
+	belowSecure := &dns.A{Hdr: dns.RR_Header{Name: "www.secure.example.", Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60}, A: net.IP{1, 2, 3, 4}}
+	unknown := &dns.A{Hdr: dns.RR_Header{Name: "www.unknown.example.", Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60}, A: net.IP{1, 2, 3, 4}}
+	rootSOA := &dns.SOA{Hdr: dns.RR_Header{Name: ".", Rrtype: dns.TypeSOA, Class: dns.ClassINET, Ttl: 60}, Ns: "a.root-servers.net.", Mbox: "nstld.verisign-grs.com."}
+	secureSOA := &dns.SOA{Hdr: dns.RR_Header{Name: "secure.example.", Rrtype: dns.TypeSOA, Class: dns.ClassINET, Ttl: 60}, Ns: "ns.secure.example.", Mbox: "hostmaster.secure.example."}
+	sig := &dns.RRSIG{Hdr: dns.RR_Header{Name: "secure.example.", Rrtype: dns.TypeRRSIG, Class: dns.ClassINET, Ttl: 60}, TypeCovered: dns.TypeSOA, SignerName: "secure.example."}
+
+	for _, tc := range []struct {
+		name     string
+		r        *dns.Msg
+		expected error
+	}{
+		{"unsigned answer from insecure zone", reply(true, true, []dns.RR{a}, nil), nil},
+		{"unsigned answer below secure zone", reply(true, true, []dns.RR{belowSecure}, nil), ErrDNSSECStripped},
+		{"unsigned answer below anchored root", reply(true, true, []dns.RR{unknown}, nil), ErrDNSSECStripped},
+		{"no EDNS", reply(true, false, []dns.RR{a}, nil), ErrDNSSECStripped},
+		{"CD cleared", reply(false, true, []dns.RR{a}, nil), ErrCDIgnored},
+		{"unsigned root", reply(true, true, nil, []dns.RR{rootSOA}), ErrDNSSECStripped},
+		{"unsigned secure zone", reply(true, true, nil, []dns.RR{secureSOA}), ErrDNSSECStripped},
+		{"signed secure zone", reply(true, true, nil, []dns.RR{secureSOA, sig}), nil},
+	} {
+		if err := rr.checkForwardedDNSSEC(tc.r, q, auth); err != tc.expected {
+			t.Errorf("%s: expected %v, got %v", tc.name, tc.expected, err)
+		}
+	}
+	if err := unanchored.checkForwardedDNSSEC(reply(true, true, []dns.RR{unknown}, nil), q, auth); err != nil {
+		t.Errorf("unsigned answer without trust anchors: expected nil, got %v", err)
+	}
+}
+
+func TestForwarderDNSSECFallback(t *testing.T) {
+	const upstream = "192.0.2.1:53"
+	var mu sync.Mutex
+	var queried []string
+	rr := NewResolver(WithCache(nil))
+	rr.FailureTTL = -1
+	rr.Forward = &ForwardConfig{Servers: []string{upstream}, Attempts: 1}
+	rr.Transport = transportFunc(func(_ context.Context, m *dns.Msg, addr string) (*dns.Msg, error) {
+		mu.Lock()
+		queried = append(queried, addr)
+		mu.Unlock()
+		if addr != upstream {
+			return nil, errors.New("unreachable")
+		}
+		// the forwarder doesn't support EDNS, so can't return DNSSEC records
+		r := new(dns.Msg)
+		r.SetReply(m)
+		r.RecursionAvailable = true
+		r.CheckingDisabled = m.CheckingDisabled
+		r.Answer = []dns.RR{&dns.A{Hdr: dns.RR_Header{Name: m.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60}, A: net.IP{1, 2, 3, 4}}}
+		return r, nil
+	})
+	forwarded := func() (forwarder, other int) {
+		mu.Lock()
+		defer mu.Unlock()
+		for _, addr := range queried {
+			if addr == upstream {
+				forwarder++
+			} else {
+				other++
+			}
+		}
+		queried = nil
+		return
+	}
+
+	// the response is discarded and the name is resolved by iterating
+	if _, _, err := rr.Lookup(context.Background(), Question{Name: "a.example.", Type: dns.TypeA}); err == nil {
+		t.Fatal("Lookup succeeded using the answer of a forwarder which breaks DNSSEC")
+	}
+	if f, o := forwarded(); f != 1 || o == 0 {
+		t.Fatalf("Expected forwarder to be queried once before iterating, got %d forwarder and %d other queries", f, o)
+	}
+	if !rr.Forward.dnssec.broken(upstream) {
+		t.Fatal("Forwarder wasn't marked as breaking DNSSEC")
+	}
+	// later validating lookups don't use the forwarder
+	rr.Lookup(context.Background(), Question{Name: "b.example.", Type: dns.TypeA})
+	if f, o := forwarded(); f != 0 || o == 0 {
+		t.Fatalf("Expected lookup to iterate without using the forwarder, got %d forwarder and %d other queries", f, o)
+	}
+	// but lookups which aren't validated do
+	if _, _, err := rr.Lookup(WithoutValidation(context.Background()), Question{Name: "c.example.", Type: dns.TypeA}); err != nil {
+		t.Fatalf("Lookup without validation failed: %s", err)
+	}
+	if f, o := forwarded(); f != 1 || o != 0 {
+		t.Fatalf("Expected lookup to use the forwarder, got %d forwarder and %d other queries", f, o)
+	}
+
+	// the forwarder is given another chance after the retry interval
+	defer func(interval time.Duration) { ForwarderDNSSECRetryInterval = interval }(ForwarderDNSSECRetryInterval)
+	ForwarderDNSSECRetryInterval = -time.Second
+	rr.Forward.dnssec.mark(upstream)
+	if rr.Forward.dnssec.broken(upstream) {
+		t.Fatal("Forwarder still marked as breaking DNSSEC after the retry interval")
+	}
+}
@@ -0,0 +1,293 @@
+package solvere
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"net"
+	"sync/atomic"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+var (
+	// DefaultHandlerTimeout is the default maximum amount of time a Handler will
+	// spend resolving a single query
+	DefaultHandlerTimeout = 10 * time.Second
+	// DefaultMaxUDPSize is the default maximum size of a UDP response sent by
+	// a Handler, larger responses are truncated
+	DefaultMaxUDPSize uint16 = 1232
+
+	dnssecTypes = []uint16{dns.TypeRRSIG, dns.TypeNSEC, dns.TypeNSEC3, dns.TypeDS}
+)
+
+// Handler implements dns.Handler using a RecursiveResolver to answer queries so that
+// it can be used with a dns.Server or any other framework that accepts dns.Handler
+type Handler struct {
+	Resolver *RecursiveResolver
+	// Timeout is the maximum amount of time spent resolving a single query, if
+	// zero DefaultHandlerTimeout is used
+	Timeout time.Duration
+	// MaxUDPSize is the maximum size of UDP responses, it is advertised to
+	// EDNS clients and responses larger than it, or the size the client
+	// advertised, are truncated. If zero DefaultMaxUDPSize is used.
+	MaxUDPSize uint16
+	// AccessControl, if not nil, decides how the queries of each client are
+	// treated. Like Resolver it must not be modified once the Handler is in use,
+	// use SetAccessControl instead.
+	AccessControl *AccessControl
+
+	// current holds the *RecursiveResolver set by SetResolver
+	current atomic.Value
+	// currentAC holds the *AccessControl set by SetAccessControl
+	currentAC atomic.Value
+}
+
+// NewHandler returns a Handler that uses rr to answer queries
+func NewHandler(rr *RecursiveResolver) *Handler {
+	return &Handler{Resolver: rr}
+}
+
+// SetResolver replaces the resolver used to answer queries, queries that are
+// already being answered finish using the previous resolver. Unlike setting
+// Resolver it is safe to call while the Handler is in use, e.g. to switch to a
+// view of the resolver returned by RecursiveResolver.With when the configuration
+// is reloaded.
+func (h *Handler) SetResolver(rr *RecursiveResolver) {
+	h.current.Store(rr)
+}
+
+// CurrentResolver returns the resolver used to answer queries, the one most
+// recently passed to SetResolver or Resolver if it hasn't been called
+func (h *Handler) CurrentResolver() *RecursiveResolver {
+	if rr, ok := h.current.Load().(*RecursiveResolver); ok {
+		return rr
+	}
+	return h.Resolver
+}
+
+// SetAccessControl replaces the access control used to answer queries, it is safe
+// to call while the Handler is in use, e.g. along with SetResolver when the
+// configuration is reloaded
+func (h *Handler) SetAccessControl(ac *AccessControl) {
+	h.currentAC.Store(ac)
+}
+
+// currentAccessControl returns the access control most recently passed to
+// SetAccessControl, or AccessControl if it hasn't been called
+func (h *Handler) currentAccessControl() *AccessControl {
+	if ac, ok := h.currentAC.Load().(*AccessControl); ok {
+		return ac
+	}
+	return h.AccessControl
+}
+
+// ServeDNS implements dns.Handler
+func (h *Handler) ServeDNS(w dns.ResponseWriter, r *dns.Msg) {
+	m := h.respond(withClientAddr(context.Background(), w.RemoteAddr()), r)
+	if _, udp := w.RemoteAddr().(*net.UDPAddr); udp {
+		truncate(m, h.udpSize(r))
+	}
+	w.WriteMsg(m)
+}
+
+func (h *Handler) maxUDPSize() uint16 {
+	if h.MaxUDPSize > 0 {
+		return h.MaxUDPSize
+	}
+	return DefaultMaxUDPSize
+}
+
+// udpSize returns the maximum size of a UDP response to r
+func (h *Handler) udpSize(r *dns.Msg) int {
+	size := uint16(dns.MinMsgSize)
+	if opt := r.IsEdns0(); opt != nil && opt.UDPSize() > size {
+		size = opt.UDPSize()
+	}
+	if max := h.maxUDPSize(); size > max {
+		size = max
+	}
+	return int(size)
+}
+
+// truncate removes all records from m, except the OPT record, and sets the TC
+// bit if the message is larger than size so the client will retry over TCP
+func truncate(m *dns.Msg, size int) {
+	if m.Len() <= size {
+		return
+	}
+	m.Truncated = true
+	m.Answer = nil
+	m.Ns = nil
+	m.Extra = extractRRSet(m.Extra, "", dns.TypeOPT)
+}
+
+func (h *Handler) timeout() time.Duration {
+	if h.Timeout > 0 {
+		return h.Timeout
+	}
+	return DefaultHandlerTimeout
+}
+
+// respond builds the response to a client query
+func (h *Handler) respond(ctx context.Context, r *dns.Msg) *dns.Msg {
+	m := new(dns.Msg)
+	m.SetReply(r)
+	m.RecursionAvailable = true
+	m.Authoritative = false
+	m.Compress = true
+
+	clientOpt := r.IsEdns0()
+	do := clientOpt != nil && clientOpt.Do()
+	if clientOpt != nil {
+		m.SetEdns0(h.maxUDPSize(), do)
+		if clientOpt.Version() != 0 {
+			m.Rcode = dns.RcodeBadVers
+			return m
+		}
+	}
+
+	if r.Opcode != dns.OpcodeQuery {
+		m.Rcode = dns.RcodeNotImplemented
+		return m
+	}
+	if len(r.Question) != 1 {
+		m.Rcode = dns.RcodeFormatError
+		return m
+	}
+	if r.Question[0].Qclass != dns.ClassINET {
+		m.Rcode = dns.RcodeRefused
+		return m
+	}
+
+	q := Question{Name: r.Question[0].Name, Type: r.Question[0].Qtype}
+	rr := h.CurrentResolver()
+	recurse := r.RecursionDesired
+	if ac, client := h.currentAccessControl(), clientAddr(ctx); ac != nil && client != nil {
+		rule := ac.Match(client)
+		switch rule.Action {
+		case ClientRefuse:
+			m.Rcode = dns.RcodeRefused
+			return m
+		case ClientAllow:
+			recurse = false
+			m.RecursionAvailable = false
+		}
+		if rule.Resolver != nil {
+			rr = rule.Resolver
+		}
+	}
+	if do {
+		ctx = WithDNSSECRecords(ctx)
+	}
+	if r.CheckingDisabled {
+		// the client validates answers itself, so it gets them even if they
+		// would fail validation (RFC 4035 Section 3.2.2)
+		ctx = WithoutValidation(ctx)
+	}
+	var a *Answer
+	if recurse {
+		ctx, cancel := context.WithTimeout(ctx, h.timeout())
+		defer cancel()
+		var err error
+		a, _, err = rr.Lookup(ctx, q)
+		if err != nil {
+			m.Rcode = dns.RcodeServerFailure
+			if clientOpt != nil {
+				addExtendedErrors(m, errorToExtendedErrors(err))
+			}
+			return m
+		}
+	} else {
+		// Non-recursive queries are only answered from local data and the
+		// cache
+		if a = rr.localAnswer(q); a == nil {
+			m.Rcode = dns.RcodeRefused
+			return m
+		}
+	}
+
+	m.Rcode = a.Rcode
+	m.Authoritative = a.Authoritative
+	m.Answer = a.Answer
+	m.Ns = a.Authority
+	opt := m.IsEdns0()
+	m.Extra = filterRRSet(a.Additional, dns.TypeOPT)
+	if !do {
+		m.Answer = stripDNSSEC(m.Answer, q.Type)
+		m.Ns = stripDNSSEC(m.Ns, q.Type)
+		m.Extra = stripDNSSEC(m.Extra, q.Type)
+	}
+	if opt != nil {
+		m.Extra = append(m.Extra, opt)
+	}
+	// RFC 6840 Section 5.7, only set the AD bit if the client asked for it
+	// either using the AD or DO bits
+	m.AuthenticatedData = a.Authenticated && !r.CheckingDisabled && (do || r.AuthenticatedData)
+	if clientOpt != nil {
+		addExtendedErrors(m, a.ExtendedErrors)
+	}
+	return m
+}
+
+// localAnswer answers q without resolving it, using the resolver's local data,
+// local zones, or cache
+func (rr *RecursiveResolver) localAnswer(q Question) *Answer {
+	if rr.LocalData != nil {
+		if a := rr.LocalData.lookup(q); a != nil {
+			return a
+		}
+	}
+	if lz := rr.localZone(q.Name); lz != nil {
+		if a := lz.lookup(q); a != nil {
+			return a
+		}
+	}
+	if rr.cache != nil {
+		return rr.cache.Get(&q)
+	}
+	return nil
+}
+
+// stripDNSSEC removes DNSSEC records from a section unless they were explicitly
+// asked for
+func stripDNSSEC(section []dns.RR, qtype uint16) []dns.RR {
+	types := make([]uint16, 0, len(dnssecTypes))
+	for _, t := range dnssecTypes {
+		if t != qtype {
+			types = append(types, t)
+		}
+	}
+	return filterRRSet(section, types...)
+}
+
+// errorToExtendedErrors returns the Extended DNS Errors that should be sent to a
+// client whose query failed with err
+func errorToExtendedErrors(err error) []ExtendedError {
+	if errors.Is(err, ErrRebinding) {
+		return []ExtendedError{{InfoCode: ExtendedErrorFiltered}}
+	}
+	var re *ResolutionError
+	if !errors.As(err, &re) {
+		return nil
+	}
+	if re.Stage == StageValidation || re.Stage == StageDenial {
+		return append([]ExtendedError{{InfoCode: ExtendedErrorDNSSECBogus}}, re.ExtendedErrors...)
+	}
+	return re.ExtendedErrors
+}
+
+// addExtendedErrors adds Extended DNS Error options to the OPT record of m
+func addExtendedErrors(m *dns.Msg, errs []ExtendedError) {
+	opt := m.IsEdns0()
+	if opt == nil {
+		return
+	}
+	for _, ee := range errs {
+		data := make([]byte, 2, 2+len(ee.ExtraText))
+		binary.BigEndian.PutUint16(data, ee.InfoCode)
+		data = append(data, ee.ExtraText...)
+		opt.Option = append(opt.Option, &dns.EDNS0_LOCAL{Code: EDNS0EDE, Data: data})
+	}
+}
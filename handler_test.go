@@ -0,0 +1,206 @@
+package solvere
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/jmhodges/clock"
+	"github.com/miekg/dns"
+)
+
+func TestHandlerRespond(t *testing.T) {
+	a := &dns.A{Hdr: dns.RR_Header{Name: "a.com.", Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 10}, A: net.IP{1, 2, 3, 4}}
+	sig := &dns.RRSIG{Hdr: dns.RR_Header{Name: "a.com.", Rrtype: dns.TypeRRSIG, Class: dns.ClassINET, Ttl: 10}, TypeCovered: dns.TypeA}
+	cache := &BasicCache{clk: clock.NewFake()}
+	rr := &RecursiveResolver{c: new(dns.Client), cache: cache}
+	rr.AddHooks(Hooks{
+		OnQuery: func(_ context.Context, q *Question) (*Answer, error) {
+			if q.Name == "bogus.com." {
+				return nil, newResolutionError(StageValidation, q, nil, dns.RcodeSuccess, ErrNoSignatures)
+			}
+			return &Answer{Answer: []dns.RR{a, sig}, Rcode: dns.RcodeSuccess, Authenticated: true}, nil
+		},
+	})
+	h := NewHandler(rr)
+
+	query := func(name string, do, rd, cd bool) *dns.Msg {
+		m := new(dns.Msg)
+		m.SetQuestion(name, dns.TypeA)
+		m.RecursionDesired = rd
+		m.CheckingDisabled = cd
+		if do {
+			m.SetEdns0(4096, true)
+		}
+		return h.respond(context.Background(), m)
+	}
+
+	r := query("a.com.", false, true, false)
+	if r.Rcode != dns.RcodeSuccess || len(r.Answer) != 1 || r.AuthenticatedData || !r.RecursionAvailable {
+		t.Fatalf("Unexpected response for non-DO query: %s", r)
+	}
+	r = query("a.com.", true, true, false)
+	if r.Rcode != dns.RcodeSuccess || len(r.Answer) != 2 || !r.AuthenticatedData || r.IsEdns0() == nil {
+		t.Fatalf("Unexpected response for DO query: %s", r)
+	}
+	r = query("a.com.", true, true, true)
+	if r.AuthenticatedData {
+		t.Fatalf("AD bit set in response to CD query: %s", r)
+	}
+	r = query("bogus.com.", true, true, false)
+	if r.Rcode != dns.RcodeServerFailure {
+		t.Fatalf("Unexpected rcode for bogus query: %s", r)
+	}
+	if ees := extractExtendedErrors(r); len(ees) != 1 || ees[0].InfoCode != ExtendedErrorDNSSECBogus {
+		t.Fatalf("Unexpected extended errors for bogus query: %v", ees)
+	}
+
+	// non-recursive queries are only answered from the cache
+	r = query("cached.com.", false, false, false)
+	if r.Rcode != dns.RcodeRefused {
+		t.Fatalf("Unexpected rcode for uncached non-recursive query: %s", r)
+	}
+	cache.Add(&Question{Name: "cached.com.", Type: dns.TypeA}, &Answer{Answer: []dns.RR{a}}, false)
+	r = query("cached.com.", false, false, false)
+	if r.Rcode != dns.RcodeSuccess || len(r.Answer) != 1 {
+		t.Fatalf("Unexpected response for cached non-recursive query: %s", r)
+	}
+
+	m := new(dns.Msg)
+	m.SetQuestion("a.com.", dns.TypeA)
+	m.Question = append(m.Question, m.Question[0])
+	if r = h.respond(context.Background(), m); r.Rcode != dns.RcodeFormatError {
+		t.Fatalf("Unexpected rcode for multi-question query: %s", r)
+	}
+	m.SetQuestion("a.com.", dns.TypeA)
+	m.Question[0].Qclass = dns.ClassCHAOS
+	if r = h.respond(context.Background(), m); r.Rcode != dns.RcodeRefused {
+		t.Fatalf("Unexpected rcode for CHAOS query: %s", r)
+	}
+}
+
+func TestHandlerSetResolver(t *testing.T) {
+	resolver := func(rcode int) *RecursiveResolver {
+		rr := &RecursiveResolver{c: new(dns.Client)}
+		rr.AddHooks(Hooks{
+			OnQuery: func(_ context.Context, q *Question) (*Answer, error) {
+				return &Answer{Rcode: rcode}, nil
+			},
+		})
+		return rr
+	}
+	rr := resolver(dns.RcodeSuccess)
+	h := NewHandler(rr)
+	if h.CurrentResolver() != rr {
+		t.Fatal("CurrentResolver didn't return Resolver")
+	}
+
+	m := new(dns.Msg)
+	m.SetQuestion("a.com.", dns.TypeA)
+	if r := h.respond(context.Background(), m); r.Rcode != dns.RcodeSuccess {
+		t.Fatalf("Unexpected rcode before SetResolver: %s", r)
+	}
+
+	next := resolver(dns.RcodeNameError)
+	h.SetResolver(next)
+	if h.CurrentResolver() != next {
+		t.Fatal("CurrentResolver didn't return the resolver passed to SetResolver")
+	}
+	if r := h.respond(context.Background(), m); r.Rcode != dns.RcodeNameError {
+		t.Fatalf("Unexpected rcode after SetResolver: %s", r)
+	}
+}
+
+func TestHandlerDNSSECRecords(t *testing.T) {
+	rr := NewResolver(WithCache(NewBasicCache()), WithValidation(false))
+	rr.Forward = &ForwardConfig{Servers: []string{"192.0.2.1:53"}, Attempts: 1}
+	var queries []bool
+	rr.Transport = transportFunc(func(_ context.Context, m *dns.Msg, _ string) (*dns.Msg, error) {
+		do := m.IsEdns0() != nil && m.IsEdns0().Do()
+		queries = append(queries, do)
+		r := new(dns.Msg)
+		r.SetReply(m)
+		r.Answer = []dns.RR{&dns.A{Hdr: dns.RR_Header{Name: "a.com.", Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60}, A: net.IP{1, 2, 3, 4}}}
+		if do {
+			r.Answer = append(r.Answer, &dns.RRSIG{
+				Hdr:         dns.RR_Header{Name: "a.com.", Rrtype: dns.TypeRRSIG, Class: dns.ClassINET, Ttl: 60},
+				TypeCovered: dns.TypeA,
+				Expiration:  uint32(time.Now().Add(time.Hour).Unix()),
+			})
+		}
+		return r, nil
+	})
+	h := NewHandler(rr)
+	query := func(do bool) *dns.Msg {
+		m := new(dns.Msg)
+		m.SetQuestion("a.com.", dns.TypeA)
+		m.SetEdns0(4096, do)
+		return h.respond(context.Background(), m)
+	}
+
+	if r := query(false); len(r.Answer) != 1 || len(queries) != 1 || queries[0] {
+		t.Fatalf("Unexpected response to non-DO query: %s", r)
+	}
+	// answers cached without signatures aren't used for DO queries
+	for j := 0; rr.cache.Get(&Question{Name: "a.com.", Type: dns.TypeA}) == nil; j++ {
+		if j == 1000 {
+			t.Fatal("Answer wasn't cached")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	r := query(true)
+	if len(queries) != 2 || !queries[1] {
+		t.Fatalf("DO bit wasn't set upstream: %v", queries)
+	}
+	if len(r.Answer) != 2 || r.Answer[1].Header().Rrtype != dns.TypeRRSIG {
+		t.Fatalf("Signature wasn't passed through: %s", r)
+	}
+}
+
+func TestHandlerCheckingDisabled(t *testing.T) {
+	rr := NewResolver(WithCache(NewBasicCache()))
+	rr.Forward = &ForwardConfig{Servers: []string{"192.0.2.1:53"}, Attempts: 1}
+	rr.Transport = transportFunc(func(_ context.Context, m *dns.Msg, _ string) (*dns.Msg, error) {
+		// the zone is signed, but the signature doesn't validate using any
+		// of the root keys
+		r := new(dns.Msg)
+		r.SetReply(m)
+		r.CheckingDisabled = m.CheckingDisabled
+		r.SetEdns0(4096, true)
+		r.Answer = []dns.RR{
+			&dns.A{Hdr: dns.RR_Header{Name: "bogus.example.", Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60}, A: net.IP{1, 2, 3, 4}},
+			&dns.RRSIG{
+				Hdr:         dns.RR_Header{Name: "bogus.example.", Rrtype: dns.TypeRRSIG, Class: dns.ClassINET, Ttl: 60},
+				TypeCovered: dns.TypeA,
+				Labels:      2,
+				OrigTtl:     60,
+				Expiration:  uint32(time.Now().Add(time.Hour).Unix()),
+				Inception:   uint32(time.Now().Add(-time.Hour).Unix()),
+				KeyTag:      1,
+				SignerName:  ".",
+				Signature:   "AAAA",
+			},
+		}
+		return r, nil
+	})
+	h := NewHandler(rr)
+	query := func(cd bool) *dns.Msg {
+		m := new(dns.Msg)
+		m.SetQuestion("bogus.example.", dns.TypeA)
+		m.SetEdns0(4096, true)
+		m.CheckingDisabled = cd
+		return h.respond(context.Background(), m)
+	}
+
+	if r := query(false); r.Rcode != dns.RcodeServerFailure {
+		t.Fatalf("Expected SERVFAIL for bogus answer, got %s", dns.RcodeToString[r.Rcode])
+	}
+	r := query(true)
+	if r.Rcode != dns.RcodeSuccess || len(extractRRSet(r.Answer, "", dns.TypeA)) != 1 {
+		t.Fatalf("Expected bogus answer for query with the CD bit set, got %s", r)
+	}
+	if r.AuthenticatedData {
+		t.Fatal("AD bit set on answer which wasn't validated")
+	}
+}
@@ -0,0 +1,98 @@
+package solvere
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+var (
+	// HealthCheckSecureName is a name in a signed zone whose SOA record must be
+	// validated by RecursiveResolver.HealthCheck
+	HealthCheckSecureName = "."
+	// HealthCheckBogusName is a name with deliberately broken signatures whose
+	// lookup must fail validation in RecursiveResolver.HealthCheck
+	HealthCheckBogusName = "dnssec-failed.org."
+)
+
+// Names of the checks made by RecursiveResolver.HealthCheck
+const (
+	HealthCheckRoot   = "root"
+	HealthCheckSecure = "secure"
+	HealthCheckBogus  = "bogus"
+)
+
+// HealthCheckResult is the result of one of the checks made by
+// RecursiveResolver.HealthCheck
+type HealthCheckResult struct {
+	Name string `json:"name"`
+	OK   bool   `json:"ok"`
+	// Skipped is true if the check doesn't apply to the resolver, such as the
+	// DNSSEC checks of a resolver that isn't validating, skipped checks are OK
+	Skipped bool          `json:"skipped,omitempty"`
+	Error   string        `json:"error,omitempty"`
+	Latency time.Duration `json:"latency"`
+}
+
+// HealthReport is returned by RecursiveResolver.HealthCheck
+type HealthReport struct {
+	// Healthy is true if all of the checks are OK
+	Healthy bool                `json:"healthy"`
+	Checks  []HealthCheckResult `json:"checks"`
+}
+
+// HealthCheck tests that the resolver works end to end, suitable for readiness
+// probes. It checks that the root zone's nameservers can be looked up, and if
+// the resolver is validating that the SOA of HealthCheckSecureName validates and
+// that HealthCheckBogusName fails validation. The lookups bypass the answer
+// cache, so ctx should have a deadline shorter than the probe's.
+func (rr *RecursiveResolver) HealthCheck(ctx context.Context) *HealthReport {
+	ctx = context.WithValue(ctx, noCacheKey{}, true)
+	validating := rr.validating(ctx) && !rr.skipValidation
+	report := &HealthReport{Healthy: true}
+	check := func(name string, skip bool, q Question, verify func(*Answer, error) error) {
+		result := HealthCheckResult{Name: name, OK: true, Skipped: skip}
+		if !skip {
+			started := time.Now()
+			a, _, err := rr.Lookup(ctx, q)
+			result.Latency = time.Since(started)
+			if err = verify(a, err); err != nil {
+				result.OK = false
+				result.Error = err.Error()
+				report.Healthy = false
+			}
+		}
+		report.Checks = append(report.Checks, result)
+	}
+
+	check(HealthCheckRoot, false, Question{Name: ".", Type: dns.TypeNS}, func(a *Answer, err error) error {
+		if err != nil {
+			return err
+		}
+		if a.Rcode != dns.RcodeSuccess || len(a.Answer) == 0 {
+			return errors.New("solvere: No root nameservers returned")
+		}
+		return nil
+	})
+	check(HealthCheckSecure, !validating, Question{Name: dns.Fqdn(HealthCheckSecureName), Type: dns.TypeSOA}, func(a *Answer, err error) error {
+		if err != nil {
+			return err
+		}
+		if !a.Authenticated {
+			return errors.New("solvere: Answer wasn't authenticated")
+		}
+		return nil
+	})
+	check(HealthCheckBogus, !validating, Question{Name: dns.Fqdn(HealthCheckBogusName), Type: dns.TypeA}, func(_ *Answer, err error) error {
+		if err == nil {
+			return errors.New("solvere: Answer passed validation")
+		}
+		if !isBogus(err) {
+			return err
+		}
+		return nil
+	})
+	return report
+}
@@ -0,0 +1,72 @@
+package solvere
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestHealthCheck(t *testing.T) {
+	var secure, signed bool
+	var rootErr error
+	rr := NewResolver(WithCache(NewBasicCache()))
+	rr.FailureTTL = -1
+	rr.BogusTTL = -1
+	rr.Sources = []Source{SourceFunc(func(ctx context.Context, _ *RecursiveResolver, q Question) (*Answer, *LookupLog, error) {
+		if !cacheSkipped(ctx) {
+			t.Fatalf("Health check lookup of %s used the cache", q.Name)
+		}
+		switch q.Name {
+		case ".":
+			if rootErr != nil {
+				return nil, nil, rootErr
+			}
+			return &Answer{Rcode: dns.RcodeSuccess, Answer: []dns.RR{&dns.NS{Hdr: dns.RR_Header{Name: ".", Rrtype: dns.TypeNS, Class: dns.ClassINET, Ttl: 60}, Ns: "a.root-servers.net."}}, Authenticated: q.Type == dns.TypeSOA && secure}, nil, nil
+		case HealthCheckBogusName:
+			if signed {
+				return nil, nil, newResolutionError(StageValidation, &q, nil, -1, ErrNoSignatures)
+			}
+			return &Answer{Rcode: dns.RcodeSuccess, Answer: []dns.RR{&dns.A{Hdr: dns.RR_Header{Name: q.Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60}, A: net.IP{1, 2, 3, 4}}}}, nil, nil
+		}
+		return nil, nil, ErrNoSource
+	})}
+
+	failed := func(report *HealthReport) []string {
+		var names []string
+		for _, c := range report.Checks {
+			if !c.OK {
+				names = append(names, c.Name)
+			}
+		}
+		return names
+	}
+
+	if report := rr.HealthCheck(context.Background()); report.Healthy || len(report.Checks) != 3 {
+		t.Fatalf("Expected unhealthy report with 3 checks, got %+v", report)
+	} else if f := failed(report); len(f) != 2 || f[0] != HealthCheckSecure || f[1] != HealthCheckBogus {
+		t.Fatalf("Expected secure and bogus checks to fail, got %v", f)
+	}
+	secure, signed = true, true
+	if report := rr.HealthCheck(context.Background()); !report.Healthy {
+		t.Fatalf("Expected healthy report, got %+v", report)
+	}
+	rootErr = errors.New("timeout")
+	if report := rr.HealthCheck(context.Background()); report.Healthy || report.Checks[0].OK || report.Checks[0].Error != "timeout" {
+		t.Fatalf("Expected root check to fail, got %+v", report)
+	}
+
+	rootErr = nil
+	rr = rr.With(WithValidation(false))
+	report := rr.HealthCheck(context.Background())
+	if !report.Healthy {
+		t.Fatalf("Expected healthy report, got %+v", report)
+	}
+	for _, c := range report.Checks[1:] {
+		if !c.Skipped || !c.OK {
+			t.Fatalf("Expected %s check to be skipped by a resolver that isn't validating, got %+v", c.Name, c)
+		}
+	}
+}
@@ -1,4 +1,6 @@
-//+build ignore
+//go:build ignore
+// +build ignore
+
 package main
 
 import (
@@ -0,0 +1,108 @@
+package solvere
+
+import (
+	"context"
+
+	"github.com/miekg/dns"
+)
+
+// Hooks is a set of optional callbacks invoked at various stages of a lookup.
+// Any of the fields may be nil. Hooks are registered with RecursiveResolver.AddHooks
+// and are called in the order they were registered. Hooks must not retain the
+// messages passed to them after they return.
+type Hooks struct {
+	// OnQuery is called when Lookup is called, before any resolution is performed.
+	// The question may be modified in place. If a non-nil Answer or error is
+	// returned resolution is short-circuited and the result is returned to the
+	// caller (OnAnswer and OnError hooks are still called).
+	OnQuery func(ctx context.Context, q *Question) (*Answer, error)
+	// OnUpstreamSend is called before a message is sent to a remote nameserver.
+	// The message may be modified in place. If a non-nil response is returned
+	// it is used instead of contacting the nameserver, if a non-nil error is
+	// returned the query fails with that error.
+	OnUpstreamSend func(ctx context.Context, m *dns.Msg, ns *Nameserver) (*dns.Msg, error)
+	// OnUpstreamReceive is called when a response is received from a remote
+	// nameserver, before it is checked or validated. The response may be modified
+	// in place, if a non-nil error is returned the query fails with that error.
+	OnUpstreamReceive func(ctx context.Context, m *dns.Msg, ns *Nameserver, r *dns.Msg) error
+	// OnAnswer is called when Lookup has successfully resolved a question. It
+	// returns the Answer that should be returned to the caller, which may simply
+	// be the Answer that was passed in.
+	OnAnswer func(ctx context.Context, q Question, a *Answer, log *LookupLog) *Answer
+	// OnError is called when Lookup fails.
+	OnError func(ctx context.Context, q Question, err error, log *LookupLog)
+	// OnBogus is called when the background validation of an answer returned
+	// by Lookup with RecursiveResolver.LazyValidation set fails, log is the
+	// LookupLog of the validation rather than of the original lookup.
+	OnBogus func(ctx context.Context, q Question, err error, log *LookupLog)
+}
+
+// AddHooks registers a set of hooks with the resolver. It is not safe to call
+// AddHooks concurrently with Lookup.
+func (rr *RecursiveResolver) AddHooks(h Hooks) {
+	rr.hooks = append(rr.hooks, h)
+}
+
+func (rr *RecursiveResolver) runOnQuery(ctx context.Context, q *Question) (*Answer, error) {
+	for _, h := range rr.hooks {
+		if h.OnQuery == nil {
+			continue
+		}
+		if a, err := h.OnQuery(ctx, q); a != nil || err != nil {
+			return a, err
+		}
+	}
+	return nil, nil
+}
+
+func (rr *RecursiveResolver) runOnUpstreamSend(ctx context.Context, m *dns.Msg, ns *Nameserver) (*dns.Msg, error) {
+	for _, h := range rr.hooks {
+		if h.OnUpstreamSend == nil {
+			continue
+		}
+		if r, err := h.OnUpstreamSend(ctx, m, ns); r != nil || err != nil {
+			return r, err
+		}
+	}
+	return nil, nil
+}
+
+func (rr *RecursiveResolver) runOnUpstreamReceive(ctx context.Context, m *dns.Msg, ns *Nameserver, r *dns.Msg) error {
+	for _, h := range rr.hooks {
+		if h.OnUpstreamReceive == nil {
+			continue
+		}
+		if err := h.OnUpstreamReceive(ctx, m, ns, r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (rr *RecursiveResolver) runOnAnswer(ctx context.Context, q Question, a *Answer, log *LookupLog) *Answer {
+	for _, h := range rr.hooks {
+		if h.OnAnswer == nil {
+			continue
+		}
+		a = h.OnAnswer(ctx, q, a, log)
+	}
+	return a
+}
+
+func (rr *RecursiveResolver) runOnError(ctx context.Context, q Question, err error, log *LookupLog) {
+	for _, h := range rr.hooks {
+		if h.OnError == nil {
+			continue
+		}
+		h.OnError(ctx, q, err, log)
+	}
+}
+
+func (rr *RecursiveResolver) runOnBogus(ctx context.Context, q Question, err error, log *LookupLog) {
+	for _, h := range rr.hooks {
+		if h.OnBogus == nil {
+			continue
+		}
+		h.OnBogus(ctx, q, err, log)
+	}
+}
@@ -0,0 +1,90 @@
+package solvere
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestLookupHooks(t *testing.T) {
+	rr := &RecursiveResolver{c: new(dns.Client)}
+	shortCircuit := &Answer{Answer: []dns.RR{&dns.A{Hdr: dns.RR_Header{Name: "a.com.", Rrtype: dns.TypeA}, A: net.IP{1, 2, 3, 4}}}}
+	hookErr := errors.New("refused by hook")
+	var seenErr error
+	rr.AddHooks(Hooks{
+		OnQuery: func(_ context.Context, q *Question) (*Answer, error) {
+			switch q.Name {
+			case "a.com.":
+				return shortCircuit, nil
+			case "b.com.":
+				return nil, hookErr
+			}
+			return nil, nil
+		},
+	})
+	rr.AddHooks(Hooks{
+		OnAnswer: func(_ context.Context, _ Question, a *Answer, _ *LookupLog) *Answer {
+			a.Rcode = dns.RcodeNameError
+			return a
+		},
+		OnError: func(_ context.Context, _ Question, err error, _ *LookupLog) {
+			seenErr = err
+		},
+	})
+
+	a, log, err := rr.Lookup(context.Background(), Question{Name: "a.com.", Type: dns.TypeA})
+	if err != nil {
+		t.Fatalf("Lookup failed with short-circuiting OnQuery hook: %s", err)
+	}
+	if a != shortCircuit || a.Rcode != dns.RcodeNameError {
+		t.Fatalf("Lookup didn't return the answer from the OnQuery and OnAnswer hooks: %#v", a)
+	}
+	if log == nil {
+		t.Fatal("Lookup returned a nil LookupLog with short-circuiting OnQuery hook")
+	}
+
+	_, _, err = rr.Lookup(context.Background(), Question{Name: "b.com.", Type: dns.TypeA})
+	if err != hookErr {
+		t.Fatalf("Lookup didn't return the error from the OnQuery hook: %v", err)
+	}
+	if seenErr != hookErr {
+		t.Fatalf("OnError hook wasn't called with the error from the OnQuery hook: %v", seenErr)
+	}
+}
+
+func TestUpstreamHooks(t *testing.T) {
+	rr := &RecursiveResolver{c: new(dns.Client)}
+	var received *dns.Msg
+	rr.AddHooks(Hooks{
+		OnUpstreamSend: func(_ context.Context, m *dns.Msg, _ *Nameserver) (*dns.Msg, error) {
+			r := new(dns.Msg)
+			r.SetReply(m)
+			r.Answer = []dns.RR{&dns.A{Hdr: dns.RR_Header{Name: m.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET}, A: net.IP{1, 2, 3, 4}}}
+			return r, nil
+		},
+		OnUpstreamReceive: func(_ context.Context, _ *dns.Msg, _ *Nameserver, r *dns.Msg) error {
+			received = r
+			if r.Question[0].Name == "bad.com." {
+				return errors.New("bad response")
+			}
+			return nil
+		},
+	})
+
+	auth := &Nameserver{Zone: ".", Addr: "127.0.0.1"}
+	r, _, err := rr.query(context.Background(), &Question{Name: "a.com.", Type: dns.TypeA}, auth)
+	if err != nil {
+		t.Fatalf("query failed with short-circuiting OnUpstreamSend hook: %s", err)
+	}
+	if r != received || len(r.Answer) != 1 {
+		t.Fatalf("query didn't return the response from the OnUpstreamSend hook: %s", r)
+	}
+
+	_, _, err = rr.query(context.Background(), &Question{Name: "bad.com.", Type: dns.TypeA}, auth)
+	if err == nil {
+		t.Fatal("query didn't fail when the OnUpstreamReceive hook returned an error")
+	}
+}
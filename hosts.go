@@ -0,0 +1,86 @@
+package solvere
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// ErrHostsSyntax is returned by ParseHosts when a line is malformed
+var ErrHostsSyntax = errors.New("solvere: Malformed hosts file")
+
+// DefaultHostsTTL is the TTL of the records created by ParseHosts
+var DefaultHostsTTL uint32 = 300
+
+// ParseHosts reads a hosts(5) file, returning a LocalData containing the A and
+// AAAA records for each of the names of an address and a PTR record for its first
+// name. It can be added to RecursiveResolver.Sources to answer queries for the
+// names, e.g. after LocalDataSource.
+func ParseHosts(r io.Reader) (*LocalData, error) {
+	ld := NewLocalData(nil)
+	reverse := make(map[string]bool)
+	s := bufio.NewScanner(r)
+	for n := 1; s.Scan(); n++ {
+		line := s.Text()
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("%w: line %d: missing names", ErrHostsSyntax, n)
+		}
+		ip := net.ParseIP(fields[0])
+		if ip == nil {
+			// addresses with zones, e.g. fe80::1%lo0, can't be returned in
+			// records
+			if strings.Contains(fields[0], "%") {
+				continue
+			}
+			return nil, fmt.Errorf("%w: line %d: invalid address %q", ErrHostsSyntax, n, fields[0])
+		}
+		for _, name := range fields[1:] {
+			name = dns.Fqdn(strings.ToLower(name))
+			if _, ok := dns.IsDomainName(name); !ok {
+				return nil, fmt.Errorf("%w: line %d: invalid name %q", ErrHostsSyntax, n, name)
+			}
+			hdr := dns.RR_Header{Name: name, Class: dns.ClassINET, Ttl: DefaultHostsTTL}
+			if ip4 := ip.To4(); ip4 != nil {
+				hdr.Rrtype = dns.TypeA
+				ld.Add(&dns.A{Hdr: hdr, A: ip4})
+			} else {
+				hdr.Rrtype = dns.TypeAAAA
+				ld.Add(&dns.AAAA{Hdr: hdr, AAAA: ip})
+			}
+		}
+		if arpa, err := dns.ReverseAddr(ip.String()); err == nil && !reverse[arpa] {
+			reverse[arpa] = true
+			ld.Add(&dns.PTR{
+				Hdr: dns.RR_Header{Name: arpa, Rrtype: dns.TypePTR, Class: dns.ClassINET, Ttl: DefaultHostsTTL},
+				Ptr: dns.Fqdn(strings.ToLower(fields[1])),
+			})
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	return ld, nil
+}
+
+// LoadHostsFile reads the hosts(5) file at path, see ParseHosts
+func LoadHostsFile(path string) (*LocalData, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ParseHosts(f)
+}
@@ -0,0 +1,43 @@
+package solvere
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestParseHosts(t *testing.T) {
+	ld, err := ParseHosts(strings.NewReader(`
+# comment
+127.0.0.1	localhost
+192.0.2.10	Printer.home printer # office
+2001:db8::10	printer.home
+fe80::1%lo0	localhost
+`))
+	if err != nil {
+		t.Fatalf("ParseHosts failed: %s", err)
+	}
+	if a := ld.lookup(Question{Name: "printer.home.", Type: dns.TypeA}); a == nil || len(a.Answer) != 1 || a.Answer[0].(*dns.A).A.String() != "192.0.2.10" {
+		t.Fatalf("Unexpected A answer: %v", a)
+	}
+	if a := ld.lookup(Question{Name: "printer.home.", Type: dns.TypeAAAA}); a == nil || len(a.Answer) != 1 {
+		t.Fatalf("Unexpected AAAA answer: %v", a)
+	}
+	if a := ld.lookup(Question{Name: "printer.", Type: dns.TypeA}); a == nil || len(a.Answer) != 1 {
+		t.Fatalf("Unexpected answer for alias: %v", a)
+	}
+	if a := ld.lookup(Question{Name: "10.2.0.192.in-addr.arpa.", Type: dns.TypePTR}); a == nil || len(a.Answer) != 1 || a.Answer[0].(*dns.PTR).Ptr != "printer.home." {
+		t.Fatalf("Unexpected PTR answer: %v", a)
+	}
+	if a := ld.lookup(Question{Name: "localhost.", Type: dns.TypeAAAA}); a == nil || len(a.Answer) != 0 {
+		t.Fatalf("Address with a zone wasn't skipped: %v", a)
+	}
+
+	for _, bad := range []string{"192.0.2.1\n", "not-an-address host\n"} {
+		if _, err := ParseHosts(strings.NewReader(bad)); err == nil || !errors.Is(err, ErrHostsSyntax) {
+			t.Errorf("Expected ErrHostsSyntax for %q, got %v", bad, err)
+		}
+	}
+}
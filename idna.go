@@ -0,0 +1,251 @@
+package solvere
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+var ErrInvalidIDN = errors.New("solvere: Invalid internationalized domain name")
+
+// punycode parameters (RFC 3492 Section 5)
+const (
+	punyBase        = 36
+	punyTMin        = 1
+	punyTMax        = 26
+	punySkew        = 38
+	punyDamp        = 700
+	punyInitialBias = 72
+	punyInitialN    = 128
+
+	acePrefix = "xn--"
+)
+
+func punyAdapt(delta, points int, first bool) int {
+	if first {
+		delta /= punyDamp
+	} else {
+		delta /= 2
+	}
+	delta += delta / points
+	k := 0
+	for delta > ((punyBase-punyTMin)*punyTMax)/2 {
+		delta /= punyBase - punyTMin
+		k += punyBase
+	}
+	return k + (punyBase-punyTMin+1)*delta/(delta+punySkew)
+}
+
+func punyThreshold(k, bias int) int {
+	switch t := k - bias; {
+	case t < punyTMin:
+		return punyTMin
+	case t > punyTMax:
+		return punyTMax
+	default:
+		return t
+	}
+}
+
+func punyDigit(d int) byte {
+	if d < 26 {
+		return byte('a' + d)
+	}
+	return byte('0' + d - 26)
+}
+
+func punyValue(c byte) (int, bool) {
+	switch {
+	case c >= '0' && c <= '9':
+		return int(c-'0') + 26, true
+	case c >= 'a' && c <= 'z':
+		return int(c - 'a'), true
+	case c >= 'A' && c <= 'Z':
+		return int(c - 'A'), true
+	}
+	return 0, false
+}
+
+// punyEncode encodes label using punycode (RFC 3492 Section 6.3)
+func punyEncode(label string) string {
+	runes := []rune(label)
+	var out []byte
+	for _, r := range runes {
+		if r < utf8.RuneSelf {
+			out = append(out, byte(r))
+		}
+	}
+	basic := len(out)
+	if basic > 0 {
+		out = append(out, '-')
+	}
+	n, delta, bias := punyInitialN, 0, punyInitialBias
+	for h := basic; h < len(runes); {
+		m := int(utf8.MaxRune) + 1
+		for _, r := range runes {
+			if int(r) >= n && int(r) < m {
+				m = int(r)
+			}
+		}
+		delta += (m - n) * (h + 1)
+		n = m
+		for _, r := range runes {
+			if int(r) < n {
+				delta++
+			}
+			if int(r) != n {
+				continue
+			}
+			q := delta
+			for k := punyBase; ; k += punyBase {
+				t := punyThreshold(k, bias)
+				if q < t {
+					break
+				}
+				out = append(out, punyDigit(t+(q-t)%(punyBase-t)))
+				q = (q - t) / (punyBase - t)
+			}
+			out = append(out, punyDigit(q))
+			bias = punyAdapt(delta, h+1, h == basic)
+			delta = 0
+			h++
+		}
+		delta++
+		n++
+	}
+	return string(out)
+}
+
+// punyDecode decodes a punycode encoded label (RFC 3492 Section 6.2)
+func punyDecode(encoded string) (string, error) {
+	var out []rune
+	pos := 0
+	if b := strings.LastIndex(encoded, "-"); b >= 0 {
+		for i := 0; i < b; i++ {
+			if encoded[i] >= utf8.RuneSelf {
+				return "", fmt.Errorf("%w: non-basic code point before delimiter", ErrInvalidIDN)
+			}
+			out = append(out, rune(encoded[i]))
+		}
+		pos = b + 1
+	}
+	n, i, bias := punyInitialN, 0, punyInitialBias
+	for pos < len(encoded) {
+		oldi, w := i, 1
+		for k := punyBase; ; k += punyBase {
+			if pos >= len(encoded) {
+				return "", fmt.Errorf("%w: truncated punycode", ErrInvalidIDN)
+			}
+			digit, ok := punyValue(encoded[pos])
+			pos++
+			if !ok {
+				return "", fmt.Errorf("%w: invalid punycode digit %q", ErrInvalidIDN, encoded[pos-1])
+			}
+			i += digit * w
+			if i < 0 || i > utf8.MaxRune*(len(out)+1) {
+				return "", fmt.Errorf("%w: punycode overflow", ErrInvalidIDN)
+			}
+			t := punyThreshold(k, bias)
+			if digit < t {
+				break
+			}
+			w *= punyBase - t
+		}
+		bias = punyAdapt(i-oldi, len(out)+1, oldi == 0)
+		n += i / (len(out) + 1)
+		i %= len(out) + 1
+		if n > utf8.MaxRune {
+			return "", fmt.Errorf("%w: punycode overflow", ErrInvalidIDN)
+		}
+		out = append(out, 0)
+		copy(out[i+1:], out[i:])
+		out[i] = rune(n)
+		i++
+	}
+	return string(out), nil
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
+
+// checkULabel checks label against the IDNA2008 rules that don't need the
+// derived property tables (RFC 5891 Section 4.2.3): only lower case letters,
+// combining marks, digits, and hyphens are allowed, it may not start with a
+// combining mark or start or end with a hyphen, and it may not have hyphens in the
+// third and fourth positions
+func checkULabel(label string) error {
+	runes := []rune(label)
+	if runes[0] == '-' || runes[len(runes)-1] == '-' {
+		return fmt.Errorf("%w: label %q starts or ends with a hyphen", ErrInvalidIDN, label)
+	}
+	if len(runes) >= 4 && runes[2] == '-' && runes[3] == '-' {
+		return fmt.Errorf("%w: label %q has hyphens in the third and fourth positions", ErrInvalidIDN, label)
+	}
+	if unicode.IsMark(runes[0]) {
+		return fmt.Errorf("%w: label %q starts with a combining mark", ErrInvalidIDN, label)
+	}
+	for _, r := range runes {
+		switch {
+		case r == '-', unicode.IsDigit(r), unicode.IsMark(r):
+		case unicode.IsLetter(r) && !unicode.IsUpper(r) && !unicode.IsTitle(r):
+		default:
+			return fmt.Errorf("%w: label %q contains disallowed character %q", ErrInvalidIDN, label, r)
+		}
+	}
+	return nil
+}
+
+// ToASCII converts a domain name containing Unicode labels (U-labels) to the
+// ASCII compatible form (A-labels) used on the wire (RFC 5891). If strict is
+// false labels are lower cased and the full stops U+3002, U+FF0E and U+FF61 are
+// treated as dots, similar to the UTS #46 mapping used by browsers, otherwise
+// labels containing characters disallowed by IDNA2008 are rejected. Labels must
+// already be in Unicode Normalization Form C.
+func ToASCII(name string, strict bool) (string, error) {
+	if isASCII(name) {
+		return name, nil
+	}
+	if !strict {
+		name = strings.NewReplacer("。", ".", "．", ".", "｡", ".").Replace(name)
+	}
+	labels := strings.Split(name, ".")
+	for i, label := range labels {
+		if isASCII(label) {
+			continue
+		}
+		if !strict {
+			label = strings.ToLower(label)
+		} else if err := checkULabel(label); err != nil {
+			return "", err
+		}
+		encoded := punyEncode(label)
+		if len(acePrefix)+len(encoded) > 63 {
+			return "", fmt.Errorf("%w: label %q is too long", ErrInvalidIDN, label)
+		}
+		labels[i] = acePrefix + encoded
+	}
+	return strings.Join(labels, "."), nil
+}
+
+// ToUnicode converts the A-labels in name back to Unicode for display, labels
+// that can't be decoded are left as they are
+func ToUnicode(name string) string {
+	labels := strings.Split(name, ".")
+	for i, label := range labels {
+		if len(label) <= len(acePrefix) || !strings.EqualFold(label[:len(acePrefix)], acePrefix) {
+			continue
+		}
+		if decoded, err := punyDecode(label[len(acePrefix):]); err == nil {
+			labels[i] = decoded
+		}
+	}
+	return strings.Join(labels, ".")
+}
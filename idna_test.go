@@ -0,0 +1,82 @@
+package solvere
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestToASCII(t *testing.T) {
+	for _, tc := range []struct {
+		name, ascii string
+	}{
+		{"example.com.", "example.com."},
+		{"bücher.example.", "xn--bcher-kva.example."},
+		{"münchen.de", "xn--mnchen-3ya.de"},
+		{"españa.", "xn--espaa-rta."},
+		{"中国.", "xn--fiqs8s."},
+		{"日本語。jp.", "xn--wgv71a119e.jp."},
+		{"BÜCHER.example.", "xn--bcher-kva.example."},
+	} {
+		ascii, err := ToASCII(tc.name, false)
+		if err != nil {
+			t.Fatalf("ToASCII(%q) failed: %s", tc.name, err)
+		}
+		if ascii != tc.ascii {
+			t.Fatalf("ToASCII(%q) = %q, expected %q", tc.name, ascii, tc.ascii)
+		}
+		if tc.name == tc.ascii {
+			continue
+		}
+		if back := ToUnicode(ascii); back == ascii {
+			t.Fatalf("ToUnicode(%q) didn't decode anything", ascii)
+		} else if again, _ := ToASCII(back, false); again != ascii {
+			t.Fatalf("ToUnicode(%q) = %q doesn't round trip", ascii, back)
+		}
+	}
+	if u := ToUnicode("xn--bcher-kva.example."); u != "bücher.example." {
+		t.Fatalf("Unexpected ToUnicode result %q", u)
+	}
+	if u := ToUnicode("xn--!!.example."); u != "xn--!!.example." {
+		t.Fatalf("Invalid A-label was changed: %q", u)
+	}
+
+	for _, name := range []string{"BÜCHER.example.", "-bücher.example.", "bü--cher.example.", "bü☃.example.", "́bücher.example."} {
+		if _, err := ToASCII(name, true); err == nil {
+			t.Fatalf("Strict ToASCII accepted %q", name)
+		}
+	}
+	if _, err := ToASCII("bücher.example.", true); err != nil {
+		t.Fatalf("Strict ToASCII failed: %s", err)
+	}
+}
+
+func TestLookupUnicodeName(t *testing.T) {
+	rr := NewRecursiveResolver(false, false, nil, nil, nil)
+	rr.Forward = &ForwardConfig{Servers: []string{"10.0.0.1"}}
+	var sent string
+	rr.Transport = transportFunc(func(_ context.Context, m *dns.Msg, _ string) (*dns.Msg, error) {
+		sent = m.Question[0].Name
+		r := new(dns.Msg)
+		r.SetReply(m)
+		r.Answer = []dns.RR{&dns.A{Hdr: dns.RR_Header{Name: m.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60}, A: net.IP{1, 2, 3, 4}}}
+		return r, nil
+	})
+	a, log, err := rr.Lookup(context.Background(), Question{Name: "bücher.example.", Type: dns.TypeA})
+	if err != nil {
+		t.Fatalf("Lookup failed: %s", err)
+	}
+	if sent != "xn--bcher-kva.example." || len(a.Answer) != 1 {
+		t.Fatalf("Expected the A-label to be queried, sent %q", sent)
+	}
+	if log.Query.Name != "xn--bcher-kva.example." {
+		t.Fatalf("Unexpected name in the lookup log %q", log.Query.Name)
+	}
+
+	rr.StrictIDNA = true
+	if _, _, err = rr.Lookup(context.Background(), Question{Name: "bü☃.example.", Type: dns.TypeA}); err == nil {
+		t.Fatal("Lookup accepted a disallowed Unicode name")
+	}
+}
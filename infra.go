@@ -0,0 +1,147 @@
+package solvere
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/jmhodges/clock"
+	"github.com/miekg/dns"
+)
+
+var (
+	// EDNSTimeoutThreshold is the number of consecutive queries to a nameserver
+	// which have to time out before the query is retried advertising a reduced EDNS
+	// buffer size
+	EDNSTimeoutThreshold = 2
+	// ReducedEDNSBufferTTL is the amount of time a nameserver which only responded
+	// once the buffer size was reduced is sent queries advertising the reduced size
+	// for, before the usual size is tried again
+	ReducedEDNSBufferTTL = 15 * time.Minute
+	// MaxInfraEntries is the maximum number of nameservers whose behavior is
+	// remembered
+	MaxInfraEntries = 10000
+)
+
+// reducedEDNSBufferSize is advertised to nameservers which stopped timing out once
+// the buffer size was reduced, responses that fit in it are never fragmented
+const reducedEDNSBufferSize uint16 = dns.MinMsgSize
+
+type infraEntry struct {
+	// timeouts is the number of consecutive queries advertising the usual buffer
+	// size which timed out
+	timeouts int
+	// bufferSize, if not zero, is the reduced EDNS buffer size that should be
+	// advertised until expires. Until confirmed is set no response advertising it
+	// has been received.
+	bufferSize uint16
+	confirmed  bool
+	expires    time.Time
+}
+
+// infraCache remembers how nameservers, keyed by address, have behaved. Some paths
+// drop large or fragmented UDP responses, so queries to nameservers behind them
+// whose responses are larger than the path allows time out while those with small
+// responses work. Once EDNSTimeoutThreshold consecutive queries to a nameserver time
+// out the query is retried with a reduced buffer size, or if there is no time left
+// the next query is, and if that is answered the reduced size is used for the
+// nameserver for ReducedEDNSBufferTTL. If it also times out the usual size is used
+// again.
+type infraCache struct {
+	mu      sync.Mutex
+	entries map[string]infraEntry
+	clk     clock.Clock
+}
+
+func newInfraCache() *infraCache {
+	return &infraCache{entries: make(map[string]infraEntry), clk: clock.Default()}
+}
+
+// bufferSize returns the reduced buffer size which should be advertised to addr,
+// or zero if the usual size should be used
+func (ic *infraCache) bufferSize(addr string) uint16 {
+	if ic == nil {
+		return 0
+	}
+	ic.mu.Lock()
+	defer ic.mu.Unlock()
+	e := ic.entries[addr]
+	if e.bufferSize == 0 || !ic.clk.Now().Before(e.expires) {
+		return 0
+	}
+	return e.bufferSize
+}
+
+// timedOut records that a query to addr advertising size timed out and returns
+// true if it should be retried with a reduced buffer size
+func (ic *infraCache) timedOut(addr string, size uint16) bool {
+	if ic == nil {
+		return false
+	}
+	ic.mu.Lock()
+	defer ic.mu.Unlock()
+	e, present := ic.entries[addr]
+	if size <= reducedEDNSBufferSize {
+		// reducing the buffer size didn't help
+		if present && e.bufferSize == size && !e.confirmed {
+			delete(ic.entries, addr)
+		}
+		return false
+	}
+	if !present && !ic.makeRoom() {
+		return false
+	}
+	if e.timeouts++; e.timeouts < EDNSTimeoutThreshold {
+		ic.entries[addr] = e
+		return false
+	}
+	ic.entries[addr] = infraEntry{bufferSize: reducedEDNSBufferSize, expires: ic.clk.Now().Add(ReducedEDNSBufferTTL)}
+	return true
+}
+
+// answered records that a query to addr advertising size was answered and returns
+// true if it shows the nameserver only responds once the buffer size is reduced
+func (ic *infraCache) answered(addr string, size uint16) bool {
+	if ic == nil {
+		return false
+	}
+	ic.mu.Lock()
+	defer ic.mu.Unlock()
+	e, present := ic.entries[addr]
+	if !present {
+		return false
+	}
+	if e.bufferSize != 0 && e.bufferSize == size && ic.clk.Now().Before(e.expires) {
+		if e.confirmed {
+			return false
+		}
+		ic.entries[addr] = infraEntry{bufferSize: size, confirmed: true, expires: ic.clk.Now().Add(ReducedEDNSBufferTTL)}
+		return true
+	}
+	delete(ic.entries, addr)
+	return false
+}
+
+// makeRoom removes the entries which don't reduce the buffer size, or have
+// expired, if there are MaxInfraEntries entries and returns false if there is
+// still no room for another. It must be called with ic.mu held.
+func (ic *infraCache) makeRoom() bool {
+	if len(ic.entries) < MaxInfraEntries {
+		return true
+	}
+	now := ic.clk.Now()
+	for addr, e := range ic.entries {
+		if e.bufferSize == 0 || !now.Before(e.expires) {
+			delete(ic.entries, addr)
+		}
+	}
+	return len(ic.entries) < MaxInfraEntries
+}
+
+// isTimeout checks if err is the result of a query timing out
+func isTimeout(err error) bool {
+	var ne net.Error
+	return errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &ne) && ne.Timeout())
+}
@@ -0,0 +1,120 @@
+package solvere
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jmhodges/clock"
+	"github.com/miekg/dns"
+)
+
+func TestEDNSBufferDownsizing(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %s", err)
+	}
+	var mu sync.Mutex
+	var sizes []uint16
+	// the path to the server drops responses when large ones are allowed
+	server := &dns.Server{PacketConn: pc, Handler: dns.HandlerFunc(func(w dns.ResponseWriter, m *dns.Msg) {
+		size := queryUDPSize(m)
+		mu.Lock()
+		sizes = append(sizes, size)
+		mu.Unlock()
+		if size > dns.MinMsgSize {
+			return
+		}
+		r := new(dns.Msg).SetReply(m)
+		r.CheckingDisabled = m.CheckingDisabled
+		r.SetEdns0(dns.MinMsgSize, true)
+		r.Answer = []dns.RR{&dns.A{Hdr: dns.RR_Header{Name: m.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60}, A: net.IP{1, 2, 3, 4}}}
+		w.WriteMsg(r)
+	})}
+	go server.ActivateAndServe()
+	defer server.Shutdown()
+
+	fc := clock.NewFake()
+	rr := NewResolver(WithCache(nil), WithValidation(false))
+	rr.infra.clk = fc
+	rr.FailureTTL = -1
+	rr.QueryTimeout = 50 * time.Millisecond
+	rr.Forward = &ForwardConfig{Servers: []string{pc.LocalAddr().String()}, Attempts: 1}
+	ut := NewUDPPoolTransport()
+	defer ut.Close()
+	rr.Transport = ut
+	lastSize := func() uint16 {
+		mu.Lock()
+		defer mu.Unlock()
+		return sizes[len(sizes)-1]
+	}
+
+	// a single timeout isn't enough to reduce the buffer size
+	if _, _, err = rr.Lookup(context.Background(), Question{Name: "a.example.", Type: dns.TypeA}); err == nil {
+		t.Fatal("Lookup succeeded with a single query advertising a large buffer size")
+	}
+	if _, _, err = rr.Lookup(context.Background(), Question{Name: "b.example.", Type: dns.TypeA}); err != nil {
+		t.Fatalf("Lookup wasn't retried with a reduced buffer size: %s", err)
+	}
+	if size := lastSize(); size != dns.MinMsgSize {
+		t.Fatalf("Retry advertised %d", size)
+	}
+	// the reduced size is remembered
+	if _, _, err = rr.Lookup(context.Background(), Question{Name: "c.example.", Type: dns.TypeA}); err != nil {
+		t.Fatalf("Lookup failed with the remembered buffer size: %s", err)
+	}
+	mu.Lock()
+	queries := len(sizes)
+	mu.Unlock()
+	if size := lastSize(); queries != 4 || size != dns.MinMsgSize {
+		t.Fatalf("Expected a single query advertising the reduced size, got %d queries advertising %d", queries, size)
+	}
+
+	// once it expires the usual size is tried again
+	fc.Add(ReducedEDNSBufferTTL)
+	rr.Lookup(context.Background(), Question{Name: "d.example.", Type: dns.TypeA})
+	mu.Lock()
+	defer mu.Unlock()
+	if sizes[4] != DefaultEDNSBufferSize {
+		t.Fatalf("Expected the default buffer size after the reduced size expired, got %d", sizes[4])
+	}
+}
+
+func TestInfraCache(t *testing.T) {
+	addr := "192.0.2.1:53"
+	ic := newInfraCache()
+	if ic.timedOut(addr, DefaultEDNSBufferSize) {
+		t.Fatal("First timeout caused the buffer size to be reduced")
+	}
+	// a response resets the count
+	ic.answered(addr, DefaultEDNSBufferSize)
+	if ic.timedOut(addr, DefaultEDNSBufferSize) {
+		t.Fatal("Timeouts weren't reset by a response")
+	}
+	if ic.bufferSize(addr) != 0 {
+		t.Fatal("Buffer size reduced after a single timeout")
+	}
+
+	// once the threshold is reached the next query uses the reduced size, which
+	// is forgotten if it times out too
+	if !ic.timedOut(addr, DefaultEDNSBufferSize) || ic.bufferSize(addr) != reducedEDNSBufferSize {
+		t.Fatal("Buffer size wasn't reduced once the threshold was reached")
+	}
+	ic.timedOut(addr, reducedEDNSBufferSize)
+	if ic.bufferSize(addr) != 0 {
+		t.Fatal("Reduced buffer size kept after it timed out")
+	}
+
+	// and kept if it works
+	ic.timedOut(addr, DefaultEDNSBufferSize)
+	ic.timedOut(addr, DefaultEDNSBufferSize)
+	if !ic.answered(addr, reducedEDNSBufferSize) || ic.answered(addr, reducedEDNSBufferSize) {
+		t.Fatal("Response to the reduced buffer size wasn't reported once")
+	}
+	ic.timedOut(addr, reducedEDNSBufferSize)
+	if ic.bufferSize(addr) != reducedEDNSBufferSize {
+		t.Fatal("Confirmed buffer size forgotten after a timeout")
+	}
+}
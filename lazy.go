@@ -0,0 +1,33 @@
+package solvere
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+)
+
+// LazyValidationTimeout is the maximum amount of time the background validation
+// of an answer returned with RecursiveResolver.LazyValidation may take
+var LazyValidationTimeout = 30 * time.Second
+
+// validateLazily resolves and validates q in the background, the answer that was
+// returned for it is replaced in the cache by the validated one. Only one
+// validation of each question runs at a time.
+func (rr *RecursiveResolver) validateLazily(ctx context.Context, q Question, resolve resolveFunc) {
+	key := bogusKey(q)
+	if _, running := rr.lazy.LoadOrStore(key, struct{}{}); running {
+		return
+	}
+	defer rr.lazy.Delete(key)
+	atomic.AddInt64(&rr.counters().lazy, 1)
+	defer atomic.AddInt64(&rr.counters().lazy, -1)
+	// the lookup that triggered the validation has already returned, so its
+	// cancellation shouldn't stop the validation
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), LazyValidationTimeout)
+	defer cancel()
+	_, ll, err := rr.resolveRemembering(ctx, q, resolve)
+	if err != nil && isBogus(err) {
+		rr.log(LogWarn, "lazily validated answer is bogus", "name", q.Name, "err", err)
+		rr.runOnBogus(ctx, q, err, ll)
+	}
+}
@@ -0,0 +1,55 @@
+package solvere
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func TestLazyValidation(t *testing.T) {
+	root, example := newTestZone(t, "."), newTestZone(t, "example.")
+	a := &dns.A{Hdr: dns.RR_Header{Name: "a.example.", Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60}, A: net.IP{1, 2, 3, 4}}
+	responses := map[uint16][]dns.RR{
+		// signed by a key that isn't in the chain of trust
+		dns.TypeA:      newTestZone(t, "example.").sign(t, a),
+		dns.TypeDNSKEY: example.sign(t, example.key),
+		dns.TypeDS:     root.sign(t, example.key.ToDS(dns.SHA256)),
+	}
+	rr := NewRecursiveResolver(false, true, nil, []dns.RR{root.key}, nil)
+	rr.LazyValidation = true
+	rr.Forward = &ForwardConfig{Servers: []string{"10.0.0.1"}}
+	rr.Transport = transportFunc(func(_ context.Context, m *dns.Msg, _ string) (*dns.Msg, error) {
+		r := new(dns.Msg)
+		r.SetReply(m)
+		r.Answer = responses[m.Question[0].Qtype]
+		return r, nil
+	})
+	bogus := make(chan error, 1)
+	rr.AddHooks(Hooks{OnBogus: func(_ context.Context, q Question, err error, _ *LookupLog) {
+		bogus <- err
+	}})
+
+	q := Question{Name: "a.example.", Type: dns.TypeA}
+	ans, _, err := rr.Lookup(context.Background(), q)
+	if err != nil {
+		t.Fatalf("Lazy lookup failed: %s", err)
+	}
+	if ans.Authenticated {
+		t.Fatal("Answer returned before validation is authenticated")
+	}
+	var bogusErr error
+	select {
+	case bogusErr = <-bogus:
+	case <-time.After(5 * time.Second):
+		t.Fatal("OnBogus wasn't called")
+	}
+	if !isBogus(bogusErr) {
+		t.Fatalf("OnBogus called with unexpected error: %s", bogusErr)
+	}
+	if _, _, err := rr.Lookup(context.Background(), q); err != bogusErr {
+		t.Fatalf("Expected the bogus error once validated, got %v", err)
+	}
+}
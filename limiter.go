@@ -0,0 +1,81 @@
+package solvere
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+)
+
+// ErrResolverBusy is returned by RecursiveResolver.Lookup when the maximum number of
+// concurrent resolutions are already in progress and the lookup cannot be queued
+var ErrResolverBusy = errors.New("solvere: Too many concurrent resolutions")
+
+// ConcurrencyLimiter caps the number of resolutions a RecursiveResolver executes
+// concurrently so that a query storm can't exhaust file descriptors and memory.
+// Lookups started once the cap is reached either wait for a running resolution to
+// complete, if there is space in the queue, or fail immediately with ErrResolverBusy.
+type ConcurrencyLimiter struct {
+	sem      chan struct{}
+	maxQueue int64
+	queued   int64
+	// running counts the resolutions in progress when sem is nil and the number
+	// of them is unlimited
+	running int64
+}
+
+// NewConcurrencyLimiter returns a ConcurrencyLimiter allowing max concurrent
+// resolutions with up to maxQueue further lookups waiting for a slot, if maxQueue
+// is zero lookups fail as soon as the limit is reached. If max is zero or negative
+// the number of concurrent resolutions is unlimited, they are only counted.
+func NewConcurrencyLimiter(max, maxQueue int) *ConcurrencyLimiter {
+	if max <= 0 {
+		return &ConcurrencyLimiter{}
+	}
+	return &ConcurrencyLimiter{sem: make(chan struct{}, max), maxQueue: int64(maxQueue)}
+}
+
+// acquire waits for a resolution slot, it fails with ErrResolverBusy if the queue is
+// full or with the error from ctx if it is done before a slot becomes available
+func (cl *ConcurrencyLimiter) acquire(ctx context.Context) error {
+	if cl.sem == nil {
+		atomic.AddInt64(&cl.running, 1)
+		return nil
+	}
+	select {
+	case cl.sem <- struct{}{}:
+		return nil
+	default:
+	}
+	if atomic.AddInt64(&cl.queued, 1) > cl.maxQueue {
+		atomic.AddInt64(&cl.queued, -1)
+		return ErrResolverBusy
+	}
+	defer atomic.AddInt64(&cl.queued, -1)
+	select {
+	case cl.sem <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (cl *ConcurrencyLimiter) release() {
+	if cl.sem == nil {
+		atomic.AddInt64(&cl.running, -1)
+		return
+	}
+	<-cl.sem
+}
+
+// InFlight returns the number of resolutions currently executing
+func (cl *ConcurrencyLimiter) InFlight() int {
+	if cl.sem == nil {
+		return int(atomic.LoadInt64(&cl.running))
+	}
+	return len(cl.sem)
+}
+
+// Queued returns the number of lookups currently waiting for a resolution slot
+func (cl *ConcurrencyLimiter) Queued() int {
+	return int(atomic.LoadInt64(&cl.queued))
+}
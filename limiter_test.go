@@ -0,0 +1,77 @@
+package solvere
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func TestConcurrencyLimiter(t *testing.T) {
+	cl := NewConcurrencyLimiter(1, 1)
+	if err := cl.acquire(context.Background()); err != nil {
+		t.Fatalf("Failed to acquire free slot: %s", err)
+	}
+	acquired := make(chan error, 1)
+	go func() { acquired <- cl.acquire(context.Background()) }()
+	for cl.Queued() != 1 {
+		time.Sleep(time.Millisecond)
+	}
+	if err := cl.acquire(context.Background()); err != ErrResolverBusy {
+		t.Fatalf("Acquire with a full queue didn't fail with ErrResolverBusy: %v", err)
+	}
+	cl.release()
+	if err := <-acquired; err != nil {
+		t.Fatalf("Queued acquire failed: %s", err)
+	}
+	if cl.InFlight() != 1 || cl.Queued() != 0 {
+		t.Fatalf("Unexpected limiter state: %d in flight, %d queued", cl.InFlight(), cl.Queued())
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := cl.acquire(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("Queued acquire didn't fail when its context expired: %v", err)
+	}
+
+	unlimited := NewConcurrencyLimiter(0, 0)
+	for i := 0; i < 3; i++ {
+		if err := unlimited.acquire(context.Background()); err != nil {
+			t.Fatalf("Acquire from an unlimited limiter failed: %s", err)
+		}
+	}
+	unlimited.release()
+	if unlimited.InFlight() != 2 {
+		t.Fatalf("Unlimited limiter has %d in flight, expected 2", unlimited.InFlight())
+	}
+}
+
+func TestLimitedLookup(t *testing.T) {
+	rr := &RecursiveResolver{
+		c:               new(dns.Client),
+		rootNameservers: []Nameserver{{Name: "a.root-servers.net.", Addr: "127.0.0.1", Zone: "."}},
+		Limiter:         NewConcurrencyLimiter(1, 0),
+	}
+	sent, unblock := make(chan struct{}), make(chan struct{})
+	var once sync.Once
+	rr.Transport = transportFunc(func(_ context.Context, m *dns.Msg, _ string) (*dns.Msg, error) {
+		once.Do(func() { close(sent) })
+		<-unblock
+		return new(dns.Msg).SetRcode(m, dns.RcodeNameError), nil
+	})
+	done := make(chan error, 1)
+	go func() {
+		_, _, err := rr.Lookup(context.Background(), Question{Name: "a.com.", Type: dns.TypeA})
+		done <- err
+	}()
+	<-sent
+	if _, _, err := rr.Lookup(context.Background(), Question{Name: "b.com.", Type: dns.TypeA}); err != ErrResolverBusy {
+		t.Fatalf("Lookup while saturated didn't fail with ErrResolverBusy: %v", err)
+	}
+	close(unblock)
+	<-done
+	if rr.Limiter.InFlight() != 0 {
+		t.Fatal("Resolution slot wasn't released after Lookup returned")
+	}
+}
@@ -0,0 +1,110 @@
+package solvere
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/miekg/dns"
+)
+
+// ErrResponseLimits is returned when a response from a remote nameserver exceeds
+// the ResponseLimits of the resolver
+var ErrResponseLimits = errors.New("solvere: Response exceeds limits")
+
+var (
+	// DefaultMaxSectionRecords is the default maximum number of records in a
+	// single section of a response
+	DefaultMaxSectionRecords = 1024
+	// DefaultMaxNameLength is the default maximum length of a name in a response,
+	// in presentation format
+	DefaultMaxNameLength = 255
+)
+
+// ResponseLimits bounds the memory and CPU spent processing a single response from
+// a remote nameserver. Responses which exceed any of the limits are discarded as
+// though no response had been received. Zero fields use the defaults.
+//
+// Transports return parsed messages, so the limits are applied after a response
+// has been unpacked and only bound the work done on it by the resolver (caching,
+// validation, following referrals). The size of a response on the wire is bounded
+// by the transports, by the advertised EDNS buffer size for UDP and by the 16 bit
+// length prefix for TCP.
+type ResponseLimits struct {
+	// MaxSectionRecords is the maximum number of records in each of the answer,
+	// authority and additional sections, if zero DefaultMaxSectionRecords is used
+	MaxSectionRecords int
+	// MaxNameLength is the maximum length of the owner names of records, and the
+	// names in their data, in presentation format. If zero DefaultMaxNameLength is
+	// used.
+	MaxNameLength int
+}
+
+func (rl ResponseLimits) maxSectionRecords() int {
+	if rl.MaxSectionRecords > 0 {
+		return rl.MaxSectionRecords
+	}
+	return DefaultMaxSectionRecords
+}
+
+func (rl ResponseLimits) maxNameLength() int {
+	if rl.MaxNameLength > 0 {
+		return rl.MaxNameLength
+	}
+	return DefaultMaxNameLength
+}
+
+// check returns an error if r exceeds any of the limits. The record counts are
+// checked first so the names of a response with too many records are never walked.
+func (rl ResponseLimits) check(r *dns.Msg) error {
+	max := rl.maxSectionRecords()
+	for _, section := range []struct {
+		name    string
+		records []dns.RR
+	}{{"answer", r.Answer}, {"authority", r.Ns}, {"additional", r.Extra}} {
+		if len(section.records) > max {
+			return fmt.Errorf("%w: %d records in %s section", ErrResponseLimits, len(section.records), section.name)
+		}
+	}
+	maxName := rl.maxNameLength()
+	for _, q := range r.Question {
+		if len(q.Name) > maxName {
+			return fmt.Errorf("%w: question name is %d characters long", ErrResponseLimits, len(q.Name))
+		}
+	}
+	for _, section := range [][]dns.RR{r.Answer, r.Ns, r.Extra} {
+		for _, record := range section {
+			for _, name := range recordNames(record) {
+				if len(name) > maxName {
+					return fmt.Errorf("%w: %s record contains a %d character long name", ErrResponseLimits, dns.TypeToString[record.Header().Rrtype], len(name))
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// recordNames returns the owner name of record and the names in its data
+func recordNames(record dns.RR) []string {
+	names := []string{record.Header().Name}
+	switch r := record.(type) {
+	case *dns.NS:
+		names = append(names, r.Ns)
+	case *dns.CNAME:
+		names = append(names, r.Target)
+	case *dns.DNAME:
+		names = append(names, r.Target)
+	case *dns.PTR:
+		names = append(names, r.Ptr)
+	case *dns.MX:
+		names = append(names, r.Mx)
+	case *dns.SRV:
+		names = append(names, r.Target)
+	case *dns.SOA:
+		names = append(names, r.Ns, r.Mbox)
+	case *dns.RRSIG:
+		names = append(names, r.SignerName)
+	case *dns.NSEC:
+		names = append(names, r.NextDomain)
+	}
+	return names
+}
@@ -0,0 +1,62 @@
+package solvere
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestResponseLimits(t *testing.T) {
+	newResponse := func(records int) *dns.Msg {
+		r := new(dns.Msg)
+		r.SetQuestion("a.example.", dns.TypeA)
+		for i := 0; i < records; i++ {
+			r.Answer = append(r.Answer, &dns.A{Hdr: dns.RR_Header{Name: "a.example.", Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60}, A: net.IP{10, 0, 0, byte(i)}})
+		}
+		return r
+	}
+	var limits ResponseLimits
+	if err := limits.check(newResponse(10)); err != nil {
+		t.Fatalf("Response within the default limits rejected: %s", err)
+	}
+	if err := limits.check(newResponse(DefaultMaxSectionRecords + 1)); err == nil || !errors.Is(err, ErrResponseLimits) {
+		t.Fatalf("Response with too many records accepted: %v", err)
+	}
+
+	limits = ResponseLimits{MaxSectionRecords: 4}
+	if err := limits.check(newResponse(5)); err == nil {
+		t.Fatal("Response exceeding MaxSectionRecords accepted")
+	}
+	limits = ResponseLimits{MaxNameLength: 12}
+	r := newResponse(0)
+	r.Ns = []dns.RR{&dns.NS{Hdr: dns.RR_Header{Name: "example.", Rrtype: dns.TypeNS, Class: dns.ClassINET, Ttl: 60}, Ns: "a-long-nameserver.example."}}
+	if err := limits.check(r); err == nil {
+		t.Fatal("Response with a name in record data exceeding MaxNameLength accepted")
+	}
+	r.Ns[0].(*dns.NS).Ns = "ns.example."
+	if err := limits.check(r); err != nil {
+		t.Fatalf("Response within MaxNameLength rejected: %s", err)
+	}
+}
+
+func TestResponseLimitsLookup(t *testing.T) {
+	rr := NewRecursiveResolver(false, false, nil, nil, nil)
+	rr.Forward = &ForwardConfig{Servers: []string{"10.0.0.1"}, Attempts: 1}
+	rr.ResponseLimits.MaxSectionRecords = 2
+	rr.Transport = transportFunc(func(_ context.Context, m *dns.Msg, _ string) (*dns.Msg, error) {
+		r := new(dns.Msg)
+		r.SetReply(m)
+		for i := 0; i < 3; i++ {
+			r.Answer = append(r.Answer, &dns.A{Hdr: dns.RR_Header{Name: m.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60}, A: net.IP{10, 0, 0, byte(i)}})
+		}
+		return r, nil
+	})
+	_, _, err := rr.Lookup(context.Background(), Question{Name: "a.example.", Type: dns.TypeA})
+	var re *ResolutionError
+	if !errors.As(err, &re) || re.Stage != StageQuery || !errors.Is(err, ErrResponseLimits) {
+		t.Fatalf("Expected the response to be discarded, got %v", err)
+	}
+}
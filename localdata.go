@@ -0,0 +1,54 @@
+package solvere
+
+import (
+	"context"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// LocalData is a set of records used to answer queries without resolving them,
+// similar to the Unbound local-data directive in a transparent local zone. Queries
+// for names which have local records are answered using them, the answer is empty
+// (NODATA) if there are no records of the requested type, and queries for all other
+// names are resolved as normal.
+type LocalData struct {
+	records map[string][]dns.RR
+}
+
+// NewLocalData returns a LocalData containing records
+func NewLocalData(records []dns.RR) *LocalData {
+	ld := &LocalData{records: make(map[string][]dns.RR)}
+	for _, r := range records {
+		ld.Add(r)
+	}
+	return ld
+}
+
+// Add adds a record, it must not be called once the LocalData is in use
+func (ld *LocalData) Add(r dns.RR) {
+	name := strings.ToLower(r.Header().Name)
+	ld.records[name] = append(ld.records[name], r)
+}
+
+// lookup returns the answer for q, or nil if there are no local records for the
+// name
+func (ld *LocalData) lookup(q Question) *Answer {
+	records, present := ld.records[strings.ToLower(q.Name)]
+	if !present {
+		return nil
+	}
+	a := &Answer{Rcode: dns.RcodeSuccess}
+	for _, r := range records {
+		if t := r.Header().Rrtype; q.Type == dns.TypeANY || t == q.Type || t == dns.TypeCNAME {
+			a.Answer = append(a.Answer, dns.Copy(r))
+		}
+	}
+	return a
+}
+
+// Lookup answers q using the local records, so a LocalData other than the
+// resolver's, such as one returned by ParseHosts, can be added to its Sources
+func (ld *LocalData) Lookup(_ context.Context, _ *RecursiveResolver, q Question) (*Answer, *LookupLog, error) {
+	return ld.lookup(q), nil, nil
+}
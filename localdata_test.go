@@ -0,0 +1,53 @@
+package solvere
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestLocalData(t *testing.T) {
+	rr := NewRecursiveResolver(false, false, nil, nil, nil)
+	rr.Forward = &ForwardConfig{Servers: []string{"10.0.0.1"}}
+	sent := 0
+	rr.Transport = transportFunc(func(_ context.Context, m *dns.Msg, _ string) (*dns.Msg, error) {
+		sent++
+		return nil, errors.New("broken")
+	})
+	rr.LocalData = NewLocalData([]dns.RR{
+		&dns.A{Hdr: dns.RR_Header{Name: "Printer.lan.", Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 300}, A: net.IP{192, 168, 1, 5}},
+		&dns.CNAME{Hdr: dns.RR_Header{Name: "www.lan.", Rrtype: dns.TypeCNAME, Class: dns.ClassINET, Ttl: 300}, Target: "printer.lan."},
+	})
+
+	a, _, err := rr.Lookup(context.Background(), Question{Name: "printer.lan.", Type: dns.TypeA})
+	if err != nil {
+		t.Fatalf("Lookup failed: %s", err)
+	}
+	if len(a.Answer) != 1 || a.Rcode != dns.RcodeSuccess {
+		t.Fatalf("Unexpected answer: %v", a)
+	}
+	a, _, err = rr.Lookup(context.Background(), Question{Name: "printer.lan.", Type: dns.TypeAAAA})
+	if err != nil {
+		t.Fatalf("Lookup failed: %s", err)
+	}
+	if len(a.Answer) != 0 || a.Rcode != dns.RcodeSuccess {
+		t.Fatalf("Expected NODATA answer, got %v", a)
+	}
+	a, _, err = rr.Lookup(context.Background(), Question{Name: "www.lan.", Type: dns.TypeA})
+	if err != nil {
+		t.Fatalf("Lookup failed: %s", err)
+	}
+	if len(a.Answer) != 1 || a.Answer[0].Header().Rrtype != dns.TypeCNAME {
+		t.Fatalf("Expected CNAME answer, got %v", a)
+	}
+	if sent != 0 {
+		t.Fatalf("Local data queries were sent upstream")
+	}
+
+	if _, _, err = rr.Lookup(context.Background(), Question{Name: "other.lan.", Type: dns.TypeA}); err == nil || sent == 0 {
+		t.Fatal("Names without local data weren't resolved")
+	}
+}
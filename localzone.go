@@ -0,0 +1,232 @@
+package solvere
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/miekg/dns"
+)
+
+var (
+	// MaxLocalAliases is the maximum number of CNAMEs followed within a local
+	// zone when answering a single query
+	MaxLocalAliases = 8
+
+	ErrNoSOA       = errors.New("solvere: Local zone has no SOA record at its origin")
+	ErrOutOfZone   = errors.New("solvere: Local zone contains a record outside of its origin")
+	ErrMultipleSOA = errors.New("solvere: Local zone has more than one SOA record")
+)
+
+// LocalZone is a zone that is answered authoritatively from a set of records, such
+// as the contents of a RFC 1035 master file, instead of being resolved. Names
+// below delegations (NS records other than at the origin) aren't served and are
+// resolved as normal.
+type LocalZone struct {
+	// Origin is the lower cased, fully qualified, name of the zone apex
+	Origin string
+
+	// mu protects the contents of the zone, which are replaced when a
+	// SecondaryZone is transferred
+	mu      sync.RWMutex
+	soa     *dns.SOA
+	records map[string][]dns.RR
+	// names contains every name that exists in the zone, including empty
+	// non-terminals
+	names map[string]struct{}
+	cuts  map[string]struct{}
+}
+
+// NewLocalZone returns a LocalZone for origin containing records, which must
+// include a single SOA record at origin
+func NewLocalZone(origin string, records []dns.RR) (*LocalZone, error) {
+	lz := &LocalZone{
+		Origin:  CanonicalName(origin),
+		records: make(map[string][]dns.RR),
+		names:   make(map[string]struct{}),
+		cuts:    make(map[string]struct{}),
+	}
+	for _, r := range records {
+		h := r.Header()
+		name := strings.ToLower(h.Name)
+		if !isSubdomain(name, lz.Origin) {
+			return nil, fmt.Errorf("%w: %s", ErrOutOfZone, r)
+		}
+		switch h.Rrtype {
+		case dns.TypeSOA:
+			if name != lz.Origin {
+				return nil, fmt.Errorf("%w: %s", ErrOutOfZone, r)
+			}
+			if lz.soa != nil {
+				return nil, ErrMultipleSOA
+			}
+			lz.soa = r.(*dns.SOA)
+		case dns.TypeNS:
+			if name != lz.Origin {
+				lz.cuts[name] = struct{}{}
+			}
+		}
+		lz.records[name] = append(lz.records[name], r)
+		for _, zone := range enclosingZones(name) {
+			if _, present := lz.names[zone]; present || !isSubdomain(zone, lz.Origin) {
+				break
+			}
+			lz.names[zone] = struct{}{}
+		}
+	}
+	if lz.soa == nil {
+		return nil, ErrNoSOA
+	}
+	return lz, nil
+}
+
+// ParseLocalZone parses a RFC 1035 master file for origin, file is used to resolve
+// $INCLUDE directives and in error messages
+func ParseLocalZone(r io.Reader, origin, file string) (*LocalZone, error) {
+	var records []dns.RR
+	var err error
+	for t := range dns.ParseZone(r, dns.Fqdn(origin), file) {
+		// the channel has to be drained even after an error
+		if t.Error != nil {
+			if err == nil {
+				err = t.Error
+			}
+			continue
+		}
+		records = append(records, t.RR)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return NewLocalZone(origin, records)
+}
+
+// LoadLocalZone reads and parses the master file for origin at path
+func LoadLocalZone(path, origin string) (*LocalZone, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ParseLocalZone(f, origin, path)
+}
+
+// negativeSOA returns the SOA record included in negative answers, with its TTL
+// set to the negative caching TTL (RFC 2308 Section 3)
+func (lz *LocalZone) negativeSOA() []dns.RR {
+	soa := dns.Copy(lz.soa)
+	if lz.soa.Minttl < soa.Header().Ttl {
+		soa.Header().Ttl = lz.soa.Minttl
+	}
+	return []dns.RR{soa}
+}
+
+// delegated checks if name is at or below a zone cut, DS records at the cut itself
+// belong to the parent side and are still served
+func (lz *LocalZone) delegated(name string, qtype uint16) bool {
+	for _, zone := range enclosingZones(name) {
+		if zone == lz.Origin {
+			return false
+		}
+		if _, present := lz.cuts[zone]; present && (zone != name || qtype != dns.TypeDS) {
+			return true
+		}
+	}
+	return false
+}
+
+// match returns copies of the records owned by name that answer a query for qtype,
+// with their owner names set to owner
+func (lz *LocalZone) match(name, owner string, qtype uint16) []dns.RR {
+	var answer []dns.RR
+	for _, r := range lz.records[name] {
+		if t := r.Header().Rrtype; qtype == dns.TypeANY || t == qtype || t == dns.TypeCNAME {
+			c := dns.Copy(r)
+			c.Header().Name = owner
+			answer = append(answer, c)
+		}
+	}
+	return answer
+}
+
+// find returns the records answering a query for name, synthesizing them from a
+// wildcard if necessary (RFC 4592), and whether the name exists
+func (lz *LocalZone) find(name, owner string, qtype uint16) ([]dns.RR, bool) {
+	if _, present := lz.names[name]; present {
+		return lz.match(name, owner, qtype), true
+	}
+	// the closest encloser is the longest existing ancestor of name
+	for _, zone := range enclosingZones(name)[1:] {
+		if _, present := lz.names[zone]; present {
+			wildcard := "*." + zone
+			if _, present := lz.records[wildcard]; present {
+				return lz.match(wildcard, owner, qtype), true
+			}
+			break
+		}
+	}
+	return nil, false
+}
+
+// replace swaps the contents of lz for those of nz, if nz is nil lz is emptied
+func (lz *LocalZone) replace(nz *LocalZone) {
+	lz.mu.Lock()
+	defer lz.mu.Unlock()
+	if nz == nil {
+		lz.soa, lz.records, lz.names, lz.cuts = nil, nil, nil, nil
+		return
+	}
+	lz.soa, lz.records, lz.names, lz.cuts = nz.soa, nz.records, nz.names, nz.cuts
+}
+
+// lookup returns the authoritative answer for q, or nil if q isn't in the zone, is
+// below a delegation, or the zone is empty
+func (lz *LocalZone) lookup(q Question) *Answer {
+	lz.mu.RLock()
+	defer lz.mu.RUnlock()
+	name := strings.ToLower(q.Name)
+	if lz.soa == nil || !isSubdomain(name, lz.Origin) || lz.delegated(name, q.Type) {
+		return nil
+	}
+	a := &Answer{Rcode: dns.RcodeSuccess, Authoritative: true}
+	owner := q.Name
+	for i := 0; i <= MaxLocalAliases; i++ {
+		records, exists := lz.find(name, owner, q.Type)
+		if !exists {
+			// the rcode describes the last name in the alias chain (RFC 6604)
+			a.Rcode = dns.RcodeNameError
+			a.Authority = lz.negativeSOA()
+			return a
+		}
+		a.Answer = append(a.Answer, records...)
+		if len(records) == 0 {
+			a.Authority = lz.negativeSOA()
+			return a
+		}
+		cname, ok := records[0].(*dns.CNAME)
+		if !ok || len(records) != 1 || q.Type == dns.TypeCNAME || q.Type == dns.TypeANY {
+			return a
+		}
+		// follow the alias if the target is also in the zone, otherwise the
+		// client has to chase it
+		name, owner = strings.ToLower(cname.Target), cname.Target
+		if !isSubdomain(name, lz.Origin) || lz.delegated(name, q.Type) {
+			return a
+		}
+	}
+	return a
+}
+
+// localZone returns the most specific local zone containing name
+func (rr *RecursiveResolver) localZone(name string) *LocalZone {
+	var best *LocalZone
+	for _, lz := range rr.LocalZones {
+		if isSubdomain(name, lz.Origin) && (best == nil || dns.CountLabel(lz.Origin) > dns.CountLabel(best.Origin)) {
+			best = lz
+		}
+	}
+	return best
+}
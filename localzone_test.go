@@ -0,0 +1,101 @@
+package solvere
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+const testZoneFile = `$TTL 3600
+@	IN SOA ns1 hostmaster 1 7200 900 1209600 300
+	IN NS ns1
+ns1	IN A 192.0.2.53
+www	IN A 192.0.2.1
+	IN AAAA 2001:db8::1
+alias	IN CNAME www
+ext	IN CNAME www.example.net.
+*.wild	IN TXT "wildcard"
+a.b.c	IN A 192.0.2.2
+sub	IN NS ns.sub
+	IN DS 12345 8 2 E06D44B80B8F1D39A95C0B0D7C65D08458E880409BBC683457104237C7F8EC8D
+ns.sub	IN A 192.0.2.54
+`
+
+func TestLocalZone(t *testing.T) {
+	lz, err := ParseLocalZone(strings.NewReader(testZoneFile), "Example.com", "")
+	if err != nil {
+		t.Fatalf("ParseLocalZone failed: %s", err)
+	}
+	for _, tc := range []struct {
+		name      string
+		qtype     uint16
+		rcode     int
+		answers   int
+		authority bool
+	}{
+		{"www.example.com.", dns.TypeA, dns.RcodeSuccess, 1, false},
+		{"WWW.example.com.", dns.TypeANY, dns.RcodeSuccess, 2, false},
+		{"www.example.com.", dns.TypeMX, dns.RcodeSuccess, 0, true},
+		{"alias.example.com.", dns.TypeAAAA, dns.RcodeSuccess, 2, false},
+		{"alias.example.com.", dns.TypeCNAME, dns.RcodeSuccess, 1, false},
+		{"ext.example.com.", dns.TypeA, dns.RcodeSuccess, 1, false},
+		{"x.wild.example.com.", dns.TypeTXT, dns.RcodeSuccess, 1, false},
+		{"x.y.wild.example.com.", dns.TypeTXT, dns.RcodeSuccess, 1, false},
+		{"x.wild.example.com.", dns.TypeA, dns.RcodeSuccess, 0, true},
+		{"b.c.example.com.", dns.TypeA, dns.RcodeSuccess, 0, true}, // empty non-terminal
+		{"missing.example.com.", dns.TypeA, dns.RcodeNameError, 0, true},
+		{"sub.example.com.", dns.TypeDS, dns.RcodeSuccess, 1, false},
+	} {
+		a := lz.lookup(Question{Name: tc.name, Type: tc.qtype})
+		if a == nil {
+			t.Errorf("%s %s: no answer", tc.name, dns.TypeToString[tc.qtype])
+			continue
+		}
+		if a.Rcode != tc.rcode || len(a.Answer) != tc.answers || (len(a.Authority) == 1) != tc.authority || !a.Authoritative {
+			t.Errorf("%s %s: unexpected answer %#v", tc.name, dns.TypeToString[tc.qtype], a)
+		}
+		if len(a.Answer) > 0 && !strings.EqualFold(a.Answer[0].Header().Name, tc.name) {
+			t.Errorf("%s %s: answer has the wrong owner %s", tc.name, dns.TypeToString[tc.qtype], a.Answer[0])
+		}
+		if tc.authority && a.Authority[0].Header().Ttl != 300 {
+			t.Errorf("%s %s: negative SOA TTL isn't the minimum TTL", tc.name, dns.TypeToString[tc.qtype])
+		}
+	}
+	for _, name := range []string{"sub.example.com.", "ns.sub.example.com.", "example.net."} {
+		if a := lz.lookup(Question{Name: name, Type: dns.TypeA}); a != nil {
+			t.Errorf("%s: expected no local answer, got %#v", name, a)
+		}
+	}
+
+	if _, err = ParseLocalZone(strings.NewReader("www IN A 192.0.2.1\n"), "example.com.", ""); err != ErrNoSOA {
+		t.Fatalf("Expected ErrNoSOA, got %v", err)
+	}
+	if _, err = ParseLocalZone(strings.NewReader(testZoneFile+"www.example.net. IN A 192.0.2.1\n"), "example.com.", ""); err == nil {
+		t.Fatal("ParseLocalZone didn't reject a out of zone record")
+	}
+}
+
+func TestLookupLocalZone(t *testing.T) {
+	lz, err := ParseLocalZone(strings.NewReader(testZoneFile), "example.com.", "")
+	if err != nil {
+		t.Fatalf("ParseLocalZone failed: %s", err)
+	}
+	rr := NewRecursiveResolver(false, false, nil, nil, nil)
+	rr.Forward = &ForwardConfig{Servers: []string{"10.0.0.1"}}
+	rr.Transport = transportFunc(func(context.Context, *dns.Msg, string) (*dns.Msg, error) {
+		return nil, errors.New("broken")
+	})
+	rr.LocalZones = []*LocalZone{lz}
+	m := new(dns.Msg)
+	m.SetQuestion("missing.example.com.", dns.TypeA)
+	r := NewHandler(rr).respond(context.Background(), m)
+	if r.Rcode != dns.RcodeNameError || !r.Authoritative {
+		t.Fatalf("Unexpected response: %s", r)
+	}
+	if _, _, err = rr.Lookup(context.Background(), Question{Name: "www.example.org.", Type: dns.TypeA}); err == nil {
+		t.Fatal("Names outside of the local zone weren't resolved")
+	}
+}
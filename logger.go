@@ -0,0 +1,109 @@
+package solvere
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+)
+
+// LogLevel is the severity of a message passed to a Logger
+type LogLevel int
+
+const (
+	// LogDebug messages describe the details of individual resolutions
+	LogDebug LogLevel = iota
+	// LogInfo messages describe expected events, such as a zone being
+	// transferred
+	LogInfo
+	// LogWarn messages describe problems with remote servers or the
+	// configuration that solvere works around
+	LogWarn
+	// LogError messages describe failures that need attention
+	LogError
+)
+
+var levelStrings = map[LogLevel]string{
+	LogDebug: "debug",
+	LogInfo:  "info",
+	LogWarn:  "warn",
+	LogError: "error",
+}
+
+func (l LogLevel) String() string {
+	if str, present := levelStrings[l]; present {
+		return str
+	}
+	return fmt.Sprintf("LogLevel(%d)", int(l))
+}
+
+// Logger receives the diagnostics emitted by solvere. The message is a short
+// constant description of the event and keyvals are alternating string keys
+// and values describing it, the same convention used by log/slog and zap's
+// SugaredLogger. Implementations must be safe for concurrent use.
+type Logger interface {
+	Log(level LogLevel, msg string, keyvals ...interface{})
+}
+
+// SlogLogger returns a Logger that writes to l
+func SlogLogger(l *slog.Logger) Logger {
+	return slogLogger{l}
+}
+
+type slogLogger struct {
+	l *slog.Logger
+}
+
+var slogLevels = map[LogLevel]slog.Level{
+	LogDebug: slog.LevelDebug,
+	LogInfo:  slog.LevelInfo,
+	LogWarn:  slog.LevelWarn,
+	LogError: slog.LevelError,
+}
+
+func (sl slogLogger) Log(level LogLevel, msg string, keyvals ...interface{}) {
+	sl.l.Log(context.Background(), slogLevels[level], msg, keyvals...)
+}
+
+// SugaredLogger is the subset of the methods of *zap.SugaredLogger used by
+// ZapLogger, so solvere doesn't depend on zap
+type SugaredLogger interface {
+	Debugw(msg string, keysAndValues ...interface{})
+	Infow(msg string, keysAndValues ...interface{})
+	Warnw(msg string, keysAndValues ...interface{})
+	Errorw(msg string, keysAndValues ...interface{})
+}
+
+// ZapLogger returns a Logger that writes to l, which is usually a
+// *zap.SugaredLogger
+func ZapLogger(l SugaredLogger) Logger {
+	return zapLogger{l}
+}
+
+type zapLogger struct {
+	l SugaredLogger
+}
+
+func (zl zapLogger) Log(level LogLevel, msg string, keyvals ...interface{}) {
+	switch level {
+	case LogDebug:
+		zl.l.Debugw(msg, keyvals...)
+	case LogInfo:
+		zl.l.Infow(msg, keyvals...)
+	case LogWarn:
+		zl.l.Warnw(msg, keyvals...)
+	default:
+		zl.l.Errorw(msg, keyvals...)
+	}
+}
+
+// logTo passes a message to l if it isn't nil
+func logTo(l Logger, level LogLevel, msg string, keyvals ...interface{}) {
+	if l != nil {
+		l.Log(level, msg, keyvals...)
+	}
+}
+
+// log passes a message to the resolver's Logger, if it has one
+func (rr *RecursiveResolver) log(level LogLevel, msg string, keyvals ...interface{}) {
+	logTo(rr.Logger, level, msg, keyvals...)
+}
@@ -0,0 +1,67 @@
+package solvere
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+type testLogger struct {
+	messages []string
+}
+
+func (tl *testLogger) Log(level LogLevel, msg string, keyvals ...interface{}) {
+	tl.messages = append(tl.messages, fmt.Sprintf("%s %s %v", level, msg, keyvals))
+}
+
+type testSugaredLogger struct {
+	testLogger
+}
+
+func (tsl *testSugaredLogger) Debugw(msg string, kv ...interface{}) { tsl.Log(LogDebug, msg, kv...) }
+func (tsl *testSugaredLogger) Infow(msg string, kv ...interface{})  { tsl.Log(LogInfo, msg, kv...) }
+func (tsl *testSugaredLogger) Warnw(msg string, kv ...interface{})  { tsl.Log(LogWarn, msg, kv...) }
+func (tsl *testSugaredLogger) Errorw(msg string, kv ...interface{}) { tsl.Log(LogError, msg, kv...) }
+
+func TestLoggerAdapters(t *testing.T) {
+	b := new(bytes.Buffer)
+	l := SlogLogger(slog.New(slog.NewTextHandler(b, &slog.HandlerOptions{Level: slog.LevelInfo})))
+	l.Log(LogDebug, "hidden")
+	l.Log(LogWarn, "zone transfer failed", "zone", "example.")
+	if out := b.String(); strings.Contains(out, "hidden") || !strings.Contains(out, "level=WARN") || !strings.Contains(out, "zone=example.") {
+		t.Fatalf("Unexpected slog output %q", out)
+	}
+
+	tsl := &testSugaredLogger{}
+	zl := ZapLogger(tsl)
+	for _, level := range []LogLevel{LogDebug, LogInfo, LogWarn, LogError} {
+		zl.Log(level, "message", "key", "value")
+	}
+	expected := []string{"debug message [key value]", "info message [key value]", "warn message [key value]", "error message [key value]"}
+	if strings.Join(tsl.messages, "\n") != strings.Join(expected, "\n") {
+		t.Fatalf("Unexpected messages %v", tsl.messages)
+	}
+}
+
+func TestResolverLogger(t *testing.T) {
+	rr := NewRecursiveResolver(false, false, nil, nil, nil)
+	rr.Forward = &ForwardConfig{Servers: []string{"10.0.0.1"}, Attempts: 1}
+	rr.Transport = transportFunc(func(_ context.Context, m *dns.Msg, _ string) (*dns.Msg, error) {
+		return nil, errors.New("timeout")
+	})
+	// the resolver works without a Logger
+	rr.Lookup(context.Background(), Question{Name: "a.example.", Type: dns.TypeA})
+
+	tl := &testLogger{}
+	rr.Logger = tl
+	rr.Lookup(context.Background(), Question{Name: "b.example.", Type: dns.TypeA})
+	if len(tl.messages) != 1 || !strings.HasPrefix(tl.messages[0], "debug upstream query failed [server 10.0.0.1") {
+		t.Fatalf("Unexpected messages %v", tl.messages)
+	}
+}
@@ -0,0 +1,140 @@
+package solvere
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// CacheKind identifies the cache a step of a lookup was answered from
+type CacheKind int
+
+const (
+	// CacheNone indicates the step wasn't answered from a cache
+	CacheNone CacheKind = iota
+	// CacheAnswer indicates the step was answered from the question/answer
+	// cache
+	CacheAnswer
+	// CacheInfrastructure indicates the step was answered from data cached to
+	// drive resolution rather than answer questions, such as glue addresses
+	// or DNSKEY records
+	CacheInfrastructure
+	// CacheNegative indicates the step was answered from the caches of recent
+	// resolution or validation failures
+	CacheNegative
+)
+
+var cacheKindStrings = map[CacheKind]string{
+	CacheNone:           "none",
+	CacheAnswer:         "answer",
+	CacheInfrastructure: "infrastructure",
+	CacheNegative:       "negative",
+}
+
+func (ck CacheKind) String() string {
+	if str, present := cacheKindStrings[ck]; present {
+		return str
+	}
+	return fmt.Sprintf("CacheKind(%d)", int(ck))
+}
+
+// MarshalText encodes the kind as its name, so it is readable in the JSON
+// encoding of a LookupLog
+func (ck CacheKind) MarshalText() ([]byte, error) {
+	return []byte(ck.String()), nil
+}
+
+// UnmarshalText decodes a kind encoded by MarshalText
+func (ck *CacheKind) UnmarshalText(text []byte) error {
+	for k, str := range cacheKindStrings {
+		if str == string(text) {
+			*ck = k
+			return nil
+		}
+	}
+	return fmt.Errorf("solvere: Unknown cache kind %q", text)
+}
+
+// JSON returns the JSON encoding of the LookupLog tree
+func (ll *LookupLog) JSON() ([]byte, error) {
+	return json.Marshal(ll)
+}
+
+// WriteDOT writes the LookupLog tree to w in the Graphviz DOT language. Each
+// step of the lookup is rendered as a node and composite lookups (referrals,
+// DNSKEY fetches, authority address lookups) are rendered as edges from the
+// step that triggered them.
+func (ll *LookupLog) WriteDOT(w io.Writer) error {
+	b := new(bytes.Buffer)
+	fmt.Fprintln(b, "digraph lookup {")
+	fmt.Fprintln(b, "\tnode [shape=box, fontname=monospace];")
+	id := 0
+	ll.writeDOTNode(b, &id)
+	fmt.Fprintln(b, "}")
+	_, err := w.Write(b.Bytes())
+	return err
+}
+
+// DOT returns the LookupLog tree in the Graphviz DOT language, see WriteDOT
+func (ll *LookupLog) DOT() string {
+	b := new(bytes.Buffer)
+	ll.WriteDOT(b) // writing to a bytes.Buffer never fails
+	return b.String()
+}
+
+func (ll *LookupLog) writeDOTNode(b *bytes.Buffer, id *int) int {
+	self := *id
+	*id++
+	attrs := ""
+	switch {
+	case ll.Error != "":
+		attrs = ", color=red"
+	case ll.DNSSECValid:
+		attrs = ", color=darkgreen"
+	}
+	fmt.Fprintf(b, "\tn%d [label=%q%s];\n", self, ll.dotLabel(), attrs)
+	for _, c := range ll.Composites {
+		if c == nil {
+			continue
+		}
+		child := c.writeDOTNode(b, id)
+		fmt.Fprintf(b, "\tn%d -> n%d;\n", self, child)
+	}
+	return self
+}
+
+func (ll *LookupLog) dotLabel() string {
+	lines := []string{}
+	if ll.Query != nil {
+		lines = append(lines, fmt.Sprintf("%s %s", ll.Query.Name, dns.TypeToString[ll.Query.Type]))
+	}
+	if ll.NS != nil {
+		lines = append(lines, fmt.Sprintf("@%s (%s) zone %s", ll.NS.Name, ll.NS.Addr, ll.NS.Zone))
+	}
+	flags := []string{dns.RcodeToString[ll.Rcode]}
+	if ll.CacheHit {
+		if ll.Cache != CacheNone {
+			flags = append(flags, "cached:"+ll.Cache.String())
+		} else {
+			flags = append(flags, "cached")
+		}
+	}
+	if ll.DNSSECValid {
+		flags = append(flags, "secure")
+	}
+	if ll.Truncated {
+		flags = append(flags, "truncated")
+	}
+	if ll.Referral {
+		flags = append(flags, "referral")
+	}
+	lines = append(lines, strings.Join(flags, " "), ll.Latency.String())
+	if ll.Error != "" {
+		lines = append(lines, "error: "+ll.Error)
+	}
+	return strings.Join(lines, "\n")
+}
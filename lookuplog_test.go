@@ -0,0 +1,110 @@
+package solvere
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func testLookupLogTree() *LookupLog {
+	q := &Question{Name: "example.com.", Type: dns.TypeA}
+	return &LookupLog{
+		Query:       q,
+		DNSSECValid: true,
+		Composites: []*LookupLog{
+			{
+				Query:    q,
+				NS:       &Nameserver{Name: "a.root-servers.net.", Addr: "198.41.0.4", Zone: "."},
+				Referral: true,
+				Composites: []*LookupLog{
+					{Query: &Question{Name: ".", Type: dns.TypeDNSKEY}, CacheHit: true, DNSSECValid: true},
+				},
+			},
+			{
+				Query: q,
+				NS:    &Nameserver{Name: "a.gtld-servers.net.", Addr: "192.5.6.30", Zone: "com."},
+				Error: "solvere: No NS authority records found",
+			},
+		},
+	}
+}
+
+func TestLookupLogJSON(t *testing.T) {
+	ll := testLookupLogTree()
+	j, err := ll.JSON()
+	if err != nil {
+		t.Fatalf("Failed to marshal LookupLog: %s", err)
+	}
+	var decoded LookupLog
+	err = json.Unmarshal(j, &decoded)
+	if err != nil {
+		t.Fatalf("Failed to unmarshal LookupLog: %s", err)
+	}
+	if len(decoded.Composites) != 2 || len(decoded.Composites[0].Composites) != 1 {
+		t.Fatalf("Decoded LookupLog has the wrong shape: %s", j)
+	}
+	if decoded.Composites[1].NS.Zone != "com." {
+		t.Fatalf("Decoded LookupLog has the wrong nameserver zone: %s", j)
+	}
+}
+
+func TestLookupLogDOT(t *testing.T) {
+	dot := testLookupLogTree().DOT()
+	if !strings.HasPrefix(dot, "digraph lookup {") || !strings.HasSuffix(dot, "}\n") {
+		t.Fatalf("DOT output isn't a digraph: %s", dot)
+	}
+	for _, expected := range []string{
+		"n0 -> n1;",
+		"n1 -> n2;",
+		"n0 -> n3;",
+		"@a.root-servers.net. (198.41.0.4) zone .",
+		"NOERROR cached secure",
+		"color=red",
+	} {
+		if !strings.Contains(dot, expected) {
+			t.Fatalf("DOT output missing %q: %s", expected, dot)
+		}
+	}
+}
+
+func TestLookupLogCacheKind(t *testing.T) {
+	rr := NewRecursiveResolver(false, false, nil, nil, NewBasicCache())
+	rr.Forward = &ForwardConfig{Servers: []string{"10.0.0.1"}, Attempts: 1}
+	rr.Transport = transportFunc(func(_ context.Context, m *dns.Msg, _ string) (*dns.Msg, error) {
+		return nil, errors.New("timeout")
+	})
+	q := Question{Name: "cached.example.", Type: dns.TypeA}
+	rr.cache.Add(&q, &Answer{Answer: []dns.RR{&dns.A{Hdr: dns.RR_Header{Name: q.Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60}, A: net.IP{1, 2, 3, 4}}}}, false)
+	_, ll, err := rr.Lookup(context.Background(), q)
+	if err != nil {
+		t.Fatalf("Lookup failed: %s", err)
+	}
+	if len(ll.Composites) != 1 || ll.Composites[0].Cache != CacheAnswer {
+		t.Fatalf("Expected a single step answered from the answer cache, got %#v", ll.Composites)
+	}
+
+	q.Name = "failing.example."
+	if _, ll, _ = rr.Lookup(context.Background(), q); ll.CacheHit || len(ll.Composites) != 1 || ll.Composites[0].CacheHit {
+		t.Fatal("Lookup that reached the network was marked as a cache hit")
+	}
+	if _, ll, _ = rr.Lookup(context.Background(), q); !ll.CacheHit || ll.Cache != CacheNegative {
+		t.Fatalf("Expected the failure to be answered from the negative cache, got %s", ll.Cache)
+	}
+
+	j, err := json.Marshal(ll)
+	if err != nil {
+		t.Fatalf("Failed to marshal LookupLog: %s", err)
+	}
+	if !strings.Contains(string(j), `"Cache":"negative"`) {
+		t.Fatalf("Cache kind missing from JSON %s", j)
+	}
+	var decoded LookupLog
+	if err = json.Unmarshal(j, &decoded); err != nil || decoded.Cache != CacheNegative {
+		t.Fatalf("Failed to round trip cache kind: %v", err)
+	}
+}
@@ -0,0 +1,106 @@
+package solvere
+
+import (
+	"sync/atomic"
+
+	"github.com/miekg/dns"
+)
+
+// MemoryCache is a QuestionAnswerCache which can report the approximate amount of
+// memory used by the answers it holds and evict answers to reduce it, so it can
+// be bounded by RecursiveResolver.MaxMemory
+type MemoryCache interface {
+	QuestionAnswerCache
+	MemoryUsage() int64
+	// Shrink evicts answers until at least n bytes have been freed, or no more
+	// can be, and returns the number of bytes freed
+	Shrink(n int64) int64
+}
+
+// MemoryHeadroom is the fraction of RecursiveResolver.MaxMemory freed beyond the
+// ceiling each time it is exceeded, so eviction doesn't run after every lookup
+var MemoryHeadroom = 0.1
+
+const (
+	// rrOverhead and answerOverhead approximate the memory used by a record
+	// beyond its wire format, and by a Answer beyond its records
+	rrOverhead     = 64
+	answerOverhead = 128
+	// negativeEntrySize approximates the memory used by a remembered
+	// resolution or validation failure
+	negativeEntrySize = 256
+	// inFlightSize approximates the memory used by the state of a lookup in
+	// progress, including the messages it is waiting on
+	inFlightSize = 4096
+)
+
+// answerSize returns the approximate number of bytes used by a
+func answerSize(a *Answer) int64 {
+	size := int64(answerOverhead)
+	for _, section := range [][]dns.RR{a.Answer, a.Authority, a.Additional} {
+		for _, r := range section {
+			size += int64(dns.Len(r) + rrOverhead)
+		}
+	}
+	return size
+}
+
+// MemoryUsage is the approximate number of bytes used by the state of a
+// RecursiveResolver
+type MemoryUsage struct {
+	// Cache is the memory used by the answer cache, it is zero if the cache
+	// doesn't implement MemoryCache
+	Cache int64
+	// Failures is the memory used by the remembered resolution and
+	// validation failures
+	Failures int64
+	// InFlight is the memory used by lookups in progress, including
+	// background validations
+	InFlight int64
+}
+
+// Total returns the sum of the memory used
+func (m MemoryUsage) Total() int64 {
+	return m.Cache + m.Failures + m.InFlight
+}
+
+func (rr *RecursiveResolver) memoryUsage() MemoryUsage {
+	var m MemoryUsage
+	if mc, ok := rr.cache.(MemoryCache); ok {
+		m.Cache = mc.MemoryUsage()
+	}
+	m.Failures = rr.failures.memoryUsage()
+	m.InFlight = (atomic.LoadInt64(&rr.counters().active) + atomic.LoadInt64(&rr.counters().lazy)) * inFlightSize
+	return m
+}
+
+// enforceMemoryLimit evicts answers from the cache and remembered failures, in
+// proportion to the memory each uses, if the resolver uses more than MaxMemory.
+// Lookups in progress can't be evicted but count towards the limit.
+func (rr *RecursiveResolver) enforceMemoryLimit() {
+	if rr.MaxMemory <= 0 {
+		return
+	}
+	usage := rr.memoryUsage()
+	if usage.Total() <= rr.MaxMemory {
+		return
+	}
+	// concurrent lookups would only evict the same memory again
+	if !atomic.CompareAndSwapInt32(&rr.counters().evicting, 0, 1) {
+		return
+	}
+	defer atomic.StoreInt32(&rr.counters().evicting, 0)
+	excess := float64(usage.Total()-rr.MaxMemory) + float64(rr.MaxMemory)*MemoryHeadroom
+	evictable := float64(usage.Cache + usage.Failures)
+	if evictable == 0 {
+		return
+	}
+	var freed int64
+	if mc, ok := rr.cache.(MemoryCache); ok && usage.Cache > 0 {
+		freed += mc.Shrink(int64(excess * float64(usage.Cache) / evictable))
+	}
+	if usage.Failures > 0 {
+		freed += rr.failures.shrink(int64(excess * float64(usage.Failures) / evictable))
+	}
+	rr.log(LogInfo, "evicted cached data to stay under the memory limit", "limit", rr.MaxMemory, "usage", usage.Total(), "freed", freed)
+}
@@ -0,0 +1,73 @@
+package solvere
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"testing"
+
+	"github.com/jmhodges/clock"
+	"github.com/miekg/dns"
+)
+
+func TestCacheShrink(t *testing.T) {
+	cache := NewBoundedCache(10)
+	cache.clk = clock.NewFake()
+	defer cache.Close()
+	answer := &Answer{Answer: []dns.RR{&dns.A{Hdr: dns.RR_Header{Name: "a.", Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60}, A: net.IP{1, 2, 3, 4}}}}
+	size := answerSize(answer)
+	for i := 0; i < 4; i++ {
+		cache.Add(&Question{Name: fmt.Sprintf("%d.", i), Type: dns.TypeA}, answer, false)
+	}
+	cache.Add(&Question{Name: "forever.", Type: dns.TypeA}, answer, true)
+	if used := cache.MemoryUsage(); used != 5*size {
+		t.Fatalf("Expected %d bytes used, got %d", 5*size, used)
+	}
+	cache.Get(&Question{Name: "0.", Type: dns.TypeA})
+
+	if freed := cache.Shrink(size + 1); freed != 2*size {
+		t.Fatalf("Expected %d bytes freed, got %d", 2*size, freed)
+	}
+	if cache.Get(&Question{Name: "1.", Type: dns.TypeA}) != nil || cache.Get(&Question{Name: "2.", Type: dns.TypeA}) != nil {
+		t.Fatal("Least recently used answers weren't evicted")
+	}
+	if cache.Get(&Question{Name: "0.", Type: dns.TypeA}) == nil {
+		t.Fatal("Recently used answer was evicted")
+	}
+	if freed := cache.Shrink(100 * size); freed != 2*size {
+		t.Fatalf("Expected %d bytes freed, got %d", 2*size, freed)
+	}
+	if cache.Get(&Question{Name: "forever.", Type: dns.TypeA}) == nil {
+		t.Fatal("Answer added forever was evicted")
+	}
+	if used := cache.MemoryUsage(); used != size {
+		t.Fatalf("Expected %d bytes used, got %d", size, used)
+	}
+}
+
+func TestMaxMemory(t *testing.T) {
+	cache := NewBasicCache()
+	defer cache.Close()
+	rr := NewRecursiveResolver(false, false, nil, nil, cache)
+	rr.Forward = &ForwardConfig{Servers: []string{"10.0.0.1"}}
+	rr.Transport = transportFunc(func(_ context.Context, m *dns.Msg, _ string) (*dns.Msg, error) {
+		r := new(dns.Msg)
+		r.SetReply(m)
+		r.Answer = []dns.RR{&dns.A{Hdr: dns.RR_Header{Name: m.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60}, A: net.IP{1, 2, 3, 4}}}
+		return r, nil
+	})
+	rr.MaxMemory = 16 * 1024
+
+	for i := 0; i < 500; i++ {
+		if _, _, err := rr.Lookup(context.Background(), Question{Name: fmt.Sprintf("%d.example.", i), Type: dns.TypeA}); err != nil {
+			t.Fatalf("Lookup failed: %s", err)
+		}
+	}
+	st := rr.Stats()
+	if st.Memory.Cache == 0 {
+		t.Fatal("Cache memory usage wasn't reported")
+	}
+	if total := st.Memory.Total(); total > rr.MaxMemory {
+		t.Fatalf("Memory usage %d exceeds the limit of %d", total, rr.MaxMemory)
+	}
+}
@@ -0,0 +1,28 @@
+package solvere
+
+import "github.com/miekg/dns"
+
+// minimalResponse returns a copy of a without the additional section, and without
+// the authority section unless a is a negative answer. The SOA record, and any
+// DNSSEC records proving the non-existence of the name or type, are kept in
+// negative answers since they are needed to cache and validate them (RFC 2308,
+// RFC 4035 Section 3.1.3).
+func minimalResponse(a *Answer) *Answer {
+	m := *a
+	m.Additional = nil
+	m.Authority = nil
+	if a.Rcode != dns.RcodeNameError && len(a.Answer) > 0 {
+		return &m
+	}
+	for _, r := range a.Authority {
+		switch r.Header().Rrtype {
+		case dns.TypeSOA, dns.TypeNSEC, dns.TypeNSEC3:
+			m.Authority = append(m.Authority, r)
+		case dns.TypeRRSIG:
+			if covered := r.(*dns.RRSIG).TypeCovered; covered == dns.TypeSOA || covered == dns.TypeNSEC || covered == dns.TypeNSEC3 {
+				m.Authority = append(m.Authority, r)
+			}
+		}
+	}
+	return &m
+}
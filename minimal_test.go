@@ -0,0 +1,58 @@
+package solvere
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestMinimalResponse(t *testing.T) {
+	soa := &dns.SOA{Hdr: dns.RR_Header{Name: "example.", Rrtype: dns.TypeSOA, Class: dns.ClassINET, Ttl: 60}, Ns: "ns.example.", Mbox: "host.example.", Minttl: 60}
+	soaSig := &dns.RRSIG{Hdr: dns.RR_Header{Name: "example.", Rrtype: dns.TypeRRSIG, Class: dns.ClassINET}, TypeCovered: dns.TypeSOA}
+	nsec := &dns.NSEC{Hdr: dns.RR_Header{Name: "a.example.", Rrtype: dns.TypeNSEC, Class: dns.ClassINET}, NextDomain: "c.example."}
+	ns := &dns.NS{Hdr: dns.RR_Header{Name: "example.", Rrtype: dns.TypeNS, Class: dns.ClassINET}, Ns: "ns.example."}
+	nsSig := &dns.RRSIG{Hdr: dns.RR_Header{Name: "example.", Rrtype: dns.TypeRRSIG, Class: dns.ClassINET}, TypeCovered: dns.TypeNS}
+	glue := &dns.A{Hdr: dns.RR_Header{Name: "ns.example.", Rrtype: dns.TypeA, Class: dns.ClassINET}, A: net.IP{10, 0, 0, 1}}
+	answer := &dns.A{Hdr: dns.RR_Header{Name: "b.example.", Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60}, A: net.IP{10, 0, 0, 2}}
+
+	full := &Answer{Answer: []dns.RR{answer}, Authority: []dns.RR{ns, nsSig}, Additional: []dns.RR{glue}, Rcode: dns.RcodeSuccess}
+	m := minimalResponse(full)
+	if len(m.Answer) != 1 || m.Authority != nil || m.Additional != nil {
+		t.Fatalf("Expected only the answer section, got %#v", m)
+	}
+	if len(full.Authority) != 2 || len(full.Additional) != 1 {
+		t.Fatal("minimalResponse modified the original answer")
+	}
+
+	negative := &Answer{Authority: []dns.RR{soa, soaSig, nsec, ns, nsSig}, Additional: []dns.RR{glue}, Rcode: dns.RcodeNameError}
+	m = minimalResponse(negative)
+	if len(m.Authority) != 3 || m.Authority[0] != soa || m.Authority[1] != soaSig || m.Authority[2] != nsec {
+		t.Fatalf("Expected the SOA and denial records to be kept, got %v", m.Authority)
+	}
+	if m.Additional != nil {
+		t.Fatalf("Expected no additional records, got %v", m.Additional)
+	}
+}
+
+func TestLookupMinimalResponses(t *testing.T) {
+	rr := NewRecursiveResolver(false, false, nil, nil, nil)
+	rr.Forward = &ForwardConfig{Servers: []string{"10.0.0.1"}}
+	rr.MinimalResponses = true
+	rr.Transport = transportFunc(func(_ context.Context, m *dns.Msg, _ string) (*dns.Msg, error) {
+		r := new(dns.Msg)
+		r.SetReply(m)
+		r.Answer = []dns.RR{&dns.A{Hdr: dns.RR_Header{Name: m.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60}, A: net.IP{1, 2, 3, 4}}}
+		r.Ns = []dns.RR{&dns.NS{Hdr: dns.RR_Header{Name: "example.", Rrtype: dns.TypeNS, Class: dns.ClassINET, Ttl: 60}, Ns: "ns.example."}}
+		r.Extra = []dns.RR{&dns.A{Hdr: dns.RR_Header{Name: "ns.example.", Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60}, A: net.IP{10, 0, 0, 1}}}
+		return r, nil
+	})
+	a, _, err := rr.Lookup(context.Background(), Question{Name: "a.example.", Type: dns.TypeA})
+	if err != nil {
+		t.Fatalf("Lookup failed: %s", err)
+	}
+	if len(a.Answer) != 1 || len(a.Authority) != 0 || len(a.Additional) != 0 {
+		t.Fatalf("Expected a minimal answer, got %#v", a)
+	}
+}
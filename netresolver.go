@@ -0,0 +1,59 @@
+package solvere
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+
+	"github.com/miekg/dns"
+)
+
+// NewNetResolver returns a *net.Resolver which sends all of its queries to rr, in
+// process, rather than to the nameservers configured for the system. It can be used
+// anywhere a *net.Resolver is accepted, such as net.Dialer.Resolver, so that code
+// using the standard library gets validated resolution without any changes.
+//
+// Since the returned resolver uses the pure Go resolver it still consults the hosts
+// file and applies the search list from /etc/resolv.conf before sending queries.
+// Names which fail to validate are reported as a temporary failure (SERVFAIL).
+func NewNetResolver(rr *RecursiveResolver) *net.Resolver {
+	h := NewHandler(rr)
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			client, server := net.Pipe()
+			go serveStream(ctx, h, server)
+			return client, nil
+		},
+	}
+}
+
+// serveStream answers length prefixed queries (RFC 1035 Section 4.2.2) read from
+// conn until it is closed. The Go resolver uses this framing for connections which
+// don't implement net.PacketConn, so responses are never truncated.
+func serveStream(ctx context.Context, h *Handler, conn net.Conn) {
+	defer conn.Close()
+	var length [2]byte
+	for {
+		if _, err := io.ReadFull(conn, length[:]); err != nil {
+			return
+		}
+		wire := make([]byte, binary.BigEndian.Uint16(length[:]))
+		if _, err := io.ReadFull(conn, wire); err != nil {
+			return
+		}
+		r := new(dns.Msg)
+		if err := r.Unpack(wire); err != nil {
+			return
+		}
+		resp, err := h.respond(ctx, r).Pack()
+		if err != nil {
+			return
+		}
+		binary.BigEndian.PutUint16(length[:], uint16(len(resp)))
+		if _, err = conn.Write(append(length[:], resp...)); err != nil {
+			return
+		}
+	}
+}
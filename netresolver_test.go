@@ -0,0 +1,45 @@
+package solvere
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestNetResolver(t *testing.T) {
+	rr := NewRecursiveResolver(false, false, nil, nil, nil)
+	rr.Forward = &ForwardConfig{Servers: []string{"10.0.0.1"}}
+	rr.Transport = transportFunc(func(_ context.Context, m *dns.Msg, _ string) (*dns.Msg, error) {
+		r := new(dns.Msg)
+		r.SetReply(m)
+		q := m.Question[0]
+		switch {
+		case q.Name == "bogus.example.":
+			r.Rcode = dns.RcodeServerFailure
+		case q.Name != "solvere.example.":
+			r.Rcode = dns.RcodeNameError
+		case q.Qtype == dns.TypeA:
+			r.Answer = []dns.RR{&dns.A{Hdr: dns.RR_Header{Name: q.Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60}, A: net.IP{192, 0, 2, 1}}}
+		}
+		return r, nil
+	})
+	resolver := NewNetResolver(rr)
+	addrs, err := resolver.LookupHost(context.Background(), "solvere.example.")
+	if err != nil {
+		t.Fatalf("LookupHost failed: %s", err)
+	}
+	if len(addrs) != 1 || addrs[0] != "192.0.2.1" {
+		t.Fatalf("Unexpected addresses: %v", addrs)
+	}
+
+	_, err = resolver.LookupHost(context.Background(), "missing.example.")
+	if dnsErr, ok := err.(*net.DNSError); !ok || !dnsErr.IsNotFound {
+		t.Fatalf("Expected not found error, got %v", err)
+	}
+	_, err = resolver.LookupHost(context.Background(), "bogus.example.")
+	if dnsErr, ok := err.(*net.DNSError); !ok || dnsErr.IsNotFound {
+		t.Fatalf("Expected server failure, got %v", err)
+	}
+}
@@ -0,0 +1,83 @@
+package solvere
+
+import (
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// normalizeKey identifies the RRset a record belongs to, the signatures covering
+// each RRset are treated as a set of their own
+type normalizeKey struct {
+	name    string
+	rrtype  uint16
+	class   uint16
+	covered uint16
+}
+
+func normalizeKeyOf(r dns.RR) normalizeKey {
+	hdr := r.Header()
+	key := normalizeKey{name: strings.ToLower(hdr.Name), rrtype: hdr.Rrtype, class: hdr.Class}
+	if sig, ok := r.(*dns.RRSIG); ok {
+		key.covered = sig.TypeCovered
+	}
+	return key
+}
+
+// normalizeRecords removes duplicate records, which some servers include in
+// responses, lowercases owner names and gives every record of a RRset the lowest
+// TTL in the set (RFC 2181 Section 5.2). Records which need changing are copied
+// since they may be shared with the cache, if nothing changes records is
+// returned as it is. OPT records are left alone.
+func normalizeRecords(records []dns.RR) []dns.RR {
+	if len(records) == 0 {
+		return records
+	}
+	ttls := make(map[normalizeKey]uint32, len(records))
+	for _, r := range records {
+		if r.Header().Rrtype == dns.TypeOPT {
+			continue
+		}
+		key := normalizeKeyOf(r)
+		if ttl, present := ttls[key]; !present || r.Header().Ttl < ttl {
+			ttls[key] = r.Header().Ttl
+		}
+	}
+	seen := make(map[string]struct{}, len(records))
+	var normalized []dns.RR
+	for i, r := range records {
+		hdr := r.Header()
+		if hdr.Rrtype == dns.TypeOPT {
+			if normalized != nil {
+				normalized = append(normalized, r)
+			}
+			continue
+		}
+		key := normalizeKeyOf(r)
+		// records are duplicates if they only differ in the case of their owner
+		// names and their TTLs
+		id := key.name + "\x00" + strings.TrimPrefix(r.String(), hdr.String())
+		_, duplicate := seen[id]
+		seen[id] = struct{}{}
+		ttl := ttls[key]
+		if !duplicate && hdr.Name == key.name && hdr.Ttl == ttl {
+			if normalized != nil {
+				normalized = append(normalized, r)
+			}
+			continue
+		}
+		if normalized == nil {
+			normalized = append(make([]dns.RR, 0, len(records)), records[:i]...)
+		}
+		if duplicate {
+			continue
+		}
+		r = dns.Copy(r)
+		r.Header().Name, r.Header().Ttl = key.name, ttl
+		normalized = append(normalized, r)
+	}
+	if normalized == nil {
+		return records
+	}
+	return normalized
+}
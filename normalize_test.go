@@ -0,0 +1,74 @@
+package solvere
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestNormalizeRecords(t *testing.T) {
+	a := func(name string, ttl uint32, ip net.IP) dns.RR {
+		return &dns.A{Hdr: dns.RR_Header{Name: name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: ttl}, A: ip}
+	}
+	sig := func(ttl uint32, covered uint16) dns.RR {
+		return &dns.RRSIG{Hdr: dns.RR_Header{Name: "example.", Rrtype: dns.TypeRRSIG, Class: dns.ClassINET, Ttl: ttl}, TypeCovered: covered}
+	}
+	opt := &dns.OPT{Hdr: dns.RR_Header{Name: ".", Rrtype: dns.TypeOPT, Ttl: 1 << 15}}
+
+	clean := []dns.RR{a("example.", 60, net.IP{1, 2, 3, 4}), a("example.", 60, net.IP{1, 2, 3, 5}), opt}
+	if normalized := normalizeRecords(clean); &normalized[0] != &clean[0] {
+		t.Fatal("Records which didn't need normalizing were copied")
+	}
+
+	records := []dns.RR{
+		a("Example.", 300, net.IP{1, 2, 3, 4}),
+		a("example.", 60, net.IP{1, 2, 3, 5}),
+		a("EXAMPLE.", 120, net.IP{1, 2, 3, 4}),
+		sig(300, dns.TypeA),
+		sig(30, dns.TypeAAAA),
+		opt,
+	}
+	normalized := normalizeRecords(records)
+	if len(normalized) != 5 {
+		t.Fatalf("Duplicate wasn't removed: %v", normalized)
+	}
+	for _, r := range normalized[:2] {
+		if r.Header().Name != "example." || r.Header().Ttl != 60 {
+			t.Fatalf("Address wasn't normalized: %s", r)
+		}
+	}
+	if !normalized[0].(*dns.A).A.Equal(net.IP{1, 2, 3, 4}) || !normalized[1].(*dns.A).A.Equal(net.IP{1, 2, 3, 5}) {
+		t.Fatalf("Records were reordered: %v", normalized)
+	}
+	if normalized[2].Header().Ttl != 300 || normalized[3].Header().Ttl != 30 {
+		t.Fatalf("Signatures of different RRsets were given the same TTL: %v", normalized)
+	}
+	if normalized[4] != opt {
+		t.Fatal("OPT record was modified")
+	}
+	if records[0].Header().Name != "Example." || records[0].Header().Ttl != 300 {
+		t.Fatal("Original records were modified")
+	}
+}
+
+func TestLookupNormalizesAnswer(t *testing.T) {
+	rr := NewResolver(WithValidation(false))
+	rr.Forward = &ForwardConfig{Servers: []string{"192.0.2.1:53"}, Attempts: 1}
+	rr.Transport = transportFunc(func(_ context.Context, m *dns.Msg, _ string) (*dns.Msg, error) {
+		r := new(dns.Msg)
+		r.SetReply(m)
+		for _, ttl := range []uint32{60, 30, 60} {
+			r.Answer = append(r.Answer, &dns.A{Hdr: dns.RR_Header{Name: "WWW.example.", Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: ttl}, A: net.IP{1, 2, 3, 4}})
+		}
+		return r, nil
+	})
+	a, _, err := rr.Lookup(context.Background(), Question{Name: "www.example.", Type: dns.TypeA})
+	if err != nil {
+		t.Fatalf("Lookup failed: %s", err)
+	}
+	if len(a.Answer) != 1 || a.Answer[0].Header().Name != "www.example." || a.Answer[0].Header().Ttl != 30 {
+		t.Fatalf("Answer wasn't normalized: %v", a.Answer)
+	}
+}
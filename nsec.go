@@ -3,7 +3,7 @@ package solvere
 import (
 	"errors"
 	"fmt"
-	// "strings"
+	"strings"
 
 	"github.com/miekg/dns"
 )
@@ -16,55 +16,164 @@ var (
 	ErrNSECBadDelegation    = errors.New("solvere: DS or SOA bit set in NSEC3 type map")
 	ErrNSECNSMissing        = errors.New("solvere: NS bit not set in NSEC3 type map")
 	ErrNSECOptOut           = errors.New("solvere: Opt-Out bit not set for NSEC3 record covering next closer")
+	ErrNSECWildcardProof    = errors.New("solvere: Wildcard expansion not proven by NSEC/NSEC3 records")
 )
 
 func typesSet(set []uint16, types ...uint16) bool {
-	tm := make(map[uint16]struct{}, len(types))
-	for _, t := range types {
-		tm[t] = struct{}{}
-	}
 	for _, t := range set {
-		if _, present := tm[t]; present {
+		if typeIn(t, types) {
 			return true
 		}
 	}
 	return false
 }
 
+var (
+	// MaxNSEC3Records is the maximum number of NSEC3 records that will be examined
+	// when verifying a denial of existence proof from a single response
+	MaxNSEC3Records = 8
+	// MaxNSEC3Hashes is the maximum number of hash operations, counting each
+	// iteration, that will be performed when verifying a denial of existence
+	// proof from a single response
+	MaxNSEC3Hashes = 5000
+)
+
+// ProofTooExpensiveError is returned when verifying a denial of existence proof
+// would exceed MaxNSEC3Records or MaxNSEC3Hashes, this prevents crafted responses
+// from consuming excessive CPU
+type ProofTooExpensiveError struct {
+	// Records is the number of NSEC3 records in the proof
+	Records int
+	// Hashes is the number of hash operations required when the limit was
+	// exceeded, or zero if the proof contained too many records
+	Hashes int
+}
+
+func (e *ProofTooExpensiveError) Error() string {
+	if e.Hashes == 0 {
+		return fmt.Sprintf("solvere: NSEC3 proof too expensive, contains %d records (max %d)", e.Records, MaxNSEC3Records)
+	}
+	return fmt.Sprintf("solvere: NSEC3 proof too expensive, requires more than %d hash operations", MaxNSEC3Hashes)
+}
+
+// nsec3Proof is a set of NSEC3 records from a single response which tracks the
+// work performed while verifying it. Since all of the NSEC3 records in a zone
+// normally share the same parameters hashed names are cached.
+type nsec3Proof struct {
+	records []*dns.NSEC3
+	hashes  map[nsec3HashKey]string
+	work    int
+}
+
+type nsec3HashKey struct {
+	name       string
+	hash       uint8
+	iterations uint16
+	salt       string
+}
+
+func newNSEC3Proof(nsec []dns.RR) (*nsec3Proof, error) {
+	if len(nsec) > MaxNSEC3Records {
+		return nil, &ProofTooExpensiveError{Records: len(nsec)}
+	}
+	p := &nsec3Proof{records: make([]*dns.NSEC3, 0, len(nsec))}
+	for _, rr := range nsec {
+		if n, ok := rr.(*dns.NSEC3); ok {
+			p.records = append(p.records, n)
+		}
+	}
+	return p, nil
+}
+
+// hash returns the hash of name using the parameters of n
+func (p *nsec3Proof) hash(name string, n *dns.NSEC3) (string, error) {
+	k := nsec3HashKey{strings.ToLower(name), n.Hash, n.Iterations, n.Salt}
+	if h, present := p.hashes[k]; present {
+		return h, nil
+	}
+	p.work += int(n.Iterations) + 1
+	if p.work > MaxNSEC3Hashes {
+		return "", &ProofTooExpensiveError{Records: len(p.records), Hashes: p.work}
+	}
+	if p.hashes == nil {
+		p.hashes = make(map[nsec3HashKey]string)
+	}
+	h := dns.HashName(name, n.Hash, n.Iterations, n.Salt)
+	p.hashes[k] = h
+	return h, nil
+}
+
+// ownerHash returns the hash from the owner name of n
+func ownerHash(n *dns.NSEC3) (string, bool) {
+	labels := dns.Split(n.Hdr.Name)
+	if len(labels) < 2 {
+		return "", false
+	}
+	return strings.ToUpper(n.Hdr.Name[labels[0] : labels[1]-1]), true
+}
+
+// match checks if the owner name of n matches name, as dns.NSEC3.Match
+func (p *nsec3Proof) match(name string, n *dns.NSEC3) (bool, error) {
+	owner, ok := ownerHash(n)
+	if !ok {
+		return false, nil
+	}
+	h, err := p.hash(name, n)
+	return err == nil && h == owner, err
+}
+
+// cover checks if name falls between the owner name and next domain of n, as
+// dns.NSEC3.Cover
+func (p *nsec3Proof) cover(name string, n *dns.NSEC3) (bool, error) {
+	owner, ok := ownerHash(n)
+	if !ok || owner == n.NextDomain {
+		return false, nil
+	}
+	h, err := p.hash(name, n)
+	if err != nil {
+		return false, err
+	}
+	return h > owner && h < n.NextDomain, nil
+}
+
 // findClosestEncloser finds the Closest Encloser and Next Closers for a name
 // in a set of NSEC3 records
-func findClosestEncloser(name string, nsec []dns.RR) (string, string) {
+func findClosestEncloser(name string, p *nsec3Proof) (string, string, error) {
 	// RFC 5155 Section 8.3 (ish)
 	labelIndices := dns.Split(name)
 	nc := name
 	for i := 0; i < len(labelIndices); i++ {
 		z := name[labelIndices[i]:]
-		_, err := findMatching(z, nsec)
-		if err != nil {
+		_, err := findMatching(z, p)
+		if err == ErrNSECMissingCoverage {
 			continue
+		} else if err != nil {
+			return "", "", err
 		}
 		if i != 0 {
 			nc = name[labelIndices[i-1]:]
 		}
-		return z, nc
+		return z, nc, nil
 	}
-	return "", ""
+	return "", "", nil
 }
 
-func findMatching(name string, nsec []dns.RR) ([]uint16, error) {
-	for _, rr := range nsec {
-		n := rr.(*dns.NSEC3)
-		if n.Match(name) {
+func findMatching(name string, p *nsec3Proof) ([]uint16, error) {
+	for _, n := range p.records {
+		if matched, err := p.match(name, n); err != nil {
+			return nil, err
+		} else if matched {
 			return n.TypeBitMap, nil
 		}
 	}
 	return nil, ErrNSECMissingCoverage
 }
 
-func findCoverer(name string, nsec []dns.RR) ([]uint16, bool, error) {
-	for _, rr := range nsec {
-		n := rr.(*dns.NSEC3)
-		if n.Cover(name) {
+func findCoverer(name string, p *nsec3Proof) ([]uint16, bool, error) {
+	for _, n := range p.records {
+		if covered, err := p.cover(name, n); err != nil {
+			return nil, false, err
+		} else if covered {
 			return n.TypeBitMap, (n.Flags & 1) == 1, nil
 		}
 	}
@@ -73,11 +182,18 @@ func findCoverer(name string, nsec []dns.RR) ([]uint16, bool, error) {
 
 // RFC 5155 Section 8.4
 func verifyNameError(q *Question, nsec []dns.RR) error {
-	ce, _ := findClosestEncloser(q.Name, nsec)
+	p, err := newNSEC3Proof(nsec)
+	if err != nil {
+		return err
+	}
+	ce, _, err := findClosestEncloser(q.Name, p)
+	if err != nil {
+		return err
+	}
 	if ce == "" {
 		return ErrNSECMissingCoverage
 	}
-	_, _, err := findCoverer(fmt.Sprintf("*.%s", ce), nsec)
+	_, _, err = findCoverer(fmt.Sprintf("*.%s", ce), p)
 	if err != nil {
 		return err
 	}
@@ -87,19 +203,26 @@ func verifyNameError(q *Question, nsec []dns.RR) error {
 // verifyNODATA verifies NSEC/NSEC3 records from a answer with a NOERROR (0) RCODE
 // and a empty Answer section
 func verifyNODATA(q *Question, nsec []dns.RR) error {
+	p, err := newNSEC3Proof(nsec)
+	if err != nil {
+		return err
+	}
 	// RFC5155 Section 8.5
-	types, err := findMatching(q.Name, nsec)
+	types, err := findMatching(q.Name, p)
 	if err != nil {
-		if q.Type != dns.TypeDS {
+		if q.Type != dns.TypeDS || err != ErrNSECMissingCoverage {
 			return err
 		}
 
 		// RFC5155 Section 8.6
-		ce, nc := findClosestEncloser(q.Name, nsec)
+		ce, nc, err := findClosestEncloser(q.Name, p)
+		if err != nil {
+			return err
+		}
 		if ce == "" {
 			return ErrNSECMissingCoverage
 		}
-		_, optOut, err := findCoverer(nc, nsec)
+		_, optOut, err := findCoverer(nc, p)
 		if err != nil {
 			return err
 		}
@@ -130,19 +253,85 @@ func verifyNODATA(q *Question, nsec []dns.RR) error {
 	return nil
 }
 
-// RFC 5155 Section 8.8
-// func verifyWildcardAnswer() {
-// }
+// verifyWildcardAnswer checks that any RRsets in answer which were synthesized from
+// a wildcard, as shown by the Labels field of their RRSIGs, are accompanied by a
+// NSEC3 (RFC 5155 Section 8.8) or NSEC (RFC 4035 Section 5.3.4) record in authority
+// proving the next closer name doesn't exist. Otherwise a signed wildcard could be
+// replayed for names which do exist.
+func verifyWildcardAnswer(answer, authority []dns.RR) error {
+	var p *nsec3Proof
+	for _, r := range answer {
+		sig, ok := r.(*dns.RRSIG)
+		if !ok {
+			continue
+		}
+		name := strings.ToLower(sig.Hdr.Name)
+		wildcard, expanded := WildcardOwner(name, sig)
+		if !expanded || wildcard == name {
+			continue
+		}
+		// the next closer name is the closest encloser, the parent of the
+		// wildcard, with one more label from name
+		labels := dns.Split(name)
+		nc := name[labels[len(labels)-int(sig.Labels)-1]:]
+
+		nsec3 := extractRRSet(authority, "", dns.TypeNSEC3)
+		if len(nsec3) == 0 {
+			if !nsecCovers(name, authority) {
+				return ErrNSECWildcardProof
+			}
+			continue
+		}
+		if p == nil {
+			var err error
+			if p, err = newNSEC3Proof(nsec3); err != nil {
+				return err
+			}
+		}
+		if _, _, err := findCoverer(nc, p); err == ErrNSECMissingCoverage {
+			return ErrNSECWildcardProof
+		} else if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// nsecCovers checks if a NSEC record in records proves name doesn't exist, i.e.
+// name falls between its owner and next names in the canonical order
+func nsecCovers(name string, records []dns.RR) bool {
+	for _, r := range records {
+		n, ok := r.(*dns.NSEC)
+		if !ok {
+			continue
+		}
+		after := CanonicalNameLess(n.Hdr.Name, name)
+		before := CanonicalNameLess(name, n.NextDomain)
+		// the last NSEC in a zone points back to the apex
+		last := !CanonicalNameLess(n.Hdr.Name, n.NextDomain)
+		if (after && before) || (last && after && isSubdomain(name, n.NextDomain)) {
+			return true
+		}
+	}
+	return false
+}
 
 // RFC 5155 Section 8.9
 func verifyDelegation(delegation string, nsec []dns.RR) error {
-	types, err := findMatching(delegation, nsec)
+	p, err := newNSEC3Proof(nsec)
 	if err != nil {
-		ce, nc := findClosestEncloser(delegation, nsec)
+		return err
+	}
+	types, err := findMatching(delegation, p)
+	if err == ErrNSECMissingCoverage {
+		ce, nc, err := findClosestEncloser(delegation, p)
+		if err != nil {
+			return err
+		}
 		if ce == "" {
 			return ErrNSECMissingCoverage
 		}
-		_, optOut, err := findCoverer(nc, nsec)
+		_, optOut, err := findCoverer(nc, p)
 		if err != nil {
 			return err
 		}
@@ -150,6 +339,8 @@ func verifyDelegation(delegation string, nsec []dns.RR) error {
 			return ErrNSECOptOut
 		}
 		return nil
+	} else if err != nil {
+		return err
 	}
 	if !typesSet(types, dns.TypeNS) {
 		return ErrNSECNSMissing
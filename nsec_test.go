@@ -168,8 +168,44 @@ func TestVerifyNODATA(t *testing.T) {
 	}
 }
 
-// func TestVerifyWildcardAnswer(t *testing.T) {
-// }
+func TestVerifyWildcardAnswer(t *testing.T) {
+	// RFC 5155 Appendix B.4
+	answer := zoneToRecords(t, `a.z.w.example. 3600 IN MX 1 ai.example.
+a.z.w.example. 3600 IN RRSIG MX 7 2 3600 20150420235959 20051021000000 40430 example. CikhH3LZPuUBB3ejersLB8NlTEdkSWoG20jUoA4moCTUED8zyPRemR5n 8MwnYIoHsLAWd/pz4fpDFmqchQAbAg==`)
+	authority := zoneToRecords(t, `q04jkcevqvmu85r014c7dkba38o0ji5r.example. 3600 IN NSEC3 1 1 12 aabbccdd r53bq7cc2uvmubfu5ocmm6pers9tk9en A RRSIG`)
+	if err := verifyWildcardAnswer(answer, authority); err != nil {
+		t.Fatalf("verifyWildcardAnswer failed with RFC5155 Appendix B.4 example: %s", err)
+	}
+	if err := verifyWildcardAnswer(answer, nil); err != ErrNSECWildcardProof {
+		t.Fatalf("verifyWildcardAnswer didn't fail without a proof: %v", err)
+	}
+	// the NSEC3 doesn't cover the next closer for a different name
+	answer[0].Header().Name, answer[1].Header().Name = "a.x.w.example.", "a.x.w.example."
+	if err := verifyWildcardAnswer(answer, authority); err != ErrNSECWildcardProof {
+		t.Fatalf("verifyWildcardAnswer didn't fail with a proof for another name: %v", err)
+	}
+
+	// RFC 4035 Appendix B.6
+	answer = zoneToRecords(t, `a.z.w.example. 3600 IN MX 1 ai.example.
+a.z.w.example. 3600 IN RRSIG MX 5 2 3600 20040509183619 20040409183619 38519 example. OMK8rAZlepfzLWW75Dxd63jy2wswESzxDKG2f9AMN1CytCd10cYISAxf AdvXSZ7xujKAtPbctvOQ2ofO7AZJ+d01EeeQTVBPq4/6KCWhqe2XTjnk VLNvvhnc0u28aoSsG0+4InvkkOHknKxw4kX18MMR34i8lC36SR5xBni8 vHI=`)
+	authority = zoneToRecords(t, `x.y.w.example. 3600 IN NSEC xx.example. MX NSEC RRSIG`)
+	if err := verifyWildcardAnswer(answer, authority); err != nil {
+		t.Fatalf("verifyWildcardAnswer failed with RFC4035 Appendix B.6 example: %s", err)
+	}
+	authority = zoneToRecords(t, `a.example. 3600 IN NSEC b.example. MX NSEC RRSIG`)
+	if err := verifyWildcardAnswer(answer, authority); err != ErrNSECWildcardProof {
+		t.Fatalf("verifyWildcardAnswer didn't fail with a NSEC that doesn't cover the name: %v", err)
+	}
+
+	// records owned by the wildcard itself, and records that weren't expanded,
+	// don't need a proof
+	answer = zoneToRecords(t, `*.w.example. 3600 IN MX 1 ai.example.
+*.w.example. 3600 IN RRSIG MX 5 2 3600 20040509183619 20040409183619 38519 example. OMK8rAZlepfzLWW75Dxd63jy2wswESzxDKG2f9AMN1CytCd10cYISAxf
+ai.example. 3600 IN RRSIG A 5 2 3600 20040509183619 20040409183619 38519 example. OMK8rAZlepfzLWW75Dxd63jy2wswESzxDKG2f9AMN1CytCd10cYISAxf`)
+	if err := verifyWildcardAnswer(answer, nil); err != nil {
+		t.Fatalf("verifyWildcardAnswer failed for unexpanded records: %s", err)
+	}
+}
 
 func TestVerifyDelegation(t *testing.T) {
 	// Valid direct delegation
@@ -252,3 +288,41 @@ func TestVerifyDelegation(t *testing.T) {
 		t.Fatalf("verifyDelegation failed wtih opt out delegation example from RFC5155: %s", err)
 	}
 }
+
+func TestNSEC3ProofWorkBound(t *testing.T) {
+	records := []dns.RR{}
+	for i := 0; i <= MaxNSEC3Records; i++ {
+		records = append(records, makeNSEC3("example.com.", "", false, nil))
+	}
+	err := verifyNameError(&Question{Name: "a.example.com.", Type: dns.TypeA}, records)
+	if pe, ok := err.(*ProofTooExpensiveError); !ok || pe.Records != len(records) {
+		t.Fatalf("verifyNameError didn't fail for proof with too many records: %v", err)
+	}
+
+	expensive := makeNSEC3("example.com.", "", false, nil)
+	expensive.Iterations = 2500
+	// a name with many labels requires a hash per label to find the closest encloser
+	name := strings.Repeat("a.", 10) + "example.com."
+	err = verifyNameError(&Question{Name: name, Type: dns.TypeA}, []dns.RR{expensive})
+	if pe, ok := err.(*ProofTooExpensiveError); !ok || pe.Hashes <= MaxNSEC3Hashes {
+		t.Fatalf("verifyNameError didn't fail for proof requiring too many hash operations: %v", err)
+	}
+	if err = verifyDelegation(name, []dns.RR{expensive}); err == nil || err == ErrNSECMissingCoverage {
+		t.Fatalf("verifyDelegation didn't fail for proof requiring too many hash operations: %v", err)
+	}
+
+	// hashes are reused across records sharing parameters
+	p, _ := newNSEC3Proof([]dns.RR{makeNSEC3("a.com.", "", false, nil), makeNSEC3("b.com.", "", false, nil)})
+	findMatching("c.com.", p)
+	if p.work != 3 {
+		t.Fatalf("Expected a single hash with 2 iterations to be computed, got %d operations", p.work)
+	}
+}
+
+func BenchmarkTypesSet(b *testing.B) {
+	set := []uint16{dns.TypeA, dns.TypeNS, dns.TypeSOA, dns.TypeMX, dns.TypeTXT, dns.TypeAAAA, dns.TypeRRSIG, dns.TypeDNSKEY, dns.TypeNSEC3PARAM}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		typesSet(set, dns.TypeDS, dns.TypeCNAME)
+	}
+}
@@ -0,0 +1,466 @@
+//go:build go1.26
+
+// The Oblivious DoH transport uses crypto/hpke, which was added in Go 1.26, so it
+// is left out of builds with older versions.
+
+package solvere
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hkdf"
+	"crypto/hpke"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sync"
+
+	"github.com/miekg/dns"
+)
+
+// ODoHContentType is the media type of Oblivious DoH messages (RFC 9230)
+const ODoHContentType = "application/oblivious-dns-message"
+
+// ODoHConfigsPath is the well-known path targets publish their ObliviousDoHConfigs at
+const ODoHConfigsPath = "/.well-known/odohconfigs"
+
+const (
+	odohVersion          = 0x0001
+	odohMessageQuery     = 0x01
+	odohMessageResponse  = 0x02
+	maxODoHMessageSize   = maxDoHMessageSize + 1024
+	maxODoHConfigsSize   = 2 + 65535
+	odohPaddingBlockSize = 128
+)
+
+var (
+	// ErrODoHStatus is returned by ODoHTransport when the proxy responds with a
+	// status other than 200
+	ErrODoHStatus = errors.New("solvere: ODoH request failed")
+	// ErrMalformedODoH is returned when an ODoH message or config can't be parsed
+	ErrMalformedODoH = errors.New("solvere: Malformed ODoH message")
+	// ErrNoODoHConfig is returned when a target doesn't publish a config using a
+	// supported HPKE ciphersuite
+	ErrNoODoHConfig = errors.New("solvere: No supported ODoH config")
+)
+
+// ODoHConfig is the HPKE public key and ciphersuite of an Oblivious DoH target
+// (RFC 9230 6). Only the HKDF KDFs and the AES-GCM AEADs are supported.
+type ODoHConfig struct {
+	KEM       uint16
+	KDF       uint16
+	AEAD      uint16
+	PublicKey []byte
+}
+
+// ParseODoHConfigs parses an ObliviousDoHConfigs structure, as published by
+// targets, returning the configs with versions and ciphersuites which are
+// supported. If there are none ErrNoODoHConfig is returned.
+func ParseODoHConfigs(b []byte) ([]ODoHConfig, error) {
+	body, rest, err := readVector(b)
+	if err != nil || len(rest) != 0 {
+		return nil, fmt.Errorf("%w: invalid configs length", ErrMalformedODoH)
+	}
+	var configs []ODoHConfig
+	for len(body) > 0 {
+		if len(body) < 4 {
+			return nil, fmt.Errorf("%w: truncated config", ErrMalformedODoH)
+		}
+		version := binary.BigEndian.Uint16(body)
+		var contents []byte
+		if contents, body, err = readVector(body[2:]); err != nil {
+			return nil, fmt.Errorf("%w: truncated config", ErrMalformedODoH)
+		}
+		// configs with unknown versions are skipped (RFC 9230 6.1)
+		if version != odohVersion {
+			continue
+		}
+		if len(contents) < 6 {
+			return nil, fmt.Errorf("%w: truncated config contents", ErrMalformedODoH)
+		}
+		c := ODoHConfig{
+			KEM:  binary.BigEndian.Uint16(contents),
+			KDF:  binary.BigEndian.Uint16(contents[2:]),
+			AEAD: binary.BigEndian.Uint16(contents[4:]),
+		}
+		if c.PublicKey, rest, err = readVector(contents[6:]); err != nil || len(rest) != 0 || len(c.PublicKey) == 0 {
+			return nil, fmt.Errorf("%w: invalid public key", ErrMalformedODoH)
+		}
+		if _, err = c.suite(); err == nil {
+			configs = append(configs, c)
+		}
+	}
+	if len(configs) == 0 {
+		return nil, ErrNoODoHConfig
+	}
+	return configs, nil
+}
+
+// contents returns the ObliviousDoHConfigContents encoding of the config
+func (c *ODoHConfig) contents() []byte {
+	b := make([]byte, 6, 8+len(c.PublicKey))
+	binary.BigEndian.PutUint16(b, c.KEM)
+	binary.BigEndian.PutUint16(b[2:], c.KDF)
+	binary.BigEndian.PutUint16(b[4:], c.AEAD)
+	return appendVector(b, c.PublicKey)
+}
+
+// odohSuite is the HPKE ciphersuite of a config, along with the parameters needed
+// to derive the response keys
+type odohSuite struct {
+	pub    hpke.PublicKey
+	kdf    hpke.KDF
+	aead   hpke.AEAD
+	hash   func() hash.Hash
+	keyLen int
+}
+
+func (c *ODoHConfig) suite() (*odohSuite, error) {
+	s := new(odohSuite)
+	switch c.KDF {
+	case 0x0001:
+		s.hash = sha256.New
+	case 0x0002:
+		s.hash = sha512.New384
+	case 0x0003:
+		s.hash = sha512.New
+	default:
+		return nil, fmt.Errorf("%w: unsupported KDF %d", ErrNoODoHConfig, c.KDF)
+	}
+	switch c.AEAD {
+	case 0x0001:
+		s.keyLen = 16
+	case 0x0002:
+		s.keyLen = 32
+	default:
+		return nil, fmt.Errorf("%w: unsupported AEAD %d", ErrNoODoHConfig, c.AEAD)
+	}
+	kem, err := hpke.NewKEM(c.KEM)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrNoODoHConfig, err)
+	}
+	if s.pub, err = kem.NewPublicKey(c.PublicKey); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrMalformedODoH, err)
+	}
+	if s.kdf, err = hpke.NewKDF(c.KDF); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrNoODoHConfig, err)
+	}
+	if s.aead, err = hpke.NewAEAD(c.AEAD); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrNoODoHConfig, err)
+	}
+	return s, nil
+}
+
+// keyID returns the identifier of the config included in queries (RFC 9230 6.2)
+func (c *ODoHConfig) keyID(s *odohSuite) ([]byte, error) {
+	prk, err := hkdf.Extract(s.hash, c.contents(), nil)
+	if err != nil {
+		return nil, err
+	}
+	return hkdf.Expand(s.hash, prk, "odoh key id", s.hash().Size())
+}
+
+// ODoHTransport is a Transport which sends Oblivious DoH (RFC 9230) queries to
+// targets via a proxy, so the proxy learns who is sending queries but not what
+// they are, and the target learns the queries but not who sent them. addr is the
+// DoH URL of the target, the target's config is fetched from ODoHConfigsPath
+// directly, rather than through the proxy, the first time it is queried unless
+// it is set using SetConfig.
+//
+//	rr.Forward = &ForwardConfig{Servers: []string{"https://odoh.example/dns-query"}}
+//	rr.Transport = &ODoHTransport{Proxy: "https://proxy.example/proxy"}
+type ODoHTransport struct {
+	// Proxy is the URL of the Oblivious Proxy, the targethost and targetpath
+	// parameters are added to it
+	Proxy string
+	// Client is used to send requests to the proxy and to fetch configs, if nil
+	// http.DefaultClient is used
+	Client *http.Client
+
+	mu      sync.Mutex
+	configs map[string]*ODoHConfig
+}
+
+// NewODoHTransport returns a ODoHTransport which relays queries via proxy using
+// http.DefaultClient
+func NewODoHTransport(proxy string) *ODoHTransport {
+	return &ODoHTransport{Proxy: proxy, Client: http.DefaultClient}
+}
+
+// SetConfig sets the config used to encrypt queries to the target at host, which
+// can be used when it is distributed out of band
+func (ot *ODoHTransport) SetConfig(host string, c ODoHConfig) {
+	ot.mu.Lock()
+	defer ot.mu.Unlock()
+	if ot.configs == nil {
+		ot.configs = make(map[string]*ODoHConfig)
+	}
+	ot.configs[host] = &c
+}
+
+func (ot *ODoHTransport) client() *http.Client {
+	if ot.Client == nil {
+		return http.DefaultClient
+	}
+	return ot.Client
+}
+
+// config returns the config of the target, fetching it if it isn't known
+func (ot *ODoHTransport) config(ctx context.Context, target *url.URL) (*ODoHConfig, error) {
+	ot.mu.Lock()
+	c := ot.configs[target.Host]
+	ot.mu.Unlock()
+	if c != nil {
+		return c, nil
+	}
+	u := url.URL{Scheme: target.Scheme, Host: target.Host, Path: ODoHConfigsPath}
+	req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := ot.client().Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%w: fetching config: %s", ErrODoHStatus, resp.Status)
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxODoHConfigsSize))
+	if err != nil {
+		return nil, err
+	}
+	configs, err := ParseODoHConfigs(body)
+	if err != nil {
+		return nil, err
+	}
+	ot.SetConfig(target.Host, configs[0])
+	return &configs[0], nil
+}
+
+// forgetConfig drops the config of the target at host so it will be fetched again,
+// in case it has been rotated
+func (ot *ODoHTransport) forgetConfig(host string) {
+	ot.mu.Lock()
+	defer ot.mu.Unlock()
+	delete(ot.configs, host)
+}
+
+// Exchange implements Transport, addr is the URL of the target
+func (ot *ODoHTransport) Exchange(ctx context.Context, m *dns.Msg, addr string) (*dns.Msg, error) {
+	target, err := url.Parse(addr)
+	if err != nil {
+		return nil, err
+	}
+	proxy, err := url.Parse(ot.Proxy)
+	if err != nil {
+		return nil, err
+	}
+	params := proxy.Query()
+	params.Set("targethost", target.Host)
+	params.Set("targetpath", target.EscapedPath())
+	proxy.RawQuery = params.Encode()
+
+	c, err := ot.config(ctx, target)
+	if err != nil {
+		return nil, err
+	}
+	q := m.Copy()
+	q.Id = 0
+	wire, err := q.Pack()
+	if err != nil {
+		return nil, err
+	}
+	query, open, err := sealODoHQuery(c, wire)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, proxy.String(), bytes.NewReader(query))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", ODoHContentType)
+	req.Header.Set("Accept", ODoHContentType)
+	resp, err := ot.client().Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		// the target may have rotated its key
+		if resp.StatusCode == http.StatusBadRequest || resp.StatusCode == http.StatusUnauthorized {
+			ot.forgetConfig(target.Host)
+		}
+		return nil, fmt.Errorf("%w: %s", ErrODoHStatus, resp.Status)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != ODoHContentType {
+		return nil, fmt.Errorf("%w: unexpected content type %q", ErrODoHStatus, ct)
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxODoHMessageSize))
+	if err != nil {
+		return nil, err
+	}
+	plain, err := open(body)
+	if err != nil {
+		ot.forgetConfig(target.Host)
+		return nil, err
+	}
+	r := new(dns.Msg)
+	if err = r.Unpack(plain); err != nil {
+		return nil, err
+	}
+	r.Id = m.Id
+	return r, nil
+}
+
+// sealODoHQuery encrypts the DNS message wire to the target's config, returning the
+// ObliviousDoHMessage and a function which decrypts the response to it (RFC 9230 6.3)
+func sealODoHQuery(c *ODoHConfig, wire []byte) ([]byte, func([]byte) ([]byte, error), error) {
+	s, err := c.suite()
+	if err != nil {
+		return nil, nil, err
+	}
+	keyID, err := c.keyID(s)
+	if err != nil {
+		return nil, nil, err
+	}
+	plain := odohPlaintext(wire)
+	enc, sender, err := hpke.NewSender(s.pub, s.kdf, s.aead, []byte("odoh query"))
+	if err != nil {
+		return nil, nil, err
+	}
+	aad := appendVector([]byte{odohMessageQuery}, keyID)
+	ct, err := sender.Seal(aad, plain)
+	if err != nil {
+		return nil, nil, err
+	}
+	secret, err := sender.Export("odoh response", s.keyLen)
+	if err != nil {
+		return nil, nil, err
+	}
+	query := appendVector(appendVector([]byte{odohMessageQuery}, keyID), append(enc, ct...))
+	open := func(msg []byte) ([]byte, error) {
+		nonce, ct, err := parseODoHMessage(msg, odohMessageResponse)
+		if err != nil {
+			return nil, err
+		}
+		aead, err := odohResponseAEAD(s, secret, plain, nonce)
+		if err != nil {
+			return nil, err
+		}
+		pt, err := aead.open(ct, appendVector([]byte{odohMessageResponse}, nonce))
+		if err != nil {
+			return nil, fmt.Errorf("%w: failed to decrypt response: %s", ErrMalformedODoH, err)
+		}
+		return parseODoHPlaintext(pt)
+	}
+	return query, open, nil
+}
+
+// odohPlaintext returns the ObliviousDoHMessagePlaintext containing wire, padded up
+// to a multiple of odohPaddingBlockSize to hide the length of the query
+func odohPlaintext(wire []byte) []byte {
+	padding := (odohPaddingBlockSize - (len(wire)+4)%odohPaddingBlockSize) % odohPaddingBlockSize
+	return appendVector(appendVector(nil, wire), make([]byte, padding))
+}
+
+// parseODoHPlaintext returns the DNS message in an ObliviousDoHMessagePlaintext,
+// the padding must be all zeros
+func parseODoHPlaintext(b []byte) ([]byte, error) {
+	wire, rest, err := readVector(b)
+	if err != nil || len(wire) == 0 {
+		return nil, fmt.Errorf("%w: invalid plaintext", ErrMalformedODoH)
+	}
+	padding, rest, err := readVector(rest)
+	if err != nil || len(rest) != 0 {
+		return nil, fmt.Errorf("%w: invalid plaintext padding", ErrMalformedODoH)
+	}
+	for _, p := range padding {
+		if p != 0 {
+			return nil, fmt.Errorf("%w: non-zero plaintext padding", ErrMalformedODoH)
+		}
+	}
+	return wire, nil
+}
+
+// parseODoHMessage returns the key ID, or response nonce, and encrypted message of an
+// ObliviousDoHMessage of the type t
+func parseODoHMessage(b []byte, t byte) ([]byte, []byte, error) {
+	if len(b) < 1 || b[0] != t {
+		return nil, nil, fmt.Errorf("%w: unexpected message type", ErrMalformedODoH)
+	}
+	id, rest, err := readVector(b[1:])
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: truncated message", ErrMalformedODoH)
+	}
+	ct, rest, err := readVector(rest)
+	if err != nil || len(rest) != 0 || len(ct) == 0 {
+		return nil, nil, fmt.Errorf("%w: invalid encrypted message", ErrMalformedODoH)
+	}
+	return id, ct, nil
+}
+
+type odohAEAD struct {
+	aead  cipher.AEAD
+	nonce []byte
+}
+
+func (a *odohAEAD) open(ct, aad []byte) ([]byte, error) {
+	return a.aead.Open(nil, a.nonce, ct, aad)
+}
+
+// odohResponseAEAD derives the key and nonce used to encrypt the response to the
+// query plain from the exported secret and the response nonce (RFC 9230 6.4)
+func odohResponseAEAD(s *odohSuite, secret, plain, nonce []byte) (*odohAEAD, error) {
+	salt := appendVector(append([]byte(nil), plain...), nonce)
+	prk, err := hkdf.Extract(s.hash, secret, salt)
+	if err != nil {
+		return nil, err
+	}
+	key, err := hkdf.Expand(s.hash, prk, "odoh key", s.keyLen)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	n, err := hkdf.Expand(s.hash, prk, "odoh nonce", aead.NonceSize())
+	if err != nil {
+		return nil, err
+	}
+	return &odohAEAD{aead: aead, nonce: n}, nil
+}
+
+// readVector reads a vector with a two byte length prefix from b, returning it and
+// the remainder of b
+func readVector(b []byte) ([]byte, []byte, error) {
+	if len(b) < 2 {
+		return nil, nil, ErrMalformedODoH
+	}
+	l := int(binary.BigEndian.Uint16(b))
+	if len(b) < 2+l {
+		return nil, nil, ErrMalformedODoH
+	}
+	return b[2 : 2+l], b[2+l:], nil
+}
+
+// appendVector appends v to b with a two byte length prefix
+func appendVector(b, v []byte) []byte {
+	b = append(b, byte(len(v)>>8), byte(len(v)))
+	return append(b, v...)
+}
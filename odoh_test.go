@@ -0,0 +1,166 @@
+//go:build go1.26
+
+package solvere
+
+import (
+	"context"
+	"crypto/ecdh"
+	"crypto/hpke"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+// marshalODoHConfigs returns the ObliviousDoHConfigs encoding of configs, all with
+// the given version
+func marshalODoHConfigs(version uint16, configs ...ODoHConfig) []byte {
+	var body []byte
+	for _, c := range configs {
+		body = binary.BigEndian.AppendUint16(body, version)
+		body = appendVector(body, c.contents())
+	}
+	return appendVector(nil, body)
+}
+
+// openODoHQuery decrypts an ObliviousDoHMessage query as a target would, returning
+// the DNS message and a function which encrypts the response to it
+func openODoHQuery(t *testing.T, c *ODoHConfig, priv hpke.PrivateKey, msg []byte) ([]byte, func([]byte) []byte) {
+	s, err := c.suite()
+	if err != nil {
+		t.Fatalf("Unsupported config: %s", err)
+	}
+	keyID, body, err := parseODoHMessage(msg, odohMessageQuery)
+	if err != nil {
+		t.Fatalf("Failed to parse query: %s", err)
+	}
+	// the encapsulated key of X25519 is 32 bytes
+	rcpt, err := hpke.NewRecipient(body[:32], priv, s.kdf, s.aead, []byte("odoh query"))
+	if err != nil {
+		t.Fatalf("Failed to set up recipient: %s", err)
+	}
+	plain, err := rcpt.Open(appendVector([]byte{odohMessageQuery}, keyID), body[32:])
+	if err != nil {
+		t.Fatalf("Failed to decrypt query: %s", err)
+	}
+	if len(plain)%odohPaddingBlockSize != 0 {
+		t.Fatalf("Query plaintext isn't padded, %d bytes", len(plain))
+	}
+	wire, err := parseODoHPlaintext(plain)
+	if err != nil {
+		t.Fatalf("Failed to parse query plaintext: %s", err)
+	}
+	secret, err := rcpt.Export("odoh response", s.keyLen)
+	if err != nil {
+		t.Fatalf("Failed to export secret: %s", err)
+	}
+	return wire, func(resp []byte) []byte {
+		nonce := make([]byte, s.keyLen)
+		rand.Read(nonce)
+		aead, err := odohResponseAEAD(s, secret, plain, nonce)
+		if err != nil {
+			t.Fatalf("Failed to derive response key: %s", err)
+		}
+		ct := aead.aead.Seal(nil, aead.nonce, odohPlaintext(resp), appendVector([]byte{odohMessageResponse}, nonce))
+		return appendVector(appendVector([]byte{odohMessageResponse}, nonce), ct)
+	}
+}
+
+func newODoHKey(t *testing.T) (ODoHConfig, hpke.PrivateKey) {
+	priv, err := hpke.DHKEM(ecdh.X25519()).GenerateKey()
+	if err != nil {
+		t.Fatalf("Failed to generate key: %s", err)
+	}
+	return ODoHConfig{KEM: 0x0020, KDF: 0x0001, AEAD: 0x0001, PublicKey: priv.PublicKey().Bytes()}, priv
+}
+
+func TestParseODoHConfigs(t *testing.T) {
+	c, _ := newODoHKey(t)
+	chacha := c
+	chacha.AEAD = 0x0003
+	configs, err := ParseODoHConfigs(marshalODoHConfigs(odohVersion, chacha, c))
+	if err != nil {
+		t.Fatalf("Failed to parse configs: %s", err)
+	}
+	if len(configs) != 1 || configs[0].AEAD != c.AEAD || string(configs[0].PublicKey) != string(c.PublicKey) {
+		t.Fatalf("Expected only the supported config, got %+v", configs)
+	}
+	if _, err = ParseODoHConfigs(marshalODoHConfigs(0xff00, c)); err != ErrNoODoHConfig {
+		t.Fatalf("Expected ErrNoODoHConfig for an unknown version, got %v", err)
+	}
+	if _, err = ParseODoHConfigs(marshalODoHConfigs(odohVersion, c)[:20]); err == nil {
+		t.Fatal("Parsed truncated configs")
+	}
+}
+
+func TestODoHTransport(t *testing.T) {
+	c, priv := newODoHKey(t)
+	oldConfig, _ := newODoHKey(t)
+	var proxied []string
+	mux := http.NewServeMux()
+	mux.HandleFunc(ODoHConfigsPath, func(w http.ResponseWriter, r *http.Request) {
+		w.Write(marshalODoHConfigs(odohVersion, c))
+	})
+	// the proxy and target are combined
+	mux.HandleFunc("/proxy", func(w http.ResponseWriter, r *http.Request) {
+		proxied = append(proxied, r.URL.Query().Get("targethost")+r.URL.Query().Get("targetpath"))
+		if r.Method != http.MethodPost || r.Header.Get("Content-Type") != ODoHContentType {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		keyID, _, err := parseODoHMessage(body, odohMessageQuery)
+		s, _ := c.suite()
+		expected, _ := c.keyID(s)
+		if err != nil || string(keyID) != string(expected) {
+			http.Error(w, "unknown key", http.StatusUnauthorized)
+			return
+		}
+		wire, seal := openODoHQuery(t, &c, priv, body)
+		q := new(dns.Msg)
+		if err := q.Unpack(wire); err != nil || q.Id != 0 {
+			http.Error(w, "bad query", http.StatusBadRequest)
+			return
+		}
+		m := new(dns.Msg)
+		m.SetReply(q)
+		m.Answer = []dns.RR{&dns.A{Hdr: dns.RR_Header{Name: q.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60}, A: net.IP{192, 0, 2, 1}}}
+		resp, _ := m.Pack()
+		w.Header().Set("Content-Type", ODoHContentType)
+		w.Write(seal(resp))
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	ot := NewODoHTransport(srv.URL + "/proxy")
+	target := srv.URL + "/dns-query"
+	host := strings.TrimPrefix(srv.URL, "http://")
+	m := new(dns.Msg)
+	m.SetQuestion("example.", dns.TypeA)
+	r, err := ot.Exchange(context.Background(), m, target)
+	if err != nil {
+		t.Fatalf("Exchange failed: %s", err)
+	}
+	if r.Id != m.Id || len(r.Answer) != 1 || r.Answer[0].(*dns.A).A.String() != "192.0.2.1" {
+		t.Fatalf("Unexpected response: %s", r)
+	}
+	if len(proxied) != 1 || proxied[0] != host+"/dns-query" {
+		t.Fatalf("Query wasn't relayed to the target, got %v", proxied)
+	}
+
+	// a stale config is dropped when the target rejects it, and fetched again
+	ot.SetConfig(host, oldConfig)
+	if _, err = ot.Exchange(context.Background(), m, target); err == nil || !errors.Is(err, ErrODoHStatus) {
+		t.Fatalf("Expected ErrODoHStatus for a stale config, got %v", err)
+	}
+	if _, err = ot.Exchange(context.Background(), m, target); err != nil {
+		t.Fatalf("Exchange failed after the config was fetched again: %s", err)
+	}
+}
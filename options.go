@@ -0,0 +1,185 @@
+package solvere
+
+import (
+	"time"
+
+	"github.com/miekg/dns"
+
+	"github.com/rolandshoemaker/solvere/hints"
+)
+
+// options are the settings which have to be known to create a RecursiveResolver,
+// settings which can be changed afterwards are applied directly to it
+type options struct {
+	useIPv6   bool
+	useDNSSEC bool
+	rootHints []dns.RR
+	rootKeys  []dns.RR
+	cache     QuestionAnswerCache
+	// rootHintsSet and rootKeysSet are set if WithRootHints and
+	// WithTrustAnchors were used
+	rootHintsSet bool
+	rootKeysSet  bool
+	// set are applied to the resolver once it has been created
+	set []func(*RecursiveResolver)
+}
+
+// Option configures a RecursiveResolver created by NewResolver
+type Option func(*options)
+
+// NewResolver returns a RecursiveResolver configured by opts. Without any options
+// it iterates from the root nameservers in the hints package over IPv4,
+// validates answers using the root keys in the hints package, and doesn't cache
+// answers. Options which set a field of RecursiveResolver are equivalent to
+// setting it after NewResolver returns, later options override earlier ones.
+func NewResolver(opts ...Option) *RecursiveResolver {
+	o := &options{
+		useDNSSEC: true,
+		rootHints: hints.RootNameservers,
+		rootKeys:  hints.RootKeys,
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	rr := newResolver(o)
+	for _, set := range o.set {
+		set(rr)
+	}
+	return rr
+}
+
+func (o *options) apply(set func(*RecursiveResolver)) {
+	o.set = append(o.set, set)
+}
+
+// WithIPv6 controls whether the IPv6 addresses of nameservers are used, they
+// aren't by default
+func WithIPv6(enabled bool) Option {
+	return func(o *options) { o.useIPv6 = enabled }
+}
+
+// WithValidation controls whether answers are validated using DNSSEC, they are
+// by default
+func WithValidation(enabled bool) Option {
+	return func(o *options) { o.useDNSSEC = enabled }
+}
+
+// WithRootHints sets the NS and A/AAAA records of the root nameservers iteration
+// starts at, if empty no root nameservers are used and every query must be
+// forwarded or answered locally
+func WithRootHints(rootHints []dns.RR) Option {
+	return func(o *options) {
+		o.rootHints = rootHints
+		o.rootHintsSet = true
+	}
+}
+
+// WithTrustAnchors sets the root DNSKEY records the chain of trust is built from
+// when validating
+func WithTrustAnchors(rootKeys []dns.RR) Option {
+	return func(o *options) {
+		o.rootKeys = rootKeys
+		o.rootKeysSet = true
+	}
+}
+
+// WithCache sets the cache answers are stored in, if nil answers aren't cached
+func WithCache(cache QuestionAnswerCache) Option {
+	return func(o *options) { o.cache = cache }
+}
+
+// WithForwarders causes all queries to be sent to the forwarders at servers, with
+// the default timeout and attempts, instead of iterating. See
+// RecursiveResolver.Forward.
+func WithForwarders(servers ...string) Option {
+	return WithForwardConfig(&ForwardConfig{Servers: servers})
+}
+
+// WithForwardConfig causes all queries to be sent to the forwarders described by
+// fc instead of iterating, see RecursiveResolver.Forward
+func WithForwardConfig(fc *ForwardConfig) Option {
+	return func(o *options) {
+		o.apply(func(rr *RecursiveResolver) { rr.Forward = fc })
+	}
+}
+
+// WithTransport sets the Transport used to exchange messages with remote
+// nameservers, see RecursiveResolver.Transport
+func WithTransport(t Transport) Option {
+	return func(o *options) {
+		o.apply(func(rr *RecursiveResolver) { rr.Transport = t })
+	}
+}
+
+// WithTimeouts sets the maximum amount of time to wait for each query to a remote
+// nameserver and for each lookup, see RecursiveResolver.QueryTimeout and
+// LookupTimeout
+func WithTimeouts(query, lookup time.Duration) Option {
+	return func(o *options) {
+		o.apply(func(rr *RecursiveResolver) {
+			rr.QueryTimeout = query
+			rr.LookupTimeout = lookup
+		})
+	}
+}
+
+// WithLogger sets the Logger diagnostics are passed to, see
+// RecursiveResolver.Logger
+func WithLogger(l Logger) Option {
+	return func(o *options) {
+		o.apply(func(rr *RecursiveResolver) { rr.Logger = l })
+	}
+}
+
+// WithHooks registers a set of hooks, see RecursiveResolver.AddHooks
+func WithHooks(h Hooks) Option {
+	return func(o *options) {
+		o.apply(func(rr *RecursiveResolver) { rr.AddHooks(h) })
+	}
+}
+
+// With returns a view of the resolver with opts applied to it. The view shares
+// the resolver's caches, counters, hooks, and Transport, so creating it is cheap
+// and it can be used for the lookups of a single client or policy, e.g. to use
+// different forwarders, timeouts, or to disable validation. Since the caches are
+// shared WithValidation can only disable validation, unless WithCache is also
+// used, and WithTrustAnchors replaces the trust anchors in the shared cache, so
+// it also affects the resolver, it is meant for views which replace the resolver
+// when its configuration is reloaded, see Handler.SetResolver. The view must not
+// be modified once it is in use.
+func (rr *RecursiveResolver) With(opts ...Option) *RecursiveResolver {
+	o := &options{
+		useIPv6:   rr.useIPv6,
+		useDNSSEC: rr.useDNSSEC && !rr.skipValidation,
+		rootHints: rr.rootHints,
+		rootKeys:  rr.rootKeys,
+		cache:     rr.cache,
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	v := *rr
+	// hooks added to the view mustn't be appended to the resolver's slice
+	v.hooks = rr.hooks[:len(rr.hooks):len(rr.hooks)]
+	if o.cache != rr.cache {
+		v.cache = o.cache
+		v.rootKeys = o.rootKeys
+		v.useDNSSEC = o.useDNSSEC
+		v.skipValidation = false
+		v.addTrustAnchors()
+	} else {
+		v.skipValidation = rr.useDNSSEC && !o.useDNSSEC
+		if o.rootKeysSet {
+			v.rootKeys = o.rootKeys
+			v.addTrustAnchors()
+		}
+	}
+	if o.rootHintsSet || o.useIPv6 != rr.useIPv6 {
+		v.useIPv6 = o.useIPv6
+		v.setRootHints(o.rootHints)
+	}
+	for _, set := range o.set {
+		set(&v)
+	}
+	return &v
+}
@@ -0,0 +1,141 @@
+package solvere
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func TestNewResolver(t *testing.T) {
+	rr := NewResolver()
+	if len(rr.rootNameservers) == 0 || len(rr.rootKeys) == 0 || !rr.useDNSSEC {
+		t.Fatal("Default resolver doesn't iterate from the built-in hints and validate")
+	}
+	for _, ns := range rr.rootNameservers {
+		if net.ParseIP(ns.Addr).To4() == nil {
+			t.Fatalf("Default resolver uses IPv6 root nameserver %s", ns.Addr)
+		}
+	}
+
+	cache := NewBasicCache()
+	defer cache.Close()
+	rr = NewResolver(WithRootHints(nil), WithValidation(false), WithCache(cache), WithForwarders("10.0.0.1"))
+	if len(rr.rootNameservers) != 0 || rr.useDNSSEC || rr.cache != cache {
+		t.Fatal("Options weren't applied")
+	}
+	if rr.Forward == nil || len(rr.Forward.Servers) != 1 || rr.Forward.Servers[0] != "10.0.0.1" {
+		t.Fatalf("Unexpected forwarders: %+v", rr.Forward)
+	}
+	if cache.Get(&Question{Name: ".", Type: dns.TypeDNSKEY}) == nil {
+		t.Fatal("Trust anchors weren't added to the cache")
+	}
+}
+
+func TestWithTimeouts(t *testing.T) {
+	rr := NewResolver(
+		WithRootHints(nil),
+		WithValidation(false),
+		WithForwardConfig(&ForwardConfig{Servers: []string{"10.0.0.1"}, Timeout: time.Minute, Attempts: 1}),
+		WithTransport(transportFunc(func(ctx context.Context, _ *dns.Msg, _ string) (*dns.Msg, error) {
+			<-ctx.Done()
+			return nil, ctx.Err()
+		})),
+		WithTimeouts(50*time.Millisecond, 0),
+	)
+	s := time.Now()
+	if _, _, err := rr.Lookup(context.Background(), Question{Name: "example.", Type: dns.TypeA}); err == nil {
+		t.Fatal("Lookup of unresponsive forwarder didn't fail")
+	}
+	if took := time.Since(s); took > 5*time.Second {
+		t.Fatalf("Query timeout wasn't applied, lookup took %s", took)
+	}
+}
+
+func TestWith(t *testing.T) {
+	cache := NewBasicCache()
+	defer cache.Close()
+	var addrs []string
+	rr := NewResolver(
+		WithRootHints(nil),
+		WithValidation(false),
+		WithCache(cache),
+		WithForwarders("10.0.0.1"),
+		WithTransport(transportFunc(func(_ context.Context, m *dns.Msg, addr string) (*dns.Msg, error) {
+			addrs = append(addrs, addr)
+			r := new(dns.Msg)
+			r.SetReply(m)
+			r.Answer = []dns.RR{&dns.A{Hdr: dns.RR_Header{Name: m.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60}, A: net.IP{1, 2, 3, 4}}}
+			return r, nil
+		})),
+	)
+	viewHooks := 0
+	view := rr.With(WithForwarders("10.0.0.2"), WithHooks(Hooks{OnQuery: func(context.Context, *Question) (*Answer, error) {
+		viewHooks++
+		return nil, nil
+	}}))
+
+	if _, _, err := view.Lookup(context.Background(), Question{Name: "a.example.", Type: dns.TypeA}); err != nil {
+		t.Fatalf("Lookup using view failed: %s", err)
+	}
+	if len(addrs) != 1 || addrs[0] != net.JoinHostPort("10.0.0.2", dnsPort) {
+		t.Fatalf("View didn't use its forwarders: %v", addrs)
+	}
+	// the answer cached by the view is used by the resolver, answers are added
+	// to the cache in the background
+	for i := 0; i < 1000 && cache.Get(&Question{Name: "a.example.", Type: dns.TypeA}) == nil; i++ {
+		time.Sleep(time.Millisecond)
+	}
+	if _, _, err := rr.Lookup(context.Background(), Question{Name: "a.example.", Type: dns.TypeA}); err != nil {
+		t.Fatalf("Lookup failed: %s", err)
+	}
+	if len(addrs) != 1 {
+		t.Fatalf("Resolver didn't use the answer cached by the view: %v", addrs)
+	}
+	if _, _, err := rr.Lookup(context.Background(), Question{Name: "b.example.", Type: dns.TypeA}); err != nil {
+		t.Fatalf("Lookup failed: %s", err)
+	}
+	if len(addrs) != 2 || addrs[1] != net.JoinHostPort("10.0.0.1", dnsPort) {
+		t.Fatalf("Resolver used the view's forwarders: %v", addrs)
+	}
+	if viewHooks != 1 {
+		t.Fatalf("Hooks added to the view were called %d times", viewHooks)
+	}
+	if queries := rr.Stats().Queries; queries != 3 {
+		t.Fatalf("Expected 3 queries counted, got %d", queries)
+	}
+}
+
+func TestWithoutValidationView(t *testing.T) {
+	cache := NewBasicCache()
+	defer cache.Close()
+	rr := NewResolver(WithRootHints(nil), WithCache(cache), WithForwarders("10.0.0.1"))
+	view := rr.With(WithValidation(false))
+	if !rr.validating(context.Background()) || !view.skipValidation {
+		t.Fatal("View doesn't skip validation")
+	}
+	if view.With(WithValidation(true)).skipValidation {
+		t.Fatal("Validation wasn't enabled again")
+	}
+	if noDNSSEC := NewResolver(WithValidation(false)); noDNSSEC.With(WithValidation(true)).validating(context.Background()) {
+		t.Fatal("View of a resolver that doesn't validate validates using its cache")
+	}
+}
+
+func TestWithTrustAnchors(t *testing.T) {
+	cache := NewBasicCache()
+	defer cache.Close()
+	rr := NewResolver(WithRootHints(nil), WithCache(cache))
+	key := &dns.DNSKEY{Hdr: dns.RR_Header{Name: ".", Rrtype: dns.TypeDNSKEY, Class: dns.ClassINET}, Flags: 257, Protocol: 3, Algorithm: dns.RSASHA256, PublicKey: "AwEAAQ=="}
+	view := rr.With(WithTrustAnchors([]dns.RR{key}))
+	if len(view.rootKeys) != 1 || len(rr.rootKeys) == 1 {
+		t.Fatal("View doesn't have its own trust anchors")
+	}
+	// the trust anchors are replaced in the shared cache
+	a := cache.Get(&Question{Name: ".", Type: dns.TypeDNSKEY})
+	if a == nil || len(a.Answer) != 1 || a.Answer[0].(*dns.DNSKEY).PublicKey != key.PublicKey {
+		t.Fatalf("Trust anchors in the cache weren't replaced: %v", a)
+	}
+}
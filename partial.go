@@ -0,0 +1,166 @@
+package solvere
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// PartialFailure describes part of a answer that couldn't be resolved, or was
+// removed because it failed validation, by a lookup using a context returned by
+// WithPartialResults
+type PartialFailure struct {
+	Name string
+	Type uint16
+	Err  error
+}
+
+type partialResultsKey struct{}
+
+// WithPartialResults returns a copy of ctx which causes lookups using it to return
+// the usable part of answers which partially failed, with Answer.Failures
+// describing what failed, rather than failing entirely. RRsets in the answer
+// section of a response which fail validation are removed as long as others
+// validate, whether iterating or forwarding, and LookupAddresses returns the
+// addresses of either type if looking up the other fails. Failures of the
+// records answering the question itself, or of the CNAME and DNAME records
+// leading to them, of the authority section, which proves negative answers, and
+// of the DNSKEY or DS records of a zone still fail the lookup. Partial answers
+// aren't authenticated or cached.
+func WithPartialResults(ctx context.Context) context.Context {
+	return context.WithValue(ctx, partialResultsKey{}, true)
+}
+
+// partialResults checks if ctx was returned by WithPartialResults
+func partialResults(ctx context.Context) bool {
+	partial, _ := ctx.Value(partialResultsKey{}).(bool)
+	return partial
+}
+
+// salvageRRSets removes the RRsets, and their RRSIGs, which fail validation using
+// the DNSKEYs returned by keyFor from the answer section of msg. err is the error
+// validating the whole message, it is returned if the authority section doesn't
+// validate, if one of the RRsets which fail answers the question or is part of
+// the alias chain leading to the answer, or if no signed RRsets are left in the
+// answer section.
+func salvageRRSets(ctx context.Context, msg *dns.Msg, keyFor func(*dns.RRSIG) *dns.DNSKEY, err error) ([]PartialFailure, error) {
+	if msg.Rcode != dns.RcodeSuccess || len(msg.Answer) == 0 || len(msg.Question) != 1 {
+		return nil, err
+	}
+	qtype := msg.Question[0].Qtype
+	chain := aliasChain(msg.Answer, msg.Question[0].Name, ".")
+	if verifySignatures(ctx, &dns.Msg{Ns: msg.Ns}, keyFor) != nil {
+		return nil, err
+	}
+	sigs := map[rrsetKey][]*dns.RRSIG{}
+	for _, r := range msg.Answer {
+		if sig, ok := r.(*dns.RRSIG); ok {
+			k := rrsetKey{strings.ToLower(sig.Hdr.Name), sig.TypeCovered, sig.Hdr.Class}
+			sigs[k] = append(sigs[k], sig)
+		}
+	}
+	var failures []PartialFailure
+	var kept []dns.RR
+	for _, set := range GroupRRSets(msg.Answer) {
+		if set.Type == dns.TypeRRSIG {
+			continue
+		}
+		k := rrsetKey{strings.ToLower(set.Name), set.Type, set.Class}
+		setErr := verifySignatures(ctx, &dns.Msg{Answer: append(append([]dns.RR{}, set.Records...), rrsigsOf(sigs[k])...)}, keyFor)
+		if setErr == ErrValidationBudgetExceeded {
+			return nil, setErr
+		} else if setErr != nil {
+			name := strings.ToLower(set.Name)
+			if mapHas(chain, name) && (set.Type == qtype || set.Type == dns.TypeCNAME) || set.Type == dns.TypeDNAME && ownsAlias(chain, name) {
+				return nil, err
+			}
+			failures = append(failures, PartialFailure{Name: set.Name, Type: set.Type, Err: setErr})
+			continue
+		}
+		kept = append(kept, set.Records...)
+		kept = append(kept, rrsigsOf(sigs[k])...)
+	}
+	if len(failures) == 0 || len(kept) == 0 {
+		return nil, err
+	}
+	msg.Answer = kept
+	return failures, nil
+}
+
+func rrsigsOf(sigs []*dns.RRSIG) []dns.RR {
+	rrs := make([]dns.RR, len(sigs))
+	for i, sig := range sigs {
+		rrs[i] = sig
+	}
+	return rrs
+}
+
+// LookupAddresses looks up the A and AAAA records of name concurrently and returns
+// a answer containing both, the LookupLog has the logs of each lookup as its
+// Composites. If either lookup fails the error is returned, unless ctx was
+// returned by WithPartialResults and the other lookup succeeded, in which case its
+// answer is returned and the failure is described in Answer.Failures. The answer
+// is only authenticated if both lookups are and neither partially failed.
+func (rr *RecursiveResolver) LookupAddresses(ctx context.Context, name string) (*Answer, *LookupLog, error) {
+	types := []uint16{dns.TypeA, dns.TypeAAAA}
+	answers := make([]*Answer, len(types))
+	logs := make([]*LookupLog, len(types))
+	errs := make([]error, len(types))
+	wg := new(sync.WaitGroup)
+	for i, t := range types {
+		wg.Add(1)
+		go func(i int, t uint16) {
+			defer wg.Done()
+			answers[i], logs[i], errs[i] = rr.Lookup(ctx, Question{Name: name, Type: t})
+		}(i, t)
+	}
+	wg.Wait()
+
+	ll := newLookupLog(&Question{Name: name, Type: dns.TypeANY}, nil)
+	for _, l := range logs {
+		if l != nil {
+			ll.Composites = append(ll.Composites, l)
+		}
+	}
+	ll.Latency = time.Since(ll.Started)
+	var combined *Answer
+	var failures []PartialFailure
+	for i, a := range answers {
+		if errs[i] != nil {
+			if !partialResults(ctx) {
+				ll.Error = errs[i].Error()
+				return nil, ll, errs[i]
+			}
+			failures = append(failures, PartialFailure{Name: name, Type: types[i], Err: errs[i]})
+			continue
+		}
+		if combined == nil {
+			c := *a
+			c.Answer = append([]dns.RR{}, a.Answer...)
+			combined = &c
+			continue
+		}
+		// both lookups follow the same CNAME records
+		combined.Answer = dns.Dedup(append(combined.Answer, a.Answer...), nil)
+		combined.Authority = append(append([]dns.RR{}, combined.Authority...), a.Authority...)
+		combined.Additional = append(append([]dns.RR{}, combined.Additional...), a.Additional...)
+		combined.Authenticated = combined.Authenticated && a.Authenticated
+		combined.ExtendedErrors = append(combined.ExtendedErrors, a.ExtendedErrors...)
+		combined.Failures = append(combined.Failures, a.Failures...)
+		if combined.Rcode != dns.RcodeSuccess {
+			combined.Rcode = a.Rcode
+		}
+	}
+	if combined == nil {
+		ll.Error = errs[0].Error()
+		return nil, ll, errs[0]
+	}
+	combined.Failures = append(combined.Failures, failures...)
+	if len(combined.Failures) > 0 {
+		combined.Authenticated = false
+	}
+	return combined, ll, nil
+}
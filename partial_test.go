@@ -0,0 +1,107 @@
+package solvere
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestPartialValidation(t *testing.T) {
+	root, example, untrusted := newTestZone(t, "."), newTestZone(t, "example."), newTestZone(t, "example.")
+	cname := &dns.CNAME{Hdr: dns.RR_Header{Name: "a.example.", Rrtype: dns.TypeCNAME, Class: dns.ClassINET, Ttl: 60}, Target: "b.example."}
+	a := &dns.A{Hdr: dns.RR_Header{Name: "b.example.", Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60}, A: net.IP{1, 2, 3, 4}}
+	txt := &dns.TXT{Hdr: dns.RR_Header{Name: "b.example.", Rrtype: dns.TypeTXT, Class: dns.ClassINET, Ttl: 60}, Txt: []string{"hello"}}
+	var answer []dns.RR
+	rr := NewRecursiveResolver(false, true, nil, []dns.RR{root.key}, nil)
+	rr.Forward = &ForwardConfig{Servers: []string{"10.0.0.1"}}
+	rr.BogusTTL = -1
+	rr.Transport = transportFunc(func(_ context.Context, m *dns.Msg, _ string) (*dns.Msg, error) {
+		r := new(dns.Msg)
+		r.SetReply(m)
+		switch m.Question[0].Qtype {
+		case dns.TypeDNSKEY:
+			r.Answer = example.sign(t, example.key)
+		case dns.TypeDS:
+			r.Answer = root.sign(t, example.key.ToDS(dns.SHA256))
+		default:
+			r.Answer = answer
+		}
+		return r, nil
+	})
+
+	// the TXT record, which doesn't answer the question, is signed by a key
+	// that isn't in the chain of trust
+	answer = append(example.sign(t, a), untrusted.sign(t, txt)...)
+	q := Question{Name: "b.example.", Type: dns.TypeANY}
+	ans, _, err := rr.Lookup(WithPartialResults(context.Background()), q)
+	if err != nil {
+		t.Fatalf("Partial lookup failed: %s", err)
+	}
+	if len(ans.Failures) != 1 || ans.Failures[0].Name != "b.example." || ans.Failures[0].Type != dns.TypeTXT || ans.Failures[0].Err == nil {
+		t.Fatalf("Unexpected failures: %+v", ans.Failures)
+	}
+	if len(extractRRSet(ans.Answer, "b.example.", dns.TypeTXT)) != 0 {
+		t.Fatal("Answer contains record which failed validation")
+	}
+	if len(extractRRSet(ans.Answer, "b.example.", dns.TypeA)) != 1 {
+		t.Fatal("Validated A record was removed")
+	}
+	if ans.Authenticated {
+		t.Fatal("Partial answer was authenticated")
+	}
+	if _, _, err := rr.Lookup(context.Background(), q); err == nil || !isBogus(err) {
+		t.Fatalf("Expected lookup without partial results to fail validation, got %v", err)
+	}
+
+	// the records answering the question, and the aliases leading to them,
+	// can't be removed
+	for _, tc := range []struct {
+		name   string
+		answer []dns.RR
+	}{
+		{"target", append(example.sign(t, cname), untrusted.sign(t, a)...)},
+		{"alias", append(untrusted.sign(t, cname), example.sign(t, a)...)},
+	} {
+		answer = tc.answer
+		_, _, err := rr.Lookup(WithPartialResults(context.Background()), Question{Name: "a.example.", Type: dns.TypeA})
+		if err == nil || !isBogus(err) {
+			t.Fatalf("Expected partial lookup with bogus %s to fail validation, got %v", tc.name, err)
+		}
+	}
+}
+
+func TestLookupAddresses(t *testing.T) {
+	errTimeout := errors.New("timed out")
+	rr := NewRecursiveResolver(false, false, nil, nil, nil)
+	rr.FailureTTL = -1
+	rr.Forward = &ForwardConfig{Servers: []string{"10.0.0.1"}, Attempts: 1}
+	rr.Transport = transportFunc(func(_ context.Context, m *dns.Msg, _ string) (*dns.Msg, error) {
+		if m.Question[0].Qtype == dns.TypeAAAA {
+			return nil, errTimeout
+		}
+		r := new(dns.Msg)
+		r.SetReply(m)
+		r.Answer = []dns.RR{&dns.A{Hdr: dns.RR_Header{Name: m.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60}, A: net.IP{1, 2, 3, 4}}}
+		return r, nil
+	})
+
+	if _, ll, err := rr.LookupAddresses(context.Background(), "example."); !errors.Is(err, errTimeout) {
+		t.Fatalf("Expected AAAA lookup failure, got %v", err)
+	} else if len(ll.Composites) != 2 {
+		t.Fatalf("Expected the logs of both lookups, got %d", len(ll.Composites))
+	}
+
+	ans, _, err := rr.LookupAddresses(WithPartialResults(context.Background()), "example.")
+	if err != nil {
+		t.Fatalf("Partial lookup failed: %s", err)
+	}
+	if ips := ans.IPs(); len(ips) != 1 || !ips[0].Equal(net.IP{1, 2, 3, 4}) {
+		t.Fatalf("Unexpected addresses: %v", ips)
+	}
+	if len(ans.Failures) != 1 || ans.Failures[0].Type != dns.TypeAAAA || !errors.Is(ans.Failures[0].Err, errTimeout) {
+		t.Fatalf("Unexpected failures: %+v", ans.Failures)
+	}
+}
@@ -0,0 +1,39 @@
+//go:build linux
+
+package solvere
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+)
+
+// checkPeerCredentials checks that the process connected to the unix socket conn
+// is running as root or as the same user as this process, using SO_PEERCRED.
+// Connections which aren't over unix sockets aren't checked.
+func checkPeerCredentials(conn net.Conn) error {
+	uc, ok := conn.(*net.UnixConn)
+	if !ok {
+		return nil
+	}
+	raw, err := uc.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var cred *syscall.Ucred
+	var serr error
+	err = raw.Control(func(fd uintptr) {
+		cred, serr = syscall.GetsockoptUcred(int(fd), syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+	})
+	if err == nil {
+		err = serr
+	}
+	if err != nil {
+		return err
+	}
+	if cred.Uid != 0 && int(cred.Uid) != os.Getuid() {
+		return fmt.Errorf("peer uid %d isn't allowed", cred.Uid)
+	}
+	return nil
+}
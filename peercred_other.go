@@ -0,0 +1,11 @@
+//go:build !linux
+
+package solvere
+
+import "net"
+
+// checkPeerCredentials does nothing as the syscall package doesn't expose the
+// credentials of unix socket peers on this platform
+func checkPeerCredentials(conn net.Conn) error {
+	return nil
+}
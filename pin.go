@@ -0,0 +1,77 @@
+package solvere
+
+import (
+	"context"
+	"time"
+
+	"github.com/jmhodges/clock"
+	"github.com/miekg/dns"
+)
+
+// PinRetryInterval is the amount of time to wait before retrying the refresh of a
+// pinned answer that failed
+var PinRetryInterval = 10 * time.Second
+
+// PinRefreshMargin is how long before a pinned answer expires it is refreshed, so
+// that the new answer replaces it in the cache before it can be evicted
+var PinRefreshMargin = 5 * time.Second
+
+// Pin looks up q and keeps its answer in the cache until ctx is cancelled. If the
+// cache is a PinningCache the answer is never evicted to make room for others, and
+// shortly before it expires it is looked up again so it is always available. This is
+// intended for the handful of names an application can't do without, such as its
+// own API endpoints. If the first lookup fails its error is returned and q isn't
+// pinned.
+func (rr *RecursiveResolver) Pin(ctx context.Context, q Question) error {
+	if pc, ok := rr.cache.(PinningCache); ok {
+		pc.Pin(&q)
+	}
+	a, _, err := rr.Lookup(ctx, q)
+	if err != nil {
+		rr.unpin(q)
+		return err
+	}
+	go rr.refreshPinned(ctx, q, a)
+	return nil
+}
+
+func (rr *RecursiveResolver) unpin(q Question) {
+	if pc, ok := rr.cache.(PinningCache); ok {
+		pc.Unpin(&q)
+	}
+}
+
+// refreshPinned looks up q shortly before each of its answers expires until ctx
+// is cancelled
+func (rr *RecursiveResolver) refreshPinned(ctx context.Context, q Question, a *Answer) {
+	defer rr.unpin(q)
+	// the refresh skips the cache, which would otherwise answer it with the
+	// answer being replaced, the new answer is still cached
+	ctx = context.WithValue(ctx, noCacheKey{}, true)
+	for {
+		wait := PinRetryInterval
+		if a != nil {
+			// refresh before the cached answer expires so there is no gap
+			// where the name isn't cached, answers with TTLs shorter than
+			// the margin are refreshed half way through them
+			if ttl := time.Duration(answerTTL(a, clock.Default())) * time.Second; ttl > 0 {
+				wait = ttl - PinRefreshMargin
+				if wait <= 0 {
+					wait = ttl / 2
+				}
+			}
+		}
+		t := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return
+		case <-t.C:
+		}
+		var err error
+		if a, _, err = rr.Lookup(ctx, q); err != nil {
+			rr.log(LogWarn, "pinned name refresh failed", "name", q.Name, "type", dns.TypeToString[q.Type], "err", err)
+			a = nil
+		}
+	}
+}
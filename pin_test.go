@@ -0,0 +1,73 @@
+package solvere
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func TestPin(t *testing.T) {
+	cache := NewBoundedCache(1)
+	rr := NewRecursiveResolver(false, false, nil, nil, cache)
+	rr.Forward = &ForwardConfig{Servers: []string{"10.0.0.1"}}
+	rr.Transport = transportFunc(func(_ context.Context, m *dns.Msg, _ string) (*dns.Msg, error) {
+		r := new(dns.Msg)
+		r.SetReply(m)
+		if m.Question[0].Name == "broken." {
+			r.Rcode = dns.RcodeServerFailure
+			return r, nil
+		}
+		r.Answer = []dns.RR{&dns.A{Hdr: dns.RR_Header{Name: m.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60}, A: net.IP{1, 2, 3, 4}}}
+		return r, nil
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := rr.Pin(ctx, Question{Name: "broken.", Type: dns.TypeA}); err == nil {
+		t.Fatal("Pin didn't fail when the lookup failed")
+	}
+	if err := rr.Pin(ctx, Question{Name: "api.example.", Type: dns.TypeA}); err != nil {
+		t.Fatalf("Pin failed: %s", err)
+	}
+	for _, name := range []string{"a.", "b.", "c."} {
+		if _, _, err := rr.Lookup(ctx, Question{Name: name, Type: dns.TypeA}); err != nil {
+			t.Fatalf("Lookup failed: %s", err)
+		}
+	}
+	// answers are added to the cache asynchronously
+	time.Sleep(10 * time.Millisecond)
+	if cache.Get(&Question{Name: "api.example.", Type: dns.TypeA}) == nil {
+		t.Fatal("Pinned answer was evicted")
+	}
+}
+
+func TestPinRefreshBeforeExpiry(t *testing.T) {
+	cache := NewBasicCache()
+	rr := NewRecursiveResolver(false, false, nil, nil, cache)
+	rr.Forward = &ForwardConfig{Servers: []string{"10.0.0.1"}}
+	queries := make(chan struct{}, 10)
+	rr.Transport = transportFunc(func(_ context.Context, m *dns.Msg, _ string) (*dns.Msg, error) {
+		queries <- struct{}{}
+		r := new(dns.Msg)
+		r.SetReply(m)
+		r.Answer = []dns.RR{&dns.A{Hdr: dns.RR_Header{Name: m.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 1}, A: net.IP{1, 2, 3, 4}}}
+		return r, nil
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := rr.Pin(ctx, Question{Name: "api.example.", Type: dns.TypeA}); err != nil {
+		t.Fatalf("Pin failed: %s", err)
+	}
+	<-queries
+	// the answer is refreshed from the forwarder, not the cache, while it is
+	// still cached
+	select {
+	case <-queries:
+	case <-time.After(900 * time.Millisecond):
+		t.Fatal("Pinned answer wasn't refreshed before it expired")
+	}
+}
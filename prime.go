@@ -0,0 +1,164 @@
+package solvere
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// DefaultPrimeTLDs are the top level domains primed by PrimeTLDs if none are
+// passed to it
+var DefaultPrimeTLDs = []string{"com.", "net.", "org."}
+
+// MaxPrimeTTL is the maximum amount of time a primed delegation is used for,
+// regardless of the TTL of its NS records
+var MaxPrimeTTL = 24 * time.Hour
+
+// primedZone is a delegation from the root learnt by PrimeTLDs
+type primedZone struct {
+	servers []Nameserver
+	// ds is the validated DS RRset for the zone, it is empty if the resolver
+	// isn't validating or the zone is proven to be insecure
+	ds      []dns.RR
+	expires time.Time
+}
+
+// primedZones are the delegations iteration may start at instead of the root
+type primedZones struct {
+	mu    sync.RWMutex
+	zones map[string]primedZone
+}
+
+func (pz *primedZones) get(zone string) (primedZone, bool) {
+	if pz == nil {
+		return primedZone{}, false
+	}
+	pz.mu.RLock()
+	defer pz.mu.RUnlock()
+	z, present := pz.zones[zone]
+	if !present || !time.Now().Before(z.expires) {
+		return primedZone{}, false
+	}
+	return z, true
+}
+
+func (pz *primedZones) set(zone string, z primedZone) {
+	pz.mu.Lock()
+	defer pz.mu.Unlock()
+	if pz.zones == nil {
+		pz.zones = make(map[string]primedZone)
+	}
+	pz.zones[zone] = z
+}
+
+type noCacheKey struct{}
+
+// cacheSkipped checks if the answer cache shouldn't be used for queries sent
+// using ctx
+func cacheSkipped(ctx context.Context) bool {
+	skip, _ := ctx.Value(noCacheKey{}).(bool)
+	return skip
+}
+
+// PrimeTLDs learns the nameservers of each of tlds, or DefaultPrimeTLDs if none
+// are passed, from the root nameservers so iteration for names in them starts at
+// their nameservers, saving a round trip to the root for the first query. When
+// validating the delegations are only used if their DS records, or the proof
+// that the zone is insecure, validate. Each delegation is used until the TTL of
+// its NS records, up to MaxPrimeTTL, expires, after which iteration starts at the
+// root again, so PrimeTLDs should be called periodically to keep them primed.
+// Every TLD is attempted and the first error encountered is returned.
+func (rr *RecursiveResolver) PrimeTLDs(ctx context.Context, tlds ...string) error {
+	if len(tlds) == 0 {
+		tlds = DefaultPrimeTLDs
+	}
+	var first error
+	for _, tld := range tlds {
+		if err := rr.primeTLD(ctx, CanonicalName(tld)); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+func (rr *RecursiveResolver) primeTLD(ctx context.Context, tld string) error {
+	if len(rr.rootNameservers) == 0 {
+		return ErrNoNSAuthorties
+	}
+	q := Question{Name: tld, Type: dns.TypeNS}
+	root := rr.pickNameserver(rr.rootNameservers)
+	// the cached NS RRset doesn't include the glue or DS records, so the
+	// referral has to come from the root
+	r, _, err := rr.query(context.WithValue(ctx, noCacheKey{}, true), &q, root)
+	if err != nil {
+		return newResolutionError(StageQuery, &q, root, -1, err)
+	}
+	if !isReferralTo(r, tld) || checkReferral(r.Ns, ".", tld) != nil {
+		return newResponseError(StageReferral, &q, root, r, ErrInvalidReferral)
+	}
+	var ds []dns.RR
+	if rr.useDNSSEC {
+		if _, _, err := rr.checkSignatures(ctx, r, root, nil); err != nil {
+			return newResponseError(StageValidation, &q, root, r, err)
+		}
+		ds = extractRRSet(r.Ns, tld, dns.TypeDS)
+		if len(ds) == 0 {
+			nsecSet := extractRRSet(r.Ns, "", dns.TypeNSEC3)
+			if len(nsecSet) == 0 {
+				return newResponseError(StageValidation, &q, root, r, ErrUnsignedDelegation)
+			}
+			if err := verifyDelegation(tld, nsecSet); err != nil {
+				return newResponseError(StageDenial, &q, root, r, err)
+			}
+			rr.zoneStatus.setFromRecords(tld, SecurityInsecure, nsecSet)
+		} else {
+			rr.zoneStatus.setFromRecords(tld, SecuritySecure, ds)
+		}
+	}
+
+	z := primedZone{ds: ds, expires: time.Now().Add(MaxPrimeTTL)}
+	names := map[string]struct{}{}
+	for _, ns := range extractRRSet(r.Ns, tld, dns.TypeNS) {
+		names[strings.ToLower(ns.(*dns.NS).Ns)] = struct{}{}
+		if expires := time.Now().Add(time.Duration(ns.Header().Ttl) * time.Second); expires.Before(z.expires) {
+			z.expires = expires
+		}
+	}
+	for _, a := range r.Extra {
+		if _, present := names[strings.ToLower(a.Header().Name)]; !present {
+			continue
+		}
+		switch a := a.(type) {
+		case *dns.A:
+			z.servers = append(z.servers, Nameserver{Name: a.Hdr.Name, Addr: a.A.String(), Zone: tld})
+		case *dns.AAAA:
+			if rr.useIPv6 {
+				z.servers = append(z.servers, Nameserver{Name: a.Hdr.Name, Addr: a.AAAA.String(), Zone: tld})
+			}
+		}
+	}
+	if len(z.servers) == 0 {
+		return newResponseError(StageReferral, &q, root, r, fmt.Errorf("%w: referral for %s has no glue", ErrNoAuthorityAddress, tld))
+	}
+	rr.fillCache(r, ".")
+	rr.primed.set(tld, z)
+	return nil
+}
+
+// primedAuthority returns one of the nameservers of the primed top level domain
+// containing name and its DS RRset, if there is one
+func (rr *RecursiveResolver) primedAuthority(name string) (*Nameserver, []dns.RR, bool) {
+	zones := enclosingZones(name)
+	if len(zones) < 2 {
+		return nil, nil, false
+	}
+	z, present := rr.primed.get(zones[len(zones)-2])
+	if !present {
+		return nil, nil, false
+	}
+	return rr.pickNameserver(z.servers), z.ds, true
+}
@@ -0,0 +1,64 @@
+package solvere
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestPrimeTLDs(t *testing.T) {
+	root, example := newTestZone(t, "."), newTestZone(t, "example.")
+	rr := NewRecursiveResolver(false, true, []dns.RR{
+		&dns.A{Hdr: dns.RR_Header{Name: "a.root-servers.net.", Rrtype: dns.TypeA, Class: dns.ClassINET}, A: net.IP{198, 41, 0, 4}},
+	}, []dns.RR{root.key}, NewBasicCache())
+	rootAddr := net.JoinHostPort("198.41.0.4", dnsPort)
+	var addrs []string
+	rr.Transport = transportFunc(func(_ context.Context, m *dns.Msg, addr string) (*dns.Msg, error) {
+		addrs = append(addrs, addr)
+		r := new(dns.Msg)
+		r.SetReply(m)
+		q := m.Question[0]
+		switch {
+		case addr == rootAddr && q.Qtype == dns.TypeDNSKEY:
+			r.Answer = root.sign(t, root.key)
+		case addr == rootAddr:
+			r.Ns = append([]dns.RR{&dns.NS{Hdr: dns.RR_Header{Name: "example.", Rrtype: dns.TypeNS, Class: dns.ClassINET, Ttl: 3600}, Ns: "ns.example."}}, root.sign(t, example.key.ToDS(dns.SHA256))...)
+			r.Extra = []dns.RR{&dns.A{Hdr: dns.RR_Header{Name: "ns.example.", Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 3600}, A: net.IP{10, 0, 0, 1}}}
+		case q.Qtype == dns.TypeDNSKEY:
+			r.Answer = example.sign(t, example.key)
+		default:
+			r.Authoritative = true
+			r.Answer = example.sign(t, &dns.A{Hdr: dns.RR_Header{Name: q.Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60}, A: net.IP{1, 2, 3, 4}})
+		}
+		return r, nil
+	})
+
+	if err := rr.PrimeTLDs(context.Background(), "example"); err != nil {
+		t.Fatalf("PrimeTLDs failed: %s", err)
+	}
+	addrs = nil
+	a, _, err := rr.Lookup(context.Background(), Question{Name: "a.example.", Type: dns.TypeA})
+	if err != nil {
+		t.Fatalf("Lookup failed: %s", err)
+	}
+	if !a.Authenticated {
+		t.Fatal("Answer from primed zone isn't authenticated")
+	}
+	for _, addr := range addrs {
+		if addr == rootAddr {
+			t.Fatalf("Root was queried for a name in a primed zone: %v", addrs)
+		}
+	}
+
+	// a delegation whose DS records don't validate isn't primed
+	rr.primed = new(primedZones)
+	root = newTestZone(t, ".")
+	if err := rr.PrimeTLDs(context.Background(), "example."); err == nil {
+		t.Fatal("PrimeTLDs accepted a referral signed by an untrusted key")
+	}
+	if _, _, primed := rr.primedAuthority("a.example."); primed {
+		t.Fatal("Zone was primed from an untrusted referral")
+	}
+}
@@ -0,0 +1,166 @@
+package solvere
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// ErrProviderFailed is returned by RecursiveResolver.Lookup when the DataProvider
+// of a ProviderZone fails
+var ErrProviderFailed = errors.New("solvere: Data provider failed")
+
+// DefaultProviderTTL is the TTL, in seconds, of records from a DataProvider which
+// don't have one if ProviderZone.TTL isn't set
+var DefaultProviderTTL uint32 = 60
+
+// DataProvider supplies the records of a zone answered by a RecursiveResolver
+// programmatically, such as from a service discovery backend or a database,
+// rather than from a fixed set of records like a LocalZone
+type DataProvider interface {
+	// LookupRecords returns the records of type qtype owned by name, a lower
+	// cased name in the zone the provider answers. A CNAME record may be
+	// returned instead for names which are aliases. exists is false if the name
+	// doesn't exist, rather than just not having any records of the type.
+	LookupRecords(ctx context.Context, name string, qtype uint16) (records []dns.RR, exists bool, err error)
+}
+
+// DataProviderFunc is a function implementing DataProvider
+type DataProviderFunc func(ctx context.Context, name string, qtype uint16) ([]dns.RR, bool, error)
+
+// LookupRecords calls f
+func (f DataProviderFunc) LookupRecords(ctx context.Context, name string, qtype uint16) ([]dns.RR, bool, error) {
+	return f(ctx, name, qtype)
+}
+
+// ProviderZone is a zone answered authoritatively using a DataProvider instead of
+// being resolved. The records returned by the provider are copied, records with
+// a TTL of zero are given TTL and DNSSEC records are removed, since the answers
+// can't be validated they are always insecure. Negative answers include a SOA
+// record for the zone made up by the resolver.
+type ProviderZone struct {
+	// Zone is the name of the zone apex
+	Zone     string
+	Provider DataProvider
+	// TTL is given to records without one, and is the negative caching TTL of
+	// the zone, if zero DefaultProviderTTL is used
+	TTL uint32
+}
+
+func (pz *ProviderZone) ttl() uint32 {
+	if pz.TTL > 0 {
+		return pz.TTL
+	}
+	return DefaultProviderTTL
+}
+
+// soa returns the SOA record included in answers from the zone
+func (pz *ProviderZone) soa() *dns.SOA {
+	zone := CanonicalName(pz.Zone)
+	mbox := "hostmaster." + zone
+	if zone == "." {
+		mbox = "hostmaster."
+	}
+	return &dns.SOA{
+		Hdr:     dns.RR_Header{Name: zone, Rrtype: dns.TypeSOA, Class: dns.ClassINET, Ttl: pz.ttl()},
+		Ns:      zone,
+		Mbox:    mbox,
+		Serial:  1,
+		Refresh: 3600,
+		Retry:   600,
+		Expire:  86400,
+		Minttl:  pz.ttl(),
+	}
+}
+
+// records returns copies of the records the provider returned for name and qtype,
+// without DNSSEC records and with any default fields set
+func (pz *ProviderZone) records(ctx context.Context, name string, qtype uint16) ([]dns.RR, bool, error) {
+	records, exists, err := pz.Provider.LookupRecords(ctx, name, qtype)
+	if err != nil {
+		return nil, false, fmt.Errorf("%w: %s: %w", ErrProviderFailed, pz.Zone, err)
+	}
+	var copied []dns.RR
+	for _, r := range records {
+		if typeIn(r.Header().Rrtype, dnssecTypes) && r.Header().Rrtype != qtype {
+			continue
+		}
+		r = dns.Copy(r)
+		hdr := r.Header()
+		if hdr.Name == "" {
+			hdr.Name = name
+		}
+		if hdr.Class == 0 {
+			hdr.Class = dns.ClassINET
+		}
+		if hdr.Ttl == 0 {
+			hdr.Ttl = pz.ttl()
+		}
+		copied = append(copied, r)
+	}
+	if len(copied) == 0 && qtype == dns.TypeSOA && name == CanonicalName(pz.Zone) {
+		return []dns.RR{pz.soa()}, true, nil
+	}
+	return copied, exists || len(copied) > 0, nil
+}
+
+// lookup answers q, which must be in the zone, following aliases to other names in
+// the zone
+func (pz *ProviderZone) lookup(ctx context.Context, q Question) (*Answer, error) {
+	zone := CanonicalName(pz.Zone)
+	a := &Answer{Rcode: dns.RcodeSuccess, Authoritative: true}
+	name := strings.ToLower(q.Name)
+	for i := 0; i <= MaxLocalAliases; i++ {
+		records, exists, err := pz.records(ctx, name, q.Type)
+		if err != nil {
+			return nil, err
+		}
+		if !exists {
+			// the rcode describes the last name in the alias chain (RFC 6604)
+			a.Rcode = dns.RcodeNameError
+			a.Authority = []dns.RR{pz.soa()}
+			return a, nil
+		}
+		a.Answer = append(a.Answer, records...)
+		if len(records) == 0 {
+			a.Authority = []dns.RR{pz.soa()}
+			return a, nil
+		}
+		cname, ok := records[0].(*dns.CNAME)
+		if !ok || len(records) != 1 || q.Type == dns.TypeCNAME || q.Type == dns.TypeANY {
+			return a, nil
+		}
+		// follow the alias if the target is also in the zone, otherwise the
+		// client has to chase it
+		name = strings.ToLower(cname.Target)
+		if !isSubdomain(name, zone) {
+			return a, nil
+		}
+	}
+	return a, nil
+}
+
+// providerZone returns the most specific provider zone containing name
+func (rr *RecursiveResolver) providerZone(name string) *ProviderZone {
+	var best *ProviderZone
+	for i := range rr.Providers {
+		pz := &rr.Providers[i]
+		if isSubdomain(name, CanonicalName(pz.Zone)) && (best == nil || dns.CountLabel(CanonicalName(pz.Zone)) > dns.CountLabel(CanonicalName(best.Zone))) {
+			best = pz
+		}
+	}
+	return best
+}
+
+// ProviderSource answers questions for names in RecursiveResolver.Providers
+var ProviderSource Source = SourceFunc(func(ctx context.Context, rr *RecursiveResolver, q Question) (*Answer, *LookupLog, error) {
+	pz := rr.providerZone(q.Name)
+	if pz == nil {
+		return nil, nil, nil
+	}
+	a, err := pz.lookup(ctx, q)
+	return a, nil, err
+})
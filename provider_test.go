@@ -0,0 +1,71 @@
+package solvere
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestProviderZone(t *testing.T) {
+	rr := NewResolver(WithValidation(false))
+	rr.Transport = transportFunc(func(_ context.Context, m *dns.Msg, _ string) (*dns.Msg, error) {
+		t.Fatalf("Query sent for a provided zone: %s", m.Question[0].Name)
+		return nil, nil
+	})
+	var asked []string
+	rr.Providers = []ProviderZone{{
+		Zone: "svc.cluster.local",
+		TTL:  5,
+		Provider: DataProviderFunc(func(_ context.Context, name string, qtype uint16) ([]dns.RR, bool, error) {
+			asked = append(asked, name)
+			switch name {
+			case "web.svc.cluster.local.":
+				if qtype != dns.TypeA {
+					return nil, true, nil
+				}
+				return []dns.RR{
+					&dns.A{Hdr: dns.RR_Header{Rrtype: dns.TypeA}, A: net.IP{10, 0, 0, 1}},
+					&dns.RRSIG{Hdr: dns.RR_Header{Rrtype: dns.TypeRRSIG}, TypeCovered: dns.TypeA},
+				}, true, nil
+			case "www.svc.cluster.local.":
+				return []dns.RR{&dns.CNAME{Hdr: dns.RR_Header{Rrtype: dns.TypeCNAME, Ttl: 30}, Target: "web.svc.cluster.local."}}, true, nil
+			case "broken.svc.cluster.local.":
+				return nil, false, errors.New("backend unavailable")
+			}
+			return nil, false, nil
+		}),
+	}}
+
+	a, _, err := rr.Lookup(context.Background(), Question{Name: "WWW.svc.cluster.local.", Type: dns.TypeA})
+	if err != nil {
+		t.Fatalf("Lookup failed: %s", err)
+	}
+	if len(a.Answer) != 2 || a.Answer[0].Header().Ttl != 30 || a.Answer[1].Header().Ttl != 5 || a.Answer[1].Header().Name != "web.svc.cluster.local." {
+		t.Fatalf("Unexpected answer: %v", a.Answer)
+	}
+	if !a.Authoritative || a.Authenticated {
+		t.Fatalf("Answer wasn't marked authoritative and insecure: %+v", a)
+	}
+	if len(asked) != 2 || asked[0] != "www.svc.cluster.local." {
+		t.Fatalf("Unexpected names asked of the provider: %v", asked)
+	}
+
+	a, _, err = rr.Lookup(context.Background(), Question{Name: "web.svc.cluster.local.", Type: dns.TypeAAAA})
+	if err != nil || a.Rcode != dns.RcodeSuccess || len(a.Answer) != 0 || len(a.Authority) != 1 || a.Authority[0].Header().Rrtype != dns.TypeSOA {
+		t.Fatalf("Unexpected NODATA answer: %v, %v", a, err)
+	}
+	a, _, err = rr.Lookup(context.Background(), Question{Name: "missing.svc.cluster.local.", Type: dns.TypeA})
+	if err != nil || a.Rcode != dns.RcodeNameError || len(a.Authority) != 1 || a.MinTTL().Seconds() != 5 {
+		t.Fatalf("Unexpected NXDOMAIN answer: %v, %v", a, err)
+	}
+	a, _, err = rr.Lookup(context.Background(), Question{Name: "svc.cluster.local.", Type: dns.TypeSOA})
+	if err != nil || len(a.Answer) != 1 || a.Answer[0].Header().Rrtype != dns.TypeSOA {
+		t.Fatalf("Unexpected SOA answer: %v, %v", a, err)
+	}
+	if _, _, err = rr.Lookup(context.Background(), Question{Name: "broken.svc.cluster.local.", Type: dns.TypeA}); err == nil || !errors.Is(err, ErrProviderFailed) {
+		t.Fatalf("Expected ErrProviderFailed, got %v", err)
+	}
+}
@@ -0,0 +1,193 @@
+package solvere
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/jmhodges/clock"
+)
+
+// ErrRateLimited is returned when a query to a nameserver would exceed the
+// configured rate limits
+var ErrRateLimited = errors.New("solvere: Outgoing query rate limit exceeded")
+
+// maxIdleBuckets is the number of buckets kept by a RateLimiter before buckets
+// which have refilled are discarded
+const maxIdleBuckets = 4096
+
+// RateLimiter limits the rate of queries sent to individual nameservers, and to
+// the nameservers of individual zones, using token buckets so that a burst of
+// client queries doesn't flood a single authoritative server and trip its
+// response rate limiting. Queries that would exceed a limit are delayed until
+// a token is available.
+type RateLimiter struct {
+	// PerServer is the number of queries per second that can be sent to a
+	// single nameserver address, if zero queries per server aren't limited
+	PerServer float64
+	// PerServerBurst is the number of queries that can be sent to a single
+	// nameserver address at once, if zero one is used
+	PerServerBurst int
+	// PerZone is the number of queries per second that can be sent to the
+	// nameservers of a single zone, if zero queries per zone aren't limited
+	PerZone float64
+	// PerZoneBurst is the number of queries that can be sent to the nameservers
+	// of a single zone at once, if zero one is used
+	PerZoneBurst int
+	// MaxWait is the longest a query will be delayed, queries that would need to
+	// wait longer fail with ErrRateLimited. If zero queries wait as long as their
+	// context allows.
+	MaxWait time.Duration
+
+	mu      sync.Mutex
+	servers map[string]*bucket
+	zones   map[string]*bucket
+	clk     clock.Clock
+}
+
+// NewRateLimiter returns a RateLimiter allowing perServer queries per second to each
+// nameserver address and perZone queries per second to the nameservers of each zone,
+// both with a burst of the same size as the rate
+func NewRateLimiter(perServer, perZone float64) *RateLimiter {
+	return &RateLimiter{
+		PerServer:      perServer,
+		PerServerBurst: int(perServer),
+		PerZone:        perZone,
+		PerZoneBurst:   int(perZone),
+	}
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// reserve takes a token from the bucket, refilling it at rate tokens per second up
+// to burst, and returns how long the caller must wait before the token is available
+func (b *bucket) reserve(now time.Time, rate float64, burst int) time.Duration {
+	b.refill(now, rate, burst)
+	b.tokens--
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / rate * float64(time.Second))
+}
+
+func (b *bucket) refill(now time.Time, rate float64, burst int) {
+	b.tokens += now.Sub(b.last).Seconds() * rate
+	if max := float64(burst); b.tokens > max {
+		b.tokens = max
+	}
+	b.last = now
+}
+
+func (rl *RateLimiter) now() time.Time {
+	if rl.clk == nil {
+		return time.Now()
+	}
+	return rl.clk.Now()
+}
+
+func burstOrOne(burst int) int {
+	if burst < 1 {
+		return 1
+	}
+	return burst
+}
+
+// getBucket returns the bucket for key, creating a full bucket if there isn't
+// one. Once there are too many buckets those which have completely refilled,
+// and so are equivalent to a new bucket, are discarded.
+func getBucket(buckets map[string]*bucket, key string, now time.Time, rate float64, burst int) *bucket {
+	b, present := buckets[key]
+	if present {
+		return b
+	}
+	if len(buckets) >= maxIdleBuckets {
+		for k, ob := range buckets {
+			ob.refill(now, rate, burst)
+			if ob.tokens >= float64(burst) {
+				delete(buckets, k)
+			}
+		}
+	}
+	b = &bucket{tokens: float64(burst), last: now}
+	buckets[key] = b
+	return b
+}
+
+// reserve takes a token for a query to the nameserver auth and returns how long the
+// query must wait before it can be sent. If the wait would exceed max the tokens
+// are returned and ErrRateLimited is returned.
+func (rl *RateLimiter) reserve(auth *Nameserver, max time.Duration) (time.Duration, error) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	now := rl.now()
+	var delay time.Duration
+	var reserved []*bucket
+	take := func(buckets *map[string]*bucket, key string, rate float64, burst int) {
+		if rate <= 0 {
+			return
+		}
+		if *buckets == nil {
+			*buckets = make(map[string]*bucket)
+		}
+		burst = burstOrOne(burst)
+		b := getBucket(*buckets, key, now, rate, burst)
+		if d := b.reserve(now, rate, burst); d > delay {
+			delay = d
+		}
+		reserved = append(reserved, b)
+	}
+	take(&rl.servers, auth.Addr, rl.PerServer, rl.PerServerBurst)
+	take(&rl.zones, auth.Zone, rl.PerZone, rl.PerZoneBurst)
+	if max > 0 && delay > max {
+		for _, b := range reserved {
+			b.tokens++
+		}
+		return 0, ErrRateLimited
+	}
+	return delay, nil
+}
+
+// wait blocks until a query can be sent to the nameserver auth without exceeding
+// the configured limits, it returns ErrRateLimited if the wait would exceed MaxWait
+// or the deadline of ctx
+func (rl *RateLimiter) wait(ctx context.Context, auth *Nameserver) error {
+	max := rl.MaxWait
+	if deadline, ok := ctx.Deadline(); ok {
+		if until := deadline.Sub(rl.now()); max == 0 || until < max {
+			max = until
+			if max <= 0 {
+				return ErrRateLimited
+			}
+		}
+	}
+	delay, err := rl.reserve(auth, max)
+	if err != nil || delay == 0 {
+		return err
+	}
+	t := time.NewTimer(delay)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		// the query won't be sent, so it shouldn't count against the limits
+		rl.refund(auth)
+		return ctx.Err()
+	}
+}
+
+// refund returns the tokens taken by reserve for a query to auth which wasn't sent
+func (rl *RateLimiter) refund(auth *Nameserver) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	if b, present := rl.servers[auth.Addr]; present && rl.PerServer > 0 {
+		b.tokens++
+	}
+	if b, present := rl.zones[auth.Zone]; present && rl.PerZone > 0 {
+		b.tokens++
+	}
+}
@@ -0,0 +1,90 @@
+package solvere
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/jmhodges/clock"
+	"github.com/miekg/dns"
+)
+
+func TestRateLimiterReserve(t *testing.T) {
+	fc := clock.NewFake()
+	rl := &RateLimiter{PerServer: 10, PerServerBurst: 2, PerZone: 1, PerZoneBurst: 3, MaxWait: 500 * time.Millisecond, clk: fc}
+	a := &Nameserver{Addr: "1.1.1.1", Zone: "com."}
+	b := &Nameserver{Addr: "2.2.2.2", Zone: "com."}
+
+	for i := 0; i < 2; i++ {
+		if d, err := rl.reserve(a, rl.MaxWait); err != nil || d != 0 {
+			t.Fatalf("Query %d within burst was delayed: %s %s", i, d, err)
+		}
+	}
+	d, err := rl.reserve(a, rl.MaxWait)
+	if err != nil || d != 100*time.Millisecond {
+		t.Fatalf("Query exceeding server burst wasn't delayed by 100ms: %s %v", d, err)
+	}
+	// the zone bucket is now empty so any server in the zone must wait for it
+	if _, err = rl.reserve(b, rl.MaxWait); err != ErrRateLimited {
+		t.Fatalf("Query exceeding zone limit and MaxWait wasn't refused: %v", err)
+	}
+	fc.Add(time.Second)
+	if d, err = rl.reserve(b, rl.MaxWait); err != nil || d != 0 {
+		t.Fatalf("Query after zone bucket refilled was delayed: %s %v", d, err)
+	}
+	// other zones are unaffected
+	if d, err = rl.reserve(&Nameserver{Addr: "3.3.3.3", Zone: "net."}, rl.MaxWait); err != nil || d != 0 {
+		t.Fatalf("Query to a different zone was delayed: %s %v", d, err)
+	}
+}
+
+func TestRateLimiterRefund(t *testing.T) {
+	fc := clock.NewFake()
+	rl := &RateLimiter{PerServer: 1, PerZone: 1, clk: fc}
+	a := &Nameserver{Addr: "1.1.1.1", Zone: "com."}
+	if d, err := rl.reserve(a, 0); err != nil || d != 0 {
+		t.Fatalf("First query was delayed: %s %v", d, err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := rl.wait(ctx, a); err != context.Canceled {
+		t.Fatalf("Expected wait to be cancelled, got %v", err)
+	}
+	// the cancelled query's tokens were returned, so the next one only waits
+	// for the first query's tokens to be replaced
+	if d, err := rl.reserve(a, 0); err != nil || d != time.Second {
+		t.Fatalf("Expected query after cancelled query to be delayed by 1s: %s %v", d, err)
+	}
+}
+
+func TestRateLimitedQuery(t *testing.T) {
+	rr := &RecursiveResolver{c: new(dns.Client), RateLimiter: &RateLimiter{PerServer: 1}}
+	sent := 0
+	rr.Transport = transportFunc(func(_ context.Context, m *dns.Msg, _ string) (*dns.Msg, error) {
+		sent++
+		r := new(dns.Msg)
+		r.SetReply(m)
+		r.Answer = []dns.RR{&dns.A{Hdr: dns.RR_Header{Name: m.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET}, A: net.IP{1, 2, 3, 4}}}
+		return r, nil
+	})
+	auth := &Nameserver{Zone: ".", Addr: "127.0.0.1"}
+	q := &Question{Name: "a.com.", Type: dns.TypeA}
+	if _, _, err := rr.query(context.Background(), q, auth); err != nil {
+		t.Fatalf("First query failed: %s", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, _, err := rr.query(ctx, q, auth); err != ErrRateLimited {
+		t.Fatalf("Query that couldn't get a token before its deadline didn't fail with ErrRateLimited: %v", err)
+	}
+	if sent != 1 {
+		t.Fatalf("Rate limited query was sent, %d queries sent", sent)
+	}
+}
+
+type transportFunc func(context.Context, *dns.Msg, string) (*dns.Msg, error)
+
+func (tf transportFunc) Exchange(ctx context.Context, m *dns.Msg, addr string) (*dns.Msg, error) {
+	return tf(ctx, m, addr)
+}
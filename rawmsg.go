@@ -0,0 +1,51 @@
+package solvere
+
+import (
+	"context"
+
+	"github.com/miekg/dns"
+)
+
+type responseMsgKey struct{}
+
+// WithResponseMsg returns a copy of ctx which causes lookups using it to set the
+// Msg field of the returned Answer, so frontends and debugging tools can use the
+// message that was validated instead of building one from the Answer
+func WithResponseMsg(ctx context.Context) context.Context {
+	return context.WithValue(ctx, responseMsgKey{}, true)
+}
+
+// responseMsgRequested checks if ctx was returned by WithResponseMsg
+func responseMsgRequested(ctx context.Context) bool {
+	requested, _ := ctx.Value(responseMsgKey{}).(bool)
+	return requested
+}
+
+// responseMsg returns the message describing the answer to q. The header and OPT
+// record are taken from the response a was extracted from, if there was one, and
+// the sections from a, so any records removed after validation, e.g. by
+// RebindingProtection, are also missing from the message.
+func responseMsg(q Question, a *Answer) *dns.Msg {
+	m := new(dns.Msg)
+	var opt *dns.OPT
+	if a.Msg != nil {
+		m.MsgHdr = a.Msg.MsgHdr
+		opt = a.Msg.IsEdns0()
+	}
+	m.Response = true
+	m.Rcode = a.Rcode
+	m.Authoritative = a.Authoritative
+	m.AuthenticatedData = a.Authenticated
+	m.Question = []dns.Question{{Name: q.Name, Qtype: q.Type, Qclass: dns.ClassINET}}
+	m.Answer = a.Answer
+	m.Ns = a.Authority
+	for _, r := range a.Additional {
+		if r.Header().Rrtype != dns.TypeOPT {
+			m.Extra = append(m.Extra, r)
+		}
+	}
+	if opt != nil {
+		m.Extra = append(m.Extra, opt)
+	}
+	return m
+}
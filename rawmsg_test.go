@@ -0,0 +1,59 @@
+package solvere
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestLookupResponseMsg(t *testing.T) {
+	rr := NewRecursiveResolver(false, false, nil, nil, nil)
+	rr.Forward = &ForwardConfig{Servers: []string{"10.0.0.1"}}
+	rr.Rebinding = &RebindingProtection{}
+	rr.Transport = transportFunc(func(_ context.Context, m *dns.Msg, _ string) (*dns.Msg, error) {
+		r := new(dns.Msg)
+		r.SetReply(m)
+		r.SetEdns0(1232, false)
+		r.Answer = []dns.RR{
+			&dns.A{Hdr: dns.RR_Header{Name: m.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60}, A: net.IP{1, 2, 3, 4}},
+			&dns.A{Hdr: dns.RR_Header{Name: m.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60}, A: net.IP{10, 0, 0, 1}},
+		}
+		return r, nil
+	})
+	q := Question{Name: "a.example.", Type: dns.TypeA}
+	a, _, err := rr.Lookup(context.Background(), q)
+	if err != nil {
+		t.Fatalf("Lookup failed: %s", err)
+	}
+	if a.Msg != nil {
+		t.Fatal("Msg set without WithResponseMsg")
+	}
+
+	a, _, err = rr.Lookup(WithResponseMsg(context.Background()), q)
+	if err != nil {
+		t.Fatalf("Lookup failed: %s", err)
+	}
+	if a.Msg == nil {
+		t.Fatal("Msg wasn't set")
+	}
+	if !a.Msg.Response || len(a.Msg.Question) != 1 || a.Msg.Question[0].Name != q.Name {
+		t.Fatalf("Unexpected message header or question: %s", a.Msg)
+	}
+	if len(a.Msg.Answer) != 1 || a.Msg.Answer[0] != a.Answer[0] {
+		t.Fatalf("Expected the filtered answer in the message, got %v", a.Msg.Answer)
+	}
+	if a.Msg.IsEdns0() == nil {
+		t.Fatal("OPT record from the response wasn't kept")
+	}
+
+	rr.LocalData = NewLocalData([]dns.RR{&dns.A{Hdr: dns.RR_Header{Name: "local.", Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60}, A: net.IP{1, 1, 1, 1}}})
+	a, _, err = rr.Lookup(WithResponseMsg(context.Background()), Question{Name: "local.", Type: dns.TypeA})
+	if err != nil {
+		t.Fatalf("Lookup failed: %s", err)
+	}
+	if a.Msg == nil || len(a.Msg.Answer) != 1 || a.Msg.Rcode != dns.RcodeSuccess {
+		t.Fatalf("Unexpected message for local data: %v", a.Msg)
+	}
+}
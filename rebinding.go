@@ -0,0 +1,121 @@
+package solvere
+
+import (
+	"errors"
+	"net"
+
+	"github.com/miekg/dns"
+)
+
+// ErrRebinding is returned by RecursiveResolver.Lookup when RebindingProtection is
+// configured to reject answers and an answer for an external name contains a
+// private address
+var ErrRebinding = errors.New("solvere: Answer for external name contains a private address")
+
+var privateNetworks = func() []*net.IPNet {
+	var nets []*net.IPNet
+	for _, cidr := range []string{
+		"0.0.0.0/8",      // RFC 1122 "this" network
+		"10.0.0.0/8",     // RFC 1918
+		"127.0.0.0/8",    // loopback
+		"169.254.0.0/16", // link-local
+		"172.16.0.0/12",  // RFC 1918
+		"192.168.0.0/16", // RFC 1918
+		"::/128",         // unspecified
+		"::1/128",        // loopback
+		"fc00::/7",       // unique local
+		"fe80::/10",      // link-local
+	} {
+		_, n, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		nets = append(nets, n)
+	}
+	return nets
+}()
+
+// isPrivateIP checks if ip is a RFC 1918, loopback, link-local or other address that
+// shouldn't be returned for an external name, IPv4-mapped IPv6 addresses are checked
+// as IPv4 addresses
+func isPrivateIP(ip net.IP) bool {
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+	for _, n := range privateNetworks {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// RebindingProtection protects embedders, such as proxies and browsers, from DNS
+// rebinding attacks by filtering A and AAAA records pointing at private address space
+// from the answers to questions for external names. Names are considered internal if
+// they are in one of the AllowedZones, which is checked against the name asked
+// rather than the target of any aliases so an external name can't alias its way to
+// an internal address.
+type RebindingProtection struct {
+	// AllowedZones are the internal zones whose names may resolve to private
+	// addresses
+	AllowedZones []string
+	// Reject causes Lookup to fail with ErrRebinding instead of stripping the
+	// private addresses from the answer
+	Reject bool
+}
+
+func (rp *RebindingProtection) allowed(name string) bool {
+	for _, z := range rp.AllowedZones {
+		if isSubdomain(name, dns.Fqdn(z)) {
+			return true
+		}
+	}
+	return false
+}
+
+func isPrivateAddressRecord(r dns.RR) bool {
+	switch rec := r.(type) {
+	case *dns.A:
+		return isPrivateIP(rec.A)
+	case *dns.AAAA:
+		return isPrivateIP(rec.AAAA)
+	}
+	return false
+}
+
+// filter applies the protection to the answer for q, it returns the answer that
+// should be returned to the caller which is a copy of a if any records were removed
+func (rp *RebindingProtection) filter(q Question, a *Answer) (*Answer, error) {
+	if rp.allowed(q.Name) {
+		return a, nil
+	}
+	var found bool
+	for _, section := range [][]dns.RR{a.Answer, a.Additional} {
+		for _, r := range section {
+			if isPrivateAddressRecord(r) {
+				found = true
+			}
+		}
+	}
+	if !found {
+		return a, nil
+	}
+	if rp.Reject {
+		return nil, ErrRebinding
+	}
+	filtered := *a
+	filtered.Answer = stripPrivateAddresses(a.Answer)
+	filtered.Additional = stripPrivateAddresses(a.Additional)
+	return &filtered, nil
+}
+
+func stripPrivateAddresses(section []dns.RR) []dns.RR {
+	out := make([]dns.RR, 0, len(section))
+	for _, r := range section {
+		if !isPrivateAddressRecord(r) {
+			out = append(out, r)
+		}
+	}
+	return out
+}
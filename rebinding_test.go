@@ -0,0 +1,64 @@
+package solvere
+
+import (
+	"net"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestIsPrivateIP(t *testing.T) {
+	for addr, private := range map[string]bool{
+		"10.1.2.3":        true,
+		"172.20.0.1":      true,
+		"172.32.0.1":      false,
+		"192.168.1.1":     true,
+		"127.0.0.1":       true,
+		"169.254.169.254": true,
+		"0.0.0.0":         true,
+		"::1":             true,
+		"fe80::1":         true,
+		"fd00::1":         true,
+		"::ffff:10.0.0.1": true,
+		"8.8.8.8":         false,
+		"2001:4860::8888": false,
+		"::ffff:8.8.8.8":  false,
+	} {
+		if isPrivateIP(net.ParseIP(addr)) != private {
+			t.Errorf("isPrivateIP(%s) != %t", addr, private)
+		}
+	}
+}
+
+func TestRebindingProtection(t *testing.T) {
+	private := &dns.A{Hdr: dns.RR_Header{Name: "b.com.", Rrtype: dns.TypeA}, A: net.IP{192, 168, 0, 1}}
+	public := &dns.A{Hdr: dns.RR_Header{Name: "b.com.", Rrtype: dns.TypeA}, A: net.IP{1, 2, 3, 4}}
+	alias := &dns.CNAME{Hdr: dns.RR_Header{Name: "a.com.", Rrtype: dns.TypeCNAME}, Target: "b.com."}
+	a := &Answer{Answer: []dns.RR{alias, private, public}}
+	rp := &RebindingProtection{AllowedZones: []string{"corp", "b.com."}}
+
+	// the allowlist applies to the name asked, not the alias target
+	filtered, err := rp.filter(Question{Name: "a.com.", Type: dns.TypeA}, a)
+	if err != nil {
+		t.Fatalf("filter failed: %s", err)
+	}
+	if len(filtered.Answer) != 2 || filtered.Answer[1] != public {
+		t.Fatalf("filter didn't strip the private address: %v", filtered.Answer)
+	}
+	if len(a.Answer) != 3 {
+		t.Fatal("filter modified the original answer")
+	}
+	for _, name := range []string{"b.com.", "host.CORP."} {
+		if filtered, _ := rp.filter(Question{Name: name, Type: dns.TypeA}, a); filtered != a {
+			t.Fatalf("filter modified the answer for internal name %s", name)
+		}
+	}
+
+	rp.Reject = true
+	if _, err = rp.filter(Question{Name: "a.com.", Type: dns.TypeA}, a); err != ErrRebinding {
+		t.Fatalf("filter didn't reject answer with a private address: %v", err)
+	}
+	if filtered, err = rp.filter(Question{Name: "a.com.", Type: dns.TypeA}, &Answer{Answer: []dns.RR{public}}); err != nil || len(filtered.Answer) != 1 {
+		t.Fatalf("filter rejected answer without private addresses: %v", err)
+	}
+}
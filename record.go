@@ -0,0 +1,188 @@
+package solvere
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// ErrNotRecorded is returned by ReplayTransport when a query wasn't recorded
+var ErrNotRecorded = errors.New("solvere: No recorded exchange for query")
+
+// recordedExchange is a query sent to a nameserver and the response, or error,
+// received. Messages are encoded as RFC 8427 JSON objects.
+type recordedExchange struct {
+	Time     time.Time    `json:"time"`
+	Server   string       `json:"server"`
+	Query    *jsonMessage `json:"query"`
+	Response *jsonMessage `json:"response,omitempty"`
+	Error    string       `json:"error,omitempty"`
+}
+
+// RecordingTransport is a Transport which writes every exchange with a nameserver
+// to a writer, as a JSON object per line, so lookups can later be repeated
+// without network access using a ReplayTransport. Responses received over UDP
+// which should be retried over TCP are retried by the RecordingTransport itself,
+// so only the response the resolver used is recorded.
+type RecordingTransport struct {
+	// Transport is used to send the queries
+	Transport Transport
+
+	mu  sync.Mutex
+	enc *json.Encoder
+	err error
+}
+
+// NewRecordingTransport returns a RecordingTransport which sends queries using t,
+// or a UDP ClientTransport if nil, and writes the exchanges to w
+func NewRecordingTransport(t Transport, w io.Writer) *RecordingTransport {
+	if t == nil {
+		t = NewClientTransport("udp")
+	}
+	return &RecordingTransport{Transport: t, enc: json.NewEncoder(w)}
+}
+
+// Exchange implements Transport
+func (rt *RecordingTransport) Exchange(ctx context.Context, m *dns.Msg, addr string) (*dns.Msg, error) {
+	s := time.Now()
+	r, err := rt.Transport.Exchange(ctx, m, addr)
+	if isUDPTransport(rt.Transport) {
+		r, err = retryOverTCP(ctx, m, addr, r, err)
+	}
+	e := &recordedExchange{Time: s, Server: addr, Query: newJSONMessage(m)}
+	if err != nil {
+		e.Error = err.Error()
+	} else {
+		e.Response = newJSONMessage(r)
+	}
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	if werr := rt.enc.Encode(e); werr != nil && rt.err == nil {
+		rt.err = werr
+	}
+	return r, err
+}
+
+// Err returns the first error encountered writing an exchange, if any
+func (rt *RecordingTransport) Err() error {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	return rt.err
+}
+
+// ReplayTransport is a Transport which answers queries with the responses recorded
+// by a RecordingTransport. Since the nameserver the resolver picks from a referral
+// is random a query is answered with the first unused exchange for the same question
+// sent to the same nameserver, or if there is none sent to any nameserver. Once
+// every exchange for a question has been used the last one is reused, and
+// questions which weren't recorded fail with ErrNotRecorded.
+type ReplayTransport struct {
+	mu        sync.Mutex
+	exchanges []*recordedExchange
+	used      []bool
+}
+
+// NewReplayTransport returns a ReplayTransport which replays the exchanges read
+// from r
+func NewReplayTransport(r io.Reader) (*ReplayTransport, error) {
+	rt := new(ReplayTransport)
+	dec := json.NewDecoder(bufio.NewReader(r))
+	for {
+		e := new(recordedExchange)
+		if err := dec.Decode(e); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		if e.Query == nil || e.Query.QNAME == "" || (e.Response == nil && e.Error == "") {
+			return nil, fmt.Errorf("%w: recorded exchange %d is missing its query or response", ErrMalformedJSON, len(rt.exchanges)+1)
+		}
+		rt.exchanges = append(rt.exchanges, e)
+	}
+	rt.used = make([]bool, len(rt.exchanges))
+	return rt, nil
+}
+
+// LoadReplayTransport returns a ReplayTransport which replays the exchanges in the
+// file at path
+func LoadReplayTransport(path string) (*ReplayTransport, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return NewReplayTransport(f)
+}
+
+// Recorded returns the time the first exchange was recorded, it can be passed to
+// WithValidationTime so the recorded signatures are validated as they were when
+// they were recorded
+func (rt *ReplayTransport) Recorded() time.Time {
+	if len(rt.exchanges) == 0 {
+		return time.Time{}
+	}
+	return rt.exchanges[0].Time
+}
+
+// Exchange implements Transport
+func (rt *ReplayTransport) Exchange(ctx context.Context, m *dns.Msg, addr string) (*dns.Msg, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if len(m.Question) != 1 {
+		return nil, ErrNotRecorded
+	}
+	q := m.Question[0]
+	rt.mu.Lock()
+	i := rt.match(q, addr)
+	if i >= 0 {
+		rt.used[i] = true
+	}
+	rt.mu.Unlock()
+	if i < 0 {
+		return nil, fmt.Errorf("%w: %s %s to %s", ErrNotRecorded, q.Name, dns.TypeToString[q.Qtype], addr)
+	}
+	e := rt.exchanges[i]
+	if e.Error != "" {
+		return nil, errors.New(e.Error)
+	}
+	r := e.Response.msg()
+	r.Id = m.Id
+	return r, nil
+}
+
+// match returns the index of the exchange which should be used to answer q sent
+// to addr, or -1 if there is none
+func (rt *ReplayTransport) match(q dns.Question, addr string) int {
+	exact, other, last := -1, -1, -1
+	for i, e := range rt.exchanges {
+		if !strings.EqualFold(e.Query.QNAME, q.Name) || e.Query.QTYPE != q.Qtype {
+			continue
+		}
+		last = i
+		if rt.used[i] {
+			continue
+		}
+		if e.Server == addr && exact < 0 {
+			exact = i
+		} else if other < 0 {
+			other = i
+		}
+	}
+	switch {
+	case exact >= 0:
+		return exact
+	case other >= 0:
+		return other
+	}
+	return last
+}
@@ -0,0 +1,129 @@
+package solvere
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func TestRecordReplay(t *testing.T) {
+	root, example := newTestZone(t, "."), newTestZone(t, "example.")
+	a := &dns.A{Hdr: dns.RR_Header{Name: "a.example.", Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60}, A: net.IP{1, 2, 3, 4}}
+	responses := map[uint16][]dns.RR{
+		dns.TypeA:      example.sign(t, a),
+		dns.TypeDNSKEY: example.sign(t, example.key),
+		dns.TypeDS:     root.sign(t, example.key.ToDS(dns.SHA256)),
+	}
+	buf := new(bytes.Buffer)
+	recorder := NewRecordingTransport(transportFunc(func(_ context.Context, m *dns.Msg, _ string) (*dns.Msg, error) {
+		r := new(dns.Msg)
+		r.SetReply(m)
+		r.Answer = responses[m.Question[0].Qtype]
+		return r, nil
+	}), buf)
+
+	rr := NewRecursiveResolver(false, true, nil, []dns.RR{root.key}, nil)
+	rr.Forward = &ForwardConfig{Servers: []string{"10.0.0.1"}}
+	rr.BogusTTL = -1
+	rr.Transport = recorder
+	q := Question{Name: "a.example.", Type: dns.TypeA}
+	if _, _, err := rr.Lookup(context.Background(), q); err != nil {
+		t.Fatalf("Lookup failed: %s", err)
+	}
+	if err := recorder.Err(); err != nil {
+		t.Fatalf("Failed to record exchanges: %s", err)
+	}
+	if lines := strings.Count(buf.String(), "\n"); lines != 3 {
+		t.Fatalf("Expected 3 recorded exchanges, got %d", lines)
+	}
+
+	replay, err := NewReplayTransport(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("Failed to load recording: %s", err)
+	}
+	rr.Transport = replay
+	ctx := WithValidationTime(context.Background(), replay.Recorded())
+	ans, _, err := rr.Lookup(ctx, q)
+	if err != nil {
+		t.Fatalf("Replayed lookup failed: %s", err)
+	}
+	if ips := ans.IPs(); len(ips) != 1 || !ips[0].Equal(a.A) || !ans.Authenticated {
+		t.Fatalf("Unexpected replayed answer: %+v", ans)
+	}
+
+	// the signatures have expired by the time given
+	ctx = WithValidationTime(context.Background(), replay.Recorded().Add(2*time.Hour))
+	if _, _, err = rr.Lookup(ctx, q); err == nil {
+		t.Fatal("Lookup validated expired signatures")
+	}
+
+	if _, _, err = rr.Lookup(ctx, Question{Name: "b.example.", Type: dns.TypeA}); err == nil || !errors.Is(err, ErrNotRecorded) {
+		t.Fatalf("Expected ErrNotRecorded for a question which wasn't recorded, got %v", err)
+	}
+}
+
+func TestReplayMatching(t *testing.T) {
+	buf := new(bytes.Buffer)
+	recorder := NewRecordingTransport(transportFunc(func(_ context.Context, m *dns.Msg, addr string) (*dns.Msg, error) {
+		if addr == "10.0.0.3:53" {
+			return nil, errors.New("connection refused")
+		}
+		r := new(dns.Msg)
+		r.SetReply(m)
+		r.Answer = []dns.RR{&dns.TXT{Hdr: dns.RR_Header{Name: m.Question[0].Name, Rrtype: dns.TypeTXT, Class: dns.ClassINET, Ttl: 60}, Txt: []string{addr}}}
+		return r, nil
+	}), buf)
+	m := new(dns.Msg)
+	m.SetQuestion("example.", dns.TypeTXT)
+	for _, addr := range []string{"10.0.0.1:53", "10.0.0.2:53", "10.0.0.3:53"} {
+		recorder.Exchange(context.Background(), m, addr)
+	}
+
+	replay, err := NewReplayTransport(buf)
+	if err != nil {
+		t.Fatalf("Failed to load recording: %s", err)
+	}
+	m.Id = 1234
+	m.Question[0].Name = "EXAMPLE."
+	for _, tc := range []struct {
+		addr     string
+		expected string
+	}{
+		// the exchange with the same server is preferred
+		{"10.0.0.2:53", "10.0.0.2:53"},
+		// then the first unused exchange with any server
+		{"10.0.0.4:53", "10.0.0.1:53"},
+		// and once they have all been used the last is reused
+		{"10.0.0.4:53", "10.0.0.3:53"},
+		{"10.0.0.1:53", "10.0.0.3:53"},
+	} {
+		r, err := replay.Exchange(context.Background(), m, tc.addr)
+		if tc.expected == "10.0.0.3:53" {
+			if err == nil || err.Error() != "connection refused" {
+				t.Fatalf("Expected the recorded error, got %v", err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("Exchange with %s failed: %s", tc.addr, err)
+		}
+		if r.Id != m.Id || r.Answer[0].(*dns.TXT).Txt[0] != tc.expected {
+			t.Fatalf("Expected the exchange with %s for %s, got %s", tc.expected, tc.addr, r)
+		}
+	}
+
+	m.Question[0].Qtype = dns.TypeA
+	if _, err = replay.Exchange(context.Background(), m, "10.0.0.1:53"); err == nil || !errors.Is(err, ErrNotRecorded) {
+		t.Fatalf("Expected ErrNotRecorded, got %v", err)
+	}
+
+	if _, err = NewReplayTransport(strings.NewReader(`{"server":"10.0.0.1:53"}`)); err == nil {
+		t.Fatal("Loaded a recording missing its query")
+	}
+}
@@ -0,0 +1,162 @@
+package solvere
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/jmhodges/clock"
+	"github.com/miekg/dns"
+)
+
+var (
+	// ReputationHalfLife is the amount of time after which the penalties a
+	// nameserver has accrued are halved, so servers which stop misbehaving
+	// recover
+	ReputationHalfLife = 5 * time.Minute
+	// ReputationThreshold is the score above which a nameserver is avoided when
+	// another nameserver of the zone has a lower score
+	ReputationThreshold = 3.0
+	// MaxReputationEntries is the maximum number of nameservers whose reputation
+	// is remembered
+	MaxReputationEntries = 10000
+)
+
+// Penalties added to the score of a nameserver for each kind of failure, lameness
+// and bogus answers are weighted more heavily than timeouts and SERVFAILs since
+// they are less likely to be transient
+const (
+	timeoutPenalty    = 1.0
+	serverFailPenalty = 1.0
+	lamePenalty       = 2.0
+	bogusPenalty      = 2.0
+)
+
+type reputationEntry struct {
+	score   float64
+	updated time.Time
+}
+
+// reputationCache tracks how badly nameservers, keyed by address, have behaved.
+// Each failure adds a penalty to the score of the nameserver, which decays
+// exponentially with a half life of ReputationHalfLife. Nameservers whose score
+// is above ReputationThreshold aren't picked while the zone has nameservers with
+// lower scores, once enough time has passed they are picked again.
+type reputationCache struct {
+	mu      sync.Mutex
+	entries map[string]reputationEntry
+	clk     clock.Clock
+}
+
+func newReputationCache() *reputationCache {
+	return &reputationCache{entries: make(map[string]reputationEntry), clk: clock.Default()}
+}
+
+// decayed returns the score of e at now
+func (e reputationEntry) decayed(now time.Time) float64 {
+	if ReputationHalfLife <= 0 {
+		return 0
+	}
+	return e.score * math.Exp2(-float64(now.Sub(e.updated))/float64(ReputationHalfLife))
+}
+
+// penalize adds penalty to the score of addr
+func (rc *reputationCache) penalize(addr string, penalty float64) {
+	if rc == nil {
+		return
+	}
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	now := rc.clk.Now()
+	e, present := rc.entries[addr]
+	if !present && !rc.makeRoom(now) {
+		return
+	}
+	rc.entries[addr] = reputationEntry{score: e.decayed(now) + penalty, updated: now}
+}
+
+// score returns the current score of addr, nameservers without any recent
+// failures have a score of zero
+func (rc *reputationCache) score(addr string) float64 {
+	if rc == nil {
+		return 0
+	}
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	e, present := rc.entries[addr]
+	if !present {
+		return 0
+	}
+	return e.decayed(rc.clk.Now())
+}
+
+// reputable returns the indexes of the addresses out of n which should be picked
+// from, those with a score at or below ReputationThreshold, or if there aren't any
+// those with the lowest score
+func (rc *reputationCache) reputable(n int, addr func(int) string) []int {
+	indexes := make([]int, 0, n)
+	lowest := math.Inf(1)
+	var best []int
+	for i := 0; i < n; i++ {
+		score := rc.score(addr(i))
+		if score <= ReputationThreshold {
+			indexes = append(indexes, i)
+			continue
+		}
+		if score < lowest {
+			lowest, best = score, best[:0]
+		}
+		if score == lowest {
+			best = append(best, i)
+		}
+	}
+	if len(indexes) == 0 {
+		return best
+	}
+	return indexes
+}
+
+// order returns addrs with the addresses which would be avoided by reputable moved
+// to the back, keeping their relative order
+func (rc *reputationCache) order(addrs []string) []string {
+	ordered := make([]string, 0, len(addrs))
+	var avoided []string
+	for _, addr := range addrs {
+		if rc.score(addr) <= ReputationThreshold {
+			ordered = append(ordered, addr)
+		} else {
+			avoided = append(avoided, addr)
+		}
+	}
+	return append(ordered, avoided...)
+}
+
+// makeRoom removes the entries whose score has decayed to almost nothing if there
+// are MaxReputationEntries entries and returns false if there is still no room for
+// another. It must be called with rc.mu held.
+func (rc *reputationCache) makeRoom(now time.Time) bool {
+	if len(rc.entries) < MaxReputationEntries {
+		return true
+	}
+	for addr, e := range rc.entries {
+		if e.decayed(now) < timeoutPenalty/8 {
+			delete(rc.entries, addr)
+		}
+	}
+	return len(rc.entries) < MaxReputationEntries
+}
+
+// observeNameserver updates the reputation of auth with the outcome of a query
+// sent to it, err is the error returned by the transport. Timeouts and other
+// network errors, SERVFAILs, and authoritative nameservers refusing queries for
+// zones they should serve are penalized.
+func (rr *RecursiveResolver) observeNameserver(auth *Nameserver, r *dns.Msg, err error) {
+	switch {
+	case err != nil:
+		rr.reputation.penalize(auth.Addr, timeoutPenalty)
+	case r.Rcode == dns.RcodeServerFailure:
+		rr.reputation.penalize(auth.Addr, serverFailPenalty)
+	case r.Rcode == dns.RcodeRefused && !auth.Forwarder:
+		rr.reputation.penalize(auth.Addr, lamePenalty)
+	}
+}
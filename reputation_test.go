@@ -0,0 +1,108 @@
+package solvere
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/jmhodges/clock"
+	"github.com/miekg/dns"
+)
+
+func TestReputationDecay(t *testing.T) {
+	fc := clock.NewFake()
+	fc.Add(time.Hour)
+	rc := newReputationCache()
+	rc.clk = fc
+
+	if score := rc.score("192.0.2.1"); score != 0 {
+		t.Fatalf("Expected unknown nameserver to have a score of 0, got %f", score)
+	}
+	for i := 0; i < 4; i++ {
+		rc.penalize("192.0.2.1", timeoutPenalty)
+	}
+	rc.penalize("192.0.2.2", lamePenalty)
+	addrs := []string{"192.0.2.1", "192.0.2.2", "192.0.2.3"}
+	if order := rc.order(addrs); order[0] != "192.0.2.2" || order[1] != "192.0.2.3" || order[2] != "192.0.2.1" {
+		t.Fatalf("Expected penalized nameserver to be ordered last, got %v", order)
+	}
+	if indexes := rc.reputable(len(addrs), func(i int) string { return addrs[i] }); len(indexes) != 2 || indexes[0] != 1 || indexes[1] != 2 {
+		t.Fatalf("Expected penalized nameserver to be avoided, got %v", indexes)
+	}
+	// if every nameserver is avoided the least bad ones are used
+	rc.penalize("192.0.2.2", lamePenalty)
+	for i := 0; i < 3; i++ {
+		rc.penalize("192.0.2.3", lamePenalty)
+	}
+	if indexes := rc.reputable(len(addrs), func(i int) string { return addrs[i] }); len(indexes) != 2 || indexes[0] != 0 || indexes[1] != 1 {
+		t.Fatalf("Expected least penalized nameservers, got %v", indexes)
+	}
+
+	fc.Add(ReputationHalfLife)
+	if score := rc.score("192.0.2.1"); score < 1.99 || score > 2.01 {
+		t.Fatalf("Expected score to halve after the half life, got %f", score)
+	}
+	if indexes := rc.reputable(len(addrs), func(i int) string { return addrs[i] }); len(indexes) != 3 {
+		t.Fatalf("Expected every nameserver to have recovered, got %v", indexes)
+	}
+	// penalties are added to the decayed score
+	rc.penalize("192.0.2.1", bogusPenalty)
+	if score := rc.score("192.0.2.1"); score < 3.99 || score > 4.01 {
+		t.Fatalf("Expected penalty to be added to the decayed score, got %f", score)
+	}
+}
+
+func TestPickNameserverReputation(t *testing.T) {
+	fc := clock.NewFake()
+	rr := NewResolver(WithCache(nil))
+	rr.reputation.clk = fc
+	servers := []Nameserver{{Addr: "192.0.2.1"}, {Addr: "192.0.2.2"}}
+	for i := 0; i < 4; i++ {
+		rr.reputation.penalize("192.0.2.1", serverFailPenalty)
+	}
+	for i := 0; i < 50; i++ {
+		if ns := rr.pickNameserver(servers); ns.Addr != "192.0.2.2" {
+			t.Fatalf("Picked nameserver with a poor reputation %s", ns.Addr)
+		}
+	}
+	fc.Add(2 * ReputationHalfLife)
+	picked := map[string]bool{}
+	for i := 0; i < 100; i++ {
+		picked[rr.pickNameserver(servers).Addr] = true
+	}
+	if !picked["192.0.2.1"] {
+		t.Fatal("Nameserver wasn't picked again once its score decayed")
+	}
+}
+
+func TestObserveNameserver(t *testing.T) {
+	rr := NewResolver(WithCache(nil), WithValidation(false))
+	rcode := dns.RcodeServerFailure
+	rr.Transport = transportFunc(func(_ context.Context, m *dns.Msg, addr string) (*dns.Msg, error) {
+		if addr == net.JoinHostPort("192.0.2.3", dnsPort) {
+			return nil, errors.New("unreachable")
+		}
+		r := new(dns.Msg)
+		r.SetRcode(m, rcode)
+		return r, nil
+	})
+	q := &Question{Name: "example.", Type: dns.TypeA}
+	for _, tc := range []struct {
+		addr     string
+		rcode    int
+		expected float64
+	}{
+		{"192.0.2.1", dns.RcodeServerFailure, serverFailPenalty},
+		{"192.0.2.2", dns.RcodeRefused, lamePenalty},
+		{"192.0.2.3", dns.RcodeSuccess, timeoutPenalty},
+		{"192.0.2.4", dns.RcodeNameError, 0},
+	} {
+		rcode = tc.rcode
+		rr.query(context.Background(), q, &Nameserver{Name: tc.addr, Addr: tc.addr, Zone: "."})
+		if score := rr.reputation.score(tc.addr); score < tc.expected-0.01 || score > tc.expected+0.01 {
+			t.Errorf("%s: expected score %f, got %f", tc.addr, tc.expected, score)
+		}
+	}
+}
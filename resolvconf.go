@@ -0,0 +1,143 @@
+package solvere
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// DefaultResolvConfPath is the location of the system resolver configuration
+const DefaultResolvConfPath = "/etc/resolv.conf"
+
+// ResolvConf contains the parts of a resolv.conf(5) file used to configure
+// forwarding. Unknown directives and options are ignored.
+type ResolvConf struct {
+	// Nameservers are the host:port addresses of the configured nameservers
+	Nameservers []string
+	// Search is the list of domains searched for names with fewer than Ndots
+	// dots
+	Search []string
+	// Ndots is the number of dots a name must contain before it is tried as
+	// an absolute name first
+	Ndots int
+	// Timeout is the amount of time to wait for a response from a nameserver
+	Timeout time.Duration
+	// Attempts is the number of times the list of nameservers is tried
+	Attempts int
+	// Rotate causes queries to be spread across the nameservers
+	Rotate bool
+	// Options contains any options not listed above, such as edns0 or trust-ad
+	Options []string
+}
+
+// ParseResolvConf parses a resolv.conf(5) formatted configuration. The defaults
+// used are the same as the system resolver, if no nameservers are listed the
+// local nameserver is used.
+func ParseResolvConf(r io.Reader) (*ResolvConf, error) {
+	rc := &ResolvConf{Ndots: 1, Timeout: DefaultForwardTimeout, Attempts: DefaultForwardAttempts}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.IndexAny(line, "#;"); i >= 0 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		switch fields[0] {
+		case "nameserver":
+			// addresses may have a zone, i.e. fe80::1%eth0
+			if ip := net.ParseIP(strings.SplitN(fields[1], "%", 2)[0]); ip != nil {
+				rc.Nameservers = append(rc.Nameservers, net.JoinHostPort(fields[1], "53"))
+			}
+		case "domain":
+			rc.Search = []string{dns.Fqdn(fields[1])}
+		case "search":
+			// the last search or domain directive wins
+			rc.Search = nil
+			for _, d := range fields[1:] {
+				rc.Search = append(rc.Search, dns.Fqdn(d))
+			}
+		case "options":
+			for _, o := range fields[1:] {
+				rc.parseOption(o)
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(rc.Nameservers) == 0 {
+		rc.Nameservers = []string{net.JoinHostPort("127.0.0.1", "53"), net.JoinHostPort("::1", "53")}
+	}
+	return rc, nil
+}
+
+func (rc *ResolvConf) parseOption(o string) {
+	name, value := o, ""
+	if i := strings.Index(o, ":"); i >= 0 {
+		name, value = o[:i], o[i+1:]
+	}
+	n, err := strconv.Atoi(value)
+	switch {
+	case name == "ndots" && err == nil && n >= 0:
+		if n > 15 {
+			n = 15
+		}
+		rc.Ndots = n
+	case name == "timeout" && err == nil && n > 0:
+		rc.Timeout = time.Duration(n) * time.Second
+	case name == "attempts" && err == nil && n > 0:
+		rc.Attempts = n
+	case name == "rotate":
+		rc.Rotate = true
+	default:
+		rc.Options = append(rc.Options, o)
+	}
+}
+
+// LoadResolvConf reads and parses the resolv.conf(5) file at path
+func LoadResolvConf(path string) (*ResolvConf, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ParseResolvConf(f)
+}
+
+// ForwardConfig returns the forwarding configuration described by rc
+func (rc *ResolvConf) ForwardConfig() *ForwardConfig {
+	return &ForwardConfig{
+		Servers:  rc.Nameservers,
+		Timeout:  rc.Timeout,
+		Attempts: rc.Attempts,
+		Rotate:   rc.Rotate,
+	}
+}
+
+// NameList returns the fully qualified names that should be tried, in order, when
+// looking up name using the search list and ndots setting. Names ending in a dot
+// are already fully qualified and are returned as is.
+func (rc *ResolvConf) NameList(name string) []string {
+	return searchNames(name, rc.Search, rc.Ndots)
+}
+
+// UseResolvConf configures rr to forward queries to the nameservers listed in the
+// resolv.conf(5) file at path while still performing its own validation, so the
+// resolver behaves like the system resolver. It must be called before rr is used.
+func (rr *RecursiveResolver) UseResolvConf(path string) error {
+	rc, err := LoadResolvConf(path)
+	if err != nil {
+		return err
+	}
+	rr.Forward = rc.ForwardConfig()
+	return nil
+}
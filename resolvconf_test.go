@@ -0,0 +1,62 @@
+package solvere
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseResolvConf(t *testing.T) {
+	rc, err := ParseResolvConf(strings.NewReader(`# generated
+nameserver 192.0.2.1
+nameserver 2001:db8::1 ; comment
+nameserver not-an-ip
+domain ignored.example
+search a.example b.example.
+options ndots:2 timeout:3 attempts:4 rotate edns0
+`))
+	if err != nil {
+		t.Fatalf("ParseResolvConf failed: %s", err)
+	}
+	expected := &ResolvConf{
+		Nameservers: []string{"192.0.2.1:53", "[2001:db8::1]:53"},
+		Search:      []string{"a.example.", "b.example."},
+		Ndots:       2,
+		Timeout:     3 * time.Second,
+		Attempts:    4,
+		Rotate:      true,
+		Options:     []string{"edns0"},
+	}
+	if !reflect.DeepEqual(rc, expected) {
+		t.Fatalf("Unexpected config: %#v", rc)
+	}
+	fc := rc.ForwardConfig()
+	if fc.Timeout != 3*time.Second || fc.Attempts != 4 || !fc.Rotate || len(fc.Servers) != 2 {
+		t.Fatalf("Unexpected forward config: %#v", fc)
+	}
+
+	rc, err = ParseResolvConf(strings.NewReader(""))
+	if err != nil {
+		t.Fatalf("ParseResolvConf failed: %s", err)
+	}
+	if rc.Ndots != 1 || rc.Attempts != DefaultForwardAttempts || len(rc.Nameservers) != 2 {
+		t.Fatalf("Unexpected defaults: %#v", rc)
+	}
+}
+
+func TestResolvConfNameList(t *testing.T) {
+	rc := &ResolvConf{Ndots: 1, Search: []string{"a.example.", "b.example."}}
+	for _, tc := range []struct {
+		name     string
+		expected []string
+	}{
+		{"host.", []string{"host."}},
+		{"host", []string{"host.a.example.", "host.b.example.", "host."}},
+		{"host.sub", []string{"host.sub.", "host.sub.a.example.", "host.sub.b.example."}},
+	} {
+		if names := rc.NameList(tc.name); !reflect.DeepEqual(names, tc.expected) {
+			t.Errorf("NameList(%q): expected %v, got %v", tc.name, tc.expected, names)
+		}
+	}
+}
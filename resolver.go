@@ -10,9 +10,10 @@ import (
 	"errors"
 	"fmt"
 	mrand "math/rand"
-	"net"
 	"os"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/miekg/dns"
@@ -44,7 +45,13 @@ var (
 	ErrTooManyReferrals   = errors.New("solvere: Too many referrals")
 	ErrNoNSAuthorties     = errors.New("solvere: No NS authority records found")
 	ErrNoAuthorityAddress = errors.New("solvere: No A/AAAA records found for the chosen authority")
-	ErrOutOfBailiwick     = errors.New("Out of bailiwick record in message")
+	// Deprecated: out of bailiwick records are now removed from responses
+	// instead of causing the query to fail so this is no longer returned
+	ErrOutOfBailiwick     = errors.New("solvere: Out of bailiwick record in message")
+	ErrAuthorityLookup    = errors.New("solvere: Authority address lookup failed")
+	ErrAliasLoop          = errors.New("solvere: Alias loop detected")
+	ErrUnsignedDelegation = errors.New("solvere: Unsigned delegation in signed zone without NSEC records")
+	ErrInvalidReferral    = errors.New("solvere: Referral is not to a zone between the current zone and the question")
 )
 
 // Question represents a DNS IN question
@@ -55,17 +62,32 @@ type Question struct {
 
 // LookupLog describes how a resolution was performed
 type LookupLog struct {
-	Query       *Question
-	Rcode       int
-	CacheHit    bool `json:",omitempty"`
+	Query    *Question
+	Rcode    int
+	CacheHit bool `json:",omitempty"`
+	// Cache is the cache the step was answered from if CacheHit is set
+	Cache       CacheKind `json:",omitempty"`
 	DNSSECValid bool
 	Latency     time.Duration
 	Error       string `json:",omitempty"`
 	Truncated   bool   `json:",omitempty"`
 	Referral    bool   `json:",omitempty"`
-	Started     time.Time
+	// Degraded is set if the query was sent to a nameserver of the parent zone
+	// because every nameserver of the zone it belongs to failed to respond
+	Degraded bool `json:",omitempty"`
+	// Scrubbed is the number of out of bailiwick or irrelevant records removed
+	// from the response
+	Scrubbed int `json:",omitempty"`
+	// DelegationPath is set if the step failed because looking up the address of
+	// a nameserver would loop or nest too deeply, it contains the nameservers
+	// whose addresses were being looked up
+	DelegationPath []DelegationStep `json:",omitempty"`
+	Started        time.Time
 
-	NS *Nameserver `json:",omitempty"`
+	NS      *Nameserver `json:",omitempty"`
+	Timings *Timings    `json:",omitempty"`
+
+	ExtendedErrors []ExtendedError `json:",omitempty"`
 
 	Composites []*LookupLog `json:",omitempty"`
 }
@@ -78,6 +100,16 @@ func newLookupLog(q *Question, ns *Nameserver) *LookupLog {
 	}
 }
 
+// newNegativeCacheLog returns the LookupLog for a question answered from the
+// caches of recent resolution and validation failures
+func newNegativeCacheLog(q *Question) *LookupLog {
+	ll := newLookupLog(q, nil)
+	ll.CacheHit = true
+	ll.Cache = CacheNegative
+	ll.Latency = time.Since(ll.Started)
+	return ll
+}
+
 // Answer contains the answer to a iterative resolution performed
 // by RecursiveResolver.Lookup
 type Answer struct {
@@ -86,17 +118,187 @@ type Answer struct {
 	Additional    []dns.RR
 	Rcode         int
 	Authenticated bool
+	// Authoritative is set if the answer came from a LocalZone
+	Authoritative bool
+	// ExtendedErrors contains any Extended DNS Errors (RFC 8914) included in
+	// the upstream response the answer was extracted from
+	ExtendedErrors []ExtendedError
+	// Msg is the response message describing the answer, it is only set for
+	// lookups using a context returned by WithResponseMsg
+	Msg *dns.Msg
+	// EDNSOptions contains the EDNS options included in the upstream response
+	// the answer was extracted from, it is only set for lookups using a
+	// context returned by WithEDNSOptions
+	EDNSOptions []dns.EDNS0
+	// Expires is the time at which a answer returned from a cache stops being
+	// fresh, the TTLs of its records have already been reduced by the time it
+	// was cached for. It is zero for answers which weren't cached, or are cached
+	// forever.
+	Expires time.Time
+	// Failures describes the parts of the answer that couldn't be resolved or
+	// validated, it is only set for lookups using a context returned by
+	// WithPartialResults
+	Failures []PartialFailure
 }
 
-// Nameserver describes an authoritative nameserver
+// Nameserver describes an authoritative nameserver, or a forwarder
 type Nameserver struct {
 	Name string
 	Addr string
 	Zone string
+	// Forwarder is set if the nameserver is a recursive resolver, queries sent
+	// to it have the RD bit set
+	Forwarder bool
+	// TSIG, if set, is the key queries sent to the nameserver are signed with,
+	// responses which aren't signed with it are rejected
+	TSIG *TSIGKey
 }
 
-// RecursiveResolver defines the parameters for running a recursive resolver
+// RecursiveResolver defines the parameters for running a recursive resolver. The
+// exported fields may be used to change the behavior of the resolver but must not
+// be modified once it is in use.
 type RecursiveResolver struct {
+	// stats is shared with the views returned by With
+	stats *resolverStats
+
+	// Transport is used to exchange messages with remote nameservers, if nil
+	// a dns.Client using UDP is used
+	Transport Transport
+	// RateLimiter, if not nil, limits the rate of queries sent to remote
+	// nameservers
+	RateLimiter *RateLimiter
+	// Damping, if not nil, throttles queries to the nameservers of zones whose
+	// lookups are failing at a high rate, such as during a random subdomain
+	// attack
+	Damping *SubdomainDamping
+	// Limiter, if not nil, caps the number of concurrent resolutions performed
+	// by Lookup
+	Limiter *ConcurrencyLimiter
+	// Rebinding, if not nil, filters private addresses from the answers for
+	// external names
+	Rebinding *RebindingProtection
+	// UDPSize is the EDNS buffer size advertised in queries, responses that
+	// don't fit are truncated and retried over TCP. If zero DefaultEDNSBufferSize
+	// is used. Queries sent over UDP to nameservers which repeatedly time out
+	// are retried with a reduced size, see EDNSTimeoutThreshold.
+	UDPSize uint16
+	// Forward, if not nil, causes all queries to be sent to a set of upstream
+	// recursive resolvers instead of iterating from the root nameservers
+	Forward *ForwardConfig
+	// ForwardZones maps zone names to the forwarders queries for names in
+	// those zones are sent to, the most specific zone is used. Names outside
+	// of all the zones are resolved using Forward, or by iterating. Zone names
+	// must be lower cased and fully qualified.
+	ForwardZones map[string]*ForwardConfig
+	// StubZones maps zone names to the addresses of their authoritative
+	// nameservers, iteration for names in those zones starts at them rather
+	// than the root. Since the chain of trust isn't followed to these servers
+	// their answers aren't validated. Zone names must be lower cased and fully
+	// qualified.
+	StubZones map[string][]string
+	// Rewrites map the names asked in Lookup to other names before they are
+	// resolved, the most specific one matching a name is applied. Names are
+	// rewritten after the OnQuery hooks are called, and LocalData and
+	// LocalZones are checked for the rewritten names.
+	Rewrites []NameRewrite
+	// Sources are the stages Lookup passes questions through until one of
+	// them answers, if nil DefaultSources is used. The OnQuery hooks and
+	// Rewrites are applied before any of them.
+	Sources []Source
+	// LocalData, if not nil, is used to answer queries for the names it
+	// contains before any resolution is attempted
+	LocalData *LocalData
+	// LocalZones are answered authoritatively instead of being resolved, the
+	// most specific zone containing a name is used
+	LocalZones []*LocalZone
+	// Providers are zones answered authoritatively using records supplied by
+	// embedders, the most specific zone containing a name is used. Local zones
+	// are checked first.
+	Providers []ProviderZone
+	// SpecialUseDomains maps the lower cased, fully qualified, names of
+	// special-use domains to how the names in them are answered, the most
+	// specific domain is used. If nil DefaultSpecialUseDomains is used, if
+	// empty every name is resolved.
+	SpecialUseDomains map[string]SpecialUse
+	// BogusTTL is the amount of time a question whose answer failed validation
+	// is remembered for, during which Lookup fails with the same error without
+	// resolving it again. If zero DefaultBogusTTL is used, if negative failures
+	// aren't remembered.
+	BogusTTL time.Duration
+	// FailureTTL is the amount of time a question that couldn't be resolved,
+	// because its servers were unreachable or responded with SERVFAIL or
+	// REFUSED, is remembered for, during which Lookup returns the same result
+	// without resolving it again. The time is doubled for each consecutive
+	// failure and is bounded by MinFailureTTL and MaxFailureTTL. If zero
+	// DefaultFailureTTL is used, if negative failures aren't remembered.
+	FailureTTL time.Duration
+	// AddressOrder controls how the records in A and AAAA RRSets served from
+	// the cache are ordered, so clients that only use the first address spread
+	// their load across the set
+	AddressOrder AddressOrder
+	// AddressFamily controls which address is queried when the nameservers of a
+	// zone have both IPv4 and IPv6 addresses
+	AddressFamily AddressFamilyPolicy
+	// MinimalResponses causes the authority and additional sections to be
+	// removed from answers returned by Lookup, except for the records needed
+	// to cache and validate negative answers
+	MinimalResponses bool
+	// AllowPrivateNameservers are the zones whose delegations may be to
+	// nameservers with loopback, link-local, or RFC 1918 addresses, "." allows
+	// every zone. Delegations of other zones to those addresses aren't followed
+	// so public zones can't direct queries at internal services. Forwarders,
+	// stub zones, and root hints aren't affected.
+	AllowPrivateNameservers []string
+	// ResponseLimits bounds the number of records in, and the length of the names
+	// in, the responses from remote nameservers, responses exceeding it are
+	// discarded
+	ResponseLimits ResponseLimits
+	// PermissiveResponses causes structurally invalid responses, such as ones
+	// containing a CNAME record alongside other data for the same name, to be
+	// used rather than rejected, for servers that are broken but common.
+	// Irrelevant and out of bailiwick records are still removed.
+	PermissiveResponses bool
+	// ParentFallback causes a query to be retried against the nameservers of
+	// the parent zone, which may answer from glue or with a referral, when
+	// every nameserver of the zone it belongs to fails to respond. Referrals
+	// received this way are returned as the answer and the LookupLog is marked
+	// as degraded. Queries for names in signed zones are never retried since
+	// the parent's responses couldn't be validated.
+	ParentFallback bool
+	// LazyValidation causes answers that need to be validated to be returned
+	// as soon as they are resolved, without being validated or cached, while
+	// they are resolved and validated again in the background. Once validated
+	// the answer is cached with its security status, and if it is bogus the
+	// failure is remembered, see BogusTTL, and the OnBogus hooks are called.
+	// Answers returned before being validated never have Authenticated set.
+	// This trades the protection of validation for latency and should only be
+	// used by applications that can tolerate acting on forged answers.
+	LazyValidation bool
+	// MaxMemory, if positive, is the approximate number of bytes the caches
+	// and lookups in progress may use. Once exceeded answers are evicted from
+	// the cache, if it implements MemoryCache, and remembered failures are
+	// forgotten, in proportion to the memory each uses, until the usage is
+	// MemoryHeadroom below the limit. The usage is reported by Stats.
+	MaxMemory int64
+	// QueryTimeout, if positive, is the maximum amount of time to wait for a
+	// response to each query sent to a remote nameserver, and LookupTimeout
+	// the maximum amount of time a call to Lookup may take. Both only shorten
+	// the deadline of the context passed to Lookup.
+	QueryTimeout  time.Duration
+	LookupTimeout time.Duration
+	// Logger, if not nil, receives diagnostics about resolutions, such as
+	// queries to remote nameservers failing or zones failing validation
+	Logger Logger
+	// SlowQueryLog, if not nil, is passed every lookup, successful or not, that
+	// takes at least SlowQueryThreshold, measured from when Lookup is called,
+	// along with its LookupLog
+	SlowQueryLog       SlowQueryLogger
+	SlowQueryThreshold time.Duration
+	// StrictIDNA causes Unicode names passed to Lookup to be rejected if they
+	// contain characters disallowed by IDNA2008, rather than being mapped to
+	// lower case before being converted to A-labels
+	StrictIDNA bool
+
 	useIPv6   bool
 	useDNSSEC bool
 
@@ -104,86 +306,249 @@ type RecursiveResolver struct {
 
 	cache           QuestionAnswerCache
 	rootNameservers []Nameserver
+	rootKeys        []dns.RR
+	zoneStatus      *zoneStatusCache
+	failures        *failureCache
+	infra           *infraCache
+	reputation      *reputationCache
+	primed          *primedZones
+	// lazy contains the questions being validated in the background
+	lazy *sync.Map
+	// rootHints are the records rootNameservers were taken from
+	rootHints []dns.RR
+	// skipValidation is set for views returned by With which don't validate
+	// even though the resolver they were created from does
+	skipValidation bool
+
+	hooks []Hooks
 }
 
 // NewRecursiveResolver returns an initialized RecursiveResolver. If cache is nil
 // answers won't be cached.
+//
+// Deprecated: use NewResolver, which NewRecursiveResolver is equivalent to when
+// passed WithIPv6, WithValidation, WithRootHints, WithTrustAnchors, and WithCache.
 func NewRecursiveResolver(useIPv6 bool, useDNSSEC bool, rootHints []dns.RR, rootKeys []dns.RR, cache QuestionAnswerCache) *RecursiveResolver {
+	return NewResolver(
+		WithIPv6(useIPv6),
+		WithRootHints(rootHints),
+		WithTrustAnchors(rootKeys),
+		WithValidation(useDNSSEC),
+		WithCache(cache),
+	)
+}
+
+func newResolver(o *options) *RecursiveResolver {
 	rr := &RecursiveResolver{
-		useIPv6:   useIPv6,
-		useDNSSEC: useDNSSEC,
-		c:         new(dns.Client),
-		cache:     cache,
+		useIPv6:    o.useIPv6,
+		useDNSSEC:  o.useDNSSEC,
+		c:          new(dns.Client),
+		cache:      o.cache,
+		rootKeys:   o.rootKeys,
+		zoneStatus: newZoneStatusCache(),
+		failures:   newFailureCache(),
+		infra:      newInfraCache(),
+		reputation: newReputationCache(),
+		stats:      new(resolverStats),
+		primed:     new(primedZones),
+		lazy:       new(sync.Map),
 	}
-	// Initialize root nameservers
+	rr.setRootHints(o.rootHints)
+	rr.addTrustAnchors()
+	return rr
+}
+
+// setRootHints initializes the root nameservers from the NS and A/AAAA records in
+// rootHints
+func (rr *RecursiveResolver) setRootHints(rootHints []dns.RR) {
+	rr.rootHints = rootHints
+	rr.rootNameservers = nil
 	addrs := extractRRSet(rootHints, "", dns.TypeA)
-	if useIPv6 {
+	if rr.useIPv6 {
 		addrs = append(addrs, extractRRSet(rootHints, "", dns.TypeAAAA)...)
 	}
 	for _, a := range addrs {
 		switch r := a.(type) {
 		case *dns.A:
-			rr.rootNameservers = append(rr.rootNameservers, Nameserver{a.Header().Name, r.A.String(), "."})
+			rr.rootNameservers = append(rr.rootNameservers, Nameserver{Name: a.Header().Name, Addr: r.A.String(), Zone: "."})
 		case *dns.AAAA:
-			rr.rootNameservers = append(rr.rootNameservers, Nameserver{a.Header().Name, r.AAAA.String(), "."})
+			rr.rootNameservers = append(rr.rootNameservers, Nameserver{Name: a.Header().Name, Addr: r.AAAA.String(), Zone: "."})
 		}
 	}
-	// Add root DNSSEC keys to cache indefinitely
+}
+
+// addTrustAnchors adds the root DNSSEC keys to the cache indefinitely
+func (rr *RecursiveResolver) addTrustAnchors() {
 	// XXX: if these keys are expired (how to tell?) should block on fetching
 	//      new ones + verifying the roll-over
 	if rr.cache != nil {
-		rr.cache.Add(&Question{Name: ".", Type: dns.TypeDNSKEY}, &Answer{rootKeys, nil, nil, dns.RcodeSuccess, true}, true)
+		rr.cache.Add(&Question{Name: ".", Type: dns.TypeDNSKEY}, &Answer{Answer: rr.rootKeys, Rcode: dns.RcodeSuccess, Authenticated: true}, true)
 	}
-	return rr
+}
+
+// send sends the query m to auth, waiting at most QueryTimeout for the response
+func (rr *RecursiveResolver) send(ctx context.Context, m *dns.Msg, auth *Nameserver) (*dns.Msg, error) {
+	if rr.QueryTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, rr.QueryTimeout)
+		defer cancel()
+	}
+	if auth.TSIG != nil {
+		return rr.exchangeTSIG(ctx, m, nameserverAddr(auth), auth.TSIG)
+	}
+	return rr.exchange(ctx, m, nameserverAddr(auth))
 }
 
 func (rr *RecursiveResolver) query(ctx context.Context, q *Question, auth *Nameserver) (*dns.Msg, *LookupLog, error) {
 	ql := newLookupLog(q, auth)
 	s := time.Now()
 	defer func() { ql.Latency = time.Since(s) }()
-	m := new(dns.Msg)
-	m.SetEdns0(4096, rr.useDNSSEC)
-	m.Question = []dns.Question{{Name: q.Name, Qtype: q.Type, Qclass: dns.ClassINET}}
-	if rr.cache != nil {
-		if answer := rr.cache.Get(q); answer != nil {
+	// answers cached by a resolver that isn't validating may not have their
+	// DNSSEC records
+	if rr.cache != nil && !cacheSkipped(ctx) && (rr.useDNSSEC || !dnssecRecordsRequested(ctx)) {
+		cs := time.Now()
+		answer := rr.getFromCache(ctx, q)
+		ql.timings().Cache += time.Since(cs)
+		if answer == nil {
+			atomic.AddUint64(&rr.counters().cacheMisses, 1)
+		} else {
+			atomic.AddUint64(&rr.counters().cacheHits, 1)
+			m := new(dns.Msg)
+			m.Question = []dns.Question{{Name: q.Name, Qtype: q.Type, Qclass: dns.ClassINET}}
 			m.Rcode = dns.RcodeSuccess
-			m.Answer = answer.Answer
+			m.Answer = orderAddresses(answer.Answer, rr.AddressOrder, int(atomic.AddUint32(&rr.counters().rotation, 1)-1))
 			m.Ns = answer.Authority
 			m.Extra = answer.Additional
 			ql.CacheHit = true
+			ql.Cache = CacheAnswer
 			ql.NS = nil
 			ql.DNSSECValid = answer.Authenticated
 			ql.Rcode = dns.RcodeSuccess
 			return m, ql, nil
 		}
 	}
-	r, _, err := rr.c.Exchange(m, net.JoinHostPort(auth.Addr, dnsPort))
+	m := rr.newQueryMsg(q, auth.Forwarder)
+	defer releaseQueryMsg(m)
+	if dnssecRecordsRequested(ctx) {
+		m.IsEdns0().SetDo()
+	}
+	if subnet := clientSubnet(ctx); subnet != nil {
+		addClientSubnet(m, subnet)
+	}
+	if opts := ednsOptions(ctx); len(opts) > 0 {
+		addEDNSOptions(m, opts)
+	}
+	trace := traceFrom(ctx)
+	r, err := rr.runOnUpstreamSend(ctx, m, auth)
 	if err != nil {
 		return nil, ql, err
 	}
+	if r != nil {
+		trace.add(TraceResponse, auth, r, "returned by OnUpstreamSend hook")
+	} else {
+		if rr.Damping != nil {
+			if err = rr.Damping.allow(auth); err != nil {
+				trace.add(TraceError, auth, nil, "%s", err)
+				return nil, ql, err
+			}
+		}
+		if rr.RateLimiter != nil {
+			if err = rr.RateLimiter.wait(ctx, auth); err != nil {
+				return nil, ql, err
+			}
+		}
+		trace.add(TraceQuery, auth, m, "")
+		ns := time.Now()
+		addr := nameserverAddr(auth)
+		udp := isUDPTransport(rr.Transport)
+		if size := rr.infra.bufferSize(addr); udp && size > 0 {
+			setQueryUDPSize(m, size)
+		}
+		r, err = rr.send(ctx, m, auth)
+		if udp && isTimeout(err) && rr.infra.timedOut(addr, queryUDPSize(m)) && ctx.Err() == nil {
+			// the path to the nameserver may drop large responses
+			setQueryUDPSize(m, reducedEDNSBufferSize)
+			trace.add(TraceQuery, auth, m, "retrying with a reduced EDNS buffer size after timeouts")
+			if r, err = rr.send(ctx, m, auth); isTimeout(err) {
+				rr.infra.timedOut(addr, reducedEDNSBufferSize)
+			}
+		}
+		if udp && err == nil && rr.infra.answered(addr, queryUDPSize(m)) {
+			rr.log(LogInfo, "nameserver only responds to queries advertising a reduced EDNS buffer size", "server", auth.Addr, "zone", auth.Zone, "size", reducedEDNSBufferSize)
+		}
+		if err == nil {
+			// responses exceeding the limits are treated as if none arrived
+			err = rr.ResponseLimits.check(r)
+		}
+		ql.timings().Network += time.Since(ns)
+		if ctx.Err() == nil {
+			rr.observeNameserver(auth, r, err)
+		}
+		if rr.Damping != nil {
+			if err != nil {
+				rr.Damping.record(auth, nil)
+			} else {
+				rr.Damping.record(auth, r)
+			}
+		}
+		atomic.AddUint64(&rr.counters().upstreamQueries, 1)
+		if err != nil {
+			atomic.AddUint64(&rr.counters().upstreamErrors, 1)
+			rr.log(LogDebug, "upstream query failed", "server", auth.Addr, "zone", auth.Zone, "name", q.Name, "type", dns.TypeToString[q.Type], "err", err)
+			trace.add(TraceError, auth, nil, "%s", err)
+			return nil, ql, err
+		}
+		trace.add(TraceResponse, auth, r, "")
+	}
+	if err = rr.runOnUpstreamReceive(ctx, m, auth, r); err != nil {
+		trace.add(TraceDiscarded, auth, nil, "rejected by OnUpstreamReceive hook: %s", err)
+		return nil, ql, err
+	}
 	ql.Rcode = r.Rcode
 
-	// check all returned records are in-bailiwick, ignore extra section?
-	for _, section := range [][]dns.RR{r.Answer, r.Ns} {
-		for _, record := range section {
-			if record.Header().Rrtype != dns.TypeOPT && !strings.HasSuffix(record.Header().Name, auth.Zone) {
-				return nil, ql, ErrOutOfBailiwick // XXX: or just strip invalid records...?
-			}
+	if !rr.PermissiveResponses {
+		if err = checkResponse(r, q); err != nil {
+			trace.add(TraceDiscarded, auth, nil, "%s", err)
+			return nil, ql, err
 		}
 	}
+	ql.Scrubbed = scrubResponse(r, q, auth.Zone)
+	if ql.Scrubbed > 0 {
+		trace.add(TraceDiscarded, auth, nil, "removed %d out of bailiwick or irrelevant records", ql.Scrubbed)
+	}
 	return r, ql, nil
 }
 
-func (rr *RecursiveResolver) lookupNS(ctx context.Context, name string) (*Nameserver, *LookupLog, error) {
-	// XXX: There is no maximum depth to Lookup -> lookupNS -> Lookup calls, looping is possible
+// lookupNS looks up the address of the nameserver name of zone
+func (rr *RecursiveResolver) lookupNS(ctx context.Context, name, zone string) (*Nameserver, *LookupLog, error) {
 	// XXX: I'm not sure how the lookup of a NS addr should be taken into account in terms of the
 	//      dnssec chain (probably if not signed the chain cannot be considered authenticated?)
-	r, log, err := rr.Lookup(ctx, Question{Name: name, Type: dns.TypeA})
+	if cc, ok := rr.cache.(CredibilityCache); ok {
+		// glue from an earlier referral saves resolving the address
+		if glue := cc.GetWithCredibility(&Question{Name: name, Type: dns.TypeA}, CredibilityAdditional); glue != nil {
+			if addresses := extractRRSet(glue.Answer, name, dns.TypeA); len(addresses) > 0 {
+				log := newLookupLog(&Question{Name: name, Type: dns.TypeA}, nil)
+				log.CacheHit = true
+				log.Cache = CacheInfrastructure
+				return &Nameserver{Name: name, Addr: rr.pickAddressRecord(addresses)}, log, nil
+			}
+		}
+	}
+	ctx, log, err := enterDelegation(ctx, name, zone)
+	if err != nil {
+		return nil, log, err
+	}
+	r, log, err := rr.lookup(ctx, Question{Name: name, Type: dns.TypeA})
 	if err != nil {
 		return nil, log, err
 	}
 	if r.Rcode != dns.RcodeSuccess {
-		return nil, log, fmt.Errorf("Authority lookup failed for %s: %s", name, dns.RcodeToString[r.Rcode])
+		return nil, log, &ResolutionError{
+			Stage:    StageAuthority,
+			Question: &Question{Name: name, Type: dns.TypeA},
+			Rcode:    r.Rcode,
+			Err:      ErrAuthorityLookup,
+		}
 	}
 	if len(r.Answer) == 0 {
 		return nil, log, ErrNoAuthorityAddress
@@ -192,7 +557,7 @@ func (rr *RecursiveResolver) lookupNS(ctx context.Context, name string) (*Namese
 	if len(addresses) == 0 {
 		return nil, log, ErrNoAuthorityAddress
 	}
-	return &Nameserver{Name: name, Addr: addresses[mrand.Intn(len(addresses))].(*dns.A).A.String()}, log, nil
+	return &Nameserver{Name: name, Addr: rr.pickAddressRecord(addresses)}, log, nil
 }
 
 func splitAuthsByZone(auths []dns.RR, extras []dns.RR, useIPv6 bool) (map[string][]string, map[string]string) {
@@ -223,13 +588,50 @@ func splitAuthsByZone(auths []dns.RR, extras []dns.RR, useIPv6 bool) (map[string
 	return zones, nsToZone
 }
 
+// fillCache opportunistically caches the NS RRsets in the authority section of r,
+// and the in bailiwick addresses of those nameservers in the additional section,
+// with a lower credibility than answers so they are replaced by better data and
+// never used to answer queries (RFC 2181 Section 5.4.1)
+func (rr *RecursiveResolver) fillCache(r *dns.Msg, zone string) {
+	cc, ok := rr.cache.(CredibilityCache)
+	if !ok {
+		return
+	}
+	nsNames := map[string]struct{}{}
+	for _, set := range GroupRRSets(r.Ns) {
+		if set.Type != dns.TypeNS || !isSubdomain(set.Name, zone) {
+			continue
+		}
+		for _, ns := range set.Records {
+			nsNames[strings.ToLower(ns.(*dns.NS).Ns)] = struct{}{}
+		}
+		cc.AddWithCredibility(&Question{Name: set.Name, Type: dns.TypeNS}, &Answer{Answer: set.Records, Rcode: dns.RcodeSuccess}, CredibilityAuthority)
+	}
+	for _, set := range GroupRRSets(r.Extra) {
+		if _, present := nsNames[strings.ToLower(set.Name)]; !present || !isSubdomain(set.Name, zone) {
+			continue
+		}
+		if set.Type == dns.TypeA || set.Type == dns.TypeAAAA {
+			cc.AddWithCredibility(&Question{Name: set.Name, Type: set.Type}, &Answer{Answer: set.Records, Rcode: dns.RcodeSuccess}, CredibilityAdditional)
+		}
+	}
+}
+
 func (rr *RecursiveResolver) pickAuthority(ctx context.Context, auths []dns.RR, extras []dns.RR) (*Nameserver, *LookupLog, error) {
-	// XXX: this ignores general concept of an 'infrastructure' cache which
-	//      tracks authority performance and uses it as a metric to pick a
-	//      authority. may want to get fancier at some point...
+	extras, private := rr.removePrivateGlue(auths, extras)
 	zones, nsToZone := splitAuthsByZone(auths, extras, rr.useIPv6)
+	if len(private) > 0 {
+		rr.log(LogInfo, "ignored private nameserver addresses in referral", "nameservers", len(private))
+		// looking up the addresses of these nameservers would most likely
+		// return the same private addresses
+		for ns := range private {
+			delete(nsToZone, ns)
+		}
+	}
 	if len(zones) == 0 {
-		if len(nsToZone) == 0 {
+		if len(nsToZone) == 0 && len(private) > 0 {
+			return nil, nil, ErrPrivateNameserver
+		} else if len(nsToZone) == 0 {
 			return nil, nil, ErrNoNSAuthorties
 		}
 		var ns, z string
@@ -237,30 +639,44 @@ func (rr *RecursiveResolver) pickAuthority(ctx context.Context, auths []dns.RR,
 		for ns, z = range nsToZone {
 			break
 		}
-		a, log, err := rr.lookupNS(ctx, ns)
+		a, log, err := rr.lookupNS(ctx, ns, z)
 		if err != nil {
 			return nil, log, err
 		}
+		if isPrivateAddr(a.Addr) && !rr.privateNameserversAllowed(z) {
+			return nil, log, ErrPrivateNameserver
+		}
 		a.Zone = z
 		return a, log, nil
 	}
 	// abuse how ranging over maps works to select a 'random' element
 	for ns, z := range nsToZone {
 		if len(zones[z]) > 0 {
-			return &Nameserver{ns, zones[z][mrand.Intn(len(zones[z]))], z}, nil, nil
+			addrs := zones[z]
+			return &Nameserver{Name: ns, Addr: addrs[rr.pickAddress(len(addrs), func(i int) string { return addrs[i] })], Zone: z}, nil, nil
 		}
 	}
 	return nil, nil, ErrNoNSAuthorties
 }
 
-func extractAnswer(m *dns.Msg, authenticated bool) *Answer {
-	return &Answer{
-		Answer:        m.Answer,
-		Authority:     m.Ns,
-		Additional:    m.Extra,
-		Rcode:         m.Rcode,
-		Authenticated: authenticated,
+// extractAnswer returns the answer contained in m, with the records of each
+// section normalized
+func extractAnswer(ctx context.Context, m *dns.Msg, authenticated bool) *Answer {
+	a := &Answer{
+		Answer:         normalizeRecords(m.Answer),
+		Authority:      normalizeRecords(m.Ns),
+		Additional:     normalizeRecords(m.Extra),
+		Rcode:          m.Rcode,
+		Authenticated:  authenticated,
+		ExtendedErrors: extractExtendedErrors(m),
+	}
+	if responseMsgRequested(ctx) {
+		a.Msg = m
 	}
+	if ednsOptions(ctx) != nil {
+		a.EDNSOptions = responseOptions(m)
+	}
+	return a
 }
 
 func allOfType(a []dns.RR, t uint16) bool {
@@ -339,11 +755,136 @@ func isAlias(answer []dns.RR, q Question) (bool, string, []dns.RR, error) {
 // Lookup a Question iteratively. All upstream responses are validated
 // and a DNSSEC chain is built if the RecursiveResolver was initialized to do so.
 // If responses are found in the question/answer cache they will be used instead
-// of sending messages to remote nameservers.
+// of sending messages to remote nameservers. Any registered Hooks are called
+// during resolution.
 func (rr *RecursiveResolver) Lookup(ctx context.Context, q Question) (*Answer, *LookupLog, error) {
+	started := time.Now()
+	defer rr.counters().lookupStarted()()
+	if rr.skipValidation {
+		ctx = WithoutValidation(ctx)
+	}
+	if rr.LookupTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, rr.LookupTimeout)
+		defer cancel()
+	}
+	var a *Answer
+	var err error
+	if !isASCII(q.Name) {
+		// Unicode names are sent, cached, and logged using their A-labels
+		q.Name, err = ToASCII(q.Name, rr.StrictIDNA)
+	}
+	if err == nil {
+		a, err = rr.runOnQuery(ctx, &q)
+	}
+	asked := q
+	var rewrite *NameRewrite
+	if a == nil && err == nil && len(rr.Rewrites) > 0 {
+		rewrite, err = rr.rewrite(&q)
+	}
+	var ll *LookupLog
+	if a == nil && err == nil {
+		a, ll, err = rr.lookupSources(ctx, q)
+	} else {
+		ll = newLookupLog(&q, nil)
+		ll.Latency = time.Since(ll.Started)
+	}
+	if rewrite != nil {
+		if err == nil {
+			a = rewrite.restore(asked.Name, q.Name, a)
+		}
+		// the caller and hooks see the question that was asked, the lookup log
+		// describes the resolution of the rewritten one
+		q = asked
+	}
+	t := ll.sumTimings()
+	t.Total = ll.Latency
+	ll.Timings = &t
+	rr.counters().lookupFinished(a, err, rr.validating(ctx))
+	rr.logSlowQuery(q, started, err, ll)
+	rr.enforceMemoryLimit()
+	if err != nil {
+		rr.runOnError(ctx, q, err, ll)
+		return nil, ll, err
+	}
+	if rr.MinimalResponses {
+		a = minimalResponse(a)
+	}
+	if responseMsgRequested(ctx) {
+		c := *a
+		c.Msg = responseMsg(q, a)
+		a = &c
+	}
+	return rr.runOnAnswer(ctx, q, a, ll), ll, nil
+}
+
+// resolveFunc resolves a question remotely, by forwarding or iterating
+type resolveFunc func(ctx context.Context, q Question) (*Answer, *LookupLog, error)
+
+// resolve resolves q using resolve, once the Limiter allows it, validating the
+// answer in the background if LazyValidation is set and filtering it using
+// Rebinding
+func (rr *RecursiveResolver) resolve(ctx context.Context, q Question, resolve resolveFunc) (*Answer, *LookupLog, error) {
+	if rr.Limiter != nil {
+		if err := rr.Limiter.acquire(ctx); err != nil {
+			return nil, nil, err
+		}
+		defer rr.Limiter.release()
+	}
+	var a *Answer
+	var ll *LookupLog
+	var err error
+	if rr.LazyValidation && rr.validating(ctx) {
+		a, ll, err = rr.resolveRemembering(WithoutValidation(ctx), q, resolve)
+		if err == nil && !a.Authenticated {
+			go rr.validateLazily(ctx, q, resolve)
+		}
+	} else {
+		a, ll, err = rr.resolveRemembering(ctx, q, resolve)
+	}
+	if err == nil && rr.Rebinding != nil {
+		a, err = rr.Rebinding.filter(q, a)
+	}
+	return a, ll, err
+}
+
+// resolveRemembering resolves q using resolve, and remembers the failure if it is
+// bogus or couldn't be resolved
+func (rr *RecursiveResolver) resolveRemembering(ctx context.Context, q Question, resolve resolveFunc) (*Answer, *LookupLog, error) {
+	ctx = withValidationBudget(ctx)
+	a, ll, err := resolve(ctx, q)
+	if err != nil && rr.bogusTTL() > 0 && rr.validating(ctx) && isBogus(err) {
+		rr.failures.add(q, validationFailure, nil, err, rr.bogusTTL())
+	}
+	if rr.failureTTL() > 0 {
+		if isResolutionFailure(ctx, a, err) {
+			rr.failures.add(q, resolutionFailure, a, err, rr.failureTTL())
+		} else if err == nil {
+			rr.failures.remove(q)
+		}
+	}
+	return a, ll, err
+}
+
+func (rr *RecursiveResolver) lookup(ctx context.Context, q Question) (*Answer, *LookupLog, error) {
 	ll := newLookupLog(&q, nil)
 
-	authority := &rr.rootNameservers[mrand.Intn(len(rr.rootNameservers))]
+	authority := rr.startAuthority(q.Name)
+	// the chain of trust can only be followed when starting from the root
+	fromRoot := authority.Zone == "."
+	// start is set while querying the authority the lookup, or the lookup of
+	// the target of an alias, started at
+	start := true
+	var parentDSSet []dns.RR
+	if fromRoot {
+		// a primed delegation from the root is followed without querying it,
+		// its DS records continue the chain of trust
+		if primed, ds, ok := rr.primedAuthority(q.Name); ok {
+			authority, parentDSSet, start = primed, ds, false
+		}
+	}
+	validate := rr.validating(ctx)
+	trace := traceFrom(ctx)
 
 	defer func() {
 		ll.Latency = time.Since(ll.Started)
@@ -351,29 +892,64 @@ func (rr *RecursiveResolver) Lookup(ctx context.Context, q Question) (*Answer, *
 
 	aliases := map[string]struct{}{}
 	var chased []dns.RR
-	var parentDSSet []dns.RR
-	// XXX: This whole loop could be split off into its own function in order
-	//      to pass through the i when we need to do things like lookupNS which
-	//      are prone to infinitely looping
+	// aliasesSecure is set while every alias that has been followed was validated
+	aliasesSecure := true
+	// partial are the RRsets removed from the responses because they failed
+	// validation, see WithPartialResults
+	var partial []PartialFailure
+	// parent and referral are the nameserver that referred the lookup to the
+	// current authority and its response, if any
+	var parent *Nameserver
+	var referral *dns.Msg
 	for i := 0; i < MaxReferrals; i++ {
 		r, log, err := rr.query(ctx, &q, authority)
 		ll.Composites = append(ll.Composites, log)
+		if err != nil && err != dns.ErrTruncated && rr.ParentFallback && parent != nil && len(parentDSSet) == 0 && ctx.Err() == nil {
+			log.Error = err.Error()
+			var logs []*LookupLog
+			r, logs, err = rr.queryFallback(ctx, &q, authority, parent, referral)
+			ll.Composites = append(ll.Composites, logs...)
+			log = logs[len(logs)-1]
+			if log.Degraded {
+				ll.Degraded = true
+				if err == nil && isReferralTo(r, authority.Zone) {
+					return extractAnswer(ctx, r, false), ll, nil
+				}
+			}
+		}
 		if err != nil && err != dns.ErrTruncated { // if truncated still try...
+			err = newResolutionError(StageQuery, &q, authority, -1, err)
 			log.Error = err.Error()
 			return nil, ll, err
 		} else if err == dns.ErrTruncated {
 			log.Truncated = true
 		}
+		if !log.CacheHit {
+			log.ExtendedErrors = extractExtendedErrors(r)
+		}
 
 		// validate
 		validated := false
 		if log.CacheHit {
 			validated = log.DNSSECValid
 		}
-		if (i == 0 || len(parentDSSet) > 0) && !log.CacheHit {
-			dkLog, err := rr.checkSignatures(ctx, r, authority, parentDSSet)
+		if validate && ((start && fromRoot) || len(parentDSSet) > 0) && !log.CacheHit {
+			dkLog, failures, err := rr.checkSignatures(ctx, r, authority, parentDSSet)
 			log.Composites = append(log.Composites, dkLog)
+			trace.validation(authority, "signatures", err)
+			for _, f := range failures {
+				trace.add(TraceDiscarded, authority, nil, "removed %s %s which failed validation: %s", f.Name, dns.TypeToString[f.Type], f.Err)
+			}
+			partial = append(partial, failures...)
 			if err != nil {
+				if !errors.Is(err, ErrValidationBudgetExceeded) && !isTimeout(err) {
+					rr.reputation.penalize(authority.Addr, bogusPenalty)
+				}
+				if len(parentDSSet) > 0 {
+					rr.zoneStatus.set(authority.Zone, SecurityBogus, BogusZoneTTL)
+					rr.log(LogWarn, "zone failed validation", "zone", authority.Zone, "server", authority.Addr, "err", err)
+				}
+				err = newResponseError(StageValidation, &q, authority, r, err)
 				log.Error = err.Error()
 				return nil, ll, err
 			}
@@ -381,14 +957,21 @@ func (rr *RecursiveResolver) Lookup(ctx context.Context, q Question) (*Answer, *
 		}
 		log.DNSSECValid = validated
 		ll.DNSSECValid = validated
+		// the AD bit may only be set if every RRset in the answer is secure
+		// (RFC 6840 Section 5.8)
+		authenticated := validated && aliasesSecure && allSigned(r.Answer) && allSigned(r.Ns)
 
 		if r.Rcode != dns.RcodeSuccess {
 			// XXX: cache name error?
 			if r.Rcode == dns.RcodeNameError {
 				nsecSet := extractRRSet(r.Ns, "", dns.TypeNSEC3)
 				if len(nsecSet) != 0 { // if the zone is signed and this is missing its a failure...
+					vs := time.Now()
 					err = verifyNameError(&q, nsecSet)
+					log.timings().Validation += time.Since(vs)
+					trace.validation(authority, "NSEC3 proof of non-existence", err)
 					if err != nil {
+						err = newResponseError(StageDenial, &q, authority, r, err)
 						log.Error = err.Error()
 						log.DNSSECValid = false
 						ll.DNSSECValid = false
@@ -396,98 +979,270 @@ func (rr *RecursiveResolver) Lookup(ctx context.Context, q Question) (*Answer, *
 					}
 				}
 			}
-			return extractAnswer(r, validated), ll, nil
+			a := extractAnswer(ctx, r, authenticated && len(partial) == 0)
+			a.Failures = partial
+			return a, ll, nil
 		}
 
 		// good response
 		if len(r.Answer) > 0 {
+			if validated && !log.CacheHit {
+				vs := time.Now()
+				err = verifyWildcardAnswer(r.Answer, r.Ns)
+				log.timings().Validation += time.Since(vs)
+				trace.validation(authority, "wildcard expansion proof", err)
+				if err != nil {
+					err = newResponseError(StageDenial, &q, authority, r, err)
+					log.Error = err.Error()
+					log.DNSSECValid = false
+					ll.DNSSECValid = false
+					return nil, ll, err
+				}
+			}
 			if ok, canonicalName, chasedRR, err := isAlias(r.Answer, q); ok {
 				if _, ok := aliases[canonicalName]; ok {
-					err = errors.New("Alias loop detected, aborting")
+					err = newResponseError(StageAlias, &q, authority, r, ErrAliasLoop)
 					log.Error = err.Error()
 					return nil, ll, err
 				}
 				aliases[canonicalName] = struct{}{}
+				aliasesSecure = authenticated
 
-				authority = &rr.rootNameservers[mrand.Intn(len(rr.rootNameservers))]
+				authority = rr.startAuthority(canonicalName)
+				fromRoot = authority.Zone == "."
+				start = true
+				parentDSSet = nil
+				if fromRoot {
+					if primed, ds, ok := rr.primedAuthority(canonicalName); ok {
+						authority, parentDSSet, start = primed, ds, false
+					}
+				}
+				parent, referral = nil, nil
 				q.Name = canonicalName
 				chased = append(chased, chasedRR...)
 				// XXX: cache alias answer
 				continue
 			} else if err != nil {
+				err = newResponseError(StageAlias, &q, authority, r, err)
 				log.Error = err.Error()
 				return nil, ll, err
 			}
-			if !log.CacheHit && rr.cache != nil {
-				go rr.cache.Add(&q, &Answer{r.Answer, r.Ns, r.Extra, r.Rcode, validated}, false)
+			if !log.CacheHit && rr.cache != nil && !validationSkipped(ctx) && len(partial) == 0 {
+				go rr.addToCache(ctx, &q, &Answer{Answer: r.Answer, Authority: r.Ns, Additional: r.Extra, Rcode: r.Rcode, Authenticated: validated}, r)
 			}
 
 			if len(chased) > 0 {
 				// put aliases at the front of the answer
 				r.Answer = append(chased, r.Answer...)
 			}
-			return extractAnswer(r, validated), ll, nil
+			// an answer with records removed isn't the authenticated answer
+			a := extractAnswer(ctx, r, authenticated && len(partial) == 0)
+			a.Failures = partial
+			return a, ll, nil
 		}
 
 		nsecSet := extractRRSet(r.Ns, "", dns.TypeNSEC3)
 
-		// NODATA response
-		if len(r.Ns) == 0 || len(nsecSet) == len(r.Ns) {
+		// NODATA response, anything other than a referral, which may include the
+		// SOA record of the zone and signatures (RFC 2308 Section 2.2)
+		if len(extractRRSet(r.Ns, "", dns.TypeNS)) == 0 {
 			if len(nsecSet) != 0 {
 				// check for proper coverage
+				vs := time.Now()
 				err = verifyNODATA(&q, nsecSet)
+				log.timings().Validation += time.Since(vs)
+				trace.validation(authority, "NSEC3 proof of no data", err)
 				if err != nil {
+					err = newResponseError(StageDenial, &q, authority, r, err)
 					log.Error = err.Error()
 					log.DNSSECValid = false
 					ll.DNSSECValid = false
 					return nil, ll, err
 				}
 			}
-			// ignore anything in additional section (?)
-			return &Answer{Rcode: dns.RcodeSuccess, Authenticated: validated}, ll, nil
+			// ignore anything in additional section (?), but keep the SOA
+			// record and any proofs of no data in the authority section
+			return &Answer{Authority: normalizeRecords(r.Ns), Rcode: dns.RcodeSuccess, Authenticated: authenticated, ExtendedErrors: log.ExtendedErrors}, ll, nil
 		}
 
 		// Referral response
 		log.Referral = true
 		var authLog *LookupLog
+		referrer := authority
+		if err = checkReferral(r.Ns, referrer.Zone, q.Name); err != nil {
+			if !log.CacheHit {
+				// the nameserver doesn't know it is authoritative for the zone
+				rr.reputation.penalize(referrer.Addr, lamePenalty)
+			}
+			err = newResponseError(StageReferral, &q, referrer, r, err)
+			log.Error = err.Error()
+			return nil, ll, err
+		}
+		if !log.CacheHit && !validationSkipped(ctx) {
+			rr.fillCache(r, referrer.Zone)
+		}
 		authority, authLog, err = rr.pickAuthority(ctx, r.Ns, r.Extra)
 		if authLog != nil {
 			log.Composites = append(log.Composites, authLog)
 		}
 		if err != nil {
+			err = newResponseError(StageReferral, &q, referrer, r, err)
 			log.Error = err.Error()
 			return nil, ll, err
 		}
+		parent, referral = referrer, r
 		if len(nsecSet) != 0 {
+			vs := time.Now()
 			err = verifyDelegation(authority.Zone, nsecSet)
+			log.timings().Validation += time.Since(vs)
+			trace.validation(referrer, "NSEC3 proof of insecure delegation", err)
 			if err != nil {
+				err = newResponseError(StageDenial, &q, referrer, r, err)
 				log.Error = err.Error()
 				log.DNSSECValid = false
 				ll.DNSSECValid = false
 				return nil, ll, err
 			}
-		} else if len(parentDSSet) > 0 {
-			err := errors.New("unsigned delegation in signed zone without NSEC records")
+		} else if len(parentDSSet) > 0 && len(extractRRSet(r.Ns, authority.Zone, dns.TypeDS)) == 0 {
+			trace.validation(referrer, "delegation", ErrUnsignedDelegation)
+			err := newResponseError(StageValidation, &q, referrer, r, ErrUnsignedDelegation)
 			log.Error = err.Error()
 			return nil, ll, err
 		}
-		if i == 0 || len(parentDSSet) > 0 {
+		if validate && ((start && fromRoot) || len(parentDSSet) > 0) {
 			parentDSSet = extractRRSet(r.Ns, authority.Zone, dns.TypeDS)
-		} else if i > 0 { // XXX: is this right?
+		} else if !start { // XXX: is this right?
 			parentDSSet = nil
 		}
+		start = false
+		if validated && !log.CacheHit {
+			// the referral was validated so it proves the status of the child
+			if len(parentDSSet) > 0 {
+				rr.zoneStatus.setFromRecords(authority.Zone, SecuritySecure, parentDSSet)
+			} else if len(nsecSet) > 0 {
+				rr.zoneStatus.setFromRecords(authority.Zone, SecurityInsecure, nsecSet)
+			}
+		}
+		if rr.zoneStatus.get(authority.Zone) == SecurityBogus {
+			err = newResponseError(StageValidation, &q, referrer, r, ErrBogusZone)
+			log.Error = err.Error()
+			return nil, ll, err
+		}
+	}
+	return nil, ll, newResolutionError(StageReferral, &q, authority, -1, ErrTooManyReferrals)
+}
+
+// queryFallback sends q to the nameservers of the zone auth belongs to, other
+// than auth, that were included in referral, and if none of them respond to
+// parent, the nameserver that sent the referral. The logs of each query are
+// returned, the last being the log of the query whose response is returned.
+func (rr *RecursiveResolver) queryFallback(ctx context.Context, q *Question, auth, parent *Nameserver, referral *dns.Msg) (*dns.Msg, []*LookupLog, error) {
+	var logs []*LookupLog
+	zones, _ := splitAuthsByZone(referral.Ns, referral.Extra, rr.useIPv6)
+	for _, addr := range rr.reputation.order(rr.orderAddressFamilies(zones[auth.Zone])) {
+		if addr == auth.Addr {
+			continue
+		}
+		r, log, err := rr.query(ctx, q, &Nameserver{Name: auth.Name, Addr: addr, Zone: auth.Zone})
+		logs = append(logs, log)
+		if err == nil || err == dns.ErrTruncated || ctx.Err() != nil {
+			return r, logs, err
+		}
+		log.Error = err.Error()
+	}
+	rr.log(LogInfo, "nameservers for zone failed, querying parent", "zone", auth.Zone, "parent", parent.Zone, "server", parent.Addr, "name", q.Name)
+	r, log, err := rr.query(ctx, q, parent)
+	log.Degraded = true
+	return r, append(logs, log), err
+}
+
+// isReferralTo checks if r is a referral to zone
+func isReferralTo(r *dns.Msg, zone string) bool {
+	if r.Rcode != dns.RcodeSuccess || len(r.Answer) > 0 {
+		return false
+	}
+	for _, a := range r.Ns {
+		if a.Header().Rrtype == dns.TypeNS && strings.EqualFold(a.Header().Name, zone) {
+			return true
+		}
+	}
+	return false
+}
+
+// startAuthority returns the nameserver iteration for name should start at, either
+// one of the nameservers of the most specific stub zone containing name or one of
+// the root nameservers
+func (rr *RecursiveResolver) startAuthority(name string) *Nameserver {
+	for _, zone := range enclosingZones(name) {
+		if addrs := rr.StubZones[zone]; len(addrs) > 0 {
+			addr := addrs[rr.pickAddress(len(addrs), func(i int) string { return addrs[i] })]
+			return &Nameserver{Name: addr, Addr: addr, Zone: zone}
+		}
+	}
+	return rr.pickNameserver(rr.rootNameservers)
+}
+
+// enclosingZones returns the lower cased names of the zones name may be in, from
+// name itself up to the root
+func enclosingZones(name string) []string {
+	name = CanonicalName(name)
+	zones := []string{name}
+	for off, end := dns.NextLabel(name, 0); !end; off, end = dns.NextLabel(name, off) {
+		zones = append(zones, name[off:])
 	}
-	return nil, ll, ErrTooManyReferrals
+	if name != "." {
+		zones = append(zones, ".")
+	}
+	return zones
+}
+
+// checkReferral checks that the NS records in a referral from a nameserver for zone
+// are for a zone below zone and above, or equal to, qname so that an upward or
+// sideways referral can't send the iteration off course
+func checkReferral(auths []dns.RR, zone, qname string) error {
+	for _, a := range auths {
+		if a.Header().Rrtype != dns.TypeNS {
+			continue
+		}
+		child := a.Header().Name
+		if strings.EqualFold(child, zone) || !isSubdomain(child, zone) || !isSubdomain(qname, child) {
+			return ErrInvalidReferral
+		}
+	}
+	return nil
+}
+
+// isSubdomain checks if name is equal to, or a subdomain of, zone. Both names
+// must be fully qualified.
+func isSubdomain(name, zone string) bool {
+	if zone == "." {
+		return true
+	}
+	name, zone = strings.ToLower(name), strings.ToLower(zone)
+	return name == zone || strings.HasSuffix(name, "."+zone)
+}
+
+// typeIn checks if t is in types, the lists of types passed around are always
+// short enough that a linear scan is cheaper than building a set
+func typeIn(t uint16, types []uint16) bool {
+	for _, c := range types {
+		if c == t {
+			return true
+		}
+	}
+	return false
 }
 
 func filterRRSet(in []dns.RR, rrTypes ...uint16) []dns.RR {
-	tMap := make(map[uint16]struct{}, len(rrTypes))
-	for _, rrType := range rrTypes {
-		tMap[rrType] = struct{}{}
+	n := 0
+	for _, r := range in {
+		if !typeIn(r.Header().Rrtype, rrTypes) {
+			n++
+		}
 	}
-	out := []dns.RR{}
+	out := make([]dns.RR, 0, n)
 	for _, r := range in {
-		if _, present := tMap[r.Header().Rrtype]; !present {
+		if !typeIn(r.Header().Rrtype, rrTypes) {
 			out = append(out, r)
 		}
 	}
@@ -495,16 +1250,18 @@ func filterRRSet(in []dns.RR, rrTypes ...uint16) []dns.RR {
 }
 
 func extractRRSet(in []dns.RR, name string, t ...uint16) []dns.RR {
-	out := []dns.RR{}
-	tMap := make(map[uint16]struct{}, len(t))
-	for _, t := range t {
-		tMap[t] = struct{}{}
+	matches := func(r dns.RR) bool {
+		return typeIn(r.Header().Rrtype, t) && (name == "" || name == r.Header().Name)
 	}
+	n := 0
 	for _, r := range in {
-		if _, present := tMap[r.Header().Rrtype]; present {
-			if name != "" && name != r.Header().Name {
-				continue
-			}
+		if matches(r) {
+			n++
+		}
+	}
+	out := make([]dns.RR, 0, n)
+	for _, r := range in {
+		if matches(r) {
 			out = append(out, r)
 		}
 	}
@@ -1,6 +1,9 @@
 package solvere
 
 import (
+	"context"
+	"errors"
+	"net"
 	"strings"
 	"testing"
 
@@ -97,6 +100,140 @@ func TestCollapseCNAMEChain(t *testing.T) {
 	}
 }
 
+func TestCheckReferral(t *testing.T) {
+	ns := func(name string) []dns.RR {
+		return []dns.RR{
+			&dns.NS{Hdr: dns.RR_Header{Name: name, Rrtype: dns.TypeNS, Class: dns.ClassINET}, Ns: "ns.example.net."},
+			&dns.DS{Hdr: dns.RR_Header{Name: "other.com.", Rrtype: dns.TypeDS, Class: dns.ClassINET}},
+		}
+	}
+	for _, tc := range []struct {
+		child, zone, qname string
+		valid              bool
+	}{
+		{"example.com.", "com.", "www.example.com.", true},
+		{"EXAMPLE.com.", "com.", "example.com.", true},
+		{"com.", ".", "www.example.com.", true},
+		{"com.", "com.", "www.example.com.", false},         // lame
+		{".", "com.", "www.example.com.", false},            // upward
+		{"other.com.", "com.", "www.example.com.", false},   // sideways
+		{"example.net.", "com.", "www.example.net.", false}, // out of zone
+		{"a.www.example.com.", "com.", "www.example.com.", false},
+	} {
+		err := checkReferral(ns(tc.child), tc.zone, tc.qname)
+		if tc.valid && err != nil {
+			t.Errorf("checkReferral rejected valid referral to %s from %s for %s: %s", tc.child, tc.zone, tc.qname, err)
+		} else if !tc.valid && err != ErrInvalidReferral {
+			t.Errorf("checkReferral didn't reject invalid referral to %s from %s for %s", tc.child, tc.zone, tc.qname)
+		}
+	}
+}
+
+func TestStubZones(t *testing.T) {
+	rr := NewRecursiveResolver(false, false, []dns.RR{
+		&dns.A{Hdr: dns.RR_Header{Name: "a.root-servers.net.", Rrtype: dns.TypeA, Class: dns.ClassINET}, A: net.IP{198, 41, 0, 4}},
+	}, nil, nil)
+	rr.StubZones = map[string][]string{"lab.example.": {"10.1.0.1:5353"}}
+	var addrs []string
+	rr.Transport = transportFunc(func(_ context.Context, m *dns.Msg, addr string) (*dns.Msg, error) {
+		addrs = append(addrs, addr)
+		r := new(dns.Msg)
+		r.SetReply(m)
+		r.Answer = []dns.RR{&dns.A{Hdr: dns.RR_Header{Name: m.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60}, A: net.IP{10, 1, 0, 2}}}
+		return r, nil
+	})
+	a, _, err := rr.Lookup(context.Background(), Question{Name: "host.lab.example.", Type: dns.TypeA})
+	if err != nil {
+		t.Fatalf("Lookup failed: %s", err)
+	}
+	if len(addrs) != 1 || addrs[0] != "10.1.0.1:5353" {
+		t.Fatalf("Expected a single query to the stub nameserver, sent %v", addrs)
+	}
+	if a.Authenticated {
+		t.Fatal("Answer from stub zone was authenticated")
+	}
+}
+
+func TestNODATAWithSOA(t *testing.T) {
+	rr := NewRecursiveResolver(false, false, []dns.RR{
+		&dns.A{Hdr: dns.RR_Header{Name: "a.root-servers.net.", Rrtype: dns.TypeA, Class: dns.ClassINET}, A: net.IP{198, 41, 0, 4}},
+	}, nil, nil)
+	rr.Transport = transportFunc(func(_ context.Context, m *dns.Msg, addr string) (*dns.Msg, error) {
+		r := new(dns.Msg)
+		r.SetReply(m)
+		r.Authoritative = true
+		r.Ns = []dns.RR{&dns.SOA{Hdr: dns.RR_Header{Name: ".", Rrtype: dns.TypeSOA, Class: dns.ClassINET, Ttl: 60}, Ns: "a.root-servers.net.", Mbox: "nstld.example.", Minttl: 60}}
+		return r, nil
+	})
+	a, _, err := rr.Lookup(context.Background(), Question{Name: "example.", Type: dns.TypeA})
+	if err != nil {
+		t.Fatalf("Lookup failed: %s", err)
+	}
+	if a.Rcode != dns.RcodeSuccess || len(a.Answer) != 0 {
+		t.Fatalf("Unexpected answer for NODATA response: %+v", a)
+	}
+	if len(a.Authority) != 1 || a.Authority[0].Header().Rrtype != dns.TypeSOA {
+		t.Fatalf("SOA record wasn't kept in NODATA answer: %v", a.Authority)
+	}
+}
+
+func TestParentFallback(t *testing.T) {
+	rr := NewRecursiveResolver(false, false, []dns.RR{
+		&dns.A{Hdr: dns.RR_Header{Name: "a.root-servers.net.", Rrtype: dns.TypeA, Class: dns.ClassINET}, A: net.IP{198, 41, 0, 4}},
+	}, nil, nil)
+	root := net.JoinHostPort("198.41.0.4", dnsPort)
+	var addrs []string
+	rr.Transport = transportFunc(func(_ context.Context, m *dns.Msg, addr string) (*dns.Msg, error) {
+		addrs = append(addrs, addr)
+		if addr != root {
+			return nil, errors.New("timeout")
+		}
+		r := new(dns.Msg)
+		r.SetReply(m)
+		for i, ns := range []string{"ns1.example.", "ns2.example."} {
+			r.Ns = append(r.Ns, &dns.NS{Hdr: dns.RR_Header{Name: "example.", Rrtype: dns.TypeNS, Class: dns.ClassINET, Ttl: 60}, Ns: ns})
+			r.Extra = append(r.Extra, &dns.A{Hdr: dns.RR_Header{Name: ns, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60}, A: net.IP{192, 0, 2, byte(i + 1)}})
+		}
+		return r, nil
+	})
+	_, ll, err := rr.Lookup(context.Background(), Question{Name: "a.example.", Type: dns.TypeA})
+	if err == nil {
+		t.Fatal("Lookup with unresponsive nameservers didn't fail")
+	}
+	if ll.Degraded || len(addrs) != 2 {
+		t.Fatalf("Expected a single query to the zone's nameservers without ParentFallback, sent %v", addrs)
+	}
+
+	rr.ParentFallback = true
+	addrs = nil
+	a, ll, err := rr.Lookup(context.Background(), Question{Name: "b.example.", Type: dns.TypeA})
+	if err != nil {
+		t.Fatalf("Lookup failed with ParentFallback set: %s", err)
+	}
+	if len(addrs) != 4 || addrs[3] != root {
+		t.Fatalf("Expected both of the zone's nameservers and then the root to be queried, sent %v", addrs)
+	}
+	if !ll.Degraded || !ll.Composites[len(ll.Composites)-1].Degraded {
+		t.Fatal("LookupLog isn't marked as degraded")
+	}
+	if len(a.Answer) != 0 || len(a.Authority) != 2 || a.Authenticated {
+		t.Fatalf("Expected the referral as the answer, got %#v", a)
+	}
+}
+
+func TestEnclosingZones(t *testing.T) {
+	for name, expected := range map[string][]string{
+		".":         {"."},
+		"com.":      {"com.", "."},
+		"A.b.Com.":  {"a.b.com.", "b.com.", "com.", "."},
+		"a\\.b.com": {"a\\.b.com.", "com.", "."},
+	} {
+		if zones := enclosingZones(name); strings.Join(zones, " ") != strings.Join(expected, " ") {
+			t.Errorf("enclosingZones(%q): expected %v, got %v", name, expected, zones)
+		}
+	}
+}
+
 func TestIsAlias(t *testing.T) {
 	for _, tc := range []struct {
 		set           []dns.RR
@@ -217,3 +354,87 @@ func TestIsAlias(t *testing.T) {
 		}
 	}
 }
+
+func benchmarkSection() []dns.RR {
+	section := []dns.RR{}
+	for i := 0; i < 8; i++ {
+		section = append(section,
+			&dns.A{Hdr: dns.RR_Header{Name: "a.com.", Rrtype: dns.TypeA}, A: net.IP{1, 2, 3, byte(i)}},
+			&dns.RRSIG{Hdr: dns.RR_Header{Name: "a.com.", Rrtype: dns.TypeRRSIG}, TypeCovered: dns.TypeA},
+		)
+	}
+	return section
+}
+
+func BenchmarkExtractRRSet(b *testing.B) {
+	section := benchmarkSection()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		extractRRSet(section, "a.com.", dns.TypeA)
+	}
+}
+
+func BenchmarkFilterRRSet(b *testing.B) {
+	section := benchmarkSection()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		filterRRSet(section, dns.TypeRRSIG)
+	}
+}
+
+func BenchmarkQuery(b *testing.B) {
+	rr := &RecursiveResolver{c: new(dns.Client)}
+	section := benchmarkSection()
+	rr.AddHooks(Hooks{
+		OnUpstreamSend: func(_ context.Context, m *dns.Msg, _ *Nameserver) (*dns.Msg, error) {
+			return &dns.Msg{MsgHdr: dns.MsgHdr{Id: m.Id, Response: true}, Answer: section}, nil
+		},
+	})
+	q := &Question{Name: "a.com.", Type: dns.TypeA}
+	auth := &Nameserver{Zone: "com.", Addr: "127.0.0.1"}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, _, err := rr.query(context.Background(), q, auth); err != nil {
+			b.Fatalf("query failed: %s", err)
+		}
+	}
+}
+
+func TestFillCache(t *testing.T) {
+	cache := NewBasicCache()
+	rr := NewRecursiveResolver(false, false, nil, nil, cache)
+	r := new(dns.Msg)
+	r.Ns = []dns.RR{
+		&dns.NS{Hdr: dns.RR_Header{Name: "example.com.", Rrtype: dns.TypeNS, Class: dns.ClassINET, Ttl: 60}, Ns: "ns.example.com."},
+		&dns.NS{Hdr: dns.RR_Header{Name: "example.com.", Rrtype: dns.TypeNS, Class: dns.ClassINET, Ttl: 60}, Ns: "ns.other."},
+	}
+	r.Extra = []dns.RR{
+		&dns.A{Hdr: dns.RR_Header{Name: "ns.example.com.", Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60}, A: net.IP{1, 2, 3, 4}},
+		&dns.A{Hdr: dns.RR_Header{Name: "ns.other.", Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60}, A: net.IP{1, 2, 3, 5}},
+		&dns.A{Hdr: dns.RR_Header{Name: "unrelated.com.", Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60}, A: net.IP{1, 2, 3, 6}},
+	}
+	rr.fillCache(r, "com.")
+
+	if a := cache.GetWithCredibility(&Question{Name: "example.com.", Type: dns.TypeNS}, CredibilityAuthority); a == nil || len(a.Answer) != 2 {
+		t.Fatalf("NS RRset wasn't cached: %v", a)
+	}
+	if a := cache.Get(&Question{Name: "example.com.", Type: dns.TypeNS}); a != nil {
+		t.Fatal("NS RRset from a referral can be used as a answer")
+	}
+	if a := cache.GetWithCredibility(&Question{Name: "ns.example.com.", Type: dns.TypeA}, CredibilityAdditional); a == nil {
+		t.Fatal("In bailiwick glue wasn't cached")
+	}
+	for _, name := range []string{"ns.other.", "unrelated.com."} {
+		if a := cache.GetWithCredibility(&Question{Name: name, Type: dns.TypeA}, CredibilityAdditional); a != nil {
+			t.Fatalf("Address for %s was cached", name)
+		}
+	}
+
+	ns, _, err := rr.lookupNS(context.Background(), "ns.example.com.", "example.com.")
+	if err != nil {
+		t.Fatalf("lookupNS failed: %s", err)
+	}
+	if ns.Addr != "1.2.3.4" {
+		t.Fatalf("lookupNS didn't use the cached glue: %s", ns.Addr)
+	}
+}
@@ -0,0 +1,113 @@
+package solvere
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// ErrNameRewrite is returned by RecursiveResolver.Lookup when rewriting the name
+// asked produces an invalid name, such as one that is too long
+var ErrNameRewrite = errors.New("solvere: Rewritten name is invalid")
+
+// NameRewrite maps the names asked in Lookup to different names before they are
+// resolved, for example so clients can keep using the names of a zone which has
+// been renamed. From is replaced with To in names equal to or below From, e.g.
+// with From old.corp. and To new.corp. a lookup of www.old.corp. resolves
+// www.new.corp.. If From starts with "*." only the names below it are rewritten,
+// and a leading "*." of To is ignored.
+type NameRewrite struct {
+	From string
+	To   string
+	// RestoreNames causes the owner names of the answer records for the
+	// rewritten names, and the targets of CNAME records pointing at them, to be
+	// changed back to the names below From, as if From had been resolved. The
+	// signatures of renamed records are removed since they no longer match
+	// them. Otherwise a CNAME from the name asked to the rewritten name is added
+	// to the answer.
+	RestoreNames bool
+}
+
+// rewriteSuffix replaces the suffix from of name with to, if from starts with
+// "*." only names below it are rewritten
+func rewriteSuffix(name, from, to string) (string, bool) {
+	subdomainsOnly := strings.HasPrefix(from, "*.")
+	from, to = CanonicalName(strings.TrimPrefix(from, "*.")), CanonicalName(strings.TrimPrefix(to, "*."))
+	lower := strings.ToLower(name)
+	switch {
+	case lower == from && !subdomainsOnly:
+		return to, true
+	case from != "." && strings.HasSuffix(lower, "."+from):
+		return name[:len(name)-len(from)] + to, true
+	}
+	return "", false
+}
+
+// rewrite applies the rewrite with the most specific From matching the name of q,
+// returning nil if none of them match
+func (rr *RecursiveResolver) rewrite(q *Question) (*NameRewrite, error) {
+	var best *NameRewrite
+	var bestName string
+	for i := range rr.Rewrites {
+		nr := &rr.Rewrites[i]
+		name, ok := rewriteSuffix(q.Name, nr.From, nr.To)
+		if ok && (best == nil || len(strings.TrimPrefix(nr.From, "*.")) > len(strings.TrimPrefix(best.From, "*."))) {
+			best, bestName = nr, name
+		}
+	}
+	if best == nil {
+		return nil, nil
+	}
+	if _, ok := dns.IsDomainName(bestName); !ok || len(bestName) > 255 {
+		return nil, fmt.Errorf("%w: %s rewritten to %s", ErrNameRewrite, q.Name, bestName)
+	}
+	q.Name = bestName
+	return best, nil
+}
+
+// restore changes the answer for the rewritten name so it answers the name asked,
+// the records of a are copied rather than modified since they may be cached
+func (nr *NameRewrite) restore(asked, rewritten string, a *Answer) *Answer {
+	c := *a
+	if !nr.RestoreNames {
+		var ttl uint32
+		for i, r := range append(append([]dns.RR(nil), a.Answer...), a.Authority...) {
+			if i == 0 || r.Header().Ttl < ttl {
+				ttl = r.Header().Ttl
+			}
+		}
+		cname := &dns.CNAME{
+			Hdr:    dns.RR_Header{Name: asked, Rrtype: dns.TypeCNAME, Class: dns.ClassINET, Ttl: ttl},
+			Target: rewritten,
+		}
+		c.Answer = append([]dns.RR{cname}, a.Answer...)
+		return &c
+	}
+	c.Answer = make([]dns.RR, 0, len(a.Answer))
+	for _, r := range a.Answer {
+		owner, renamed := rewriteSuffix(r.Header().Name, nr.To, nr.From)
+		if renamed && r.Header().Rrtype == dns.TypeRRSIG {
+			continue
+		}
+		cname, isCNAME := r.(*dns.CNAME)
+		target, retarget := "", false
+		if isCNAME {
+			target, retarget = rewriteSuffix(cname.Target, nr.To, nr.From)
+		}
+		if !renamed && !retarget {
+			c.Answer = append(c.Answer, r)
+			continue
+		}
+		r = dns.Copy(r)
+		if renamed {
+			r.Header().Name = owner
+		}
+		if retarget {
+			r.(*dns.CNAME).Target = target
+		}
+		c.Answer = append(c.Answer, r)
+	}
+	return &c
+}
@@ -0,0 +1,92 @@
+package solvere
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func TestRewriteSuffix(t *testing.T) {
+	for _, tc := range []struct {
+		name, from, to, expected string
+	}{
+		{"www.old.corp.", "old.corp", "new.corp", "www.new.corp."},
+		{"Old.Corp.", "old.corp.", "new.corp.", "new.corp."},
+		{"a.b.OLD.corp.", "*.old.corp.", "*.new.corp.", "a.b.new.corp."},
+		{"old.corp.", "*.old.corp.", "*.new.corp.", ""},
+		{"notold.corp.", "old.corp.", "new.corp.", ""},
+		{"example.", ".", "new.corp.", ""},
+	} {
+		name, ok := rewriteSuffix(tc.name, tc.from, tc.to)
+		if name != tc.expected || ok != (tc.expected != "") {
+			t.Errorf("Expected %s rewritten by %s -> %s to be %q, got %q", tc.name, tc.from, tc.to, tc.expected, name)
+		}
+	}
+}
+
+func TestNameRewrites(t *testing.T) {
+	var asked []string
+	rr := NewResolver(WithCache(NewBasicCache()), WithValidation(false))
+	rr.Forward = &ForwardConfig{Servers: []string{"192.0.2.1:53"}, Attempts: 1}
+	rr.Transport = transportFunc(func(_ context.Context, m *dns.Msg, _ string) (*dns.Msg, error) {
+		name := m.Question[0].Name
+		asked = append(asked, name)
+		r := new(dns.Msg)
+		r.SetReply(m)
+		target := "web." + name[strings.IndexByte(name, '.')+1:]
+		r.Answer = []dns.RR{
+			&dns.CNAME{Hdr: dns.RR_Header{Name: name, Rrtype: dns.TypeCNAME, Class: dns.ClassINET, Ttl: 60}, Target: target},
+			&dns.RRSIG{Hdr: dns.RR_Header{Name: name, Rrtype: dns.TypeRRSIG, Class: dns.ClassINET, Ttl: 60}, TypeCovered: dns.TypeCNAME, Expiration: uint32(time.Now().Add(time.Hour).Unix())},
+			&dns.A{Hdr: dns.RR_Header{Name: target, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 30}, A: net.IP{1, 2, 3, 4}},
+		}
+		return r, nil
+	})
+	rr.Rewrites = []NameRewrite{
+		{From: "old.corp", To: "new.corp", RestoreNames: true},
+		{From: "*.lab.old.corp.", To: "*.lab.example."},
+	}
+
+	for i := 0; i < 2; i++ {
+		// the second lookup is answered from the cache, which mustn't have
+		// been modified
+		a, _, err := rr.Lookup(context.Background(), Question{Name: "www.old.corp.", Type: dns.TypeA})
+		if err != nil {
+			t.Fatalf("Lookup failed: %s", err)
+		}
+		if len(asked) != 1 || asked[0] != "www.new.corp." {
+			t.Fatalf("Unexpected names sent upstream: %v", asked)
+		}
+		if len(a.Answer) != 2 || a.Answer[0].Header().Name != "www.old.corp." || a.Answer[0].(*dns.CNAME).Target != "web.old.corp." || a.Answer[1].Header().Name != "web.old.corp." {
+			t.Fatalf("Names weren't restored: %v", a.Answer)
+		}
+		// answers are cached in the background
+		for j := 0; rr.cache.Get(&Question{Name: "www.new.corp.", Type: dns.TypeA}) == nil; j++ {
+			if j == 1000 {
+				t.Fatal("Answer for the rewritten name wasn't cached")
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	// the most specific rewrite is used, and adds a CNAME to the rewritten name
+	a, _, err := rr.Lookup(context.Background(), Question{Name: "db.lab.old.corp.", Type: dns.TypeA})
+	if err != nil {
+		t.Fatalf("Lookup failed: %s", err)
+	}
+	if asked[1] != "db.lab.example." {
+		t.Fatalf("Unexpected name sent upstream: %s", asked[1])
+	}
+	if len(a.Answer) != 4 || a.Answer[0].String() != "db.lab.old.corp.\t30\tIN\tCNAME\tdb.lab.example." {
+		t.Fatalf("Unexpected answer: %v", a.Answer)
+	}
+
+	rr.Rewrites = []NameRewrite{{From: "old.corp", To: strings.Repeat(strings.Repeat("a", 63)+".", 4)}}
+	if _, _, err := rr.Lookup(context.Background(), Question{Name: "www.old.corp.", Type: dns.TypeA}); err == nil || !errors.Is(err, ErrNameRewrite) {
+		t.Fatalf("Expected ErrNameRewrite, got %v", err)
+	}
+}
@@ -0,0 +1,283 @@
+package solvere
+
+import (
+	"bytes"
+	mrand "math/rand"
+	"sort"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// RRSet is a set of records sharing the same owner name, type, and class
+type RRSet struct {
+	Name    string
+	Type    uint16
+	Class   uint16
+	Records []dns.RR
+}
+
+// rrsetKey identifies the RRSet a record belongs to, names are compared case
+// insensitively
+type rrsetKey struct {
+	name  string
+	t     uint16
+	class uint16
+}
+
+func keyOf(r dns.RR) rrsetKey {
+	h := r.Header()
+	return rrsetKey{strings.ToLower(h.Name), h.Rrtype, h.Class}
+}
+
+// GroupRRSets groups records into RRSets by owner name, type, and class. The sets
+// are returned in the order their first record appears in records. RRSIG records
+// are grouped together by owner name and class, as a set of type TypeRRSIG, rather
+// than with the records they cover.
+func GroupRRSets(records []dns.RR) []RRSet {
+	var sets []RRSet
+	index := make(map[rrsetKey]int)
+	for _, r := range records {
+		k := keyOf(r)
+		i, present := index[k]
+		if !present {
+			h := r.Header()
+			i = len(sets)
+			index[k] = i
+			sets = append(sets, RRSet{Name: h.Name, Type: h.Rrtype, Class: h.Class})
+		}
+		sets[i].Records = append(sets[i].Records, r)
+	}
+	return sets
+}
+
+// CanonicalName returns name in the canonical form described in RFC 4034 Section 6.2,
+// fully qualified and lower cased
+func CanonicalName(name string) string {
+	return strings.ToLower(dns.Fqdn(name))
+}
+
+// CanonicalNameLess reports whether a sorts before b in the canonical DNS name order
+// described in RFC 4034 Section 6.1, which compares labels from the right as lower
+// cased octet strings
+func CanonicalNameLess(a, b string) bool {
+	al, bl := wireLabels(a), wireLabels(b)
+	for i, j := len(al)-1, len(bl)-1; i >= 0 && j >= 0; i, j = i-1, j-1 {
+		if c := bytes.Compare(al[i], bl[j]); c != 0 {
+			return c < 0
+		}
+	}
+	return len(al) < len(bl)
+}
+
+// wireLabels returns the lower cased wire format labels of name, so escaped
+// octets compare by value
+func wireLabels(name string) [][]byte {
+	wire := make([]byte, 256)
+	n, err := dns.PackDomainName(dns.Fqdn(name), wire, 0, nil, false)
+	if err != nil {
+		return nil
+	}
+	var labels [][]byte
+	for off := 0; off < n && wire[off] != 0; off += int(wire[off]) + 1 {
+		label := wire[off+1 : off+1+int(wire[off])]
+		for i, c := range label {
+			if c >= 'A' && c <= 'Z' {
+				label[i] = c + ('a' - 'A')
+			}
+		}
+		labels = append(labels, label)
+	}
+	return labels
+}
+
+// canonicalRR returns a copy of r in the canonical form described in RFC 4034
+// Section 6.2, with the owner name and any domain names in the RDATA lower cased
+func canonicalRR(r dns.RR) dns.RR {
+	c := dns.Copy(r)
+	c.Header().Name = strings.ToLower(c.Header().Name)
+	// the types listed in RFC 4034 Section 6.2, as corrected by RFC 6840 Section
+	// 5.1, which contain domain names
+	switch x := c.(type) {
+	case *dns.NS:
+		x.Ns = strings.ToLower(x.Ns)
+	case *dns.CNAME:
+		x.Target = strings.ToLower(x.Target)
+	case *dns.SOA:
+		x.Ns = strings.ToLower(x.Ns)
+		x.Mbox = strings.ToLower(x.Mbox)
+	case *dns.MB:
+		x.Mb = strings.ToLower(x.Mb)
+	case *dns.MG:
+		x.Mg = strings.ToLower(x.Mg)
+	case *dns.MR:
+		x.Mr = strings.ToLower(x.Mr)
+	case *dns.PTR:
+		x.Ptr = strings.ToLower(x.Ptr)
+	case *dns.MINFO:
+		x.Rmail = strings.ToLower(x.Rmail)
+		x.Email = strings.ToLower(x.Email)
+	case *dns.MX:
+		x.Mx = strings.ToLower(x.Mx)
+	case *dns.NAPTR:
+		x.Replacement = strings.ToLower(x.Replacement)
+	case *dns.KX:
+		x.Exchanger = strings.ToLower(x.Exchanger)
+	case *dns.SRV:
+		x.Target = strings.ToLower(x.Target)
+	case *dns.DNAME:
+		x.Target = strings.ToLower(x.Target)
+	case *dns.RRSIG:
+		x.SignerName = strings.ToLower(x.SignerName)
+	}
+	return c
+}
+
+// canonicalRdata returns the wire format of the RDATA of the canonical form of r
+func canonicalRdata(r dns.RR) ([]byte, error) {
+	return packRdata(canonicalRR(r))
+}
+
+// packRdata returns the uncompressed wire format of the RDATA of r
+func packRdata(r dns.RR) ([]byte, error) {
+	wire := make([]byte, dns.Len(r)+1)
+	off, err := dns.PackRR(r, wire, 0, nil, false)
+	if err != nil {
+		return nil, err
+	}
+	_, start, err := dns.UnpackDomainName(wire, 0)
+	if err != nil {
+		return nil, err
+	}
+	// skip the type, class, TTL, and RDLENGTH
+	return wire[start+10 : off], nil
+}
+
+// SortCanonical sorts the records of a RRSet into the canonical order described in
+// RFC 4034 Section 6.3, ordered by their canonical RDATA. Records which cannot be
+// packed sort last.
+func SortCanonical(rrset []dns.RR) {
+	rdata := make([][]byte, len(rrset))
+	for i, r := range rrset {
+		rdata[i], _ = canonicalRdata(r)
+	}
+	sort.Sort(canonicalSorter{rrset, rdata})
+}
+
+type canonicalSorter struct {
+	rrs   []dns.RR
+	rdata [][]byte
+}
+
+func (cs canonicalSorter) Len() int { return len(cs.rrs) }
+func (cs canonicalSorter) Swap(i, j int) {
+	cs.rrs[i], cs.rrs[j] = cs.rrs[j], cs.rrs[i]
+	cs.rdata[i], cs.rdata[j] = cs.rdata[j], cs.rdata[i]
+}
+func (cs canonicalSorter) Less(i, j int) bool {
+	if cs.rdata[i] == nil || cs.rdata[j] == nil {
+		return cs.rdata[j] == nil && cs.rdata[i] != nil
+	}
+	return bytes.Compare(cs.rdata[i], cs.rdata[j]) < 0
+}
+
+// WildcardOwner returns the wildcard name a record owned by name was expanded from
+// if the RRSIG sig shows it was synthesized from a wildcard (RFC 4035 Section 5.3.2),
+// i.e. the Labels field is less than the number of labels in name
+func WildcardOwner(name string, sig *dns.RRSIG) (string, bool) {
+	labels := dns.SplitDomainName(name)
+	if len(labels) <= int(sig.Labels) {
+		return "", false
+	}
+	return "*." + strings.Join(labels[len(labels)-int(sig.Labels):], ".") + ".", true
+}
+
+// HarmonizeTTLs sets the TTL of every record in each RRSet in records to the lowest
+// TTL in the set, as required by RFC 2181 Section 5.2. If a covering RRSIG is
+// present the TTLs are also capped at its original TTL (RFC 4035 Section 5.3.3).
+// The TTLs of RRSIG records are left alone since the signatures covering different
+// types are independent.
+func HarmonizeTTLs(records []dns.RR) {
+	sigTTLs := make(map[rrsetKey]uint32)
+	for _, r := range records {
+		if sig, ok := r.(*dns.RRSIG); ok {
+			k := rrsetKey{strings.ToLower(sig.Hdr.Name), sig.TypeCovered, sig.Hdr.Class}
+			if ttl, present := sigTTLs[k]; !present || sig.OrigTtl < ttl {
+				sigTTLs[k] = sig.OrigTtl
+			}
+		}
+	}
+	for _, set := range GroupRRSets(records) {
+		if set.Type == dns.TypeRRSIG {
+			continue
+		}
+		min := set.Records[0].Header().Ttl
+		for _, r := range set.Records[1:] {
+			if ttl := r.Header().Ttl; ttl < min {
+				min = ttl
+			}
+		}
+		if ttl, present := sigTTLs[rrsetKey{strings.ToLower(set.Name), set.Type, set.Class}]; present && ttl < min {
+			min = ttl
+		}
+		for _, r := range set.Records {
+			r.Header().Ttl = min
+		}
+	}
+}
+
+// AddressOrder controls the order of the records in A and AAAA RRSets served from
+// the cache
+type AddressOrder int
+
+const (
+	// AddressOrderFixed serves records in the order they were received
+	AddressOrderFixed AddressOrder = iota
+	// AddressOrderRotate moves each record to the front of its set in turn,
+	// like the round robin ordering of most authoritative nameservers
+	AddressOrderRotate
+	// AddressOrderRandom shuffles the records each time they are served
+	AddressOrderRandom
+)
+
+// orderAddresses returns a copy of records with the records of each A and AAAA
+// RRSet reordered according to order, other records are left in place. n is used
+// as the rotation offset for AddressOrderRotate.
+func orderAddresses(records []dns.RR, order AddressOrder, n int) []dns.RR {
+	if order == AddressOrderFixed {
+		return records
+	}
+	var ordered []dns.RR
+	positions := make(map[rrsetKey][]int)
+	for i, r := range records {
+		if t := r.Header().Rrtype; t == dns.TypeA || t == dns.TypeAAAA {
+			k := keyOf(r)
+			positions[k] = append(positions[k], i)
+		}
+	}
+	for _, p := range positions {
+		if len(p) < 2 {
+			continue
+		}
+		if ordered == nil {
+			ordered = make([]dns.RR, len(records))
+			copy(ordered, records)
+		}
+		perm := make([]int, len(p))
+		switch order {
+		case AddressOrderRotate:
+			for i := range perm {
+				perm[i] = (i + n) % len(p)
+			}
+		case AddressOrderRandom:
+			perm = mrand.Perm(len(p))
+		}
+		for i, j := range perm {
+			ordered[p[i]] = records[p[j]]
+		}
+	}
+	if ordered == nil {
+		return records
+	}
+	return ordered
+}
@@ -0,0 +1,113 @@
+package solvere
+
+import (
+	"net"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestGroupRRSets(t *testing.T) {
+	records := []dns.RR{
+		&dns.A{Hdr: dns.RR_Header{Name: "a.com.", Rrtype: dns.TypeA, Class: dns.ClassINET}, A: net.IP{1, 2, 3, 4}},
+		&dns.AAAA{Hdr: dns.RR_Header{Name: "a.com.", Rrtype: dns.TypeAAAA, Class: dns.ClassINET}, AAAA: net.ParseIP("::1")},
+		&dns.A{Hdr: dns.RR_Header{Name: "A.com.", Rrtype: dns.TypeA, Class: dns.ClassINET}, A: net.IP{1, 2, 3, 5}},
+		&dns.A{Hdr: dns.RR_Header{Name: "b.com.", Rrtype: dns.TypeA, Class: dns.ClassINET}, A: net.IP{1, 2, 3, 6}},
+	}
+	sets := GroupRRSets(records)
+	if len(sets) != 3 {
+		t.Fatalf("Expected 3 RRSets, got %d", len(sets))
+	}
+	if sets[0].Name != "a.com." || sets[0].Type != dns.TypeA || len(sets[0].Records) != 2 {
+		t.Fatalf("Unexpected first RRSet: %#v", sets[0])
+	}
+	if sets[1].Type != dns.TypeAAAA || sets[2].Name != "b.com." {
+		t.Fatalf("RRSets weren't returned in order of appearance: %#v", sets)
+	}
+}
+
+func TestCanonicalNameLess(t *testing.T) {
+	// RFC 4034 Section 6.1 example
+	ordered := []string{
+		"example.", "a.example.", "yljkjljk.a.example.", "Z.a.example.",
+		"zABC.a.EXAMPLE.", "z.example.", "\\001.z.example.", "*.z.example.", "\\200.z.example.",
+	}
+	for i := 0; i < len(ordered)-1; i++ {
+		if !CanonicalNameLess(ordered[i], ordered[i+1]) || CanonicalNameLess(ordered[i+1], ordered[i]) {
+			t.Errorf("%s doesn't sort before %s", ordered[i], ordered[i+1])
+		}
+	}
+}
+
+func TestSortCanonical(t *testing.T) {
+	rrset := []dns.RR{
+		&dns.MX{Hdr: dns.RR_Header{Name: "a.com.", Rrtype: dns.TypeMX, Class: dns.ClassINET}, Preference: 10, Mx: "b.com."},
+		&dns.MX{Hdr: dns.RR_Header{Name: "a.com.", Rrtype: dns.TypeMX, Class: dns.ClassINET}, Preference: 10, Mx: "A.com."},
+		&dns.MX{Hdr: dns.RR_Header{Name: "a.com.", Rrtype: dns.TypeMX, Class: dns.ClassINET}, Preference: 5, Mx: "z.com."},
+	}
+	SortCanonical(rrset)
+	for i, expected := range []string{"z.com.", "A.com.", "b.com."} {
+		if mx := rrset[i].(*dns.MX).Mx; mx != expected {
+			t.Fatalf("Unexpected record at position %d after sorting: %s", i, mx)
+		}
+	}
+}
+
+func TestWildcardOwner(t *testing.T) {
+	sig := &dns.RRSIG{Labels: 2}
+	if owner, ok := WildcardOwner("a.b.example.com.", sig); !ok || owner != "*.example.com." {
+		t.Fatalf("Unexpected wildcard owner: %q %t", owner, ok)
+	}
+	if _, ok := WildcardOwner("example.com.", sig); ok {
+		t.Fatal("WildcardOwner found a wildcard for a name with the same number of labels as the signature")
+	}
+}
+
+func TestHarmonizeTTLs(t *testing.T) {
+	records := []dns.RR{
+		&dns.A{Hdr: dns.RR_Header{Name: "a.com.", Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 300}, A: net.IP{1, 2, 3, 4}},
+		&dns.A{Hdr: dns.RR_Header{Name: "a.com.", Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 200}, A: net.IP{1, 2, 3, 5}},
+		&dns.TXT{Hdr: dns.RR_Header{Name: "a.com.", Rrtype: dns.TypeTXT, Class: dns.ClassINET, Ttl: 300}, Txt: []string{"hi"}},
+		&dns.RRSIG{Hdr: dns.RR_Header{Name: "a.com.", Rrtype: dns.TypeRRSIG, Class: dns.ClassINET, Ttl: 300}, TypeCovered: dns.TypeTXT, OrigTtl: 100},
+	}
+	HarmonizeTTLs(records)
+	for i, expected := range []uint32{200, 200, 100, 300} {
+		if ttl := records[i].Header().Ttl; ttl != expected {
+			t.Fatalf("Unexpected TTL for record %d: %d, expected %d", i, ttl, expected)
+		}
+	}
+}
+
+func TestOrderAddresses(t *testing.T) {
+	a := func(name string, last byte) dns.RR {
+		return &dns.A{Hdr: dns.RR_Header{Name: name, Rrtype: dns.TypeA, Class: dns.ClassINET}, A: net.IP{10, 0, 0, last}}
+	}
+	cname := &dns.CNAME{Hdr: dns.RR_Header{Name: "a.com.", Rrtype: dns.TypeCNAME, Class: dns.ClassINET}, Target: "b.com."}
+	records := []dns.RR{cname, a("b.com.", 1), a("b.com.", 2), a("b.com.", 3), a("c.com.", 4)}
+	if ordered := orderAddresses(records, AddressOrderFixed, 1); ordered[1] != records[1] {
+		t.Fatal("AddressOrderFixed reordered the records")
+	}
+	for n, first := range []byte{1, 2, 3, 1} {
+		ordered := orderAddresses(records, AddressOrderRotate, n)
+		if ordered[0] != cname || ordered[4] != records[4] {
+			t.Fatalf("Records outside of the address RRSet were moved: %v", ordered)
+		}
+		if got := ordered[1].(*dns.A).A[3]; got != first {
+			t.Fatalf("Expected 10.0.0.%d first after %d rotations, got 10.0.0.%d", first, n, got)
+		}
+	}
+	if records[1].(*dns.A).A[3] != 1 {
+		t.Fatal("orderAddresses modified the original records")
+	}
+	seen := make(map[byte]bool)
+	for i := 0; i < 100; i++ {
+		ordered := orderAddresses(records, AddressOrderRandom, 0)
+		if len(ordered) != len(records) {
+			t.Fatalf("Expected %d records, got %d", len(records), len(ordered))
+		}
+		seen[ordered[1].(*dns.A).A[3]] = true
+	}
+	if len(seen) != 3 {
+		t.Fatalf("AddressOrderRandom didn't move every record to the front, saw %v", seen)
+	}
+}
@@ -0,0 +1,202 @@
+package solvere
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// ErrMalformedResponse is returned when a response from a remote nameserver
+// contains records that can't legally appear together, unless PermissiveResponses
+// is set
+var ErrMalformedResponse = errors.New("solvere: Structurally invalid response")
+
+// checkResponse rejects responses whose contents can't legally appear together:
+// records of a class other than IN, more than one SOA record in a section, a CNAME
+// record alongside other data for the same name (RFC 1034 Section 3.6.2, RFC 2181
+// Section 10.1), or answer records for names unrelated to the question q.
+func checkResponse(r *dns.Msg, q *Question) error {
+	for _, section := range [][]dns.RR{r.Answer, r.Ns, r.Extra} {
+		soas := 0
+		for _, record := range section {
+			h := record.Header()
+			switch h.Rrtype {
+			case dns.TypeOPT, dns.TypeTSIG:
+				continue
+			case dns.TypeSOA:
+				soas++
+			}
+			if h.Class != dns.ClassINET {
+				return fmt.Errorf("%w: %s record for %s has class %s", ErrMalformedResponse, dns.TypeToString[h.Rrtype], h.Name, dns.Class(h.Class))
+			}
+		}
+		if soas > 1 {
+			return fmt.Errorf("%w: more than one SOA record in a section", ErrMalformedResponse)
+		}
+	}
+	cnames := make(map[string]int)
+	other := make(map[string]uint16)
+	for _, record := range r.Answer {
+		name := strings.ToLower(record.Header().Name)
+		switch t := record.Header().Rrtype; t {
+		case dns.TypeCNAME:
+			cnames[name]++
+		case dns.TypeRRSIG, dns.TypeNSEC, dns.TypeNSEC3:
+			// DNSSEC records may accompany a CNAME (RFC 4035 Section 2.5)
+		default:
+			other[name] = t
+		}
+	}
+	for name, n := range cnames {
+		if n > 1 {
+			return fmt.Errorf("%w: multiple CNAME records for %s", ErrMalformedResponse, name)
+		}
+		if t, present := other[name]; present {
+			return fmt.Errorf("%w: CNAME record for %s alongside %s record", ErrMalformedResponse, name, dns.TypeToString[t])
+		}
+	}
+	names := aliasChain(r.Answer, q.Name, ".")
+	for _, record := range r.Answer {
+		name := strings.ToLower(record.Header().Name)
+		if mapHas(names, name) || isDNAME(record) && ownsAlias(names, name) {
+			continue
+		}
+		return fmt.Errorf("%w: answer for %s doesn't relate to the question %s", ErrMalformedResponse, record.Header().Name, q.Name)
+	}
+	return nil
+}
+
+// isDNAME checks if record is a DNAME, or a signature covering one
+func isDNAME(record dns.RR) bool {
+	if sig, ok := record.(*dns.RRSIG); ok {
+		return sig.TypeCovered == dns.TypeDNAME
+	}
+	return record.Header().Rrtype == dns.TypeDNAME
+}
+
+// ownsAlias checks if owner is an ancestor of one of names
+func ownsAlias(names map[string]struct{}, owner string) bool {
+	for n := range names {
+		if n != owner && isSubdomain(n, owner) {
+			return true
+		}
+	}
+	return false
+}
+
+// scrubResponse removes records from a response which aren't within the bailiwick of
+// zone, the zone of the nameserver that sent it, or which aren't relevant to the
+// question q, rather than trusting whatever the server put in the message. Whole
+// RRsets are removed so the signatures of the remaining sets still validate. It
+// returns the number of records removed.
+func scrubResponse(r *dns.Msg, q *Question, zone string) int {
+	before := len(r.Answer) + len(r.Ns) + len(r.Extra)
+	names := aliasChain(r.Answer, q.Name, zone)
+	r.Answer = scrubSection(r.Answer, zone, func(name string, t uint16) bool {
+		if t == dns.TypeDNAME {
+			return ownsAlias(names, name)
+		}
+		_, relevant := names[name]
+		return relevant && (q.Type == dns.TypeANY || t == q.Type || t == dns.TypeCNAME)
+	})
+	r.Ns = scrubSection(r.Ns, zone, func(name string, t uint16) bool {
+		switch t {
+		case dns.TypeNSEC, dns.TypeNSEC3:
+			return true
+		case dns.TypeNS, dns.TypeDS, dns.TypeSOA:
+			// only the delegations and zone apexes on the path to the
+			// question are relevant
+			for n := range names {
+				if isSubdomain(n, name) {
+					return true
+				}
+			}
+		}
+		return false
+	})
+	targets := targetNames(r.Answer, r.Ns)
+	r.Extra = scrubSection(r.Extra, zone, func(name string, t uint16) bool {
+		if t != dns.TypeA && t != dns.TypeAAAA {
+			return false
+		}
+		_, referenced := targets[name]
+		return referenced
+	})
+	return before - len(r.Answer) - len(r.Ns) - len(r.Extra)
+}
+
+// scrubSection returns the records from section which are in the bailiwick of zone
+// and for which keep returns true, given the lower cased owner name and type (or the
+// covered type for RRSIGs). OPT and TSIG records are always kept.
+func scrubSection(section []dns.RR, zone string, keep func(name string, t uint16) bool) []dns.RR {
+	out := section[:0]
+	for _, record := range section {
+		h := record.Header()
+		if h.Rrtype == dns.TypeOPT || h.Rrtype == dns.TypeTSIG {
+			out = append(out, record)
+			continue
+		}
+		t := h.Rrtype
+		if sig, ok := record.(*dns.RRSIG); ok {
+			t = sig.TypeCovered
+		}
+		name := strings.ToLower(h.Name)
+		if isSubdomain(name, zone) && keep(name, t) {
+			out = append(out, record)
+		}
+	}
+	for i := len(out); i < len(section); i++ {
+		section[i] = nil
+	}
+	return out
+}
+
+// aliasChain returns the lower cased set of names reached by following the in
+// bailiwick CNAMEs in answer from qname
+func aliasChain(answer []dns.RR, qname, zone string) map[string]struct{} {
+	names := map[string]struct{}{strings.ToLower(qname): {}}
+	for grown := true; grown; {
+		grown = false
+		for _, record := range answer {
+			cname, ok := record.(*dns.CNAME)
+			if !ok || !isSubdomain(cname.Hdr.Name, zone) {
+				continue
+			}
+			if _, present := names[strings.ToLower(cname.Hdr.Name)]; !present {
+				continue
+			}
+			if target := strings.ToLower(cname.Target); !mapHas(names, target) {
+				names[target] = struct{}{}
+				grown = true
+			}
+		}
+	}
+	return names
+}
+
+func mapHas(m map[string]struct{}, k string) bool {
+	_, present := m[k]
+	return present
+}
+
+// targetNames returns the lower cased names referenced by the NS, MX and SRV records
+// in sections, which are the only names address records in the additional section
+// are relevant for
+func targetNames(sections ...[]dns.RR) map[string]struct{} {
+	targets := map[string]struct{}{}
+	for _, section := range sections {
+		for _, record := range section {
+			switch rec := record.(type) {
+			case *dns.NS:
+				targets[strings.ToLower(rec.Ns)] = struct{}{}
+			case *dns.MX:
+				targets[strings.ToLower(rec.Mx)] = struct{}{}
+			case *dns.SRV:
+				targets[strings.ToLower(rec.Target)] = struct{}{}
+			}
+		}
+	}
+	return targets
+}
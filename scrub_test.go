@@ -0,0 +1,158 @@
+package solvere
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestScrubResponse(t *testing.T) {
+	hdr := func(name string, t uint16) dns.RR_Header {
+		return dns.RR_Header{Name: name, Rrtype: t, Class: dns.ClassINET, Ttl: 60}
+	}
+	r := new(dns.Msg)
+	r.Answer = []dns.RR{
+		&dns.CNAME{Hdr: hdr("www.example.com.", dns.TypeCNAME), Target: "WEB.example.com."},
+		&dns.A{Hdr: hdr("web.example.com.", dns.TypeA), A: net.IP{1, 2, 3, 4}},
+		&dns.RRSIG{Hdr: hdr("web.example.com.", dns.TypeRRSIG), TypeCovered: dns.TypeA},
+		// irrelevant to the question
+		&dns.A{Hdr: hdr("other.example.com.", dns.TypeA), A: net.IP{1, 2, 3, 5}},
+		&dns.MX{Hdr: hdr("web.example.com.", dns.TypeMX), Mx: "mail.example.com."},
+		&dns.RRSIG{Hdr: hdr("web.example.com.", dns.TypeRRSIG), TypeCovered: dns.TypeMX},
+		// out of bailiwick
+		&dns.A{Hdr: hdr("www.bank.com.", dns.TypeA), A: net.IP{6, 6, 6, 6}},
+	}
+	r.Ns = []dns.RR{
+		&dns.NS{Hdr: hdr("example.com.", dns.TypeNS), Ns: "ns1.example.com."},
+		&dns.NS{Hdr: hdr("other.example.com.", dns.TypeNS), Ns: "ns1.example.com."},
+		&dns.NS{Hdr: hdr("com.", dns.TypeNS), Ns: "ns.evil.net."},
+		&dns.NSEC{Hdr: hdr("a.example.com.", dns.TypeNSEC), NextDomain: "b.example.com."},
+	}
+	r.Extra = []dns.RR{
+		&dns.A{Hdr: hdr("ns1.example.com.", dns.TypeA), A: net.IP{1, 1, 1, 1}},
+		&dns.A{Hdr: hdr("mail.example.com.", dns.TypeA), A: net.IP{1, 1, 1, 2}},
+		&dns.A{Hdr: hdr("ns.evil.net.", dns.TypeA), A: net.IP{6, 6, 6, 6}},
+		&dns.TXT{Hdr: hdr("ns1.example.com.", dns.TypeTXT), Txt: []string{"hi"}},
+	}
+	r.SetEdns0(4096, false)
+
+	removed := scrubResponse(r, &Question{Name: "www.example.com.", Type: dns.TypeA}, "example.com.")
+	if removed != 9 {
+		t.Fatalf("Expected 9 records to be scrubbed, got %d: %s", removed, r)
+	}
+	if len(r.Answer) != 3 || r.Answer[1].(*dns.A).A.String() != "1.2.3.4" {
+		t.Fatalf("Unexpected answer section after scrubbing: %v", r.Answer)
+	}
+	if len(r.Ns) != 2 || r.Ns[0].Header().Name != "example.com." || r.Ns[1].Header().Rrtype != dns.TypeNSEC {
+		t.Fatalf("Unexpected authority section after scrubbing: %v", r.Ns)
+	}
+	if len(r.Extra) != 2 || r.Extra[0].Header().Name != "ns1.example.com." || r.IsEdns0() == nil {
+		t.Fatalf("Unexpected additional section after scrubbing: %v", r.Extra)
+	}
+
+	// DNAMEs above the question, and their synthesized CNAMEs, are kept
+	r.Answer = []dns.RR{
+		&dns.DNAME{Hdr: hdr("example.com.", dns.TypeDNAME), Target: "example.net."},
+		&dns.CNAME{Hdr: hdr("www.example.com.", dns.TypeCNAME), Target: "www.example.net."},
+		&dns.DNAME{Hdr: hdr("other.example.com.", dns.TypeDNAME), Target: "example.org."},
+	}
+	if removed = scrubResponse(r, &Question{Name: "www.example.com.", Type: dns.TypeA}, "."); removed != 1 || len(r.Answer) != 2 {
+		t.Fatalf("Unexpected answer section after scrubbing aliases: %v", r.Answer)
+	}
+}
+
+func TestCheckResponse(t *testing.T) {
+	hdr := func(name string, t uint16) dns.RR_Header {
+		return dns.RR_Header{Name: name, Rrtype: t, Class: dns.ClassINET, Ttl: 60}
+	}
+	soa := func(name string) dns.RR {
+		return &dns.SOA{Hdr: hdr(name, dns.TypeSOA), Ns: "ns.example.com.", Mbox: "host.example.com."}
+	}
+	q := &Question{Name: "www.example.com.", Type: dns.TypeA}
+	chaos := &dns.A{Hdr: hdr("www.example.com.", dns.TypeA), A: net.IP{1, 2, 3, 4}}
+	chaos.Hdr.Class = dns.ClassCHAOS
+	for _, tc := range []struct {
+		name   string
+		answer []dns.RR
+		ns     []dns.RR
+		valid  bool
+	}{
+		{
+			name: "alias chain",
+			answer: []dns.RR{
+				&dns.DNAME{Hdr: hdr("example.com.", dns.TypeDNAME), Target: "example.net."},
+				&dns.CNAME{Hdr: hdr("www.example.com.", dns.TypeCNAME), Target: "www.example.net."},
+				&dns.RRSIG{Hdr: hdr("www.example.com.", dns.TypeRRSIG), TypeCovered: dns.TypeCNAME},
+				&dns.A{Hdr: hdr("www.example.net.", dns.TypeA), A: net.IP{1, 2, 3, 4}},
+			},
+			valid: true,
+		},
+		{
+			name:  "negative answer",
+			ns:    []dns.RR{soa("example.com.")},
+			valid: true,
+		},
+		{
+			name: "CNAME and other data",
+			answer: []dns.RR{
+				&dns.CNAME{Hdr: hdr("www.example.com.", dns.TypeCNAME), Target: "web.example.com."},
+				&dns.A{Hdr: hdr("www.example.com.", dns.TypeA), A: net.IP{1, 2, 3, 4}},
+			},
+		},
+		{
+			name: "multiple CNAMEs",
+			answer: []dns.RR{
+				&dns.CNAME{Hdr: hdr("www.example.com.", dns.TypeCNAME), Target: "a.example.com."},
+				&dns.CNAME{Hdr: hdr("www.example.com.", dns.TypeCNAME), Target: "b.example.com."},
+			},
+		},
+		{
+			name: "multiple SOAs",
+			ns:   []dns.RR{soa("example.com."), soa("com.")},
+		},
+		{
+			name:   "unrelated answer",
+			answer: []dns.RR{&dns.A{Hdr: hdr("bank.com.", dns.TypeA), A: net.IP{6, 6, 6, 6}}},
+		},
+		{
+			name:   "wrong class",
+			answer: []dns.RR{chaos},
+		},
+	} {
+		r := new(dns.Msg)
+		r.Answer, r.Ns = tc.answer, tc.ns
+		r.SetEdns0(4096, false)
+		err := checkResponse(r, q)
+		if tc.valid && err != nil {
+			t.Errorf("%s: checkResponse failed: %s", tc.name, err)
+		} else if !tc.valid && err == nil {
+			t.Errorf("%s: checkResponse accepted an invalid response", tc.name)
+		}
+	}
+}
+
+func TestPermissiveResponses(t *testing.T) {
+	rr := NewRecursiveResolver(false, false, nil, nil, nil)
+	rr.Forward = &ForwardConfig{Servers: []string{"10.0.0.1"}, Attempts: 1}
+	// the failure of the first lookup shouldn't be remembered for the second
+	rr.FailureTTL = -1
+	rr.Transport = transportFunc(func(_ context.Context, m *dns.Msg, _ string) (*dns.Msg, error) {
+		r := new(dns.Msg)
+		r.SetReply(m)
+		hdr := dns.RR_Header{Name: m.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60}
+		r.Answer = []dns.RR{&dns.A{Hdr: hdr, A: net.IP{1, 2, 3, 4}}}
+		hdr.Rrtype = dns.TypeCNAME
+		r.Answer = append(r.Answer, &dns.CNAME{Hdr: hdr, Target: "b.example."})
+		return r, nil
+	})
+	q := Question{Name: "a.example.", Type: dns.TypeA}
+	if _, _, err := rr.Lookup(context.Background(), q); err == nil {
+		t.Fatal("Lookup accepted a CNAME alongside other data")
+	}
+	rr.PermissiveResponses = true
+	if _, _, err := rr.Lookup(context.Background(), q); err != nil {
+		t.Fatalf("Lookup failed with PermissiveResponses set: %s", err)
+	}
+}
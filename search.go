@@ -0,0 +1,196 @@
+package solvere
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jmhodges/clock"
+	"github.com/miekg/dns"
+)
+
+// MaxSearchNegativeEntries is the maximum number of candidate names a
+// SearchResolver remembers don't exist
+const MaxSearchNegativeEntries = 10000
+
+// searchNames returns the fully qualified names that should be tried, in order,
+// when looking up name using the search list and ndots setting
+func searchNames(name string, search []string, ndots int) []string {
+	if dns.IsFqdn(name) {
+		return []string{name}
+	}
+	var names []string
+	absolute := strings.Count(name, ".") >= ndots
+	if absolute {
+		names = append(names, name+".")
+	}
+	for _, s := range search {
+		names = append(names, name+"."+strings.TrimPrefix(dns.Fqdn(s), "."))
+	}
+	if !absolute {
+		names = append(names, name+".")
+	}
+	return names
+}
+
+type searchEntry struct {
+	answer  *Answer
+	expires time.Time
+}
+
+// SearchResolver looks up names which may not be fully qualified the way a stub
+// resolver configured by resolv.conf(5) does, trying the names produced by
+// appending each of the Search domains in order. Names with at least Ndots dots
+// are tried as they are first, others after the search list, and names ending in
+// a dot are only tried as they are. Candidates which don't exist, or don't have
+// records of the type asked, are remembered for the TTL of their negative answer
+// so later lookups skip straight to the names which do.
+type SearchResolver struct {
+	Resolver *RecursiveResolver
+	// Search is the list of domains appended to names
+	Search []string
+	// Ndots is the number of dots a name must contain to be tried as it is
+	// before the search list
+	Ndots int
+	// StrictErrors stops the search when a lookup fails, rather than only when
+	// it is answered, as net.Resolver.StrictErrors does
+	StrictErrors bool
+
+	mu       sync.Mutex
+	negative map[Question]searchEntry
+	clk      clock.Clock
+}
+
+// NewSearchResolver returns a SearchResolver which uses rr to look up the names
+// produced by search and ndots
+func NewSearchResolver(rr *RecursiveResolver, search []string, ndots int) *SearchResolver {
+	return &SearchResolver{
+		Resolver: rr,
+		Search:   search,
+		Ndots:    ndots,
+		clk:      clock.Default(),
+	}
+}
+
+// SearchResolver returns a SearchResolver which uses rr with the search list and
+// ndots setting of rc
+func (rc *ResolvConf) SearchResolver(rr *RecursiveResolver) *SearchResolver {
+	return NewSearchResolver(rr, rc.Search, rc.Ndots)
+}
+
+// Names returns the fully qualified names tried, in order, when looking up name
+func (sr *SearchResolver) Names(name string) []string {
+	return searchNames(name, sr.Search, sr.Ndots)
+}
+
+// Lookup looks up the records of type qtype for name, trying each of the names
+// returned by Names until one of them has records of that type, and returns the
+// question which was answered along with its answer and the LookupLog of the
+// last lookup performed. If none of them do the negative answer for the last name
+// is returned, unless a lookup failed in which case the first error is.
+func (sr *SearchResolver) Lookup(ctx context.Context, name string, qtype uint16) (Question, *Answer, *LookupLog, error) {
+	var last Question
+	var negative *Answer
+	var ll *LookupLog
+	var firstErr error
+	for _, candidate := range sr.Names(name) {
+		q := Question{Name: candidate, Type: qtype}
+		last = q
+		if a := sr.negativeAnswer(q); a != nil {
+			negative = a
+			continue
+		}
+		var a *Answer
+		var err error
+		a, ll, err = sr.Resolver.Lookup(ctx, q)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			if sr.StrictErrors || ctx.Err() != nil {
+				break
+			}
+			continue
+		}
+		if !isNegativeAnswer(qtype, a) {
+			return q, a, ll, nil
+		}
+		sr.addNegative(q, a)
+		negative = a
+	}
+	if firstErr != nil {
+		return last, nil, ll, firstErr
+	}
+	return last, negative, ll, nil
+}
+
+// isNegativeAnswer checks if a is NXDOMAIN, or doesn't contain any records of type
+// qtype
+func isNegativeAnswer(qtype uint16, a *Answer) bool {
+	if a.Rcode == dns.RcodeNameError {
+		return true
+	}
+	if a.Rcode != dns.RcodeSuccess {
+		return false
+	}
+	for _, r := range a.Answer {
+		if t := r.Header().Rrtype; t == qtype || (qtype == dns.TypeANY && t != dns.TypeRRSIG) {
+			return false
+		}
+	}
+	return true
+}
+
+func (sr *SearchResolver) clock() clock.Clock {
+	if sr.clk == nil {
+		return clock.Default()
+	}
+	return sr.clk
+}
+
+// negativeAnswer returns the remembered negative answer for q, names which don't
+// exist are remembered for every type
+func (sr *SearchResolver) negativeAnswer(q Question) *Answer {
+	sr.mu.Lock()
+	defer sr.mu.Unlock()
+	now := sr.clock().Now()
+	for _, key := range []Question{{Name: strings.ToLower(q.Name)}, bogusKey(q)} {
+		if e, present := sr.negative[key]; present {
+			if now.Before(e.expires) {
+				return e.answer
+			}
+			delete(sr.negative, key)
+		}
+	}
+	return nil
+}
+
+// addNegative remembers the negative answer for q for its TTL
+func (sr *SearchResolver) addNegative(q Question, a *Answer) {
+	ttl := answerTTL(a, sr.clock())
+	if ttl <= 0 {
+		return
+	}
+	key := bogusKey(q)
+	if a.Rcode == dns.RcodeNameError {
+		key.Type = 0
+	}
+	now := sr.clock().Now()
+	sr.mu.Lock()
+	defer sr.mu.Unlock()
+	if sr.negative == nil {
+		sr.negative = make(map[Question]searchEntry)
+	}
+	if len(sr.negative) >= MaxSearchNegativeEntries {
+		for k, e := range sr.negative {
+			if !now.Before(e.expires) {
+				delete(sr.negative, k)
+			}
+		}
+		if len(sr.negative) >= MaxSearchNegativeEntries {
+			return
+		}
+	}
+	sr.negative[key] = searchEntry{answer: a, expires: now.Add(time.Duration(ttl) * time.Second)}
+}
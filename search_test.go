@@ -0,0 +1,86 @@
+package solvere
+
+import (
+	"context"
+	"errors"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jmhodges/clock"
+	"github.com/miekg/dns"
+)
+
+func TestSearchResolver(t *testing.T) {
+	var asked []string
+	rr := NewResolver(WithValidation(false))
+	rr.FailureTTL = -1
+	rr.Forward = &ForwardConfig{Servers: []string{"192.0.2.1:53"}, Attempts: 1}
+	rr.Transport = transportFunc(func(_ context.Context, m *dns.Msg, _ string) (*dns.Msg, error) {
+		name := m.Question[0].Name
+		asked = append(asked, name)
+		r := new(dns.Msg)
+		r.SetReply(m)
+		soa := &dns.SOA{Hdr: dns.RR_Header{Name: ".", Rrtype: dns.TypeSOA, Class: dns.ClassINET, Ttl: 60}, Minttl: 60}
+		switch {
+		case strings.HasPrefix(name, "broken."):
+			return nil, errors.New("timeout")
+		case name == "host.b.example." && m.Question[0].Qtype == dns.TypeA:
+			r.Answer = []dns.RR{&dns.A{Hdr: dns.RR_Header{Name: name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60}, A: net.IP{1, 2, 3, 4}}}
+		case name == "host.b.example.":
+			r.Ns = []dns.RR{soa}
+		default:
+			r.Rcode = dns.RcodeNameError
+			r.Ns = []dns.RR{soa}
+		}
+		return r, nil
+	})
+	fc := clock.NewFake()
+	sr := NewSearchResolver(rr, []string{"a.example", "b.example."}, 1)
+	sr.clk = fc
+
+	if names := sr.Names("host.sub"); !reflect.DeepEqual(names, []string{"host.sub.", "host.sub.a.example.", "host.sub.b.example."}) {
+		t.Fatalf("Unexpected names: %v", names)
+	}
+	q, a, _, err := sr.Lookup(context.Background(), "host", dns.TypeA)
+	if err != nil {
+		t.Fatalf("Lookup failed: %s", err)
+	}
+	if q.Name != "host.b.example." || len(a.Answer) != 1 || !reflect.DeepEqual(asked, []string{"host.a.example.", "host.b.example."}) {
+		t.Fatalf("Unexpected result %s %v, asked %v", q.Name, a.Answer, asked)
+	}
+
+	// the nonexistent candidate is remembered for every type, the name without
+	// any records of the type asked only for that type
+	asked = nil
+	q, a, _, err = sr.Lookup(context.Background(), "host", dns.TypeAAAA)
+	if err != nil {
+		t.Fatalf("Lookup failed: %s", err)
+	}
+	if q.Name != "host." || a.Rcode != dns.RcodeNameError || !reflect.DeepEqual(asked, []string{"host.b.example.", "host."}) {
+		t.Fatalf("Unexpected result %s %d, asked %v", q.Name, a.Rcode, asked)
+	}
+	asked = nil
+	if _, _, _, err = sr.Lookup(context.Background(), "host", dns.TypeAAAA); err != nil || len(asked) != 0 {
+		t.Fatalf("Negative answers weren't remembered, asked %v: %v", asked, err)
+	}
+	fc.Add(time.Minute)
+	if sr.negativeAnswer(Question{Name: "host.a.example.", Type: dns.TypeA}) != nil {
+		t.Fatal("Negative answer wasn't forgotten after its TTL")
+	}
+
+	// failures are returned once every name has been tried, unless errors are
+	// strict
+	asked = nil
+	sr.Search = []string{"x.example."}
+	if _, _, _, err = sr.Lookup(context.Background(), "broken", dns.TypeA); err == nil || len(asked) != 2 {
+		t.Fatalf("Expected the failure after trying every name, asked %v: %v", asked, err)
+	}
+	asked = nil
+	sr.StrictErrors = true
+	if _, _, _, err = sr.Lookup(context.Background(), "broken.host", dns.TypeA); err == nil || len(asked) != 1 {
+		t.Fatalf("Expected the search to stop at the failure, asked %v: %v", asked, err)
+	}
+}
@@ -0,0 +1,369 @@
+package solvere
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// ErrServerClosed is returned by Server.ListenAndServe and Server.Serve after
+// Server.Shutdown has been called
+var ErrServerClosed = errors.New("solvere: Server closed")
+
+var (
+	// DefaultIdleTimeout is the default amount of time a idle TCP or TLS connection
+	// is kept open waiting for further queries
+	DefaultIdleTimeout = 10 * time.Second
+
+	dotPort = "853"
+)
+
+// Server serves DNS queries over UDP and TCP, or DNS-over-TLS, using a Handler
+type Server struct {
+	// Addr is the address to listen on, ":53" if empty, or ":853" if empty when
+	// using ListenAndServeTLS
+	Addr string
+	// Handler is used to answer queries, generally a *Handler
+	Handler dns.Handler
+	// ReadTimeout and WriteTimeout are the network timeouts used for reading
+	// queries and writing responses, if zero the dns package defaults are used
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+	// IdleTimeout is how long a idle TCP or TLS connection is kept open waiting
+	// for further queries, if zero DefaultIdleTimeout is used
+	IdleTimeout time.Duration
+	// MaxConnections limits the number of concurrently open TCP or TLS connections,
+	// further connections are not accepted until existing ones are closed. If zero
+	// there is no limit.
+	MaxConnections int
+	// TLSConfig is used by ListenAndServeTLS, it must contain at least one
+	// certificate unless certificate and key files are passed to ListenAndServeTLS
+	TLSConfig *tls.Config
+	// DontFragment causes the don't fragment bit to be set on UDP responses, so
+	// responses too large for the path are lost rather than fragmented, it has
+	// no effect on platforms other than Linux. Responses are already limited to
+	// the MaxUDPSize of a Handler.
+	DontFragment bool
+
+	mu       sync.Mutex
+	servers  []*dns.Server
+	closed   bool
+	inFlight sync.WaitGroup
+}
+
+// NewServer returns a Server that listens on addr and answers queries using rr
+func NewServer(addr string, rr *RecursiveResolver) *Server {
+	return &Server{Addr: addr, Handler: NewHandler(rr)}
+}
+
+// ListenAndServe listens on the UDP and TCP addresses s.Addr and serves queries
+// until Shutdown is called or either listener fails.
+func (s *Server) ListenAndServe() error {
+	addr := s.Addr
+	if addr == "" {
+		addr = ":" + dnsPort
+	}
+	pc, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return err
+	}
+	// use the port the UDP listener was bound to in case addr has port zero
+	l, err := net.Listen("tcp", pc.LocalAddr().String())
+	if err != nil {
+		pc.Close()
+		return err
+	}
+	return s.Serve(pc, l)
+}
+
+// ListenAndServeTLS listens on the TCP address s.Addr and serves DNS-over-TLS
+// (RFC 7858) queries until Shutdown is called or the listener fails. If certFile
+// and keyFile are not empty the certificate and key they contain are added to a
+// copy of s.TLSConfig.
+func (s *Server) ListenAndServeTLS(certFile, keyFile string) error {
+	config, err := s.tlsConfig(certFile, keyFile)
+	if err != nil {
+		return err
+	}
+	addr := s.Addr
+	if addr == "" {
+		addr = ":" + dotPort
+	}
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(nil, tls.NewListener(l, config))
+}
+
+// ServeTLS serves DNS-over-TLS queries received on the TCP listener l, such as one
+// inherited using SystemdSockets, until Shutdown is called or the listener fails.
+// The certificates are configured as for ListenAndServeTLS. ServeTLS takes
+// ownership of l.
+func (s *Server) ServeTLS(l net.Listener, certFile, keyFile string) error {
+	config, err := s.tlsConfig(certFile, keyFile)
+	if err != nil {
+		l.Close()
+		return err
+	}
+	return s.Serve(nil, tls.NewListener(l, config))
+}
+
+func (s *Server) tlsConfig(certFile, keyFile string) (*tls.Config, error) {
+	config := &tls.Config{}
+	if s.TLSConfig != nil {
+		config = s.TLSConfig.Clone()
+	}
+	if certFile != "" || keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = append(config.Certificates, cert)
+	}
+	if len(config.Certificates) == 0 && config.GetCertificate == nil {
+		return nil, errors.New("solvere: No TLS certificate configured")
+	}
+	return config, nil
+}
+
+// Serve serves queries received on the UDP socket pc and the TCP listener l until
+// Shutdown is called or either fails. Serve takes ownership of pc and l. Either may
+// be nil, but not both.
+func (s *Server) Serve(pc net.PacketConn, l net.Listener) error {
+	if pc == nil && l == nil {
+		return errors.New("solvere: No listeners to serve on")
+	}
+	if pc != nil && s.DontFragment {
+		if err := dontFragmentPacketConn(pc); err != nil {
+			pc.Close()
+			if l != nil {
+				l.Close()
+			}
+			return err
+		}
+	}
+	handler := dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			return
+		}
+		s.inFlight.Add(1)
+		s.mu.Unlock()
+		defer s.inFlight.Done()
+		if _, tcp := w.RemoteAddr().(*net.TCPAddr); tcp && hasTCPKeepalive(r) {
+			w = &keepaliveWriter{ResponseWriter: w, timeout: s.idleTimeout()}
+		}
+		s.Handler.ServeDNS(w, r)
+	})
+	var servers []*dns.Server
+	if pc != nil {
+		servers = append(servers, &dns.Server{PacketConn: pc, Handler: handler, ReadTimeout: s.ReadTimeout, WriteTimeout: s.WriteTimeout})
+	}
+	if l != nil {
+		if s.MaxConnections > 0 {
+			l = newLimitListener(l, s.MaxConnections)
+		}
+		servers = append(servers, &dns.Server{
+			Listener:     l,
+			Handler:      handler,
+			ReadTimeout:  s.ReadTimeout,
+			WriteTimeout: s.WriteTimeout,
+			IdleTimeout:  s.idleTimeout,
+		})
+	}
+
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		if pc != nil {
+			pc.Close()
+		}
+		if l != nil {
+			l.Close()
+		}
+		return ErrServerClosed
+	}
+	s.mu.Unlock()
+
+	started := make(chan struct{}, len(servers))
+	errs := make(chan error, len(servers))
+	for _, srv := range servers {
+		srv.NotifyStartedFunc = func() { started <- struct{}{} }
+		go func(srv *dns.Server) {
+			errs <- srv.ActivateAndServe()
+		}(srv)
+	}
+	// wait for each server to either start or fail
+	var err error
+	running, exited := 0, 0
+	for running+exited < len(servers) {
+		select {
+		case <-started:
+			running++
+		case err = <-errs:
+			exited++
+		}
+	}
+
+	if exited == 0 {
+		s.mu.Lock()
+		if s.closed {
+			// Shutdown was called while the servers were starting
+			s.mu.Unlock()
+			shutdownAll(servers)
+		} else {
+			s.servers = append(s.servers, servers...)
+			s.mu.Unlock()
+		}
+		// wait for the first server to exit and then stop the others
+		err = <-errs
+		exited++
+	}
+	shutdownAll(servers)
+	for ; exited < len(servers); exited++ {
+		<-errs
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return ErrServerClosed
+	}
+	return err
+}
+
+// keepaliveWriter adds the edns-tcp-keepalive option advertising the idle timeout
+// to responses written to TCP clients which sent it in their query (RFC 7828 3.3.2)
+type keepaliveWriter struct {
+	dns.ResponseWriter
+	timeout time.Duration
+}
+
+func (kw *keepaliveWriter) WriteMsg(m *dns.Msg) error {
+	if opt := m.IsEdns0(); opt != nil {
+		setTCPKeepalive(opt, kw.timeout)
+	}
+	return kw.ResponseWriter.WriteMsg(m)
+}
+
+func (s *Server) idleTimeout() time.Duration {
+	if s.IdleTimeout > 0 {
+		return s.IdleTimeout
+	}
+	return DefaultIdleTimeout
+}
+
+func shutdownAll(servers []*dns.Server) {
+	for _, srv := range servers {
+		// errors are ignored since they only indicate the server was already
+		// stopped or still had queries in flight, which are tracked separately
+		go srv.Shutdown()
+	}
+}
+
+// Shutdown stops the server from accepting new queries and waits for in-flight
+// queries to be answered, or for ctx to be done.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	s.closed = true
+	servers := s.servers
+	s.servers = nil
+	s.mu.Unlock()
+	shutdownAll(servers)
+
+	done := make(chan struct{})
+	go func() {
+		s.inFlight.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// dontFragmentPacketConn sets the don't fragment bit on packets sent on pc, if it
+// is a socket
+func dontFragmentPacketConn(pc net.PacketConn) error {
+	sc, ok := pc.(syscall.Conn)
+	if !ok {
+		return nil
+	}
+	c, err := sc.SyscallConn()
+	if err != nil {
+		return err
+	}
+	// the socket is IPv6, and possibly dual stack, unless it is bound to an IPv4
+	// address
+	ua, _ := pc.LocalAddr().(*net.UDPAddr)
+	return setDontFragment(c, ua == nil || ua.IP.To4() == nil)
+}
+
+// limitListener is a net.Listener that blocks in Accept while max connections
+// are open
+type limitListener struct {
+	net.Listener
+	sem       chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func newLimitListener(l net.Listener, max int) *limitListener {
+	return &limitListener{Listener: l, sem: make(chan struct{}, max), done: make(chan struct{})}
+}
+
+func (ll *limitListener) Accept() (net.Conn, error) {
+	select {
+	case ll.sem <- struct{}{}:
+	case <-ll.done:
+		return nil, errors.New("solvere: Listener closed")
+	}
+	c, err := ll.Listener.Accept()
+	if err != nil {
+		<-ll.sem
+		return nil, err
+	}
+	return &limitConn{Conn: c, release: func() { <-ll.sem }}, nil
+}
+
+func (ll *limitListener) Close() error {
+	ll.closeOnce.Do(func() { close(ll.done) })
+	return ll.Listener.Close()
+}
+
+// limitConn releases its slot in a limitListener when it is closed. The dns
+// package abandons connections whose first read fails without closing them, and
+// never reads from a connection again once a read fails, so connections are
+// closed when a read fails rather than being left open holding a slot.
+type limitConn struct {
+	net.Conn
+	once    sync.Once
+	release func()
+}
+
+func (lc *limitConn) Read(b []byte) (int, error) {
+	n, err := lc.Conn.Read(b)
+	if err != nil {
+		lc.Close()
+	}
+	return n, err
+}
+
+func (lc *limitConn) Close() error {
+	var err error
+	lc.once.Do(func() {
+		err = lc.Conn.Close()
+		lc.release()
+	})
+	return err
+}
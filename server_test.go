@@ -0,0 +1,232 @@
+package solvere
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func TestServer(t *testing.T) {
+	rr := &RecursiveResolver{c: new(dns.Client)}
+	rr.AddHooks(Hooks{
+		OnQuery: func(_ context.Context, q *Question) (*Answer, error) {
+			a := &Answer{Rcode: dns.RcodeSuccess}
+			for i := 0; i < 100; i++ {
+				a.Answer = append(a.Answer, &dns.A{
+					Hdr: dns.RR_Header{Name: q.Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 10},
+					A:   net.IP{10, 0, byte(i / 256), byte(i % 256)},
+				})
+			}
+			return a, nil
+		},
+	})
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen on UDP: %s", err)
+	}
+	l, err := net.Listen("tcp", pc.LocalAddr().String())
+	if err != nil {
+		t.Fatalf("Failed to listen on TCP: %s", err)
+	}
+	s := NewServer("", rr)
+	served := make(chan error, 1)
+	go func() { served <- s.Serve(pc, l) }()
+	// wait for things to warm up :/
+	time.Sleep(time.Millisecond * 100)
+
+	m := new(dns.Msg)
+	m.SetQuestion("a.com.", dns.TypeA)
+	m.SetEdns0(4096, false)
+	r, _, err := (&dns.Client{Net: "udp"}).Exchange(m, pc.LocalAddr().String())
+	if err != nil && err != dns.ErrTruncated {
+		t.Fatalf("UDP query failed: %s", err)
+	}
+	if r == nil || !r.Truncated || len(r.Answer) != 0 || r.IsEdns0() == nil {
+		t.Fatalf("Expected a truncated UDP response with a OPT record: %v", r)
+	}
+	if r.IsEdns0().UDPSize() != DefaultMaxUDPSize {
+		t.Fatalf("Server advertised unexpected UDP size: %d", r.IsEdns0().UDPSize())
+	}
+
+	r, _, err = (&dns.Client{Net: "tcp"}).Exchange(m, l.Addr().String())
+	if err != nil {
+		t.Fatalf("TCP query failed: %s", err)
+	}
+	if r.Truncated || len(r.Answer) != 100 {
+		t.Fatalf("Expected a complete TCP response, got %d records (truncated: %t)", len(r.Answer), r.Truncated)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err = s.Shutdown(ctx); err != nil {
+		t.Fatalf("Shutdown failed: %s", err)
+	}
+	select {
+	case err = <-served:
+		if err != ErrServerClosed {
+			t.Fatalf("Serve returned unexpected error after Shutdown: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Serve didn't return after Shutdown")
+	}
+	if err = s.Serve(nil, nil); err == nil {
+		t.Fatal("Serve didn't fail without any listeners")
+	}
+	if err = s.ListenAndServe(); err != ErrServerClosed {
+		t.Fatalf("ListenAndServe didn't fail after Shutdown: %v", err)
+	}
+}
+
+func selfSignedCert(t *testing.T) (tls.Certificate, *x509.CertPool) {
+	k, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %s", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		IsCA:         true,
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, k.Public(), k)
+	if err != nil {
+		t.Fatalf("Failed to create certificate: %s", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("Failed to parse certificate: %s", err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: k}, pool
+}
+
+func TestServerTLS(t *testing.T) {
+	rr := &RecursiveResolver{c: new(dns.Client)}
+	rr.AddHooks(Hooks{
+		OnQuery: func(_ context.Context, q *Question) (*Answer, error) {
+			return &Answer{Rcode: dns.RcodeNameError}, nil
+		},
+	})
+	cert, pool := selfSignedCert(t)
+	s := NewServer("", rr)
+	s.MaxConnections = 2
+	if err := s.ListenAndServeTLS("", ""); err == nil {
+		t.Fatal("ListenAndServeTLS didn't fail without a certificate")
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen on TCP: %s", err)
+	}
+	served := make(chan error, 1)
+	s.TLSConfig = &tls.Config{Certificates: []tls.Certificate{cert}}
+	go func() { served <- s.ServeTLS(l, "", "") }()
+	time.Sleep(time.Millisecond * 100)
+
+	m := new(dns.Msg)
+	m.SetQuestion("a.com.", dns.TypeA)
+	c := &dns.Client{Net: "tcp-tls", TLSConfig: &tls.Config{RootCAs: pool}}
+	r, _, err := c.Exchange(m, l.Addr().String())
+	if err != nil {
+		t.Fatalf("DNS-over-TLS query failed: %s", err)
+	}
+	if r.Rcode != dns.RcodeNameError {
+		t.Fatalf("Unexpected DNS-over-TLS response: %s", r)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err = s.Shutdown(ctx); err != nil {
+		t.Fatalf("Shutdown failed: %s", err)
+	}
+	if err = <-served; err != ErrServerClosed {
+		t.Fatalf("Serve returned unexpected error after Shutdown: %v", err)
+	}
+}
+
+func TestLimitListener(t *testing.T) {
+	inner, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen on TCP: %s", err)
+	}
+	l := newLimitListener(inner, 1)
+	accepted := make(chan net.Conn, 2)
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				close(accepted)
+				return
+			}
+			accepted <- c
+		}
+	}()
+	for i := 0; i < 2; i++ {
+		c, err := net.Dial("tcp", inner.Addr().String())
+		if err != nil {
+			t.Fatalf("Failed to connect: %s", err)
+		}
+		defer c.Close()
+	}
+	first := <-accepted
+	select {
+	case <-accepted:
+		t.Fatal("limitListener accepted more connections than allowed")
+	case <-time.After(time.Millisecond * 100):
+	}
+	// a failed read closes the connection, rather than freeing its slot while
+	// it stays open
+	first.SetReadDeadline(time.Now())
+	if _, err := first.Read(make([]byte, 1)); err == nil {
+		t.Fatal("Read didn't time out")
+	}
+	if _, err := first.Write([]byte{0}); err == nil {
+		t.Fatal("Connection wasn't closed after a failed read")
+	}
+	first.Close()
+	select {
+	case <-accepted:
+	case <-time.After(time.Second):
+		t.Fatal("limitListener didn't accept a connection after one was closed")
+	}
+	l.Close()
+	select {
+	case <-accepted:
+	case <-time.After(time.Second):
+		t.Fatal("limitListener didn't stop accepting after being closed")
+	}
+}
+
+func TestServerTCPKeepalive(t *testing.T) {
+	addr, _ := newKeepaliveServer(t, 3*time.Second)
+	m := new(dns.Msg)
+	m.SetQuestion("example.", dns.TypeA)
+	m.SetEdns0(DefaultEDNSBufferSize, false)
+	r, _, err := (&dns.Client{Net: "tcp"}).Exchange(m, addr)
+	if err != nil {
+		t.Fatalf("TCP query failed: %s", err)
+	}
+	if _, ok := tcpKeepaliveTimeout(r); ok {
+		t.Fatal("Server advertised a timeout to a client which didn't send the option")
+	}
+	m.IsEdns0().Option = []dns.EDNS0{&dns.EDNS0_LOCAL{Code: dns.EDNS0TCPKEEPALIVE}}
+	if r, _, err = (&dns.Client{Net: "tcp"}).Exchange(m, addr); err != nil {
+		t.Fatalf("TCP query failed: %s", err)
+	}
+	if idle, ok := tcpKeepaliveTimeout(r); !ok || idle != 3*time.Second {
+		t.Fatalf("Expected the server to advertise its idle timeout, got %s", r)
+	}
+}
@@ -0,0 +1,163 @@
+package service
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// LookupMethod is the path gRPC clients send Lookup calls to
+const LookupMethod = "/solvere.v1.Resolver/Lookup"
+
+// MaxRequestSize is the maximum size of a request message the server accepts
+var MaxRequestSize = 64 * 1024
+
+// gRPC status codes, see https://grpc.github.io/grpc/core/md_doc_statuscodes.html
+const (
+	grpcOK                = 0
+	grpcInvalidArgument   = 3
+	grpcResourceExhausted = 8
+	grpcUnimplemented     = 12
+	grpcInternal          = 13
+)
+
+// ServeHTTP implements the gRPC protocol over HTTP/2 for the Resolver service, so
+// the Server can be used with clients generated from resolver.proto. Only
+// uncompressed unary calls are supported, and the grpc-timeout header is
+// honoured. The http.Server it is served by must support HTTP/2, see Serve.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost || !strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc") {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "gRPC requests only", http.StatusUnsupportedMediaType)
+		return
+	}
+	if r.ProtoMajor != 2 {
+		http.Error(w, "gRPC requires HTTP/2", http.StatusHTTPVersionNotSupported)
+		return
+	}
+	w.Header().Set("Content-Type", "application/grpc")
+	if r.URL.Path != LookupMethod {
+		writeGRPCStatus(w, grpcUnimplemented, fmt.Sprintf("unknown method %s", r.URL.Path))
+		return
+	}
+	ctx := r.Context()
+	if timeout := r.Header.Get("Grpc-Timeout"); timeout != "" {
+		d, err := parseGRPCTimeout(timeout)
+		if err != nil {
+			writeGRPCStatus(w, grpcInvalidArgument, err.Error())
+			return
+		}
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, d)
+		defer cancel()
+	}
+
+	msg, code, err := readGRPCMessage(r.Body)
+	if err != nil {
+		writeGRPCStatus(w, code, err.Error())
+		return
+	}
+	req := new(LookupRequest)
+	if err := req.unmarshal(msg); err != nil {
+		writeGRPCStatus(w, grpcInvalidArgument, err.Error())
+		return
+	}
+	resp, err := s.Lookup(ctx, req)
+	if err != nil {
+		code := grpcInternal
+		if err == ErrMissingQuestion {
+			code = grpcInvalidArgument
+		}
+		writeGRPCStatus(w, code, err.Error())
+		return
+	}
+	body := resp.marshal()
+	frame := make([]byte, 5, 5+len(body))
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(body)))
+	w.Header().Set("Trailer", "Grpc-Status")
+	w.WriteHeader(http.StatusOK)
+	w.Write(append(frame, body...))
+	w.Header().Set("Grpc-Status", strconv.Itoa(grpcOK))
+}
+
+// Serve accepts connections from gRPC clients on l, using HTTP/2 without TLS
+// (what gRPC calls insecure credentials), until l is closed. To use TLS serve the
+// Server using a http.Server with a TLSConfig instead.
+func (s *Server) Serve(l net.Listener) error {
+	protocols := new(http.Protocols)
+	protocols.SetUnencryptedHTTP2(true)
+	hs := &http.Server{Handler: s, Protocols: protocols, ReadHeaderTimeout: 10 * time.Second}
+	return hs.Serve(l)
+}
+
+// readGRPCMessage reads the single length prefixed message of a unary call
+func readGRPCMessage(body io.Reader) ([]byte, int, error) {
+	var prefix [5]byte
+	if _, err := io.ReadFull(body, prefix[:]); err != nil {
+		return nil, grpcInvalidArgument, errors.New("missing request message")
+	}
+	if prefix[0] != 0 {
+		return nil, grpcUnimplemented, errors.New("compressed messages aren't supported")
+	}
+	length := binary.BigEndian.Uint32(prefix[1:])
+	if length > uint32(MaxRequestSize) {
+		return nil, grpcResourceExhausted, fmt.Errorf("request message larger than %d bytes", MaxRequestSize)
+	}
+	msg := make([]byte, length)
+	if _, err := io.ReadFull(body, msg); err != nil {
+		return nil, grpcInvalidArgument, errors.New("truncated request message")
+	}
+	return msg, grpcOK, nil
+}
+
+// writeGRPCStatus sends the status of a failed call as a trailers-only response
+func writeGRPCStatus(w http.ResponseWriter, code int, msg string) {
+	w.Header().Set("Grpc-Status", strconv.Itoa(code))
+	if msg != "" {
+		w.Header().Set("Grpc-Message", encodeGRPCMessage(msg))
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// encodeGRPCMessage percent encodes the bytes of msg which aren't printable ASCII,
+// as the grpc-message header requires
+func encodeGRPCMessage(msg string) string {
+	var b strings.Builder
+	for i := 0; i < len(msg); i++ {
+		if c := msg[i]; c >= ' ' && c <= '~' && c != '%' {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// parseGRPCTimeout parses the value of a grpc-timeout header, a positive integer
+// of at most eight digits followed by a unit
+func parseGRPCTimeout(s string) (time.Duration, error) {
+	units := map[byte]time.Duration{
+		'H': time.Hour,
+		'M': time.Minute,
+		'S': time.Second,
+		'm': time.Millisecond,
+		'u': time.Microsecond,
+		'n': time.Nanosecond,
+	}
+	if len(s) < 2 || len(s) > 9 {
+		return 0, fmt.Errorf("invalid grpc-timeout %q", s)
+	}
+	unit, present := units[s[len(s)-1]]
+	v, err := strconv.ParseInt(s[:len(s)-1], 10, 64)
+	if !present || err != nil || v < 0 {
+		return 0, fmt.Errorf("invalid grpc-timeout %q", s)
+	}
+	return time.Duration(v) * unit, nil
+}
@@ -0,0 +1,139 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/miekg/dns"
+
+	"github.com/rolandshoemaker/solvere"
+)
+
+func TestWireRoundTrip(t *testing.T) {
+	resp := &LookupResponse{
+		Answer: &Answer{
+			Answer:         []string{"a.com.\t10\tIN\tA\t1.2.3.4", ""},
+			Rcode:          -1,
+			Authenticated:  true,
+			ExtendedErrors: []*ExtendedError{{InfoCode: 3, ExtraText: "stale"}},
+		},
+		Log: &LookupLog{
+			Query:         &Question{Name: "a.com.", Type: 1},
+			CacheHit:      true,
+			LatencyNs:     1234,
+			StartedUnixNs: -5,
+			Ns:            &Nameserver{Name: "ns.a.com.", Addr: "192.0.2.1", Zone: "a.com."},
+			Timings:       &Timings{NetworkNs: 7, TotalNs: 9},
+			Composites:    []*LookupLog{{Error: "timeout", Referral: true}},
+		},
+		ErrorStage: "query",
+	}
+	decoded := new(LookupResponse)
+	if err := decoded.unmarshal(resp.marshal()); err != nil {
+		t.Fatalf("Failed to decode response: %s", err)
+	}
+	if !reflect.DeepEqual(resp, decoded) {
+		t.Fatalf("Decoded response differs:\n%#v\n%#v", resp, decoded)
+	}
+
+	req := &LookupRequest{Question: &Question{Name: "a.com.", Type: 28}, TimeoutMs: 500}
+	// unknown fields are skipped
+	encoded := appendString(req.marshal(), 15, "unknown")
+	decodedReq := new(LookupRequest)
+	if err := decodedReq.unmarshal(encoded); err != nil || !reflect.DeepEqual(req, decodedReq) {
+		t.Fatalf("Unexpected decoded request %#v: %v", decodedReq, err)
+	}
+	if err := decodedReq.unmarshal([]byte{0x0a, 0x05, 0x01}); err != ErrMalformedMessage {
+		t.Fatalf("Expected truncated message to fail to decode, got %v", err)
+	}
+	// the question has to be length delimited
+	if err := decodedReq.unmarshal([]byte{0x08, 0x01}); err != ErrMalformedMessage {
+		t.Fatalf("Expected field with the wrong wire type to fail to decode, got %v", err)
+	}
+}
+
+func TestGRPCServer(t *testing.T) {
+	rr := solvere.NewResolver(solvere.WithCache(nil), solvere.WithValidation(false))
+	rr.AddHooks(solvere.Hooks{
+		OnQuery: func(_ context.Context, q *solvere.Question) (*solvere.Answer, error) {
+			return &solvere.Answer{
+				Answer: []dns.RR{&dns.A{Hdr: dns.RR_Header{Name: q.Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 10}, A: net.IP{1, 2, 3, 4}}},
+			}, nil
+		},
+	})
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %s", err)
+	}
+	defer l.Close()
+	go NewServer(rr).Serve(l)
+
+	protocols := new(http.Protocols)
+	protocols.SetUnencryptedHTTP2(true)
+	client := &http.Client{Transport: &http.Transport{Protocols: protocols}}
+	call := func(method string, req *LookupRequest) (*LookupResponse, string, string) {
+		body := req.marshal()
+		frame := make([]byte, 5, 5+len(body))
+		binary.BigEndian.PutUint32(frame[1:], uint32(len(body)))
+		hr, err := http.NewRequest(http.MethodPost, "http://"+l.Addr().String()+method, bytes.NewReader(append(frame, body...)))
+		if err != nil {
+			t.Fatalf("Failed to create request: %s", err)
+		}
+		hr.Header.Set("Content-Type", "application/grpc")
+		hr.Header.Set("Grpc-Timeout", "5S")
+		r, err := client.Do(hr)
+		if err != nil {
+			t.Fatalf("Call failed: %s", err)
+		}
+		defer r.Body.Close()
+		msg, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Fatalf("Failed to read response: %s", err)
+		}
+		status := r.Header.Get("Grpc-Status")
+		if status == "" {
+			status = r.Trailer.Get("Grpc-Status")
+		}
+		if len(msg) == 0 {
+			return nil, status, r.Header.Get("Grpc-Message")
+		}
+		if len(msg) < 5 || int(binary.BigEndian.Uint32(msg[1:5])) != len(msg)-5 {
+			t.Fatalf("Malformed response frame %x", msg)
+		}
+		resp := new(LookupResponse)
+		if err := resp.unmarshal(msg[5:]); err != nil {
+			t.Fatalf("Failed to decode response: %s", err)
+		}
+		return resp, status, ""
+	}
+
+	resp, status, _ := call(LookupMethod, &LookupRequest{Question: &Question{Name: "a.com", Type: uint32(dns.TypeA)}})
+	if status != "0" || resp == nil || len(resp.Answer.Answer) != 1 || resp.Log == nil {
+		t.Fatalf("Unexpected response %#v with status %s", resp, status)
+	}
+	if _, status, msg := call(LookupMethod, &LookupRequest{}); status != "3" || msg != ErrMissingQuestion.Error() {
+		t.Fatalf("Expected InvalidArgument status without a question, got %s %q", status, msg)
+	}
+	if _, status, _ := call("/solvere.v1.Resolver/Unknown", &LookupRequest{}); status != "12" {
+		t.Fatalf("Expected Unimplemented status for unknown method, got %s", status)
+	}
+}
+
+func TestParseGRPCTimeout(t *testing.T) {
+	for s, expected := range map[string]int64{"5S": 5e9, "100m": 1e8, "2H": 7200e9, "10n": 10} {
+		if d, err := parseGRPCTimeout(s); err != nil || int64(d) != expected {
+			t.Errorf("%s: expected %d, got %d %v", s, expected, d, err)
+		}
+	}
+	for _, s := range []string{"", "5", "5X", "-1S", "123456789S"} {
+		if _, err := parseGRPCTimeout(s); err == nil {
+			t.Errorf("%q: expected error", s)
+		}
+	}
+}
@@ -0,0 +1,212 @@
+// Package service implements the Resolver service defined in resolver.proto,
+// exposing a solvere.RecursiveResolver to non-Go services over gRPC.
+//
+// The message types in this package mirror the messages in resolver.proto using
+// the names protoc-gen-go generates. Rather than depending on the gRPC and
+// protobuf runtime packages, Server encodes the messages itself and speaks the
+// gRPC protocol over HTTP/2 using net/http, so any client generated from
+// resolver.proto can call it:
+//
+//	l, err := net.Listen("tcp", "127.0.0.1:8053")
+//	...
+//	go service.NewServer(rr).Serve(l)
+package service
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"github.com/rolandshoemaker/solvere"
+)
+
+// DefaultTimeout is the maximum amount of time spent on a lookup when the request
+// doesn't specify a timeout
+var DefaultTimeout = 10 * time.Second
+
+// Question mirrors the Question message
+type Question struct {
+	Name string
+	Type uint32
+}
+
+// LookupRequest mirrors the LookupRequest message
+type LookupRequest struct {
+	Question  *Question
+	TimeoutMs int64
+}
+
+// ExtendedError mirrors the ExtendedError message
+type ExtendedError struct {
+	InfoCode  uint32
+	ExtraText string
+}
+
+// Answer mirrors the Answer message
+type Answer struct {
+	Answer         []string
+	Authority      []string
+	Additional     []string
+	Rcode          int32
+	Authenticated  bool
+	ExtendedErrors []*ExtendedError
+}
+
+// Nameserver mirrors the Nameserver message
+type Nameserver struct {
+	Name string
+	Addr string
+	Zone string
+}
+
+// Timings mirrors the Timings message
+type Timings struct {
+	CacheNs      int64
+	NetworkNs    int64
+	ValidationNs int64
+	TotalNs      int64
+}
+
+// LookupLog mirrors the LookupLog message
+type LookupLog struct {
+	Query          *Question
+	Rcode          int32
+	CacheHit       bool
+	DnssecValid    bool
+	LatencyNs      int64
+	Error          string
+	Truncated      bool
+	Referral       bool
+	StartedUnixNs  int64
+	Ns             *Nameserver
+	Timings        *Timings
+	ExtendedErrors []*ExtendedError
+	Composites     []*LookupLog
+}
+
+// LookupResponse mirrors the LookupResponse message
+type LookupResponse struct {
+	Answer     *Answer
+	Log        *LookupLog
+	Error      string
+	ErrorStage string
+}
+
+// Server implements the Resolver service using a solvere.RecursiveResolver
+type Server struct {
+	// Resolver is used to perform lookups, it must be set unless Handler is
+	Resolver *solvere.RecursiveResolver
+	// Handler, if not nil, is used to find the resolver to perform lookups
+	// with instead of Resolver, so the resolver most recently passed to
+	// SetResolver is used
+	Handler *solvere.Handler
+}
+
+// NewServer returns a Server that uses rr to perform lookups
+func NewServer(rr *solvere.RecursiveResolver) *Server {
+	return &Server{Resolver: rr}
+}
+
+// ErrMissingQuestion is returned by Server.Lookup if the request doesn't contain
+// a question
+var ErrMissingQuestion = errors.New("service: Request doesn't contain a question")
+
+// Lookup implements the Lookup RPC. Resolution failures are reported in the
+// response rather than as a error so the LookupLog is always returned.
+func (s *Server) Lookup(ctx context.Context, req *LookupRequest) (*LookupResponse, error) {
+	if req.Question == nil || req.Question.Name == "" {
+		return nil, ErrMissingQuestion
+	}
+	timeout := DefaultTimeout
+	if req.TimeoutMs > 0 {
+		timeout = time.Duration(req.TimeoutMs) * time.Millisecond
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	q := solvere.Question{Name: dns.Fqdn(req.Question.Name), Type: uint16(req.Question.Type)}
+	rr := s.Resolver
+	if s.Handler != nil {
+		rr = s.Handler.CurrentResolver()
+	}
+	a, log, err := rr.Lookup(ctx, q)
+	resp := &LookupResponse{Log: convertLog(log)}
+	if err != nil {
+		resp.Error = err.Error()
+		var re *solvere.ResolutionError
+		if errors.As(err, &re) {
+			resp.ErrorStage = re.Stage.String()
+		}
+		return resp, nil
+	}
+	resp.Answer = convertAnswer(a)
+	return resp, nil
+}
+
+func rrStrings(section []dns.RR) []string {
+	var out []string
+	for _, rr := range section {
+		if rr.Header().Rrtype == dns.TypeOPT {
+			continue
+		}
+		out = append(out, rr.String())
+	}
+	return out
+}
+
+func convertExtendedErrors(errs []solvere.ExtendedError) []*ExtendedError {
+	var out []*ExtendedError
+	for _, ee := range errs {
+		out = append(out, &ExtendedError{InfoCode: uint32(ee.InfoCode), ExtraText: ee.ExtraText})
+	}
+	return out
+}
+
+func convertAnswer(a *solvere.Answer) *Answer {
+	return &Answer{
+		Answer:         rrStrings(a.Answer),
+		Authority:      rrStrings(a.Authority),
+		Additional:     rrStrings(a.Additional),
+		Rcode:          int32(a.Rcode),
+		Authenticated:  a.Authenticated,
+		ExtendedErrors: convertExtendedErrors(a.ExtendedErrors),
+	}
+}
+
+func convertLog(ll *solvere.LookupLog) *LookupLog {
+	if ll == nil {
+		return nil
+	}
+	out := &LookupLog{
+		Rcode:          int32(ll.Rcode),
+		CacheHit:       ll.CacheHit,
+		DnssecValid:    ll.DNSSECValid,
+		LatencyNs:      int64(ll.Latency),
+		Error:          ll.Error,
+		Truncated:      ll.Truncated,
+		Referral:       ll.Referral,
+		StartedUnixNs:  ll.Started.UnixNano(),
+		ExtendedErrors: convertExtendedErrors(ll.ExtendedErrors),
+	}
+	if ll.Query != nil {
+		out.Query = &Question{Name: ll.Query.Name, Type: uint32(ll.Query.Type)}
+	}
+	if ll.NS != nil {
+		out.Ns = &Nameserver{Name: ll.NS.Name, Addr: ll.NS.Addr, Zone: ll.NS.Zone}
+	}
+	if ll.Timings != nil {
+		out.Timings = &Timings{
+			CacheNs:      int64(ll.Timings.Cache),
+			NetworkNs:    int64(ll.Timings.Network),
+			ValidationNs: int64(ll.Timings.Validation),
+			TotalNs:      int64(ll.Timings.Total),
+		}
+	}
+	for _, c := range ll.Composites {
+		if c != nil {
+			out.Composites = append(out.Composites, convertLog(c))
+		}
+	}
+	return out
+}
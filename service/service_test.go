@@ -0,0 +1,75 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/miekg/dns"
+
+	"github.com/rolandshoemaker/solvere"
+)
+
+func TestLookup(t *testing.T) {
+	rr := solvere.NewRecursiveResolver(false, true, nil, nil, nil)
+	rr.AddHooks(solvere.Hooks{
+		OnQuery: func(_ context.Context, q *solvere.Question) (*solvere.Answer, error) {
+			if q.Name == "bad.com." {
+				return nil, errors.New("broken")
+			}
+			return &solvere.Answer{
+				Answer:         []dns.RR{&dns.A{Hdr: dns.RR_Header{Name: q.Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 10}, A: net.IP{1, 2, 3, 4}}},
+				Authenticated:  true,
+				ExtendedErrors: []solvere.ExtendedError{{InfoCode: solvere.ExtendedErrorStaleAnswer}},
+			}, nil
+		},
+	})
+	s := NewServer(rr)
+
+	if _, err := s.Lookup(context.Background(), &LookupRequest{}); err != ErrMissingQuestion {
+		t.Fatalf("Lookup didn't fail without a question: %v", err)
+	}
+
+	resp, err := s.Lookup(context.Background(), &LookupRequest{Question: &Question{Name: "a.com", Type: uint32(dns.TypeA)}})
+	if err != nil {
+		t.Fatalf("Lookup failed: %s", err)
+	}
+	if resp.Error != "" || resp.Answer == nil || !resp.Answer.Authenticated {
+		t.Fatalf("Lookup returned unexpected response: %#v", resp)
+	}
+	if len(resp.Answer.Answer) != 1 || resp.Answer.Answer[0] != "a.com.\t10\tIN\tA\t1.2.3.4" {
+		t.Fatalf("Lookup returned unexpected records: %v", resp.Answer.Answer)
+	}
+	if len(resp.Answer.ExtendedErrors) != 1 || resp.Answer.ExtendedErrors[0].InfoCode != uint32(solvere.ExtendedErrorStaleAnswer) {
+		t.Fatalf("Lookup returned unexpected extended errors: %v", resp.Answer.ExtendedErrors)
+	}
+	if resp.Log == nil || resp.Log.Query.Name != "a.com." || resp.Log.Timings == nil {
+		t.Fatalf("Lookup returned unexpected log: %#v", resp.Log)
+	}
+
+	resp, err = s.Lookup(context.Background(), &LookupRequest{Question: &Question{Name: "bad.com.", Type: uint32(dns.TypeA)}})
+	if err != nil {
+		t.Fatalf("Lookup returned a error for a failed resolution: %s", err)
+	}
+	if resp.Answer != nil || resp.Error != "broken" || resp.Log == nil {
+		t.Fatalf("Lookup returned unexpected response for failed resolution: %#v", resp)
+	}
+}
+
+func TestConvertLog(t *testing.T) {
+	if convertLog(nil) != nil {
+		t.Fatal("convertLog returned a non-nil log for a nil log")
+	}
+	ll := &solvere.LookupLog{
+		Query: &solvere.Question{Name: "a.com.", Type: dns.TypeA},
+		Composites: []*solvere.LookupLog{
+			{NS: &solvere.Nameserver{Name: "ns.com.", Addr: "1.2.3.4", Zone: "com."}, Referral: true},
+			nil,
+		},
+	}
+	out := convertLog(ll)
+	if len(out.Composites) != 1 || out.Composites[0].Ns.Zone != "com." || !out.Composites[0].Referral {
+		t.Fatalf("convertLog returned unexpected log: %#v", out)
+	}
+}
@@ -0,0 +1,420 @@
+package service
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// The messages are encoded using the protobuf binary wire format by hand, which
+// for the handful of scalar, string and embedded message fields in resolver.proto
+// is simpler than depending on the protobuf runtime. Fields set to their zero
+// value aren't encoded, as in proto3, and unknown fields are skipped when
+// decoding so newer clients can talk to the server.
+
+// ErrMalformedMessage is returned when a message can't be decoded
+var ErrMalformedMessage = errors.New("service: Malformed protobuf message")
+
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+	wireFixed32 = 5
+)
+
+func appendVarint(b []byte, v uint64) []byte {
+	for v >= 0x80 {
+		b = append(b, byte(v)|0x80)
+		v >>= 7
+	}
+	return append(b, byte(v))
+}
+
+func appendTag(b []byte, field, wireType int) []byte {
+	return appendVarint(b, uint64(field)<<3|uint64(wireType))
+}
+
+func appendUint(b []byte, field int, v uint64) []byte {
+	if v == 0 {
+		return b
+	}
+	return appendVarint(appendTag(b, field, wireVarint), v)
+}
+
+// appendInt encodes a int32 or int64 field, negative values are sign extended to
+// 64 bits as protobuf requires
+func appendInt(b []byte, field int, v int64) []byte {
+	return appendUint(b, field, uint64(v))
+}
+
+func appendBool(b []byte, field int, v bool) []byte {
+	if !v {
+		return b
+	}
+	return appendUint(b, field, 1)
+}
+
+func appendBytes(b []byte, field int, v []byte) []byte {
+	return append(appendVarint(appendTag(b, field, wireBytes), uint64(len(v))), v...)
+}
+
+func appendString(b []byte, field int, v string) []byte {
+	if v == "" {
+		return b
+	}
+	return appendBytes(b, field, []byte(v))
+}
+
+// appendStrings encodes a repeated string field, every element is encoded even if
+// it is empty
+func appendStrings(b []byte, field int, v []string) []byte {
+	for _, s := range v {
+		b = appendBytes(b, field, []byte(s))
+	}
+	return b
+}
+
+// decodeFields calls fn with each field in b, v is the value of varint fields
+// and data the contents of length delimited ones
+func decodeFields(b []byte, fn func(field, wireType int, v uint64, data []byte) error) error {
+	for len(b) > 0 {
+		tag, n := binary.Uvarint(b)
+		if n <= 0 || tag>>3 == 0 {
+			return ErrMalformedMessage
+		}
+		b = b[n:]
+		field, wireType := int(tag>>3), int(tag&7)
+		var v uint64
+		var data []byte
+		switch wireType {
+		case wireVarint:
+			if v, n = binary.Uvarint(b); n <= 0 {
+				return ErrMalformedMessage
+			}
+			b = b[n:]
+		case wireFixed64, wireFixed32:
+			size := 8
+			if wireType == wireFixed32 {
+				size = 4
+			}
+			if len(b) < size {
+				return ErrMalformedMessage
+			}
+			b = b[size:]
+			continue
+		case wireBytes:
+			length, n := binary.Uvarint(b)
+			if n <= 0 || length > uint64(len(b)-n) {
+				return ErrMalformedMessage
+			}
+			data, b = b[n:n+int(length)], b[n+int(length):]
+		default:
+			return ErrMalformedMessage
+		}
+		if err := fn(field, wireType, v, data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// fieldDecoder decodes the fields of a message, checking each has the wire type
+// its type in resolver.proto is encoded with
+type fieldDecoder struct {
+	wireType int
+	v        uint64
+	data     []byte
+	err      error
+}
+
+func (fd *fieldDecoder) check(wireType int) bool {
+	if fd.wireType != wireType {
+		fd.err = ErrMalformedMessage
+		return false
+	}
+	return true
+}
+
+func (fd *fieldDecoder) uint(dst *uint32) {
+	if fd.check(wireVarint) {
+		*dst = uint32(fd.v)
+	}
+}
+
+func (fd *fieldDecoder) int32(dst *int32) {
+	if fd.check(wireVarint) {
+		*dst = int32(fd.v)
+	}
+}
+
+func (fd *fieldDecoder) int64(dst *int64) {
+	if fd.check(wireVarint) {
+		*dst = int64(fd.v)
+	}
+}
+
+func (fd *fieldDecoder) bool(dst *bool) {
+	if fd.check(wireVarint) {
+		*dst = fd.v != 0
+	}
+}
+
+func (fd *fieldDecoder) string(dst *string) {
+	if fd.check(wireBytes) {
+		*dst = string(fd.data)
+	}
+}
+
+func (fd *fieldDecoder) appendString(dst *[]string) {
+	if fd.check(wireBytes) {
+		*dst = append(*dst, string(fd.data))
+	}
+}
+
+func (fd *fieldDecoder) message(m interface{ unmarshal([]byte) error }) {
+	if fd.check(wireBytes) {
+		fd.err = m.unmarshal(fd.data)
+	}
+}
+
+// decodeMessage calls fn with a fieldDecoder for each field of b
+func decodeMessage(b []byte, fn func(field int, fd *fieldDecoder)) error {
+	return decodeFields(b, func(field, wireType int, v uint64, data []byte) error {
+		fd := &fieldDecoder{wireType: wireType, v: v, data: data}
+		fn(field, fd)
+		return fd.err
+	})
+}
+
+func (m *Question) marshal() []byte {
+	var b []byte
+	b = appendString(b, 1, m.Name)
+	return appendUint(b, 2, uint64(m.Type))
+}
+
+func (m *Question) unmarshal(b []byte) error {
+	return decodeMessage(b, func(field int, fd *fieldDecoder) {
+		switch field {
+		case 1:
+			fd.string(&m.Name)
+		case 2:
+			fd.uint(&m.Type)
+		}
+	})
+}
+
+func (m *LookupRequest) marshal() []byte {
+	var b []byte
+	if m.Question != nil {
+		b = appendBytes(b, 1, m.Question.marshal())
+	}
+	return appendInt(b, 2, m.TimeoutMs)
+}
+
+func (m *LookupRequest) unmarshal(b []byte) error {
+	return decodeMessage(b, func(field int, fd *fieldDecoder) {
+		switch field {
+		case 1:
+			m.Question = new(Question)
+			fd.message(m.Question)
+		case 2:
+			fd.int64(&m.TimeoutMs)
+		}
+	})
+}
+
+func (m *ExtendedError) marshal() []byte {
+	var b []byte
+	b = appendUint(b, 1, uint64(m.InfoCode))
+	return appendString(b, 2, m.ExtraText)
+}
+
+func (m *ExtendedError) unmarshal(b []byte) error {
+	return decodeMessage(b, func(field int, fd *fieldDecoder) {
+		switch field {
+		case 1:
+			fd.uint(&m.InfoCode)
+		case 2:
+			fd.string(&m.ExtraText)
+		}
+	})
+}
+
+func appendExtendedErrors(b []byte, field int, errs []*ExtendedError) []byte {
+	for _, ee := range errs {
+		b = appendBytes(b, field, ee.marshal())
+	}
+	return b
+}
+
+func (fd *fieldDecoder) appendExtendedError(dst *[]*ExtendedError) {
+	ee := new(ExtendedError)
+	if fd.message(ee); fd.err == nil {
+		*dst = append(*dst, ee)
+	}
+}
+
+func (m *Answer) marshal() []byte {
+	var b []byte
+	b = appendStrings(b, 1, m.Answer)
+	b = appendStrings(b, 2, m.Authority)
+	b = appendStrings(b, 3, m.Additional)
+	b = appendInt(b, 4, int64(m.Rcode))
+	b = appendBool(b, 5, m.Authenticated)
+	return appendExtendedErrors(b, 6, m.ExtendedErrors)
+}
+
+func (m *Answer) unmarshal(b []byte) error {
+	return decodeMessage(b, func(field int, fd *fieldDecoder) {
+		switch field {
+		case 1:
+			fd.appendString(&m.Answer)
+		case 2:
+			fd.appendString(&m.Authority)
+		case 3:
+			fd.appendString(&m.Additional)
+		case 4:
+			fd.int32(&m.Rcode)
+		case 5:
+			fd.bool(&m.Authenticated)
+		case 6:
+			fd.appendExtendedError(&m.ExtendedErrors)
+		}
+	})
+}
+
+func (m *Nameserver) marshal() []byte {
+	var b []byte
+	b = appendString(b, 1, m.Name)
+	b = appendString(b, 2, m.Addr)
+	return appendString(b, 3, m.Zone)
+}
+
+func (m *Nameserver) unmarshal(b []byte) error {
+	return decodeMessage(b, func(field int, fd *fieldDecoder) {
+		switch field {
+		case 1:
+			fd.string(&m.Name)
+		case 2:
+			fd.string(&m.Addr)
+		case 3:
+			fd.string(&m.Zone)
+		}
+	})
+}
+
+func (m *Timings) marshal() []byte {
+	var b []byte
+	b = appendInt(b, 1, m.CacheNs)
+	b = appendInt(b, 2, m.NetworkNs)
+	b = appendInt(b, 3, m.ValidationNs)
+	return appendInt(b, 4, m.TotalNs)
+}
+
+func (m *Timings) unmarshal(b []byte) error {
+	return decodeMessage(b, func(field int, fd *fieldDecoder) {
+		switch field {
+		case 1:
+			fd.int64(&m.CacheNs)
+		case 2:
+			fd.int64(&m.NetworkNs)
+		case 3:
+			fd.int64(&m.ValidationNs)
+		case 4:
+			fd.int64(&m.TotalNs)
+		}
+	})
+}
+
+func (m *LookupLog) marshal() []byte {
+	var b []byte
+	if m.Query != nil {
+		b = appendBytes(b, 1, m.Query.marshal())
+	}
+	b = appendInt(b, 2, int64(m.Rcode))
+	b = appendBool(b, 3, m.CacheHit)
+	b = appendBool(b, 4, m.DnssecValid)
+	b = appendInt(b, 5, m.LatencyNs)
+	b = appendString(b, 6, m.Error)
+	b = appendBool(b, 7, m.Truncated)
+	b = appendBool(b, 8, m.Referral)
+	b = appendInt(b, 9, m.StartedUnixNs)
+	if m.Ns != nil {
+		b = appendBytes(b, 10, m.Ns.marshal())
+	}
+	if m.Timings != nil {
+		b = appendBytes(b, 11, m.Timings.marshal())
+	}
+	b = appendExtendedErrors(b, 12, m.ExtendedErrors)
+	for _, c := range m.Composites {
+		b = appendBytes(b, 13, c.marshal())
+	}
+	return b
+}
+
+func (m *LookupLog) unmarshal(b []byte) error {
+	return decodeMessage(b, func(field int, fd *fieldDecoder) {
+		switch field {
+		case 1:
+			m.Query = new(Question)
+			fd.message(m.Query)
+		case 2:
+			fd.int32(&m.Rcode)
+		case 3:
+			fd.bool(&m.CacheHit)
+		case 4:
+			fd.bool(&m.DnssecValid)
+		case 5:
+			fd.int64(&m.LatencyNs)
+		case 6:
+			fd.string(&m.Error)
+		case 7:
+			fd.bool(&m.Truncated)
+		case 8:
+			fd.bool(&m.Referral)
+		case 9:
+			fd.int64(&m.StartedUnixNs)
+		case 10:
+			m.Ns = new(Nameserver)
+			fd.message(m.Ns)
+		case 11:
+			m.Timings = new(Timings)
+			fd.message(m.Timings)
+		case 12:
+			fd.appendExtendedError(&m.ExtendedErrors)
+		case 13:
+			c := new(LookupLog)
+			if fd.message(c); fd.err == nil {
+				m.Composites = append(m.Composites, c)
+			}
+		}
+	})
+}
+
+func (m *LookupResponse) marshal() []byte {
+	var b []byte
+	if m.Answer != nil {
+		b = appendBytes(b, 1, m.Answer.marshal())
+	}
+	if m.Log != nil {
+		b = appendBytes(b, 2, m.Log.marshal())
+	}
+	b = appendString(b, 3, m.Error)
+	return appendString(b, 4, m.ErrorStage)
+}
+
+func (m *LookupResponse) unmarshal(b []byte) error {
+	return decodeMessage(b, func(field int, fd *fieldDecoder) {
+		switch field {
+		case 1:
+			m.Answer = new(Answer)
+			fd.message(m.Answer)
+		case 2:
+			m.Log = new(LookupLog)
+			fd.message(m.Log)
+		case 3:
+			fd.string(&m.Error)
+		case 4:
+			fd.string(&m.ErrorStage)
+		}
+	})
+}
@@ -0,0 +1,57 @@
+package solvere
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+)
+
+// SlowQueryLogger receives the lookups that took longer than
+// RecursiveResolver.SlowQueryThreshold. Implementations must be safe for
+// concurrent use and must not modify the LookupLog.
+type SlowQueryLogger interface {
+	LogSlowQuery(q Question, latency time.Duration, err error, ll *LookupLog)
+}
+
+// SlowQueryEntry is the JSON object written for each slow lookup by the
+// SlowQueryLogger returned by NewSlowQueryWriter
+type SlowQueryEntry struct {
+	Question Question
+	Latency  time.Duration
+	Error    string `json:",omitempty"`
+	Log      *LookupLog
+}
+
+type slowQueryWriter struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+// NewSlowQueryWriter returns a SlowQueryLogger that writes each slow lookup to w
+// as a line containing a SlowQueryEntry encoded as JSON. Write errors are
+// ignored.
+func NewSlowQueryWriter(w io.Writer) SlowQueryLogger {
+	return &slowQueryWriter{enc: json.NewEncoder(w)}
+}
+
+func (sw *slowQueryWriter) LogSlowQuery(q Question, latency time.Duration, err error, ll *LookupLog) {
+	e := SlowQueryEntry{Question: q, Latency: latency, Log: ll}
+	if err != nil {
+		e.Error = err.Error()
+	}
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+	sw.enc.Encode(e)
+}
+
+// logSlowQuery passes the lookup of q to the SlowQueryLog if it took longer than
+// the threshold
+func (rr *RecursiveResolver) logSlowQuery(q Question, started time.Time, err error, ll *LookupLog) {
+	if rr.SlowQueryLog == nil || rr.SlowQueryThreshold <= 0 {
+		return
+	}
+	if latency := time.Since(started); latency >= rr.SlowQueryThreshold {
+		rr.SlowQueryLog.LogSlowQuery(q, latency, err, ll)
+	}
+}
@@ -0,0 +1,46 @@
+package solvere
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func TestSlowQueryLog(t *testing.T) {
+	rr := NewRecursiveResolver(false, false, nil, nil, nil)
+	rr.Forward = &ForwardConfig{Servers: []string{"10.0.0.1"}}
+	delay := time.Duration(0)
+	rr.Transport = transportFunc(func(_ context.Context, m *dns.Msg, _ string) (*dns.Msg, error) {
+		time.Sleep(delay)
+		r := new(dns.Msg)
+		r.SetReply(m)
+		r.Answer = []dns.RR{&dns.A{Hdr: dns.RR_Header{Name: m.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60}, A: net.IP{1, 2, 3, 4}}}
+		return r, nil
+	})
+	b := new(bytes.Buffer)
+	rr.SlowQueryLog = NewSlowQueryWriter(b)
+	rr.SlowQueryThreshold = 20 * time.Millisecond
+
+	if _, _, err := rr.Lookup(context.Background(), Question{Name: "fast.example.", Type: dns.TypeA}); err != nil {
+		t.Fatalf("Lookup failed: %s", err)
+	}
+	if b.Len() != 0 {
+		t.Fatalf("Fast lookup was logged: %s", b)
+	}
+	delay = 30 * time.Millisecond
+	if _, _, err := rr.Lookup(context.Background(), Question{Name: "slow.example.", Type: dns.TypeA}); err != nil {
+		t.Fatalf("Lookup failed: %s", err)
+	}
+	var e SlowQueryEntry
+	if err := json.Unmarshal(b.Bytes(), &e); err != nil {
+		t.Fatalf("Failed to decode slow query entry %q: %s", b, err)
+	}
+	if e.Question.Name != "slow.example." || e.Latency < delay || e.Log == nil || len(e.Log.Composites) != 1 {
+		t.Fatalf("Unexpected slow query entry %#v", e)
+	}
+}
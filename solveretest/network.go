@@ -0,0 +1,308 @@
+// Package solveretest provides a fake network of authoritative nameservers which
+// can be used as the solvere.Transport of a resolver, so code using solvere can be
+// tested deterministically without network access.
+//
+// A Network is programmed with zones, each served from one or more addresses.
+// Delegations are derived from the zones themselves, a query for a name below a
+// zone the nameserver isn't authoritative for is answered with a referral to the
+// closest zone which is. Zones can be signed, in which case responses to queries
+// with the DO bit set are signed and denial of existence is proven with NSEC3
+// records, and nameservers can be made to misbehave using SetBehavior.
+//
+//	n := solveretest.NewNetwork()
+//	n.AddZone(".", "198.41.0.4").Sign()
+//	n.AddZone("example.", "192.0.2.1").Sign()
+//	n.AddZone("www.example.", "192.0.2.2").Parse("www 300 IN A 192.0.2.10")
+//	rr := n.NewResolver()
+package solveretest
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/miekg/dns"
+
+	"github.com/rolandshoemaker/solvere"
+)
+
+// Behavior describes how a nameserver in a Network answers queries
+type Behavior int
+
+const (
+	// BehaviorNormal answers queries using the zones the nameserver serves
+	BehaviorNormal Behavior = iota
+	// BehaviorLame answers every query with REFUSED, as a nameserver which has
+	// been delegated a zone it doesn't serve would
+	BehaviorLame
+	// BehaviorUnresponsive never answers, Exchange returns ErrTimeout
+	BehaviorUnresponsive
+	// BehaviorTruncated answers every query with an empty response with the TC
+	// bit set, as if the response didn't fit in a UDP datagram
+	BehaviorTruncated
+	// BehaviorBogus answers queries normally but corrupts every signature in the
+	// responses, so they fail validation
+	BehaviorBogus
+	// BehaviorServerFailure answers every query with SERVFAIL
+	BehaviorServerFailure
+)
+
+// ErrTimeout is returned by Exchange for queries sent to an unresponsive
+// nameserver
+var ErrTimeout = errors.New("solveretest: query timed out")
+
+// Query is a query received by a nameserver in a Network
+type Query struct {
+	// Addr is the address of the nameserver, without the port
+	Addr     string
+	Question dns.Question
+	// DNSSECOK is set if the query had the DO bit set
+	DNSSECOK bool
+}
+
+// Network is a set of fake authoritative nameservers, it implements
+// solvere.Transport and is safe for concurrent use
+type Network struct {
+	mu        sync.Mutex
+	zones     map[string]*Zone
+	servers   map[string][]*Zone
+	behaviors map[string]Behavior
+	queries   []Query
+}
+
+// NewNetwork returns an empty Network, the root zone has to be added before
+// resolvers using it can iterate
+func NewNetwork() *Network {
+	return &Network{
+		zones:     make(map[string]*Zone),
+		servers:   make(map[string][]*Zone),
+		behaviors: make(map[string]Behavior),
+	}
+}
+
+// AddZone adds a zone with a SOA record, served by nameservers named ns1, ns2, and
+// so on, in the zone with the addresses in addrs. If the zone already exists the
+// nameservers are added to it.
+func (n *Network) AddZone(origin string, addrs ...string) *Zone {
+	origin = solvere.CanonicalName(origin)
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	z, present := n.zones[origin]
+	if !present {
+		z = &Zone{Origin: origin, n: n}
+		z.records = []dns.RR{&dns.SOA{
+			Hdr:     dns.RR_Header{Name: origin, Rrtype: dns.TypeSOA, Class: dns.ClassINET, Ttl: DefaultTTL},
+			Ns:      childName("ns1", origin),
+			Mbox:    childName("hostmaster", origin),
+			Serial:  1,
+			Refresh: 3600,
+			Retry:   600,
+			Expire:  86400,
+			Minttl:  NegativeTTL,
+		}}
+		n.zones[origin] = z
+	}
+	for _, addr := range addrs {
+		z.addNameserver(childName("ns"+strconv.Itoa(len(z.nameservers())+1), origin), addr)
+	}
+	return z
+}
+
+// Zone returns the zone with the origin, or nil if it hasn't been added
+func (n *Network) Zone(origin string) *Zone {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.zones[solvere.CanonicalName(origin)]
+}
+
+// SetBehavior sets how the nameserver at addr answers queries
+func (n *Network) SetBehavior(addr string, b Behavior) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.behaviors[addr] = b
+}
+
+// Queries returns the queries received by the nameservers in the Network, in the
+// order they were received
+func (n *Network) Queries() []Query {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return append([]Query(nil), n.queries...)
+}
+
+// ResetQueries forgets the queries received so far
+func (n *Network) ResetQueries() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.queries = nil
+}
+
+// RootHints returns the NS records of the root zone and the addresses of its
+// nameservers, for use with solvere.WithRootHints
+func (n *Network) RootHints() []dns.RR {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	root := n.zones["."]
+	if root == nil {
+		return nil
+	}
+	ns := root.nameservers()
+	hints := copyRecords(ns)
+	for _, r := range ns {
+		hints = append(hints, copyRecords(root.addresses(r.(*dns.NS).Ns))...)
+	}
+	return hints
+}
+
+// TrustAnchors returns the DNSKEY records of the root zone, for use with
+// solvere.WithTrustAnchors, or nil if it isn't signed
+func (n *Network) TrustAnchors() []dns.RR {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	root := n.zones["."]
+	if root == nil || root.key == nil {
+		return nil
+	}
+	return []dns.RR{dns.Copy(root.key)}
+}
+
+// NewResolver returns a resolver which iterates from the root zone of the Network,
+// using it as its transport, and validates answers using the root zone's keys if
+// it is signed. Answers are cached in a solvere.BasicCache, opts are applied
+// afterwards and may override any of these.
+func (n *Network) NewResolver(opts ...solvere.Option) *solvere.RecursiveResolver {
+	anchors := n.TrustAnchors()
+	opts = append([]solvere.Option{
+		solvere.WithRootHints(n.RootHints()),
+		solvere.WithTrustAnchors(anchors),
+		solvere.WithValidation(anchors != nil),
+		solvere.WithCache(solvere.NewBasicCache()),
+		solvere.WithTransport(n),
+	}, opts...)
+	return solvere.NewResolver(opts...)
+}
+
+// Exchange implements solvere.Transport, m is answered by the nameserver at addr
+func (n *Network) Exchange(ctx context.Context, m *dns.Msg, addr string) (*dns.Msg, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		addr = host
+	}
+	opt := m.IsEdns0()
+	do := opt != nil && opt.Do()
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if len(m.Question) > 0 {
+		n.queries = append(n.queries, Query{Addr: addr, Question: m.Question[0], DNSSECOK: do})
+	}
+	b := n.behaviors[addr]
+	if b == BehaviorUnresponsive {
+		return nil, ErrTimeout
+	}
+	r := new(dns.Msg)
+	r.SetReply(m)
+	switch {
+	case len(m.Question) != 1 || m.Question[0].Qclass != dns.ClassINET:
+		r.Rcode = dns.RcodeFormatError
+	case b == BehaviorLame:
+		r.Rcode = dns.RcodeRefused
+	case b == BehaviorServerFailure:
+		r.Rcode = dns.RcodeServerFailure
+	default:
+		if z := n.authority(addr, m.Question[0]); z != nil {
+			z.answer(r, m.Question[0], do)
+		} else {
+			r.Rcode = dns.RcodeRefused
+		}
+	}
+	switch b {
+	case BehaviorTruncated:
+		r.Truncated = true
+		r.Answer, r.Ns, r.Extra = nil, nil, nil
+	case BehaviorBogus:
+		for _, section := range [][]dns.RR{r.Answer, r.Ns, r.Extra} {
+			for _, record := range section {
+				if sig, ok := record.(*dns.RRSIG); ok {
+					corrupt(sig)
+				}
+			}
+		}
+	}
+	if opt != nil {
+		r.SetEdns0(dns.DefaultMsgSize, do)
+	}
+	return r, nil
+}
+
+// authority returns the zone served from addr which is authoritative for q, the
+// most specific zone containing the name unless q is for the DS records of a zone
+// whose parent is also served from addr, since they are served by the parent
+func (n *Network) authority(addr string, q dns.Question) *Zone {
+	var zones []*Zone
+	for _, z := range n.servers[addr] {
+		if dns.IsSubDomain(z.Origin, q.Name) {
+			zones = append(zones, z)
+		}
+	}
+	if len(zones) == 0 {
+		return nil
+	}
+	sort.Slice(zones, func(i, j int) bool { return dns.CountLabel(zones[i].Origin) > dns.CountLabel(zones[j].Origin) })
+	if q.Qtype == dns.TypeDS && len(zones) > 1 && strings.EqualFold(zones[0].Origin, q.Name) {
+		return zones[1]
+	}
+	return zones[0]
+}
+
+// cut returns the zone below z, on the path to name, which is closest to z, or
+// nil if name is in z
+func (n *Network) cut(z *Zone, name string) *Zone {
+	var closest *Zone
+	for origin, child := range n.zones {
+		if child == z || !dns.IsSubDomain(z.Origin, origin) || !dns.IsSubDomain(origin, name) {
+			continue
+		}
+		if closest == nil || dns.CountLabel(origin) < dns.CountLabel(closest.Origin) {
+			closest = child
+		}
+	}
+	return closest
+}
+
+// corrupt flips a bit of the signature of sig
+func corrupt(sig *dns.RRSIG) {
+	b := []byte(sig.Signature)
+	if len(b) == 0 {
+		return
+	}
+	// swap the first character for another valid base64 character
+	if b[0] == 'A' {
+		b[0] = 'B'
+	} else {
+		b[0] = 'A'
+	}
+	sig.Signature = string(b)
+}
+
+// childName returns label prepended to origin
+func childName(label, origin string) string {
+	if origin == "." {
+		return label + "."
+	}
+	return label + "." + origin
+}
+
+func copyRecords(records []dns.RR) []dns.RR {
+	out := make([]dns.RR, len(records))
+	for i, r := range records {
+		out[i] = dns.Copy(r)
+	}
+	return out
+}
@@ -0,0 +1,138 @@
+package solveretest
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/miekg/dns"
+
+	"github.com/rolandshoemaker/solvere"
+)
+
+// newSignedNetwork returns a Network with a signed root, a signed example. zone, and
+// a signed signed.example. zone below it
+func newSignedNetwork(t *testing.T) *Network {
+	n := NewNetwork()
+	for _, z := range []*Zone{n.AddZone(".", "198.51.100.1"), n.AddZone("example.", "198.51.100.2"), n.AddZone("signed.example.", "198.51.100.3", "198.51.100.4")} {
+		if err := z.Sign(); err != nil {
+			t.Fatalf("Failed to sign %s: %s", z.Origin, err)
+		}
+	}
+	if err := n.Zone("signed.example.").Parse(`
+www 300 IN A 192.0.2.1
+alias 300 IN CNAME www
+a.b 300 IN TXT "deep"
+`); err != nil {
+		t.Fatalf("Failed to parse records: %s", err)
+	}
+	return n
+}
+
+func lookup(t *testing.T, rr *solvere.RecursiveResolver, name string, qtype uint16) (*solvere.Answer, error) {
+	t.Helper()
+	a, _, err := rr.Lookup(context.Background(), solvere.Question{Name: name, Type: qtype})
+	return a, err
+}
+
+func TestNetworkLookup(t *testing.T) {
+	n := newSignedNetwork(t)
+	rr := n.NewResolver(solvere.WithCache(nil))
+	rr.FailureTTL = -1
+
+	a, err := lookup(t, rr, "www.signed.example.", dns.TypeA)
+	if err != nil {
+		t.Fatalf("Lookup failed: %s", err)
+	}
+	if ips := a.IPs(); len(ips) != 1 || ips[0].String() != "192.0.2.1" || !a.Authenticated {
+		t.Fatalf("Unexpected answer: %+v", a)
+	}
+	if qs := n.Queries(); len(qs) == 0 || !qs[0].DNSSECOK || qs[0].Addr != "198.51.100.1" {
+		t.Fatalf("Unexpected queries: %+v", qs)
+	}
+
+	a, err = lookup(t, rr, "alias.signed.example.", dns.TypeA)
+	if err != nil {
+		t.Fatalf("Lookup of alias failed: %s", err)
+	}
+	if ips := a.IPs(); len(ips) != 1 || len(a.CNAMEChain()) != 2 {
+		t.Fatalf("Unexpected answer for alias: %+v", a)
+	}
+
+	for _, tc := range []struct {
+		name  string
+		qtype uint16
+		rcode int
+	}{
+		{"missing.signed.example.", dns.TypeA, dns.RcodeNameError},
+		{"www.missing.example.", dns.TypeA, dns.RcodeNameError},
+		{"www.signed.example.", dns.TypeAAAA, dns.RcodeSuccess},
+		// an empty non-terminal
+		{"b.signed.example.", dns.TypeA, dns.RcodeSuccess},
+	} {
+		a, err := lookup(t, rr, tc.name, tc.qtype)
+		if err != nil {
+			t.Fatalf("Lookup of %s %s failed: %s", tc.name, dns.TypeToString[tc.qtype], err)
+		}
+		if a.Rcode != tc.rcode || len(a.Answer) != 0 || !a.Authenticated {
+			t.Fatalf("Unexpected answer for %s %s: %+v", tc.name, dns.TypeToString[tc.qtype], a)
+		}
+	}
+}
+
+func TestNetworkInsecureDelegation(t *testing.T) {
+	n := newSignedNetwork(t)
+	n.AddZone("unsigned.example.", "198.51.100.5").Parse("www 300 IN A 192.0.2.2")
+	rr := n.NewResolver(solvere.WithCache(nil))
+
+	a, err := lookup(t, rr, "www.unsigned.example.", dns.TypeA)
+	if err != nil {
+		t.Fatalf("Lookup failed: %s", err)
+	}
+	if len(a.IPs()) != 1 || a.Authenticated {
+		t.Fatalf("Unexpected answer from unsigned zone: %+v", a)
+	}
+}
+
+func TestNetworkWildcard(t *testing.T) {
+	n := newSignedNetwork(t)
+	n.Zone("signed.example.").Parse("*.wild 300 IN A 192.0.2.3")
+	rr := n.NewResolver(solvere.WithCache(nil))
+
+	a, err := lookup(t, rr, "anything.wild.signed.example.", dns.TypeA)
+	if err != nil {
+		t.Fatalf("Lookup failed: %s", err)
+	}
+	if ips := a.IPs(); len(ips) != 1 || ips[0].String() != "192.0.2.3" || !a.Authenticated {
+		t.Fatalf("Unexpected answer synthesized from wildcard: %+v", a)
+	}
+	if a.Answer[0].Header().Name != "anything.wild.signed.example." {
+		t.Fatalf("Wildcard wasn't expanded: %s", a.Answer[0])
+	}
+}
+
+func TestNetworkBehaviors(t *testing.T) {
+	// the keys of the zone can't be fetched from a misbehaving nameserver, so
+	// every behavior causes validation to fail
+	for _, tc := range []struct {
+		behavior Behavior
+		rcode    int
+		err      error
+	}{
+		{BehaviorBogus, dns.RcodeSuccess, dns.ErrSig},
+		{BehaviorUnresponsive, -1, ErrTimeout},
+		{BehaviorLame, dns.RcodeRefused, solvere.ErrNoDNSKEY},
+		{BehaviorServerFailure, dns.RcodeServerFailure, solvere.ErrNoDNSKEY},
+		{BehaviorTruncated, dns.RcodeSuccess, solvere.ErrNoDNSKEY},
+	} {
+		n := newSignedNetwork(t)
+		rr := n.NewResolver(solvere.WithCache(nil))
+		rr.FailureTTL = -1
+		n.SetBehavior("198.51.100.2", tc.behavior)
+		_, err := lookup(t, rr, "www.signed.example.", dns.TypeA)
+		var re *solvere.ResolutionError
+		if !errors.As(err, &re) || re.Zone != "example." || re.Rcode != tc.rcode || !errors.Is(err, tc.err) {
+			t.Fatalf("Unexpected error with behavior %d: %v", tc.behavior, err)
+		}
+	}
+}
@@ -0,0 +1,449 @@
+package solveretest
+
+import (
+	"crypto"
+	"net"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"github.com/rolandshoemaker/solvere"
+)
+
+var (
+	// DefaultTTL is the TTL of the records created by a Network
+	DefaultTTL uint32 = 3600
+	// NegativeTTL is the minimum TTL of the SOA records created by a Network,
+	// and so how long negative answers are cached (RFC 2308 Section 5)
+	NegativeTTL uint32 = 300
+	// SignatureValidity is how long the signatures in responses are valid for,
+	// they are also backdated by an hour
+	SignatureValidity = 24 * time.Hour
+)
+
+// Zone is a zone in a Network, its methods are safe for concurrent use
+type Zone struct {
+	// Origin is the fully qualified, lower cased, name of the zone apex
+	Origin string
+
+	n       *Network
+	records []dns.RR
+	key     *dns.DNSKEY
+	priv    crypto.Signer
+}
+
+// Add adds records to the zone, records for names outside of the zone, or below
+// the apex of another zone in the Network, are never served
+func (z *Zone) Add(records ...dns.RR) {
+	z.n.mu.Lock()
+	defer z.n.mu.Unlock()
+	z.records = append(z.records, records...)
+}
+
+// Parse adds the records in text, in the master file format (RFC 1035 Section 5),
+// to the zone. Relative names are relative to the zone apex.
+func (z *Zone) Parse(text string) error {
+	var records []dns.RR
+	var err error
+	for t := range dns.ParseZone(strings.NewReader(text), z.Origin, "") {
+		// the channel has to be drained even after an error
+		if t.Error != nil {
+			if err == nil {
+				err = t.Error
+			}
+			continue
+		}
+		records = append(records, t.RR)
+	}
+	if err != nil {
+		return err
+	}
+	z.Add(records...)
+	return nil
+}
+
+// AddNameserver adds a NS record for name to the zone and serves it from addr. If
+// name is in the zone an address record is also added, which is used as glue in
+// referrals from the parent zone, otherwise the address record of name has to be
+// added to the zone containing it.
+func (z *Zone) AddNameserver(name, addr string) {
+	z.n.mu.Lock()
+	defer z.n.mu.Unlock()
+	z.addNameserver(solvere.CanonicalName(name), addr)
+}
+
+func (z *Zone) addNameserver(name, addr string) {
+	z.records = append(z.records, &dns.NS{
+		Hdr: dns.RR_Header{Name: z.Origin, Rrtype: dns.TypeNS, Class: dns.ClassINET, Ttl: DefaultTTL},
+		Ns:  name,
+	})
+	if ip := net.ParseIP(addr); ip != nil && dns.IsSubDomain(z.Origin, name) {
+		if ip4 := ip.To4(); ip4 != nil {
+			z.records = append(z.records, &dns.A{Hdr: dns.RR_Header{Name: name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: DefaultTTL}, A: ip4})
+		} else {
+			z.records = append(z.records, &dns.AAAA{Hdr: dns.RR_Header{Name: name, Rrtype: dns.TypeAAAA, Class: dns.ClassINET, Ttl: DefaultTTL}, AAAA: ip})
+		}
+	}
+	for _, served := range z.n.servers[addr] {
+		if served == z {
+			return
+		}
+	}
+	z.n.servers[addr] = append(z.n.servers[addr], z)
+}
+
+// Sign generates a key for the zone which is used to sign responses to queries
+// with the DO bit set. The DNSKEY and NSEC3PARAM records are added to the zone, and
+// if the parent zone is signed the DS record is added to referrals from it.
+func (z *Zone) Sign() error {
+	key := &dns.DNSKEY{
+		Hdr:       dns.RR_Header{Name: z.Origin, Rrtype: dns.TypeDNSKEY, Class: dns.ClassINET, Ttl: DefaultTTL},
+		Flags:     257,
+		Protocol:  3,
+		Algorithm: dns.ECDSAP256SHA256,
+	}
+	priv, err := key.Generate(256)
+	if err != nil {
+		return err
+	}
+	z.n.mu.Lock()
+	defer z.n.mu.Unlock()
+	z.key, z.priv = key, priv.(crypto.Signer)
+	z.records = append(z.records, key, &dns.NSEC3PARAM{
+		Hdr:  dns.RR_Header{Name: z.Origin, Rrtype: dns.TypeNSEC3PARAM, Class: dns.ClassINET, Ttl: DefaultTTL},
+		Hash: dns.SHA1,
+	})
+	return nil
+}
+
+// Key returns the key the zone is signed with, or nil if it isn't signed
+func (z *Zone) Key() *dns.DNSKEY {
+	z.n.mu.Lock()
+	defer z.n.mu.Unlock()
+	if z.key == nil {
+		return nil
+	}
+	return dns.Copy(z.key).(*dns.DNSKEY)
+}
+
+// DS returns the DS record for the key the zone is signed with, or nil if it
+// isn't signed
+func (z *Zone) DS() *dns.DS {
+	z.n.mu.Lock()
+	defer z.n.mu.Unlock()
+	return z.ds()
+}
+
+func (z *Zone) ds() *dns.DS {
+	if z.key == nil {
+		return nil
+	}
+	ds := z.key.ToDS(dns.SHA256)
+	ds.Hdr.Ttl = DefaultTTL
+	return ds
+}
+
+func (z *Zone) nameservers() []dns.RR {
+	return z.rrset(z.Origin, dns.TypeNS)
+}
+
+// addresses returns the A and AAAA records for name in the zone
+func (z *Zone) addresses(name string) []dns.RR {
+	return append(z.rrset(name, dns.TypeA), z.rrset(name, dns.TypeAAAA)...)
+}
+
+func (z *Zone) rrset(name string, t uint16) []dns.RR {
+	var set []dns.RR
+	for _, r := range z.records {
+		if h := r.Header(); h.Rrtype == t && strings.EqualFold(h.Name, name) {
+			set = append(set, r)
+		}
+	}
+	return set
+}
+
+// zoneData is a snapshot of the authoritative data in a zone
+type zoneData struct {
+	z *Zone
+	// names maps the lower cased owner names in the zone to their records
+	names map[string][]dns.RR
+	// cuts maps the lower cased names of the delegation points in the zone to
+	// the delegated zones
+	cuts map[string]*Zone
+	// exists contains every name in the zone, including empty non-terminals
+	exists map[string]struct{}
+}
+
+func (z *Zone) data() *zoneData {
+	d := &zoneData{z: z, names: make(map[string][]dns.RR), cuts: make(map[string]*Zone), exists: make(map[string]struct{})}
+	for origin, child := range z.n.zones {
+		if child != z && dns.IsSubDomain(z.Origin, origin) && z.n.cut(z, origin) == child {
+			d.cuts[origin] = child
+		}
+	}
+	for _, r := range z.records {
+		name := strings.ToLower(r.Header().Name)
+		if !dns.IsSubDomain(z.Origin, name) || d.occluded(name) {
+			continue
+		}
+		d.names[name] = append(d.names[name], r)
+	}
+	for name := range d.cuts {
+		d.addName(name)
+	}
+	for name := range d.names {
+		d.addName(name)
+	}
+	return d
+}
+
+// occluded checks if name is at or below a delegation point
+func (d *zoneData) occluded(name string) bool {
+	for cut := range d.cuts {
+		if dns.IsSubDomain(cut, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// addName adds name and the names between it and the apex to exists
+func (d *zoneData) addName(name string) {
+	for ; dns.IsSubDomain(d.z.Origin, name); name = parent(name) {
+		if _, present := d.exists[name]; present {
+			return
+		}
+		d.exists[name] = struct{}{}
+		if name == d.z.Origin {
+			return
+		}
+	}
+}
+
+// closestEncloser returns the longest existing name which name is below
+func (d *zoneData) closestEncloser(name string) string {
+	for ; name != d.z.Origin; name = parent(name) {
+		if _, present := d.exists[name]; present {
+			return name
+		}
+	}
+	return name
+}
+
+// answer fills in r to answer q, signing the response if do is set and the zone
+// is signed
+func (z *Zone) answer(r *dns.Msg, q dns.Question, do bool) {
+	d := z.data()
+	signed := do && z.key != nil
+	name := strings.ToLower(q.Name)
+
+	for cut, child := range d.cuts {
+		if !dns.IsSubDomain(cut, name) || (name == cut && q.Qtype == dns.TypeDS) {
+			continue
+		}
+		// referral to the delegated zone
+		r.Ns = copyRecords(child.nameservers())
+		for _, ns := range child.nameservers() {
+			if target := ns.(*dns.NS).Ns; dns.IsSubDomain(cut, target) {
+				r.Extra = append(r.Extra, copyRecords(child.addresses(target))...)
+			}
+		}
+		if signed {
+			if ds := child.ds(); ds != nil {
+				r.Ns = append(r.Ns, z.sign(ds)...)
+			} else {
+				r.Ns = append(r.Ns, z.sign(d.nsec3(cut, true)...)...)
+			}
+		}
+		return
+	}
+
+	r.Authoritative = true
+	records := d.names[name]
+	if child, present := d.cuts[name]; present && q.Qtype == dns.TypeDS {
+		if ds := child.ds(); ds != nil && z.key != nil {
+			records = []dns.RR{ds}
+		}
+	}
+	if _, present := d.exists[name]; !present {
+		ce := d.closestEncloser(name)
+		wildcard := childName("*", ce)
+		if _, present := d.names[wildcard]; !present {
+			r.Rcode = dns.RcodeNameError
+			r.Ns = z.negative(d, signed, d.nsec3(ce, true), d.nsec3(nextCloser(name, ce), false), d.nsec3(wildcard, false))
+			return
+		}
+		// synthesize the answer from the wildcard, proving the next closer name
+		// doesn't exist
+		answer := matching(d.names[wildcard], q.Qtype)
+		if len(answer) == 0 {
+			r.Ns = z.negative(d, signed, d.nsec3(ce, true), d.nsec3(nextCloser(name, ce), false), d.nsec3(wildcard, true))
+			return
+		}
+		r.Answer = z.synthesize(answer, q.Name, signed)
+		if signed {
+			r.Ns = z.sign(d.nsec3(nextCloser(name, ce), false)...)
+		}
+		return
+	}
+	answer := matching(records, q.Qtype)
+	if len(answer) == 0 {
+		r.Ns = z.negative(d, signed, d.nsec3(name, true))
+		return
+	}
+	r.Answer = copyRecords(answer)
+	if signed {
+		r.Answer = z.sign(r.Answer...)
+	}
+}
+
+// matching returns the records of type t, or the CNAME records if there are none
+// and t isn't CNAME
+func matching(records []dns.RR, t uint16) []dns.RR {
+	var out, cnames []dns.RR
+	for _, r := range records {
+		switch rt := r.Header().Rrtype; {
+		case rt == t || (t == dns.TypeANY && rt != dns.TypeRRSIG):
+			out = append(out, r)
+		case rt == dns.TypeCNAME:
+			cnames = append(cnames, r)
+		}
+	}
+	if len(out) == 0 && t != dns.TypeCNAME {
+		return cnames
+	}
+	return out
+}
+
+// negative returns the authority section of a negative response, containing the
+// SOA record and, if signed is set, the NSEC3 records in proof
+func (z *Zone) negative(d *zoneData, signed bool, proof ...[]dns.RR) []dns.RR {
+	soa := dns.Copy(d.names[z.Origin][0]).(*dns.SOA)
+	if soa.Minttl < soa.Hdr.Ttl {
+		soa.Hdr.Ttl = soa.Minttl
+	}
+	if !signed {
+		return []dns.RR{soa}
+	}
+	ns := z.sign(soa)
+	seen := map[string]struct{}{}
+	for _, records := range proof {
+		for _, r := range records {
+			if _, present := seen[r.Header().Name]; present {
+				continue
+			}
+			seen[r.Header().Name] = struct{}{}
+			ns = append(ns, z.sign(r)...)
+		}
+	}
+	return ns
+}
+
+// synthesize returns copies of the records expanded from a wildcard with the
+// owner name, with their signatures if signed is set
+func (z *Zone) synthesize(records []dns.RR, name string, signed bool) []dns.RR {
+	out := copyRecords(records)
+	if signed {
+		// the signatures cover the wildcard owner name
+		out = z.sign(out...)
+	}
+	for _, r := range out {
+		r.Header().Name = name
+	}
+	return out
+}
+
+// sign returns records followed by a RRSIG for each RRset in them
+func (z *Zone) sign(records ...dns.RR) []dns.RR {
+	out := append([]dns.RR(nil), records...)
+	now := time.Now()
+	for _, set := range solvere.GroupRRSets(records) {
+		sig := &dns.RRSIG{
+			Hdr:        dns.RR_Header{Ttl: set.Records[0].Header().Ttl},
+			Algorithm:  z.key.Algorithm,
+			SignerName: z.Origin,
+			KeyTag:     z.key.KeyTag(),
+			Inception:  uint32(now.Add(-time.Hour).Unix()),
+			Expiration: uint32(now.Add(SignatureValidity).Unix()),
+		}
+		if err := sig.Sign(z.priv, set.Records); err != nil {
+			// only returned for invalid keys or records that can't be packed
+			panic("solveretest: signing " + set.Name + " failed: " + err.Error())
+		}
+		out = append(out, sig)
+	}
+	return out
+}
+
+// nsec3 returns the NSEC3 record matching name if match is set, otherwise the
+// record covering it, using no salt or additional iterations (RFC 9276)
+func (d *zoneData) nsec3(name string, match bool) []dns.RR {
+	type entry struct {
+		hash  string
+		types []uint16
+	}
+	entries := make([]entry, 0, len(d.exists))
+	for n := range d.exists {
+		entries = append(entries, entry{dns.HashName(n, dns.SHA1, 0, ""), d.types(n)})
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].hash < entries[j].hash })
+	h := dns.HashName(name, dns.SHA1, 0, "")
+	// the record matching h, or the last record before it, wrapping around to
+	// the last record
+	i := sort.Search(len(entries), func(i int) bool { return entries[i].hash > h }) - 1
+	if i < 0 {
+		i = len(entries) - 1
+	}
+	if match && entries[i].hash != h {
+		return nil
+	}
+	next := entries[(i+1)%len(entries)]
+	return []dns.RR{&dns.NSEC3{
+		Hdr:        dns.RR_Header{Name: childName(strings.ToLower(entries[i].hash), d.z.Origin), Rrtype: dns.TypeNSEC3, Class: dns.ClassINET, Ttl: NegativeTTL},
+		Hash:       dns.SHA1,
+		HashLength: 20,
+		NextDomain: next.hash,
+		TypeBitMap: entries[i].types,
+	}}
+}
+
+// types returns the types of the records at name, as they appear in the type bit
+// map of its NSEC3 record
+func (d *zoneData) types(name string) []uint16 {
+	set := map[uint16]struct{}{}
+	if child, present := d.cuts[name]; present {
+		set[dns.TypeNS] = struct{}{}
+		if child.key != nil {
+			set[dns.TypeDS] = struct{}{}
+			set[dns.TypeRRSIG] = struct{}{}
+		}
+	}
+	for _, r := range d.names[name] {
+		set[r.Header().Rrtype] = struct{}{}
+		set[dns.TypeRRSIG] = struct{}{}
+	}
+	types := make([]uint16, 0, len(set))
+	for t := range set {
+		types = append(types, t)
+	}
+	sort.Slice(types, func(i, j int) bool { return types[i] < types[j] })
+	return types
+}
+
+// nextCloser returns the name one label longer than the closest encloser ce on
+// the path to name
+func nextCloser(name, ce string) string {
+	labels := dns.Split(name)
+	return name[labels[len(labels)-dns.CountLabel(ce)-1]:]
+}
+
+// parent returns name with its first label removed
+func parent(name string) string {
+	off, end := dns.NextLabel(name, 0)
+	if end {
+		return "."
+	}
+	return name[off:]
+}
@@ -0,0 +1,95 @@
+package solveretest
+
+import (
+	"context"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func exchange(t *testing.T, n *Network, addr, name string, qtype uint16, do bool) *dns.Msg {
+	t.Helper()
+	m := new(dns.Msg)
+	m.SetQuestion(name, qtype)
+	if do {
+		m.SetEdns0(4096, true)
+	}
+	r, err := n.Exchange(context.Background(), m, addr+":53")
+	if err != nil {
+		t.Fatalf("Exchange failed: %s", err)
+	}
+	return r
+}
+
+func count(records []dns.RR, t uint16) int {
+	c := 0
+	for _, r := range records {
+		if r.Header().Rrtype == t {
+			c++
+		}
+	}
+	return c
+}
+
+func TestZoneReferral(t *testing.T) {
+	n := newSignedNetwork(t)
+	r := exchange(t, n, "198.51.100.2", "www.signed.example.", dns.TypeA, true)
+	if r.Authoritative || len(r.Answer) != 0 || count(r.Ns, dns.TypeNS) != 2 || count(r.Ns, dns.TypeDS) != 1 || count(r.Ns, dns.TypeRRSIG) != 1 {
+		t.Fatalf("Unexpected referral: %s", r)
+	}
+	if count(r.Extra, dns.TypeA) != 2 {
+		t.Fatalf("Referral didn't include glue: %s", r)
+	}
+
+	// the DS records are served by the parent
+	r = exchange(t, n, "198.51.100.2", "signed.example.", dns.TypeDS, true)
+	if !r.Authoritative || count(r.Answer, dns.TypeDS) != 1 {
+		t.Fatalf("Unexpected answer for DS query: %s", r)
+	}
+	if r.Answer[0].(*dns.DS).Digest != n.Zone("signed.example.").DS().Digest {
+		t.Fatalf("DS doesn't match the key of the zone: %s", r.Answer[0])
+	}
+
+	n.AddZone("unsigned.example.", "198.51.100.5")
+	r = exchange(t, n, "198.51.100.2", "www.unsigned.example.", dns.TypeA, true)
+	if count(r.Ns, dns.TypeDS) != 0 || count(r.Ns, dns.TypeNSEC3) != 1 {
+		t.Fatalf("Insecure referral didn't include a NSEC3 record: %s", r)
+	}
+	if types := r.Ns[len(r.Ns)-2].(*dns.NSEC3).TypeBitMap; len(types) != 1 || types[0] != dns.TypeNS {
+		t.Fatalf("Unexpected types for delegation: %v", types)
+	}
+}
+
+func TestZoneAnswers(t *testing.T) {
+	n := newSignedNetwork(t)
+	r := exchange(t, n, "198.51.100.3", "www.signed.example.", dns.TypeA, false)
+	if !r.Authoritative || len(r.Answer) != 1 || r.IsEdns0() != nil {
+		t.Fatalf("Unexpected answer without DO bit: %s", r)
+	}
+	r = exchange(t, n, "198.51.100.3", "missing.signed.example.", dns.TypeA, true)
+	// the closest encloser, next closer name and wildcard are the apex,
+	// missing.signed.example. and *.signed.example. which need three records
+	if r.Rcode != dns.RcodeNameError || count(r.Ns, dns.TypeSOA) != 1 || count(r.Ns, dns.TypeNSEC3) != 3 {
+		t.Fatalf("Unexpected NXDOMAIN response: %s", r)
+	}
+	if r.Ns[0].Header().Ttl != NegativeTTL {
+		t.Fatalf("SOA TTL wasn't capped: %s", r.Ns[0])
+	}
+	r = exchange(t, n, "198.51.100.3", "b.signed.example.", dns.TypeA, true)
+	if r.Rcode != dns.RcodeSuccess || len(r.Answer) != 0 || count(r.Ns, dns.TypeNSEC3) != 1 {
+		t.Fatalf("Unexpected response for empty non-terminal: %s", r)
+	}
+	r = exchange(t, n, "198.51.100.3", "www.other.", dns.TypeA, false)
+	if r.Rcode != dns.RcodeRefused {
+		t.Fatalf("Unexpected response for name the nameserver isn't authoritative for: %s", r)
+	}
+
+	if err := n.Zone("signed.example.").Parse("bad record"); err == nil {
+		t.Fatal("Parse didn't fail for invalid record")
+	}
+	n.ResetQueries()
+	exchange(t, n, "198.51.100.4", "www.signed.example.", dns.TypeA, false)
+	if qs := n.Queries(); len(qs) != 1 || qs[0].Addr != "198.51.100.4" || qs[0].Question.Name != "www.signed.example." || qs[0].DNSSECOK {
+		t.Fatalf("Unexpected queries: %+v", qs)
+	}
+}
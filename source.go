@@ -0,0 +1,164 @@
+package solvere
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"time"
+)
+
+// ErrNoSource is returned by RecursiveResolver.Lookup when none of the resolver's
+// Sources answered the question
+var ErrNoSource = errors.New("solvere: No source answered the question")
+
+// Source is a stage of the pipeline RecursiveResolver.Lookup passes questions
+// through, such as local data, the cache, or iterative resolution. Each source
+// either answers the question, fails it, or passes it on to the next source.
+type Source interface {
+	// Lookup answers q, returning a nil answer and error to pass q on to the
+	// next source. The LookupLog may be nil, in which case a empty one is used.
+	Lookup(ctx context.Context, rr *RecursiveResolver, q Question) (*Answer, *LookupLog, error)
+}
+
+// SourceFunc is a function implementing Source
+type SourceFunc func(ctx context.Context, rr *RecursiveResolver, q Question) (*Answer, *LookupLog, error)
+
+// Lookup calls f
+func (f SourceFunc) Lookup(ctx context.Context, rr *RecursiveResolver, q Question) (*Answer, *LookupLog, error) {
+	return f(ctx, rr, q)
+}
+
+var (
+	// LocalDataSource answers questions using RecursiveResolver.LocalData
+	LocalDataSource Source = SourceFunc(func(_ context.Context, rr *RecursiveResolver, q Question) (*Answer, *LookupLog, error) {
+		if rr.LocalData == nil {
+			return nil, nil, nil
+		}
+		return rr.LocalData.lookup(q), nil, nil
+	})
+	// LocalZoneSource answers questions for names in RecursiveResolver.LocalZones
+	LocalZoneSource Source = SourceFunc(func(_ context.Context, rr *RecursiveResolver, q Question) (*Answer, *LookupLog, error) {
+		if lz := rr.localZone(q.Name); lz != nil {
+			return lz.lookup(q), nil, nil
+		}
+		return nil, nil, nil
+	})
+	// SpecialUseSource answers questions for special-use domain names, see
+	// RecursiveResolver.SpecialUseDomains
+	SpecialUseSource Source = SourceFunc(func(_ context.Context, rr *RecursiveResolver, q Question) (*Answer, *LookupLog, error) {
+		return rr.specialUse(q), nil, nil
+	})
+	// NegativeCacheSource fails questions which recently failed validation or
+	// couldn't be resolved, see RecursiveResolver.BogusTTL and FailureTTL
+	NegativeCacheSource Source = SourceFunc(func(ctx context.Context, rr *RecursiveResolver, q Question) (*Answer, *LookupLog, error) {
+		if rr.bogusTTL() > 0 && rr.validating(ctx) {
+			if _, err, cached := rr.failures.get(q, validationFailure); cached {
+				return nil, newNegativeCacheLog(&q), err
+			}
+		}
+		if rr.failureTTL() > 0 {
+			if a, err, cached := rr.failures.get(q, resolutionFailure); cached {
+				return a, newNegativeCacheLog(&q), err
+			}
+		}
+		return nil, nil, nil
+	})
+	// CacheSource answers questions using the cached answer for them
+	CacheSource Source = SourceFunc(func(ctx context.Context, rr *RecursiveResolver, q Question) (*Answer, *LookupLog, error) {
+		// answers cached by a resolver that isn't validating may not have their
+		// DNSSEC records
+		if rr.cache == nil || cacheSkipped(ctx) || (!rr.useDNSSEC && dnssecRecordsRequested(ctx)) {
+			return nil, nil, nil
+		}
+		a := rr.getFromCache(ctx, &q)
+		if a == nil {
+			return nil, nil, nil
+		}
+		atomic.AddUint64(&rr.counters().cacheHits, 1)
+		// the answer is logged the way a query answered from the cache is
+		step := newLookupLog(&q, nil)
+		step.CacheHit = true
+		step.Cache = CacheAnswer
+		step.DNSSECValid = a.Authenticated
+		step.Rcode = a.Rcode
+		step.Latency = time.Since(step.Started)
+		ll := newLookupLog(&q, nil)
+		ll.Composites = []*LookupLog{step}
+		ll.DNSSECValid = a.Authenticated
+		ll.Latency = step.Latency
+		c := *a
+		c.Answer = orderAddresses(a.Answer, rr.AddressOrder, int(atomic.AddUint32(&rr.counters().rotation, 1)-1))
+		var err error
+		if rr.Rebinding != nil {
+			a, err = rr.Rebinding.filter(q, &c)
+		} else {
+			a = &c
+		}
+		return a, ll, err
+	})
+	// ForwarderSource resolves questions for names which should be forwarded,
+	// see RecursiveResolver.Forward, ForwardZones and StubZones
+	ForwarderSource Source = SourceFunc(func(ctx context.Context, rr *RecursiveResolver, q Question) (*Answer, *LookupLog, error) {
+		fc := rr.forwardConfig(q.Name)
+		if fc == nil {
+			return nil, nil, nil
+		}
+		return rr.resolve(ctx, q, func(ctx context.Context, q Question) (*Answer, *LookupLog, error) {
+			return rr.forward(ctx, fc, q)
+		})
+	})
+	// IterationSource resolves questions by iterating from the root, or the
+	// closest primed delegation
+	IterationSource Source = SourceFunc(func(ctx context.Context, rr *RecursiveResolver, q Question) (*Answer, *LookupLog, error) {
+		return rr.resolve(ctx, q, rr.lookup)
+	})
+)
+
+// DefaultSources returns the sources used by a RecursiveResolver whose Sources
+// aren't set, in order: LocalDataSource, LocalZoneSource, ProviderSource,
+// SpecialUseSource, NegativeCacheSource, CacheSource, ForwarderSource and
+// IterationSource. A new slice is returned each time so it can be modified, e.g.
+//
+//	hosts, err := solvere.LoadHostsFile("/etc/hosts")
+//	...
+//	sources := solvere.DefaultSources()
+//	// answer from the hosts file after the local data
+//	rr.Sources = append(sources[:1], append([]solvere.Source{hosts}, sources[1:]...)...)
+func DefaultSources() []Source {
+	return []Source{
+		LocalDataSource,
+		LocalZoneSource,
+		ProviderSource,
+		SpecialUseSource,
+		NegativeCacheSource,
+		CacheSource,
+		ForwarderSource,
+		IterationSource,
+	}
+}
+
+// lookupSources passes q through each of the resolver's sources until one of
+// them answers or fails it
+func (rr *RecursiveResolver) lookupSources(ctx context.Context, q Question) (*Answer, *LookupLog, error) {
+	sources := rr.Sources
+	if sources == nil {
+		sources = DefaultSources()
+	}
+	started := time.Now()
+	for _, s := range sources {
+		a, ll, err := s.Lookup(ctx, rr, q)
+		if a == nil && err == nil {
+			continue
+		}
+		if ll == nil {
+			ll = newLookupLog(&q, nil)
+			ll.Started = started
+			ll.Latency = time.Since(started)
+		}
+		return a, ll, err
+	}
+	ll := newLookupLog(&q, nil)
+	ll.Started = started
+	ll.Latency = time.Since(started)
+	return nil, ll, ErrNoSource
+}
@@ -0,0 +1,71 @@
+package solvere
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestSources(t *testing.T) {
+	rr := NewResolver(WithCache(NewBasicCache()), WithValidation(false))
+	rr.Forward = &ForwardConfig{Servers: []string{"192.0.2.1:53"}, Attempts: 1}
+	queries := 0
+	rr.Transport = transportFunc(func(_ context.Context, m *dns.Msg, _ string) (*dns.Msg, error) {
+		queries++
+		r := new(dns.Msg)
+		r.SetReply(m)
+		r.Answer = []dns.RR{&dns.A{Hdr: dns.RR_Header{Name: m.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60}, A: net.IP{192, 0, 2, 2}}}
+		return r, nil
+	})
+	hosts := NewLocalData([]dns.RR{&dns.A{Hdr: dns.RR_Header{Name: "printer.home.", Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60}, A: net.IP{192, 0, 2, 10}}})
+	var asked []string
+	logging := SourceFunc(func(_ context.Context, _ *RecursiveResolver, q Question) (*Answer, *LookupLog, error) {
+		asked = append(asked, q.Name)
+		return nil, nil, nil
+	})
+	sources := DefaultSources()
+	rr.Sources = append([]Source{logging, hosts}, sources...)
+
+	a, ll, err := rr.Lookup(context.Background(), Question{Name: "printer.home.", Type: dns.TypeA})
+	if err != nil {
+		t.Fatalf("Lookup failed: %s", err)
+	}
+	if len(a.Answer) != 1 || !a.Answer[0].(*dns.A).A.Equal(net.IP{192, 0, 2, 10}) || queries != 0 || ll == nil {
+		t.Fatalf("Question wasn't answered by the inserted source: %v", a)
+	}
+	if _, _, err = rr.Lookup(context.Background(), Question{Name: "www.example.", Type: dns.TypeA}); err != nil {
+		t.Fatalf("Lookup failed: %s", err)
+	}
+	if queries != 1 || len(asked) != 2 {
+		t.Fatalf("Question wasn't passed through the sources: %d queries, asked %v", queries, asked)
+	}
+
+	// without a resolving source nothing can be answered
+	rr.Sources = []Source{LocalDataSource, CacheSource}
+	if _, _, err = rr.Lookup(context.Background(), Question{Name: "other.example.", Type: dns.TypeA}); err != ErrNoSource {
+		t.Fatalf("Expected ErrNoSource, got %v", err)
+	}
+}
+
+func TestCacheSource(t *testing.T) {
+	rr := NewResolver(WithCache(NewBasicCache()), WithValidation(false))
+	q := Question{Name: "cached.example.", Type: dns.TypeA}
+	rr.cache.Add(&q, &Answer{Answer: []dns.RR{&dns.A{Hdr: dns.RR_Header{Name: q.Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60}, A: net.IP{10, 0, 0, 1}}}}, false)
+	a, ll, err := CacheSource.Lookup(context.Background(), rr, q)
+	if err != nil || a == nil || len(a.Answer) != 1 {
+		t.Fatalf("Cached answer wasn't returned: %v, %v", a, err)
+	}
+	if len(ll.Composites) != 1 || ll.Composites[0].Cache != CacheAnswer {
+		t.Fatalf("Unexpected lookup log: %#v", ll)
+	}
+	if a, _, _ := CacheSource.Lookup(context.Background(), rr, Question{Name: "other.example.", Type: dns.TypeA}); a != nil {
+		t.Fatalf("Answer returned for uncached question: %v", a)
+	}
+	// cached answers are still filtered
+	rr.Rebinding = &RebindingProtection{}
+	if a, _, err := CacheSource.Lookup(context.Background(), rr, q); err == nil && len(a.Answer) != 0 {
+		t.Fatalf("Cached private address wasn't filtered: %v", a)
+	}
+}
@@ -0,0 +1,180 @@
+package solvere
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// SpecialUse describes how names in a special-use domain are handled
+type SpecialUse int
+
+const (
+	// SpecialUseResolve resolves names as normal
+	SpecialUseResolve SpecialUse = iota
+	// SpecialUseNXDOMAIN answers every query with NXDOMAIN
+	SpecialUseNXDOMAIN
+	// SpecialUseRefuse answers every query with REFUSED
+	SpecialUseRefuse
+	// SpecialUseLoopback answers address queries for every name with the
+	// loopback addresses, and other queries with NODATA
+	SpecialUseLoopback
+	// SpecialUseEmptyZone answers as if the domain were a zone containing
+	// only a SOA record at its apex (RFC 6303 Section 3)
+	SpecialUseEmptyZone
+)
+
+// specialUseTTL is the TTL of the records in answers for special-use names
+const specialUseTTL = 10800
+
+// DefaultSpecialUseDomains is used when RecursiveResolver.SpecialUseDomains is nil,
+// it contains the special-use domains which caching resolvers are expected to
+// answer themselves rather than sending queries for them to the root (RFC 6761
+// Section 6, RFC 6762 Section 22, RFC 7686 Section 2, RFC 8375 Section 4 and
+// RFC 9476 Section 2).
+var DefaultSpecialUseDomains = map[string]SpecialUse{
+	"localhost.": SpecialUseLoopback,
+	"invalid.":   SpecialUseNXDOMAIN,
+	"test.":      SpecialUseNXDOMAIN,
+	"local.":     SpecialUseNXDOMAIN,
+	"onion.":     SpecialUseNXDOMAIN,
+	"home.arpa.": SpecialUseNXDOMAIN,
+	"alt.":       SpecialUseNXDOMAIN,
+}
+
+// LocallyServedZones are the reverse zones for the private, loopback, link-local,
+// and documentation address ranges, queries for which only reach the AS112 servers
+// when leaked (RFC 6303 Section 4, RFC 7793). They are included in
+// DefaultSpecialUseDomains as empty zones.
+var LocallyServedZones = locallyServedZones()
+
+func locallyServedZones() []string {
+	zones := []string{
+		"10.in-addr.arpa.",
+		"168.192.in-addr.arpa.",
+		"0.in-addr.arpa.",
+		"127.in-addr.arpa.",
+		"254.169.in-addr.arpa.",
+		"2.0.192.in-addr.arpa.",
+		"100.51.198.in-addr.arpa.",
+		"113.0.203.in-addr.arpa.",
+		"255.255.255.255.in-addr.arpa.",
+		// the unspecified and loopback addresses
+		strings.Repeat("0.", 32) + "ip6.arpa.",
+		"1." + strings.Repeat("0.", 31) + "ip6.arpa.",
+		// unique local and link-local addresses
+		"d.f.ip6.arpa.",
+		"8.e.f.ip6.arpa.",
+		"9.e.f.ip6.arpa.",
+		"a.e.f.ip6.arpa.",
+		"b.e.f.ip6.arpa.",
+		// 2001:db8::/32
+		"8.b.d.0.1.0.0.2.ip6.arpa.",
+	}
+	for i := 16; i < 32; i++ {
+		zones = append(zones, fmt.Sprintf("%d.172.in-addr.arpa.", i))
+	}
+	// the shared address space, 100.64.0.0/10 (RFC 6598)
+	for i := 64; i < 128; i++ {
+		zones = append(zones, fmt.Sprintf("%d.100.in-addr.arpa.", i))
+	}
+	return zones
+}
+
+func init() {
+	for _, zone := range LocallyServedZones {
+		DefaultSpecialUseDomains[zone] = SpecialUseEmptyZone
+	}
+}
+
+func (rr *RecursiveResolver) specialUseDomains() map[string]SpecialUse {
+	if rr.SpecialUseDomains != nil {
+		return rr.SpecialUseDomains
+	}
+	return DefaultSpecialUseDomains
+}
+
+// specialUseSOA returns the SOA record included in negative answers for names in
+// the special-use domain zone
+func specialUseSOA(zone string) []dns.RR {
+	return []dns.RR{&dns.SOA{
+		Hdr:     dns.RR_Header{Name: zone, Rrtype: dns.TypeSOA, Class: dns.ClassINET, Ttl: specialUseTTL},
+		Ns:      zone,
+		Mbox:    "nobody.invalid.",
+		Serial:  1,
+		Refresh: 3600,
+		Retry:   1200,
+		Expire:  604800,
+		Minttl:  specialUseTTL,
+	}}
+}
+
+// loopbackAnswer returns the answer to q for a name that resolves to the loopback
+// addresses
+func loopbackAnswer(q Question, zone string) *Answer {
+	a := &Answer{Rcode: dns.RcodeSuccess, Authoritative: true}
+	if q.Type == dns.TypeA || q.Type == dns.TypeANY {
+		a.Answer = append(a.Answer, &dns.A{
+			Hdr: dns.RR_Header{Name: q.Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: specialUseTTL},
+			A:   net.IPv4(127, 0, 0, 1),
+		})
+	}
+	if q.Type == dns.TypeAAAA || q.Type == dns.TypeANY {
+		a.Answer = append(a.Answer, &dns.AAAA{
+			Hdr:  dns.RR_Header{Name: q.Name, Rrtype: dns.TypeAAAA, Class: dns.ClassINET, Ttl: specialUseTTL},
+			AAAA: net.IPv6loopback,
+		})
+	}
+	if len(a.Answer) == 0 {
+		a.Authority = specialUseSOA(zone)
+	}
+	return a
+}
+
+// emptyZoneAnswer returns the answer to q from a zone containing only a SOA record
+func emptyZoneAnswer(q Question, zone string) *Answer {
+	if !strings.EqualFold(q.Name, zone) {
+		return &Answer{Rcode: dns.RcodeNameError, Authority: specialUseSOA(zone), Authoritative: true}
+	}
+	if q.Type == dns.TypeSOA || q.Type == dns.TypeANY {
+		return &Answer{Rcode: dns.RcodeSuccess, Answer: specialUseSOA(zone), Authoritative: true}
+	}
+	return &Answer{Rcode: dns.RcodeSuccess, Authority: specialUseSOA(zone), Authoritative: true}
+}
+
+// specialUse returns the answer for q if it is in a special-use domain that isn't
+// resolved, or nil. Zones in ForwardZones or StubZones take precedence over any
+// special-use domain that contains them, so e.g. home.arpa. can be forwarded to a
+// local router.
+func (rr *RecursiveResolver) specialUse(q Question) *Answer {
+	domains := rr.specialUseDomains()
+	if len(domains) == 0 {
+		return nil
+	}
+	for _, zone := range enclosingZones(q.Name) {
+		if _, present := rr.ForwardZones[zone]; present {
+			return nil
+		}
+		if _, present := rr.StubZones[zone]; present {
+			return nil
+		}
+		action, present := domains[zone]
+		if !present {
+			continue
+		}
+		switch action {
+		case SpecialUseNXDOMAIN:
+			return &Answer{Rcode: dns.RcodeNameError, Authority: specialUseSOA(zone), Authoritative: true}
+		case SpecialUseRefuse:
+			return &Answer{Rcode: dns.RcodeRefused}
+		case SpecialUseLoopback:
+			return loopbackAnswer(q, zone)
+		case SpecialUseEmptyZone:
+			return emptyZoneAnswer(q, zone)
+		}
+		return nil
+	}
+	return nil
+}
@@ -0,0 +1,120 @@
+package solvere
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestSpecialUse(t *testing.T) {
+	rr := NewRecursiveResolver(false, false, nil, nil, nil)
+	rr.Forward = &ForwardConfig{Servers: []string{"10.0.0.1"}, Attempts: 1}
+	sent := 0
+	rr.Transport = transportFunc(func(_ context.Context, m *dns.Msg, _ string) (*dns.Msg, error) {
+		sent++
+		return nil, errors.New("broken")
+	})
+	for _, tc := range []struct {
+		q     Question
+		rcode int
+		addrs int
+	}{
+		{Question{Name: "localhost.", Type: dns.TypeA}, dns.RcodeSuccess, 1},
+		{Question{Name: "www.LOCALHOST.", Type: dns.TypeANY}, dns.RcodeSuccess, 2},
+		{Question{Name: "localhost.", Type: dns.TypeMX}, dns.RcodeSuccess, 0},
+		{Question{Name: "a.invalid.", Type: dns.TypeA}, dns.RcodeNameError, 0},
+		{Question{Name: "a.test.", Type: dns.TypeA}, dns.RcodeNameError, 0},
+		{Question{Name: "duckduckgogg42xjoc72x3sjasowoarfbgcmvfimaftt6twagswzczad.onion.", Type: dns.TypeA}, dns.RcodeNameError, 0},
+		{Question{Name: "router.home.arpa.", Type: dns.TypeAAAA}, dns.RcodeNameError, 0},
+	} {
+		a, _, err := rr.Lookup(context.Background(), tc.q)
+		if err != nil {
+			t.Fatalf("Lookup for %s failed: %s", tc.q.Name, err)
+		}
+		if a.Rcode != tc.rcode || len(a.Answer) != tc.addrs {
+			t.Fatalf("Unexpected answer for %s: %#v", tc.q.Name, a)
+		}
+		if tc.addrs == 0 && (len(a.Authority) != 1 || a.Authority[0].Header().Rrtype != dns.TypeSOA) {
+			t.Fatalf("Expected a SOA record in the negative answer for %s, got %v", tc.q.Name, a.Authority)
+		}
+		for _, r := range a.Answer {
+			if addr, ok := r.(*dns.A); ok && !addr.A.Equal(net.IPv4(127, 0, 0, 1)) {
+				t.Fatalf("Unexpected loopback address %s", addr.A)
+			}
+		}
+	}
+	if sent != 0 {
+		t.Fatalf("%d queries for special-use names were sent upstream", sent)
+	}
+
+	rr.SpecialUseDomains = map[string]SpecialUse{"corp.": SpecialUseRefuse}
+	a, _, err := rr.Lookup(context.Background(), Question{Name: "a.corp.", Type: dns.TypeA})
+	if err != nil || a.Rcode != dns.RcodeRefused {
+		t.Fatalf("Expected a REFUSED answer, got %#v, %v", a, err)
+	}
+	if _, _, err = rr.Lookup(context.Background(), Question{Name: "a.test.", Type: dns.TypeA}); err == nil || sent == 0 {
+		t.Fatal("Name removed from SpecialUseDomains wasn't resolved")
+	}
+
+	// explicitly forwarded zones take precedence
+	sent = 0
+	rr.SpecialUseDomains = nil
+	rr.ForwardZones = map[string]*ForwardConfig{"home.arpa.": {Servers: []string{"192.168.1.1"}, Attempts: 1}}
+	if _, _, err = rr.Lookup(context.Background(), Question{Name: "router.home.arpa.", Type: dns.TypeA}); err == nil || sent != 1 {
+		t.Fatalf("Expected the forwarded special-use name to be sent upstream, %d queries sent", sent)
+	}
+}
+
+func TestLocallyServedZones(t *testing.T) {
+	rr := NewRecursiveResolver(false, false, nil, nil, nil)
+	rr.Forward = &ForwardConfig{Servers: []string{"10.0.0.1"}, Attempts: 1}
+	rr.Transport = transportFunc(func(_ context.Context, m *dns.Msg, _ string) (*dns.Msg, error) {
+		t.Fatalf("Query for %s sent upstream", m.Question[0].Name)
+		return nil, nil
+	})
+	for _, tc := range []struct {
+		ip    string
+		zone  string
+		rcode int
+	}{
+		{"10.1.2.3", "10.in-addr.arpa.", dns.RcodeNameError},
+		{"172.20.0.1", "20.172.in-addr.arpa.", dns.RcodeNameError},
+		{"192.168.1.1", "168.192.in-addr.arpa.", dns.RcodeNameError},
+		{"169.254.0.1", "254.169.in-addr.arpa.", dns.RcodeNameError},
+		{"100.100.0.1", "100.100.in-addr.arpa.", dns.RcodeNameError},
+		{"fe80::1", "8.e.f.ip6.arpa.", dns.RcodeNameError},
+		{"fd00::1", "d.f.ip6.arpa.", dns.RcodeNameError},
+		{"::1", "1." + strings.Repeat("0.", 31) + "ip6.arpa.", dns.RcodeSuccess},
+	} {
+		name, err := dns.ReverseAddr(tc.ip)
+		if err != nil {
+			t.Fatalf("Failed to build reverse name for %s: %s", tc.ip, err)
+		}
+		a, _, err := rr.Lookup(context.Background(), Question{Name: name, Type: dns.TypePTR})
+		if err != nil {
+			t.Fatalf("Lookup for %s failed: %s", name, err)
+		}
+		if a.Rcode != tc.rcode || len(a.Authority) != 1 || a.Authority[0].Header().Name != tc.zone {
+			t.Fatalf("Unexpected answer for %s: %#v", name, a)
+		}
+	}
+
+	a, _, err := rr.Lookup(context.Background(), Question{Name: "10.in-addr.arpa.", Type: dns.TypeSOA})
+	if err != nil {
+		t.Fatalf("Lookup failed: %s", err)
+	}
+	if a.Rcode != dns.RcodeSuccess || len(a.Answer) != 1 {
+		t.Fatalf("Expected the SOA record for the zone apex, got %#v", a)
+	}
+
+	// configured data takes precedence
+	rr.LocalData = NewLocalData([]dns.RR{&dns.PTR{Hdr: dns.RR_Header{Name: "1.1.168.192.in-addr.arpa.", Rrtype: dns.TypePTR, Class: dns.ClassINET, Ttl: 60}, Ptr: "router.home.arpa."}})
+	a, _, err = rr.Lookup(context.Background(), Question{Name: "1.1.168.192.in-addr.arpa.", Type: dns.TypePTR})
+	if err != nil || len(a.Answer) != 1 {
+		t.Fatalf("Expected the configured PTR record, got %#v, %v", a, err)
+	}
+}
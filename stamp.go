@@ -0,0 +1,376 @@
+package solvere
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// StampPrefix is the scheme of a DNS stamp
+const StampPrefix = "sdns://"
+
+var (
+	// ErrMalformedStamp is returned when a DNS stamp can't be parsed
+	ErrMalformedStamp = errors.New("solvere: Malformed DNS stamp")
+	// ErrUnsupportedStamp is returned when a DNS stamp describes a server using a
+	// protocol solvere can't query, such as DNSCrypt
+	ErrUnsupportedStamp = errors.New("solvere: Unsupported DNS stamp protocol")
+	// ErrStampHashMismatch is returned when a server's certificate chain doesn't
+	// contain a certificate matching any of the hashes in its stamp
+	ErrStampHashMismatch = errors.New("solvere: Server certificate chain doesn't match DNS stamp hashes")
+)
+
+// StampProtocol is the protocol of the server a DNS stamp describes
+type StampProtocol byte
+
+const (
+	StampPlain    StampProtocol = 0x00
+	StampDNSCrypt StampProtocol = 0x01
+	StampDoH      StampProtocol = 0x02
+	StampDoT      StampProtocol = 0x03
+	StampDoQ      StampProtocol = 0x04
+	StampODoH     StampProtocol = 0x05
+)
+
+var stampProtocolNames = map[StampProtocol]string{
+	StampPlain:    "plain",
+	StampDNSCrypt: "DNSCrypt",
+	StampDoH:      "DoH",
+	StampDoT:      "DoT",
+	StampDoQ:      "DoQ",
+	StampODoH:     "ODoH",
+}
+
+func (sp StampProtocol) String() string {
+	if name, present := stampProtocolNames[sp]; present {
+		return name
+	}
+	return fmt.Sprintf("protocol 0x%02x", byte(sp))
+}
+
+// StampProps are the properties a DNS stamp claims the server has
+type StampProps uint64
+
+const (
+	// StampPropDNSSEC means the server validates DNSSEC
+	StampPropDNSSEC StampProps = 1 << 0
+	// StampPropNoLog means the server doesn't keep logs
+	StampPropNoLog StampProps = 1 << 1
+	// StampPropNoFilter means the server doesn't intentionally block domains
+	StampPropNoFilter StampProps = 1 << 2
+)
+
+// defaultStampPorts are the ports used when the address in a stamp doesn't have one
+var defaultStampPorts = map[StampProtocol]string{
+	StampPlain: "53",
+	StampDoH:   "443",
+	StampDoT:   "853",
+}
+
+// ServerStamp is a decoded DNS stamp (https://dnscrypt.info/stamps-specifications)
+// for a plain DNS, DNS-over-HTTPS, or DNS-over-TLS server. Stamps for other
+// protocols can't be parsed.
+type ServerStamp struct {
+	Protocol StampProtocol
+	Props    StampProps
+	// Addr is the host:port address the server is reached at. It is empty for
+	// DoH and DoT servers which are reached by resolving the host name in
+	// ProviderName.
+	Addr string
+	// ProviderName is the host name, optionally with a port, of a DoH or DoT
+	// server, which its certificate is verified against
+	ProviderName string
+	// Hashes are the SHA256 digests of the TBSCertificate of certificates in the
+	// chain of a DoH or DoT server, if any are set one of them has to be in the
+	// chain
+	Hashes [][]byte
+	// Path is the path of a DoH endpoint
+	Path string
+}
+
+// ParseStamp decodes a sdns:// DNS stamp
+func ParseStamp(stamp string) (*ServerStamp, error) {
+	if !strings.HasPrefix(stamp, StampPrefix) {
+		return nil, fmt.Errorf("%w: missing %s prefix", ErrMalformedStamp, StampPrefix)
+	}
+	data, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(stamp[len(StampPrefix):], "="))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrMalformedStamp, err)
+	}
+	if len(data) < 9 {
+		return nil, fmt.Errorf("%w: too short", ErrMalformedStamp)
+	}
+	ss := &ServerStamp{Protocol: StampProtocol(data[0]), Props: StampProps(binary.LittleEndian.Uint64(data[1:9]))}
+	sr := &stampReader{data: data[9:]}
+	switch ss.Protocol {
+	case StampPlain:
+		ss.Addr = sr.string()
+	case StampDoH, StampDoT:
+		ss.Addr = sr.string()
+		ss.Hashes = sr.set()
+		ss.ProviderName = sr.string()
+		if ss.Protocol == StampDoH {
+			ss.Path = sr.string()
+		}
+		// the optional bootstrap resolvers are only used to resolve
+		// ProviderName, which solvere does itself
+		if len(sr.data) > 0 {
+			sr.set()
+		}
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedStamp, ss.Protocol)
+	}
+	if sr.err != nil {
+		return nil, sr.err
+	}
+	if len(sr.data) > 0 {
+		return nil, fmt.Errorf("%w: %d trailing bytes", ErrMalformedStamp, len(sr.data))
+	}
+	return ss, ss.normalize()
+}
+
+// normalize adds the default port to Addr, and checks the required fields are set
+func (ss *ServerStamp) normalize() error {
+	if ss.Addr != "" {
+		host, port := ss.Addr, defaultStampPorts[ss.Protocol]
+		if h, p, err := net.SplitHostPort(ss.Addr); err == nil {
+			host, port = h, p
+		} else {
+			host = strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+		}
+		if net.ParseIP(host) == nil {
+			return fmt.Errorf("%w: invalid address %q", ErrMalformedStamp, ss.Addr)
+		}
+		ss.Addr = net.JoinHostPort(host, port)
+	}
+	switch ss.Protocol {
+	case StampPlain:
+		if ss.Addr == "" {
+			return fmt.Errorf("%w: missing address", ErrMalformedStamp)
+		}
+	case StampDoH, StampDoT:
+		if ss.ProviderName == "" {
+			return fmt.Errorf("%w: missing host name", ErrMalformedStamp)
+		}
+		if ss.Protocol == StampDoH && !strings.HasPrefix(ss.Path, "/") {
+			return fmt.Errorf("%w: invalid path %q", ErrMalformedStamp, ss.Path)
+		}
+	}
+	return nil
+}
+
+// String encodes the stamp
+func (ss *ServerStamp) String() string {
+	var b bytes.Buffer
+	b.WriteByte(byte(ss.Protocol))
+	binary.Write(&b, binary.LittleEndian, uint64(ss.Props))
+	writeLP := func(s []byte) {
+		b.WriteByte(byte(len(s)))
+		b.Write(s)
+	}
+	writeLP([]byte(ss.Addr))
+	if ss.Protocol == StampDoH || ss.Protocol == StampDoT {
+		if len(ss.Hashes) == 0 {
+			b.WriteByte(0)
+		}
+		for i, h := range ss.Hashes {
+			l := byte(len(h))
+			if i < len(ss.Hashes)-1 {
+				l |= 0x80
+			}
+			b.WriteByte(l)
+			b.Write(h)
+		}
+		writeLP([]byte(ss.ProviderName))
+		if ss.Protocol == StampDoH {
+			writeLP([]byte(ss.Path))
+		}
+	}
+	return StampPrefix + base64.RawURLEncoding.EncodeToString(b.Bytes())
+}
+
+// Server returns the address of the server as used in ForwardConfig.Servers, a
+// host:port pair for plain and DoT servers and a URL for DoH servers
+func (ss *ServerStamp) Server() string {
+	switch ss.Protocol {
+	case StampDoH:
+		return "https://" + ss.ProviderName + ss.Path
+	case StampDoT:
+		if ss.Addr != "" {
+			return ss.Addr
+		}
+		return ss.providerAddr()
+	}
+	return ss.Addr
+}
+
+// Transport returns a Transport for querying the server, which connects to Addr
+// if it is set and verifies the certificate of DoH and DoT servers is for
+// ProviderName and matches Hashes
+func (ss *ServerStamp) Transport() Transport {
+	switch ss.Protocol {
+	case StampDoH:
+		ht := &http.Transport{
+			Proxy:             http.ProxyFromEnvironment,
+			TLSClientConfig:   ss.tlsConfig(),
+			ForceAttemptHTTP2: true,
+		}
+		if ss.Addr != "" {
+			dialer := &net.Dialer{}
+			ht.DialContext = func(ctx context.Context, network, _ string) (net.Conn, error) {
+				return dialer.DialContext(ctx, network, ss.Addr)
+			}
+		}
+		dt := NewDoHTransport()
+		dt.Client = &http.Client{Transport: ht}
+		return dt
+	case StampDoT:
+		ct := NewClientTransport("tcp-tls")
+		ct.Client.TLSConfig = ss.tlsConfig()
+		return ct
+	}
+	return NewClientTransport("udp")
+}
+
+// providerAddr returns ProviderName with the default port if it doesn't have one
+func (ss *ServerStamp) providerAddr() string {
+	if _, _, err := net.SplitHostPort(ss.ProviderName); err == nil {
+		return ss.ProviderName
+	}
+	return net.JoinHostPort(ss.ProviderName, defaultStampPorts[ss.Protocol])
+}
+
+func (ss *ServerStamp) tlsConfig() *tls.Config {
+	host := ss.ProviderName
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	config := &tls.Config{ServerName: host}
+	if len(ss.Hashes) > 0 {
+		config.VerifyPeerCertificate = ss.verifyHashes
+	}
+	return config
+}
+
+// verifyHashes checks one of the certificates in the verified chains matches one
+// of the stamp's hashes
+func (ss *ServerStamp) verifyHashes(_ [][]byte, chains [][]*x509.Certificate) error {
+	for _, chain := range chains {
+		for _, cert := range chain {
+			sum := sha256.Sum256(cert.RawTBSCertificate)
+			for _, h := range ss.Hashes {
+				if bytes.Equal(h, sum[:]) {
+					return nil
+				}
+			}
+		}
+	}
+	return ErrStampHashMismatch
+}
+
+// stampReader reads the length prefixed fields of a stamp, once a read fails err
+// is set and all further reads return nothing
+type stampReader struct {
+	data []byte
+	err  error
+}
+
+func (sr *stampReader) bytes(l int) []byte {
+	if sr.err != nil {
+		return nil
+	}
+	if l > len(sr.data) {
+		sr.err = fmt.Errorf("%w: truncated", ErrMalformedStamp)
+		return nil
+	}
+	b := sr.data[:l]
+	sr.data = sr.data[l:]
+	return b
+}
+
+func (sr *stampReader) string() string {
+	l := sr.bytes(1)
+	if l == nil {
+		return ""
+	}
+	return string(sr.bytes(int(l[0])))
+}
+
+// set reads a variable length set of fields, the high bit of the length of each
+// field is set if another field follows it
+func (sr *stampReader) set() [][]byte {
+	var set [][]byte
+	for {
+		l := sr.bytes(1)
+		if l == nil {
+			return nil
+		}
+		if b := sr.bytes(int(l[0] & 0x7f)); len(b) > 0 {
+			set = append(set, append([]byte(nil), b...))
+		}
+		if l[0]&0x80 == 0 {
+			return set
+		}
+	}
+}
+
+// StampTransport is a Transport which sends queries to servers configured using
+// DNS stamps with the Transport for each stamp, and all other queries using
+// Fallback
+//
+//	fc, st, err := ForwardStamps([]string{"sdns://..."})
+//	rr.Forward = fc
+//	rr.Transport = st
+type StampTransport struct {
+	// Fallback is used for addresses which weren't configured with a stamp, if
+	// nil a UDP ClientTransport is used
+	Fallback Transport
+
+	transports map[string]Transport
+}
+
+// ForwardStamps parses stamps and returns a ForwardConfig with a server for each
+// stamp, in order, and a StampTransport for querying them
+func ForwardStamps(stamps []string) (*ForwardConfig, *StampTransport, error) {
+	fc := &ForwardConfig{}
+	st := &StampTransport{transports: make(map[string]Transport, len(stamps))}
+	for _, s := range stamps {
+		ss, err := ParseStamp(s)
+		if err != nil {
+			return nil, nil, err
+		}
+		server := ss.Server()
+		fc.Servers = append(fc.Servers, server)
+		if ss.Protocol != StampPlain {
+			st.transports[server] = ss.Transport()
+		}
+	}
+	return fc, st, nil
+}
+
+// Exchange implements Transport
+func (st *StampTransport) Exchange(ctx context.Context, m *dns.Msg, addr string) (*dns.Msg, error) {
+	if t, present := st.transports[addr]; present {
+		return t.Exchange(ctx, m, addr)
+	}
+	t := st.Fallback
+	if t == nil {
+		t = NewClientTransport("udp")
+	}
+	r, err := t.Exchange(ctx, m, addr)
+	if isUDPTransport(t) {
+		return retryOverTCP(ctx, m, addr, r, err)
+	}
+	return r, err
+}
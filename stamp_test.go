@@ -0,0 +1,143 @@
+package solvere
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestParseStamp(t *testing.T) {
+	hash := bytes.Repeat([]byte{0xaa}, 32)
+	// DoH, DNSSEC and no logs, 1.1.1.1 with a single hash, dns.example, /dns-query
+	raw := []byte{0x02, 0x03, 0, 0, 0, 0, 0, 0, 0, 7}
+	raw = append(raw, "1.1.1.1"...)
+	raw = append(raw, 32)
+	raw = append(raw, hash...)
+	raw = append(raw, 11)
+	raw = append(raw, "dns.example"...)
+	raw = append(raw, 10)
+	raw = append(raw, "/dns-query"...)
+	stamp := StampPrefix + base64.RawURLEncoding.EncodeToString(raw)
+
+	ss, err := ParseStamp(stamp)
+	if err != nil {
+		t.Fatalf("Failed to parse stamp: %s", err)
+	}
+	if ss.Protocol != StampDoH || ss.Props != StampPropDNSSEC|StampPropNoLog || ss.Addr != "1.1.1.1:443" || ss.ProviderName != "dns.example" || ss.Path != "/dns-query" {
+		t.Fatalf("Unexpected stamp: %+v", ss)
+	}
+	if len(ss.Hashes) != 1 || !bytes.Equal(ss.Hashes[0], hash) {
+		t.Fatalf("Unexpected hashes: %x", ss.Hashes)
+	}
+	if s := ss.Server(); s != "https://dns.example/dns-query" {
+		t.Fatalf("Unexpected server: %s", s)
+	}
+	if _, ok := ss.Transport().(*DoHTransport); !ok {
+		t.Fatalf("Unexpected transport: %T", ss.Transport())
+	}
+	// encoding adds the default port to the address
+	if rt, err := ParseStamp(ss.String()); err != nil || rt.Addr != ss.Addr || rt.Path != ss.Path || len(rt.Hashes) != 1 {
+		t.Fatalf("Stamp didn't round trip: %+v %v", rt, err)
+	}
+
+	for _, tc := range []struct {
+		stamp    ServerStamp
+		addr     string
+		server   string
+		protocol StampProtocol
+	}{
+		{ServerStamp{Protocol: StampPlain, Addr: "192.0.2.1"}, "192.0.2.1:53", "192.0.2.1:53", StampPlain},
+		{ServerStamp{Protocol: StampPlain, Addr: "[2001:db8::1]:5353"}, "[2001:db8::1]:5353", "[2001:db8::1]:5353", StampPlain},
+		{ServerStamp{Protocol: StampDoT, Addr: "192.0.2.1", ProviderName: "dot.example", Hashes: [][]byte{hash, hash}}, "192.0.2.1:853", "192.0.2.1:853", StampDoT},
+		{ServerStamp{Protocol: StampDoT, ProviderName: "dot.example"}, "", "dot.example:853", StampDoT},
+	} {
+		ss, err := ParseStamp(tc.stamp.String())
+		if err != nil {
+			t.Fatalf("Failed to parse %+v: %s", tc.stamp, err)
+		}
+		if ss.Protocol != tc.protocol || ss.Addr != tc.addr || ss.Server() != tc.server || len(ss.Hashes) != len(tc.stamp.Hashes) {
+			t.Fatalf("Unexpected stamp: %+v", ss)
+		}
+	}
+
+	for _, invalid := range []string{
+		"https://dns.example",
+		"sdns://!!",
+		StampPrefix + base64.RawURLEncoding.EncodeToString([]byte{0x00, 0}),
+		(&ServerStamp{Protocol: StampPlain}).String(),
+		(&ServerStamp{Protocol: StampPlain, Addr: "dns.example"}).String(),
+		(&ServerStamp{Protocol: StampDoH, ProviderName: "dns.example"}).String(),
+		(&ServerStamp{Protocol: StampDoT}).String(),
+		(&ServerStamp{Protocol: StampPlain, Addr: "192.0.2.1"}).String() + "AA",
+	} {
+		if _, err := ParseStamp(invalid); err == nil || !errors.Is(err, ErrMalformedStamp) {
+			t.Fatalf("Expected %q to be malformed, got %v", invalid, err)
+		}
+	}
+	if _, err := ParseStamp((&ServerStamp{Protocol: StampDNSCrypt}).String()); err == nil || !errors.Is(err, ErrUnsupportedStamp) {
+		t.Fatalf("Expected DNSCrypt stamp to be unsupported, got %v", err)
+	}
+}
+
+func TestStampTransport(t *testing.T) {
+	rr := &RecursiveResolver{c: new(dns.Client)}
+	rr.AddHooks(Hooks{
+		OnQuery: func(_ context.Context, q *Question) (*Answer, error) {
+			return &Answer{
+				Answer: []dns.RR{&dns.A{Hdr: dns.RR_Header{Name: q.Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60}, A: net.IP{1, 2, 3, 4}}},
+				Rcode:  dns.RcodeSuccess,
+			}, nil
+		},
+	})
+	server := httptest.NewTLSServer(NewDoHHandler(rr))
+	defer server.Close()
+	pool := x509.NewCertPool()
+	pool.AddCert(server.Certificate())
+	sum := sha256.Sum256(server.Certificate().RawTBSCertificate)
+
+	plain := &ServerStamp{Protocol: StampPlain, Addr: "192.0.2.1"}
+	doh := &ServerStamp{Protocol: StampDoH, Addr: server.Listener.Addr().String(), ProviderName: "example.com", Hashes: [][]byte{sum[:]}, Path: "/dns-query"}
+	fc, st, err := ForwardStamps([]string{doh.String(), plain.String()})
+	if err != nil {
+		t.Fatalf("ForwardStamps failed: %s", err)
+	}
+	if len(fc.Servers) != 2 || fc.Servers[0] != "https://example.com/dns-query" || fc.Servers[1] != "192.0.2.1:53" {
+		t.Fatalf("Unexpected servers: %v", fc.Servers)
+	}
+	dt := st.transports[fc.Servers[0]].(*DoHTransport)
+	dt.Client.Transport.(*http.Transport).TLSClientConfig.RootCAs = pool
+	var fallback []string
+	st.Fallback = transportFunc(func(_ context.Context, m *dns.Msg, addr string) (*dns.Msg, error) {
+		fallback = append(fallback, addr)
+		return new(dns.Msg).SetReply(m), nil
+	})
+
+	m := new(dns.Msg).SetQuestion("a.example.", dns.TypeA)
+	r, err := st.Exchange(context.Background(), m, fc.Servers[0])
+	if err != nil {
+		t.Fatalf("DoH exchange failed: %s", err)
+	}
+	if len(r.Answer) != 1 {
+		t.Fatalf("Unexpected answer: %v", r.Answer)
+	}
+	if _, err = st.Exchange(context.Background(), m, fc.Servers[1]); err != nil || len(fallback) != 1 || fallback[0] != fc.Servers[1] {
+		t.Fatalf("Plain server wasn't queried using the fallback: %v %v", fallback, err)
+	}
+
+	// a certificate chain without any of the hashes is rejected
+	doh.Hashes = [][]byte{bytes.Repeat([]byte{0xaa}, 32)}
+	wrong := doh.Transport().(*DoHTransport)
+	wrong.Client.Transport.(*http.Transport).TLSClientConfig.RootCAs = pool
+	if _, err = wrong.Exchange(context.Background(), m, doh.Server()); err == nil || !errors.Is(err, ErrStampHashMismatch) {
+		t.Fatalf("Expected the hash mismatch to be rejected, got %v", err)
+	}
+}
@@ -0,0 +1,123 @@
+package solvere
+
+import (
+	"runtime"
+	"sync/atomic"
+)
+
+// Stats is a point in time snapshot of the counters kept by a RecursiveResolver,
+// all of the counters start at zero when the resolver is created
+type Stats struct {
+	// Queries is the number of calls to Lookup
+	Queries uint64
+	// Rcodes is the number of successful lookups by the RCODE of their answer
+	Rcodes map[int]uint64
+	// Errors is the number of lookups that failed
+	Errors uint64
+	// CacheHits and CacheMisses are the number of times the cache was checked
+	// for a question, including those asked while iterating, and did or didn't
+	// contain an answer
+	CacheHits   uint64
+	CacheMisses uint64
+	// Secure, Insecure, and Bogus are the number of lookups with validation
+	// enabled whose answers were authenticated, weren't signed, or failed
+	// validation
+	Secure   uint64
+	Insecure uint64
+	Bogus    uint64
+	// UpstreamQueries is the number of queries sent to remote nameservers and
+	// UpstreamErrors the number of those that didn't receive a response
+	UpstreamQueries uint64
+	UpstreamErrors  uint64
+	// ActiveLookups is the number of calls to Lookup in progress
+	ActiveLookups int64
+	// Goroutines is the number of goroutines in the process, including those
+	// not started by the resolver
+	Goroutines int
+	// Memory is the approximate amount of memory used by the resolver's
+	// caches and lookups in progress
+	Memory MemoryUsage
+}
+
+// resolverStats holds the counters reported by RecursiveResolver.Stats, they are
+// all accessed atomically
+type resolverStats struct {
+	queries, errors                 uint64
+	cacheHits, cacheMisses          uint64
+	secure, insecure, bogus         uint64
+	upstreamQueries, upstreamErrors uint64
+	active                          int64
+	// lazy is the number of answers being validated in the background
+	lazy int64
+	// rcodes are indexed by the 4 bit header RCODE, extended RCODEs are
+	// counted with the header part of them
+	rcodes [16]uint64
+	// rotation is used to order cached addresses, family to interleave address
+	// families, and evicting is set while enforceMemoryLimit is evicting, they
+	// aren't reported but are kept here so they are shared with views of the
+	// resolver
+	rotation uint32
+	family   uint32
+	evicting int32
+}
+
+// discardedStats are the counters of resolvers that weren't created by
+// NewResolver, which aren't reported
+var discardedStats resolverStats
+
+// counters returns the resolver's counters
+func (rr *RecursiveResolver) counters() *resolverStats {
+	if rr.stats == nil {
+		return &discardedStats
+	}
+	return rr.stats
+}
+
+// lookupStarted records the start of a call to Lookup, the returned function
+// must be called when it returns
+func (s *resolverStats) lookupStarted() func() {
+	atomic.AddUint64(&s.queries, 1)
+	atomic.AddInt64(&s.active, 1)
+	return func() { atomic.AddInt64(&s.active, -1) }
+}
+
+// lookupFinished records the outcome of a call to Lookup
+func (s *resolverStats) lookupFinished(a *Answer, err error, validating bool) {
+	switch {
+	case err != nil:
+		atomic.AddUint64(&s.errors, 1)
+		if validating && isBogus(err) {
+			atomic.AddUint64(&s.bogus, 1)
+		}
+		return
+	case validating && a.Authenticated:
+		atomic.AddUint64(&s.secure, 1)
+	case validating:
+		atomic.AddUint64(&s.insecure, 1)
+	}
+	atomic.AddUint64(&s.rcodes[a.Rcode&0xf], 1)
+}
+
+// Stats returns a snapshot of the resolver's counters, it is safe to call
+// concurrently with Lookup
+func (rr *RecursiveResolver) Stats() Stats {
+	st := Stats{Rcodes: make(map[int]uint64), Goroutines: runtime.NumGoroutine()}
+	s := rr.counters()
+	st.Queries = atomic.LoadUint64(&s.queries)
+	st.Errors = atomic.LoadUint64(&s.errors)
+	st.CacheHits = atomic.LoadUint64(&s.cacheHits)
+	st.CacheMisses = atomic.LoadUint64(&s.cacheMisses)
+	st.Secure = atomic.LoadUint64(&s.secure)
+	st.Insecure = atomic.LoadUint64(&s.insecure)
+	st.Bogus = atomic.LoadUint64(&s.bogus)
+	st.UpstreamQueries = atomic.LoadUint64(&s.upstreamQueries)
+	st.UpstreamErrors = atomic.LoadUint64(&s.upstreamErrors)
+	st.ActiveLookups = atomic.LoadInt64(&s.active)
+	st.Memory = rr.memoryUsage()
+	for rcode := range s.rcodes {
+		if n := atomic.LoadUint64(&s.rcodes[rcode]); n > 0 {
+			st.Rcodes[rcode] = n
+		}
+	}
+	return st
+}
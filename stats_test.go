@@ -0,0 +1,50 @@
+package solvere
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestStats(t *testing.T) {
+	rr := NewRecursiveResolver(false, false, nil, nil, NewBasicCache())
+	rr.Forward = &ForwardConfig{Servers: []string{"10.0.0.1"}, Attempts: 1}
+	rr.Transport = transportFunc(func(_ context.Context, m *dns.Msg, _ string) (*dns.Msg, error) {
+		r := new(dns.Msg)
+		r.SetReply(m)
+		switch m.Question[0].Name {
+		case "a.example.":
+			r.Answer = []dns.RR{&dns.A{Hdr: dns.RR_Header{Name: m.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60}, A: net.IP{1, 2, 3, 4}}}
+		case "b.example.":
+			r.Rcode = dns.RcodeNameError
+		default:
+			return nil, errors.New("timeout")
+		}
+		return r, nil
+	})
+	for _, name := range []string{"a.example.", "b.example.", "c.example."} {
+		rr.Lookup(context.Background(), Question{Name: name, Type: dns.TypeA})
+	}
+	st := rr.Stats()
+	if st.Queries != 3 || st.Errors != 1 || st.ActiveLookups != 0 {
+		t.Fatalf("Unexpected lookup counts: %#v", st)
+	}
+	if st.Rcodes[dns.RcodeSuccess] != 1 || st.Rcodes[dns.RcodeNameError] != 1 {
+		t.Fatalf("Unexpected RCODE counts: %v", st.Rcodes)
+	}
+	if st.UpstreamQueries != 3 || st.UpstreamErrors != 1 {
+		t.Fatalf("Unexpected upstream counts: %#v", st)
+	}
+	if st.CacheMisses != 3 || st.CacheHits != 0 {
+		t.Fatalf("Unexpected cache counts: %#v", st)
+	}
+	if st.Secure+st.Insecure+st.Bogus != 0 {
+		t.Fatalf("Validation outcomes counted without validation: %#v", st)
+	}
+	if st.Goroutines == 0 {
+		t.Fatal("Goroutines wasn't set")
+	}
+}
@@ -0,0 +1,137 @@
+package solvere
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// ErrSystemdSocket is returned when the sockets passed by systemd can't be used
+var ErrSystemdSocket = errors.New("solvere: Invalid systemd socket")
+
+// listenFDsStart is the first file descriptor passed by socket activation
+const listenFDsStart = 3
+
+// SystemdSocket is a socket inherited using systemd socket activation, see
+// systemd.socket(5). Exactly one of PacketConn and Listener is set.
+type SystemdSocket struct {
+	// Name is the FileDescriptorName of the socket, which defaults to the name
+	// of the socket unit, or "unknown" if systemd didn't pass names
+	Name       string
+	PacketConn net.PacketConn
+	Listener   net.Listener
+}
+
+// SystemdSockets returns the sockets passed to the process by systemd socket
+// activation (sd_listen_fds(3)), so a server can answer queries on port 53
+// without running as root. UDP sockets are returned as a PacketConn, and TCP
+// sockets as a Listener for Server.Serve or Server.ServeTLS. If the process
+// wasn't socket activated nil is returned. The LISTEN_* environment variables are
+// removed so child processes don't also use the sockets.
+func SystemdSockets() ([]SystemdSocket, error) {
+	n, names, err := parseListenEnv(os.Getenv("LISTEN_PID"), os.Getenv("LISTEN_FDS"), os.Getenv("LISTEN_FDNAMES"))
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+	if err != nil || n == 0 {
+		return nil, err
+	}
+	files := make([]*os.File, n)
+	for i := range files {
+		files[i] = os.NewFile(uintptr(listenFDsStart+i), names[i])
+	}
+	return systemdSockets(files, names)
+}
+
+// parseListenEnv returns the number of sockets passed and their names, zero if
+// the variables are for another process
+func parseListenEnv(pid, fds, fdNames string) (int, []string, error) {
+	if pid == "" || fds == "" {
+		return 0, nil, nil
+	}
+	if p, err := strconv.Atoi(pid); err != nil || p != os.Getpid() {
+		return 0, nil, nil
+	}
+	n, err := strconv.Atoi(fds)
+	if err != nil || n < 0 {
+		return 0, nil, fmt.Errorf("%w: invalid LISTEN_FDS %q", ErrSystemdSocket, fds)
+	}
+	names := make([]string, n)
+	given := strings.Split(fdNames, ":")
+	for i := range names {
+		names[i] = "unknown"
+		if fdNames != "" && i < len(given) && given[i] != "" {
+			names[i] = given[i]
+		}
+	}
+	return n, names, nil
+}
+
+// systemdSockets converts the passed files to sockets, closing the files. If any
+// can't be converted all the sockets are closed.
+func systemdSockets(files []*os.File, names []string) ([]SystemdSocket, error) {
+	var sockets []SystemdSocket
+	var err error
+	for i, f := range files {
+		// the net package duplicates the descriptor so f is closed regardless
+		s := SystemdSocket{Name: names[i]}
+		var lerr error
+		if s.Listener, lerr = net.FileListener(f); lerr != nil {
+			s.Listener = nil
+			if s.PacketConn, lerr = net.FilePacketConn(f); lerr != nil {
+				s.PacketConn = nil
+			}
+		}
+		f.Close()
+		if s.Listener == nil && s.PacketConn == nil && err == nil {
+			err = fmt.Errorf("%w: %s (fd %d): %s", ErrSystemdSocket, names[i], listenFDsStart+i, lerr)
+		}
+		sockets = append(sockets, s)
+	}
+	if err != nil {
+		for _, s := range sockets {
+			s.Close()
+		}
+		return nil, err
+	}
+	return sockets, nil
+}
+
+// Close closes the socket
+func (ss SystemdSocket) Close() error {
+	if ss.Listener != nil {
+		return ss.Listener.Close()
+	}
+	if ss.PacketConn != nil {
+		return ss.PacketConn.Close()
+	}
+	return nil
+}
+
+// Notification states for SystemdNotify, see sd_notify(3)
+const (
+	SystemdReady     = "READY=1"
+	SystemdReloading = "RELOADING=1"
+	SystemdStopping  = "STOPPING=1"
+)
+
+// SystemdNotify sends state, such as SystemdReady, to the service manager using
+// the socket in NOTIFY_SOCKET (sd_notify(3)) so units with Type=notify know when
+// the server is ready to answer queries. If the variable isn't set, because the
+// process wasn't started by systemd, nothing is sent and nil is returned.
+func SystemdNotify(state string) error {
+	addr := os.Getenv("NOTIFY_SOCKET")
+	if addr == "" {
+		return nil
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: addr, Net: "unixgram"})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = conn.Write([]byte(state))
+	return err
+}
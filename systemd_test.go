@@ -0,0 +1,122 @@
+package solvere
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+func TestParseListenEnv(t *testing.T) {
+	pid := strconv.Itoa(os.Getpid())
+	for _, tc := range []struct {
+		pid, fds, names string
+		n               int
+		expected        []string
+	}{
+		{"", "", "", 0, nil},
+		{"1", "2", "", 0, nil},
+		{pid, "2", "", 2, []string{"unknown", "unknown"}},
+		{pid, "3", "dns:dns-tcp", 3, []string{"dns", "dns-tcp", "unknown"}},
+	} {
+		n, names, err := parseListenEnv(tc.pid, tc.fds, tc.names)
+		if err != nil || n != tc.n || len(names) != len(tc.expected) {
+			t.Fatalf("Unexpected result for %+v: %d %v %v", tc, n, names, err)
+		}
+		for i := range names {
+			if names[i] != tc.expected[i] {
+				t.Fatalf("Unexpected names for %+v: %v", tc, names)
+			}
+		}
+	}
+	if _, _, err := parseListenEnv(pid, "x", ""); err == nil {
+		t.Fatal("parseListenEnv accepted an invalid LISTEN_FDS")
+	}
+
+	// not being socket activated isn't an error
+	os.Unsetenv("LISTEN_PID")
+	if sockets, err := SystemdSockets(); sockets != nil || err != nil {
+		t.Fatalf("Unexpected sockets without socket activation: %v %v", sockets, err)
+	}
+}
+
+func TestSystemdSockets(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen on UDP: %s", err)
+	}
+	defer pc.Close()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen on TCP: %s", err)
+	}
+	defer l.Close()
+	pcf, err := pc.(*net.UDPConn).File()
+	if err != nil {
+		t.Fatalf("Failed to get UDP socket file: %s", err)
+	}
+	lf, err := l.(*net.TCPListener).File()
+	if err != nil {
+		t.Fatalf("Failed to get TCP socket file: %s", err)
+	}
+
+	sockets, err := systemdSockets([]*os.File{pcf, lf}, []string{"dns", "dns-tcp"})
+	if err != nil {
+		t.Fatalf("systemdSockets failed: %s", err)
+	}
+	defer func() {
+		for _, s := range sockets {
+			s.Close()
+		}
+	}()
+	if len(sockets) != 2 || sockets[0].PacketConn == nil || sockets[0].Name != "dns" || sockets[1].Listener == nil || sockets[1].Name != "dns-tcp" {
+		t.Fatalf("Unexpected sockets: %+v", sockets)
+	}
+	if sockets[0].PacketConn.LocalAddr().String() != pc.LocalAddr().String() || sockets[1].Listener.Addr().String() != l.Addr().String() {
+		t.Fatal("Inherited sockets are bound to different addresses")
+	}
+
+	// files which aren't sockets are rejected
+	f, err := ioutil.TempFile("", "solvere")
+	if err != nil {
+		t.Fatalf("Failed to create temporary file: %s", err)
+	}
+	defer os.Remove(f.Name())
+	if _, err = systemdSockets([]*os.File{f}, []string{"file"}); err == nil {
+		t.Fatal("systemdSockets accepted a regular file")
+	}
+}
+
+func TestSystemdNotify(t *testing.T) {
+	os.Unsetenv("NOTIFY_SOCKET")
+	if err := SystemdNotify(SystemdReady); err != nil {
+		t.Fatalf("SystemdNotify failed without NOTIFY_SOCKET: %s", err)
+	}
+
+	dir, err := ioutil.TempDir("", "solvere")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "notify")
+	c, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		t.Fatalf("Failed to listen on unix socket: %s", err)
+	}
+	defer c.Close()
+	os.Setenv("NOTIFY_SOCKET", path)
+	defer os.Unsetenv("NOTIFY_SOCKET")
+	if err = SystemdNotify(SystemdReady); err != nil {
+		t.Fatalf("SystemdNotify failed: %s", err)
+	}
+	buf := make([]byte, 64)
+	n, err := c.Read(buf)
+	if err != nil {
+		t.Fatalf("Failed to read notification: %s", err)
+	}
+	if string(buf[:n]) != SystemdReady {
+		t.Fatalf("Unexpected notification: %q", buf[:n])
+	}
+}
@@ -0,0 +1,252 @@
+package solvere
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// DefaultTCPIdleTimeout is the default amount of time a TCPPoolTransport keeps an
+// idle connection open when the nameserver didn't advertise an idle timeout
+var DefaultTCPIdleTimeout = 2 * time.Second
+
+// TCPPoolTransport is a Transport that sends queries over TCP, or DNS over TLS, and
+// keeps connections open after use so later queries to the same nameserver avoid
+// the cost of the TCP and TLS handshakes. Queries carry the edns-tcp-keepalive
+// option (RFC 7828) and idle connections are kept for as long as the nameserver
+// advertises in its responses, or IdleTimeout if it doesn't include the option.
+// Connections are closed straight away when the nameserver advertises a timeout of
+// zero, and once they fail.
+type TCPPoolTransport struct {
+	// Net is either "tcp" or "tcp-tls"
+	Net string
+	// TLSConfig is used for "tcp-tls" connections
+	TLSConfig *tls.Config
+	// MaxIdlePerServer is the maximum number of idle connections kept for a single
+	// nameserver, if zero DefaultMaxIdleSockets is used
+	MaxIdlePerServer int
+	// IdleTimeout is how long idle connections are kept when the nameserver
+	// doesn't advertise a timeout, if zero DefaultTCPIdleTimeout is used
+	IdleTimeout time.Duration
+	// Timeout is used when the context passed to Exchange has no deadline, if zero
+	// DefaultForwardTimeout is used
+	Timeout time.Duration
+
+	mu     sync.Mutex
+	idle   map[string][]*pooledConn
+	closed bool
+}
+
+// pooledConn is an idle connection and the time it should be closed at if it
+// isn't used again
+type pooledConn struct {
+	*dns.Conn
+	expires time.Time
+}
+
+// NewTCPPoolTransport returns a TCPPoolTransport using the network net, either
+// "tcp" or "tcp-tls"
+func NewTCPPoolTransport(net string) *TCPPoolTransport {
+	return &TCPPoolTransport{Net: net}
+}
+
+func (tt *TCPPoolTransport) maxIdle() int {
+	if tt.MaxIdlePerServer > 0 {
+		return tt.MaxIdlePerServer
+	}
+	return DefaultMaxIdleSockets
+}
+
+func (tt *TCPPoolTransport) idleTimeout() time.Duration {
+	if tt.IdleTimeout > 0 {
+		return tt.IdleTimeout
+	}
+	return DefaultTCPIdleTimeout
+}
+
+func (tt *TCPPoolTransport) timeout() time.Duration {
+	if tt.Timeout > 0 {
+		return tt.Timeout
+	}
+	return DefaultForwardTimeout
+}
+
+// idleConn returns an idle connection to addr which hasn't expired, or nil
+func (tt *TCPPoolTransport) idleConn(addr string) *pooledConn {
+	tt.mu.Lock()
+	defer tt.mu.Unlock()
+	now := time.Now()
+	for conns := tt.idle[addr]; len(conns) > 0; conns = tt.idle[addr] {
+		pc := conns[len(conns)-1]
+		if len(conns) == 1 {
+			delete(tt.idle, addr)
+		} else {
+			tt.idle[addr] = conns[:len(conns)-1]
+		}
+		if now.Before(pc.expires) {
+			return pc
+		}
+		pc.Close()
+	}
+	return nil
+}
+
+func (tt *TCPPoolTransport) dial(ctx context.Context, addr string) (*pooledConn, error) {
+	d := &net.Dialer{}
+	var c net.Conn
+	var err error
+	if tt.Net == "tcp-tls" {
+		c, err = (&tls.Dialer{NetDialer: d, Config: tt.TLSConfig}).DialContext(ctx, "tcp", addr)
+	} else {
+		c, err = d.DialContext(ctx, "tcp", addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &pooledConn{Conn: &dns.Conn{Conn: c}}, nil
+}
+
+// put returns a connection to the pool using the idle timeout advertised in the
+// response r, or closes it
+func (tt *TCPPoolTransport) put(addr string, pc *pooledConn, r *dns.Msg) {
+	idle, advertised := tcpKeepaliveTimeout(r)
+	if !advertised {
+		idle = tt.idleTimeout()
+	}
+	tt.mu.Lock()
+	defer tt.mu.Unlock()
+	if idle <= 0 || tt.closed || len(tt.idle[addr]) >= tt.maxIdle() {
+		pc.Close()
+		return
+	}
+	pc.expires = time.Now().Add(idle)
+	if tt.idle == nil {
+		tt.idle = make(map[string][]*pooledConn)
+	}
+	tt.idle[addr] = append(tt.idle[addr], pc)
+}
+
+// Exchange implements Transport
+func (tt *TCPPoolTransport) Exchange(ctx context.Context, m *dns.Msg, addr string) (*dns.Msg, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(tt.timeout())
+	}
+	q := m.Copy()
+	opt := q.IsEdns0()
+	if opt == nil {
+		q.SetEdns0(dns.MinMsgSize, false)
+		opt = q.IsEdns0()
+	}
+	// clients don't include a timeout (RFC 7828 3.2.1)
+	opt.Option = append(opt.Option, &dns.EDNS0_LOCAL{Code: dns.EDNS0TCPKEEPALIVE})
+	// an idle connection may have been closed by the nameserver since it was last
+	// used, so the query is sent again on a new connection if it fails
+	if pc := tt.idleConn(addr); pc != nil {
+		if r, err := tt.exchangeOn(pc, q, deadline); err == nil {
+			tt.put(addr, pc, r)
+			return r, nil
+		}
+		pc.Close()
+	}
+	dctx, cancel := context.WithDeadline(ctx, deadline)
+	defer cancel()
+	pc, err := tt.dial(dctx, addr)
+	if err != nil {
+		return nil, err
+	}
+	r, err := tt.exchangeOn(pc, q, deadline)
+	if err != nil {
+		pc.Close()
+		return nil, err
+	}
+	tt.put(addr, pc, r)
+	return r, nil
+}
+
+func (tt *TCPPoolTransport) exchangeOn(pc *pooledConn, m *dns.Msg, deadline time.Time) (*dns.Msg, error) {
+	pc.SetDeadline(deadline)
+	if err := pc.WriteMsg(m); err != nil {
+		return nil, err
+	}
+	r, err := pc.ReadMsg()
+	if err != nil {
+		return nil, err
+	}
+	if r.Id != m.Id {
+		return nil, dns.ErrId
+	}
+	return r, nil
+}
+
+// tcpKeepaliveTimeout returns the idle timeout in the edns-tcp-keepalive option
+// of m, if it has one with a timeout. The dns package returns the option as a
+// EDNS0_LOCAL as it doesn't unpack it.
+func tcpKeepaliveTimeout(m *dns.Msg) (time.Duration, bool) {
+	opt := m.IsEdns0()
+	if opt == nil {
+		return 0, false
+	}
+	for _, o := range opt.Option {
+		if local, ok := o.(*dns.EDNS0_LOCAL); ok && local.Code == dns.EDNS0TCPKEEPALIVE && len(local.Data) == 2 {
+			// the timeout is in units of 100 milliseconds
+			return time.Duration(binary.BigEndian.Uint16(local.Data)) * 100 * time.Millisecond, true
+		}
+	}
+	return 0, false
+}
+
+// hasTCPKeepalive checks if m has the edns-tcp-keepalive option
+func hasTCPKeepalive(m *dns.Msg) bool {
+	opt := m.IsEdns0()
+	if opt == nil {
+		return false
+	}
+	for _, o := range opt.Option {
+		if o.Option() == dns.EDNS0TCPKEEPALIVE {
+			return true
+		}
+	}
+	return false
+}
+
+// setTCPKeepalive replaces any edns-tcp-keepalive option in opt with one
+// advertising the idle timeout
+func setTCPKeepalive(opt *dns.OPT, timeout time.Duration) {
+	units := timeout / (100 * time.Millisecond)
+	if units > 0xffff {
+		units = 0xffff
+	}
+	data := make([]byte, 2)
+	binary.BigEndian.PutUint16(data, uint16(units))
+	options := opt.Option[:0]
+	for _, o := range opt.Option {
+		if o.Option() != dns.EDNS0TCPKEEPALIVE {
+			options = append(options, o)
+		}
+	}
+	opt.Option = append(options, &dns.EDNS0_LOCAL{Code: dns.EDNS0TCPKEEPALIVE, Data: data})
+}
+
+// Close closes all idle connections, connections in use are closed once their
+// exchange completes
+func (tt *TCPPoolTransport) Close() error {
+	tt.mu.Lock()
+	defer tt.mu.Unlock()
+	for _, conns := range tt.idle {
+		for _, pc := range conns {
+			pc.Close()
+		}
+	}
+	tt.idle = nil
+	tt.closed = true
+	return nil
+}
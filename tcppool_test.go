@@ -0,0 +1,84 @@
+package solvere
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// countingListener counts the connections accepted
+type countingListener struct {
+	net.Listener
+	accepted int32
+}
+
+func (cl *countingListener) Accept() (net.Conn, error) {
+	c, err := cl.Listener.Accept()
+	if err == nil {
+		atomic.AddInt32(&cl.accepted, 1)
+	}
+	return c, err
+}
+
+func newKeepaliveServer(t *testing.T, idle time.Duration) (string, *countingListener) {
+	rr := &RecursiveResolver{c: new(dns.Client)}
+	rr.AddHooks(Hooks{
+		OnQuery: func(_ context.Context, q *Question) (*Answer, error) {
+			return &Answer{Rcode: dns.RcodeSuccess, Answer: []dns.RR{&dns.A{
+				Hdr: dns.RR_Header{Name: q.Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 10},
+				A:   net.IP{192, 0, 2, 1},
+			}}}, nil
+		},
+	})
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen on TCP: %s", err)
+	}
+	cl := &countingListener{Listener: l}
+	s := NewServer("", rr)
+	s.IdleTimeout = idle
+	go s.Serve(nil, cl)
+	t.Cleanup(func() { s.Shutdown(context.Background()) })
+	// wait for things to warm up :/
+	time.Sleep(time.Millisecond * 100)
+	return l.Addr().String(), cl
+}
+
+func TestTCPPoolTransport(t *testing.T) {
+	addr, cl := newKeepaliveServer(t, 2*time.Second)
+	tt := NewTCPPoolTransport("tcp")
+	defer tt.Close()
+	m := new(dns.Msg)
+	m.SetQuestion("example.", dns.TypeA)
+	m.SetEdns0(DefaultEDNSBufferSize, false)
+	for i := 0; i < 3; i++ {
+		r, err := tt.Exchange(context.Background(), m, addr)
+		if err != nil {
+			t.Fatalf("Exchange failed: %s", err)
+		}
+		if idle, ok := tcpKeepaliveTimeout(r); !ok || idle != 2*time.Second || len(r.Answer) != 1 {
+			t.Fatalf("Expected a response advertising a 2s idle timeout, got %s", r)
+		}
+	}
+	if n := atomic.LoadInt32(&cl.accepted); n != 1 {
+		t.Fatalf("Expected the connection to be reused, %d were opened", n)
+	}
+	if len(m.IsEdns0().Option) != 0 {
+		t.Fatal("Exchange modified the query")
+	}
+
+	// the server is about to close idle connections so they aren't kept
+	addr, cl = newKeepaliveServer(t, 50*time.Millisecond)
+	for i := 0; i < 2; i++ {
+		if _, err := tt.Exchange(context.Background(), m, addr); err != nil {
+			t.Fatalf("Exchange failed: %s", err)
+		}
+	}
+	if n := atomic.LoadInt32(&cl.accepted); n != 2 {
+		t.Fatalf("Expected a connection per query, %d were opened", n)
+	}
+}
@@ -0,0 +1,47 @@
+package solvere
+
+import (
+	"time"
+)
+
+// Timings describes where time was spent during a lookup. For the top level
+// LookupLog returned by RecursiveResolver.Lookup it contains the totals for
+// the whole resolution, for all other entries it only contains the time spent
+// in that individual step (composite steps are not included).
+type Timings struct {
+	// Cache is the time spent querying the question/answer cache
+	Cache time.Duration `json:",omitempty"`
+	// Network is the time spent exchanging messages with remote nameservers
+	Network time.Duration `json:",omitempty"`
+	// Validation is the time spent verifying signatures and denial of existence
+	// proofs
+	Validation time.Duration `json:",omitempty"`
+	// Total is the end-to-end time of the resolution, it is only set on the top
+	// level LookupLog
+	Total time.Duration `json:",omitempty"`
+}
+
+func (ll *LookupLog) timings() *Timings {
+	if ll.Timings == nil {
+		ll.Timings = new(Timings)
+	}
+	return ll.Timings
+}
+
+// sumTimings returns the sum of the timings of ll and all of its composites
+func (ll *LookupLog) sumTimings() Timings {
+	var sum Timings
+	if ll.Timings != nil {
+		sum = *ll.Timings
+	}
+	for _, c := range ll.Composites {
+		if c == nil {
+			continue
+		}
+		cs := c.sumTimings()
+		sum.Cache += cs.Cache
+		sum.Network += cs.Network
+		sum.Validation += cs.Validation
+	}
+	return sum
+}
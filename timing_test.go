@@ -0,0 +1,47 @@
+package solvere
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func TestSumTimings(t *testing.T) {
+	ll := &LookupLog{
+		Composites: []*LookupLog{
+			{Timings: &Timings{Cache: time.Millisecond, Network: 10 * time.Millisecond}},
+			nil,
+			{
+				Timings: &Timings{Validation: 2 * time.Millisecond},
+				Composites: []*LookupLog{
+					{Timings: &Timings{Network: 5 * time.Millisecond, Validation: time.Millisecond}},
+					{},
+				},
+			},
+		},
+	}
+	sum := ll.sumTimings()
+	expected := Timings{Cache: time.Millisecond, Network: 15 * time.Millisecond, Validation: 3 * time.Millisecond}
+	if sum != expected {
+		t.Fatalf("sumTimings returned unexpected timings: expected %#v, got %#v", expected, sum)
+	}
+}
+
+func TestLookupTimings(t *testing.T) {
+	rr := &RecursiveResolver{c: new(dns.Client)}
+	rr.AddHooks(Hooks{
+		OnQuery: func(context.Context, *Question) (*Answer, error) {
+			time.Sleep(time.Millisecond)
+			return &Answer{}, nil
+		},
+	})
+	_, log, err := rr.Lookup(context.Background(), Question{Name: "a.com.", Type: dns.TypeA})
+	if err != nil {
+		t.Fatalf("Lookup failed: %s", err)
+	}
+	if log.Timings == nil || log.Timings.Total != log.Latency {
+		t.Fatalf("Lookup didn't set the total time on the LookupLog: %#v", log.Timings)
+	}
+}
@@ -0,0 +1,556 @@
+package solvere
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// The configuration file format is the subset of TOML (https://toml.io) needed to
+// describe a Config: top level keys, [table] and [[array of tables]] headers with
+// bare names, and values which are strings, integers, floats, booleans, or arrays
+// of them. Durations are written as strings parsed by time.ParseDuration. Struct
+// fields are named by their config tag, or their name in snake case.
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// configFieldName returns the key for a struct field, or "" if it is skipped
+func configFieldName(f reflect.StructField) string {
+	if f.PkgPath != "" {
+		return ""
+	}
+	if tag := f.Tag.Get("config"); tag != "" {
+		if tag == "-" {
+			return ""
+		}
+		return tag
+	}
+	var b strings.Builder
+	runes := []rune(f.Name)
+	for i, r := range runes {
+		if i > 0 && unicode.IsUpper(r) && (unicode.IsLower(runes[i-1]) || (i+1 < len(runes) && unicode.IsLower(runes[i+1]))) {
+			b.WriteByte('_')
+		}
+		b.WriteRune(unicode.ToLower(r))
+	}
+	return b.String()
+}
+
+// configField returns the field of the struct v with the key name
+func configField(v reflect.Value, name string) (reflect.Value, bool) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		if configFieldName(t.Field(i)) == name {
+			return v.Field(i), true
+		}
+	}
+	return reflect.Value{}, false
+}
+
+// isTable checks if values of t are written as a [table]
+func isTable(t reflect.Type) bool {
+	return t.Kind() == reflect.Struct || (t.Kind() == reflect.Ptr && t.Elem().Kind() == reflect.Struct)
+}
+
+// isTableArray checks if values of t are written as an [[array of tables]]
+func isTableArray(t reflect.Type) bool {
+	return t.Kind() == reflect.Slice && t.Elem().Kind() == reflect.Struct
+}
+
+// tomlParser decodes a document into a struct
+type tomlParser struct {
+	data string
+	pos  int
+	line int
+}
+
+func (p *tomlParser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("%w: line %d: %s", ErrConfigSyntax, p.line, fmt.Sprintf(format, args...))
+}
+
+func (p *tomlParser) eof() bool {
+	return p.pos >= len(p.data)
+}
+
+func (p *tomlParser) peek() byte {
+	if p.eof() {
+		return 0
+	}
+	return p.data[p.pos]
+}
+
+// skip skips spaces and tabs, and if newlines is set newlines and comments
+func (p *tomlParser) skip(newlines bool) {
+	for !p.eof() {
+		switch c := p.peek(); {
+		case c == ' ' || c == '\t' || c == '\r':
+			p.pos++
+		case newlines && c == '\n':
+			p.pos++
+			p.line++
+		case newlines && c == '#':
+			for !p.eof() && p.peek() != '\n' {
+				p.pos++
+			}
+		default:
+			return
+		}
+	}
+}
+
+// endOfLine consumes the rest of the line, which may only contain a comment
+func (p *tomlParser) endOfLine() error {
+	p.skip(false)
+	if p.peek() == '#' {
+		for !p.eof() && p.peek() != '\n' {
+			p.pos++
+		}
+	}
+	if !p.eof() && p.peek() != '\n' {
+		return p.errorf("unexpected %q after value", p.peek())
+	}
+	return nil
+}
+
+func (p *tomlParser) bareKey() string {
+	start := p.pos
+	for !p.eof() {
+		c := p.peek()
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_' || c == '-') {
+			break
+		}
+		p.pos++
+	}
+	return p.data[start:p.pos]
+}
+
+func (p *tomlParser) value() (interface{}, error) {
+	switch c := p.peek(); {
+	case c == '"':
+		return p.basicString()
+	case c == '\'':
+		p.pos++
+		end := strings.IndexAny(p.data[p.pos:], "'\n")
+		if end < 0 || p.data[p.pos+end] != '\'' {
+			return nil, p.errorf("unterminated string")
+		}
+		s := p.data[p.pos : p.pos+end]
+		p.pos += end + 1
+		return s, nil
+	case c == '[':
+		p.pos++
+		var values []interface{}
+		for {
+			p.skip(true)
+			if p.peek() == ']' {
+				p.pos++
+				return values, nil
+			}
+			v, err := p.value()
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, v)
+			p.skip(true)
+			if p.peek() == ',' {
+				p.pos++
+			} else if p.peek() != ']' {
+				return nil, p.errorf("expected , or ] in array")
+			}
+		}
+	default:
+		start := p.pos
+		for !p.eof() && !strings.ContainsRune(" \t\r\n,]#", rune(p.peek())) {
+			p.pos++
+		}
+		word := p.data[start:p.pos]
+		switch word {
+		case "true":
+			return true, nil
+		case "false":
+			return false, nil
+		}
+		clean := strings.Replace(word, "_", "", -1)
+		if i, err := strconv.ParseInt(clean, 10, 64); err == nil {
+			return i, nil
+		}
+		if f, err := strconv.ParseFloat(clean, 64); err == nil && strings.IndexFunc(clean, unicode.IsDigit) >= 0 {
+			return f, nil
+		}
+		if word == "" {
+			return nil, p.errorf("missing value")
+		}
+		return nil, p.errorf("invalid value %q", word)
+	}
+}
+
+func (p *tomlParser) basicString() (string, error) {
+	p.pos++
+	var b strings.Builder
+	for {
+		if p.eof() || p.peek() == '\n' {
+			return "", p.errorf("unterminated string")
+		}
+		c := p.peek()
+		p.pos++
+		if c == '"' {
+			return b.String(), nil
+		}
+		if c != '\\' {
+			b.WriteByte(c)
+			continue
+		}
+		if p.eof() {
+			return "", p.errorf("unterminated string")
+		}
+		e := p.peek()
+		p.pos++
+		switch e {
+		case 'b':
+			b.WriteByte('\b')
+		case 't':
+			b.WriteByte('\t')
+		case 'n':
+			b.WriteByte('\n')
+		case 'f':
+			b.WriteByte('\f')
+		case 'r':
+			b.WriteByte('\r')
+		case '"', '\\':
+			b.WriteByte(e)
+		case 'u', 'U':
+			n := 4
+			if e == 'U' {
+				n = 8
+			}
+			if p.pos+n > len(p.data) {
+				return "", p.errorf("truncated escape")
+			}
+			code, err := strconv.ParseUint(p.data[p.pos:p.pos+n], 16, 32)
+			if err != nil || !utf8.ValidRune(rune(code)) {
+				return "", p.errorf("invalid escape \\%c%s", e, p.data[p.pos:p.pos+n])
+			}
+			b.WriteRune(rune(code))
+			p.pos += n
+		default:
+			return "", p.errorf("invalid escape \\%c", e)
+		}
+	}
+}
+
+// decodeTOML decodes data into the struct pointed to by v, fields v already has
+// are kept unless they are set by data. Unknown keys and tables, and keys set more
+// than once in the same table, are errors.
+func decodeTOML(data string, v interface{}) error {
+	root := reflect.ValueOf(v).Elem()
+	p := &tomlParser{data: data, line: 1}
+	current := root
+	seen := map[string]bool{}
+	// keys are the keys set in the current table
+	keys := map[string]bool{}
+	for {
+		p.skip(true)
+		if p.eof() {
+			return nil
+		}
+		if p.peek() == '[' {
+			p.pos++
+			array := p.peek() == '['
+			if array {
+				p.pos++
+			}
+			p.skip(false)
+			name := p.bareKey()
+			p.skip(false)
+			closing := "]"
+			if array {
+				closing = "]]"
+			}
+			if !strings.HasPrefix(p.data[p.pos:], closing) {
+				return p.errorf("malformed table header")
+			}
+			p.pos += len(closing)
+			keys = map[string]bool{}
+			field, present := configField(root, name)
+			switch {
+			case !present:
+				return p.errorf("unknown table %q", name)
+			case array && isTableArray(field.Type()):
+				field.Set(reflect.Append(field, reflect.New(field.Type().Elem()).Elem()))
+				current = field.Index(field.Len() - 1)
+			case !array && isTable(field.Type()):
+				if seen[name] {
+					return p.errorf("duplicate table %q", name)
+				}
+				seen[name] = true
+				if field.Kind() == reflect.Ptr {
+					if field.IsNil() {
+						field.Set(reflect.New(field.Type().Elem()))
+					}
+					field = field.Elem()
+				}
+				current = field
+			default:
+				return p.errorf("%q isn't a table", name)
+			}
+		} else {
+			key := p.bareKey()
+			if key == "" {
+				return p.errorf("expected a key")
+			}
+			p.skip(false)
+			if p.peek() != '=' {
+				return p.errorf("expected = after %q", key)
+			}
+			p.pos++
+			p.skip(false)
+			field, present := configField(current, key)
+			if !present || isTable(field.Type()) || isTableArray(field.Type()) {
+				return p.errorf("unknown key %q", key)
+			}
+			if keys[key] {
+				return p.errorf("duplicate key %q", key)
+			}
+			keys[key] = true
+			value, err := p.value()
+			if err != nil {
+				return err
+			}
+			if err := setConfigValue(field, value); err != nil {
+				return p.errorf("%s: %s", key, err)
+			}
+		}
+		if err := p.endOfLine(); err != nil {
+			return err
+		}
+	}
+}
+
+// setConfigValue sets field to a decoded value
+func setConfigValue(field reflect.Value, value interface{}) error {
+	if field.Type() == durationType {
+		s, ok := value.(string)
+		if !ok {
+			return fmt.Errorf("expected a duration string")
+		}
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return err
+		}
+		field.SetInt(int64(d))
+		return nil
+	}
+	switch field.Kind() {
+	case reflect.String:
+		if s, ok := value.(string); ok {
+			field.SetString(s)
+			return nil
+		}
+	case reflect.Bool:
+		if b, ok := value.(bool); ok {
+			field.SetBool(b)
+			return nil
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if i, ok := value.(int64); ok {
+			if field.OverflowInt(i) {
+				return fmt.Errorf("%d is out of range", i)
+			}
+			field.SetInt(i)
+			return nil
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if i, ok := value.(int64); ok {
+			if i < 0 || field.OverflowUint(uint64(i)) {
+				return fmt.Errorf("%d is out of range", i)
+			}
+			field.SetUint(uint64(i))
+			return nil
+		}
+	case reflect.Float32, reflect.Float64:
+		switch f := value.(type) {
+		case float64:
+			field.SetFloat(f)
+			return nil
+		case int64:
+			field.SetFloat(float64(f))
+			return nil
+		}
+	case reflect.Slice:
+		if values, ok := value.([]interface{}); ok {
+			s := reflect.MakeSlice(field.Type(), len(values), len(values))
+			for i, v := range values {
+				if err := setConfigValue(s.Index(i), v); err != nil {
+					return err
+				}
+			}
+			field.Set(s)
+			return nil
+		}
+	}
+	return fmt.Errorf("can't use %v as %s", value, field.Type())
+}
+
+// encodeTOML writes the struct pointed to by v, omitting the fields which are
+// equal to those of defaults, which must have the same type, so decoding the
+// output into a copy of defaults results in v
+func encodeTOML(w io.Writer, v, defaults interface{}) error {
+	bw := bufio.NewWriter(w)
+	root, def := reflect.ValueOf(v).Elem(), reflect.ValueOf(defaults).Elem()
+	t := root.Type()
+	if err := writeTOMLKeys(bw, root, def); err != nil {
+		return err
+	}
+	for i := 0; i < t.NumField(); i++ {
+		name := configFieldName(t.Field(i))
+		field, defField := root.Field(i), def.Field(i)
+		if name == "" || (!isTable(field.Type()) && !isTableArray(field.Type())) || reflect.DeepEqual(field.Interface(), defField.Interface()) {
+			continue
+		}
+		if isTableArray(field.Type()) {
+			zero := reflect.New(field.Type().Elem()).Elem()
+			for j := 0; j < field.Len(); j++ {
+				fmt.Fprintf(bw, "\n[[%s]]\n", name)
+				if err := writeTOMLKeys(bw, field.Index(j), zero); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		if field.Kind() == reflect.Ptr {
+			if field.IsNil() {
+				return fmt.Errorf("%w: %s can't be unset when it is set by default", ErrConfigSyntax, name)
+			}
+			field = field.Elem()
+			if defField.IsNil() {
+				defField = reflect.New(field.Type()).Elem()
+			} else {
+				defField = defField.Elem()
+			}
+		}
+		fmt.Fprintf(bw, "\n[%s]\n", name)
+		if err := writeTOMLKeys(bw, field, defField); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+// writeTOMLKeys writes the fields of v which aren't tables and differ from def
+func writeTOMLKeys(w io.Writer, v, def reflect.Value) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		name := configFieldName(t.Field(i))
+		field := v.Field(i)
+		if name == "" || isTable(field.Type()) || isTableArray(field.Type()) || reflect.DeepEqual(field.Interface(), def.Field(i).Interface()) {
+			continue
+		}
+		s, err := formatConfigValue(field)
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		fmt.Fprintf(w, "%s = %s\n", name, s)
+	}
+	return nil
+}
+
+func formatConfigValue(v reflect.Value) (string, error) {
+	if v.Type() == durationType {
+		return quoteTOML(time.Duration(v.Int()).String()), nil
+	}
+	switch v.Kind() {
+	case reflect.String:
+		return quoteTOML(v.String()), nil
+	case reflect.Bool:
+		return strconv.FormatBool(v.Bool()), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(v.Int(), 10), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.FormatUint(v.Uint(), 10), nil
+	case reflect.Float32, reflect.Float64:
+		s := strconv.FormatFloat(v.Float(), 'g', -1, 64)
+		if !strings.ContainsAny(s, ".eEn") {
+			// keep the value a float
+			s += ".0"
+		}
+		return s, nil
+	case reflect.Slice:
+		if v.Len() == 0 {
+			return "[]", nil
+		}
+		elems := make([]string, v.Len())
+		for i := range elems {
+			s, err := formatConfigValue(v.Index(i))
+			if err != nil {
+				return "", err
+			}
+			elems[i] = "    " + s + ",\n"
+		}
+		return "[\n" + strings.Join(elems, "") + "]", nil
+	}
+	return "", fmt.Errorf("unsupported type %s", v.Type())
+}
+
+// configJSONValue converts v into a value which is encoded as JSON using the
+// same keys and duration strings as the TOML encoding
+func configJSONValue(v reflect.Value) interface{} {
+	if v.Type() == durationType {
+		return time.Duration(v.Int()).String()
+	}
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() {
+			return nil
+		}
+		return configJSONValue(v.Elem())
+	case reflect.Struct:
+		fields := make(map[string]interface{}, v.NumField())
+		for i := 0; i < v.NumField(); i++ {
+			if name := configFieldName(v.Type().Field(i)); name != "" {
+				fields[name] = configJSONValue(v.Field(i))
+			}
+		}
+		return fields
+	case reflect.Slice:
+		if v.IsNil() {
+			return nil
+		}
+		elems := make([]interface{}, v.Len())
+		for i := range elems {
+			elems[i] = configJSONValue(v.Index(i))
+		}
+		return elems
+	}
+	return v.Interface()
+}
+
+// quoteTOML quotes s as a TOML basic string
+func quoteTOML(s string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for _, r := range s {
+		switch {
+		case r == '"' || r == '\\':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case r == '\n':
+			b.WriteString(`\n`)
+		case r == '\t':
+			b.WriteString(`\t`)
+		case r == '\r':
+			b.WriteString(`\r`)
+		case r < 0x20 || r == 0x7f:
+			fmt.Fprintf(&b, `\u%04X`, r)
+		default:
+			b.WriteRune(r)
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
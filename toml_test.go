@@ -0,0 +1,156 @@
+package solvere
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+type tomlTestTable struct {
+	Name  string
+	Ratio float64
+}
+
+type tomlTestDoc struct {
+	Text     string
+	Literal  string
+	Count    int
+	Small    uint8
+	Enabled  bool
+	Wait     time.Duration
+	UDPSize  int
+	Names    []string
+	Table    *tomlTestTable
+	Entries  []tomlTestTable `config:"entry"`
+	Skipped  string          `config:"-"`
+	internal string
+}
+
+func TestConfigFieldName(t *testing.T) {
+	typ := reflect.TypeOf(tomlTestDoc{})
+	for field, expected := range map[string]string{
+		"Text":     "text",
+		"UDPSize":  "udp_size",
+		"Entries":  "entry",
+		"Skipped":  "",
+		"internal": "",
+	} {
+		f, _ := typ.FieldByName(field)
+		if name := configFieldName(f); name != expected {
+			t.Fatalf("Expected %s to be named %q, got %q", field, expected, name)
+		}
+	}
+}
+
+func TestDecodeTOML(t *testing.T) {
+	var doc tomlTestDoc
+	err := decodeTOML(`
+text = "a \"quoted\" \\ value\t\u00e9" # comment
+literal = 'C:\path'
+count = -1_024
+small = 255
+enabled = true
+wait = "1m30s"
+names = [
+	"a",
+	# comment between elements
+	'b', ]
+
+[table]
+name = "t"
+ratio = 2
+
+[[entry]]
+name = "first"
+
+[[entry]]
+name = "second"
+ratio = 0.5
+`, &doc)
+	if err != nil {
+		t.Fatalf("decodeTOML failed: %s", err)
+	}
+	expected := tomlTestDoc{
+		Text:    "a \"quoted\" \\ value\t\u00e9",
+		Literal: `C:\path`,
+		Count:   -1024,
+		Small:   255,
+		Enabled: true,
+		Wait:    90 * time.Second,
+		Names:   []string{"a", "b"},
+		Table:   &tomlTestTable{Name: "t", Ratio: 2},
+		Entries: []tomlTestTable{{Name: "first"}, {Name: "second", Ratio: 0.5}},
+	}
+	if !reflect.DeepEqual(doc, expected) {
+		t.Fatalf("Unexpected document:\n%#v\n%#v", doc, expected)
+	}
+
+	for _, tc := range []struct {
+		doc, err string
+	}{
+		{"text = \"unterminated", "line 1: unterminated string"},
+		{"\n\ntext = 'a\n'", "line 3: unterminated string"},
+		{"text = \"\\x41\"", "invalid escape"},
+		{"count = 1 2", "unexpected"},
+		{"count = \"1\"", "can't use"},
+		{"small = 256", "out of range"},
+		{"small = -1", "out of range"},
+		{"wait = 5", "duration"},
+		{"names = [\"a\" \"b\"]", "expected , or ]"},
+		{"count =", "missing value"},
+		{"table = 1", "unknown key"},
+		{"skipped = \"a\"", "unknown key"},
+		{"[count]", "isn't a table"},
+		{"[table", "malformed table header"},
+		{"[table]\n[table]", "duplicate table"},
+		{"count = 1\ncount = 2", "line 2: duplicate key \"count\""},
+		{"[table]\nname = \"a\"\n\nname = \"b\"", "line 4: duplicate key"},
+		{"= 1", "expected a key"},
+	} {
+		var doc tomlTestDoc
+		err := decodeTOML(tc.doc, &doc)
+		if err == nil || !strings.Contains(err.Error(), tc.err) || !errors.Is(err, ErrConfigSyntax) {
+			t.Fatalf("Expected %q to fail with %q, got %v", tc.doc, tc.err, err)
+		}
+	}
+}
+
+func TestEncodeTOML(t *testing.T) {
+	doc := &tomlTestDoc{
+		Text:    "control \x01 and \"quotes\"\n",
+		Count:   3,
+		Wait:    time.Second,
+		Names:   []string{"a", "b"},
+		Table:   &tomlTestTable{Ratio: 1},
+		Entries: []tomlTestTable{{Name: "first"}, {}},
+		Skipped: "never written",
+	}
+	defaults := &tomlTestDoc{Count: 3}
+	var b bytes.Buffer
+	if err := encodeTOML(&b, doc, defaults); err != nil {
+		t.Fatalf("encodeTOML failed: %s", err)
+	}
+	out := b.String()
+	if strings.Contains(out, "count") || strings.Contains(out, "never written") {
+		t.Fatalf("Default or skipped field was written:\n%s", out)
+	}
+	if !strings.Contains(out, `text = "control \u0001 and \"quotes\"\n"`) || !strings.Contains(out, "ratio = 1.0") {
+		t.Fatalf("Unexpected encoding:\n%s", out)
+	}
+	decoded := *defaults
+	if err := decodeTOML(out, &decoded); err != nil {
+		t.Fatalf("Failed to decode encoded document: %s\n%s", err, out)
+	}
+	doc.Skipped = ""
+	if !reflect.DeepEqual(&decoded, doc) {
+		t.Fatalf("Document didn't round trip:\n%#v\n%#v", decoded, doc)
+	}
+
+	// a table set by default can't be removed
+	if err := encodeTOML(&b, &tomlTestDoc{}, &tomlTestDoc{Table: &tomlTestTable{}}); err == nil {
+		t.Fatal("encodeTOML didn't fail when a default table was unset")
+	}
+}
@@ -0,0 +1,135 @@
+package solvere
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// TraceKind describes a TraceEvent
+type TraceKind int
+
+const (
+	// TraceQuery is a query sent to a remote nameserver
+	TraceQuery TraceKind = iota
+	// TraceResponse is a response received from a remote nameserver, as it was
+	// received before any records were removed from it
+	TraceResponse
+	// TraceError is a query that didn't receive a response
+	TraceError
+	// TraceDiscarded is a response, or records from it, that weren't used,
+	// e.g. because it was malformed or from a lame server
+	TraceDiscarded
+	// TraceValidation is a DNSSEC validation decision
+	TraceValidation
+)
+
+var traceKindStrings = map[TraceKind]string{
+	TraceQuery:      "query",
+	TraceResponse:   "response",
+	TraceError:      "error",
+	TraceDiscarded:  "discarded",
+	TraceValidation: "validation",
+}
+
+func (tk TraceKind) String() string {
+	if str, present := traceKindStrings[tk]; present {
+		return str
+	}
+	return fmt.Sprintf("TraceKind(%d)", int(tk))
+}
+
+// TraceEvent is a single step recorded in a Trace
+type TraceEvent struct {
+	Time time.Time
+	Kind TraceKind
+	// Server is the nameserver the message was exchanged with, or whose
+	// response was validated
+	Server *Nameserver
+	// Msg is a copy of the message that was sent or received, if any
+	Msg *dns.Msg
+	// Detail describes the event, e.g. why a response was discarded or the
+	// outcome of a validation
+	Detail string
+}
+
+// Trace records every message exchanged with remote nameservers and every
+// validation decision made during the lookups using the context returned by
+// WithTrace, in the order they happened
+type Trace struct {
+	mu     sync.Mutex
+	events []TraceEvent
+}
+
+type traceKey struct{}
+
+// WithTrace returns a copy of ctx which causes lookups using it to record their
+// steps in the returned Trace, similar to dig +trace. Since every message is
+// copied tracing is expensive and is meant for debugging individual queries.
+func WithTrace(ctx context.Context) (context.Context, *Trace) {
+	t := new(Trace)
+	return context.WithValue(ctx, traceKey{}, t), t
+}
+
+// traceFrom returns the Trace set by WithTrace, or nil
+func traceFrom(ctx context.Context) *Trace {
+	t, _ := ctx.Value(traceKey{}).(*Trace)
+	return t
+}
+
+// add records an event, m is copied so it may be modified or reused afterwards
+func (t *Trace) add(kind TraceKind, ns *Nameserver, m *dns.Msg, format string, args ...interface{}) {
+	if t == nil {
+		return
+	}
+	e := TraceEvent{Time: time.Now(), Kind: kind, Detail: fmt.Sprintf(format, args...)}
+	if ns != nil {
+		c := *ns
+		e.Server = &c
+	}
+	if m != nil {
+		e.Msg = m.Copy()
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.events = append(t.events, e)
+}
+
+// validation records the outcome of verifying what
+func (t *Trace) validation(ns *Nameserver, what string, err error) {
+	if err != nil {
+		t.add(TraceValidation, ns, nil, "%s failed: %s", what, err)
+	} else {
+		t.add(TraceValidation, ns, nil, "%s verified", what)
+	}
+}
+
+// Events returns the events that have been recorded so far
+func (t *Trace) Events() []TraceEvent {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]TraceEvent{}, t.events...)
+}
+
+// String returns the events in a human readable form, including the messages
+func (t *Trace) String() string {
+	b := new(bytes.Buffer)
+	for _, e := range t.Events() {
+		fmt.Fprintf(b, ";; %s %s", e.Time.Format("15:04:05.000000"), e.Kind)
+		if e.Server != nil {
+			fmt.Fprintf(b, " @%s (%s) zone %s", e.Server.Name, e.Server.Addr, e.Server.Zone)
+		}
+		if e.Detail != "" {
+			fmt.Fprintf(b, ": %s", e.Detail)
+		}
+		fmt.Fprintln(b)
+		if e.Msg != nil {
+			fmt.Fprintln(b, e.Msg)
+		}
+	}
+	return b.String()
+}
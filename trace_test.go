@@ -0,0 +1,49 @@
+package solvere
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestTrace(t *testing.T) {
+	rr := NewRecursiveResolver(false, false, nil, nil, nil)
+	rr.Forward = &ForwardConfig{Servers: []string{"10.0.0.1", "10.0.0.2"}, Attempts: 1}
+	rr.Transport = transportFunc(func(_ context.Context, m *dns.Msg, addr string) (*dns.Msg, error) {
+		r := new(dns.Msg)
+		r.SetReply(m)
+		r.RecursionAvailable = true
+		if addr == net.JoinHostPort("10.0.0.1", dnsPort) {
+			r.Rcode = dns.RcodeServerFailure
+			return r, nil
+		}
+		r.Answer = []dns.RR{&dns.A{Hdr: dns.RR_Header{Name: m.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60}, A: net.IP{1, 2, 3, 4}}}
+		return r, nil
+	})
+	ctx, trace := WithTrace(context.Background())
+	if _, _, err := rr.Lookup(ctx, Question{Name: "a.example.", Type: dns.TypeA}); err != nil {
+		t.Fatalf("Lookup failed: %s", err)
+	}
+	events := trace.Events()
+	expected := []TraceKind{TraceQuery, TraceResponse, TraceDiscarded, TraceQuery, TraceResponse}
+	if len(events) != len(expected) {
+		t.Fatalf("Expected %d events, got %v", len(expected), events)
+	}
+	for i, e := range events {
+		if e.Kind != expected[i] {
+			t.Fatalf("Expected event %d to be a %s, got %s", i, expected[i], e.Kind)
+		}
+	}
+	if events[1].Msg == nil || events[1].Msg.Rcode != dns.RcodeServerFailure || events[1].Server.Addr != "10.0.0.1" {
+		t.Fatalf("Unexpected response event %#v", events[1])
+	}
+	if events[3].Msg == nil || events[3].Msg.Question[0].Name != "a.example." {
+		t.Fatalf("Query event doesn't contain the query %#v", events[3])
+	}
+	if s := trace.String(); !strings.Contains(s, "discarded @10.0.0.1") || !strings.Contains(s, "SERVFAIL") {
+		t.Fatalf("Unexpected trace output %s", s)
+	}
+}
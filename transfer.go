@@ -0,0 +1,419 @@
+package solvere
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+var (
+	// DefaultTransferTimeout is the amount of time a zone transfer, or the query
+	// for the SOA record of the primary, may take if SecondaryZone.Timeout isn't
+	// set
+	DefaultTransferTimeout = 30 * time.Second
+	// MinRefreshInterval is the shortest amount of time waited between checks of
+	// the primary, regardless of the SOA timers
+	MinRefreshInterval = 10 * time.Second
+
+	// ErrBadTransfer is returned when the primary sends a zone transfer, or a
+	// response to the SOA query made before one, which can't be used
+	ErrBadTransfer = errors.New("solvere: Malformed zone transfer")
+)
+
+// TSIGKey is a key used to authenticate zone transfers, and queries sent to
+// forwarders (RFC 8945)
+type TSIGKey struct {
+	// Name is the fully qualified name of the key
+	Name string
+	// Algorithm is the fully qualified name of the HMAC algorithm, if empty
+	// dns.HmacSHA256 is used
+	Algorithm string
+	// Secret is the base64 encoded shared secret
+	Secret string
+}
+
+func (tk *TSIGKey) algorithm() string {
+	if tk.Algorithm != "" {
+		return tk.Algorithm
+	}
+	return dns.HmacSHA256
+}
+
+func (tk *TSIGKey) secrets() map[string]string {
+	return map[string]string{dns.Fqdn(tk.Name): tk.Secret}
+}
+
+// sign adds a TSIG record to m
+func (tk *TSIGKey) sign(m *dns.Msg) {
+	m.SetTsig(dns.Fqdn(tk.Name), tk.algorithm(), 300, time.Now().Unix())
+}
+
+// SecondaryZone is a LocalZone whose contents are transferred from a primary
+// nameserver using AXFR or IXFR (RFC 5936, RFC 1995), and kept up to date using the
+// timers in its SOA record (RFC 1034 Section 4.3.5). Zone can be added to
+// RecursiveResolver.LocalZones before the first transfer has completed, it isn't
+// served until then or once it has expired.
+type SecondaryZone struct {
+	// Zone is served authoritatively, its contents are replaced after each
+	// transfer
+	Zone *LocalZone
+	// Primary is the host:port address of the primary nameserver
+	Primary string
+	// Key, if not nil, is used to sign the queries sent to the primary and
+	// verify its responses
+	Key *TSIGKey
+	// Timeout is the amount of time a single transfer may take, if zero
+	// DefaultTransferTimeout is used
+	Timeout time.Duration
+	// Logger, if not nil, receives diagnostics about the transfers performed
+	// by Run
+	Logger Logger
+
+	mu sync.Mutex
+	// records are the current contents of the zone, used to apply incremental
+	// transfers
+	records []dns.RR
+	soa     *dns.SOA
+	// refreshed is the last time the primary was successfully checked
+	refreshed time.Time
+}
+
+// NewSecondaryZone returns a SecondaryZone for origin transferred from primary,
+// Transfer or Run must be called to populate it
+func NewSecondaryZone(origin, primary string, key *TSIGKey) *SecondaryZone {
+	return &SecondaryZone{
+		Zone:    &LocalZone{Origin: CanonicalName(origin)},
+		Primary: primary,
+		Key:     key,
+	}
+}
+
+func (sz *SecondaryZone) timeout() time.Duration {
+	if sz.Timeout > 0 {
+		return sz.Timeout
+	}
+	return DefaultTransferTimeout
+}
+
+// Serial returns the serial of the current contents of the zone, and false if the
+// zone hasn't been transferred
+func (sz *SecondaryZone) Serial() (uint32, bool) {
+	sz.mu.Lock()
+	defer sz.mu.Unlock()
+	if sz.soa == nil {
+		return 0, false
+	}
+	return sz.soa.Serial, true
+}
+
+// serialNewer compares two serials using the serial number arithmetic described
+// in RFC 1982
+func serialNewer(a, b uint32) bool {
+	return a != b && a-b < 1<<31
+}
+
+// primarySerial queries the primary for the current serial of the zone
+func (sz *SecondaryZone) primarySerial(ctx context.Context) (uint32, error) {
+	m := new(dns.Msg)
+	m.SetQuestion(sz.Zone.Origin, dns.TypeSOA)
+	c := &dns.Client{Net: "tcp", Timeout: sz.timeout()}
+	if deadline, ok := ctx.Deadline(); ok {
+		c.Timeout = time.Until(deadline)
+	}
+	if sz.Key != nil {
+		c.TsigSecret = sz.Key.secrets()
+		sz.Key.sign(m)
+	}
+	r, _, err := c.Exchange(m, sz.Primary)
+	if err != nil {
+		return 0, err
+	}
+	if sz.Key != nil && r.IsTsig() == nil {
+		// dns.Client only verifies signatures that are present
+		return 0, fmt.Errorf("%w: primary didn't sign its response", ErrBadTransfer)
+	}
+	if r.Rcode != dns.RcodeSuccess {
+		return 0, fmt.Errorf("%w: primary responded with %s to SOA query", ErrBadTransfer, dns.RcodeToString[r.Rcode])
+	}
+	for _, a := range r.Answer {
+		if soa, ok := a.(*dns.SOA); ok && strings.ToLower(soa.Hdr.Name) == sz.Zone.Origin {
+			return soa.Serial, nil
+		}
+	}
+	return 0, fmt.Errorf("%w: primary didn't return the SOA record", ErrBadTransfer)
+}
+
+// transfer performs a full zone transfer, or an incremental transfer from current
+// if it isn't nil, and returns every record received
+func (sz *SecondaryZone) transfer(ctx context.Context, current *dns.SOA) ([]dns.RR, error) {
+	ctx, cancel := context.WithTimeout(ctx, sz.timeout())
+	defer cancel()
+	conn, err := (&net.Dialer{}).DialContext(ctx, "tcp", sz.Primary)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	// reads don't take a context, so close the connection to abort them
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-stop:
+		}
+	}()
+	m := new(dns.Msg)
+	if current != nil {
+		m.SetIxfr(sz.Zone.Origin, current.Serial, current.Ns, current.Mbox)
+	} else {
+		m.SetAxfr(sz.Zone.Origin)
+	}
+	// dns.Transfer only verifies the signatures of envelopes that have them, so
+	// the transfer is read here to require every envelope to be signed
+	co := &dns.Conn{Conn: conn}
+	var out []byte
+	var mac string
+	if sz.Key != nil {
+		sz.Key.sign(m)
+		out, mac, err = dns.TsigGenerate(m, sz.Key.Secret, "", false)
+	} else {
+		out, err = m.Pack()
+	}
+	if err != nil {
+		return nil, err
+	}
+	co.SetWriteDeadline(time.Now().Add(sz.timeout()))
+	if _, err = co.Write(out); err != nil {
+		return nil, err
+	}
+	var records []dns.RR
+	for envelope, done := 1, false; !done; envelope++ {
+		co.SetReadDeadline(time.Now().Add(sz.timeout()))
+		p, err := co.ReadMsgHeader(nil)
+		if err != nil {
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				return nil, ctxErr
+			}
+			return nil, err
+		}
+		in := new(dns.Msg)
+		if err := in.Unpack(p); err != nil {
+			return nil, err
+		}
+		if in.Id != m.Id {
+			return nil, dns.ErrId
+		}
+		if sz.Key != nil {
+			ts := in.IsTsig()
+			if ts == nil || !strings.EqualFold(ts.Hdr.Name, dns.Fqdn(sz.Key.Name)) {
+				return nil, fmt.Errorf("%w: envelope %d of the transfer", ErrUnsignedResponse, envelope)
+			}
+			// envelopes after the first are signed with only the timers of
+			// their TSIG records, as dns.Transfer does
+			if err := dns.TsigVerify(p, sz.Key.Secret, mac, envelope > 1); err != nil {
+				return nil, err
+			}
+			mac = ts.MAC
+		}
+		if in.Rcode != dns.RcodeSuccess {
+			return nil, fmt.Errorf("%w: primary responded with %s to transfer", ErrBadTransfer, dns.RcodeToString[in.Rcode])
+		}
+		if len(in.Answer) == 0 {
+			return nil, ErrBadTransfer
+		}
+		records = append(records, in.Answer...)
+		first, ok := records[0].(*dns.SOA)
+		if !ok {
+			return nil, fmt.Errorf("%w: transfer doesn't start with a SOA record", ErrBadTransfer)
+		}
+		// a incremental transfer of a single SOA record means there are no
+		// changes, otherwise transfers end with the first SOA record again
+		last, ok := records[len(records)-1].(*dns.SOA)
+		done = (len(records) == 1 && current != nil) || (len(records) > 1 && ok && last.Serial == first.Serial)
+	}
+	return records, nil
+}
+
+// recordKey identifies a record by its owner name, type, class, and RDATA, so
+// deletions in incremental transfers can be matched regardless of TTL
+func recordKey(r dns.RR) string {
+	rdata, _ := packRdata(r)
+	k := keyOf(r)
+	return fmt.Sprintf("%s/%d/%d/%s", k.name, k.t, k.class, hex.EncodeToString(rdata))
+}
+
+// applyIXFR applies the differences in a incremental transfer to records and
+// returns the new contents of the zone. A incremental transfer consists of the new
+// SOA record, followed by sequences of deleted records starting with an old SOA
+// record and added records starting with a newer SOA record, and finally the new
+// SOA record again (RFC 1995 Section 4).
+func applyIXFR(records, ixfr []dns.RR) ([]dns.RR, error) {
+	if len(ixfr) < 2 || ixfr[len(ixfr)-1].Header().Rrtype != dns.TypeSOA {
+		return nil, fmt.Errorf("%w: incremental transfer doesn't end with a SOA record", ErrBadTransfer)
+	}
+	set := make(map[string]dns.RR, len(records))
+	var order []string
+	for _, r := range records {
+		k := recordKey(r)
+		if _, present := set[k]; !present {
+			order = append(order, k)
+		}
+		set[k] = r
+	}
+	adding := true
+	for _, r := range ixfr[1 : len(ixfr)-1] {
+		if r.Header().Rrtype == dns.TypeSOA {
+			// each SOA record starts the next sequence of deletions or additions
+			adding = !adding
+		}
+		k := recordKey(r)
+		if !adding {
+			delete(set, k)
+			continue
+		}
+		if _, present := set[k]; !present {
+			order = append(order, k)
+		}
+		set[k] = r
+	}
+	if !adding {
+		return nil, fmt.Errorf("%w: incremental transfer ends with deletions", ErrBadTransfer)
+	}
+	updated := make([]dns.RR, 0, len(set))
+	for _, k := range order {
+		if r, present := set[k]; present {
+			updated = append(updated, r)
+			delete(set, k)
+		}
+	}
+	return updated, nil
+}
+
+// update replaces the contents of the zone with records
+func (sz *SecondaryZone) update(records []dns.RR) error {
+	lz, err := NewLocalZone(sz.Zone.Origin, records)
+	if err != nil {
+		return err
+	}
+	sz.Zone.replace(lz)
+	sz.mu.Lock()
+	defer sz.mu.Unlock()
+	sz.records = records
+	sz.soa = lz.soa
+	sz.refreshed = time.Now()
+	return nil
+}
+
+// Transfer checks the serial of the zone on the primary and, if it is newer than
+// the current contents or the zone hasn't been transferred yet, transfers it. An
+// incremental transfer is tried first if the zone has already been transferred,
+// falling back to a full transfer if it fails.
+func (sz *SecondaryZone) Transfer(ctx context.Context) error {
+	serial, err := sz.primarySerial(ctx)
+	if err != nil {
+		return err
+	}
+	sz.mu.Lock()
+	current, records := sz.soa, sz.records
+	sz.mu.Unlock()
+	if current != nil && !serialNewer(serial, current.Serial) {
+		sz.mu.Lock()
+		sz.refreshed = time.Now()
+		sz.mu.Unlock()
+		return nil
+	}
+	if current != nil && sz.incremental(ctx, current, records) == nil {
+		return nil
+	}
+	axfr, err := sz.transfer(ctx, nil)
+	if err != nil {
+		return err
+	}
+	return sz.full(axfr)
+}
+
+// full replaces the zone with the records of a full transfer, which ends with a
+// copy of the SOA record
+func (sz *SecondaryZone) full(axfr []dns.RR) error {
+	if len(axfr) < 2 || axfr[len(axfr)-1].Header().Rrtype != dns.TypeSOA {
+		return fmt.Errorf("%w: transfer doesn't end with a SOA record", ErrBadTransfer)
+	}
+	return sz.update(axfr[:len(axfr)-1])
+}
+
+// incremental updates the zone using an incremental transfer from current
+func (sz *SecondaryZone) incremental(ctx context.Context, current *dns.SOA, records []dns.RR) error {
+	ixfr, err := sz.transfer(ctx, current)
+	if err != nil {
+		return err
+	}
+	switch {
+	case len(ixfr) == 1:
+		// the primary has nothing newer after all
+		return sz.update(records)
+	case ixfr[1].Header().Rrtype != dns.TypeSOA:
+		// primaries may send the full zone in response to an IXFR
+		return sz.full(ixfr)
+	}
+	updated, err := applyIXFR(records, ixfr)
+	if err != nil {
+		return err
+	}
+	return sz.update(updated)
+}
+
+// expire stops the zone from being served if the primary hasn't been reached
+// within the SOA expire interval
+func (sz *SecondaryZone) expire() {
+	sz.mu.Lock()
+	defer sz.mu.Unlock()
+	if sz.soa == nil || time.Since(sz.refreshed) < time.Duration(sz.soa.Expire)*time.Second {
+		return
+	}
+	sz.Zone.replace(nil)
+	sz.records, sz.soa = nil, nil
+}
+
+// nextCheck returns how long to wait before checking the primary again
+func (sz *SecondaryZone) nextCheck(failed bool) time.Duration {
+	sz.mu.Lock()
+	defer sz.mu.Unlock()
+	wait := MinRefreshInterval
+	if sz.soa != nil {
+		wait = time.Duration(sz.soa.Refresh) * time.Second
+		if failed {
+			wait = time.Duration(sz.soa.Retry) * time.Second
+		}
+	}
+	if wait < MinRefreshInterval {
+		wait = MinRefreshInterval
+	}
+	return wait
+}
+
+// Run transfers the zone and keeps it up to date until ctx is done. The primary
+// is checked every SOA refresh interval, or retry interval after a failure, and
+// the zone stops being served if the primary can't be reached before the SOA
+// expire interval passes.
+func (sz *SecondaryZone) Run(ctx context.Context) {
+	for {
+		err := sz.Transfer(ctx)
+		if err != nil {
+			logTo(sz.Logger, LogWarn, "zone transfer failed", "zone", sz.Zone.Origin, "primary", sz.Primary, "err", err)
+			sz.expire()
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(sz.nextCheck(err != nil)):
+		}
+	}
+}
@@ -0,0 +1,220 @@
+package solvere
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// testPrimary is a primary nameserver serving a single zone over TCP
+type testPrimary struct {
+	mu      sync.Mutex
+	records []dns.RR
+	// ixfr, if not nil, is sent in response to IXFR queries
+	ixfr []dns.RR
+	// unsigned causes transfers to be sent without a TSIG record
+	unsigned bool
+	queries  []uint16
+	addr     string
+}
+
+func newTestPrimary(t *testing.T, key *TSIGKey, records []dns.RR) *testPrimary {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen on TCP: %s", err)
+	}
+	tp := &testPrimary{records: records, addr: l.Addr().String()}
+	s := &dns.Server{Listener: l, Handler: dns.HandlerFunc(tp.serve)}
+	if key != nil {
+		s.TsigSecret = key.secrets()
+	}
+	started := make(chan struct{})
+	s.NotifyStartedFunc = func() { close(started) }
+	go s.ActivateAndServe()
+	<-started
+	t.Cleanup(func() { s.Shutdown() })
+	return tp
+}
+
+func (tp *testPrimary) serve(w dns.ResponseWriter, q *dns.Msg) {
+	tp.mu.Lock()
+	defer tp.mu.Unlock()
+	qtype := q.Question[0].Qtype
+	tp.queries = append(tp.queries, qtype)
+	r := new(dns.Msg)
+	r.SetReply(q)
+	if q.IsTsig() != nil {
+		if w.TsigStatus() != nil {
+			r.Rcode = dns.RcodeNotAuth
+			w.WriteMsg(r)
+			return
+		}
+		if qtype == dns.TypeSOA || !tp.unsigned {
+			r.SetTsig(q.IsTsig().Hdr.Name, dns.HmacSHA256, 300, time.Now().Unix())
+		}
+	}
+	switch {
+	case qtype == dns.TypeSOA:
+		r.Answer = tp.records[:1]
+	case qtype == dns.TypeIXFR && tp.ixfr != nil:
+		r.Answer = tp.ixfr
+	default:
+		r.Answer = append(append([]dns.RR{}, tp.records...), tp.records[0])
+	}
+	w.WriteMsg(r)
+}
+
+func testSOA(serial uint32) *dns.SOA {
+	return &dns.SOA{
+		Hdr:     dns.RR_Header{Name: "example.", Rrtype: dns.TypeSOA, Class: dns.ClassINET, Ttl: 60},
+		Ns:      "ns.example.",
+		Mbox:    "host.example.",
+		Serial:  serial,
+		Refresh: 3600,
+		Retry:   600,
+		Expire:  86400,
+		Minttl:  60,
+	}
+}
+
+func testA(name string, last byte) *dns.A {
+	return &dns.A{Hdr: dns.RR_Header{Name: name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60}, A: net.IP{10, 0, 0, last}}
+}
+
+func TestSecondaryZone(t *testing.T) {
+	key := &TSIGKey{Name: "transfer.", Secret: "c2VjcmV0c2VjcmV0c2VjcmV0"}
+	tp := newTestPrimary(t, key, []dns.RR{testSOA(1), testA("a.example.", 1), testA("b.example.", 2)})
+	sz := NewSecondaryZone("example.", tp.addr, key)
+	rr := NewRecursiveResolver(false, false, nil, nil, nil)
+	rr.LocalZones = []*LocalZone{sz.Zone}
+	if sz.Zone.lookup(Question{Name: "a.example.", Type: dns.TypeA}) != nil {
+		t.Fatal("Zone was served before being transferred")
+	}
+
+	if err := sz.Transfer(context.Background()); err != nil {
+		t.Fatalf("Transfer failed: %s", err)
+	}
+	if serial, ok := sz.Serial(); !ok || serial != 1 {
+		t.Fatalf("Expected serial 1, got %d", serial)
+	}
+	a, _, err := rr.Lookup(context.Background(), Question{Name: "b.example.", Type: dns.TypeA})
+	if err != nil {
+		t.Fatalf("Lookup failed: %s", err)
+	}
+	if !a.Authoritative || len(a.Answer) != 1 {
+		t.Fatalf("Expected an authoritative answer from the transferred zone, got %#v", a)
+	}
+
+	// the serial hasn't changed, so nothing should be transferred
+	tp.queries = nil
+	if err := sz.Transfer(context.Background()); err != nil {
+		t.Fatalf("Transfer failed: %s", err)
+	}
+	if len(tp.queries) != 1 || tp.queries[0] != dns.TypeSOA {
+		t.Fatalf("Expected only a SOA query, got %v", tp.queries)
+	}
+
+	// b.example. is removed and c.example. added in serial 2
+	tp.records = []dns.RR{testSOA(2), testA("a.example.", 1), testA("c.example.", 3)}
+	tp.ixfr = []dns.RR{testSOA(2), testSOA(1), testA("b.example.", 2), testSOA(2), testA("c.example.", 3), testSOA(2)}
+	tp.queries = nil
+	if err := sz.Transfer(context.Background()); err != nil {
+		t.Fatalf("Transfer failed: %s", err)
+	}
+	if len(tp.queries) != 2 || tp.queries[1] != dns.TypeIXFR {
+		t.Fatalf("Expected a incremental transfer, got %v", tp.queries)
+	}
+	if serial, _ := sz.Serial(); serial != 2 {
+		t.Fatalf("Expected serial 2, got %d", serial)
+	}
+	if a := sz.Zone.lookup(Question{Name: "b.example.", Type: dns.TypeA}); a == nil || a.Rcode != dns.RcodeNameError {
+		t.Fatalf("Expected b.example. to have been removed, got %#v", a)
+	}
+	if a := sz.Zone.lookup(Question{Name: "c.example.", Type: dns.TypeA}); a == nil || len(a.Answer) != 1 {
+		t.Fatalf("Expected c.example. to have been added, got %#v", a)
+	}
+
+	// a broken incremental transfer falls back to a full transfer
+	tp.records = []dns.RR{testSOA(3), testA("d.example.", 4)}
+	tp.ixfr = []dns.RR{testSOA(3), testSOA(2), testSOA(3), testSOA(2), testA("a.example.", 1), testSOA(3)}
+	tp.queries = nil
+	if err := sz.Transfer(context.Background()); err != nil {
+		t.Fatalf("Transfer failed: %s", err)
+	}
+	if len(tp.queries) != 3 || tp.queries[2] != dns.TypeAXFR {
+		t.Fatalf("Expected a full transfer after the incremental one failed, got %v", tp.queries)
+	}
+	if a := sz.Zone.lookup(Question{Name: "d.example.", Type: dns.TypeA}); a == nil || len(a.Answer) != 1 {
+		t.Fatalf("Expected d.example. to exist after the full transfer, got %#v", a)
+	}
+
+	// transfers which aren't signed are rejected, even though the SOA query was
+	tp.records = []dns.RR{testSOA(4), testA("e.example.", 5)}
+	tp.ixfr = nil
+	tp.unsigned = true
+	if err := sz.Transfer(context.Background()); !errors.Is(err, ErrUnsignedResponse) {
+		t.Fatalf("Expected ErrUnsignedResponse for an unsigned transfer, got %v", err)
+	}
+	if a := sz.Zone.lookup(Question{Name: "e.example.", Type: dns.TypeA}); a == nil || a.Rcode != dns.RcodeNameError {
+		t.Fatalf("Unsigned transfer was served, got %#v", a)
+	}
+	tp.unsigned = false
+
+	wrong := NewSecondaryZone("example.", tp.addr, &TSIGKey{Name: "transfer.", Secret: "d3JvbmdrZXl3cm9uZ2tleQ=="})
+	if err := wrong.Transfer(context.Background()); err == nil {
+		t.Fatal("Transfer succeeded with the wrong TSIG secret")
+	}
+}
+
+func TestApplyIXFR(t *testing.T) {
+	records := []dns.RR{testSOA(1), testA("a.example.", 1), testA("a.example.", 2)}
+	ixfr := []dns.RR{
+		testSOA(3),
+		testSOA(1), testA("a.example.", 1), testSOA(2), testA("b.example.", 3),
+		testSOA(2), testA("b.example.", 3), testSOA(3), testA("a.example.", 1),
+		testSOA(3),
+	}
+	updated, err := applyIXFR(records, ixfr)
+	if err != nil {
+		t.Fatalf("applyIXFR failed: %s", err)
+	}
+	if len(updated) != 3 {
+		t.Fatalf("Expected 3 records, got %v", updated)
+	}
+	if _, err := NewLocalZone("example.", updated); err != nil {
+		t.Fatalf("Updated records aren't a valid zone: %s", err)
+	}
+	for _, r := range updated {
+		if soa, ok := r.(*dns.SOA); ok && soa.Serial != 3 {
+			t.Fatalf("Expected the SOA record with serial 3, got %s", soa)
+		}
+		if a, ok := r.(*dns.A); ok && a.Hdr.Name != "a.example." {
+			t.Fatalf("Unexpected record: %s", a)
+		}
+	}
+	if _, err := applyIXFR(records, []dns.RR{testSOA(2), testSOA(1), testA("a.example.", 1), testSOA(2)}); err == nil {
+		t.Fatal("applyIXFR accepted a transfer ending with deletions")
+	}
+}
+
+func TestSerialNewer(t *testing.T) {
+	for _, tc := range []struct {
+		a, b  uint32
+		newer bool
+	}{
+		{2, 1, true},
+		{1, 2, false},
+		{1, 1, false},
+		{0, 0xffffffff, true},
+		{0xffffffff, 0, false},
+	} {
+		if serialNewer(tc.a, tc.b) != tc.newer {
+			t.Errorf("serialNewer(%d, %d) != %t", tc.a, tc.b, tc.newer)
+		}
+	}
+}
@@ -0,0 +1,190 @@
+package solvere
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// Transport is used by RecursiveResolver to exchange messages with remote
+// nameservers
+type Transport interface {
+	// Exchange sends m to the nameserver at addr (host:port) and returns the
+	// response. m must not be retained after Exchange returns.
+	Exchange(ctx context.Context, m *dns.Msg, addr string) (*dns.Msg, error)
+}
+
+// ClientTransport is a Transport that uses a dns.Client, if the context passed
+// to Exchange has a deadline it overrides the timeout of the client
+type ClientTransport struct {
+	Client *dns.Client
+}
+
+// NewClientTransport returns a ClientTransport that uses the network net, one of
+// "udp", "tcp" or "tcp-tls"
+func NewClientTransport(net string) *ClientTransport {
+	return &ClientTransport{Client: &dns.Client{Net: net}}
+}
+
+// Exchange implements Transport
+func (ct *ClientTransport) Exchange(ctx context.Context, m *dns.Msg, addr string) (*dns.Msg, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	c := ct.Client
+	if deadline, ok := ctx.Deadline(); ok {
+		c = &dns.Client{
+			Net:        c.Net,
+			UDPSize:    c.UDPSize,
+			TLSConfig:  c.TLSConfig,
+			Timeout:    time.Until(deadline),
+			TsigSecret: c.TsigSecret,
+		}
+	}
+	r, _, err := c.Exchange(m, addr)
+	return r, err
+}
+
+func (rr *RecursiveResolver) exchange(ctx context.Context, m *dns.Msg, addr string) (*dns.Msg, error) {
+	var r *dns.Msg
+	var err error
+	if rr.Transport != nil {
+		r, err = rr.Transport.Exchange(ctx, m, addr)
+	} else {
+		r, _, err = rr.c.Exchange(m, addr)
+	}
+	if isUDPTransport(rr.Transport) {
+		return retryOverTCP(ctx, m, addr, r, err)
+	}
+	return r, err
+}
+
+// exchangeTSIG sends m to addr signed with key and verifies the signature of the
+// response. Since the signature covers the message as it is packed, and has to be
+// verified before the response is unpacked, a dns.Client is used instead of the
+// Transport, using the network of the Transport if it is a ClientTransport and
+// UDP otherwise. Truncated responses received over UDP are retried over TCP.
+func (rr *RecursiveResolver) exchangeTSIG(ctx context.Context, m *dns.Msg, addr string, key *TSIGKey) (*dns.Msg, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	c := &dns.Client{Net: "udp", TsigSecret: key.secrets(), Timeout: DefaultUDPTimeout}
+	if ct, ok := rr.Transport.(*ClientTransport); ok && ct.Client.Net != "" {
+		c.Net, c.TLSConfig = ct.Client.Net, ct.Client.TLSConfig
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		c.Timeout = time.Until(deadline)
+	}
+	// the signature is added to a copy as m is returned to the pool
+	sm := m.Copy()
+	key.sign(sm)
+	r, _, err := c.Exchange(sm, addr)
+	if strings.HasPrefix(c.Net, "udp") && (err == dns.ErrTruncated || (err == nil && r.Truncated)) {
+		c.Net = "tcp"
+		r, _, err = c.Exchange(sm, addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	// dns.Client only verifies signatures that are present
+	if r.IsTsig() == nil {
+		return nil, ErrUnsignedResponse
+	}
+	r.Extra = filterRRSet(r.Extra, dns.TypeTSIG)
+	return r, nil
+}
+
+// retryOverTCP sends m to addr again over TCP if the response r, or error err,
+// received over UDP shows a response with the wrong ID arrived on the query
+// socket, and so may have been spoofed, or the response didn't fit in the
+// advertised buffer size. Unlike UDPPoolTransport there is no SpoofThreshold, the
+// dns.Client stops reading at the first response with the wrong ID so there is no
+// way to keep waiting for the real one.
+func retryOverTCP(ctx context.Context, m *dns.Msg, addr string, r *dns.Msg, err error) (*dns.Msg, error) {
+	if err == dns.ErrId || err == dns.ErrTruncated || (err == nil && r.Truncated) {
+		deadline, ok := ctx.Deadline()
+		if !ok {
+			deadline = time.Now().Add(DefaultUDPTimeout)
+		}
+		return exchangeTCP(m, addr, deadline)
+	}
+	return r, err
+}
+
+// isUDPTransport checks if t sends queries over UDP, nil is the dns.Client the
+// resolver uses by default. Custom transports are assumed not to so their
+// responses aren't retried over TCP.
+func isUDPTransport(t Transport) bool {
+	switch t := t.(type) {
+	case nil, *UDPPoolTransport:
+		return true
+	case *ClientTransport:
+		return t.Client.Net == "" || strings.HasPrefix(t.Client.Net, "udp")
+	}
+	return false
+}
+
+func (rr *RecursiveResolver) udpSize() uint16 {
+	if rr.UDPSize >= dns.MinMsgSize {
+		return rr.UDPSize
+	}
+	return DefaultEDNSBufferSize
+}
+
+// queryUDPSize returns the buffer size advertised in the query m
+func queryUDPSize(m *dns.Msg) uint16 {
+	if opt := m.IsEdns0(); opt != nil {
+		return opt.UDPSize()
+	}
+	return dns.MinMsgSize
+}
+
+// setQueryUDPSize changes the buffer size advertised in the query m
+func setQueryUDPSize(m *dns.Msg, size uint16) {
+	if opt := m.IsEdns0(); opt != nil {
+		opt.SetUDPSize(size)
+	}
+}
+
+// queryMsgPool holds the messages used for outgoing queries so they can be reused,
+// messages are returned to the pool once the exchange is complete so Transports and
+// Hooks must not retain them
+var queryMsgPool = sync.Pool{New: func() interface{} { return new(dns.Msg) }}
+
+// newQueryMsg returns a query message for q from the pool, if recursive is set the
+// query is for a forwarder
+func (rr *RecursiveResolver) newQueryMsg(q *Question, recursive bool) *dns.Msg {
+	m := queryMsgPool.Get().(*dns.Msg)
+	questions := m.Question[:0]
+	var opt *dns.OPT
+	if len(m.Extra) == 1 {
+		opt, _ = m.Extra[0].(*dns.OPT)
+	}
+	extra := m.Extra[:0]
+	*m = dns.Msg{}
+	m.Id = dns.Id()
+	m.Question = append(questions, dns.Question{Name: q.Name, Qtype: q.Type, Qclass: dns.ClassINET})
+	if opt == nil {
+		opt = new(dns.OPT)
+	}
+	*opt = dns.OPT{Hdr: dns.RR_Header{Name: ".", Rrtype: dns.TypeOPT}}
+	opt.SetUDPSize(rr.udpSize())
+	if rr.useDNSSEC {
+		opt.SetDo()
+	}
+	m.Extra = append(extra, opt)
+	if recursive {
+		// forwarders perform the recursion, but solvere does its own validation
+		// so ask for responses that failed validation upstream too
+		m.RecursionDesired = true
+		m.CheckingDisabled = rr.useDNSSEC
+	}
+	return m
+}
+
+func releaseQueryMsg(m *dns.Msg) {
+	queryMsgPool.Put(m)
+}
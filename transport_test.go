@@ -0,0 +1,94 @@
+package solvere
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// startUDPAndTCP starts dns.Servers answering with udp and tcp on the same local
+// port and returns its address
+func startUDPAndTCP(t *testing.T, udp, tcp dns.HandlerFunc) (string, func()) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen on UDP: %s", err)
+	}
+	l, err := net.Listen("tcp", pc.LocalAddr().String())
+	if err != nil {
+		t.Fatalf("Failed to listen on TCP: %s", err)
+	}
+	started := make(chan struct{}, 2)
+	udpServer := &dns.Server{PacketConn: pc, Handler: udp, NotifyStartedFunc: func() { started <- struct{}{} }}
+	tcpServer := &dns.Server{Listener: l, Handler: tcp, NotifyStartedFunc: func() { started <- struct{}{} }}
+	go udpServer.ActivateAndServe()
+	go tcpServer.ActivateAndServe()
+	<-started
+	<-started
+	return pc.LocalAddr().String(), func() {
+		udpServer.Shutdown()
+		tcpServer.Shutdown()
+	}
+}
+
+// bigAnswer answers with n A records
+func bigAnswer(n int) dns.HandlerFunc {
+	return func(w dns.ResponseWriter, m *dns.Msg) {
+		r := new(dns.Msg).SetReply(m)
+		for i := 0; i < n; i++ {
+			r.Answer = append(r.Answer, &dns.A{
+				Hdr: dns.RR_Header{Name: m.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60},
+				A:   net.IP{10, 0, byte(i / 256), byte(i % 256)},
+			})
+		}
+		w.WriteMsg(r)
+	}
+}
+
+func TestExchangeTruncatedRetry(t *testing.T) {
+	addr, stop := startUDPAndTCP(t, func(w dns.ResponseWriter, m *dns.Msg) {
+		r := new(dns.Msg).SetReply(m)
+		r.Truncated = true
+		w.WriteMsg(r)
+	}, bigAnswer(100))
+	defer stop()
+
+	for _, transport := range []Transport{nil, NewUDPPoolTransport(), NewClientTransport("udp")} {
+		rr := &RecursiveResolver{c: new(dns.Client), Transport: transport}
+		m := rr.newQueryMsg(&Question{Name: "a.com.", Type: dns.TypeA}, false)
+		if opt := m.IsEdns0(); opt == nil || opt.UDPSize() != DefaultEDNSBufferSize {
+			t.Fatalf("Query doesn't advertise the default EDNS buffer size: %s", m)
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		r, err := rr.exchange(ctx, m, addr)
+		cancel()
+		if err != nil {
+			t.Fatalf("exchange failed using %T: %s", transport, err)
+		}
+		if r.Truncated || len(r.Answer) != 100 {
+			t.Fatalf("exchange using %T didn't retry truncated response over TCP: %s", transport, r)
+		}
+	}
+}
+
+func TestDropOversized(t *testing.T) {
+	addr, stop := startUDPAndTCP(t, bigAnswer(100), bigAnswer(1))
+	defer stop()
+
+	m := new(dns.Msg)
+	m.SetQuestion("a.com.", dns.TypeA)
+	m.SetEdns0(DefaultEDNSBufferSize, false)
+	ut := &UDPPoolTransport{DropOversized: true}
+	defer ut.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	r, err := ut.Exchange(ctx, m, addr)
+	if err != nil {
+		t.Fatalf("Exchange failed: %s", err)
+	}
+	if len(r.Answer) != 1 || ut.Oversized() != 1 || ut.TCPFallbacks() != 1 {
+		t.Fatalf("Exchange didn't ignore oversized UDP response and retry over TCP: %s", r)
+	}
+}
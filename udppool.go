@@ -0,0 +1,287 @@
+package solvere
+
+import (
+	"context"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+var (
+	// DefaultMaxIdleSockets is the default number of idle sockets a UDPPoolTransport
+	// keeps for each nameserver
+	DefaultMaxIdleSockets = 4
+	// DefaultMaxPooledServers is the default number of nameservers a UDPPoolTransport
+	// keeps idle sockets for
+	DefaultMaxPooledServers = 64
+	// DefaultUDPTimeout is the default timeout used by a UDPPoolTransport when the
+	// context passed to Exchange has no deadline
+	DefaultUDPTimeout = 2 * time.Second
+	// DefaultSpoofThreshold is the default number of responses with the question
+	// of the outstanding query but the wrong ID a UDPPoolTransport accepts during
+	// a single exchange before assuming it is under attack, it isn't used by
+	// other transports
+	DefaultSpoofThreshold = 2
+)
+
+// DefaultEDNSBufferSize is the default EDNS buffer size advertised in queries, it
+// is small enough to avoid IP fragmentation on almost all paths (DNS Flag Day 2020)
+// so larger responses are truncated and retried over TCP
+const DefaultEDNSBufferSize uint16 = 1232
+
+// UDPPoolTransport is a Transport that keeps a small pool of connected UDP sockets
+// for each frequently-used nameserver (roots, TLDs, forwarders) and reuses them for
+// subsequent queries instead of creating and binding a new socket per query, this
+// reduces syscall overhead and ephemeral port churn at high query rates. Sockets
+// which fail are closed rather than being returned to the pool.
+//
+// Since the sockets are connected datagrams from sources other than the nameserver
+// are dropped by the kernel. Responses with a question that doesn't match the
+// outstanding query are late replies to earlier queries that timed out on the
+// same socket and are ignored. Responses with the right question but the wrong ID
+// are discarded, once SpoofThreshold of them arrive during a single exchange the
+// transaction is assumed to be under a spoofing attack, the socket is closed, and
+// the question is asked again over TCP.
+//
+// To avoid the spoofing attacks IP fragmentation allows DontFragment sets the don't
+// fragment bit on queries, where the platform supports it, and DropOversized causes
+// responses larger than the buffer size advertised in the query, which can only
+// arrive via IP fragmentation, to be ignored and the question asked again over TCP.
+// NewUDPPoolTransport sets both, they can be cleared for networks where fragments
+// have to be relied on.
+type UDPPoolTransport struct {
+	// accessed atomically, kept at the start of the struct for alignment
+	spoofed      uint64
+	oversized    uint64
+	tcpFallbacks uint64
+
+	// MaxIdlePerServer is the maximum number of idle sockets kept for a single
+	// nameserver, if zero DefaultMaxIdleSockets is used
+	MaxIdlePerServer int
+	// MaxServers is the maximum number of nameservers idle sockets are kept for,
+	// once it is reached sockets for other nameservers are closed after use. If
+	// zero DefaultMaxPooledServers is used.
+	MaxServers int
+	// Timeout is used when the context passed to Exchange has no deadline, if zero
+	// DefaultUDPTimeout is used
+	Timeout time.Duration
+	// SpoofThreshold is the number of responses with the wrong ID after which the
+	// question is asked again over TCP, if zero DefaultSpoofThreshold is used. Only
+	// UDPPoolTransport honours it, the dns.Client used by default and by a
+	// ClientTransport abandons the exchange at the first response with the wrong
+	// ID, so those queries are asked again over TCP straight away.
+	SpoofThreshold int
+	// DropOversized causes responses larger than the advertised EDNS buffer size to
+	// be ignored
+	DropOversized bool
+	// DontFragment causes the don't fragment bit to be set on queries, it has no
+	// effect on platforms other than Linux
+	DontFragment bool
+
+	mu     sync.Mutex
+	idle   map[string][]*dns.Conn
+	closed bool
+}
+
+// NewUDPPoolTransport returns a UDPPoolTransport using the default limits which
+// avoids IP fragmentation
+func NewUDPPoolTransport() *UDPPoolTransport {
+	return &UDPPoolTransport{DropOversized: true, DontFragment: true}
+}
+
+func (ut *UDPPoolTransport) maxIdle() int {
+	if ut.MaxIdlePerServer > 0 {
+		return ut.MaxIdlePerServer
+	}
+	return DefaultMaxIdleSockets
+}
+
+func (ut *UDPPoolTransport) maxServers() int {
+	if ut.MaxServers > 0 {
+		return ut.MaxServers
+	}
+	return DefaultMaxPooledServers
+}
+
+func (ut *UDPPoolTransport) spoofThreshold() int {
+	if ut.SpoofThreshold > 0 {
+		return ut.SpoofThreshold
+	}
+	return DefaultSpoofThreshold
+}
+
+// Spoofed returns the number of responses with the question of the outstanding
+// query but the wrong ID that have been discarded
+func (ut *UDPPoolTransport) Spoofed() uint64 {
+	return atomic.LoadUint64(&ut.spoofed)
+}
+
+// Oversized returns the number of responses that were ignored because they were
+// larger than the advertised buffer size
+func (ut *UDPPoolTransport) Oversized() uint64 {
+	return atomic.LoadUint64(&ut.oversized)
+}
+
+// TCPFallbacks returns the number of exchanges that were retried over TCP because
+// they appeared to be under a spoofing attack or their response was oversized
+func (ut *UDPPoolTransport) TCPFallbacks() uint64 {
+	return atomic.LoadUint64(&ut.tcpFallbacks)
+}
+
+func (ut *UDPPoolTransport) timeout() time.Duration {
+	if ut.Timeout > 0 {
+		return ut.Timeout
+	}
+	return DefaultUDPTimeout
+}
+
+// get returns an idle socket connected to addr, or dials a new one
+func (ut *UDPPoolTransport) get(addr string, deadline time.Time) (*dns.Conn, error) {
+	ut.mu.Lock()
+	if conns := ut.idle[addr]; len(conns) > 0 {
+		co := conns[len(conns)-1]
+		if len(conns) == 1 {
+			delete(ut.idle, addr)
+		} else {
+			ut.idle[addr] = conns[:len(conns)-1]
+		}
+		ut.mu.Unlock()
+		return co, nil
+	}
+	ut.mu.Unlock()
+	d := &net.Dialer{Deadline: deadline}
+	if ut.DontFragment {
+		d.Control = func(network, _ string, c syscall.RawConn) error {
+			return setDontFragment(c, network == "udp6")
+		}
+	}
+	c, err := d.Dial("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return &dns.Conn{Conn: c}, nil
+}
+
+// put returns a socket to the pool, or closes it if the pool is full
+func (ut *UDPPoolTransport) put(addr string, co *dns.Conn) {
+	ut.mu.Lock()
+	defer ut.mu.Unlock()
+	conns, present := ut.idle[addr]
+	if ut.closed || len(conns) >= ut.maxIdle() || (!present && len(ut.idle) >= ut.maxServers()) {
+		co.Close()
+		return
+	}
+	if ut.idle == nil {
+		ut.idle = make(map[string][]*dns.Conn)
+	}
+	ut.idle[addr] = append(conns, co)
+}
+
+// Exchange implements Transport
+func (ut *UDPPoolTransport) Exchange(ctx context.Context, m *dns.Msg, addr string) (*dns.Msg, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(ut.timeout())
+	}
+	co, err := ut.get(addr, deadline)
+	if err != nil {
+		return nil, err
+	}
+	advertised := uint16(dns.MinMsgSize)
+	if opt := m.IsEdns0(); opt != nil && opt.UDPSize() >= dns.MinMsgSize {
+		advertised = opt.UDPSize()
+	}
+	co.UDPSize = advertised
+	if ut.DropOversized && advertised < dns.MaxMsgSize {
+		// read one more byte than advertised so oversized responses can be
+		// detected
+		co.UDPSize++
+	}
+	co.SetDeadline(deadline)
+	if err = co.WriteMsg(m); err != nil {
+		co.Close()
+		return nil, err
+	}
+	for mismatched := 0; ; {
+		p, err := co.ReadMsgHeader(nil)
+		if err != nil {
+			co.Close()
+			return nil, err
+		}
+		if ut.DropOversized && len(p) > int(advertised) {
+			atomic.AddUint64(&ut.oversized, 1)
+			atomic.AddUint64(&ut.tcpFallbacks, 1)
+			ut.put(addr, co)
+			return exchangeTCP(m, addr, deadline)
+		}
+		r := new(dns.Msg)
+		if err = r.Unpack(p); err != nil && err != dns.ErrTruncated {
+			co.Close()
+			return nil, err
+		}
+		if !questionMatches(m, r) {
+			// a late reply to an earlier query sent on the socket
+			continue
+		}
+		if r.Id == m.Id {
+			ut.put(addr, co)
+			return r, err
+		}
+		atomic.AddUint64(&ut.spoofed, 1)
+		if mismatched++; mismatched >= ut.spoofThreshold() {
+			// the port may be known to an attacker so stop using the socket
+			co.Close()
+			atomic.AddUint64(&ut.tcpFallbacks, 1)
+			return exchangeTCP(m, addr, deadline)
+		}
+	}
+}
+
+// exchangeTCP sends m to addr over TCP, which can't be spoofed by an off-path
+// attacker
+func exchangeTCP(m *dns.Msg, addr string, deadline time.Time) (*dns.Msg, error) {
+	r, _, err := (&dns.Client{Net: "tcp", Timeout: time.Until(deadline)}).Exchange(m, addr)
+	return r, err
+}
+
+// responseMatches checks that r has the same ID and question as the query m
+func responseMatches(m, r *dns.Msg) bool {
+	return r.Id == m.Id && questionMatches(m, r)
+}
+
+// questionMatches checks that r has the same question as the query m
+func questionMatches(m, r *dns.Msg) bool {
+	if len(r.Question) != len(m.Question) {
+		return false
+	}
+	for i := range m.Question {
+		mq, rq := m.Question[i], r.Question[i]
+		if mq.Qtype != rq.Qtype || mq.Qclass != rq.Qclass || !strings.EqualFold(mq.Name, rq.Name) {
+			return false
+		}
+	}
+	return true
+}
+
+// Close closes all idle sockets, sockets in use are closed once their exchange
+// completes
+func (ut *UDPPoolTransport) Close() error {
+	ut.mu.Lock()
+	defer ut.mu.Unlock()
+	for _, conns := range ut.idle {
+		for _, co := range conns {
+			co.Close()
+		}
+	}
+	ut.idle = nil
+	ut.closed = true
+	return nil
+}
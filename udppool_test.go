@@ -0,0 +1,110 @@
+package solvere
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func TestUDPPoolTransport(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen on UDP: %s", err)
+	}
+	defer pc.Close()
+	clients := make(chan string, 10)
+	go func() {
+		buf := make([]byte, 4096)
+		for {
+			n, from, err := pc.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			clients <- from.String()
+			m := new(dns.Msg)
+			if err := m.Unpack(buf[:n]); err != nil {
+				continue
+			}
+			// send a late reply to an earlier query, which should be ignored,
+			// and a response with the wrong ID, which should be discarded,
+			// before the real response
+			late := new(dns.Msg)
+			late.SetReply(m)
+			late.Question[0].Name = "late.com."
+			stale := new(dns.Msg)
+			stale.SetReply(m)
+			stale.Id = m.Id + 1
+			for _, r := range []*dns.Msg{late, stale, new(dns.Msg).SetReply(m)} {
+				out, _ := r.Pack()
+				pc.WriteTo(out, from)
+			}
+		}
+	}()
+
+	ut := &UDPPoolTransport{SpoofThreshold: 2}
+	defer ut.Close()
+	for _, name := range []string{"a.com.", "b.com."} {
+		m := new(dns.Msg)
+		m.SetQuestion(name, dns.TypeA)
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		r, err := ut.Exchange(ctx, m, pc.LocalAddr().String())
+		cancel()
+		if err != nil {
+			t.Fatalf("Exchange failed: %s", err)
+		}
+		if r.Id != m.Id || r.Question[0].Name != name {
+			t.Fatalf("Exchange returned a response that doesn't match the query: %s", r)
+		}
+	}
+	if first, second := <-clients, <-clients; first != second {
+		t.Fatalf("Socket wasn't reused for the second query: %s != %s", first, second)
+	}
+
+	if ut.Spoofed() != 2 || ut.TCPFallbacks() != 0 {
+		t.Fatalf("Unexpected spoofing counters: %d spoofed, %d TCP fallbacks", ut.Spoofed(), ut.TCPFallbacks())
+	}
+
+	// once the threshold is reached the question is asked again over TCP
+	l, err := net.Listen("tcp", pc.LocalAddr().String())
+	if err != nil {
+		t.Fatalf("Failed to listen on TCP: %s", err)
+	}
+	tcpServer := &dns.Server{Listener: l, Handler: dns.HandlerFunc(func(w dns.ResponseWriter, m *dns.Msg) {
+		r := new(dns.Msg).SetReply(m)
+		r.Answer = []dns.RR{&dns.A{Hdr: dns.RR_Header{Name: m.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET}, A: net.IP{1, 2, 3, 4}}}
+		w.WriteMsg(r)
+	})}
+	go tcpServer.ActivateAndServe()
+	defer tcpServer.Shutdown()
+	ut.SpoofThreshold = 1
+	m := new(dns.Msg)
+	m.SetQuestion("c.com.", dns.TypeA)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	r, err := ut.Exchange(ctx, m, pc.LocalAddr().String())
+	if err != nil {
+		t.Fatalf("Exchange failed after spoofed response: %s", err)
+	}
+	if len(r.Answer) != 1 || ut.TCPFallbacks() != 1 {
+		t.Fatalf("Exchange didn't retry over TCP after spoofed response: %s", r)
+	}
+	<-clients
+	if len(ut.idle[pc.LocalAddr().String()]) != 0 {
+		t.Fatal("Socket that received a spoofed response was returned to the pool")
+	}
+
+	ut.MaxServers = 1
+	for _, addr := range []string{pc.LocalAddr().String(), "1.2.3.4:53"} {
+		c, err := net.Dial("udp", pc.LocalAddr().String())
+		if err != nil {
+			t.Fatalf("Failed to dial: %s", err)
+		}
+		ut.put(addr, &dns.Conn{Conn: c})
+	}
+	if ut.idle["1.2.3.4:53"] != nil {
+		t.Fatal("Socket was pooled for a nameserver beyond MaxServers")
+	}
+}
@@ -0,0 +1,98 @@
+package solvere
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+var ErrNoZoneCut = errors.New("solvere: Unable to find the zone containing name")
+
+// ZoneCut describes the zone that contains a name
+type ZoneCut struct {
+	// Zone is the lower cased name of the zone apex
+	Zone string
+	// NS is the NS RRset at the zone apex
+	NS []dns.RR
+	// Security is the DNSSEC status of the zone, it is SecurityUnknown if the
+	// resolver isn't validating or the status couldn't be proven
+	Security SecurityStatus
+}
+
+// soaZone returns the name of the zone containing name using the SOA record in the
+// response to a SOA query for name, a empty string is returned if the response
+// doesn't establish the zone (i.e. name is an alias)
+func soaZone(a *Answer, name string) string {
+	for _, r := range extractRRSet(a.Answer, "", dns.TypeSOA) {
+		if strings.EqualFold(r.Header().Name, name) {
+			return CanonicalName(name)
+		}
+	}
+	for _, r := range extractRRSet(a.Authority, "", dns.TypeSOA) {
+		if isSubdomain(name, r.Header().Name) {
+			return CanonicalName(r.Header().Name)
+		}
+	}
+	return ""
+}
+
+// FindZoneCut finds the zone containing name, its NS RRset, and its security
+// status. The zone is found by querying for the SOA of name, and then each of its
+// ancestors if name is an alias, so the result is the same as the zone from which
+// a answer for name would be served.
+func (rr *RecursiveResolver) FindZoneCut(ctx context.Context, name string) (*ZoneCut, *LookupLog, error) {
+	ll := newLookupLog(&Question{Name: name, Type: dns.TypeNS}, nil)
+	zc := &ZoneCut{}
+	var soaAnswer *Answer
+	for _, candidate := range enclosingZones(name) {
+		a, log, err := rr.Lookup(ctx, Question{Name: candidate, Type: dns.TypeSOA})
+		ll.Composites = append(ll.Composites, log)
+		if err != nil {
+			return nil, ll, err
+		}
+		if zc.Zone = soaZone(a, candidate); zc.Zone != "" {
+			soaAnswer = a
+			break
+		}
+	}
+	if zc.Zone == "" {
+		return nil, ll, ErrNoZoneCut
+	}
+
+	a, log, err := rr.Lookup(ctx, Question{Name: zc.Zone, Type: dns.TypeNS})
+	ll.Composites = append(ll.Composites, log)
+	if err != nil {
+		return nil, ll, err
+	}
+	for _, r := range extractRRSet(a.Answer, "", dns.TypeNS) {
+		if strings.EqualFold(r.Header().Name, zc.Zone) {
+			zc.NS = append(zc.NS, r)
+		}
+	}
+	if len(zc.NS) == 0 {
+		return nil, ll, ErrNoNSAuthorties
+	}
+
+	if rr.useDNSSEC {
+		zc.Security = rr.zoneStatus.get(zc.Zone)
+		if zc.Security == SecurityUnknown && a.Authenticated && soaAnswer.Authenticated {
+			zc.Security = SecuritySecure
+		} else if zc.Security == SecurityUnknown && rr.validatesFromRoot(zc.Zone) {
+			// iterative lookups from the root fail unless unsigned answers are
+			// from a zone proven to be insecure
+			zc.Security = SecurityInsecure
+		}
+	}
+	ll.DNSSECValid = zc.Security == SecuritySecure
+	ll.Latency = time.Since(ll.Started)
+	return zc, ll, nil
+}
+
+// validatesFromRoot checks if lookups for name are performed iteratively starting
+// from the root, and so follow the chain of trust
+func (rr *RecursiveResolver) validatesFromRoot(name string) bool {
+	return rr.forwardConfig(name) == nil && rr.localZone(name) == nil && rr.startAuthority(name).Zone == "."
+}
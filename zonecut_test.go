@@ -0,0 +1,50 @@
+package solvere
+
+import (
+	"context"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestFindZoneCut(t *testing.T) {
+	soa := &dns.SOA{Hdr: dns.RR_Header{Name: "example.", Rrtype: dns.TypeSOA, Class: dns.ClassINET, Ttl: 60}, Ns: "ns.example.", Mbox: "admin.example.", Minttl: 60}
+	ns := &dns.NS{Hdr: dns.RR_Header{Name: "example.", Rrtype: dns.TypeNS, Class: dns.ClassINET, Ttl: 60}, Ns: "ns.example."}
+	alias := &dns.CNAME{Hdr: dns.RR_Header{Name: "alias.example.", Rrtype: dns.TypeCNAME, Class: dns.ClassINET, Ttl: 60}, Target: "other."}
+
+	rr := NewRecursiveResolver(false, false, nil, nil, nil)
+	rr.Forward = &ForwardConfig{Servers: []string{"10.0.0.1"}}
+	rr.Transport = transportFunc(func(_ context.Context, m *dns.Msg, _ string) (*dns.Msg, error) {
+		r := new(dns.Msg)
+		r.SetReply(m)
+		q := m.Question[0]
+		switch {
+		case q.Name == "alias.example.":
+			r.Answer = []dns.RR{alias}
+		case q.Name == "example." && q.Qtype == dns.TypeSOA:
+			r.Answer = []dns.RR{soa}
+		case q.Name == "example." && q.Qtype == dns.TypeNS:
+			r.Answer = []dns.RR{ns}
+		default:
+			r.Rcode = dns.RcodeNameError
+			r.Ns = []dns.RR{soa}
+		}
+		return r, nil
+	})
+
+	for _, name := range []string{"example.", "a.b.Example.", "alias.example."} {
+		zc, _, err := rr.FindZoneCut(context.Background(), name)
+		if err != nil {
+			t.Fatalf("FindZoneCut for %s failed: %s", name, err)
+		}
+		if zc.Zone != "example." {
+			t.Fatalf("Expected %s to be in example., got %s", name, zc.Zone)
+		}
+		if len(zc.NS) != 1 || zc.NS[0].(*dns.NS).Ns != "ns.example." {
+			t.Fatalf("Unexpected NS RRset: %v", zc.NS)
+		}
+		if zc.Security != SecurityUnknown {
+			t.Fatalf("Non-validating resolver returned %s status", zc.Security)
+		}
+	}
+}
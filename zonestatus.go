@@ -0,0 +1,137 @@
+package solvere
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jmhodges/clock"
+	"github.com/miekg/dns"
+)
+
+var (
+	// MaxZoneStatusTTL is the maximum amount of time the security status of a zone
+	// is remembered for
+	MaxZoneStatusTTL = 24 * time.Hour
+	// BogusZoneTTL is the amount of time a zone which failed validation is
+	// remembered as bogus for, during which lookups for names in it fail without
+	// contacting its nameservers
+	BogusZoneTTL = time.Minute
+	// MaxZoneStatusEntries is the maximum number of zones whose security status
+	// is remembered
+	MaxZoneStatusEntries = 10000
+
+	ErrBogusZone = errors.New("solvere: Zone recently failed validation")
+)
+
+// SecurityStatus is the DNSSEC security status of a zone (RFC 4035 Section 4.3)
+type SecurityStatus int
+
+const (
+	// SecurityUnknown means the status of the zone hasn't been determined
+	SecurityUnknown SecurityStatus = iota
+	// SecuritySecure means there is a chain of trust to the zone's keys
+	SecuritySecure
+	// SecurityInsecure means the zone has been proven to be unsigned
+	SecurityInsecure
+	// SecurityBogus means the zone should be signed but validation failed
+	SecurityBogus
+)
+
+func (s SecurityStatus) String() string {
+	switch s {
+	case SecuritySecure:
+		return "secure"
+	case SecurityInsecure:
+		return "insecure"
+	case SecurityBogus:
+		return "bogus"
+	}
+	return "unknown"
+}
+
+type zoneStatusEntry struct {
+	status  SecurityStatus
+	expires time.Time
+}
+
+// zoneStatusCache remembers the security status of zone cuts so that stable zones
+// don't have to be rediscovered for every lookup, and bogus zones fail fast
+type zoneStatusCache struct {
+	mu      sync.RWMutex
+	entries map[string]zoneStatusEntry
+	clk     clock.Clock
+}
+
+func newZoneStatusCache() *zoneStatusCache {
+	return &zoneStatusCache{entries: make(map[string]zoneStatusEntry), clk: clock.Default()}
+}
+
+// get returns the status of zone, or SecurityUnknown if it isn't known
+func (zc *zoneStatusCache) get(zone string) SecurityStatus {
+	if zc == nil {
+		return SecurityUnknown
+	}
+	zc.mu.RLock()
+	defer zc.mu.RUnlock()
+	e, present := zc.entries[strings.ToLower(zone)]
+	if !present || !zc.clk.Now().Before(e.expires) {
+		return SecurityUnknown
+	}
+	return e.status
+}
+
+// set remembers the status of zone for ttl, capped at MaxZoneStatusTTL
+func (zc *zoneStatusCache) set(zone string, status SecurityStatus, ttl time.Duration) {
+	if zc == nil {
+		return
+	}
+	if ttl > MaxZoneStatusTTL {
+		ttl = MaxZoneStatusTTL
+	}
+	if ttl <= 0 {
+		return
+	}
+	now := zc.clk.Now()
+	zc.mu.Lock()
+	defer zc.mu.Unlock()
+	if len(zc.entries) >= MaxZoneStatusEntries {
+		for z, e := range zc.entries {
+			if !now.Before(e.expires) {
+				delete(zc.entries, z)
+			}
+		}
+		if len(zc.entries) >= MaxZoneStatusEntries {
+			return
+		}
+	}
+	zc.entries[strings.ToLower(zone)] = zoneStatusEntry{status: status, expires: now.Add(ttl)}
+}
+
+// flush forgets the status of the zone name, and if subdomains is true the zones
+// below it, and returns the number forgotten
+func (zc *zoneStatusCache) flush(name string, subdomains bool) int {
+	if zc == nil {
+		return 0
+	}
+	zc.mu.Lock()
+	defer zc.mu.Unlock()
+	flushed := 0
+	for z := range zc.entries {
+		if flushMatches(z, name, subdomains) {
+			delete(zc.entries, z)
+			flushed++
+		}
+	}
+	return flushed
+}
+
+// setFromRecords remembers the status of zone for the TTL of the records that
+// proved it
+func (zc *zoneStatusCache) setFromRecords(zone string, status SecurityStatus, proof []dns.RR) {
+	if zc == nil {
+		return
+	}
+	zc.set(zone, status, time.Duration(minTTL(proof, zc.clk))*time.Second)
+}
@@ -0,0 +1,86 @@
+package solvere
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/jmhodges/clock"
+	"github.com/miekg/dns"
+)
+
+func TestZoneStatusCache(t *testing.T) {
+	fc := clock.NewFake()
+	zc := newZoneStatusCache()
+	zc.clk = fc
+
+	if s := zc.get("example."); s != SecurityUnknown {
+		t.Fatalf("Empty cache returned %s", s)
+	}
+	zc.set("Example.", SecuritySecure, time.Hour)
+	if s := zc.get("example."); s != SecuritySecure {
+		t.Fatalf("Expected secure, got %s", s)
+	}
+	fc.Add(time.Hour)
+	if s := zc.get("example."); s != SecurityUnknown {
+		t.Fatalf("Expired status returned %s", s)
+	}
+
+	zc.set("example.", SecurityInsecure, 48*time.Hour)
+	fc.Add(MaxZoneStatusTTL)
+	if s := zc.get("example."); s != SecurityUnknown {
+		t.Fatalf("Status wasn't capped at MaxZoneStatusTTL, got %s", s)
+	}
+
+	zc.set("example.", SecurityBogus, 0)
+	if s := zc.get("example."); s != SecurityUnknown {
+		t.Fatalf("Status with no TTL was cached, got %s", s)
+	}
+
+	a := &dns.A{Hdr: dns.RR_Header{Name: "example.", Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 30}}
+	zc.setFromRecords("example.", SecurityInsecure, []dns.RR{a})
+	fc.Add(29 * time.Second)
+	if s := zc.get("example."); s != SecurityInsecure {
+		t.Fatalf("Expected insecure, got %s", s)
+	}
+	fc.Add(time.Second)
+	if s := zc.get("example."); s != SecurityUnknown {
+		t.Fatalf("Status outlived its records, got %s", s)
+	}
+}
+
+func TestForwardBogusZone(t *testing.T) {
+	root, example := newTestZone(t, "."), newTestZone(t, "example.")
+	a := &dns.A{Hdr: dns.RR_Header{Name: "a.example.", Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60}, A: net.IP{1, 2, 3, 4}}
+	responses := map[uint16][]dns.RR{
+		dns.TypeA:      example.sign(t, a),
+		dns.TypeDNSKEY: example.sign(t, example.key),
+		// signed by the wrong key
+		dns.TypeDS: example.sign(t, example.key.ToDS(dns.SHA256)),
+	}
+	queries := 0
+	rr := NewRecursiveResolver(false, true, nil, []dns.RR{root.key}, nil)
+	rr.Forward = &ForwardConfig{Servers: []string{"10.0.0.1"}}
+	rr.BogusTTL = -1
+	rr.Transport = transportFunc(func(_ context.Context, m *dns.Msg, _ string) (*dns.Msg, error) {
+		queries++
+		r := new(dns.Msg)
+		r.SetReply(m)
+		r.Answer = responses[m.Question[0].Qtype]
+		return r, nil
+	})
+	if _, _, err := rr.Lookup(context.Background(), Question{Name: "a.example.", Type: dns.TypeA}); err == nil {
+		t.Fatal("Lookup didn't fail with a broken chain of trust")
+	}
+	if s := rr.zoneStatus.get("example."); s != SecurityBogus {
+		t.Fatalf("Expected example. to be bogus, got %s", s)
+	}
+	queries = 0
+	if _, _, err := rr.Lookup(context.Background(), Question{Name: "a.example.", Type: dns.TypeA}); err == nil {
+		t.Fatal("Lookup in a bogus zone didn't fail")
+	}
+	if queries != 1 {
+		t.Fatalf("Expected only the answer to be requested, sent %d queries", queries)
+	}
+}